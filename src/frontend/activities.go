@@ -15,89 +15,2078 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/experiments"
+	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// activityAdminToken guards destructive activity endpoints. They are
+// disabled unless ACTIVITY_ADMIN_TOKEN is set.
+var activityAdminToken = os.Getenv("ACTIVITY_ADMIN_TOKEN")
+
+// requireActivityAdmin only lets requests carrying the admin token as a
+// bearer token through to next.
+func requireActivityAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+		if activityAdminToken == "" {
+			renderJSONError(log, r, w, errors.New("activity admin endpoints are disabled, set ACTIVITY_ADMIN_TOKEN to enable them"), http.StatusForbidden)
+			return
+		}
+		if !isActivityAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			renderJSONError(log, r, w, errors.New("missing or invalid admin token"), http.StatusUnauthorized)
+			return
+		}
+		// The token is shared, so the client address is all there is to
+		// tell admins apart in the audit trail
+		next(w, r.WithContext(activitylog.WithRequester(r.Context(), "admin@"+clientAddress(r))))
+	}
+}
+
+// isActivityAdmin reports whether r carries the admin token, for endpoints
+// that answer everyone but tell admins more
+func isActivityAdmin(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return activityAdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(activityAdminToken)) == 1
+}
+
+// refuseWhenReadOnly answers 503 instead of calling next while the activity
+// log is read-only, for endpoints that change it
+func refuseWhenReadOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if activitylog.ReadOnly() {
+			log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+			renderJSONError(log, r, w, errors.New("the activity log is read-only, writes are frozen until POST /activities/admin/readonly turns it off"), http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientAddress returns the IP address of the client of r, without the
+// port. Forwarding headers are ignored since anyone can set them.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func (fe *frontendServer) listActivitiesHandler(w http.ResponseWriter, r *http.Request) {
 	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
-	
-	// Parse query parameters
-	limit := 100 // default limit
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
+	filter, err := parseFilter(r)
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid filter"), http.StatusBadRequest)
+		return
+	}
+
+	requireAdminUnlessOwn(filter, func(w http.ResponseWriter, r *http.Request) {
+		writeActivities(log, r, w, filter, parseLimit(r, 100), "failed to get activities")
+	})(w, r)
+}
+
+func (fe *frontendServer) sessionActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	filter, err := parseFilter(r)
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid filter"), http.StatusBadRequest)
+		return
+	}
+	// The shopper's own session, unless another one or a user is asked for
+	if filter.SessionID == "" && filter.UserID == "" {
+		filter.SessionID = sessionID(r)
+	}
+	requireAdminUnlessOwn(filter, func(w http.ResponseWriter, r *http.Request) {
+		writeActivities(log, r, w, filter, parseLimit(r, 50), "failed to get session activities")
+	})(w, r)
+}
+
+// requireAdminUnlessOwn lets requests whose filter picks sessions or a user
+// through to next only with the admin token, unless it is the shopper's own
+// session, see ownActivities
+func requireAdminUnlessOwn(filter activitylog.Filter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ownActivities(r, filter) {
+			requireActivityAdmin(next)(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ownActivities reports whether filter only asks for the activities of the
+// shopper's own session. Users are for admins to look up: anyone can sign
+// in as any user ID, so a signed-in user isn't one to show across sessions.
+func ownActivities(r *http.Request, filter activitylog.Filter) bool {
+	if filter.SessionID != "" && filter.SessionID != sessionID(r) {
+		return false
+	}
+	for _, id := range filter.SessionIDs {
+		if id != sessionID(r) {
+			return false
 		}
 	}
+	return filter.UserID == ""
+}
 
-	// Get activities
-	activities, err := activitylog.GetRecentActivities(limit)
+// activityByIDHandler answers the activity with the id of the path, such
+// as one a response reported in X-Activity-Id
+func (fe *frontendServer) activityByIDHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
-		renderHTTPError(log, r, w, errors.Wrap(err, "failed to get activities"), http.StatusInternalServerError)
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid activity id"), http.StatusBadRequest)
 		return
 	}
+	activity, err := activitylog.GetActivityByID(r.Context(), id)
+	writeActivity(log, r, w, activity, err)
+}
 
-	// Return JSON response
+// activityByRequestHandler answers the activity of the request with the
+// request_id of the path, such as one a response reported in
+// X-Activity-Request-Id
+func (fe *frontendServer) activityByRequestHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	activity, err := activitylog.GetActivityByRequestID(r.Context(), mux.Vars(r)["request_id"])
+	writeActivity(log, r, w, activity, err)
+}
+
+// writeActivity answers a single activity looked up, or why it couldn't be
+func writeActivity(log logrus.FieldLogger, r *http.Request, w http.ResponseWriter, activity activitylog.ActivityLog, err error) {
+	if errors.Is(err, activitylog.ErrActivityNotFound) {
+		renderJSONError(log, r, w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get activity"))
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(activities)
+	json.NewEncoder(w).Encode(activity)
 }
 
-func (fe *frontendServer) sessionActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+// deviceHistoryHandler lists the sessions of the shopper's own device,
+// oldest first. Admins can ask for another one with device_id.
+func (fe *frontendServer) deviceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	device := r.URL.Query().Get("device_id")
+	if device != "" && device != deviceID(r) {
+		requireActivityAdmin(func(w http.ResponseWriter, r *http.Request) {
+			writeDeviceHistory(log, r, w, device)
+		})(w, r)
+		return
+	}
+	device = deviceID(r)
+	if device == "" {
+		renderJSONError(log, r, w, errors.New("no device ID: enable device IDs, or pass device_id with the admin token"), http.StatusBadRequest)
+		return
+	}
+	writeDeviceHistory(log, r, w, device)
+}
+
+// writeDeviceHistory answers the sessions of device
+func writeDeviceHistory(log logrus.FieldLogger, r *http.Request, w http.ResponseWriter, device string) {
+	sessions, err := activitylog.GetDeviceHistory(r.Context(), device)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get device history"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// writeActivities answers the activities matching the filter, most recent
+// first, encoding them as they are read. With typed=true their details are
+// objects, the typed details of each activity type, rather than strings.
+// User and device IDs are left out unless the admin token is passed. A
+// failure is answered with a 500 saying what failed unless part of the list
+// has already been sent, in which case the list is cut short.
+func writeActivities(log logrus.FieldLogger, r *http.Request, w http.ResponseWriter, filter activitylog.Filter, limit int, what string) {
+	w.Header().Set("Content-Type", "application/json")
+	aw := activitylog.NewActivityArrayWriter(w)
+	if r.URL.Query().Get("typed") == "true" {
+		aw.TypedDetails()
+	}
+	if !isActivityAdmin(r) {
+		aw.WithoutIdentities()
+	}
+	err := activitylog.GetActivitiesStream(r.Context(), filter, limit, func(activity activitylog.ActivityLog) error {
+		return aw.Encode(&activity)
+	})
+	if err != nil {
+		aw.Discard()
+		if aw.Flushed() {
+			log.WithError(err).Warn(what + ", the response was cut short")
+			return
+		}
+		renderJSONError(log, r, w, errors.Wrap(err, what), http.StatusInternalServerError)
+		return
+	}
+	if err := aw.Close(); err != nil {
+		log.WithError(err).Debug("failed to write activities")
+	}
+}
+
+// sessionSummariesHandler sums up the sessions with activities matching the
+// filter, typically every session of a user with user_id, for admins
+func (fe *frontendServer) sessionSummariesHandler(w http.ResponseWriter, r *http.Request) {
 	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
-	sessionID := sessionID(r)
+	filter, err := parseFilter(r)
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid filter"), http.StatusBadRequest)
+		return
+	}
 
-	// Parse query parameters
-	limit := 50 // default limit
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
+	requireAdminUnlessOwn(filter, func(w http.ResponseWriter, r *http.Request) {
+		sessions, err := activitylog.GetSessionSummaries(r.Context(), filter, parseLimit(r, 50))
+		if err != nil {
+			renderActivityError(log, r, w, errors.Wrap(err, "failed to get sessions"))
+			return
+		}
+		if !isActivityAdmin(r) {
+			for i := range sessions {
+				sessions[i].UserID = ""
+			}
 		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sessions)
+	})(w, r)
+}
+
+// clearSessionActivitiesHandler deletes the activities of the shopper's own
+// session, as identified by the session cookie, and of every session of
+// their device when it has a device cookie, which is then replaced. It
+// never takes a session or device ID from the request. Forms get
+// redirected back with a flash message, other clients get the number of
+// deleted activities as JSON.
+func (fe *frontendServer) clearSessionActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	if !validCSRFToken(r) {
+		renderJSONError(log, r, w, errors.New("missing or invalid CSRF token"), http.StatusForbidden)
+		return
+	}
+	// Everyone shares the session, so it isn't the shopper's alone to clear
+	if os.Getenv("ENABLE_SINGLE_SHARED_SESSION") == "true" {
+		renderJSONError(log, r, w, errors.New("clearing history is disabled for the shared session"), http.StatusForbidden)
+		return
 	}
+	// Logging the request would leave a trace of the session right away
+	activitylog.SkipActivity(r.Context())
 
-	// Get session activities
-	activities, err := activitylog.GetActivitiesBySession(sessionID, limit)
+	ctx := activitylog.WithRequester(r.Context(), "session@"+clientAddress(r))
+	deleted, err := activitylog.DeleteSession(ctx, sessionID(r))
 	if err != nil {
-		renderHTTPError(log, r, w, errors.Wrap(err, "failed to get session activities"), http.StatusInternalServerError)
+		renderJSONError(log, r, w, errors.Wrapf(err, "failed to clear session history after deleting %d activities", deleted), http.StatusInternalServerError)
 		return
 	}
+	if device := deviceID(r); device != "" {
+		n, err := activitylog.DeleteDevice(ctx, device)
+		deleted += n
+		if err != nil {
+			renderJSONError(log, r, w, errors.Wrapf(err, "failed to clear device history after deleting %d activities", deleted), http.StatusInternalServerError)
+			return
+		}
+		forgetDeviceID(w)
+	}
+	log.WithField("deleted", deleted).Info("cleared session history")
 
-	// Return JSON response
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int64{"deleted": deleted})
+		return
+	}
+	setFlash(w, fmt.Sprintf("Your browsing history was cleared (%d activities removed).", deleted))
+	referer := r.Header.Get("referer")
+	if referer == "" {
+		referer = baseUrl + "/"
+	}
+	w.Header().Set("Location", referer)
+	w.WriteHeader(http.StatusFound)
+}
+
+// activitiesViewHandler renders the activity dashboard. Activities hold
+// shopper-controlled values, so they are only ever rendered through the
+// template's auto-escaping.
+func (fe *frontendServer) activitiesViewHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	activities, err := activitylog.GetRecentActivities(50)
+	if err != nil {
+		renderHTTPError(log, r, w, errors.Wrap(err, "failed to get activities"), http.StatusInternalServerError)
+		return
+	}
+	startTime, endTime := parseTimeRange(r)
+	stats, err := activitylog.GetActivityStats(r.Context(), activitylog.Filter{Start: startTime, End: endTime})
+	if err != nil {
+		// The dashboard is still useful without any of the reports
+		log.WithField("error", err).Warn("failed to get activity stats")
+	}
+
+	cohorts, err := activitylog.GetCohortReport(r.Context(), 8)
+	if err != nil {
+		log.WithField("error", err).Warn("failed to get cohort retention")
+	}
+	funnel, err := activitylog.GetFunnel(r.Context(), startTime, endTime, activitylog.DefaultFunnel)
+	if err != nil {
+		log.WithField("error", err).Warn("failed to get the funnel")
+	}
+	timeToConvert, err := activitylog.GetTimeToConversion(r.Context(), startTime, endTime)
+	if err != nil {
+		log.WithField("error", err).Warn("failed to get time to conversion")
+	}
+	heatmap, err := dashboardHeatmap(r)
+	if err != nil {
+		log.WithField("error", err).Warn("failed to get the activity heatmap")
+	}
+	schemaVersion, err := activitylog.SchemaVersion()
+	if err != nil {
+		log.WithField("error", err).Warn("failed to read the activity schema version")
+	}
+	var unclassified *unclassifiedReport
+	if paths, err := activitylog.GetUnclassifiedPaths(r.Context(), startTime, endTime, dashboardUnclassifiedPaths); err != nil {
+		log.WithField("error", err).Warn("failed to get unclassified paths")
+	} else if stats != nil {
+		share := activitylog.GetUnclassifiedShare(stats)
+		unclassified = &unclassifiedReport{Share: &share, Paths: paths}
+	}
+
+	if err := executeTemplate(w, r, "activities", map[string]interface{}{
+		"activities":      activities,
+		"stats":           stats,
+		"cohorts":         cohorts,
+		"funnel":          funnel,
+		"time_to_convert": timeToConvert,
+		"heatmap":         heatmap,
+		"activity_types":  activitylog.ActivityTypes(),
+		"schema_version":  schemaVersion,
+		"unclassified":    unclassified,
+	}); err != nil {
+		log.Println(err)
+	}
+}
+
+// The dashboard's heatmap covers the last heatmapWeeks weeks, and grays out
+// cells covering fewer than minHeatmapSamples hours of them
+const (
+	heatmapWeeks      = 4
+	minHeatmapSamples = 2
+)
+
+// heatmapRow is a day of the week of the dashboard's heatmap
+type heatmapRow struct {
+	Day   string
+	Cells []heatmapCell
+}
+
+// heatmapCell is an hour of the dashboard's heatmap. Intensity is its
+// activities per hour relative to the busiest cell.
+type heatmapCell struct {
+	Count     int
+	Samples   int
+	Intensity float64
+	LowSample bool
+}
+
+// dashboardHeatmap returns the rows of the dashboard's heatmap of recent
+// activities, in the time zone of the optional tz parameter
+func dashboardHeatmap(r *http.Request) ([]heatmapRow, error) {
+	loc, err := parseTimezone(r)
+	if err != nil {
+		return nil, err
+	}
+	end := activitylog.Now()
+	start := end.Add(-heatmapWeeks * 7 * 24 * time.Hour)
+	counts, err := activitylog.GetActivityHeatmapIn(r.Context(), start, end, "", loc)
+	if err != nil {
+		return nil, err
+	}
+	return heatmapRows(counts, activitylog.HeatmapSamples(start, end, loc)), nil
+}
+
+// heatmapRows lays out a heatmap for the dashboard, comparing cells by
+// their activities per sampled hour
+func heatmapRows(counts, samples [7][24]int) []heatmapRow {
+	var busiest float64
+	for d := range counts {
+		for h := range counts[d] {
+			if samples[d][h] >= minHeatmapSamples {
+				busiest = math.Max(busiest, float64(counts[d][h])/float64(samples[d][h]))
+			}
+		}
+	}
+	rows := make([]heatmapRow, 7)
+	for d := range counts {
+		rows[d] = heatmapRow{Day: time.Weekday(d).String()[:3], Cells: make([]heatmapCell, 24)}
+		for h := range counts[d] {
+			cell := heatmapCell{Count: counts[d][h], Samples: samples[d][h], LowSample: samples[d][h] < minHeatmapSamples}
+			if !cell.LowSample && busiest > 0 {
+				cell.Intensity = float64(cell.Count) / float64(cell.Samples) / busiest
+			}
+			rows[d].Cells[h] = cell
+		}
+	}
+	return rows
+}
+
+// streamActivitiesHandler pushes activities as they are logged as
+// server-sent events, one JSON encoded activity per event. Their user and
+// device IDs are for admins only.
+func (fe *frontendServer) streamActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	admin := isActivityAdmin(r)
+	activities, unsubscribe := activitylog.Subscribe(64)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case activity := <-activities:
+			if !admin {
+				activity = activity.WithoutIdentities()
+			}
+			data, err := json.Marshal(activity)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", activity.ID, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+const (
+	// defaultPollTimeout is how long /activities/poll waits by default
+	defaultPollTimeout = 25 * time.Second
+	// maxPollTimeout caps the timeout of /activities/poll below the write
+	// timeouts of the proxies in front of the frontend
+	maxPollTimeout = 55 * time.Second
+	// maxPollActivities caps the activities /activities/poll answers at once
+	maxPollActivities = 500
+)
+
+// pollActivitiesHandler answers the activities logged after the since_id
+// query parameter, of the given types when type is given, as soon as there
+// are any, waiting up to timeout (25s by default, at most 55s) for one. It
+// answers 204 No Content when none was logged in time; limit caps the
+// activities answered at once. Their user and device IDs are for admins
+// only.
+func (fe *frontendServer) pollActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	q := r.URL.Query()
+	sinceID, err := strconv.ParseInt(q.Get("since_id"), 10, 64)
+	if err != nil || sinceID < 0 {
+		renderJSONError(log, r, w, errors.New("since_id must be a non-negative activity ID"), http.StatusBadRequest)
+		return
+	}
+	timeout := defaultPollTimeout
+	if t := q.Get("timeout"); t != "" {
+		timeout, err = time.ParseDuration(t)
+		if err != nil || timeout <= 0 {
+			renderJSONError(log, r, w, errors.Errorf("invalid timeout %q", t), http.StatusBadRequest)
+			return
+		}
+		timeout = min(timeout, maxPollTimeout)
+	}
+	types := splitList(q["type"])
+	if err := (activitylog.Filter{Types: types}).Validate(); err != nil {
+		renderJSONError(log, r, w, err, http.StatusBadRequest)
+		return
+	}
+	limit := min(parseLimit(r, maxPollActivities), maxPollActivities)
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	activities, err := activitylog.WaitForActivities(ctx, sinceID, types, limit)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to poll activities"))
+		return
+	}
+	if r.Context().Err() != nil {
+		// The client is gone
+		return
+	}
+	if len(activities) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !isActivityAdmin(r) {
+		for i := range activities {
+			activities[i] = activities[i].WithoutIdentities()
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(activities)
 }
 
-func (fe *frontendServer) activityStatsHandler(w http.ResponseWriter, r *http.Request) {
+// statsEndpoint is a read-only stats endpoint, listed by /activities/meta
+// with the query parameters it accepts
+type statsEndpoint struct {
+	Path        string   `json:"path"`
+	Description string   `json:"description"`
+	Params      []string `json:"params"`
+	handler     http.HandlerFunc
+}
+
+// statsEndpoints returns the stats endpoints, which main registers from
+// this list so that /activities/meta can't miss one
+func (fe *frontendServer) statsEndpoints() []statsEndpoint {
+	timeRange := []string{"start", "end"}
+	return []statsEndpoint{
+		{"/activities/stats", "Activities per type", []string{"start", "end", "experiment", "variant", "tag", "format", "as_of", "days", "group_by"}, fe.activityStatsHandler},
+		{"/activities/stats/currencies", "Currency changes between each pair of currencies", timeRange, fe.currencyStatsHandler},
+		{"/activities/stats/geo", "Checkouts and shipping costs per country", timeRange, fe.geoStatsHandler},
+		{"/activities/stats/attribution", "Actions per type of page they were taken from", timeRange, fe.attributionStatsHandler},
+		{"/activities/stats/render", "Render time percentiles per page type", timeRange, fe.renderStatsHandler},
+		{"/activities/stats/campaigns", "Funnel of each UTM campaign", timeRange, fe.campaignStatsHandler},
+		{"/activities/stats/funnel", "Sessions, or devices, reaching each step of a funnel, in order", []string{"start", "end", "steps", "identity"}, fe.funnelStatsHandler},
+		{"/activities/stats/time-to-convert", "Time from first activity to first checkout of converting sessions, or devices", []string{"start", "end", "identity"}, fe.timeToConvertStatsHandler},
+		{"/activities/stats/assistant", "Shopping assistant messages, sessions and cart adds following them", timeRange, fe.assistantStatsHandler},
+		{"/activities/stats/availability", "Cart add rates of product views with and without the product available, per product", timeRange, fe.availabilityStatsHandler},
+		{"/activities/stats/checkout-failures", "Failed checkouts per failure reason", timeRange, fe.checkoutFailureStatsHandler},
+		{"/activities/stats/dependencies", "Requests the cart service failed, per activity type and gRPC code", timeRange, fe.dependencyFailureStatsHandler},
+		{"/activities/stats/details-size", "Average, largest and total size of details per activity type, and the share without any", timeRange, fe.detailsSizeStatsHandler},
+		{"/activities/stats/normalized", "Activities per session, percentage of sessions per activity type and cart adds per 100 product views", timeRange, fe.normalizedStatsHandler},
+		{"/activities/stats/compare", "Sessions, checkouts, errors, latency and normalized stats per frontend version or revision", []string{"start", "end", "split_by", "as_of", "days"}, fe.compareStatsHandler},
+		{"/activities/stats/canary", "Rates, error rates and latency percentiles of a canary version against a baseline, with deltas and significance hints", []string{"baseline_version", "canary_version", "window"}, fe.canaryStatsHandler},
+		{"/activities/stats/sources", "Sessions per referrer type and top external referring domains", timeRange, fe.trafficSourcesHandler},
+		{"/activities/stats/landing-pages", "Paths sessions arrive on most, with the share of them that check out", []string{"start", "end", "limit"}, fe.landingPagesStatsHandler},
+		{"/activities/stats/quantities", "Cart adds per quantity and cart adds with a suspicious quantity", timeRange, fe.quantityStatsHandler},
+		{"/activities/stats/notfound", "Most requested paths that don't exist", []string{"start", "end", "limit"}, fe.notFoundStatsHandler},
+		{"/activities/stats/top-errors", "Most frequent errors by template, handler and error", []string{"start", "end", "limit"}, fe.topErrorsStatsHandler},
+		{"/activities/stats/unclassified", "Routes most logged as other and the share of activities those are", []string{"start", "end", "limit"}, fe.unclassifiedStatsHandler},
+		{"/activities/stats/cohorts", "Weekly retention of session, or device, cohorts", []string{"weeks", "tz", "identity"}, fe.cohortStatsHandler},
+		{"/activities/stats/timeseries", "Activities per type and time bucket", []string{"start", "end", "bucket", "tz"}, fe.activityTimeSeriesHandler},
+		{"/activities/stats/heatmap", "Activities per day of the week and hour of the day", []string{"start", "end", "type", "tz"}, fe.heatmapStatsHandler},
+	}
+}
+
+// activityMeta is the body of GET /activities/meta, which describes what
+// the activity log records and serves so that dashboards can adapt
+type activityMeta struct {
+	ActivityTypes  []activitylog.ActivityTypeInfo `json:"activity_types"`
+	StatsEndpoints []statsEndpoint                `json:"stats_endpoints"`
+	SchemaVersion  int                            `json:"schema_version"`
+	Retention      activityRetention              `json:"retention"`
+	Features       map[string]bool                `json:"features"`
+	Experiments    experiments.Set                `json:"experiments"`
+	Deployment     activityDeployment             `json:"deployment"`
+	// ReadOnly is whether writes to the activity log are frozen, see
+	// POST /activities/admin/readonly
+	ReadOnly bool `json:"read_only"`
+}
+
+// activityDeployment is the build and deployment stamped on the activities
+// this frontend logs
+type activityDeployment struct {
+	Version  string `json:"version"`
+	Revision string `json:"revision"`
+	// FlagsHash identifies the feature flags of the frontend, resolved by
+	// /activities/flagsets
+	FlagsHash string `json:"flags_hash"`
+}
+
+// activityRetention describes how long activities are kept
+type activityRetention struct {
+	// AutomaticPurge is false while activities are only deleted through
+	// PurgeEndpoint, true once they are split by month and the months
+	// past RetentionMonths dropped, or expired per TypeRetentionHours
+	AutomaticPurge  bool `json:"automatic_purge"`
+	RetentionMonths int  `json:"retention_months,omitempty"`
+	// TypeRetentionHours is how long activities of each type, or of the
+	// others under default, are kept; see ACTIVITY_RETENTION
+	TypeRetentionHours map[string]float64 `json:"type_retention_hours,omitempty"`
+	PurgeEndpoint      string             `json:"purge_endpoint"`
+	// DeletedSessionGraceHours is how long the activities of sessions that
+	// cleared their history are kept, hidden, before being removed
+	DeletedSessionGraceHours float64 `json:"deleted_session_grace_hours"`
+	AuditEndpoint            string  `json:"audit_endpoint"`
+}
+
+// typeRetentionHours is the retention of each activity type, in hours
+func typeRetentionHours() map[string]float64 {
+	hours := make(map[string]float64)
+	for activityType, ttl := range activitylog.Retention() {
+		hours[activityType] = ttl.Hours()
+	}
+	return hours
+}
+
+func (fe *frontendServer) activityMetaHandler(w http.ResponseWriter, r *http.Request) {
 	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	schemaVersion, err := activitylog.SchemaVersion()
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to read the schema version"), http.StatusInternalServerError)
+		return
+	}
 
-	// Parse time range parameters
-	endTime := time.Now()
-	startTime := endTime.Add(-24 * time.Hour) // default to last 24 hours
+	experimentSet := fe.experiments
+	if experimentSet == nil {
+		experimentSet = experiments.Set{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(activityMeta{
+		ActivityTypes:  activitylog.ActivityTypes(),
+		StatsEndpoints: fe.statsEndpoints(),
+		SchemaVersion:  schemaVersion,
+		Retention: activityRetention{
+			AutomaticPurge:           activitylog.Partitioned() && activitylog.PartitionRetention() > 0 || len(activitylog.Retention()) > 0,
+			RetentionMonths:          activitylog.PartitionRetention(),
+			TypeRetentionHours:       typeRetentionHours(),
+			PurgeEndpoint:            "/activities/purge",
+			DeletedSessionGraceHours: activitylog.DeletedSessionGrace().Hours(),
+			AuditEndpoint:            "/activities/admin/audit",
+		},
+		Features: map[string]bool{
+			"assistant":             assistantEnabled,
+			"popularity_badge":      popularityBadge,
+			"cart_value_details":    cartValueDetails,
+			"cymbal_branding":       isCymbalBrand,
+			"single_shared_session": os.Getenv("ENABLE_SINGLE_SHARED_SESSION") == "true",
+			"activity_webhook":      os.Getenv("ACTIVITY_WEBHOOK_URL") != "",
+			"gcs_export":            fe.archiveExporter != nil,
+			"details_compression":   activitylog.DetailsCodec() != activitylog.DetailsCodecNone,
+			"cors":                  os.Getenv("ACTIVITY_CORS_ORIGINS") != "",
+			"assistant_text":        logAssistantText,
+			"strict_logging":        os.Getenv("ACTIVITY_STRICT") == "true",
+			"activity_debug":        activityDebug,
+			"client_events":         clientEvents,
+			"monthly_partitions":    activitylog.Partitioned(),
+			"load_shedding":         activityLoadShedder != nil,
+			"session_quota":         sessionQuotaEnabled(),
+		},
+		Experiments: experimentSet,
+		Deployment:  activityDeployment{Version: version, Revision: os.Getenv("FRONTEND_REVISION"), FlagsHash: fe.flagsHash},
+		ReadOnly:    activitylog.ReadOnly(),
+	})
+}
 
-	if start := r.URL.Query().Get("start"); start != "" {
-		if st, err := time.Parse(time.RFC3339, start); err == nil {
-			startTime = st
+// behaviorFlags are the feature flags that change what shoppers see or
+// can do, and so the activities they log. Only these are recorded in flag
+// sets: the others are about how activities are stored and served.
+func behaviorFlags() map[string]bool {
+	return map[string]bool{
+		"assistant":             assistantEnabled,
+		"popularity_badge":      popularityBadge,
+		"cart_value_details":    cartValueDetails,
+		"cymbal_branding":       isCymbalBrand,
+		"single_shared_session": os.Getenv("ENABLE_SINGLE_SHARED_SESSION") == "true",
+		"client_events":         clientEvents,
+	}
+}
+
+// flagSetsHandler resolves the flags_hash of activities to the feature
+// flags it stands for, those of the given comma-separated hashes or all
+func (fe *frontendServer) flagSetsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	var hashes []string
+	if v := r.URL.Query().Get("hash"); v != "" {
+		hashes = strings.Split(v, ",")
+	}
+	sets, err := activitylog.GetFlagSets(r.Context(), hashes)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get flag sets"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"current":   fe.flagsHash,
+		"flag_sets": sets,
+	})
+}
+
+// sessionQuotaEnabled tells whether the activities stored per session are
+// capped
+func sessionQuotaEnabled() bool {
+	maxRows, _ := activitylog.SessionQuota()
+	return maxRows > 0
+}
+
+// defaultAsOfDays is the window of days as-of stats cover by default
+const defaultAsOfDays = 7
+
+// activityVersionHandler tells clients which version of the activity API
+// this frontend serves and the features its endpoints registered, so that
+// tools deployed alongside older frontends can check before calling
+func (fe *frontendServer) activityVersionHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	v, err := activitylog.GetServerVersion()
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to read the schema version"), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// parseAsOf reads the optional as_of query parameter, the time stats are
+// asked as of, and days, the number of days before it they cover. ok is
+// false without as_of.
+func parseAsOf(r *http.Request) (asOf time.Time, window time.Duration, ok bool, err error) {
+	v := r.URL.Query().Get("as_of")
+	if v == "" {
+		return time.Time{}, 0, false, nil
+	}
+	if asOf, err = time.Parse(time.RFC3339, v); err != nil {
+		return time.Time{}, 0, false, errors.Wrap(err, "invalid as_of")
+	}
+	days := defaultAsOfDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		if days, err = strconv.Atoi(v); err != nil || days < 1 || days > 366 {
+			return time.Time{}, 0, false, errors.New("days must be between 1 and 366")
 		}
 	}
-	if end := r.URL.Query().Get("end"); end != "" {
-		if et, err := time.Parse(time.RFC3339, end); err == nil {
-			endTime = et
+	return asOf, time.Duration(days) * 24 * time.Hour, true, nil
+}
+
+func (fe *frontendServer) activityStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	if groupBy := r.URL.Query().Get("group_by"); groupBy != "" {
+		fe.groupedActivityStats(w, r, strings.Split(groupBy, ","))
+		return
+	}
+	if asOf, window, ok, err := parseAsOf(r); err != nil || ok {
+		if err != nil {
+			renderJSONError(log, r, w, err, http.StatusBadRequest)
+			return
 		}
+		fe.activityStatsAsOf(w, r, asOf, window)
+		return
+	}
+	startTime, endTime := parseTimeRange(r)
+	tags, err := parseTags(r)
+	if err != nil {
+		renderJSONError(log, r, w, err, http.StatusBadRequest)
+		return
+	}
+	filter := activitylog.Filter{
+		Start:      startTime,
+		End:        endTime,
+		Experiment: r.URL.Query().Get("experiment"),
+		Variant:    r.URL.Query().Get("variant"),
+		Tags:       tags,
+	}
+	if err := filter.Validate(); err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid filter"), http.StatusBadRequest)
+		return
+	}
+	format, err := statsFormat(r)
+	if err != nil {
+		renderJSONError(log, r, w, err, http.StatusBadRequest)
+		return
 	}
 
 	// Get activity statistics
-	stats, err := activitylog.GetActivityStats(startTime, endTime)
+	stats, err := activitylog.GetActivityStats(r.Context(), filter)
 	if err != nil {
-		renderHTTPError(log, r, w, errors.Wrap(err, "failed to get activity stats"), http.StatusInternalServerError)
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get activity stats"))
+		return
+	}
+
+	if format == "prometheus" {
+		w.Header().Set("Content-Type", prometheusContentType)
+		writeActivityCounts(w, stats, startTime, endTime)
 		return
 	}
 
 	// Return JSON response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
-}
\ No newline at end of file
+}
+
+// activityStatsAsOf serves the stats of the window days before asOf from
+// the roll-ups, which don't keep experiments, and only as JSON so that the
+// coverage warning can't be lost
+func (fe *frontendServer) activityStatsAsOf(w http.ResponseWriter, r *http.Request, asOf time.Time, window time.Duration) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	q := r.URL.Query()
+	if q.Get("experiment") != "" || q.Get("variant") != "" || q.Get("tag") != "" {
+		renderJSONError(log, r, w, errors.New("as_of stats can't be filtered by experiment or tag"), http.StatusBadRequest)
+		return
+	}
+	if format, err := statsFormat(r); err != nil || format != "json" {
+		renderJSONError(log, r, w, errors.New("as_of stats are only served as JSON"), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := activitylog.GetStatsAsOf(r.Context(), window, asOf)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get activity stats as of "+asOf.Format(time.RFC3339)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// groupedActivityStats serves the activities per value of one or two
+// dimensions. They are counted from the raw activities, which is why they
+// can't be combined with as_of or filtered.
+func (fe *frontendServer) groupedActivityStats(w http.ResponseWriter, r *http.Request, dims []string) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	if err := activitylog.ValidateGroupBy(dims); err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid group_by"), http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("experiment") != "" || q.Get("variant") != "" || q.Get("tag") != "" || q.Get("as_of") != "" {
+		renderJSONError(log, r, w, errors.New("grouped stats can't be filtered by experiment or tag, nor read as of a date"), http.StatusBadRequest)
+		return
+	}
+	if format, err := statsFormat(r); err != nil || format != "json" {
+		renderJSONError(log, r, w, errors.New("grouped stats are only served as JSON"), http.StatusBadRequest)
+		return
+	}
+
+	startTime, endTime := parseTimeRange(r)
+	stats, err := activitylog.GetGroupedStats(r.Context(), startTime, endTime, dims)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get grouped activity stats"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (fe *frontendServer) currencyStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	// Get currency change statistics
+	stats, err := activitylog.GetCurrencyTransitions(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get currency stats"))
+		return
+	}
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (fe *frontendServer) geoStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	stats, err := activitylog.GetCheckoutsByCountry(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get checkout stats by country"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (fe *frontendServer) attributionStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	stats, err := activitylog.GetActionAttribution(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get action attribution"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (fe *frontendServer) renderStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	stats, err := activitylog.GetRenderTimeStats(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get render time stats"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (fe *frontendServer) campaignStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	stats, err := activitylog.GetCampaignPerformance(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get campaign performance"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// funnelStatsHandler counts the sessions going through the steps listed in
+// the steps query parameter, the default funnel when it is missing. With
+// identity=device it counts devices instead.
+func (fe *frontendServer) funnelStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+	steps := splitList(r.URL.Query()["steps"])
+	if len(steps) == 0 {
+		steps = activitylog.DefaultFunnel
+	}
+	if err := activitylog.ValidateFunnel(steps); err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid funnel"), http.StatusBadRequest)
+		return
+	}
+	identity, err := activitylog.ParseIdentity(r.URL.Query().Get("identity"))
+	if err != nil {
+		renderJSONError(log, r, w, err, http.StatusBadRequest)
+		return
+	}
+
+	funnel, err := activitylog.GetFunnelBy(r.Context(), startTime, endTime, steps, identity)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get funnel"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(funnel)
+}
+
+// timeToConvertStatsHandler reports how long the sessions that converted
+// took to get to their first checkout, or the devices with identity=device
+func (fe *frontendServer) timeToConvertStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+	identity, err := activitylog.ParseIdentity(r.URL.Query().Get("identity"))
+	if err != nil {
+		renderJSONError(log, r, w, err, http.StatusBadRequest)
+		return
+	}
+
+	stats, err := activitylog.GetTimeToConversionBy(r.Context(), startTime, endTime, identity)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get time to conversion"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (fe *frontendServer) assistantStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	usage, err := activitylog.GetAssistantUsage(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get assistant usage"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+func (fe *frontendServer) availabilityStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	impact, err := activitylog.GetAvailabilityImpact(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get the availability impact"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(impact)
+}
+
+func (fe *frontendServer) notFoundStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	paths, err := activitylog.GetTopNotFoundPaths(r.Context(), startTime, endTime, parseLimit(r, 20))
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get not found paths"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(paths)
+}
+
+func (fe *frontendServer) landingPagesStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	pages, err := activitylog.GetLandingPages(r.Context(), startTime, endTime, parseLimit(r, 20))
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get landing pages"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pages)
+}
+
+func (fe *frontendServer) topErrorsStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	groups, err := activitylog.GetTopErrors(r.Context(), startTime, endTime, parseLimit(r, 20))
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get top errors"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+func (fe *frontendServer) unclassifiedStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	stats, err := activitylog.GetActivityStats(r.Context(), activitylog.Filter{Start: startTime, End: endTime})
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get activity stats"))
+		return
+	}
+	paths, err := activitylog.GetUnclassifiedPaths(r.Context(), startTime, endTime, parseLimit(r, 20))
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get unclassified paths"))
+		return
+	}
+	share := activitylog.GetUnclassifiedShare(stats)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(unclassifiedReport{Share: &share, Paths: paths})
+}
+
+func (fe *frontendServer) checkoutFailureStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	reasons, err := activitylog.GetCheckoutFailureReasons(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get checkout failure reasons"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reasons)
+}
+
+// normalizedStatsHandler serves metrics relative to the number of sessions,
+// which unlike raw counts don't move with traffic
+func (fe *frontendServer) normalizedStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	stats, err := activitylog.GetNormalizedStats(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get normalized stats"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// dependencyFailureStatsHandler counts the requests during which the cart
+// service failed
+func (fe *frontendServer) dependencyFailureStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	failures, err := activitylog.GetDependencyFailures(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get dependency failures"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(failures)
+}
+
+// detailsSizeStatsHandler reports how much room the details of each
+// activity type take
+func (fe *frontendServer) detailsSizeStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	stats, err := activitylog.GetDetailsSizeStats(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get details size stats"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// compareStatsHandler compares the activities served by each frontend
+// version, or each revision with split_by=revision
+func (fe *frontendServer) compareStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+	split := r.URL.Query().Get("split_by")
+	if split == "" {
+		split = activitylog.SplitVersion
+	}
+	if err := activitylog.ValidateSplit(split); err != nil {
+		renderJSONError(log, r, w, err, http.StatusBadRequest)
+		return
+	}
+
+	asOf, window, withAsOf, err := parseAsOf(r)
+	if err != nil {
+		renderJSONError(log, r, w, err, http.StatusBadRequest)
+		return
+	}
+	var coverage activitylog.Coverage
+	if withAsOf {
+		// Roll-ups don't keep versions, so the comparison is counted from
+		// the raw activities, which may have been pruned since
+		if startTime, endTime, err = activitylog.AsOfWindow(window, asOf); err != nil {
+			renderJSONError(log, r, w, err, http.StatusBadRequest)
+			return
+		}
+		if coverage, err = activitylog.GetRawCoverage(r.Context(), startTime, endTime); err != nil {
+			renderActivityError(log, r, w, errors.Wrap(err, "failed to check the coverage of the comparison"))
+			return
+		}
+	}
+
+	groups, err := activitylog.GetComparison(r.Context(), split, startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to compare activities"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !withAsOf {
+		json.NewEncoder(w).Encode(groups)
+		return
+	}
+	json.NewEncoder(w).Encode(comparisonAsOf{
+		AsOf:     asOf.UTC(),
+		Start:    startTime,
+		End:      endTime,
+		Groups:   groups,
+		Coverage: coverage,
+	})
+}
+
+// defaultCanaryWindow and maxCanaryWindow bound how far back a canary
+// comparison looks
+const (
+	defaultCanaryWindow = time.Hour
+	maxCanaryWindow     = 7 * 24 * time.Hour
+)
+
+// canaryStatsHandler compares the activities of the canary version of the
+// frontend with those of the baseline over the window up to now. It
+// refuses with 422 while either version has served too few.
+func (fe *frontendServer) canaryStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	q := r.URL.Query()
+	baseline, canary := q.Get("baseline_version"), q.Get("canary_version")
+	if baseline == "" || canary == "" || baseline == canary {
+		renderJSONError(log, r, w, errors.New("baseline_version and canary_version must be two different versions"), http.StatusBadRequest)
+		return
+	}
+	window := defaultCanaryWindow
+	if v := q.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxCanaryWindow {
+			renderJSONError(log, r, w, errors.Errorf("window must be a duration up to %s", maxCanaryWindow), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+
+	end := activitylog.Now()
+	comparison, err := activitylog.GetCanaryComparison(r.Context(), baseline, canary, end.Add(-window), end)
+	var small *activitylog.SampleTooSmallError
+	if errors.As(err, &small) {
+		renderJSONError(log, r, w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to compare the canary"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comparison)
+}
+
+// comparisonAsOf is the body of GET /activities/stats/compare with as_of,
+// the comparison of the days before it along with how much of them is left
+type comparisonAsOf struct {
+	AsOf   time.Time                `json:"as_of"`
+	Start  time.Time                `json:"start"`
+	End    time.Time                `json:"end"`
+	Groups []activitylog.Comparison `json:"groups"`
+	activitylog.Coverage
+}
+
+func (fe *frontendServer) trafficSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	sources, err := activitylog.GetTrafficSources(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get traffic sources"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sources)
+}
+
+func (fe *frontendServer) quantityStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	dist, err := activitylog.GetQuantityDistribution(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get quantity distribution"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dist)
+}
+
+// observedCurrenciesHandler lists the currencies activities were logged
+// with, including the invalid ones that were rejected
+func (fe *frontendServer) observedCurrenciesHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+
+	observed, err := activitylog.GetObservedCurrencies(r.Context(), startTime, endTime)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get observed currencies"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(observed)
+}
+
+func (fe *frontendServer) cohortStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	weeks := 8
+	if v := r.URL.Query().Get("weeks"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 52 {
+			renderJSONError(log, r, w, errors.New("weeks must be between 1 and 52"), http.StatusBadRequest)
+			return
+		}
+		weeks = n
+	}
+	loc, err := parseTimezone(r)
+	if err != nil {
+		renderJSONError(log, r, w, err, http.StatusBadRequest)
+		return
+	}
+	identity, err := activitylog.ParseIdentity(r.URL.Query().Get("identity"))
+	if err != nil {
+		renderJSONError(log, r, w, err, http.StatusBadRequest)
+		return
+	}
+
+	report, err := activitylog.GetCohortReportBy(r.Context(), weeks, loc, identity)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get cohort retention"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// activityHeatmap is the body of GET /activities/stats/heatmap. Days are
+// indexed Sunday first, and Samples holds how many hours of the range fell
+// in each cell, which counts are to be divided by to compare cells.
+type activityHeatmap struct {
+	Type     string     `json:"type,omitempty"`
+	Timezone string     `json:"tz"`
+	Counts   [7][24]int `json:"counts"`
+	Samples  [7][24]int `json:"samples"`
+}
+
+func (fe *frontendServer) heatmapStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+	if endTime.Sub(startTime) > activitylog.MaxHeatmapRange {
+		renderJSONError(log, r, w, errors.New("a heatmap covers at most 366 days"), http.StatusBadRequest)
+		return
+	}
+	loc, err := parseTimezone(r)
+	if err != nil {
+		renderJSONError(log, r, w, err, http.StatusBadRequest)
+		return
+	}
+	activityType := r.URL.Query().Get("type")
+
+	counts, err := activitylog.GetActivityHeatmapIn(r.Context(), startTime, endTime, activityType, loc)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get the activity heatmap"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(activityHeatmap{
+		Type:     activityType,
+		Timezone: loc.String(),
+		Counts:   counts,
+		Samples:  activitylog.HeatmapSamples(startTime, endTime, loc),
+	})
+}
+
+func (fe *frontendServer) activityTimeSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+	filter := activitylog.Filter{Start: startTime, End: endTime}
+
+	bucket := time.Hour
+	if b := r.URL.Query().Get("bucket"); b != "" {
+		d, err := time.ParseDuration(b)
+		if err != nil {
+			renderJSONError(log, r, w, errors.Wrap(err, "invalid bucket"), http.StatusBadRequest)
+			return
+		}
+		if d < time.Minute || d%time.Second != 0 {
+			renderJSONError(log, r, w, errors.New("bucket must be a whole number of seconds, at least a minute"), http.StatusBadRequest)
+			return
+		}
+		bucket = d
+	}
+	loc, err := parseTimezone(r)
+	if err != nil {
+		renderJSONError(log, r, w, err, http.StatusBadRequest)
+		return
+	}
+
+	series, err := activitylog.GetActivityTimeSeriesIn(r.Context(), filter, bucket, loc)
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to get activity time series"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeSeriesResponse{
+		Timezone: loc.String(),
+		Bucket:   bucket.String(),
+		Points:   series,
+	})
+}
+
+// timeSeriesResponse is the body of GET /activities/stats/timeseries
+type timeSeriesResponse struct {
+	// Timezone is the IANA name of the zone buckets are aligned on
+	Timezone string                        `json:"timezone"`
+	Bucket   string                        `json:"bucket"`
+	Points   []activitylog.TimeSeriesPoint `json:"points"`
+}
+
+// rebuildRollupsHandler re-aggregates the roll-ups of the days between the
+// from and to query parameters (YYYY-MM-DD, both inclusive), for instance
+// after activities were imported or fixed up by hand.
+func (fe *frontendServer) rebuildRollupsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	from, err := time.Parse("2006-01-02", r.URL.Query().Get("from"))
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid from date"), http.StatusBadRequest)
+		return
+	}
+	to, err := time.Parse("2006-01-02", r.URL.Query().Get("to"))
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid to date"), http.StatusBadRequest)
+		return
+	}
+	if to.Before(from) {
+		renderJSONError(log, r, w, errors.New("to must not be before from"), http.StatusBadRequest)
+		return
+	}
+
+	days, err := activitylog.RebuildRollups(r.Context(), from, to)
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrapf(err, "failed to rebuild roll-ups after %d days", days), http.StatusInternalServerError)
+		return
+	}
+	log.WithFields(logrus.Fields{"from": from, "to": to, "days": days}).Info("rebuilt activity roll-ups")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"days": days})
+}
+
+// rebuildSessionCountersHandler recomputes the counters of every session
+// from their activities, repairing the drift the nightly check reports
+func (fe *frontendServer) rebuildSessionCountersHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	sessions, err := activitylog.RebuildSessionCounters(r.Context())
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to rebuild session counters"), http.StatusInternalServerError)
+		return
+	}
+	log.WithField("sessions", sessions).Info("rebuilt session counters")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"sessions": sessions})
+}
+
+// backfillProductsHandler fills in the product column of activities logged
+// before it existed, streaming one JSON progress report per batch so that
+// long backfills can be followed. Cancelling the request stops it between
+// batches; running it again picks up what is left.
+func (fe *frontendServer) backfillProductsHandler(w http.ResponseWriter, r *http.Request) {
+	streamBackfill(w, r, "products", activitylog.BackfillProducts)
+}
+
+// backfillQuantitiesHandler records the quantity of cart adds logged before
+// quantities were recorded as integers, the way backfillProductsHandler
+// fills in products
+func (fe *frontendServer) backfillQuantitiesHandler(w http.ResponseWriter, r *http.Request) {
+	streamBackfill(w, r, "quantities", activitylog.BackfillQuantities)
+}
+
+// streamBackfill runs a backfill, writing its progress as it goes
+func streamBackfill(w http.ResponseWriter, r *http.Request, what string,
+	backfill func(context.Context, func(activitylog.BackfillProgress)) (activitylog.BackfillProgress, error)) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	p, err := backfill(r.Context(), func(p activitylog.BackfillProgress) {
+		enc.Encode(p)
+		rc.Flush()
+	})
+	if err != nil {
+		// Headers are gone, report the failure as the last line
+		log.WithError(err).WithField("last_id", p.LastID).Errorf("%s backfill failed", what)
+		enc.Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	log.WithFields(logrus.Fields{"updated": p.Updated, "max_id": p.MaxID}).Infof("backfilled activity %s", what)
+}
+
+// exportActivitiesHandler streams the activities matching the usual filter
+// parameters as newline-delimited JSON, sampled by type as given by sample,
+// e.g. sample=page_view:0.05,product_view:0.2, and reproducibly so with a
+// seed. The last line summarizes the sample.
+func (fe *frontendServer) exportActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	filter, err := parseFilter(r)
+	if err != nil {
+		renderJSONError(log, r, w, err, http.StatusBadRequest)
+		return
+	}
+	spec, err := parseSampleSpec(r.URL.Query().Get("sample"))
+	if err == nil {
+		err = activitylog.ValidateSampleSpec(spec)
+	}
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid sample"), http.StatusBadRequest)
+		return
+	}
+	opts := []activitylog.ExportOption{activitylog.ExportFilter(filter)}
+	if v := r.URL.Query().Get("seed"); v != "" {
+		seed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			renderJSONError(log, r, w, errors.Wrap(err, "invalid seed"), http.StatusBadRequest)
+			return
+		}
+		opts = append(opts, activitylog.ExportSeed(seed))
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if err := activitylog.ExportSampled(r.Context(), w, spec, opts...); err != nil {
+		if errors.Is(err, activitylog.ErrBusy) {
+			// Nothing was written yet
+			renderActivityError(log, r, w, err)
+			return
+		}
+		// Headers are gone, report the failure as the last line
+		log.WithError(err).Error("sampled export failed")
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	}
+}
+
+// parseSampleSpec parses comma-separated type:probability pairs
+func parseSampleSpec(v string) (map[string]float64, error) {
+	spec := make(map[string]float64)
+	for _, pair := range splitList([]string{v}) {
+		t, p, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, errors.Errorf("%q isn't a type:probability pair", pair)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid probability for %s", t)
+		}
+		spec[strings.TrimSpace(t)] = rate
+	}
+	return spec, nil
+}
+
+// errArchivesDisabled is returned by the archive endpoints when no bucket
+// is configured
+var errArchivesDisabled = errors.New("archive export is disabled, set ACTIVITY_GCS_BUCKET to enable it")
+
+// uploadArchiveHandler exports the archive of the UTC day given as date,
+// yesterday by default, right away
+func (fe *frontendServer) uploadArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	if fe.archiveExporter == nil {
+		renderJSONError(log, r, w, errArchivesDisabled, http.StatusNotFound)
+		return
+	}
+	day := activitylog.Now().UTC().AddDate(0, 0, -1)
+	if date := r.URL.Query().Get("date"); date != "" {
+		d, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			renderJSONError(log, r, w, errors.Wrap(err, "invalid date"), http.StatusBadRequest)
+			return
+		}
+		day = d
+	}
+
+	upload, err := fe.archiveExporter.ExportDay(r.Context(), day)
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to export the archive"), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(upload)
+}
+
+// archiveStatusHandler reports the last successful archive upload and the
+// last failure
+func (fe *frontendServer) archiveStatusHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	if fe.archiveExporter == nil {
+		renderJSONError(log, r, w, errArchivesDisabled, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fe.archiveExporter.Status())
+}
+
+// outboxStatusHandler shows how far behind each sink forwarded to through
+// the outbox is
+func (fe *frontendServer) outboxStatusHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	sinks, err := activitylog.OutboxStatus(r.Context())
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to read the outbox status"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"sinks": sinks})
+}
+
+// queryPlansHandler shows how SQLite runs the registered activity log
+// queries, and which of them don't use the indexes they should
+func (fe *frontendServer) queryPlansHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	plans, err := activitylog.ExplainQueries()
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to explain queries"), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plans)
+}
+
+// debugQueryResponse is how the activity log would answer a request,
+// without answering it
+type debugQueryResponse struct {
+	Queries []activitylog.DebugQuery `json:"queries"`
+	// AnalyzedAt is when the planner statistics were last refreshed by
+	// this process, missing if they weren't
+	AnalyzedAt *time.Time `json:"analyzed_at,omitempty"`
+}
+
+// debugQueryHandler shows the SQL a list or stats request with the same
+// parameters runs, with its plan, without running it, to reproduce slow
+// requests. query picks the request, list (the default) or stats, and
+// explain=true is required since nothing else is supported.
+func (fe *frontendServer) debugQueryHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	if r.URL.Query().Get("explain") != "true" {
+		renderJSONError(log, r, w, errors.New("only explain=true is supported, queries aren't run"), http.StatusBadRequest)
+		return
+	}
+	filter, err := parseFilter(r)
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid filter"), http.StatusBadRequest)
+		return
+	}
+	kind := r.URL.Query().Get("query")
+	if kind == "" {
+		kind = activitylog.QueryList
+	}
+	if kind != activitylog.QueryList && kind != activitylog.QueryStats {
+		renderJSONError(log, r, w, errors.Errorf("invalid query %q, must be %s or %s", kind, activitylog.QueryList, activitylog.QueryStats), http.StatusBadRequest)
+		return
+	}
+	queries, err := activitylog.ExplainFilterQueries(r.Context(), kind, filter, parseLimit(r, 100))
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to explain the query"), http.StatusInternalServerError)
+		return
+	}
+
+	resp := debugQueryResponse{Queries: queries}
+	if at := activitylog.LastAnalyze(); !at.IsZero() {
+		resp.AnalyzedAt = &at
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// dbStatsResponse is the body of GET /activities/db/stats
+type dbStatsResponse struct {
+	*activitylog.DBStats
+	// DetailsSize is how much room details take per activity type over the
+	// last 24 hours, or between start and end. It is left out when the
+	// query fails, which shouldn't take the rest of the stats with it.
+	DetailsSize []activitylog.DetailsSizeStats `json:"details_size,omitempty"`
+}
+
+// dbStatsHandler reports the size of the activities database and of its
+// write-ahead log, how the last checkpoint went and the room details take
+func (fe *frontendServer) dbStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	stats, err := activitylog.GetDBStats(r.Context())
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to get database stats"), http.StatusInternalServerError)
+		return
+	}
+	resp := dbStatsResponse{DBStats: stats}
+	startTime, endTime := parseTimeRange(r)
+	if resp.DetailsSize, err = activitylog.GetDetailsSizeStats(r.Context(), startTime, endTime); err != nil {
+		log.WithError(err).Warn("failed to get details size stats")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// verifyDBHandler checks the integrity of the activities database right
+// away, replacing it with an empty one when it is corrupt, as the nightly
+// check does
+func (fe *frontendServer) verifyDBHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	result, err := activitylog.VerifyDB(r.Context())
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to verify the database"), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// snapshotDBHandler downloads a consistent copy of the activities
+// database, for offline analysis, instead of a copy of the live file
+// racing with the writer. One snapshot is taken at a time.
+func (fe *frontendServer) snapshotDBHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	snapshot, err := activitylog.SnapshotDB(r.Context())
+	if errors.Is(err, activitylog.ErrSnapshotInProgress) {
+		renderJSONError(log, r, w, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		renderActivityError(log, r, w, errors.Wrap(err, "failed to snapshot the database"))
+		return
+	}
+	defer snapshot.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Length", strconv.FormatInt(snapshot.Size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"activities-%s.db\"", snapshot.TakenAt.Format("20060102T150405Z")))
+	w.Header().Set("Last-Modified", snapshot.TakenAt.Format(http.TimeFormat))
+	if _, err := io.Copy(w, snapshot); err != nil {
+		log.WithError(err).Warn("failed to send the database snapshot")
+	}
+}
+
+// listAlertsHandler lists the most recent traffic alerts, only those not
+// acknowledged yet with unacknowledged=1
+func (fe *frontendServer) listAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	unacknowledged := r.URL.Query().Get("unacknowledged") == "1"
+
+	alerts, err := activitylog.GetAlerts(parseLimit(r, 50), unacknowledged)
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to get alerts"), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// listAuditHandler lists the most recent destructive operations on the
+// activity log, newest first
+func (fe *frontendServer) listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	entries, err := activitylog.GetAuditEntries(parseLimit(r, 50))
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to get the audit trail"), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func (fe *frontendServer) acknowledgeAlertHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid alert id"), http.StatusBadRequest)
+		return
+	}
+
+	err = activitylog.AcknowledgeAlert(id)
+	if errors.Is(err, activitylog.ErrAlertNotFound) {
+		renderJSONError(log, r, w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to acknowledge alert"), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgeRequest is the body of POST /activities/purge
+type purgeRequest struct {
+	Confirm       bool      `json:"confirm"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Types         []string  `json:"types"`
+	TypesNot      []string  `json:"types_not"`
+	SessionID     string    `json:"session_id"`
+	SessionIDs    []string  `json:"session_ids"`
+	PathPrefix    string    `json:"path_prefix"`
+	StatusClasses []int     `json:"status_classes"`
+	Source        string    `json:"source"`
+	Experiment    string    `json:"experiment"`
+	Variant       string    `json:"variant"`
+}
+
+func (fe *frontendServer) purgeActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+
+	var req purgeRequest
+	dec := json.NewDecoder(r.Body)
+	// A misspelled field would silently widen the purge
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid purge request"), http.StatusBadRequest)
+		return
+	}
+	if !req.Confirm {
+		renderJSONError(log, r, w, errors.New(`purging activities requires "confirm": true`), http.StatusBadRequest)
+		return
+	}
+	filter := activitylog.Filter{
+		Start:         req.Start,
+		End:           req.End,
+		Types:         req.Types,
+		TypesNot:      req.TypesNot,
+		SessionID:     req.SessionID,
+		SessionIDs:    req.SessionIDs,
+		PathPrefix:    req.PathPrefix,
+		StatusClasses: req.StatusClasses,
+		Source:        req.Source,
+		Experiment:    req.Experiment,
+		Variant:       req.Variant,
+	}
+
+	start := time.Now()
+	deleted, err := activitylog.DeleteByFilter(r.Context(), filter)
+	elapsed := time.Since(start)
+	if errors.Is(err, activitylog.ErrEmptyFilter) {
+		renderJSONError(log, r, w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrapf(err, "failed to purge activities after deleting %d", deleted), http.StatusInternalServerError)
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"filter":  req,
+		"deleted": deleted,
+		"took_ms": elapsed.Milliseconds(),
+	}).Warn("purged activities")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{
+		"deleted":    deleted,
+		"elapsed_ms": elapsed.Milliseconds(),
+	})
+}
+
+// readOnlyRequest turns read-only mode on or off
+type readOnlyRequest struct {
+	ReadOnly *bool `json:"read_only"`
+}
+
+// setReadOnlyHandler freezes writes to the activity log, or lets them
+// through again, without a restart
+func (fe *frontendServer) setReadOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+
+	var req readOnlyRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "invalid read-only request"), http.StatusBadRequest)
+		return
+	}
+	if req.ReadOnly == nil {
+		renderJSONError(log, r, w, errors.New(`"read_only" is required`), http.StatusBadRequest)
+		return
+	}
+	changed := activitylog.SetReadOnly(*req.ReadOnly)
+	if changed {
+		log.WithFields(logrus.Fields{
+			"read_only": *req.ReadOnly,
+			"requester": "admin@" + clientAddress(r),
+		}).Warn("activity log read-only mode changed")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"read_only": *req.ReadOnly, "changed": changed})
+}
+
+// activityError is the JSON error envelope of the activity endpoints
+type activityError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// renderJSONError is renderHTTPError for the activity API, which answers
+// with a JSON error envelope instead of the HTML error page.
+func renderJSONError(log logrus.FieldLogger, r *http.Request, w http.ResponseWriter, err error, code int) {
+	log.WithField("error", err).Error("request error")
+	if code >= http.StatusInternalServerError {
+		activitylog.AddDetails(r.Context(), activitylog.CommonDetails{Error: err.Error()})
+	}
+
+	var body activityError
+	body.Error.Code = code
+	body.Error.Message = err.Error()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// renderActivityError reports a failed activity query. Queries rejected
+// because too many analytical queries are running are reported as 503 with
+// a Retry-After hint, everything else as 500.
+func renderActivityError(log logrus.FieldLogger, r *http.Request, w http.ResponseWriter, err error) {
+	if errors.Is(err, activitylog.ErrBusy) {
+		retryAfter := int(math.Ceil(activitylog.AnalyticalQueryWait().Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		renderJSONError(log, r, w, err, http.StatusServiceUnavailable)
+		return
+	}
+	renderJSONError(log, r, w, err, http.StatusInternalServerError)
+}
+
+// parseLimit reads the optional limit query parameter
+func parseLimit(r *http.Request, defaultLimit int) int {
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		return l
+	}
+	return defaultLimit
+}
+
+// parseFilter reads an activity filter from the query parameters: RFC3339
+// start and (exclusive) end, type and type! (excluded types), session_id, sessions,
+// user_id, path_prefix, status_class (4 for 4xx), response_class (page,
+// redirect, error or api), source, experiment, variant, version (of the
+// frontend), tag (name:value, repeated to match several), detail.<key>
+// (the value of a key of the details, up to three keys) and before_id (the
+// ID of the last activity of the previous page).
+// List parameters can be repeated or comma-separated.
+func parseFilter(r *http.Request) (activitylog.Filter, error) {
+	q := r.URL.Query()
+	filter := activitylog.Filter{
+		Types:           splitList(q["type"]),
+		TypesNot:        splitList(q["type!"]),
+		SessionID:       q.Get("session_id"),
+		SessionIDs:      splitList(q["sessions"]),
+		UserID:          q.Get("user_id"),
+		PathPrefix:      q.Get("path_prefix"),
+		ResponseClasses: splitList(q["response_class"]),
+		Source:          q.Get("source"),
+		Experiment:      q.Get("experiment"),
+		Variant:         q.Get("variant"),
+		Version:         q.Get("version"),
+	}
+	var err error
+	if filter.Tags, err = parseTags(r); err != nil {
+		return filter, err
+	}
+	filter.Details = parseDetailFilters(r)
+	for _, v := range splitList(q["status_class"]) {
+		c, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, errors.Errorf("invalid status_class %q", v)
+		}
+		filter.StatusClasses = append(filter.StatusClasses, c)
+	}
+	if v := q.Get("start"); v != "" {
+		if filter.Start, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.Wrap(err, "invalid start")
+		}
+	}
+	if v := q.Get("end"); v != "" {
+		if filter.End, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.Wrap(err, "invalid end")
+		}
+	}
+	if v := q.Get("before_id"); v != "" {
+		if filter.BeforeID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return filter, errors.Errorf("invalid before_id %q", v)
+		}
+	}
+	return filter, filter.Validate()
+}
+
+// parseTags reads the tag query parameters, each a tag cookie and the value
+// to match separated by a colon, such as tag=loyalty_tier:gold. Values are
+// taken whole, commas included.
+func parseTags(r *http.Request) (map[string]string, error) {
+	var tags map[string]string
+	for _, v := range r.URL.Query()["tag"] {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok || name == "" {
+			return nil, errors.Errorf("invalid tag %q, must look like name:value", v)
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[name] = value
+	}
+	return tags, nil
+}
+
+// parseDetailFilters reads the detail.<key> query parameters, such as
+// detail.product_id=OLJCESPC7Z, into the value to match under each key.
+// Values are taken whole, commas included; the filter validates the keys.
+func parseDetailFilters(r *http.Request) map[string]string {
+	var details map[string]string
+	for param, values := range r.URL.Query() {
+		key, ok := strings.CutPrefix(param, "detail.")
+		if !ok {
+			continue
+		}
+		if details == nil {
+			details = make(map[string]string)
+		}
+		details[key] = values[0]
+	}
+	return details
+}
+
+// splitList flattens repeated and comma-separated query parameter values
+func splitList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// parseTimeRange reads the optional RFC3339 start and end query parameters,
+// defaulting to the last 24 hours. Activities at end are left out, so that
+// adjacent ranges don't count them twice.
+func parseTimeRange(r *http.Request) (time.Time, time.Time) {
+	endTime := activitylog.Now()
+	startTime := endTime.Add(-24 * time.Hour) // default to last 24 hours
+
+	if start := r.URL.Query().Get("start"); start != "" {
+		if st, err := time.Parse(time.RFC3339, start); err == nil {
+			startTime = st
+		}
+	}
+	if end := r.URL.Query().Get("end"); end != "" {
+		if et, err := time.Parse(time.RFC3339, end); err == nil {
+			endTime = et
+		}
+	}
+	return startTime, endTime
+}
+
+// parseTimezone reads the optional tz query parameter, an IANA zone name
+// such as Asia/Tokyo that buckets are aligned on, defaulting to UTC
+func parseTimezone(r *http.Request) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return time.UTC, nil
+	}
+	// Local would be whatever zone the server happens to run in
+	if tz == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", tz)
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", tz)
+	}
+	return loc, nil
+}
@@ -0,0 +1,865 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+func TestActivitiesDashboardEscapesActivities(t *testing.T) {
+	payload := "<script>alert(1)</script>"
+	activities := []activitylog.ActivityLog{{
+		SessionID:    payload,
+		ActivityType: activitylog.ActivityTypeAddToCart,
+		Path:         "/cart/" + payload,
+		Method:       "POST",
+		UserCurrency: payload,
+		Details:      `{"note":"` + payload + `"}`,
+		CreatedAt:    time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+	}}
+	stats := map[string]int{payload: 1}
+
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "activities", map[string]interface{}{
+		"activities": activities,
+		"stats":      stats,
+	}); err != nil {
+		t.Fatalf("rendering the dashboard failed: %v", err)
+	}
+	page := buf.String()
+	if strings.Contains(page, payload) {
+		t.Errorf("dashboard renders %s unescaped", payload)
+	}
+	if !strings.Contains(page, "&lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Error("dashboard doesn't render the escaped activity")
+	}
+}
+
+func TestScriptPayloadsPostedToCartStaySafe(t *testing.T) {
+	emptyActivityLog(t)
+	fe := &frontendServer{}
+	r := mux.NewRouter()
+	r.HandleFunc("/cart", fe.addToCartHandler).Methods(http.MethodPost)
+	r.HandleFunc("/activities/view", fe.activitiesViewHandler).Methods(http.MethodGet)
+	log := logrus.New()
+	log.Out = io.Discard
+	h := activitylog.NewActivityMiddleware(log, r)
+	// The session ID is shown on the dashboard, make it a payload too
+	payload := "<script>alert(1)</script>"
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		req = req.WithContext(context.WithValue(req.Context(), ctxKeyLog{}, logrus.FieldLogger(log)))
+		req = req.WithContext(context.WithValue(req.Context(), activitylog.CtxKeySessionID{}, payload))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	serve(sessionRequest("/cart", payload, url.Values{"product_id": {payload}, "quantity": {"1" + payload}}))
+	activities, err := activitylog.GetActivitiesBySession(payload, 1)
+	if err != nil || len(activities) != 1 {
+		t.Fatalf("POST /cart logged %d activities, %v; want 1", len(activities), err)
+	}
+	if strings.ContainsAny(activities[0].Details, "<>") {
+		t.Errorf("stored details %s contain markup", activities[0].Details)
+	}
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/activities/view", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /activities/view: status %d, want 200", w.Code)
+	}
+	if page := w.Body.String(); strings.Contains(page, payload) || !strings.Contains(page, "&lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Errorf("dashboard doesn't show the payload escaped:\n%s", page)
+	}
+}
+
+func TestActivitiesDashboardRendersCohorts(t *testing.T) {
+	cohorts := &activitylog.CohortReport{
+		Note: activitylog.CohortNote,
+		Cohorts: []activitylog.CohortRow{
+			{WeekStart: time.Date(2025, 5, 19, 0, 0, 0, 0, time.UTC), Sessions: 4, Retention: []float64{1, 0.25}},
+			{WeekStart: time.Date(2025, 5, 26, 0, 0, 0, 0, time.UTC), Sessions: 0, Retention: []float64{0}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "activities", map[string]interface{}{
+		"cohorts": cohorts,
+	}); err != nil {
+		t.Fatalf("rendering the dashboard failed: %v", err)
+	}
+	page := buf.String()
+	for _, want := range []string{
+		"2025-05-19", "25%", "rgba(66, 133, 244, 0.25)", "returning sessions, not users",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("dashboard doesn't contain %q", want)
+		}
+	}
+}
+
+func TestBucketedStatsRejectUnknownTimezones(t *testing.T) {
+	fe := &frontendServer{}
+	for _, tz := range []string{"Mars/Olympus_Mons", "Local", "../../etc/passwd"} {
+		for path, handler := range map[string]http.HandlerFunc{
+			"/activities/stats/timeseries": fe.activityTimeSeriesHandler,
+			"/activities/stats/cohorts":    fe.cohortStatsHandler,
+		} {
+			req := sessionRequest(path, "session-1", nil)
+			req.Method = http.MethodGet
+			req.URL.RawQuery = "tz=" + tz
+			w := httptest.NewRecorder()
+			handler(w, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s?tz=%s: status = %d, want %d", path, tz, w.Code, http.StatusBadRequest)
+			}
+		}
+	}
+}
+
+func TestCanaryStats(t *testing.T) {
+	emptyActivityLog(t)
+	defer activitylog.ConfigureCanaryMinSample(0)
+	for version, n := range map[string]int{"v1": activitylog.DefaultCanaryMinSample, "v2": 5} {
+		for i := 0; i < n; i++ {
+			a := &activitylog.ActivityLog{SessionID: version, RequestID: "r", ActivityType: activitylog.ActivityTypePageView,
+				Path: "/", Method: http.MethodGet, StatusCode: http.StatusOK, Version: version}
+			if err := activitylog.LogActivity(a); err != nil {
+				t.Fatalf("LogActivity() failed: %v", err)
+			}
+		}
+	}
+	fe := &frontendServer{}
+	get := func(query string) *httptest.ResponseRecorder {
+		req := sessionRequest("/activities/stats/canary", "session-1", nil)
+		req.Method = http.MethodGet
+		req.URL.RawQuery = query
+		w := httptest.NewRecorder()
+		fe.canaryStatsHandler(w, req)
+		return w
+	}
+
+	for _, query := range []string{"baseline_version=v1", "baseline_version=v1&canary_version=v1", "baseline_version=v1&canary_version=v2&window=forever"} {
+		if w := get(query); w.Code != http.StatusBadRequest {
+			t.Errorf("?%s: status = %d, want 400", query, w.Code)
+		}
+	}
+	if w := get("baseline_version=v1&canary_version=v2"); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "5 for the canary") {
+		t.Errorf("too small a canary: status = %d (%s), want 422 with the sample sizes", w.Code, w.Body)
+	}
+
+	activitylog.ConfigureCanaryMinSample(5)
+	w := get("baseline_version=v1&canary_version=v2&window=30m")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var got activitylog.CanaryComparison
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding the response failed: %v", err)
+	}
+	if got.Canary.Overall.Activities != 5 || got.Baseline.Overall.Activities != activitylog.DefaultCanaryMinSample ||
+		got.End.Sub(got.Start) != 30*time.Minute {
+		t.Errorf("comparison = %+v, want 5 canary activities over 30 minutes", got)
+	}
+}
+
+func TestListActivitiesEncodesAsBefore(t *testing.T) {
+	emptyActivityLog(t)
+	fe := &frontendServer{}
+	list := func(handler http.HandlerFunc, path, query string) *httptest.ResponseRecorder {
+		req := sessionRequest(path, "session-1", nil)
+		req.Method = http.MethodGet
+		req.URL.RawQuery = query
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s?%s: status = %d, want 200: %s", path, query, w.Code, w.Body)
+		}
+		return w
+	}
+	encoded := func(filter activitylog.Filter, limit int) string {
+		activities, err := activitylog.GetActivities(filter, limit)
+		if err != nil {
+			t.Fatalf("GetActivities() failed: %v", err)
+		}
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(activities)
+		return buf.String()
+	}
+
+	if got, want := list(fe.listActivitiesHandler, "/activities", "").Body.String(), encoded(activitylog.Filter{}, 100); got != want {
+		t.Errorf("empty list = %q, want %q", got, want)
+	}
+	for i := 0; i < 300; i++ {
+		a := &activitylog.ActivityLog{SessionID: "session-1", RequestID: "r", ActivityType: activitylog.ActivityTypeAddToCart,
+			Path: "/cart?<b>&x", Method: http.MethodPost, StatusCode: http.StatusFound, ProductID: "OLJCESPC7Z",
+			Details: `{"product_id":"OLJCESPC7Z","note":"caf\u00e9 \u2028"}`}
+		if err := activitylog.LogActivity(a); err != nil {
+			t.Fatalf("LogActivity() failed: %v", err)
+		}
+	}
+	w := list(fe.listActivitiesHandler, "/activities", "limit=250")
+	if got, want := w.Body.String(), encoded(activitylog.Filter{}, 250); got != want {
+		t.Errorf("/activities wrote %d bytes that differ from the %d json.Encoder writes", len(got), len(want))
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	got := list(fe.sessionActivitiesHandler, "/activities/session", "").Body.String()
+	if want := encoded(activitylog.Filter{SessionID: "session-1"}, 50); got != want {
+		t.Errorf("/activities/session wrote %d bytes that differ from the %d json.Encoder writes", len(got), len(want))
+	}
+
+	// Typed details are objects, without the keys they don't know
+	var typed []struct {
+		Details activitylog.AddToCartDetails `json:"details"`
+	}
+	if err := json.Unmarshal(list(fe.listActivitiesHandler, "/activities", "limit=1&typed=true").Body.Bytes(), &typed); err != nil {
+		t.Fatalf("decoding typed activities failed: %v", err)
+	}
+	if len(typed) != 1 || typed[0].Details.ProductID != "OLJCESPC7Z" {
+		t.Errorf("typed activities = %+v, want the details of a cart add", typed)
+	}
+}
+
+func TestActivitiesOfOthersNeedTheAdminToken(t *testing.T) {
+	emptyActivityLog(t)
+	defer func(token string) { activityAdminToken = token }(activityAdminToken)
+	activityAdminToken = "secret"
+	for _, a := range []activitylog.ActivityLog{
+		{SessionID: "mine", UserID: "me", ActivityType: activitylog.ActivityTypePageView},
+		{SessionID: "theirs", UserID: "them", ActivityType: activitylog.ActivityTypePageView},
+	} {
+		if err := activitylog.LogActivity(&a); err != nil {
+			t.Fatalf("LogActivity() failed: %v", err)
+		}
+	}
+	// Activities and session summaries both name their session
+	type session struct {
+		SessionID string `json:"session_id"`
+	}
+	fe := &frontendServer{}
+	for path, handler := range map[string]http.HandlerFunc{
+		"/activities":          fe.listActivitiesHandler,
+		"/activities/session":  fe.sessionActivitiesHandler,
+		"/activities/sessions": fe.sessionSummariesHandler,
+	} {
+		get := func(query, token string) (int, []session) {
+			req := sessionRequest(path, "mine", nil)
+			req.Method = http.MethodGet
+			req.URL.RawQuery = query
+			req = req.WithContext(context.WithValue(req.Context(), ctxKeyUserID{}, "me"))
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+			var got []session
+			if w.Code == http.StatusOK {
+				if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+					t.Fatalf("decoding %s?%s failed: %v", path, query, err)
+				}
+			}
+			return w.Code, got
+		}
+
+		for _, query := range []string{"session_id=mine", "sessions=mine"} {
+			if code, got := get(query, ""); code != http.StatusOK || len(got) != 1 || got[0].SessionID != "mine" {
+				t.Errorf("%s?%s: status %d, %+v; want the caller's own session", path, query, code, got)
+			}
+		}
+		// Signing in takes any user ID, so not even their own user is theirs
+		for _, query := range []string{"session_id=theirs", "user_id=them", "user_id=me", "sessions=mine,theirs"} {
+			if code, got := get(query, ""); code != http.StatusUnauthorized || got != nil {
+				t.Errorf("%s?%s without the admin token: status %d, %+v; want 401", path, query, code, got)
+			}
+			if code, got := get(query, "secret"); code != http.StatusOK || len(got) == 0 {
+				t.Errorf("%s?%s with the admin token: status %d, %+v; want their sessions", path, query, code, got)
+			}
+		}
+		if path == "/activities/session" {
+			if code, got := get("", ""); code != http.StatusOK || len(got) != 1 || got[0].SessionID != "mine" {
+				t.Errorf("%s: status %d, %+v; want the caller's own session", path, code, got)
+			}
+		}
+	}
+}
+
+func TestFlagSets(t *testing.T) {
+	emptyActivityLog(t)
+	defer func(enabled bool) { assistantEnabled = enabled }(assistantEnabled)
+	var hashes []string
+	for _, enabled := range []bool{false, true} {
+		assistantEnabled = enabled
+		hash, err := activitylog.RegisterFlagSet(context.Background(), behaviorFlags())
+		if err != nil {
+			t.Fatalf("RegisterFlagSet() failed: %v", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	fe := &frontendServer{flagsHash: hashes[1]}
+	get := func(query string) (current string, sets []activitylog.FlagSet) {
+		t.Helper()
+		req := sessionRequest("/activities/flagsets", "session-1", nil)
+		req.Method = http.MethodGet
+		req.URL.RawQuery = query
+		w := httptest.NewRecorder()
+		fe.flagSetsHandler(w, req)
+		var body struct {
+			Current  string                `json:"current"`
+			FlagSets []activitylog.FlagSet `json:"flag_sets"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("?%s: status = %d (%v), want 200", query, w.Code, err)
+		}
+		return body.Current, body.FlagSets
+	}
+
+	current, sets := get("")
+	if current != hashes[1] || len(sets) != 2 || sets[0].Flags["assistant"] || !sets[1].Flags["assistant"] {
+		t.Errorf("current = %s, sets = %+v, want both sets, the one with the assistant current", current, sets)
+	}
+	if _, sets := get("hash=" + hashes[0] + ",unknown"); len(sets) != 1 || sets[0].Hash != hashes[0] {
+		t.Errorf("sets = %+v, want only %s", sets, hashes[0])
+	}
+}
+
+func TestOutboxStatus(t *testing.T) {
+	emptyActivityLog(t)
+	fe := &frontendServer{}
+	get := func() map[string][]activitylog.OutboxSinkStatus {
+		t.Helper()
+		req := sessionRequest("/activities/outbox/status", "session-1", nil)
+		req.Method = http.MethodGet
+		w := httptest.NewRecorder()
+		fe.outboxStatusHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+		var got map[string][]activitylog.OutboxSinkStatus
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatalf("decoding the response failed: %v", err)
+		}
+		return got
+	}
+	if got := get(); len(got["sinks"]) != 0 {
+		t.Errorf("sinks = %+v with none running, want none", got["sinks"])
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := activitylog.NewWebhookSink(activitylog.WebhookConfig{URL: srv.URL, RetryBackoff: time.Hour})
+	if err := sink.Start(ctx); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	defer sink.Wait()
+	defer cancel()
+	if err := activitylog.LogActivity(&activitylog.ActivityLog{SessionID: "s", ActivityType: activitylog.ActivityTypePageView}); err != nil {
+		t.Fatalf("LogActivity() failed: %v", err)
+	}
+	if got := get()["sinks"]; len(got) != 1 || got[0].Sink != "webhook" || got[0].Pending != 1 {
+		t.Errorf("sinks = %+v, want the webhook with 1 pending", got)
+	}
+}
+
+func TestStatsAsOfRejectsWhatRollupsCantAnswer(t *testing.T) {
+	fe := &frontendServer{}
+	for _, query := range []string{
+		"as_of=yesterday",
+		"as_of=2025-05-01T00:00:00Z&days=0",
+		"as_of=2025-05-01T00:00:00Z&experiment=layout",
+		"as_of=2025-05-01T00:00:00Z&format=prometheus",
+	} {
+		req := sessionRequest("/activities/stats", "session-1", nil)
+		req.Method = http.MethodGet
+		req.URL.RawQuery = query
+		w := httptest.NewRecorder()
+		fe.activityStatsHandler(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("/activities/stats?%s: status = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGroupedStats(t *testing.T) {
+	emptyActivityLog(t)
+	for _, currency := range []string{"USD", "USD", "EUR"} {
+		if err := activitylog.LogActivity(&activitylog.ActivityLog{SessionID: "s", ActivityType: activitylog.ActivityTypePageView, UserCurrency: currency}); err != nil {
+			t.Fatalf("LogActivity() failed: %v", err)
+		}
+	}
+	fe := &frontendServer{}
+	get := func(query string) *httptest.ResponseRecorder {
+		req := sessionRequest("/activities/stats", "session-1", nil)
+		req.Method = http.MethodGet
+		req.URL.RawQuery = query
+		w := httptest.NewRecorder()
+		fe.activityStatsHandler(w, req)
+		return w
+	}
+
+	w := get("group_by=activity_type,user_currency")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var stats map[string]map[string]int
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if got := stats[activitylog.ActivityTypePageView]; got["USD"] != 2 || got["EUR"] != 1 {
+		t.Errorf("page views per currency = %v, want 2 USD and 1 EUR", got)
+	}
+
+	for _, query := range []string{
+		"group_by=session_id",
+		"group_by=activity_type,path",
+		"group_by=activity_type,source,version",
+		"group_by=nope",
+		"group_by=activity_type&experiment=layout",
+		"group_by=activity_type&format=prometheus",
+		"group_by=activity_type&as_of=2025-05-01T00:00:00Z",
+	} {
+		if w := get(query); w.Code != http.StatusBadRequest {
+			t.Errorf("/activities/stats?%s: status = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestActivityVersion(t *testing.T) {
+	emptyActivityLog(t)
+	activitylog.RegisterFeature(activitylog.FeatureSSE, activitylog.FeatureFiltersV2)
+	req := sessionRequest("/activities/version", "session-1", nil)
+	req.Method = http.MethodGet
+	w := httptest.NewRecorder()
+	(&frontendServer{}).activityVersionHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	// Decoded loosely, the way a client of another version would
+	var got struct {
+		SchemaVersion *int     `json:"schema_version"`
+		APIVersion    *int     `json:"api_version"`
+		Features      []string `json:"features"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.SchemaVersion == nil || *got.SchemaVersion == 0 || got.APIVersion == nil || *got.APIVersion != activitylog.APIVersion {
+		t.Errorf("version = %s, want the schema and API versions", w.Body)
+	}
+	features := strings.Join(got.Features, ",")
+	for _, f := range []string{"sse", "filters_v2"} {
+		if !strings.Contains(features, f) {
+			t.Errorf("features = %v, want %s", got.Features, f)
+		}
+	}
+}
+
+func TestVerifyDBEndpoint(t *testing.T) {
+	emptyActivityLog(t)
+	req := sessionRequest("/activities/db/verify", "session-1", nil)
+	w := httptest.NewRecorder()
+	(&frontendServer{}).verifyDBHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var result activitylog.IntegrityResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK || result.MovedTo != "" {
+		t.Errorf("verify = %+v, want a healthy database left alone", result)
+	}
+
+	health := httptest.NewRecorder()
+	healthHandler(health, httptest.NewRequest(http.MethodGet, "/_healthz", nil))
+	if strings.Contains(health.Body.String(), "activity database") {
+		t.Errorf("health = %q, want no database problem", health.Body)
+	}
+}
+
+func TestActivitiesDashboardRendersConversion(t *testing.T) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "activities", map[string]interface{}{
+		"funnel": []activitylog.FunnelStep{
+			{ActivityType: activitylog.ActivityTypeProductView, Sessions: 4, Conversion: 1},
+			{ActivityType: activitylog.ActivityTypeCheckout, Sessions: 1, Conversion: 0.25},
+		},
+		"time_to_convert": activitylog.DurationStats{
+			Count: 1, Median: 200, P90: 200,
+			Histogram: []activitylog.DurationBucket{{Label: "< 5m", LessThan: 300, Count: 1}},
+		},
+	}); err != nil {
+		t.Fatalf("rendering the dashboard failed: %v", err)
+	}
+	page := buf.String()
+	for _, want := range []string{"product_view", "25%", "median 3m20s", "&lt; 5m"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("dashboard doesn't contain %q", want)
+		}
+	}
+}
+
+func TestActivitiesDashboardRendersUnclassified(t *testing.T) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "activities", map[string]interface{}{
+		"unclassified": &unclassifiedReport{
+			Share: &activitylog.UnclassifiedShare{
+				Share: activitylog.Ratio{Numerator: 1, Denominator: 4, Value: 0.25}, Threshold: 0.1, Warning: true,
+			},
+			Paths: []activitylog.UnclassifiedPath{{RouteTemplate: "/wishlist/{id}", Count: 3}, {Count: 1}},
+		},
+	}); err != nil {
+		t.Fatalf("rendering the dashboard failed: %v", err)
+	}
+	page := buf.String()
+	for _, want := range []string{`class="warning"`, "25% of activities", "above the 10% warning", "/wishlist/{id}", "(not recorded)"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("dashboard doesn't contain %q", want)
+		}
+	}
+}
+
+func TestActivitiesDashboardRendersHeatmap(t *testing.T) {
+	var counts, samples [7][24]int
+	for d := range samples {
+		for h := range samples[d] {
+			samples[d][h] = 4
+		}
+	}
+	samples[time.Saturday][23] = 1
+	counts[time.Monday][9] = 8
+	counts[time.Tuesday][9] = 2
+	counts[time.Saturday][23] = 5
+	rows := heatmapRows(counts, samples)
+	if got := rows[time.Monday].Cells[9].Intensity; got != 1 {
+		t.Errorf("busiest cell intensity = %v, want 1", got)
+	}
+	if got := rows[time.Tuesday].Cells[9].Intensity; got != 0.25 {
+		t.Errorf("Tuesday 9:00 intensity = %v, want 0.25", got)
+	}
+	// A cell sampled once doesn't become the busiest
+	if c := rows[time.Saturday].Cells[23]; !c.LowSample || c.Intensity != 0 {
+		t.Errorf("Saturday 23:00 = %+v, want a low sample cell", c)
+	}
+
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "activities", map[string]interface{}{
+		"heatmap": rows,
+	}); err != nil {
+		t.Fatalf("rendering the dashboard failed: %v", err)
+	}
+	page := buf.String()
+	for _, want := range []string{
+		"<th>Mon</th>", "rgba(66, 133, 244, 0.25)", `class="low-sample" title="5 activities in 1 hours`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("dashboard doesn't contain %q", want)
+		}
+	}
+}
+
+func TestPurgeIsAuditedWithTheAdminAddress(t *testing.T) {
+	emptyActivityLog(t)
+	defer func(token string) { activityAdminToken = token }(activityAdminToken)
+	activityAdminToken = "secret"
+	if err := activitylog.LogActivity(&activitylog.ActivityLog{SessionID: "bot", ActivityType: activitylog.ActivityTypePageView, Source: activitylog.SourceLoadGenerator}); err != nil {
+		t.Fatalf("LogActivity() failed: %v", err)
+	}
+	fe := &frontendServer{}
+	r := mux.NewRouter()
+	r.HandleFunc("/activities/purge", requireActivityAdmin(fe.purgeActivitiesHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/activities/admin/audit", requireActivityAdmin(fe.listAuditHandler)).Methods(http.MethodGet)
+	log := logrus.New()
+	log.Out = io.Discard
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		req.Header.Set("Authorization", "Bearer secret")
+		req.RemoteAddr = "192.0.2.1:4242"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), ctxKeyLog{}, logrus.FieldLogger(log))))
+		return w
+	}
+
+	if w := serve(httptest.NewRequest(http.MethodPost, "/activities/purge", strings.NewReader(`{"confirm":true,"source":"loadgenerator"}`))); w.Code != http.StatusOK {
+		t.Fatalf("purge: status = %d, want 200: %s", w.Code, w.Body)
+	}
+	w := serve(httptest.NewRequest(http.MethodGet, "/activities/admin/audit", nil))
+	var entries []activitylog.AuditEntry
+	if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+		t.Fatalf("decoding the audit trail failed: %v", err)
+	}
+	// Newest first, after the reset that emptied the log
+	if len(entries) == 0 || entries[0].Operation != activitylog.AuditPurge || entries[0].Affected != 1 || entries[0].Requester != "admin@192.0.2.1" {
+		t.Errorf("audit trail = %+v, want the purge by admin@192.0.2.1", entries)
+	}
+}
+
+func TestReadOnlyRefusesChangesAndKeepsReads(t *testing.T) {
+	emptyActivityLog(t)
+	defer func(token string) { activityAdminToken = token }(activityAdminToken)
+	activityAdminToken = "secret"
+	defer activitylog.SetReadOnly(false)
+	fe := &frontendServer{}
+	r := mux.NewRouter()
+	r.HandleFunc("/activities", fe.listActivitiesHandler).Methods(http.MethodGet)
+	r.HandleFunc("/activities/meta", fe.activityMetaHandler).Methods(http.MethodGet)
+	r.HandleFunc("/activities/purge", requireActivityAdmin(refuseWhenReadOnly(fe.purgeActivitiesHandler))).Methods(http.MethodPost)
+	r.HandleFunc("/activities/admin/readonly", requireActivityAdmin(fe.setReadOnlyHandler)).Methods(http.MethodPost)
+	log := logrus.New()
+	log.Out = io.Discard
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), ctxKeyLog{}, logrus.FieldLogger(log))))
+		return w
+	}
+	setReadOnly := func(body string) *httptest.ResponseRecorder {
+		return serve(httptest.NewRequest(http.MethodPost, "/activities/admin/readonly", strings.NewReader(body)))
+	}
+	purge := func() int {
+		return serve(httptest.NewRequest(http.MethodPost, "/activities/purge", strings.NewReader(`{"confirm":true,"source":"loadgenerator"}`))).Code
+	}
+
+	if w := setReadOnly(`{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("without read_only: status = %d, want 400", w.Code)
+	}
+	w := setReadOnly(`{"read_only":true}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"changed":true`) {
+		t.Fatalf("turning read-only on: %d %s", w.Code, w.Body)
+	}
+	if code := purge(); code != http.StatusServiceUnavailable {
+		t.Errorf("purge while read-only: status = %d, want 503", code)
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/activities", nil)); w.Code != http.StatusOK {
+		t.Errorf("listing while read-only: status = %d, want 200", w.Code)
+	}
+	var meta activityMeta
+	if err := json.NewDecoder(serve(httptest.NewRequest(http.MethodGet, "/activities/meta", nil)).Body).Decode(&meta); err != nil || !meta.ReadOnly {
+		t.Errorf("meta = %+v (%v), want read_only", meta, err)
+	}
+
+	if w := setReadOnly(`{"read_only":false}`); w.Code != http.StatusOK {
+		t.Fatalf("turning read-only off: %d %s", w.Code, w.Body)
+	}
+	if code := purge(); code != http.StatusOK {
+		t.Errorf("purge once writable: status = %d, want 200", code)
+	}
+}
+
+func TestSnapshotDownload(t *testing.T) {
+	emptyActivityLog(t)
+	defer func(token string) { activityAdminToken = token }(activityAdminToken)
+	activityAdminToken = "secret"
+	if err := activitylog.LogActivity(&activitylog.ActivityLog{SessionID: "snapshot", ActivityType: activitylog.ActivityTypePageView}); err != nil {
+		t.Fatalf("LogActivity() failed: %v", err)
+	}
+	fe := &frontendServer{}
+	r := mux.NewRouter()
+	r.HandleFunc("/activities/db/snapshot", requireActivityAdmin(fe.snapshotDBHandler)).Methods(http.MethodGet)
+	log := logrus.New()
+	log.Out = io.Discard
+	req := httptest.NewRequest(http.MethodGet, "/activities/db/snapshot", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), ctxKeyLog{}, logrus.FieldLogger(log))))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if !bytes.HasPrefix(w.Body.Bytes(), []byte("SQLite format 3\x00")) {
+		t.Errorf("body starts with %q, want an SQLite database", w.Body.Bytes()[:min(16, w.Body.Len())])
+	}
+	if got, want := w.Header().Get("Content-Length"), strconv.Itoa(w.Body.Len()); got != want {
+		t.Errorf("Content-Length = %s, want %s", got, want)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment;") {
+		t.Errorf("Content-Disposition = %q, want a download", w.Header().Get("Content-Disposition"))
+	}
+}
+
+func TestDebugQueryExplainsWithoutRunning(t *testing.T) {
+	emptyActivityLog(t)
+	defer func(token string) { activityAdminToken = token }(activityAdminToken)
+	activityAdminToken = "secret"
+	fe := &frontendServer{}
+	r := mux.NewRouter()
+	r.HandleFunc("/activities/debug/query", requireActivityAdmin(fe.debugQueryHandler)).Methods(http.MethodGet)
+	log := logrus.New()
+	log.Out = io.Discard
+	serve := func(target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), ctxKeyLog{}, logrus.FieldLogger(log))))
+		return w
+	}
+
+	if w := serve("/activities/debug/query?explain=true", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: status = %d, want 401", w.Code)
+	}
+	if w := serve("/activities/debug/query?session_id=secret-session", "secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("without explain: status = %d, want 400", w.Code)
+	}
+	if w := serve("/activities/debug/query?explain=true&query=funnel", "secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown query: status = %d, want 400", w.Code)
+	}
+
+	w := serve("/activities/debug/query?explain=true&query=stats&session_id=secret-session&type=checkout", "secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if strings.Contains(w.Body.String(), "secret-session") {
+		t.Errorf("response %s shows the session ID", w.Body)
+	}
+	var resp debugQueryResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding the response failed: %v", err)
+	}
+	if len(resp.Queries) != 1 || !strings.Contains(resp.Queries[0].SQL, "activity_type IN (?) AND session_id = ?") || len(resp.Queries[0].Plan) == 0 {
+		t.Errorf("queries = %+v, want the stats query with its plan", resp.Queries)
+	}
+}
+
+func TestExportActivitiesEndpoint(t *testing.T) {
+	emptyActivityLog(t)
+	for _, a := range []activitylog.ActivityLog{
+		{SessionID: "session-1", ActivityType: activitylog.ActivityTypePageView},
+		{SessionID: "session-1", ActivityType: activitylog.ActivityTypeCheckout},
+	} {
+		if err := activitylog.LogActivity(&a); err != nil {
+			t.Fatalf("LogActivity() failed: %v", err)
+		}
+	}
+	h := http.HandlerFunc((&frontendServer{}).exportActivitiesHandler)
+	log := logrus.New()
+	log.Out = io.Discard
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/activities/export"+query, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), ctxKeyLog{}, logrus.FieldLogger(log))))
+		return w
+	}
+
+	w := get("?sample=page_view:0&seed=42")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if w.Code != http.StatusOK || len(lines) != 2 {
+		t.Fatalf("status = %d, body %s; want the checkout and a summary", w.Code, w.Body)
+	}
+	var summary struct {
+		Summary activitylog.SampleSummary `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &summary); err != nil || summary.Summary.Seed != 42 ||
+		summary.Summary.Counts[activitylog.ActivityTypeCheckout] != 1 {
+		t.Errorf("summary = %s (%v), want seed 42 and the checkout counted", lines[1], err)
+	}
+
+	for _, query := range []string{"?sample=page_view", "?sample=page_view:lots", "?sample=page_view:2", "?sample=nope:0.5", "?seed=x"} {
+		if w := get(query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestActivityLookup(t *testing.T) {
+	emptyActivityLog(t)
+	defer func(token string) { activityAdminToken = token }(activityAdminToken)
+	activityAdminToken = "secret"
+	logged := &activitylog.ActivityLog{SessionID: "lookup", RequestID: "request-lookup", ActivityType: activitylog.ActivityTypeViewCart}
+	if err := activitylog.LogActivity(logged); err != nil {
+		t.Fatalf("LogActivity() failed: %v", err)
+	}
+	fe := &frontendServer{}
+	r := mux.NewRouter()
+	r.HandleFunc("/activities/{id:[0-9]+}", requireActivityAdmin(fe.activityByIDHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/activities/by-request/{request_id}", requireActivityAdmin(fe.activityByRequestHandler)).Methods(http.MethodGet)
+	log := logrus.New()
+	log.Out = io.Discard
+	serve := func(target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), ctxKeyLog{}, logrus.FieldLogger(log))))
+		return w
+	}
+
+	byID := "/activities/" + strconv.FormatInt(logged.ID, 10)
+	for _, target := range []string{byID, "/activities/by-request/request-lookup"} {
+		if w := serve(target, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without the admin token: status %d, want 401", target, w.Code)
+		}
+		w := serve(target, "secret")
+		var got activitylog.ActivityLog
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil || w.Code != http.StatusOK || got.ID != logged.ID {
+			t.Errorf("GET %s: status %d, activity %+v (%v); want activity %d", target, w.Code, got, err, logged.ID)
+		}
+	}
+	if w := serve("/activities/by-request/unknown", "secret"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown request: status %d, want 404", w.Code)
+	}
+}
+
+func TestPollActivities(t *testing.T) {
+	emptyActivityLog(t)
+	logged := &activitylog.ActivityLog{SessionID: "poll", ActivityType: activitylog.ActivityTypeViewCart}
+	if err := activitylog.LogActivity(logged); err != nil {
+		t.Fatalf("LogActivity() failed: %v", err)
+	}
+	fe := &frontendServer{}
+	log := logrus.New()
+	log.Out = io.Discard
+	serve := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		fe.pollActivitiesHandler(w, req.WithContext(context.WithValue(req.Context(), ctxKeyLog{}, logrus.FieldLogger(log))))
+		return w
+	}
+
+	w := serve("/activities/poll?since_id=0")
+	var got []activitylog.ActivityLog
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil || w.Code != http.StatusOK || len(got) != 1 || got[0].ID != logged.ID {
+		t.Errorf("poll since 0: status %d, activities %+v (%v); want activity %d", w.Code, got, err, logged.ID)
+	}
+	since := "/activities/poll?since_id=" + strconv.FormatInt(logged.ID, 10)
+	if w := serve(since + "&timeout=10ms"); w.Code != http.StatusNoContent {
+		t.Errorf("poll without new activities: status %d, want 204", w.Code)
+	}
+	for _, target := range []string{"/activities/poll", since + "&timeout=soon", "/activities/poll?since_id=-1"} {
+		if w := serve(target); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: status %d, want 400", target, w.Code)
+		}
+	}
+}
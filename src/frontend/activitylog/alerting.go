@@ -0,0 +1,376 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Formats alert notifications are posted in
+const (
+	AlertFormatJSON  = "json"
+	AlertFormatSlack = "slack"
+)
+
+// Kinds of the alerts raised by the alert notifier
+const (
+	AlertKindErrorRate        = "error_rate"
+	AlertKindCheckoutFailures = "checkout_failures"
+)
+
+// Alert notifier defaults, used for zero AlertNotifierConfig fields
+const (
+	defaultAlertWindow           = 5 * time.Minute
+	defaultAlertErrorRate        = 0.05
+	defaultAlertMinRequests      = 20
+	defaultAlertCheckoutFailures = 5
+	defaultAlertCooldown         = 30 * time.Minute
+	defaultAlertInterval         = 15 * time.Second
+	defaultAlertTimeout          = 10 * time.Second
+
+	// alertBucket is the granularity the notifier counts activities at
+	alertBucket = 10 * time.Second
+	// alertQueue is how many notifications can wait for the webhook. Rules
+	// notify at most twice per evaluation, so it only fills up when the
+	// webhook is stuck.
+	alertQueue = 8
+)
+
+// AlertNotifierConfig configures an AlertNotifier. Only URL is required.
+type AlertNotifierConfig struct {
+	// URL receives the notifications
+	URL string
+	// Format is AlertFormatJSON, the default, or AlertFormatSlack for a
+	// payload a Slack incoming webhook accepts
+	Format string
+	// Window is the span of recent activities the rules look at
+	Window time.Duration
+	// ErrorRate is the share of requests answered with a 5xx status above
+	// which an alert is raised, once at least MinRequests were made
+	ErrorRate   float64
+	MinRequests int
+	// CheckoutFailures is the number of failed checkouts above which an
+	// alert is raised
+	CheckoutFailures int
+	// Cooldown is how long an alert that keeps firing stays quiet before it
+	// is notified again
+	Cooldown time.Duration
+	// Interval is how often the rules are evaluated
+	Interval time.Duration
+	// Timeout bounds each POST
+	Timeout time.Duration
+}
+
+// AlertNotification is the JSON posted to the webhook when an alert fires
+// or resolves
+type AlertNotification struct {
+	// Status is "firing" or "resolved"
+	Status    string    `json:"status"`
+	Kind      string    `json:"kind"`
+	Observed  float64   `json:"observed"`
+	Threshold float64   `json:"threshold"`
+	Window    string    `json:"window"`
+	At        time.Time `json:"at"`
+	Message   string    `json:"message"`
+}
+
+// alertCounts are the activities of one bucket the rules care about
+type alertCounts struct {
+	requests         int
+	serverErrors     int
+	checkoutFailures int
+}
+
+// alertState is where a rule stands: whether it is firing and when that
+// was last notified, and whether a notification of it is waiting to be
+// delivered
+type alertState struct {
+	firing   bool
+	notified time.Time
+	pending  bool
+}
+
+// alertDelivery is a notification waiting for the webhook, and the state
+// of its rule once delivered
+type alertDelivery struct {
+	notification AlertNotification
+	state        *alertState
+	firing       bool
+}
+
+// AlertNotifier watches the error rate and checkout failures of the
+// activities logged over a recent window, and posts an alert to a webhook
+// when either is too high, and a resolution once it isn't anymore. It
+// counts activities from the live feed rather than querying the database.
+// An alert that keeps firing is notified once per cool-down. Notifications
+// are posted by a worker of their own, so that a slow webhook doesn't hold
+// up the feed.
+type AlertNotifier struct {
+	cfg    AlertNotifierConfig
+	client *http.Client
+	queue  chan alertDelivery
+
+	mu      sync.Mutex
+	buckets map[time.Time]*alertCounts
+	states  map[string]*alertState
+}
+
+// NewAlertNotifier creates a notifier for cfg, filling in defaults
+func NewAlertNotifier(cfg AlertNotifierConfig) *AlertNotifier {
+	if cfg.Format == "" {
+		cfg.Format = AlertFormatJSON
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaultAlertWindow
+	}
+	if cfg.ErrorRate <= 0 {
+		cfg.ErrorRate = defaultAlertErrorRate
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = defaultAlertMinRequests
+	}
+	if cfg.CheckoutFailures <= 0 {
+		cfg.CheckoutFailures = defaultAlertCheckoutFailures
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = defaultAlertCooldown
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultAlertInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultAlertTimeout
+	}
+	return &AlertNotifier{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		queue:   make(chan alertDelivery, alertQueue),
+		buckets: make(map[time.Time]*alertCounts),
+		states:  make(map[string]*alertState),
+	}
+}
+
+// ValidateAlertFormat checks that format is a format alerts can be posted in
+func ValidateAlertFormat(format string) error {
+	if format != AlertFormatJSON && format != AlertFormatSlack {
+		return fmt.Errorf("invalid alert format %q, must be %s or %s", format, AlertFormatJSON, AlertFormatSlack)
+	}
+	return nil
+}
+
+// Start feeds logged activities to the notifier, evaluates the rules every
+// interval and delivers the notifications until ctx is done.
+func (n *AlertNotifier) Start(ctx context.Context) {
+	activities, unsubscribe := Subscribe(256)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case d := <-n.queue:
+				n.deliver(ctx, d)
+			}
+		}
+	}()
+	go func() {
+		defer unsubscribe()
+		ticker := time.NewTicker(n.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case a := <-activities:
+				n.observe(a)
+			case <-ticker.C:
+				n.evaluate(Now())
+			}
+		}
+	}()
+}
+
+// observe counts an activity in the bucket it was logged in
+func (n *AlertNotifier) observe(a ActivityLog) {
+	bucket := a.CreatedAt.UTC().Truncate(alertBucket)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	c := n.buckets[bucket]
+	if c == nil {
+		c = &alertCounts{}
+		n.buckets[bucket] = c
+	}
+	c.requests++
+	if a.StatusCode >= 500 {
+		c.serverErrors++
+	}
+	if a.ActivityType == ActivityTypeCheckout && checkoutFailed(a.Details) {
+		c.checkoutFailures++
+	}
+}
+
+// checkoutFailed tells whether the details of a checkout say it failed
+func checkoutFailed(details string) bool {
+	d, err := UnmarshalDetails(ActivityTypeCheckout, details)
+	return err == nil && d.(*CheckoutDetails).Failed
+}
+
+// totals sums up the buckets of the window ending at now, forgetting older
+// ones
+func (n *AlertNotifier) totals(now time.Time) alertCounts {
+	start := now.UTC().Add(-n.cfg.Window)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var total alertCounts
+	for bucket, c := range n.buckets {
+		if !bucket.After(start.Add(-alertBucket)) {
+			delete(n.buckets, bucket)
+			continue
+		}
+		total.requests += c.requests
+		total.serverErrors += c.serverErrors
+		total.checkoutFailures += c.checkoutFailures
+	}
+	return total
+}
+
+// evaluate checks the rules against the window ending at now and queues
+// the notifications of what started firing, what kept firing past its
+// cool-down and what resolved
+func (n *AlertNotifier) evaluate(now time.Time) {
+	total := n.totals(now)
+	var errorRate float64
+	if total.requests > 0 {
+		errorRate = float64(total.serverErrors) / float64(total.requests)
+	}
+	n.check(now, AlertKindErrorRate, errorRate, n.cfg.ErrorRate,
+		total.requests >= n.cfg.MinRequests && errorRate > n.cfg.ErrorRate)
+	n.check(now, AlertKindCheckoutFailures, float64(total.checkoutFailures), float64(n.cfg.CheckoutFailures),
+		total.checkoutFailures > n.cfg.CheckoutFailures)
+}
+
+// check queues the notification of a change of a rule, or that it kept
+// firing for a whole cool-down, unless one of the rule is already waiting.
+// A notification that can't be queued or delivered is tried again at the
+// next evaluation.
+func (n *AlertNotifier) check(now time.Time, kind string, observed, threshold float64, firing bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	state := n.states[kind]
+	if state == nil {
+		state = &alertState{}
+		n.states[kind] = state
+	}
+	notify := firing && (!state.firing || now.Sub(state.notified) >= n.cfg.Cooldown) ||
+		!firing && state.firing
+	if !notify || state.pending {
+		return
+	}
+
+	status := "resolved"
+	if firing {
+		status = "firing"
+	}
+	notification := AlertNotification{
+		Status:    status,
+		Kind:      kind,
+		Observed:  observed,
+		Threshold: threshold,
+		Window:    n.cfg.Window.String(),
+		At:        now.UTC(),
+	}
+	notification.Message = alertMessage(notification)
+	select {
+	case n.queue <- alertDelivery{notification: notification, state: state, firing: firing}:
+		state.pending = true
+	default:
+		logger.WithFields(logrus.Fields{"kind": kind, "status": status}).Warn("activity alert queue is full, dropping the alert")
+	}
+}
+
+// deliver posts a queued notification and, once the webhook took it,
+// records where its rule stands
+func (n *AlertNotifier) deliver(ctx context.Context, d alertDelivery) {
+	a := d.notification
+	fields := logrus.Fields{"kind": a.Kind, "status": a.Status, "observed": a.Observed}
+	err := n.send(ctx, a)
+	n.mu.Lock()
+	d.state.pending = false
+	if err == nil {
+		d.state.firing = d.firing
+		d.state.notified = a.At
+	}
+	n.mu.Unlock()
+	if err != nil {
+		logger.WithError(err).WithFields(fields).Warn("failed to send activity alert")
+		return
+	}
+	logger.WithFields(fields).Info("sent activity alert")
+
+	if d.firing {
+		alert := Alert{Kind: a.Kind, Metric: a.Kind, Expected: a.Threshold, Observed: a.Observed, WindowStart: a.At.Add(-n.cfg.Window)}
+		if err := recordAlert(&alert); err != nil {
+			logger.WithError(err).Warn("failed to record activity alert")
+		}
+	}
+}
+
+// alertMessage describes a notification for people
+func alertMessage(a AlertNotification) string {
+	var what string
+	switch a.Kind {
+	case AlertKindErrorRate:
+		what = fmt.Sprintf("5xx rate is %.1f%% (threshold %.1f%%)", a.Observed*100, a.Threshold*100)
+	case AlertKindCheckoutFailures:
+		what = fmt.Sprintf("%.0f checkouts failed (threshold %.0f)", a.Observed, a.Threshold)
+	}
+	if a.Status == "resolved" {
+		return fmt.Sprintf("Resolved: %s over the last %s", what, a.Window)
+	}
+	return fmt.Sprintf("Alert: %s over the last %s", what, a.Window)
+}
+
+// send posts a notification in the configured format
+func (n *AlertNotifier) send(ctx context.Context, a AlertNotification) error {
+	var payload interface{} = a
+	if n.cfg.Format == AlertFormatSlack {
+		payload = map[string]string{"text": a.Message}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// alertReceiver collects the payloads posted to it
+type alertReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	payloads []string
+}
+
+func newAlertReceiver(t *testing.T, status func() int) *alertReceiver {
+	rcv := &alertReceiver{}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if code := status(); code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.payloads = append(rcv.payloads, string(body))
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+func alwaysOK() int { return http.StatusOK }
+
+// notifications decodes the JSON notifications received so far
+func (rcv *alertReceiver) notifications(t *testing.T) []AlertNotification {
+	t.Helper()
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	var got []AlertNotification
+	for _, p := range rcv.payloads {
+		var a AlertNotification
+		if err := json.Unmarshal([]byte(p), &a); err != nil {
+			t.Fatalf("payload %s isn't a notification: %v", p, err)
+		}
+		got = append(got, a)
+	}
+	return got
+}
+
+// feedRequests observes requests logged now, errors of them answered with
+// a 500
+func feedRequests(n *AlertNotifier, fc *FakeClock, requests, errors int) {
+	for i := 0; i < requests; i++ {
+		a := ActivityLog{ActivityType: ActivityTypePageView, StatusCode: http.StatusOK, CreatedAt: fc.Now()}
+		if i < errors {
+			a.StatusCode = http.StatusInternalServerError
+		}
+		n.observe(a)
+	}
+}
+
+// evaluate evaluates the rules at now and delivers what that queued
+func evaluate(n *AlertNotifier, now time.Time) {
+	n.evaluate(now)
+	for len(n.queue) > 0 {
+		n.deliver(context.Background(), <-n.queue)
+	}
+}
+
+func TestAlertNotifierErrorRate(t *testing.T) {
+	fc := setupTestDB(t)
+	rcv := newAlertReceiver(t, alwaysOK)
+	n := NewAlertNotifier(AlertNotifierConfig{URL: rcv.URL, ErrorRate: 0.1, MinRequests: 20, Cooldown: 10 * time.Minute})
+
+	// A high rate over too few requests is noise
+	feedRequests(n, fc, 10, 5)
+	evaluate(n, fc.Now())
+	if got := rcv.notifications(t); len(got) != 0 {
+		t.Fatalf("10 requests raised %+v", got)
+	}
+
+	feedRequests(n, fc, 30, 5)
+	evaluate(n, fc.Now())
+	got := rcv.notifications(t)
+	if len(got) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(got))
+	}
+	if a := got[0]; a.Status != "firing" || a.Kind != AlertKindErrorRate || a.Observed != 0.25 || a.Threshold != 0.1 ||
+		!a.At.Equal(fc.Now()) || !strings.Contains(a.Message, "25.0%") {
+		t.Errorf("notification = %+v", a)
+	}
+	if alerts := mustGetAlerts(t, false); len(alerts) != 1 || alerts[0].Kind != AlertKindErrorRate {
+		t.Errorf("recorded alerts = %+v, want the error rate alert", alerts)
+	}
+
+	// A sustained incident is notified once per cool-down
+	for i := 0; i < 12; i++ {
+		fc.Advance(time.Minute)
+		feedRequests(n, fc, 10, 5)
+		evaluate(n, fc.Now())
+	}
+	if got := rcv.notifications(t); len(got) != 2 || got[1].Status != "firing" {
+		t.Fatalf("12 minutes into the incident: %+v, want a second firing notification", got)
+	}
+
+	// Once the errors fall out of the window, it resolves, once
+	fc.Advance(6 * time.Minute)
+	feedRequests(n, fc, 30, 0)
+	evaluate(n, fc.Now())
+	evaluate(n, fc.Now())
+	got = rcv.notifications(t)
+	if len(got) != 3 || got[2].Status != "resolved" || got[2].Observed != 0 || !strings.HasPrefix(got[2].Message, "Resolved") {
+		t.Errorf("after recovering: %+v, want a single resolution", got)
+	}
+}
+
+func TestAlertNotifierCheckoutFailuresInSlackFormat(t *testing.T) {
+	fc := setupTestDB(t)
+	rcv := newAlertReceiver(t, alwaysOK)
+	n := NewAlertNotifier(AlertNotifierConfig{URL: rcv.URL, Format: AlertFormatSlack, CheckoutFailures: 2})
+
+	for i := 0; i < 3; i++ {
+		n.observe(ActivityLog{ActivityType: ActivityTypeCheckout, StatusCode: http.StatusFound,
+			Details: `{"failed":true,"failure_reason":"payment_declined"}`, CreatedAt: fc.Now()})
+		n.observe(ActivityLog{ActivityType: ActivityTypeCheckout, StatusCode: http.StatusOK, Details: `{"order_id":"1"}`, CreatedAt: fc.Now()})
+	}
+	evaluate(n, fc.Now())
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if len(rcv.payloads) != 1 {
+		t.Fatalf("got %d payloads, want 1", len(rcv.payloads))
+	}
+	var slack map[string]string
+	if err := json.Unmarshal([]byte(rcv.payloads[0]), &slack); err != nil || len(slack) != 1 {
+		t.Fatalf("payload %s isn't a Slack message: %v", rcv.payloads[0], err)
+	}
+	if want := "Alert: 3 checkouts failed (threshold 2) over the last 5m0s"; slack["text"] != want {
+		t.Errorf("text = %q, want %q", slack["text"], want)
+	}
+}
+
+func TestAlertNotifierRetriesUndelivered(t *testing.T) {
+	fc := setupTestDB(t)
+	var calls atomic.Int32
+	rcv := newAlertReceiver(t, func() int {
+		if calls.Add(1) == 1 {
+			return http.StatusBadGateway
+		}
+		return http.StatusOK
+	})
+	n := NewAlertNotifier(AlertNotifierConfig{URL: rcv.URL, MinRequests: 1})
+
+	feedRequests(n, fc, 10, 10)
+	evaluate(n, fc.Now())
+	if got := rcv.notifications(t); len(got) != 0 {
+		t.Fatalf("rejected notification was recorded: %+v", got)
+	}
+	fc.Advance(15 * time.Second)
+	evaluate(n, fc.Now())
+	if got := rcv.notifications(t); len(got) != 1 || got[0].Status != "firing" {
+		t.Errorf("after a failed delivery: %+v, want the alert delivered at the next evaluation", got)
+	}
+}
+
+func TestAlertNotifierQueuesOneNotificationPerRule(t *testing.T) {
+	fc := setupTestDB(t)
+	rcv := newAlertReceiver(t, alwaysOK)
+	n := NewAlertNotifier(AlertNotifierConfig{URL: rcv.URL, MinRequests: 1})
+
+	// The webhook is slow: evaluations go on without waiting for it
+	feedRequests(n, fc, 10, 10)
+	for i := 0; i < 3; i++ {
+		n.evaluate(fc.Now())
+		fc.Advance(15 * time.Second)
+	}
+	if got := len(n.queue); got != 1 {
+		t.Fatalf("%d notifications queued, want the alert once", got)
+	}
+	n.deliver(context.Background(), <-n.queue)
+	if got := rcv.notifications(t); len(got) != 1 || got[0].Status != "firing" {
+		t.Errorf("delivered %+v, want the alert", got)
+	}
+}
+
+func TestValidateAlertFormat(t *testing.T) {
+	for _, format := range []string{AlertFormatJSON, AlertFormatSlack} {
+		if err := ValidateAlertFormat(format); err != nil {
+			t.Errorf("ValidateAlertFormat(%q) = %v", format, err)
+		}
+	}
+	if err := ValidateAlertFormat("teams"); err == nil {
+		t.Error("ValidateAlertFormat(teams) succeeded, want an error")
+	}
+}
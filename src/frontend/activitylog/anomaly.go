@@ -0,0 +1,293 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAnomalyWindow     = 30 * time.Minute
+	defaultAnomalyMultiplier = 3
+	defaultAnomalyMinCount   = 10
+
+	// AlertKindAnomaly is the kind of alerts raised by the anomaly detector
+	AlertKindAnomaly = "anomaly"
+)
+
+// ErrAlertNotFound is returned when acknowledging an alert that doesn't exist
+var ErrAlertNotFound = errors.New("activitylog: alert not found")
+
+// Alert is a noteworthy change in traffic, kept until acknowledged
+type Alert struct {
+	ID   int64  `json:"id"`
+	Kind string `json:"kind"`
+	// Metric names what was measured, e.g. "page_view per minute"
+	Metric   string  `json:"metric"`
+	Expected float64 `json:"expected"`
+	Observed float64 `json:"observed"`
+	// WindowStart is the start of the minute the observation covers
+	WindowStart    time.Time  `json:"window_start"`
+	CreatedAt      time.Time  `json:"created_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at"`
+}
+
+// AnomalyConfig tunes the anomaly detector. Zero fields use the defaults.
+type AnomalyConfig struct {
+	// Window is the span of the trailing average each minute is compared
+	// against. It sets the smoothing of an exponentially weighted moving
+	// average, and is rounded to whole minutes.
+	Window time.Duration
+	// Warmup is how long a type must have been tracked before it can raise
+	// alerts, Window by default.
+	Warmup time.Duration
+	// Multiplier is how far a minute's count must be above or below the
+	// average to be flagged: more than Multiplier times the average, or
+	// less than the average divided by Multiplier.
+	Multiplier float64
+	// MinCount keeps quiet types quiet: spikes are only flagged when at
+	// least MinCount activities were observed, drops when at least MinCount
+	// were expected.
+	MinCount int
+}
+
+// baseline is the moving average of a type's activities per minute
+type baseline struct {
+	average float64
+	minutes int
+}
+
+// AnomalyDetector counts logged activities per type and minute and raises
+// an alert when a minute's count deviates too much from the trailing
+// average of that type.
+type AnomalyDetector struct {
+	cfg    AnomalyConfig
+	alpha  float64
+	warmup int
+
+	mu        sync.Mutex
+	minute    time.Time
+	counts    map[string]int
+	baselines map[string]*baseline
+}
+
+// NewAnomalyDetector creates a detector with the given configuration
+func NewAnomalyDetector(cfg AnomalyConfig) *AnomalyDetector {
+	if cfg.Window < time.Minute {
+		cfg.Window = defaultAnomalyWindow
+	}
+	if cfg.Warmup <= 0 {
+		cfg.Warmup = cfg.Window
+	}
+	if cfg.Multiplier <= 1 {
+		cfg.Multiplier = defaultAnomalyMultiplier
+	}
+	if cfg.MinCount <= 0 {
+		cfg.MinCount = defaultAnomalyMinCount
+	}
+	window := int(cfg.Window / time.Minute)
+	return &AnomalyDetector{
+		cfg:       cfg,
+		alpha:     2 / float64(window+1),
+		warmup:    int(cfg.Warmup / time.Minute),
+		counts:    make(map[string]int),
+		baselines: make(map[string]*baseline),
+	}
+}
+
+// Start feeds logged activities to the detector and checks every finished
+// minute until ctx is done.
+func (d *AnomalyDetector) Start(ctx context.Context) {
+	activities, unsubscribe := Subscribe(256)
+	go func() {
+		defer unsubscribe()
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case a := <-activities:
+				d.observe(a)
+			case <-ticker.C:
+				d.advance(Now())
+			}
+		}
+	}()
+}
+
+// Reset forgets the counts and averages observed so far, as if the
+// detector had just started
+func (d *AnomalyDetector) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.minute = time.Time{}
+	d.counts = make(map[string]int)
+	d.baselines = make(map[string]*baseline)
+}
+
+// observe counts an activity in the minute it was logged in. Activities
+// arriving after their minute was checked count towards the current one.
+func (d *AnomalyDetector) observe(a ActivityLog) {
+	d.advance(a.CreatedAt)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counts[a.ActivityType]++
+}
+
+// advance checks every minute that ended by now and records the alerts
+func (d *AnomalyDetector) advance(now time.Time) {
+	for _, alert := range d.closeMinutes(now.UTC().Truncate(time.Minute)) {
+		logger.WithFields(logrus.Fields{
+			"metric":   alert.Metric,
+			"expected": alert.Expected,
+			"observed": alert.Observed,
+		}).Warn("activity anomaly detected")
+		if err := recordAlert(&alert); err != nil {
+			logger.WithError(err).Warn("failed to record activity alert")
+		}
+	}
+}
+
+// closeMinutes compares the counts of every minute before current to the
+// baselines, folds them into the baselines and returns the anomalies.
+// Minutes without any activity count as zero, unless the detector was idle
+// for so long that the baselines are meaningless and start over.
+func (d *AnomalyDetector) closeMinutes(current time.Time) []Alert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.minute.IsZero() {
+		d.minute = current
+	}
+	if current.Sub(d.minute) > 10*d.cfg.Window {
+		d.minute = current
+		d.counts = make(map[string]int)
+		d.baselines = make(map[string]*baseline)
+		return nil
+	}
+
+	var alerts []Alert
+	for ; d.minute.Before(current); d.minute = d.minute.Add(time.Minute) {
+		for t := range d.counts {
+			if d.baselines[t] == nil {
+				d.baselines[t] = &baseline{}
+			}
+		}
+		for t, b := range d.baselines {
+			observed := float64(d.counts[t])
+			if b.minutes >= d.warmup && d.anomalous(b.average, observed) {
+				alerts = append(alerts, Alert{
+					Kind:        AlertKindAnomaly,
+					Metric:      t + " per minute",
+					Expected:    b.average,
+					Observed:    observed,
+					WindowStart: d.minute,
+				})
+			}
+			if b.minutes == 0 {
+				b.average = observed
+			} else {
+				b.average += d.alpha * (observed - b.average)
+			}
+			b.minutes++
+		}
+		d.counts = make(map[string]int)
+	}
+	return alerts
+}
+
+// anomalous tells whether observed is too far from the expected count
+func (d *AnomalyDetector) anomalous(expected, observed float64) bool {
+	minCount := float64(d.cfg.MinCount)
+	spike := observed >= minCount && observed > expected*d.cfg.Multiplier
+	drop := expected >= minCount && observed < expected/d.cfg.Multiplier
+	return spike || drop
+}
+
+// recordAlert stores a new alert and sets its ID and creation time. While
+// the activity log is read-only alerts are only logged.
+func recordAlert(alert *Alert) error {
+	if ReadOnly() {
+		return ErrReadOnly
+	}
+	alert.CreatedAt = Now()
+	res, err := GetDB().Exec(`
+		INSERT INTO activity_alerts (kind, metric, expected, observed, window_start, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		alert.Kind, alert.Metric, alert.Expected, alert.Observed, alert.WindowStart.UTC(), alert.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	alert.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetAlerts returns the most recent alerts, newest first, optionally only
+// those not acknowledged yet
+func GetAlerts(limit int, unacknowledgedOnly bool) ([]Alert, error) {
+	query := `
+		SELECT id, kind, metric, expected, observed, window_start, created_at, acknowledged_at
+		FROM activity_alerts`
+	if unacknowledgedOnly {
+		query += " WHERE acknowledged_at IS NULL"
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+
+	rows, err := getReadDB().Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		var a Alert
+		var acked sql.NullTime
+		if err := rows.Scan(&a.ID, &a.Kind, &a.Metric, &a.Expected, &a.Observed,
+			&a.WindowStart, &a.CreatedAt, &acked); err != nil {
+			return nil, err
+		}
+		if acked.Valid {
+			a.AcknowledgedAt = &acked.Time
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// AcknowledgeAlert marks an alert as seen. Acknowledging it again keeps the
+// original acknowledgment time.
+func AcknowledgeAlert(id int64) error {
+	res, err := GetDB().Exec(
+		"UPDATE activity_alerts SET acknowledged_at = COALESCE(acknowledged_at, ?) WHERE id = ?",
+		Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlertNotFound
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// feedMinutes observes count activities of the given type during each of
+// the given number of minutes, advancing the clock past each of them
+func feedMinutes(d *AnomalyDetector, fc *FakeClock, activityType string, count, minutes int) {
+	for m := 0; m < minutes; m++ {
+		for i := 0; i < count; i++ {
+			d.observe(ActivityLog{ActivityType: activityType, CreatedAt: fc.Now()})
+		}
+		fc.Advance(time.Minute)
+		d.advance(fc.Now())
+	}
+}
+
+func mustGetAlerts(t *testing.T, unacknowledgedOnly bool) []Alert {
+	t.Helper()
+	alerts, err := GetAlerts(100, unacknowledgedOnly)
+	if err != nil {
+		t.Fatalf("GetAlerts() failed: %v", err)
+	}
+	return alerts
+}
+
+func TestAnomalyDetectorFlagsSpikesAndDrops(t *testing.T) {
+	fc := setupTestDB(t)
+	d := NewAnomalyDetector(AnomalyConfig{Window: 10 * time.Minute, Multiplier: 3, MinCount: 5})
+
+	feedMinutes(d, fc, ActivityTypePageView, 10, 10)
+	if alerts := mustGetAlerts(t, false); len(alerts) != 0 {
+		t.Fatalf("steady traffic raised %+v", alerts)
+	}
+
+	spikeStart := fc.Now()
+	feedMinutes(d, fc, ActivityTypePageView, 40, 1)
+	alerts := mustGetAlerts(t, false)
+	if len(alerts) != 1 {
+		t.Fatalf("spike raised %d alerts, want 1", len(alerts))
+	}
+	a := alerts[0]
+	if a.Kind != AlertKindAnomaly || a.Metric != "page_view per minute" || a.Expected != 10 || a.Observed != 40 ||
+		!a.WindowStart.Equal(spikeStart) || !a.CreatedAt.Equal(fc.Now()) || a.AcknowledgedAt != nil {
+		t.Errorf("spike alert = %+v", a)
+	}
+
+	// Traffic stopping altogether is a drop
+	fc.Advance(time.Minute)
+	d.advance(fc.Now())
+	alerts = mustGetAlerts(t, false)
+	if len(alerts) != 2 || alerts[0].Observed != 0 || alerts[0].Expected <= 10 {
+		t.Errorf("drop raised %+v, want a second alert observing nothing", alerts)
+	}
+}
+
+func TestAnomalyDetectorWaitsForWarmup(t *testing.T) {
+	fc := setupTestDB(t)
+	d := NewAnomalyDetector(AnomalyConfig{Window: 10 * time.Minute, Warmup: 5 * time.Minute, MinCount: 5})
+
+	feedMinutes(d, fc, ActivityTypeCheckout, 1, 4)
+	feedMinutes(d, fc, ActivityTypeCheckout, 50, 1)
+	if alerts := mustGetAlerts(t, false); len(alerts) != 0 {
+		t.Errorf("spike during warm-up raised %+v", alerts)
+	}
+}
+
+func TestAnomalyDetectorIgnoresQuietTypes(t *testing.T) {
+	fc := setupTestDB(t)
+	d := NewAnomalyDetector(AnomalyConfig{Window: 5 * time.Minute, MinCount: 10})
+
+	// Quadrupling from one to four a minute, and going back to none, are
+	// both below MinCount
+	feedMinutes(d, fc, ActivityTypeAddToCart, 1, 5)
+	feedMinutes(d, fc, ActivityTypeAddToCart, 4, 1)
+	feedMinutes(d, fc, ActivityTypeAddToCart, 0, 3)
+	if alerts := mustGetAlerts(t, false); len(alerts) != 0 {
+		t.Errorf("quiet type raised %+v", alerts)
+	}
+}
+
+func TestAnomalyDetectorStartsOverAfterIdling(t *testing.T) {
+	fc := setupTestDB(t)
+	d := NewAnomalyDetector(AnomalyConfig{Window: 5 * time.Minute, MinCount: 5})
+
+	feedMinutes(d, fc, ActivityTypePageView, 20, 5)
+	// Not running for hours isn't a drop, and doesn't make the next minute
+	// a spike either
+	fc.Advance(3 * time.Hour)
+	feedMinutes(d, fc, ActivityTypePageView, 20, 2)
+	if alerts := mustGetAlerts(t, false); len(alerts) != 0 {
+		t.Errorf("idling raised %+v", alerts)
+	}
+}
+
+func TestAcknowledgeAlert(t *testing.T) {
+	fc := setupTestDB(t)
+	alert := Alert{Kind: AlertKindAnomaly, Metric: "page_view per minute", Expected: 1, Observed: 9, WindowStart: fc.Now()}
+	if err := recordAlert(&alert); err != nil {
+		t.Fatalf("recordAlert() failed: %v", err)
+	}
+
+	fc.Advance(time.Minute)
+	if err := AcknowledgeAlert(alert.ID); err != nil {
+		t.Fatalf("AcknowledgeAlert() failed: %v", err)
+	}
+	ackedAt := fc.Now()
+	fc.Advance(time.Minute)
+	if err := AcknowledgeAlert(alert.ID); err != nil {
+		t.Fatalf("AcknowledgeAlert() again failed: %v", err)
+	}
+
+	alerts := mustGetAlerts(t, false)
+	if len(alerts) != 1 || alerts[0].AcknowledgedAt == nil || !alerts[0].AcknowledgedAt.Equal(ackedAt) {
+		t.Errorf("GetAlerts() = %+v, want the alert acknowledged at %v", alerts, ackedAt)
+	}
+	if alerts := mustGetAlerts(t, true); len(alerts) != 0 {
+		t.Errorf("GetAlerts(unacknowledged) = %+v, want none", alerts)
+	}
+	if err := AcknowledgeAlert(alert.ID + 1); !errors.Is(err, ErrAlertNotFound) {
+		t.Errorf("AcknowledgeAlert(missing) = %v, want ErrAlertNotFound", err)
+	}
+}
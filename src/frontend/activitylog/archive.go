@@ -0,0 +1,48 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ArchiveObject names part of the archive of a UTC day, partitioned by date
+// the way Hive-style tools expect, e.g.
+// activities/dt=2025-06-01/part-0.ndjson.gz
+func ArchiveObject(day time.Time, part int) string {
+	return fmt.Sprintf("activities/dt=%s/part-%d.ndjson.gz", day.UTC().Format(dateLayout), part)
+}
+
+// WriteArchive writes the activities created in [start, end) to w, oldest
+// first, as gzipped newline-delimited JSON, and returns how many it wrote.
+// Activities are streamed, so an archive of any size takes little memory.
+func WriteArchive(ctx context.Context, w io.Writer, start, end time.Time) (int, error) {
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	n := 0
+	err := QueryStream(ctx, Filter{Start: start, End: end}, func(a ActivityLog) error {
+		n++
+		return enc.Encode(a)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, zw.Close()
+}
@@ -0,0 +1,216 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// StatsAsOf are the activity stats of a window of whole UTC days as they
+// were at a point in time, computed from the roll-ups alone
+type StatsAsOf struct {
+	AsOf time.Time `json:"as_of"`
+	// Start and End are the days counted, [Start, End)
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	Counts       map[string]int `json:"counts"`
+	Errors       int            `json:"errors"`
+	AvgLatencyMs float64        `json:"avg_latency_ms"`
+	Coverage
+}
+
+// Coverage tells which days of a window the numbers leave out because the
+// data to count them is missing. Warning is only set when some are.
+type Coverage struct {
+	MissingDays []string `json:"missing_days,omitempty"`
+	Warning     string   `json:"warning,omitempty"`
+}
+
+// Partial tells whether some days of the window are left out
+func (c Coverage) Partial() bool {
+	return len(c.MissingDays) > 0
+}
+
+func newCoverage(missing []string, days int, what string) Coverage {
+	c := Coverage{MissingDays: missing}
+	if len(missing) > 0 {
+		c.Warning = fmt.Sprintf("partial coverage: %d of the %d days of the window have no %s, the numbers leave them out",
+			len(missing), days, what)
+	}
+	return c
+}
+
+// AsOfWindow returns the whole UTC days [start, end) of the window ending
+// at the UTC midnight starting the day of asOf, which is as far as roll-ups
+// can tell. window must be a positive number of days.
+func AsOfWindow(window time.Duration, asOf time.Time) (time.Time, time.Time, error) {
+	if window <= 0 || window%day != 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("window must be a positive number of days, got %v", window)
+	}
+	if asOf.IsZero() {
+		return time.Time{}, time.Time{}, fmt.Errorf("as of time is required")
+	}
+	end := truncateDay(asOf)
+	return end.Add(-window), end, nil
+}
+
+// GetStatsAsOf returns the stats of the window days before asOf, such as
+// the last 7 days as of a month ago, as they were rolled up. Raw activities
+// aren't read, so the stats are the same once they are deleted. Days of the
+// window that weren't rolled up are listed as missing rather than counted
+// from whatever raw activities are left.
+func GetStatsAsOf(ctx context.Context, window time.Duration, asOf time.Time) (*StatsAsOf, error) {
+	start, end, err := AsOfWindow(window, asOf)
+	if err != nil {
+		return nil, err
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "stats as of", time.Now())
+
+	stats := &StatsAsOf{AsOf: asOf.UTC(), Start: start, End: end, Counts: make(map[string]int)}
+	first, last := start.Format(dateLayout), end.Format(dateLayout)
+	rows, err := getReadDB().QueryContext(ctx, `
+		SELECT activity_type, SUM(count), SUM(error_count), SUM(total_latency_ms)
+		FROM activity_rollups
+		WHERE date >= ? AND date < ?
+		GROUP BY activity_type`, first, last)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var total, latency int
+	for rows.Next() {
+		var activityType string
+		var count, errs, ms int
+		if err := rows.Scan(&activityType, &count, &errs, &ms); err != nil {
+			return nil, err
+		}
+		stats.Counts[activityType] = count
+		stats.Errors += errs
+		total += count
+		latency += ms
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if total > 0 {
+		stats.AvgLatencyMs = float64(latency) / float64(total)
+	}
+
+	rolled, err := rolledUpDateSet(ctx, first, last)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for d := start; d.Before(end); d = d.Add(day) {
+		if !rolled[d.Format(dateLayout)] {
+			missing = append(missing, d.Format(dateLayout))
+		}
+	}
+	stats.Coverage = newCoverage(missing, int(window/day), "roll-ups")
+	return stats, nil
+}
+
+// GetRawCoverage checks that the raw activities of the days [start, end)
+// are all still there, which stats that roll-ups can't answer, such as
+// splits by version, need. A rolled up day counting more activities than
+// are left was pruned since and is listed as missing. Days that weren't
+// rolled up can't be checked and are assumed whole.
+func GetRawCoverage(ctx context.Context, start, end time.Time) (Coverage, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return Coverage{}, err
+	}
+	defer release()
+	defer observeQuery(ctx, "raw coverage", time.Now())
+
+	rolled := make(map[string]int)
+	rows, err := getReadDB().QueryContext(ctx, `
+		SELECT date, SUM(count) FROM activity_rollups
+		WHERE date >= ? AND date < ?
+		GROUP BY date`, start.UTC().Format(dateLayout), end.UTC().Format(dateLayout))
+	if err != nil {
+		return Coverage{}, err
+	}
+	for rows.Next() {
+		var date string
+		var count int
+		if err := rows.Scan(&date, &count); err != nil {
+			rows.Close()
+			return Coverage{}, err
+		}
+		rolled[date] = count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Coverage{}, err
+	}
+
+	raw := make(map[string]int)
+	rows, err = getReadDB().QueryContext(ctx, `
+		SELECT date(created_at), COUNT(*) FROM activities
+		WHERE `+createdIn("created_at")+` AND deleted_at IS NULL
+		GROUP BY date(created_at)`, start.UTC(), end.UTC())
+	if err != nil {
+		return Coverage{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var date string
+		var count int
+		if err := rows.Scan(&date, &count); err != nil {
+			return Coverage{}, err
+		}
+		raw[date] = count
+	}
+	if err := rows.Err(); err != nil {
+		return Coverage{}, err
+	}
+
+	var missing []string
+	days := 0
+	for d := truncateDay(start); d.Before(end); d = d.Add(day) {
+		days++
+		date := d.Format(dateLayout)
+		if count, ok := rolled[date]; ok && raw[date] < count {
+			missing = append(missing, date)
+		}
+	}
+	return newCoverage(missing, days, "raw activities left"), nil
+}
+
+// rolledUpDateSet returns the days in [first, last) that were rolled up
+func rolledUpDateSet(ctx context.Context, first, last string) (map[string]bool, error) {
+	rows, err := getReadDB().QueryContext(ctx, "SELECT date FROM activity_rollup_days WHERE date >= ? AND date < ?", first, last)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rolled := make(map[string]bool)
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			return nil, err
+		}
+		rolled[date] = true
+	}
+	return rolled, rows.Err()
+}
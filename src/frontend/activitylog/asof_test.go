@@ -0,0 +1,111 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStatsAsOfSurvivePruning(t *testing.T) {
+	fc := setupTestDB(t)
+	seedDays(t, fc, 10)
+	today := truncateDay(fc.Now())
+	if _, err := RebuildRollups(context.Background(), today.Add(-10*day), today); err != nil {
+		t.Fatalf("RebuildRollups failed: %v", err)
+	}
+	asOf := today.Add(-2*day + 5*time.Hour)
+
+	before, err := GetStatsAsOf(context.Background(), 3*day, asOf)
+	if err != nil {
+		t.Fatalf("GetStatsAsOf failed: %v", err)
+	}
+	if want := today.Add(-5 * day); !before.Start.Equal(want) || !before.End.Equal(today.Add(-2*day)) {
+		t.Errorf("window = [%v, %v), want [%v, %v)", before.Start, before.End, want, today.Add(-2*day))
+	}
+	if before.Partial() || before.Warning != "" {
+		t.Errorf("fully rolled up window reported as partial: %+v", before.Coverage)
+	}
+	raw, err := GetActivityStats(context.Background(), Filter{Start: before.Start, End: before.End})
+	if err != nil {
+		t.Fatalf("GetActivityStats failed: %v", err)
+	}
+	if !reflect.DeepEqual(before.Counts, raw) {
+		t.Errorf("counts = %v, want %v", before.Counts, raw)
+	}
+
+	// Prune the raw activities of the window
+	if _, err := GetDB().Exec("DELETE FROM activities WHERE created_at < ?", before.End); err != nil {
+		t.Fatalf("pruning failed: %v", err)
+	}
+	after, err := GetStatsAsOf(context.Background(), 3*day, asOf)
+	if err != nil {
+		t.Fatalf("GetStatsAsOf after pruning failed: %v", err)
+	}
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("stats changed after pruning:\n got %+v\nwant %+v", after, before)
+	}
+	coverage, err := GetRawCoverage(context.Background(), before.Start, before.End)
+	if err != nil {
+		t.Fatalf("GetRawCoverage failed: %v", err)
+	}
+	if want := []string{"2025-05-27", "2025-05-28", "2025-05-29"}; !reflect.DeepEqual(coverage.MissingDays, want) {
+		t.Errorf("missing raw days = %v, want %v", coverage.MissingDays, want)
+	}
+	if coverage, err := GetRawCoverage(context.Background(), today.Add(-2*day), today); err != nil || coverage.Partial() {
+		t.Errorf("GetRawCoverage of unpruned days = %+v, %v, want whole", coverage, err)
+	}
+}
+
+func TestStatsAsOfWarnsAboutMissingRollups(t *testing.T) {
+	fc := setupTestDB(t)
+	seedDays(t, fc, 4)
+	today := truncateDay(fc.Now())
+	if err := RollupDay(context.Background(), today.Add(-2*day)); err != nil {
+		t.Fatalf("RollupDay failed: %v", err)
+	}
+
+	stats, err := GetStatsAsOf(context.Background(), 3*day, fc.Now())
+	if err != nil {
+		t.Fatalf("GetStatsAsOf failed: %v", err)
+	}
+	if want := []string{"2025-05-29", "2025-05-31"}; !reflect.DeepEqual(stats.MissingDays, want) {
+		t.Errorf("missing days = %v, want %v", stats.MissingDays, want)
+	}
+	if stats.Warning == "" {
+		t.Error("partial window has no warning")
+	}
+	raw, err := GetActivityStats(context.Background(), Filter{Start: today.Add(-2 * day), End: today.Add(-day)})
+	if err != nil {
+		t.Fatalf("GetActivityStats failed: %v", err)
+	}
+	if !reflect.DeepEqual(stats.Counts, raw) {
+		t.Errorf("counts = %v, want only the rolled up day's %v", stats.Counts, raw)
+	}
+}
+
+func TestAsOfWindowRequiresWholeDays(t *testing.T) {
+	asOf := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	for _, window := range []time.Duration{0, -day, 36 * time.Hour} {
+		if _, _, err := AsOfWindow(window, asOf); err == nil {
+			t.Errorf("AsOfWindow(%v) succeeded, want an error", window)
+		}
+	}
+	if _, _, err := AsOfWindow(day, time.Time{}); err == nil {
+		t.Error("AsOfWindow without an as of time succeeded")
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"time"
+)
+
+// assistantCartWindow is how soon after a message to the assistant a cart
+// add counts as led to by the assistant
+const assistantCartWindow = 10 * time.Minute
+
+// AssistantUsage summarizes how shoppers used the shopping assistant
+type AssistantUsage struct {
+	Messages int `json:"messages"`
+	Sessions int `json:"sessions"`
+	// MessagesPerSession is the average length of a conversation
+	MessagesPerSession float64 `json:"messages_per_session"`
+	// CartAddSessions is how many of the sessions added to their cart
+	// within ten minutes of a message, and CartAddRate their fraction
+	CartAddSessions int     `json:"cart_add_sessions"`
+	CartAddRate     float64 `json:"cart_add_rate"`
+}
+
+// GetAssistantUsage counts the messages sent to the shopping assistant
+// during a given time period and the sessions that sent them, and how many
+// of those went on to add to their cart shortly after a message
+func GetAssistantUsage(ctx context.Context, startTime, endTime time.Time) (AssistantUsage, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return AssistantUsage{}, err
+	}
+	defer release()
+	defer observeQuery(ctx, "assistant usage", time.Now())
+
+	query := `
+		WITH messages AS MATERIALIZED (
+			SELECT session_id, created_at
+			FROM activities
+			WHERE activity_type = ? AND ` + createdIn("created_at") + ` AND deleted_at IS NULL
+		)
+		SELECT COUNT(*), COUNT(DISTINCT session_id),
+			   (SELECT COUNT(DISTINCT m.session_id)
+				FROM messages m
+				WHERE EXISTS (
+					SELECT 1 FROM activities c
+					WHERE c.session_id = m.session_id AND c.activity_type = ?
+					  AND c.created_at > m.created_at AND c.deleted_at IS NULL
+					  AND julianday(c.created_at) - julianday(m.created_at) <= ?))
+		FROM messages`
+
+	var usage AssistantUsage
+	err = getReadDB().QueryRowContext(ctx, query, ActivityTypeAssistantMessage, startTime.UTC(), endTime.UTC(),
+		ActivityTypeAddToCart, assistantCartWindow.Hours()/24).
+		Scan(&usage.Messages, &usage.Sessions, &usage.CartAddSessions)
+	if err != nil {
+		return AssistantUsage{}, err
+	}
+	if usage.Sessions > 0 {
+		usage.MessagesPerSession = float64(usage.Messages) / float64(usage.Sessions)
+		usage.CartAddRate = float64(usage.CartAddSessions) / float64(usage.Sessions)
+	}
+	return usage, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package activitylog
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareRecordsAssistantMessages(t *testing.T) {
+	setupTestDB(t)
+	req := httptest.NewRequest("POST", "/bot", strings.NewReader(`{"message":"hi"}`))
+	serve(newTestRouter(), req, "session-1")
+	if a := lastActivity(t); a.ActivityType != ActivityTypeAssistantMessage {
+		t.Errorf("activity type = %q, want %q", a.ActivityType, ActivityTypeAssistantMessage)
+	}
+}
+
+func TestGetAssistantUsage(t *testing.T) {
+	fc := setupTestDB(t)
+	start := fc.Now()
+	// Three messages, then a cart add nine minutes after the last one
+	logJourney(t, fc, "helped", ActivityTypeAssistantMessage, ActivityTypeAssistantMessage, ActivityTypeAssistantMessage)
+	fc.Advance(8 * time.Minute)
+	logJourney(t, fc, "helped", ActivityTypeAddToCart)
+	// A cart add eleven minutes after the only message is too late
+	logJourney(t, fc, "late", ActivityTypeAssistantMessage)
+	fc.Advance(10 * time.Minute)
+	logJourney(t, fc, "late", ActivityTypeAddToCart)
+	// Adding to the cart before asking doesn't count either
+	logJourney(t, fc, "before", ActivityTypeAddToCart, ActivityTypeAssistantMessage)
+	logJourney(t, fc, "no assistant", ActivityTypeProductView, ActivityTypeAddToCart)
+	end := fc.Now().Add(time.Minute)
+
+	got, err := GetAssistantUsage(context.Background(), start, end)
+	if err != nil {
+		t.Fatalf("GetAssistantUsage() failed: %v", err)
+	}
+	want := AssistantUsage{Messages: 5, Sessions: 3, MessagesPerSession: 5.0 / 3, CartAddSessions: 1, CartAddRate: 1.0 / 3}
+	if got != want {
+		t.Errorf("GetAssistantUsage() = %+v, want %+v", got, want)
+	}
+
+	if got, err := GetAssistantUsage(context.Background(), end, end.Add(time.Hour)); err != nil || got != (AssistantUsage{}) {
+		t.Errorf("GetAssistantUsage(empty window) = %+v, %v, want zero usage", got, err)
+	}
+}
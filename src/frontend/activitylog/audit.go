@@ -0,0 +1,184 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Operations recorded in the audit trail
+const (
+	// AuditPurge is an admin deleting the activities matching a filter
+	AuditPurge = "purge"
+	// AuditDeleteSession is a shopper clearing the history of their
+	// session, which is soft-deleted
+	AuditDeleteSession = "delete_session"
+	// AuditDeleteDevice is a shopper clearing the history of their device,
+	// every session of it, which is soft-deleted
+	AuditDeleteDevice = "delete_device"
+	// AuditRemoveDeleted is the retention job removing soft-deleted
+	// activities whose grace period is over
+	AuditRemoveDeleted = "remove_deleted"
+	// AuditExpire is the retention job removing the activities of a type
+	// past its retention
+	AuditExpire = "expire"
+	// AuditReset is an end-to-end test emptying the activity log
+	AuditReset = "reset"
+	// AuditDropPartition is retention dropping a month of activities
+	AuditDropPartition = "drop_partition"
+)
+
+// unknownRequester records operations whose context doesn't say who asked
+const unknownRequester = "unknown"
+
+// AuditEntry is one destructive operation on the activity log
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Operation string          `json:"operation"`
+	Filter    json.RawMessage `json:"filter"`
+	// Affected is the number of activities the operation deleted
+	Affected  int64     `json:"affected"`
+	Requester string    `json:"requester"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ctxKeyRequester struct{}
+
+// WithRequester returns a context whose destructive operations are recorded
+// in the audit trail as requested by requester, such as the address of the
+// client that asked for them.
+func WithRequester(ctx context.Context, requester string) context.Context {
+	return context.WithValue(ctx, ctxKeyRequester{}, requester)
+}
+
+func requesterOf(ctx context.Context) string {
+	if r, ok := ctx.Value(ctxKeyRequester{}).(string); ok && r != "" {
+		return r
+	}
+	return unknownRequester
+}
+
+// auditRecord keeps the audit entry of an operation up to date as it
+// deletes activities, possibly over several transactions
+type auditRecord struct {
+	operation string
+	filter    interface{}
+	id        int64
+}
+
+// add counts n more affected activities, creating the entry the first time.
+// It runs in tx so that the entry is committed along with the change.
+func (a *auditRecord) add(ctx context.Context, tx *sql.Tx, n int64) error {
+	if a.id != 0 {
+		_, err := tx.ExecContext(ctx, "UPDATE admin_audit SET affected = affected + ? WHERE id = ?", n, a.id)
+		return err
+	}
+	filter, err := json.Marshal(a.filter)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO admin_audit (operation, filter, affected, requester, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		a.operation, string(filter), n, requesterOf(ctx), Now().UTC())
+	if err != nil {
+		return err
+	}
+	a.id, err = res.LastInsertId()
+	return err
+}
+
+// auditFilter describes the fields of a filter that are set
+func auditFilter(f Filter) map[string]interface{} {
+	m := make(map[string]interface{})
+	if !f.Start.IsZero() {
+		m["start"] = f.Start.UTC()
+	}
+	if !f.End.IsZero() {
+		m["end"] = f.End.UTC()
+	}
+	if len(f.Types) > 0 {
+		m["types"] = f.Types
+	}
+	if len(f.TypesNot) > 0 {
+		m["types_not"] = f.TypesNot
+	}
+	if f.SessionID != "" {
+		m["session_id"] = f.SessionID
+	}
+	if len(f.SessionIDs) > 0 {
+		m["session_ids"] = f.SessionIDs
+	}
+	if f.UserID != "" {
+		m["user_id"] = f.UserID
+	}
+	if f.PathPrefix != "" {
+		m["path_prefix"] = f.PathPrefix
+	}
+	if len(f.StatusClasses) > 0 {
+		m["status_classes"] = f.StatusClasses
+	}
+	if len(f.ResponseClasses) > 0 {
+		m["response_classes"] = f.ResponseClasses
+	}
+	if f.Source != "" {
+		m["source"] = f.Source
+	}
+	if f.Experiment != "" {
+		m["experiment"] = f.Experiment
+	}
+	if f.Variant != "" {
+		m["variant"] = f.Variant
+	}
+	if f.Version != "" {
+		m["version"] = f.Version
+	}
+	if len(f.Tags) > 0 {
+		m["tags"] = f.Tags
+	}
+	if len(f.Details) > 0 {
+		m["details"] = f.Details
+	}
+	return m
+}
+
+// GetAuditEntries returns the most recent entries of the audit trail,
+// newest first, at most MaxActivities
+func GetAuditEntries(limit int) ([]AuditEntry, error) {
+	rows, err := getReadDB().Query(`
+		SELECT id, operation, filter, affected, requester, created_at
+		FROM admin_audit
+		ORDER BY id DESC
+		LIMIT ?`, boundLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var filter string
+		if err := rows.Scan(&e.ID, &e.Operation, &filter, &e.Affected, &e.Requester, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Filter = json.RawMessage(filter)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
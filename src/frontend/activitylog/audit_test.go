@@ -0,0 +1,76 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDestructiveOperationsAreAudited(t *testing.T) {
+	fc := setupTestDB(t)
+	for i := 0; i < purgeBatchSize+5; i++ {
+		mustLog(t, &ActivityLog{SessionID: "bot", ActivityType: ActivityTypePageView, Source: SourceLoadGenerator})
+	}
+	mustLog(t, &ActivityLog{SessionID: "shopper", ActivityType: ActivityTypePageView})
+
+	ctx := WithRequester(context.Background(), "admin@192.0.2.1")
+	if _, err := DeleteByFilter(ctx, Filter{Source: SourceLoadGenerator}); err != nil {
+		t.Fatalf("DeleteByFilter() failed: %v", err)
+	}
+	if _, err := DeleteSession(context.Background(), "shopper"); err != nil {
+		t.Fatalf("DeleteSession() failed: %v", err)
+	}
+
+	entries, err := GetAuditEntries(0)
+	if err != nil {
+		t.Fatalf("GetAuditEntries() failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("GetAuditEntries() = %+v, want 2 entries", entries)
+	}
+	// Newest first
+	session, purge := entries[0], entries[1]
+	if purge.Operation != AuditPurge || purge.Affected != purgeBatchSize+5 || purge.Requester != "admin@192.0.2.1" ||
+		!purge.CreatedAt.Equal(fc.Now()) {
+		t.Errorf("purge entry = %+v, want %d activities purged by the admin", purge, purgeBatchSize+5)
+	}
+	var filter map[string]interface{}
+	if err := json.Unmarshal(purge.Filter, &filter); err != nil || !reflect.DeepEqual(filter, map[string]interface{}{"source": SourceLoadGenerator}) {
+		t.Errorf("purge filter = %s, want only the source", purge.Filter)
+	}
+	if session.Operation != AuditDeleteSession || session.Affected != 1 || session.Requester != unknownRequester ||
+		string(session.Filter) != `{"session_id":"shopper"}` {
+		t.Errorf("session entry = %+v, want the shopper's activity deleted by an unknown requester", session)
+	}
+}
+
+func TestFailedSessionDeleteIsNotAudited(t *testing.T) {
+	setupTestDB(t)
+	mustLog(t, &ActivityLog{SessionID: "shopper", ActivityType: ActivityTypePageView})
+	if _, err := GetDB().Exec("DROP TABLE admin_audit"); err != nil {
+		t.Fatalf("dropping the audit table failed: %v", err)
+	}
+
+	if _, err := DeleteSession(context.Background(), "shopper"); err == nil {
+		t.Fatal("DeleteSession() succeeded without an audit trail")
+	}
+	// The deletion and its audit entry go together
+	if n := countActivities(t); n != 1 {
+		t.Errorf("%d activities left, want the session kept", n)
+	}
+}
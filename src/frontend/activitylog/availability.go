@@ -0,0 +1,117 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// availabilityCartWindow is how soon after viewing a product adding it to
+// the cart counts as led to by the view
+const availabilityCartWindow = 30 * time.Minute
+
+// AvailabilityImpact compares, for a product, how often views led to a
+// cart add when the product could be shown and when it couldn't
+type AvailabilityImpact struct {
+	ProductID string `json:"product_id"`
+	// Views and CartAdds count the views of the product as available and
+	// those followed by adding it to the cart within 30 minutes in the same
+	// session. CartAddRate is their ratio.
+	Views       int     `json:"views"`
+	CartAdds    int     `json:"cart_adds"`
+	CartAddRate float64 `json:"cart_add_rate"`
+	// The Unavailable counts are the same for views flagged
+	// product_unavailable, when the catalog failed to return the product
+	UnavailableViews       int     `json:"unavailable_views"`
+	UnavailableCartAdds    int     `json:"unavailable_cart_adds"`
+	UnavailableCartAddRate float64 `json:"unavailable_cart_add_rate"`
+}
+
+// GetAvailabilityImpact returns, per product viewed during a given time
+// period, the cart add rates of its views with and without the product
+// available. Products with the most unavailable views come first.
+func GetAvailabilityImpact(ctx context.Context, startTime, endTime time.Time) ([]AvailabilityImpact, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "availability impact", time.Now())
+
+	query := `
+		SELECT product_id, unavailable, COUNT(*), COALESCE(SUM(carted), 0)
+		FROM (
+			SELECT v.product_id,
+				   COALESCE(json_extract(` + detailsJSON + `, '$.product_unavailable'), 0) <> 0 AS unavailable,
+				   EXISTS (
+					   SELECT 1 FROM activities c
+					   WHERE c.session_id = v.session_id AND c.product_id = v.product_id
+						 AND c.activity_type = ? AND c.created_at > v.created_at AND c.deleted_at IS NULL
+						 AND julianday(c.created_at) - julianday(v.created_at) <= ?) AS carted
+			FROM activities v
+			WHERE v.activity_type = ? AND v.product_id IS NOT NULL
+			  AND ` + createdIn("v.created_at") + ` AND v.deleted_at IS NULL)
+		GROUP BY product_id, unavailable`
+
+	rows, err := getReadDB().QueryContext(ctx, query, ActivityTypeAddToCart, availabilityCartWindow.Hours()/24,
+		ActivityTypeProductView, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byProduct := make(map[string]*AvailabilityImpact)
+	for rows.Next() {
+		var productID string
+		var unavailable bool
+		var views, cartAdds int
+		if err := rows.Scan(&productID, &unavailable, &views, &cartAdds); err != nil {
+			return nil, err
+		}
+		p := byProduct[productID]
+		if p == nil {
+			p = &AvailabilityImpact{ProductID: productID}
+			byProduct[productID] = p
+		}
+		if unavailable {
+			p.UnavailableViews, p.UnavailableCartAdds = views, cartAdds
+			p.UnavailableCartAddRate = float64(cartAdds) / float64(views)
+		} else {
+			p.Views, p.CartAdds = views, cartAdds
+			p.CartAddRate = float64(cartAdds) / float64(views)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	impact := make([]AvailabilityImpact, 0, len(byProduct))
+	for _, p := range byProduct {
+		impact = append(impact, *p)
+	}
+	sort.Slice(impact, func(i, j int) bool {
+		a, b := impact[i], impact[j]
+		if a.UnavailableViews != b.UnavailableViews {
+			return a.UnavailableViews > b.UnavailableViews
+		}
+		if a.Views != b.Views {
+			return a.Views > b.Views
+		}
+		return a.ProductID < b.ProductID
+	})
+	return impact, nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetAvailabilityImpact(t *testing.T) {
+	fc := setupTestDB(t)
+	start := fc.Now()
+	view := func(session, product string, unavailable bool) {
+		a := &ActivityLog{SessionID: session, ActivityType: ActivityTypeProductView, ProductID: product}
+		if unavailable {
+			a.Details = `{"product_unavailable":true}`
+		}
+		mustLog(t, a)
+		fc.Advance(time.Second)
+	}
+	cartAdd := func(session, product string) {
+		mustLog(t, &ActivityLog{SessionID: session, ActivityType: ActivityTypeAddToCart, ProductID: product})
+		fc.Advance(time.Second)
+	}
+
+	// Two views of an available product, one followed by a cart add
+	view("s1", "OLJCESPC7Z", false)
+	cartAdd("s1", "OLJCESPC7Z")
+	view("s2", "OLJCESPC7Z", false)
+	// Two views while it failed to load, one retried and added later
+	view("s3", "OLJCESPC7Z", true)
+	view("s4", "OLJCESPC7Z", true)
+	cartAdd("s4", "OLJCESPC7Z")
+	// A cart add of another product or in another session doesn't count
+	view("s5", "66VCHSJNUP", true)
+	cartAdd("s5", "OLJCESPC7Z")
+	cartAdd("s6", "66VCHSJNUP")
+	// Nor does one past the window
+	view("s7", "1YMWWN1N4O", false)
+	fc.Advance(availabilityCartWindow + time.Minute)
+	cartAdd("s7", "1YMWWN1N4O")
+	end := fc.Now().Add(time.Minute)
+
+	got, err := GetAvailabilityImpact(context.Background(), start, end)
+	if err != nil {
+		t.Fatalf("GetAvailabilityImpact() failed: %v", err)
+	}
+	want := []AvailabilityImpact{
+		{ProductID: "OLJCESPC7Z", Views: 2, CartAdds: 1, CartAddRate: 0.5, UnavailableViews: 2, UnavailableCartAdds: 1, UnavailableCartAddRate: 0.5},
+		{ProductID: "66VCHSJNUP", UnavailableViews: 1},
+		{ProductID: "1YMWWN1N4O", Views: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAvailabilityImpact() = %+v, want %+v", got, want)
+	}
+
+	if got, err := GetAvailabilityImpact(context.Background(), end, end.Add(time.Hour)); err != nil || len(got) != 0 {
+		t.Errorf("GetAvailabilityImpact(empty window) = %+v, %v, want no products", got, err)
+	}
+}
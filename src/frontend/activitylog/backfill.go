@@ -0,0 +1,87 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"time"
+)
+
+// backfillBatchSize is the range of activity IDs each backfill statement
+// covers, keeping its write transaction short like purgeBatchSize
+const backfillBatchSize = 1000
+
+// BackfillProgress tells how far a backfill, such as BackfillProducts, got
+type BackfillProgress struct {
+	// LastID is the highest activity ID looked at so far, MaxID the highest
+	// there was when the backfill started
+	LastID int64 `json:"last_id"`
+	MaxID  int64 `json:"max_id"`
+	// Updated is the number of activities filled in
+	Updated int64 `json:"updated"`
+	Done    bool  `json:"done"`
+}
+
+// backfillProductsQuery fills in the product of the product views and cart
+// adds in a range of IDs from their details. Details that aren't JSON or
+// lack a well-formed product ID leave the column NULL.
+func backfillProductsQuery(table string) string {
+	return `
+	UPDATE ` + table + ` SET product_id = batch.product_id
+	FROM (
+		SELECT id, CASE WHEN json_valid(` + detailsJSON + `) THEN json_extract(` + detailsJSON + `, '$.product_id') END AS product_id
+		FROM ` + table + `
+		WHERE id > ? AND id <= ? AND product_id IS NULL AND activity_type IN (?, ?)
+	) AS batch
+	WHERE ` + table + `.id = batch.id
+	  AND typeof(batch.product_id) = 'text'
+	  AND length(batch.product_id) BETWEEN 1 AND 64
+	  AND batch.product_id NOT GLOB '*[^A-Za-z0-9]*'`
+}
+
+// BackfillProducts fills in the product ID column of the activities logged
+// before it existed, in batches, calling progress after each. It stops
+// between batches when ctx is cancelled, and can be run again: activities
+// that already have a product are skipped. Checkouts of that time only
+// recorded the number of items, so they get no line items.
+func BackfillProducts(ctx context.Context, progress func(BackfillProgress)) (BackfillProgress, error) {
+	var p BackfillProgress
+	if err := GetDB().QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM activities").Scan(&p.MaxID); err != nil {
+		return p, err
+	}
+	for p.LastID < p.MaxID {
+		if err := ctx.Err(); err != nil {
+			return p, err
+		}
+		next := min(p.LastID+backfillBatchSize, p.MaxID)
+		start := time.Now()
+		n, err := execEachTable(ctx, GetDB(), backfillProductsQuery, p.LastID, next,
+			ActivityTypeProductView, ActivityTypeAddToCart)
+		observeQuery(ctx, "backfill products", start)
+		if err != nil {
+			return p, err
+		}
+		p.LastID = next
+		p.Updated += n
+		if progress != nil && p.LastID < p.MaxID {
+			progress(p)
+		}
+	}
+	p.Done = true
+	if progress != nil {
+		progress(p)
+	}
+	return p, nil
+}
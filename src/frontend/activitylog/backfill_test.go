@@ -0,0 +1,115 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackfillProducts(t *testing.T) {
+	fc := setupTestDB(t)
+	// Logged before product IDs had their own column
+	for _, a := range []ActivityLog{
+		{SessionID: "view", ActivityType: ActivityTypeProductView, Details: `{"product_id":"OLJCESPC7Z"}`},
+		{SessionID: "add", ActivityType: ActivityTypeAddToCart, Details: `{"product_id":"66VCHSJNUP","quantity":"1"}`},
+		{SessionID: "malformed", ActivityType: ActivityTypeProductView, Details: `{"product_id":`},
+		{SessionID: "missing", ActivityType: ActivityTypeProductView},
+		{SessionID: "invalid", ActivityType: ActivityTypeProductView, Details: `{"product_id":"../etc"}`},
+		{SessionID: "checkout", ActivityType: ActivityTypeCheckout, Details: `{"product_id":"OLJCESPC7Z"}`},
+		{SessionID: "new", ActivityType: ActivityTypeProductView, ProductID: "1YMWWN1N4O", Details: `{"product_id":"OLJCESPC7Z"}`},
+	} {
+		a.CreatedAt = fc.Now()
+		mustLog(t, &a)
+	}
+	// A gap in the IDs makes for several batches
+	if _, err := GetDB().Exec(`INSERT INTO activities (id, session_id, request_id, activity_type, path, method, status_code, user_currency, details, created_at)
+		VALUES (2500, 'late', '', ?, '/product/L9ECAV7KIM', 'GET', 200, 'USD', '{"product_id":"L9ECAV7KIM"}', ?)`,
+		ActivityTypeProductView, fc.Now().UTC()); err != nil {
+		t.Fatalf("inserting the late activity failed: %v", err)
+	}
+
+	// Until backfilled, old rows don't count
+	if n, _ := GetProductViewCount("OLJCESPC7Z", time.Hour); n != 0 {
+		t.Errorf("GetProductViewCount() = %d before the backfill, want 0", n)
+	}
+	productViewCache.entries = make(map[productViewKey]productViewEntry)
+
+	var reports []BackfillProgress
+	p, err := BackfillProducts(context.Background(), func(p BackfillProgress) {
+		reports = append(reports, p)
+	})
+	if err != nil {
+		t.Fatalf("BackfillProducts() failed: %v", err)
+	}
+	if p.Updated != 3 || !p.Done || p.LastID != 2500 || p.MaxID != 2500 {
+		t.Errorf("BackfillProducts() = %+v, want 3 updated up to ID 2500", p)
+	}
+	if len(reports) != 3 || reports[0].LastID != 1000 || reports[1].LastID != 2000 || !reports[2].Done {
+		t.Errorf("progress reports = %+v, want one per batch", reports)
+	}
+
+	got := make(map[string]string)
+	all, err := GetActivities(Filter{}, 0)
+	if err != nil {
+		t.Fatalf("GetActivities() failed: %v", err)
+	}
+	for _, a := range all {
+		got[a.SessionID] = a.ProductID
+	}
+	want := map[string]string{
+		"view": "OLJCESPC7Z", "add": "66VCHSJNUP", "late": "L9ECAV7KIM", "new": "1YMWWN1N4O",
+		"malformed": "", "missing": "", "invalid": "", "checkout": "",
+	}
+	for session, product := range want {
+		if got[session] != product {
+			t.Errorf("%s: product_id = %q, want %q", session, got[session], product)
+		}
+	}
+	if n, _ := GetProductViewCount("OLJCESPC7Z", time.Hour); n != 1 {
+		t.Errorf("GetProductViewCount() = %d after the backfill, want 1", n)
+	}
+
+	// Running it again changes nothing
+	if p, err := BackfillProducts(context.Background(), nil); err != nil || p.Updated != 0 {
+		t.Errorf("second BackfillProducts() = %+v, %v, want nothing updated", p, err)
+	}
+}
+
+func TestActivityItems(t *testing.T) {
+	setupTestDB(t)
+	a := &ActivityLog{SessionID: "session-1", ActivityType: ActivityTypeCheckout, Items: []ActivityItem{
+		{ProductID: "OLJCESPC7Z", Quantity: 2},
+		{ProductID: "66VCHSJNUP", Quantity: 1},
+	}}
+	mustLog(t, a)
+
+	items, err := GetActivityItems(a.ID)
+	if err != nil {
+		t.Fatalf("GetActivityItems() failed: %v", err)
+	}
+	if len(items) != 2 || items[0] != a.Items[0] || items[1] != a.Items[1] {
+		t.Errorf("GetActivityItems() = %+v, want %+v", items, a.Items)
+	}
+
+	// Items go with their activity
+	if _, err := DeleteSession(context.Background(), "session-1"); err != nil {
+		t.Fatalf("DeleteSession() failed: %v", err)
+	}
+	if items, _ := GetActivityItems(a.ID); len(items) != 0 {
+		t.Errorf("%d items left after deleting their activity", len(items))
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned by LogActivity while writes are suspended
+// after repeated failures. The activity is dropped.
+var ErrCircuitOpen = errors.New("activitylog: writes suspended after repeated failures")
+
+// BreakerState is the state of the circuit breaker around activity writes
+type BreakerState int
+
+const (
+	// BreakerClosed lets every write through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen drops every write until the cool-down has passed
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe write through to find out whether
+	// the database recovered
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// writeBreaker stops paying for writes to a database that fails them all.
+// After threshold consecutive failures it opens for cooldown, then lets a
+// probe through: success closes it again, failure re-opens it.
+var writeBreaker = struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+}{
+	threshold: defaultBreakerThreshold,
+	cooldown:  defaultBreakerCooldown,
+}
+
+// ConfigureWriteBreaker sets after how many consecutive write failures
+// writes are suspended, and for how long. Non-positive values keep the
+// defaults. The breaker starts out closed.
+func ConfigureWriteBreaker(threshold int, cooldown time.Duration) {
+	if threshold <= 0 {
+		threshold = defaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	writeBreaker.Lock()
+	defer writeBreaker.Unlock()
+	writeBreaker.threshold = threshold
+	writeBreaker.cooldown = cooldown
+	writeBreaker.failures = 0
+	setBreakerState(BreakerClosed)
+}
+
+// WriteBreakerState returns the current state of the write circuit breaker
+func WriteBreakerState() BreakerState {
+	writeBreaker.Lock()
+	defer writeBreaker.Unlock()
+	return writeBreaker.state
+}
+
+// allowWrite returns ErrCircuitOpen when the write must be dropped
+func allowWrite() error {
+	writeBreaker.Lock()
+	defer writeBreaker.Unlock()
+	switch writeBreaker.state {
+	case BreakerOpen:
+		if Now().Sub(writeBreaker.openedAt) >= writeBreaker.cooldown {
+			// This write is the probe
+			setBreakerState(BreakerHalfOpen)
+			return nil
+		}
+	case BreakerHalfOpen:
+		// A probe is already in flight
+	default:
+		return nil
+	}
+	writesShortCircuited.Add(1)
+	return ErrCircuitOpen
+}
+
+// recordWrite feeds the outcome of an allowed write to the breaker
+func recordWrite(err error) {
+	writeBreaker.Lock()
+	defer writeBreaker.Unlock()
+	if err == nil {
+		writeBreaker.failures = 0
+		setBreakerState(BreakerClosed)
+		return
+	}
+	writeBreaker.failures++
+	if writeBreaker.state == BreakerHalfOpen || writeBreaker.failures >= writeBreaker.threshold {
+		writeBreaker.openedAt = Now()
+		if writeBreaker.state != BreakerOpen {
+			logger.WithError(err).Warnf("suspending activity writes for %v after %d failures",
+				writeBreaker.cooldown, writeBreaker.failures)
+		}
+		setBreakerState(BreakerOpen)
+	}
+}
+
+// setBreakerState changes the state, keeping the gauge in sync. The caller
+// holds writeBreaker's lock.
+func setBreakerState(s BreakerState) {
+	writeBreaker.state = s
+	if s == BreakerClosed {
+		writeBreakerOpen.Set(0)
+	} else {
+		writeBreakerOpen.Set(1)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// breakWrites makes every write fail until the returned function is called
+func breakWrites(t *testing.T) func() {
+	t.Helper()
+	if _, err := GetDB().Exec("ALTER TABLE activities RENAME TO activities_broken"); err != nil {
+		t.Fatalf("breaking writes failed: %v", err)
+	}
+	return func() {
+		if _, err := GetDB().Exec("ALTER TABLE activities_broken RENAME TO activities"); err != nil {
+			t.Fatalf("repairing writes failed: %v", err)
+		}
+	}
+}
+
+func logPageView() error {
+	return LogActivity(&ActivityLog{SessionID: "session-1", RequestID: "request-1", ActivityType: ActivityTypePageView})
+}
+
+func TestWriteBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	setupTestDB(t)
+	ConfigureWriteBreaker(3, time.Minute)
+	repair := breakWrites(t)
+	defer repair()
+
+	for i := 0; i < 3; i++ {
+		if err := logPageView(); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("write #%d: err = %v, want the database error", i+1, err)
+		}
+	}
+	if s := WriteBreakerState(); s != BreakerOpen {
+		t.Fatalf("state = %v after 3 failures, want open", s)
+	}
+	if err := logPageView(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("write while open: err = %v, want ErrCircuitOpen", err)
+	}
+	if got := writeBreakerOpen.Value(); got != 1 {
+		t.Errorf("gauge = %d while open, want 1", got)
+	}
+}
+
+func TestWriteBreakerSuccessResetsFailures(t *testing.T) {
+	setupTestDB(t)
+	ConfigureWriteBreaker(2, time.Minute)
+
+	repair := breakWrites(t)
+	logPageView()
+	repair()
+	if err := logPageView(); err != nil {
+		t.Fatalf("LogActivity() failed: %v", err)
+	}
+	defer breakWrites(t)()
+	logPageView()
+	if s := WriteBreakerState(); s != BreakerClosed {
+		t.Errorf("state = %v after non-consecutive failures, want closed", s)
+	}
+}
+
+func TestWriteBreakerProbesAfterCooldown(t *testing.T) {
+	fc := setupTestDB(t)
+	ConfigureWriteBreaker(1, time.Minute)
+	repair := breakWrites(t)
+	logPageView()
+
+	// Still cooling down
+	fc.Advance(59 * time.Second)
+	if err := logPageView(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("write during cool-down: err = %v, want ErrCircuitOpen", err)
+	}
+
+	// A failing probe re-opens the breaker for another cool-down
+	fc.Advance(time.Second)
+	if err := logPageView(); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("probe: err = %v, want the database error", err)
+	}
+	if s := WriteBreakerState(); s != BreakerOpen {
+		t.Fatalf("state = %v after failed probe, want open", s)
+	}
+	fc.Advance(30 * time.Second)
+	if err := logPageView(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("write after failed probe: err = %v, want ErrCircuitOpen", err)
+	}
+
+	// A successful probe closes it
+	repair()
+	fc.Advance(30 * time.Second)
+	if err := logPageView(); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if s := WriteBreakerState(); s != BreakerClosed {
+		t.Errorf("state = %v after successful probe, want closed", s)
+	}
+	if got := writeBreakerOpen.Value(); got != 0 {
+		t.Errorf("gauge = %d while closed, want 0", got)
+	}
+}
+
+func TestWriteBreakerAllowsSingleProbe(t *testing.T) {
+	fc := setupTestDB(t)
+	ConfigureWriteBreaker(1, time.Minute)
+	defer breakWrites(t)()
+	logPageView()
+	fc.Advance(time.Minute)
+
+	if err := allowWrite(); err != nil {
+		t.Fatalf("probe: allowWrite() = %v, want nil", err)
+	}
+	if err := allowWrite(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second write while probing: allowWrite() = %v, want ErrCircuitOpen", err)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const (
+	cookieCampaign = "shop_campaign"
+	// campaignCookieMaxAge is how long a session keeps the campaign it
+	// arrived through, in seconds
+	campaignCookieMaxAge = 30 * 60
+
+	// CampaignDirect is the campaign of sessions that didn't arrive through
+	// a campaign link
+	CampaignDirect = "(direct)"
+)
+
+// utmParams are the query parameters of campaign links kept in the details
+// of the activity they land on
+var utmParams = []string{"utm_source", "utm_medium", "utm_campaign"}
+
+// utmValue is the format of utm_* values
+var utmValue = regexp.MustCompile(`^[A-Za-z0-9_.+-]{1,64}$`)
+
+// CampaignPerformance is how far the sessions of one campaign got down the
+// DefaultFunnel
+type CampaignPerformance struct {
+	Campaign     string `json:"campaign"`
+	Sessions     int    `json:"sessions"`
+	ProductViews int    `json:"product_views"`
+	CartAdds     int    `json:"cart_adds"`
+	CartViews    int    `json:"cart_views"`
+	Checkouts    int    `json:"checkouts"`
+	// FailedCartAdds are the cart adds that didn't add anything, which
+	// CartAdds leaves out
+	FailedCartAdds int `json:"failed_cart_adds"`
+}
+
+// trackCampaign returns the campaign of a request and the utm_* parameters
+// it landed with. A GET with a valid utm_campaign starts a campaign, which
+// is remembered in a short-lived cookie so the rest of the session is
+// attributed to it; it must be called before the response is written.
+func trackCampaign(w http.ResponseWriter, r *http.Request) (string, map[string]string) {
+	var utm map[string]string
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		for _, param := range utmParams {
+			if v := query.Get(param); v != "" {
+				if utm == nil {
+					utm = make(map[string]string)
+				}
+				utm[param] = v
+			}
+		}
+	}
+
+	if campaign := utm["utm_campaign"]; utmValue.MatchString(campaign) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookieCampaign,
+			Value:    campaign,
+			Path:     "/",
+			MaxAge:   campaignCookieMaxAge,
+			HttpOnly: true,
+		})
+		return campaign, utm
+	}
+	if c, err := r.Cookie(cookieCampaign); err == nil && utmValue.MatchString(c.Value) {
+		return c.Value, utm
+	}
+	return "", utm
+}
+
+// GetCampaignPerformance returns the funnel of every campaign with activity
+// in a given time period, busiest first. Sessions that didn't arrive
+// through a campaign link are reported under CampaignDirect. Failed
+// checkouts aren't counted, failed cart adds are counted apart.
+func GetCampaignPerformance(ctx context.Context, startTime, endTime time.Time) ([]CampaignPerformance, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "campaign performance", time.Now())
+
+	query := `
+		SELECT COALESCE(NULLIF(utm_campaign, ''), ?) AS campaign,
+			   COUNT(DISTINCT session_id) AS sessions,
+			   COALESCE(SUM(activity_type = ?), 0),
+			   COALESCE(SUM(activity_type = ? AND NOT ` + failedAttempt + `), 0),
+			   COALESCE(SUM(activity_type = ?), 0),
+			   COALESCE(SUM(activity_type = ? AND COALESCE(json_extract(` + detailsJSON + `, '$.failed'), 0) = 0), 0),
+			   COALESCE(SUM(activity_type = ? AND ` + failedAttempt + `), 0)
+		FROM activities
+		WHERE ` + createdIn("created_at") + ` AND deleted_at IS NULL
+		GROUP BY campaign
+		ORDER BY sessions DESC, campaign`
+
+	rows, err := getReadDB().QueryContext(ctx, query, CampaignDirect,
+		ActivityTypeProductView, ActivityTypeAddToCart, ActivityTypeViewCart, ActivityTypeCheckout, ActivityTypeAddToCart,
+		startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	campaigns := []CampaignPerformance{}
+	for rows.Next() {
+		var c CampaignPerformance
+		if err := rows.Scan(&c.Campaign, &c.Sessions, &c.ProductViews, &c.CartAdds, &c.CartViews, &c.Checkouts, &c.FailedCartAdds); err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, c)
+	}
+	return campaigns, rows.Err()
+}
@@ -0,0 +1,54 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetCampaignPerformance(t *testing.T) {
+	fc := setupTestDB(t)
+	for _, a := range []ActivityLog{
+		{SessionID: "a", Campaign: "spring", ActivityType: ActivityTypePageView},
+		{SessionID: "a", Campaign: "spring", ActivityType: ActivityTypeProductView},
+		{SessionID: "a", Campaign: "spring", ActivityType: ActivityTypeAddToCart},
+		{SessionID: "a", Campaign: "spring", ActivityType: ActivityTypeViewCart},
+		{SessionID: "a", Campaign: "spring", ActivityType: ActivityTypeCheckout},
+		{SessionID: "b", Campaign: "spring", ActivityType: ActivityTypeProductView},
+		{SessionID: "b", Campaign: "spring", ActivityType: ActivityTypeCheckout, Details: `{"failed":true}`},
+		{SessionID: "c", ActivityType: ActivityTypeProductView},
+		{SessionID: "d", Campaign: "fall", ActivityType: ActivityTypePageView},
+	} {
+		mustLog(t, &a)
+	}
+	// Outside the period
+	mustLog(t, &ActivityLog{SessionID: "e", Campaign: "fall", ActivityType: ActivityTypeCheckout, CreatedAt: fc.Now().Add(-2 * time.Hour)})
+
+	got, err := GetCampaignPerformance(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetCampaignPerformance() failed: %v", err)
+	}
+	want := []CampaignPerformance{
+		{Campaign: "spring", Sessions: 2, ProductViews: 2, CartAdds: 1, CartViews: 1, Checkouts: 1},
+		{Campaign: CampaignDirect, Sessions: 1, ProductViews: 1},
+		{Campaign: "fall", Sessions: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetCampaignPerformance() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultCanaryMinSample is how many activities each version needs before
+// GetCanaryComparison compares them
+const DefaultCanaryMinSample = 100
+
+// significantZ is the z-score beyond which a difference is hinted
+// significant, about 95% confidence both ways
+const significantZ = 1.96
+
+var canaryMinSample = struct {
+	sync.RWMutex
+	n int
+}{n: DefaultCanaryMinSample}
+
+// ConfigureCanaryMinSample sets how many activities each version needs
+// before they are compared. A non-positive value restores the default.
+func ConfigureCanaryMinSample(n int) {
+	if n <= 0 {
+		n = DefaultCanaryMinSample
+	}
+	canaryMinSample.Lock()
+	defer canaryMinSample.Unlock()
+	canaryMinSample.n = n
+}
+
+// CanaryMinSample returns how many activities each version needs before
+// they are compared
+func CanaryMinSample() int {
+	canaryMinSample.RLock()
+	defer canaryMinSample.RUnlock()
+	return canaryMinSample.n
+}
+
+// SampleTooSmallError is returned by GetCanaryComparison when either
+// version served fewer activities than the minimum sample
+type SampleTooSmallError struct {
+	Baseline, Canary, Min int
+}
+
+func (e *SampleTooSmallError) Error() string {
+	return fmt.Sprintf("too few activities to compare: %d for the baseline and %d for the canary, at least %d each",
+		e.Baseline, e.Canary, e.Min)
+}
+
+// Latencies are latency percentiles in milliseconds
+type Latencies struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+// CanaryStats are the traffic and health of one version, overall or for
+// one activity type
+type CanaryStats struct {
+	Activities int `json:"activities"`
+	// PerSession is the activities per session of the version, which
+	// compares versions serving different shares of the traffic
+	PerSession Ratio `json:"per_session"`
+	// ErrorPercent is the percentage of activities that failed with a
+	// server error
+	ErrorPercent Ratio     `json:"error_percent"`
+	LatencyMs    Latencies `json:"latency_ms"`
+}
+
+// CanaryVersion is the stats of one version over the window
+type CanaryVersion struct {
+	Version  string                 `json:"version"`
+	Sessions int                    `json:"sessions"`
+	Overall  CanaryStats            `json:"overall"`
+	Types    map[string]CanaryStats `json:"types"`
+}
+
+// Significance hints whether a difference is more than noise: Z is the
+// z-score of the difference, Significant whether it is beyond about 95%
+// confidence. It is a hint, not a verdict: it assumes independent
+// activities, which those of one session are not.
+type Significance struct {
+	Z           float64 `json:"z"`
+	Significant bool    `json:"significant"`
+}
+
+// CanaryDelta is how the canary differs from the baseline. The relative
+// deltas are the change over the baseline's value, 0.1 for 10% more, and
+// nil when the baseline's value is 0.
+type CanaryDelta struct {
+	PerSession             *float64     `json:"per_session"`
+	PerSessionSignificance Significance `json:"per_session_significance"`
+	// ErrorPercentPoints is the difference of the error percentages,
+	// which is meaningful where a relative change of a tiny rate isn't
+	ErrorPercentPoints       float64      `json:"error_percent_points"`
+	ErrorPercentSignificance Significance `json:"error_percent_significance"`
+	LatencyP50               *float64     `json:"latency_p50"`
+	LatencyP90               *float64     `json:"latency_p90"`
+	LatencyP99               *float64     `json:"latency_p99"`
+}
+
+// CanaryComparison compares the canary version with the baseline over a
+// window
+type CanaryComparison struct {
+	Start     time.Time              `json:"start"`
+	End       time.Time              `json:"end"`
+	MinSample int                    `json:"min_sample"`
+	Baseline  CanaryVersion          `json:"baseline"`
+	Canary    CanaryVersion          `json:"canary"`
+	Overall   CanaryDelta            `json:"overall"`
+	Types     map[string]CanaryDelta `json:"types"`
+}
+
+// canarySample is what is gathered of a version's activities to compute
+// its stats
+type canarySample struct {
+	activities, errors int
+	latencies          []float64
+}
+
+func (s *canarySample) add(failed bool, latencyMs float64) {
+	s.activities++
+	if failed {
+		s.errors++
+	}
+	s.latencies = append(s.latencies, latencyMs)
+}
+
+func (s *canarySample) stats(sessions int) CanaryStats {
+	stats := CanaryStats{
+		Activities:   s.activities,
+		PerSession:   newRatio(s.activities, sessions, 1),
+		ErrorPercent: newRatio(s.errors, s.activities, 100),
+	}
+	if len(s.latencies) > 0 {
+		sort.Float64s(s.latencies)
+		stats.LatencyMs = Latencies{
+			P50: percentile(s.latencies, 50),
+			P90: percentile(s.latencies, 90),
+			P99: percentile(s.latencies, 99),
+		}
+	}
+	return stats
+}
+
+// GetCanaryComparison compares the activities the canary version served
+// in [startTime, endTime) with those of the baseline version: per type and
+// overall, their rate per session, error rate and latency percentiles,
+// and how the canary's differ. It returns a *SampleTooSmallError when
+// either version served fewer than CanaryMinSample activities.
+func GetCanaryComparison(ctx context.Context, baseline, canary string, startTime, endTime time.Time) (*CanaryComparison, error) {
+	if baseline == "" || canary == "" || baseline == canary {
+		return nil, fmt.Errorf("need two different versions to compare, got %q and %q", baseline, canary)
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "canary comparison", time.Now())
+
+	query := `
+		SELECT version, session_id, activity_type, status_code >= 500, latency_ms
+		FROM activities
+		WHERE version IN (?, ?) AND ` + createdIn("created_at") + ` AND deleted_at IS NULL`
+	rows, err := getReadDB().QueryContext(ctx, query, baseline, canary, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type side struct {
+		sessions map[string]bool
+		overall  canarySample
+		types    map[string]*canarySample
+	}
+	sides := map[string]*side{
+		baseline: {sessions: make(map[string]bool), types: make(map[string]*canarySample)},
+		canary:   {sessions: make(map[string]bool), types: make(map[string]*canarySample)},
+	}
+	for rows.Next() {
+		var version, sessionID, activityType string
+		var failed bool
+		var latencyMs float64
+		if err := rows.Scan(&version, &sessionID, &activityType, &failed, &latencyMs); err != nil {
+			return nil, err
+		}
+		s := sides[version]
+		s.sessions[sessionID] = true
+		s.overall.add(failed, latencyMs)
+		if s.types[activityType] == nil {
+			s.types[activityType] = &canarySample{}
+		}
+		s.types[activityType].add(failed, latencyMs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	min := CanaryMinSample()
+	if b, c := sides[baseline].overall.activities, sides[canary].overall.activities; b < min || c < min {
+		return nil, &SampleTooSmallError{Baseline: b, Canary: c, Min: min}
+	}
+	version := func(name string) CanaryVersion {
+		s := sides[name]
+		v := CanaryVersion{
+			Version:  name,
+			Sessions: len(s.sessions),
+			Overall:  s.overall.stats(len(s.sessions)),
+			Types:    make(map[string]CanaryStats, len(s.types)),
+		}
+		for activityType, sample := range s.types {
+			v.Types[activityType] = sample.stats(len(s.sessions))
+		}
+		return v
+	}
+	comparison := &CanaryComparison{
+		Start:     startTime.UTC(),
+		End:       endTime.UTC(),
+		MinSample: min,
+		Baseline:  version(baseline),
+		Canary:    version(canary),
+		Types:     make(map[string]CanaryDelta),
+	}
+	comparison.Overall = canaryDelta(comparison.Baseline.Overall, comparison.Canary.Overall)
+	for activityType := range comparison.Baseline.Types {
+		comparison.Types[activityType] = canaryDelta(comparison.Baseline.Types[activityType], comparison.Canary.Types[activityType])
+	}
+	for activityType := range comparison.Canary.Types {
+		if _, ok := comparison.Types[activityType]; !ok {
+			comparison.Types[activityType] = canaryDelta(CanaryStats{PerSession: newRatio(0, comparison.Baseline.Sessions, 1)}, comparison.Canary.Types[activityType])
+		}
+	}
+	return comparison, nil
+}
+
+// canaryDelta is how the canary's stats differ from the baseline's
+func canaryDelta(baseline, canary CanaryStats) CanaryDelta {
+	return CanaryDelta{
+		PerSession:               relativeChange(baseline.PerSession.Value, canary.PerSession.Value),
+		PerSessionSignificance:   rateSignificance(baseline.PerSession, canary.PerSession),
+		ErrorPercentPoints:       canary.ErrorPercent.Value - baseline.ErrorPercent.Value,
+		ErrorPercentSignificance: proportionSignificance(baseline.ErrorPercent, canary.ErrorPercent),
+		LatencyP50:               relativeChange(baseline.LatencyMs.P50, canary.LatencyMs.P50),
+		LatencyP90:               relativeChange(baseline.LatencyMs.P90, canary.LatencyMs.P90),
+		LatencyP99:               relativeChange(baseline.LatencyMs.P99, canary.LatencyMs.P99),
+	}
+}
+
+// relativeChange is the change from baseline to canary over baseline, nil
+// when baseline is 0
+func relativeChange(baseline, canary float64) *float64 {
+	if baseline == 0 {
+		return nil
+	}
+	change := (canary - baseline) / baseline
+	return &change
+}
+
+// rateSignificance compares two counts per session as Poisson rates
+func rateSignificance(baseline, canary Ratio) Significance {
+	if baseline.Denominator == 0 || canary.Denominator == 0 || baseline.Numerator+canary.Numerator == 0 {
+		return Significance{}
+	}
+	b, c := float64(baseline.Denominator), float64(canary.Denominator)
+	rb, rc := float64(baseline.Numerator)/b, float64(canary.Numerator)/c
+	return significance((rc - rb) / math.Sqrt(float64(baseline.Numerator)/(b*b)+float64(canary.Numerator)/(c*c)))
+}
+
+// proportionSignificance compares two proportions with a two-proportion
+// z-test
+func proportionSignificance(baseline, canary Ratio) Significance {
+	n := float64(baseline.Denominator + canary.Denominator)
+	if baseline.Denominator == 0 || canary.Denominator == 0 {
+		return Significance{}
+	}
+	pooled := float64(baseline.Numerator+canary.Numerator) / n
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(baseline.Denominator) + 1/float64(canary.Denominator)))
+	if se == 0 {
+		return Significance{}
+	}
+	pb := float64(baseline.Numerator) / float64(baseline.Denominator)
+	pc := float64(canary.Numerator) / float64(canary.Denominator)
+	return significance((pc - pb) / se)
+}
+
+func significance(z float64) Significance {
+	return Significance{Z: math.Round(z*100) / 100, Significant: math.Abs(z) >= significantZ}
+}
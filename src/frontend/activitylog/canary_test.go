@@ -0,0 +1,103 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// logVersion logs activities of a version, the given share of them failed
+// with a server error
+func logVersion(t *testing.T, version, activityType string, n, failed int, latency time.Duration) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		status := 200
+		if i < failed {
+			status = 500
+		}
+		mustLog(t, &ActivityLog{
+			SessionID:    fmt.Sprintf("%s-%d", version, i%10),
+			ActivityType: activityType,
+			Version:      version,
+			StatusCode:   status,
+			LatencyMs:    latency.Milliseconds() + int64(i%10),
+		})
+	}
+}
+
+func TestCanaryComparison(t *testing.T) {
+	fc := setupTestDB(t)
+	ConfigureCanaryMinSample(50)
+	defer ConfigureCanaryMinSample(0)
+	logVersion(t, "v1", ActivityTypeProductView, 400, 4, 100*time.Millisecond)
+	logVersion(t, "v1", ActivityTypeCheckout, 20, 0, 300*time.Millisecond)
+	logVersion(t, "v2", ActivityTypeProductView, 200, 40, 150*time.Millisecond)
+	logVersion(t, "v2", ActivityTypeAddToCart, 10, 0, 50*time.Millisecond)
+	// Other versions aren't compared
+	logVersion(t, "v0", ActivityTypeProductView, 100, 100, time.Second)
+
+	got, err := GetCanaryComparison(context.Background(), "v1", "v2", fc.Now().Add(-time.Hour), fc.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("GetCanaryComparison() failed: %v", err)
+	}
+	if got.Baseline.Sessions != 10 || got.Baseline.Overall.Activities != 420 || got.Canary.Overall.Activities != 210 {
+		t.Errorf("samples = %d sessions, %d and %d activities, want 10, 420 and 210",
+			got.Baseline.Sessions, got.Baseline.Overall.Activities, got.Canary.Overall.Activities)
+	}
+	views := got.Types[ActivityTypeProductView]
+	if views.PerSession == nil || *views.PerSession != -0.5 {
+		t.Errorf("product views per session changed by %v, want -0.5", views.PerSession)
+	}
+	if !views.PerSessionSignificance.Significant || views.PerSessionSignificance.Z >= 0 {
+		t.Errorf("half the product views hinted %+v, want a significant drop", views.PerSessionSignificance)
+	}
+	if views.ErrorPercentPoints != 19 || !views.ErrorPercentSignificance.Significant {
+		t.Errorf("product view errors = %v points (%+v), want a significant 19 points more",
+			views.ErrorPercentPoints, views.ErrorPercentSignificance)
+	}
+	if got.Canary.Types[ActivityTypeProductView].LatencyMs.P50 != 154 || views.LatencyP50 == nil || *views.LatencyP50 <= 0 {
+		t.Errorf("canary product view latency %+v (%v), want a slower p50 of 154ms",
+			got.Canary.Types[ActivityTypeProductView].LatencyMs, views.LatencyP50)
+	}
+	// A type only one version served
+	if d := got.Types[ActivityTypeCheckout]; d.PerSession == nil || *d.PerSession != -1 {
+		t.Errorf("checkouts changed by %v, want -1", d.PerSession)
+	}
+	if d := got.Types[ActivityTypeAddToCart]; d.PerSession != nil || d.PerSessionSignificance.Z <= 0 {
+		t.Errorf("cart adds, new in the canary, = %+v, want no relative change and a positive z", d)
+	}
+	if _, ok := got.Baseline.Types[ActivityTypeAddToCart]; ok {
+		t.Error("baseline stats include a type it never served")
+	}
+}
+
+func TestCanaryComparisonRefusesSmallSamples(t *testing.T) {
+	fc := setupTestDB(t)
+	logVersion(t, "v1", ActivityTypePageView, DefaultCanaryMinSample, 0, time.Millisecond)
+	logVersion(t, "v2", ActivityTypePageView, DefaultCanaryMinSample-1, 0, time.Millisecond)
+
+	_, err := GetCanaryComparison(context.Background(), "v1", "v2", fc.Now().Add(-time.Hour), fc.Now().Add(time.Second))
+	var small *SampleTooSmallError
+	if !errors.As(err, &small) || small.Baseline != DefaultCanaryMinSample || small.Canary != DefaultCanaryMinSample-1 {
+		t.Errorf("GetCanaryComparison() = %v, want the sample sizes refused", err)
+	}
+	if _, err := GetCanaryComparison(context.Background(), "v1", "v1", fc.Now().Add(-time.Hour), fc.Now()); err == nil {
+		t.Error("GetCanaryComparison() compared a version with itself")
+	}
+}
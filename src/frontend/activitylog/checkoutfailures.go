@@ -0,0 +1,79 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"time"
+)
+
+// Reasons a checkout failed for, recorded under the failure_reason detail
+// of failed checkouts
+const (
+	FailurePaymentDeclined = "payment_declined"
+	FailureShipping        = "shipping_failure"
+	FailureCartUnavailable = "cart_unavailable"
+	FailureValidation      = "validation_error"
+	// FailureOther is any other reason, with the gRPC code the checkout
+	// service returned under the failure_code detail
+	FailureOther = "other"
+)
+
+// CheckoutFailureCount is the number of checkouts that failed for a reason.
+// Code is only set for FailureOther, splitting it by gRPC code.
+type CheckoutFailureCount struct {
+	Reason string `json:"reason"`
+	Code   string `json:"code,omitempty"`
+	Count  int    `json:"count"`
+}
+
+// GetCheckoutFailureReasons returns the number of failed checkouts per
+// failure reason in a given time period, most frequent first. Failures
+// logged before reasons were recorded count as FailureOther without a code.
+func GetCheckoutFailureReasons(ctx context.Context, startTime, endTime time.Time) ([]CheckoutFailureCount, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "checkout failure reasons", time.Now())
+
+	query := `
+		SELECT reason, CASE WHEN reason = ? THEN code ELSE '' END AS reason_code, COUNT(*) AS count
+		FROM (
+			SELECT COALESCE(json_extract(` + detailsJSON + `, '$.failure_reason'), ?) AS reason,
+				   COALESCE(json_extract(` + detailsJSON + `, '$.failure_code'), '') AS code
+			FROM activities
+			WHERE activity_type = ? AND ` + createdIn("created_at") + ` AND deleted_at IS NULL
+			  AND COALESCE(json_extract(` + detailsJSON + `, '$.failed'), 0))
+		GROUP BY reason, reason_code
+		ORDER BY count DESC, reason, reason_code`
+
+	rows, err := getReadDB().QueryContext(ctx, query, FailureOther, FailureOther, ActivityTypeCheckout, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []CheckoutFailureCount{}
+	for rows.Next() {
+		var c CheckoutFailureCount
+		if err := rows.Scan(&c.Reason, &c.Code, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
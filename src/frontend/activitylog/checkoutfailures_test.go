@@ -0,0 +1,58 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetCheckoutFailureReasons(t *testing.T) {
+	fc := setupTestDB(t)
+	for _, details := range []string{
+		`{"failed":true,"failure_reason":"payment_declined","failure_code":"Internal"}`,
+		`{"failed":true,"failure_reason":"payment_declined","failure_code":"Internal"}`,
+		`{"failed":true,"failure_reason":"validation_error"}`,
+		`{"failed":true,"failure_reason":"other","failure_code":"DeadlineExceeded"}`,
+		`{"failed":true,"failure_reason":"other","failure_code":"Unavailable"}`,
+		`{"failed":true,"failure_reason":"other","failure_code":"Unavailable"}`,
+		// Logged before reasons were recorded
+		`{"failed":true}`,
+		// Successful checkouts don't count
+		`{"country":"FR"}`,
+		``,
+	} {
+		mustLog(t, &ActivityLog{SessionID: "session-1", ActivityType: ActivityTypeCheckout, Details: details})
+	}
+	// Nor do other activities
+	mustLog(t, &ActivityLog{SessionID: "session-1", ActivityType: ActivityTypeAddToCart, Details: `{"failed":true,"failure_reason":"payment_declined"}`})
+
+	got, err := GetCheckoutFailureReasons(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetCheckoutFailureReasons() failed: %v", err)
+	}
+	want := []CheckoutFailureCount{
+		{Reason: FailureOther, Code: "Unavailable", Count: 2},
+		{Reason: FailurePaymentDeclined, Count: 2},
+		{Reason: FailureOther, Count: 1},
+		{Reason: FailureOther, Code: "DeadlineExceeded", Count: 1},
+		{Reason: FailureValidation, Count: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetCheckoutFailureReasons() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,405 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client is a typed client for the frontend's activity HTTP API. It
+// decodes into the same types the frontend encodes, so tools don't need to
+// hand-roll requests against /activities.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+// maxEventSize bounds a single server-sent event
+const maxEventSize = 1 << 20
+
+// versionTTL is how long the features of a frontend are cached, so that a
+// rollout is noticed without asking before every call
+const versionTTL = 5 * time.Minute
+
+// Client calls the activity API of a frontend.
+type Client struct {
+	// BaseURL is the frontend's URL including its base path, for example
+	// http://frontend:8080.
+	BaseURL string
+	// HTTPClient sends the requests; http.DefaultClient when nil. Stream
+	// holds its request open, so a client Timeout also ends the stream.
+	HTTPClient *http.Client
+	// AdminToken is sent as a bearer token, for the admin endpoints such
+	// as the one Snapshot calls. Other endpoints don't need it.
+	AdminToken string
+
+	mu        sync.Mutex
+	version   *activitylog.ServerVersion
+	fetchedAt time.Time
+}
+
+// Filter narrows down the activities returned. Zero-valued fields don't
+// filter.
+type Filter struct {
+	// Start and End bound when the activities happened to [Start, End).
+	Start time.Time
+	End   time.Time
+	// Types restricts the activity types, TypesNot excludes some.
+	Types    []string
+	TypesNot []string
+	// SessionIDs restricts the activities to any of several sessions.
+	SessionIDs []string
+	// UserID restricts the activities to a signed-in user, across all of
+	// their sessions.
+	UserID string
+	// PathPrefix restricts the activities to paths starting with it.
+	PathPrefix string
+	// StatusClasses restricts the response status classes, 4 standing for
+	// 4xx and so on.
+	StatusClasses []int
+	// ResponseClasses restricts the response classes, such as
+	// activitylog.ResponseClassRedirect. Requires the response_class
+	// feature.
+	ResponseClasses []string
+	// Source restricts the activities to a traffic source.
+	Source string
+	// Experiment and Variant restrict the activities to sessions assigned
+	// to the given variant of an experiment. Variant requires Experiment.
+	Experiment string
+	Variant    string
+	// Version restricts the activities to those served by a frontend
+	// version.
+	Version string
+	// Tags restrict the activities to those tagged with every given value
+	// of a tag cookie.
+	Tags map[string]string
+	// Details restrict the activities to those with every given value
+	// under a top-level key of their details, at most
+	// activitylog.MaxDetailFilters. Requires the detail_filters feature.
+	Details map[string]string
+	// Limit caps the number of activities, leaving the default of the
+	// endpoint when zero.
+	Limit int
+	// BeforeID restricts the activities to those logged before the one
+	// with this ID: the ID of the last activity of a page gets the next
+	// one. Requires the cursor feature.
+	BeforeID int64
+}
+
+// needsFiltersV2 tells whether the filter uses more than the types, time
+// range and limit that every frontend understands
+func (f Filter) needsFiltersV2() bool {
+	return len(f.TypesNot) > 0 || len(f.SessionIDs) > 0 || f.UserID != "" || f.PathPrefix != "" ||
+		len(f.StatusClasses) > 0 || f.Source != "" || f.Experiment != "" || f.Variant != "" ||
+		f.Version != "" || len(f.Tags) > 0
+}
+
+// values encodes the filter as query parameters
+func (f Filter) values() url.Values {
+	v := url.Values{}
+	if !f.Start.IsZero() {
+		v.Set("start", f.Start.Format(time.RFC3339))
+	}
+	if !f.End.IsZero() {
+		v.Set("end", f.End.Format(time.RFC3339))
+	}
+	for _, t := range f.Types {
+		v.Add("type", t)
+	}
+	for _, t := range f.TypesNot {
+		v.Add("type!", t)
+	}
+	for _, id := range f.SessionIDs {
+		v.Add("sessions", id)
+	}
+	if f.UserID != "" {
+		v.Set("user_id", f.UserID)
+	}
+	if f.PathPrefix != "" {
+		v.Set("path_prefix", f.PathPrefix)
+	}
+	for _, c := range f.StatusClasses {
+		v.Add("status_class", strconv.Itoa(c))
+	}
+	for _, c := range f.ResponseClasses {
+		v.Add("response_class", c)
+	}
+	if f.Source != "" {
+		v.Set("source", f.Source)
+	}
+	if f.Experiment != "" {
+		v.Set("experiment", f.Experiment)
+	}
+	if f.Variant != "" {
+		v.Set("variant", f.Variant)
+	}
+	if f.Version != "" {
+		v.Set("version", f.Version)
+	}
+	for name, value := range f.Tags {
+		v.Add("tag", name+":"+value)
+	}
+	for key, value := range f.Details {
+		v.Set("detail."+key, value)
+	}
+	if f.Limit > 0 {
+		v.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if f.BeforeID > 0 {
+		v.Set("before_id", strconv.FormatInt(f.BeforeID, 10))
+	}
+	return v
+}
+
+// Error is a failed request, as described by the API's error envelope.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("activity API: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// ErrUnsupportedFeature is returned instead of calling the frontend when a
+// call needs a feature it doesn't advertise, such as filters an older
+// frontend would reject or ignore.
+type ErrUnsupportedFeature struct {
+	Feature string
+}
+
+func (e *ErrUnsupportedFeature) Error() string {
+	return fmt.Sprintf("activity API: the frontend doesn't support %s", e.Feature)
+}
+
+// Version returns the schema version, API version and features of the
+// frontend, cached for a few minutes. Frontends that predate
+// /activities/version are reported with no features.
+func (c *Client) Version(ctx context.Context) (activitylog.ServerVersion, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.version != nil && time.Since(c.fetchedAt) < versionTTL {
+		return *c.version, nil
+	}
+	var v activitylog.ServerVersion
+	err := c.getJSON(ctx, "/activities/version", nil, &v)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		v, err = activitylog.ServerVersion{}, nil
+	}
+	if err != nil {
+		return v, err
+	}
+	c.version, c.fetchedAt = &v, time.Now()
+	return v, nil
+}
+
+// Require returns an *ErrUnsupportedFeature unless the frontend advertises
+// the feature
+func (c *Client) Require(ctx context.Context, feature string) error {
+	v, err := c.Version(ctx)
+	if err != nil {
+		return err
+	}
+	for _, f := range v.Features {
+		if f == feature {
+			return nil
+		}
+	}
+	return &ErrUnsupportedFeature{Feature: feature}
+}
+
+// requireFilter checks that the frontend understands every field of f
+func (c *Client) requireFilter(ctx context.Context, f Filter) error {
+	if len(f.ResponseClasses) > 0 {
+		if err := c.Require(ctx, activitylog.FeatureResponseClass); err != nil {
+			return err
+		}
+	}
+	if len(f.Details) > 0 {
+		if err := c.Require(ctx, activitylog.FeatureDetailFilters); err != nil {
+			return err
+		}
+	}
+	if f.BeforeID > 0 {
+		if err := c.Require(ctx, activitylog.FeatureCursor); err != nil {
+			return err
+		}
+	}
+	if !f.needsFiltersV2() {
+		return nil
+	}
+	return c.Require(ctx, activitylog.FeatureFiltersV2)
+}
+
+// Recent returns the most recently logged activities across all sessions
+func (c *Client) Recent(ctx context.Context, f Filter) ([]activitylog.ActivityLog, error) {
+	if err := c.requireFilter(ctx, f); err != nil {
+		return nil, err
+	}
+	var activities []activitylog.ActivityLog
+	err := c.getJSON(ctx, "/activities", f.values(), &activities)
+	return activities, err
+}
+
+// BySession returns the most recently logged activities of a session
+func (c *Client) BySession(ctx context.Context, sessionID string, f Filter) ([]activitylog.ActivityLog, error) {
+	if err := c.requireFilter(ctx, f); err != nil {
+		return nil, err
+	}
+	v := f.values()
+	v.Set("session_id", sessionID)
+	var activities []activitylog.ActivityLog
+	err := c.getJSON(ctx, "/activities/session", v, &activities)
+	return activities, err
+}
+
+// Stats returns the number of activities per type between start and end
+func (c *Client) Stats(ctx context.Context, start, end time.Time) (map[string]int, error) {
+	v := Filter{Start: start, End: end}.values()
+	var stats map[string]int
+	err := c.getJSON(ctx, "/activities/stats", v, &stats)
+	return stats, err
+}
+
+// Snapshot downloads a consistent copy of the activities database to w and
+// returns its size. It needs the AdminToken. A frontend takes one snapshot
+// at a time, others fail with a 409 *Error meanwhile.
+func (c *Client) Snapshot(ctx context.Context, w io.Writer) (int64, error) {
+	resp, err := c.get(ctx, "/activities/db/snapshot", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(w, resp.Body)
+	if err == nil && resp.ContentLength >= 0 && n != resp.ContentLength {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Stream calls handler with every activity logged from now on until ctx is
+// done, in which case it returns ctx's error, or the stream breaks off.
+func (c *Client) Stream(ctx context.Context, handler func(activitylog.ActivityLog)) error {
+	if err := c.Require(ctx, activitylog.FeatureSSE); err != nil {
+		return err
+	}
+	resp, err := c.get(ctx, "/activities/stream", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 4096), maxEventSize)
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// A blank line dispatches the event
+			if data.Len() == 0 {
+				continue
+			}
+			var activity activitylog.ActivityLog
+			if err := json.Unmarshal(data.Bytes(), &activity); err != nil {
+				return fmt.Errorf("activity API: decoding streamed activity: %w", err)
+			}
+			data.Reset()
+			handler(activity)
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// Comments, ids and other fields carry nothing we need
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// getJSON decodes the JSON response to a GET request into v
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	resp, err := c.get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("activity API: decoding %s: %w", path, err)
+	}
+	return nil
+}
+
+// get sends a GET request and turns error responses into *Error
+func (c *Client) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := strings.TrimSuffix(c.BaseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AdminToken)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+	return resp, nil
+}
+
+// decodeError reads the error envelope of a failed response, falling back to
+// the status text for responses that don't come from the API itself, such
+// as a proxy's.
+func decodeError(resp *http.Response) error {
+	var envelope struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	e := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxEventSize))
+	if err == nil && json.Unmarshal(body, &envelope) == nil && envelope.Error.Message != "" {
+		e.Message = envelope.Error.Message
+	}
+	return e
+}
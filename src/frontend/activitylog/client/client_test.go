@@ -0,0 +1,353 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+var sampleActivities = []activitylog.ActivityLog{
+	{
+		ID:              2,
+		SessionID:       "5c9c7a4e-1f2b-4e55-9d0e-2b1f3c4d5e6f",
+		RequestID:       "0d6f8b7a-3c2e-4f1a-8b9c-7d6e5f4a3b2c",
+		ParentRequestID: "9a8b7c6d-5e4f-4a3b-2c1d-0e9f8a7b6c5d",
+		ActivityType:    activitylog.ActivityTypeAddToCart,
+		Path:            "/cart",
+		Method:          http.MethodPost,
+		StatusCode:      http.StatusFound,
+		UserCurrency:    "EUR",
+		Source:          activitylog.SourceWeb,
+		LatencyMs:       42,
+		Details:         `{"product_id":"OLJCESPC7Z","quantity":"2"}`,
+		CreatedAt:       time.Date(2025, 6, 1, 12, 30, 0, 0, time.UTC),
+	},
+	{
+		ID:           1,
+		SessionID:    "5c9c7a4e-1f2b-4e55-9d0e-2b1f3c4d5e6f",
+		RequestID:    "9a8b7c6d-5e4f-4a3b-2c1d-0e9f8a7b6c5d",
+		ActivityType: activitylog.ActivityTypePageView,
+		Path:         "/",
+		Method:       http.MethodGet,
+		StatusCode:   http.StatusOK,
+		UserCurrency: "USD",
+		CreatedAt:    time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+	},
+}
+
+var sampleVersion = activitylog.ServerVersion{
+	SchemaVersion: 42,
+	APIVersion:    activitylog.APIVersion,
+	Features: []string{
+		activitylog.FeatureExport,
+		activitylog.FeatureFiltersV2,
+		activitylog.FeatureGroupBy,
+		activitylog.FeatureSessionSummaries,
+		activitylog.FeatureSSE,
+		activitylog.FeatureStatsAsOf,
+	},
+}
+
+var sampleStats = map[string]int{
+	activitylog.ActivityTypePageView:  120,
+	activitylog.ActivityTypeAddToCart: 7,
+}
+
+// encode encodes v the way the frontend's handlers do
+func encode(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		t.Fatalf("encoding %T failed: %v", v, err)
+	}
+	return buf.Bytes()
+}
+
+// golden compares got against testdata/name, or rewrites it with -update
+func golden(t *testing.T, name string, got []byte) []byte {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("updating %s failed: %v", path, err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s failed: %v", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("wire shape changed, %s:\ngot:  %s\nwant: %s", path, got, want)
+	}
+	return want
+}
+
+// serveFile answers /activities/version with version.golden and every other
+// request with the golden file, and records the last of those
+func serveFile(t *testing.T, name string, code int, last **http.Request) *Client {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("reading %s failed: %v", name, err)
+	}
+	version, err := os.ReadFile(filepath.Join("testdata", "version.golden"))
+	if err != nil {
+		t.Fatalf("reading version.golden failed: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/activities/version" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(version)
+			return
+		}
+		if last != nil {
+			*last = r
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return &Client{BaseURL: srv.URL + "/"}
+}
+
+func TestActivitiesWireShape(t *testing.T) {
+	golden(t, "activities.golden", encode(t, sampleActivities))
+	golden(t, "stats.golden", encode(t, sampleStats))
+	golden(t, "version.golden", encode(t, sampleVersion))
+}
+
+func TestVersionIsCached(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		json.NewEncoder(w).Encode(sampleVersion)
+	}))
+	defer srv.Close()
+
+	c := &Client{BaseURL: srv.URL}
+	for i := 0; i < 3; i++ {
+		got, err := c.Version(context.Background())
+		if err != nil {
+			t.Fatalf("Version() failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, sampleVersion) {
+			t.Errorf("Version() = %+v, want %+v", got, sampleVersion)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("fetched the version %d times, want once", calls)
+	}
+}
+
+// TestUnsupportedFeatures checks that calls needing what an older frontend
+// lacks fail before reaching it
+func TestUnsupportedFeatures(t *testing.T) {
+	for name, version := range map[string]http.HandlerFunc{
+		"without the features": func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(activitylog.ServerVersion{SchemaVersion: 3, APIVersion: 1, Features: []string{}})
+		},
+		"predating /activities/version": func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var called []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/activities/version" {
+					version(w, r)
+					return
+				}
+				called = append(called, r.URL.Path)
+				w.Write([]byte("[]"))
+			}))
+			defer srv.Close()
+			c := &Client{BaseURL: srv.URL}
+			ctx := context.Background()
+
+			var unsupported *ErrUnsupportedFeature
+			if _, err := c.Recent(ctx, Filter{Source: activitylog.SourceWeb}); !errors.As(err, &unsupported) || unsupported.Feature != "filters_v2" {
+				t.Errorf("Recent() with a source error = %v, want filters_v2 unsupported", err)
+			}
+			if _, err := c.Recent(ctx, Filter{ResponseClasses: []string{activitylog.ResponseClassRedirect}}); !errors.As(err, &unsupported) || unsupported.Feature != "response_class" {
+				t.Errorf("Recent() with a response class error = %v, want response_class unsupported", err)
+			}
+			if _, err := c.Recent(ctx, Filter{Details: map[string]string{"product_id": "OLJCESPC7Z"}}); !errors.As(err, &unsupported) || unsupported.Feature != "detail_filters" {
+				t.Errorf("Recent() with a detail error = %v, want detail_filters unsupported", err)
+			}
+			if _, err := c.Recent(ctx, Filter{BeforeID: 42}); !errors.As(err, &unsupported) || unsupported.Feature != "cursor" {
+				t.Errorf("Recent() with a cursor error = %v, want cursor unsupported", err)
+			}
+			if err := c.Stream(ctx, func(activitylog.ActivityLog) {}); !errors.As(err, &unsupported) || unsupported.Feature != "sse" {
+				t.Errorf("Stream() error = %v, want sse unsupported", err)
+			}
+			if len(called) != 0 {
+				t.Errorf("called %v, want no calls needing missing features", called)
+			}
+
+			// Filters every frontend understands need no feature
+			if _, err := c.Recent(ctx, Filter{Types: []string{activitylog.ActivityTypePageView}, Limit: 5}); err != nil {
+				t.Errorf("Recent() with basic filters failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestRecent(t *testing.T) {
+	var req *http.Request
+	c := serveFile(t, "activities.golden", http.StatusOK, &req)
+	f := Filter{
+		Start:  time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+		Types:  []string{activitylog.ActivityTypePageView, activitylog.ActivityTypeAddToCart},
+		Source: activitylog.SourceWeb,
+		Limit:  10,
+	}
+
+	got, err := c.Recent(context.Background(), f)
+	if err != nil {
+		t.Fatalf("Recent() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, sampleActivities) {
+		t.Errorf("Recent() = %+v, want %+v", got, sampleActivities)
+	}
+	if want := "/activities?limit=10&source=web&start=2025-06-01T00%3A00%3A00Z&type=page_view&type=add_to_cart"; req.URL.String() != want {
+		t.Errorf("requested %s, want %s", req.URL, want)
+	}
+}
+
+func TestBySession(t *testing.T) {
+	var req *http.Request
+	c := serveFile(t, "activities.golden", http.StatusOK, &req)
+
+	got, err := c.BySession(context.Background(), "session-1", Filter{})
+	if err != nil {
+		t.Fatalf("BySession() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, sampleActivities) {
+		t.Errorf("BySession() = %+v, want %+v", got, sampleActivities)
+	}
+	if want := "/activities/session?session_id=session-1"; req.URL.String() != want {
+		t.Errorf("requested %s, want %s", req.URL, want)
+	}
+}
+
+func TestStats(t *testing.T) {
+	c := serveFile(t, "stats.golden", http.StatusOK, nil)
+	got, err := c.Stats(context.Background(), time.Now().Add(-time.Hour), time.Now())
+	if err != nil {
+		t.Fatalf("Stats() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, sampleStats) {
+		t.Errorf("Stats() = %v, want %v", got, sampleStats)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	c := serveFile(t, "error.golden", http.StatusBadRequest, nil)
+	_, err := c.Recent(context.Background(), Filter{})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Recent() error = %v, want *Error", err)
+	}
+	want := &Error{StatusCode: http.StatusBadRequest, Message: "invalid filter: variant requires an experiment"}
+	if !reflect.DeepEqual(apiErr, want) {
+		t.Errorf("Recent() error = %+v, want %+v", apiErr, want)
+	}
+}
+
+func TestErrorWithoutEnvelope(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream connect error", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	_, err := (&Client{BaseURL: srv.URL}).Stats(context.Background(), time.Time{}, time.Time{})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Stats() error = %v, want a 502 *Error", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/activities/db/snapshot" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("SQLite format 3\x00"))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	n, err := (&Client{BaseURL: srv.URL, AdminToken: "secret"}).Snapshot(context.Background(), &buf)
+	if err != nil || n != 16 || buf.String() != "SQLite format 3\x00" {
+		t.Errorf("Snapshot() = %d, %v, wrote %q; want the database", n, err, buf.String())
+	}
+	var apiErr *Error
+	if _, err := (&Client{BaseURL: srv.URL}).Snapshot(context.Background(), io.Discard); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Snapshot() without the token = %v, want a 401 *Error", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/activities/version" {
+			json.NewEncoder(w).Encode(sampleVersion)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		for _, a := range sampleActivities {
+			data, _ := json.Marshal(a)
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", a.ID, data)
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var got []activitylog.ActivityLog
+	err := (&Client{BaseURL: srv.URL}).Stream(ctx, func(a activitylog.ActivityLog) {
+		got = append(got, a)
+		if len(got) == len(sampleActivities) {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Stream() error = %v, want %v", err, context.Canceled)
+	}
+	if !reflect.DeepEqual(got, sampleActivities) {
+		t.Errorf("Stream() delivered %+v, want %+v", got, sampleActivities)
+	}
+}
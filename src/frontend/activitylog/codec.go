@@ -0,0 +1,116 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Details codecs
+const (
+	// DetailsCodecNone stores details as JSON text
+	DetailsCodecNone = "none"
+	// DetailsCodecGzip stores large details gzipped
+	DetailsCodecGzip = "gzip"
+)
+
+// DefaultDetailsCodecThreshold is the size in bytes details must exceed to
+// be compressed. Smaller ones barely shrink, if at all.
+const DefaultDetailsCodecThreshold = 256
+
+// detailsMarkerGzip is the first byte of gzipped details, which are stored
+// as a BLOB. JSON text can't start with it, so details are told apart by
+// their marker rather than by the codec configured when reading them.
+const detailsMarkerGzip = 0x01
+
+var detailsCodec = struct {
+	sync.RWMutex
+	name      string
+	threshold int
+}{name: DetailsCodecNone, threshold: DefaultDetailsCodecThreshold}
+
+// ConfigureDetailsCodec sets how the details of new activities are stored:
+// DetailsCodecNone or DetailsCodecGzip, the latter for details larger than
+// threshold bytes. A non-positive threshold restores the default. Details
+// already stored are read whatever the codec.
+func ConfigureDetailsCodec(name string, threshold int) error {
+	if name != DetailsCodecNone && name != DetailsCodecGzip {
+		return fmt.Errorf("unknown details codec %q, must be %s or %s", name, DetailsCodecNone, DetailsCodecGzip)
+	}
+	if threshold <= 0 {
+		threshold = DefaultDetailsCodecThreshold
+	}
+	detailsCodec.Lock()
+	defer detailsCodec.Unlock()
+	detailsCodec.name = name
+	detailsCodec.threshold = threshold
+	return nil
+}
+
+// DetailsCodec returns the codec the details of new activities are stored with
+func DetailsCodec() string {
+	detailsCodec.RLock()
+	defer detailsCodec.RUnlock()
+	return detailsCodec.name
+}
+
+// encodeStoredDetails returns the details of an activity of activityType
+// as they are to be stored: as is, or gzipped behind detailsMarkerGzip when
+// the codec says so. The details the session counters read stay as is.
+func encodeStoredDetails(activityType, details string) (interface{}, error) {
+	detailsCodec.RLock()
+	name, threshold := detailsCodec.name, detailsCodec.threshold
+	detailsCodec.RUnlock()
+	if name != DetailsCodecGzip || len(details) <= threshold || countedDetails(activityType) {
+		return details, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(detailsMarkerGzip)
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, details); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeStoredDetails returns the JSON of details as they were stored by
+// encodeStoredDetails
+func decodeStoredDetails(stored []byte) (string, error) {
+	if len(stored) == 0 || stored[0] != detailsMarkerGzip {
+		return string(stored), nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(stored[1:]))
+	if err != nil {
+		return "", fmt.Errorf("corrupt compressed details: %w", err)
+	}
+	details, err := io.ReadAll(zr)
+	if err != nil {
+		return "", fmt.Errorf("corrupt compressed details: %w", err)
+	}
+	return string(details), nil
+}
+
+// sqliteDriver is the SQLite driver with activity_details() registered on
+// every connection, which detailsJSON decodes compressed details with. Which
+// driver that is depends on the build, see driver_cgo.go and driver_purego.go.
+const sqliteDriver = "sqlite3_activitylog"
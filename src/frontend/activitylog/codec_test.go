@@ -0,0 +1,142 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useDetailsCodec(t *testing.T, name string, threshold int) {
+	t.Helper()
+	if err := ConfigureDetailsCodec(name, threshold); err != nil {
+		t.Fatalf("ConfigureDetailsCodec(%q) failed: %v", name, err)
+	}
+	t.Cleanup(func() { ConfigureDetailsCodec(DetailsCodecNone, 0) })
+}
+
+// storedAs returns how SQLite stores the details of the activity with the
+// given ID
+func storedAs(t *testing.T, id int64) string {
+	t.Helper()
+	var typ string
+	if err := GetDB().QueryRow("SELECT typeof(details) FROM activities WHERE id = ?", id).Scan(&typ); err != nil {
+		t.Fatalf("reading the details type failed: %v", err)
+	}
+	return typ
+}
+
+func TestDetailsCodecMixedDatabase(t *testing.T) {
+	fc := setupTestDB(t)
+	padding := strings.Repeat("x", 2*DefaultDetailsCodecThreshold)
+	logged := func(activityType string) *ActivityLog {
+		details, _ := json.Marshal(map[string]interface{}{
+			"failed": true, "failure_reason": "card_declined", "experiments": map[string]string{"layout": "grid"}, "padding": padding,
+		})
+		a := &ActivityLog{ActivityType: activityType, Details: string(details), CreatedAt: fc.Now().Add(-time.Minute)}
+		mustLog(t, a)
+		return a
+	}
+
+	plain := logged(ActivityTypeCheckout)
+	small := &ActivityLog{ActivityType: ActivityTypePageView, Details: `{"a":1}`}
+	useDetailsCodec(t, DetailsCodecGzip, 0)
+	mustLog(t, small)
+	compressed := logged(ActivityTypePageView)
+	counted := logged(ActivityTypeCheckout)
+	if got := storedAs(t, plain.ID); got != "text" {
+		t.Errorf("details logged without a codec stored as %s", got)
+	}
+	if got := storedAs(t, small.ID); got != "text" {
+		t.Errorf("details under the threshold stored as %s", got)
+	}
+	if got := storedAs(t, compressed.ID); got != "blob" {
+		t.Errorf("details over the threshold stored as %s, want compressed", got)
+	}
+	if got := storedAs(t, counted.ID); got != "text" {
+		t.Errorf("checkout details over the threshold stored as %s, want them as is for the session counters", got)
+	}
+
+	// Reads decompress whatever the codec is now
+	for _, codec := range []string{DetailsCodecGzip, DetailsCodecNone} {
+		useDetailsCodec(t, codec, 0)
+		got, err := GetActivities(Filter{Experiment: "layout", Variant: "grid"}, 10)
+		if err != nil {
+			t.Fatalf("GetActivities failed: %v", err)
+		}
+		if len(got) != 3 {
+			t.Fatalf("%s: filtering on details matched %d activities, want all 3", codec, len(got))
+		}
+		for _, a := range got {
+			if a.Details != plain.Details {
+				t.Errorf("%s: details read back as %q", codec, a.Details)
+			}
+		}
+		reasons, err := GetCheckoutFailureReasons(context.Background(), fc.Now().Add(-time.Hour), fc.Now())
+		if err != nil {
+			t.Fatalf("GetCheckoutFailureReasons failed: %v", err)
+		}
+		if len(reasons) != 1 || reasons[0].Reason != "card_declined" || reasons[0].Count != 2 {
+			t.Errorf("%s: failure reasons = %+v, want both checkouts", codec, reasons)
+		}
+	}
+
+	if err := mergeActivityDetails(context.Background(), compressed.ID, `{"render_ms":12}`); err != nil {
+		t.Fatalf("merging into compressed details failed: %v", err)
+	}
+	var merged string
+	if err := GetDB().QueryRow("SELECT details FROM activities WHERE id = ?", compressed.ID).Scan(&merged); err != nil {
+		t.Fatalf("reading the merged details failed: %v", err)
+	}
+	if d := detailsOf(t, ActivityLog{Details: merged}); d["render_ms"] != float64(12) || d["failure_reason"] != "card_declined" {
+		t.Errorf("merged details = %v", d)
+	}
+}
+
+func TestDetailsCodecRoundTrip(t *testing.T) {
+	useDetailsCodec(t, DetailsCodecGzip, 10)
+	for _, details := range []string{"", `{}`, `{"short":1}`, fmt.Sprintf(`{"long":%q}`, strings.Repeat("é", 100))} {
+		stored, err := encodeStoredDetails(ActivityTypePageView, details)
+		if err != nil {
+			t.Fatalf("encodeStoredDetails(%q) failed: %v", details, err)
+		}
+		var raw []byte
+		switch s := stored.(type) {
+		case string:
+			raw = []byte(s)
+		case []byte:
+			raw = s
+		}
+		if got, err := decodeStoredDetails(raw); err != nil || got != details {
+			t.Errorf("round trip of %q = %q, %v", details, got, err)
+		}
+	}
+	if _, err := decodeStoredDetails([]byte{detailsMarkerGzip, 'n', 'o', 'p', 'e'}); err == nil {
+		t.Error("decoding corrupt details succeeded")
+	}
+}
+
+func TestConfigureDetailsCodecRejectsUnknownCodecs(t *testing.T) {
+	if err := ConfigureDetailsCodec("snappy", 0); err == nil {
+		t.Error("ConfigureDetailsCodec(snappy) succeeded")
+	}
+	if got := DetailsCodec(); got != DetailsCodecNone {
+		t.Errorf("codec after a rejected one = %q, want %q", got, DetailsCodecNone)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	week = 7 * day
+	// weekOffset shifts Unix time so that weeks start on Mondays; the epoch
+	// fell on a Thursday.
+	weekOffset = 3 * day
+)
+
+// CohortNote explains what the cohort report measures
+const CohortNote = "Sessions are the only identity the frontend has: this measures returning sessions, not users. " +
+	"A shopper who clears cookies or switches devices starts a new cohort."
+
+// DeviceCohortNote explains what the cohort report of devices measures
+const DeviceCohortNote = "This measures returning devices: the sessions of a browser keeping its device cookie count as one. " +
+	"A shopper who clears cookies or switches devices starts a new cohort, and sessions without a device ID count on their own."
+
+// CohortReport is the weekly retention of the sessions, or devices, first
+// seen in each of the last weeks
+type CohortReport struct {
+	Note string `json:"note"`
+	// Timezone is the IANA name of the zone weeks start in
+	Timezone string      `json:"timezone"`
+	Cohorts  []CohortRow `json:"cohorts"`
+}
+
+// CohortRow is the retention of the sessions first seen in one week
+type CohortRow struct {
+	// WeekStart is the Monday, 00:00 in the report's time zone, the
+	// cohort's week started at.
+	WeekStart time.Time `json:"week_start"`
+	// Sessions is the number of sessions, or devices, first seen that week.
+	Sessions int `json:"sessions"`
+	// Retention is the fraction of the sessions seen in the cohort's week
+	// and each week after it, up to the current week.
+	Retention []float64 `json:"retention"`
+}
+
+// GetCohortRetention returns the cohort triangle of the last weeks, oldest
+// cohort first: row i holds the fraction of the sessions first seen in
+// week i that were seen again in week i, i+1, and so on up to the current
+// week. Empty cohorts have a retention of 0.
+func GetCohortRetention(ctx context.Context, weeks int) ([][]float64, error) {
+	report, err := GetCohortReport(ctx, weeks)
+	if err != nil {
+		return nil, err
+	}
+	retention := make([][]float64, len(report.Cohorts))
+	for i, c := range report.Cohorts {
+		retention[i] = c.Retention
+	}
+	return retention, nil
+}
+
+// GetCohortReport is GetCohortRetention with the start and size of each
+// cohort.
+func GetCohortReport(ctx context.Context, weeks int) (*CohortReport, error) {
+	return GetCohortReportIn(ctx, weeks, time.UTC)
+}
+
+// GetCohortReportIn is GetCohortReport with weeks starting on Mondays at
+// midnight in loc rather than UTC
+func GetCohortReportIn(ctx context.Context, weeks int, loc *time.Location) (*CohortReport, error) {
+	return GetCohortReportBy(ctx, weeks, loc, IdentitySession)
+}
+
+// GetCohortReportBy is GetCohortReportIn with cohorts of sessions or of
+// devices, as identity says. A device is first seen with its first session
+// and retained by any later one.
+func GetCohortReportBy(ctx context.Context, weeks int, loc *time.Location, identity string) (*CohortReport, error) {
+	return cohortReport(ctx, weeks, loc, identity, time.Time{})
+}
+
+// GetCohortReportAsOf is GetCohortReport as it was at end: the weeks are
+// those up to the one before end, and activities logged since are left
+// out, so that the report of a past week doesn't change.
+func GetCohortReportAsOf(ctx context.Context, weeks int, end time.Time) (*CohortReport, error) {
+	return cohortReport(ctx, weeks, time.UTC, IdentitySession, end)
+}
+
+// cohortReport is GetCohortReportBy counting only the activities before
+// end, up to the week before it, unless end is zero
+func cohortReport(ctx context.Context, weeks int, loc *time.Location, identity string, end time.Time) (*CohortReport, error) {
+	if weeks <= 0 {
+		return nil, errors.New("weeks must be positive")
+	}
+	if _, err := ParseIdentity(identity); err != nil {
+		return nil, err
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "cohort report", time.Now())
+
+	now := Now()
+	before, beforeArgs := "", []interface{}(nil)
+	if !end.IsZero() {
+		now = end.Add(-time.Nanosecond)
+		before, beforeArgs = " AND created_at < ?", []interface{}{end.UTC()}
+	}
+	offset, length := int64(weekOffset/time.Second), int64(week/time.Second)
+	current := (wallSeconds(now, loc) + offset) / length
+	first := current - int64(weeks-1)
+	weekStart := func(i int) time.Time {
+		return fromWallSeconds((first+int64(i))*length-offset, loc)
+	}
+	windowStart := weekStart(0)
+	activeLocal, activeArgs := localSeconds("created_at", loc, windowStart, now)
+	firstLocal, firstArgs := localSeconds("f.first_at", loc, windowStart, now)
+
+	// One scan over every activity finds when each session was first seen,
+	// keeping the sessions that started within the window, and one scan
+	// over the window finds the weeks they were active in.
+	id := identityColumn(identity, "")
+	query := `
+		WITH first_seen AS MATERIALIZED (
+			SELECT ` + id + ` AS shopper, MIN(created_at) AS first_at
+			FROM activities
+			WHERE deleted_at IS NULL` + before + `
+			GROUP BY shopper
+			HAVING first_at >= ?
+		),
+		active_weeks AS MATERIALIZED (
+			SELECT DISTINCT ` + id + ` AS shopper,
+				   (` + activeLocal + ` + ?) / ? AS week
+			FROM activities
+			WHERE created_at >= ?` + before + ` AND deleted_at IS NULL
+		)
+		SELECT (` + firstLocal + ` + ?) / ? AS cohort,
+			   a.week, COUNT(*)
+		FROM first_seen f
+		JOIN active_weeks a ON a.shopper = f.shopper
+		GROUP BY cohort, a.week`
+
+	var args []interface{}
+	args = append(append(args, beforeArgs...), windowStart.UTC())
+	args = append(append(args, activeArgs...), offset, length, windowStart.UTC())
+	args = append(append(args, beforeArgs...), firstArgs...)
+	args = append(args, offset, length)
+	rows, err := getReadDB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// counts[i][j] is the number of sessions of cohort i active in week i+j
+	counts := make([][]int, weeks)
+	for i := range counts {
+		counts[i] = make([]int, weeks-i)
+	}
+	for rows.Next() {
+		var cohort, activeWeek int64
+		var n int
+		if err := rows.Scan(&cohort, &activeWeek, &n); err != nil {
+			return nil, err
+		}
+		i, j := cohort-first, activeWeek-cohort
+		if i < 0 || i >= int64(weeks) || j < 0 || j >= int64(len(counts[i])) {
+			continue
+		}
+		counts[i][j] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	note := CohortNote
+	if identity == IdentityDevice {
+		note = DeviceCohortNote
+	}
+	report := &CohortReport{Note: note, Timezone: loc.String(), Cohorts: make([]CohortRow, weeks)}
+	for i, c := range counts {
+		row := CohortRow{
+			WeekStart: weekStart(i),
+			Sessions:  c[0],
+			Retention: make([]float64, len(c)),
+		}
+		for j, n := range c {
+			if c[0] > 0 {
+				row.Retention[j] = float64(n) / float64(c[0])
+			}
+		}
+		report.Cohorts[i] = row
+	}
+	return report, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetCohortRetention(t *testing.T) {
+	setupTestDB(t)
+	// The fake clock is on Sunday 2025-06-01, so the last three weeks
+	// start on May 12, 19 and 26.
+	may := func(d int) time.Time { return time.Date(2025, 5, d, 10, 0, 0, 0, time.UTC) }
+	visits := map[string][]time.Time{
+		// First seen before the window, not part of any cohort
+		"old": {may(1), may(13), may(20)},
+		// Cohort of May 12: 4 sessions, 2 back the next week, 1 the week
+		// after
+		"a": {may(12), may(14), may(19), may(26)},
+		"b": {may(18), may(25)},
+		"c": {may(15)},
+		"d": {may(16)},
+		// Cohort of May 19: 2 sessions, 1 back the next week
+		"e": {may(19), may(31)},
+		"f": {may(21)},
+		// Cohort of May 26
+		"g": {may(30)},
+	}
+	for session, times := range visits {
+		for _, at := range times {
+			mustLog(t, &ActivityLog{SessionID: session, ActivityType: ActivityTypePageView, CreatedAt: at})
+		}
+	}
+
+	got, err := GetCohortRetention(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("GetCohortRetention() failed: %v", err)
+	}
+	want := [][]float64{
+		{1, 0.5, 0.25},
+		{1, 0.5},
+		{1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetCohortRetention() = %v, want %v", got, want)
+	}
+
+	report, err := GetCohortReport(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("GetCohortReport() failed: %v", err)
+	}
+	var starts []time.Time
+	var sessions []int
+	for _, c := range report.Cohorts {
+		starts = append(starts, c.WeekStart)
+		sessions = append(sessions, c.Sessions)
+	}
+	wantStarts := []time.Time{
+		time.Date(2025, 5, 12, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 5, 19, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 5, 26, 0, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(starts, wantStarts) || !reflect.DeepEqual(sessions, []int{4, 2, 1}) {
+		t.Errorf("cohorts start %v with %v sessions, want %v with [4 2 1]", starts, sessions, wantStarts)
+	}
+
+	// As of the end of the week of May 19, the week of May 26 hasn't
+	// happened yet
+	report, err = GetCohortReportAsOf(context.Background(), 2, time.Date(2025, 5, 26, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("GetCohortReportAsOf() failed: %v", err)
+	}
+	if len(report.Cohorts) != 2 || !report.Cohorts[1].WeekStart.Equal(wantStarts[1]) {
+		t.Fatalf("GetCohortReportAsOf() = %+v, want the cohorts of May 12 and 19", report.Cohorts)
+	}
+	if c := report.Cohorts[0]; c.Sessions != 4 || !reflect.DeepEqual(c.Retention, []float64{1, 0.5}) {
+		t.Errorf("cohort of May 12 as of May 26 = %+v, want 4 sessions, half back the next week", c)
+	}
+	if c := report.Cohorts[1]; c.Sessions != 2 || !reflect.DeepEqual(c.Retention, []float64{1}) {
+		t.Errorf("cohort of May 19 as of May 26 = %+v, want 2 sessions", c)
+	}
+}
+
+func TestGetCohortRetentionEmptyCohorts(t *testing.T) {
+	setupTestDB(t)
+	got, err := GetCohortRetention(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("GetCohortRetention() failed: %v", err)
+	}
+	if want := [][]float64{{0, 0}, {0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetCohortRetention() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// What GetComparison can split activities by
+const (
+	SplitVersion  = "version"
+	SplitRevision = "revision"
+)
+
+// SplitUnknown is the group of activities logged before the value they are
+// split by was recorded
+const SplitUnknown = "(unknown)"
+
+// splitColumns are the columns holding the value of each split
+var splitColumns = map[string]string{
+	SplitVersion:  "version",
+	SplitRevision: "revision",
+}
+
+// Comparison is the traffic and health of the activities sharing a value
+// of the split, such as a frontend version
+type Comparison struct {
+	Value           string  `json:"value"`
+	Sessions        int     `json:"sessions"`
+	Activities      int     `json:"activities"`
+	Checkouts       int     `json:"checkouts"`
+	FailedCheckouts int     `json:"failed_checkouts"`
+	ServerErrors    int     `json:"server_errors"`
+	AvgLatencyMs    float64 `json:"avg_latency_ms"`
+	// Normalized are the stats of the group relative to its sessions,
+	// which tell whether behavior changed regardless of how much traffic
+	// the group got
+	Normalized *NormalizedStats `json:"normalized"`
+}
+
+// ValidateSplit checks that activities can be split by split
+func ValidateSplit(split string) error {
+	if _, ok := splitColumns[split]; !ok {
+		return fmt.Errorf("unknown split %q, must be %s or %s", split, SplitVersion, SplitRevision)
+	}
+	return nil
+}
+
+// GetComparison splits the activities of a given time period by version or
+// revision and returns the stats of each group, most recently seen first,
+// so that a deploy reads as the new version above the one it replaced.
+func GetComparison(ctx context.Context, split string, startTime, endTime time.Time) ([]Comparison, error) {
+	if err := ValidateSplit(split); err != nil {
+		return nil, err
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "comparison", time.Now())
+
+	column := splitColumns[split]
+	normalized, err := normalizedStats(ctx, getReadDB(), "COALESCE(NULLIF("+column+", ''), '"+SplitUnknown+"')", startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+		SELECT COALESCE(NULLIF(` + column + `, ''), ?) AS value,
+			   COUNT(DISTINCT session_id),
+			   COUNT(*),
+			   COALESCE(SUM(activity_type = ? AND NOT COALESCE(json_extract(` + detailsJSON + `, '$.failed'), 0)), 0),
+			   COALESCE(SUM(activity_type = ? AND COALESCE(json_extract(` + detailsJSON + `, '$.failed'), 0)), 0),
+			   COALESCE(SUM(status_code >= 500), 0),
+			   COALESCE(AVG(latency_ms), 0)
+		FROM activities
+		WHERE ` + createdIn("created_at") + ` AND deleted_at IS NULL
+		GROUP BY value
+		ORDER BY MAX(created_at) DESC, value`
+
+	rows, err := getReadDB().QueryContext(ctx, query, SplitUnknown, ActivityTypeCheckout, ActivityTypeCheckout,
+		startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []Comparison{}
+	for rows.Next() {
+		var c Comparison
+		if err := rows.Scan(&c.Value, &c.Sessions, &c.Activities, &c.Checkouts, &c.FailedCheckouts,
+			&c.ServerErrors, &c.AvgLatencyMs); err != nil {
+			return nil, err
+		}
+		c.Normalized = normalized[c.Value]
+		groups = append(groups, c)
+	}
+	return groups, rows.Err()
+}
@@ -0,0 +1,84 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetComparison(t *testing.T) {
+	fc := setupTestDB(t)
+	// Activities logged before versions were recorded
+	mustLog(t, &ActivityLog{SessionID: "a", ActivityType: ActivityTypePageView, StatusCode: 200, LatencyMs: 10})
+	fc.Advance(time.Minute)
+	for _, a := range []ActivityLog{
+		{SessionID: "b", Version: "1.0.0", Revision: "blue", ActivityType: ActivityTypePageView, StatusCode: 200, LatencyMs: 20},
+		{SessionID: "b", Version: "1.0.0", Revision: "blue", ActivityType: ActivityTypeCheckout, StatusCode: 200, LatencyMs: 40},
+	} {
+		mustLog(t, &a)
+	}
+	fc.Advance(time.Minute)
+	for _, a := range []ActivityLog{
+		{SessionID: "c", Version: "1.1.0", Revision: "green", ActivityType: ActivityTypePageView, StatusCode: 500, LatencyMs: 30},
+		{SessionID: "d", Version: "1.1.0", Revision: "green", ActivityType: ActivityTypeCheckout, StatusCode: 500, LatencyMs: 90, Details: `{"failed":true}`},
+	} {
+		mustLog(t, &a)
+	}
+	// Outside the period
+	mustLog(t, &ActivityLog{SessionID: "e", Version: "0.9.0", ActivityType: ActivityTypeCheckout, CreatedAt: fc.Now().Add(-2 * time.Hour)})
+
+	got, err := GetComparison(context.Background(), SplitVersion, fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetComparison() failed: %v", err)
+	}
+	// 1.1.0 had two sessions, one of which checked out, unsuccessfully
+	if n := got[0].Normalized; n == nil || n.ActivitiesPerSession != (Ratio{2, 2, 1}) ||
+		n.SessionPercent[ActivityTypeCheckout] != (Ratio{1, 2, 50}) {
+		t.Errorf("normalized stats of 1.1.0 = %+v", n)
+	}
+	for i := range got {
+		if got[i].Normalized == nil || got[i].Normalized.Sessions != got[i].Sessions {
+			t.Errorf("normalized stats of %s = %+v, want %d sessions", got[i].Value, got[i].Normalized, got[i].Sessions)
+		}
+		got[i].Normalized = nil
+	}
+	want := []Comparison{
+		{Value: "1.1.0", Sessions: 2, Activities: 2, FailedCheckouts: 1, ServerErrors: 2, AvgLatencyMs: 60},
+		{Value: "1.0.0", Sessions: 1, Activities: 2, Checkouts: 1, AvgLatencyMs: 30},
+		{Value: SplitUnknown, Sessions: 1, Activities: 1, AvgLatencyMs: 10},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetComparison(version) = %+v, want %+v", got, want)
+	}
+
+	got, err = GetComparison(context.Background(), SplitRevision, fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetComparison() failed: %v", err)
+	}
+	var values []string
+	for _, c := range got {
+		values = append(values, c.Value)
+	}
+	if want := []string{"green", "blue", SplitUnknown}; !reflect.DeepEqual(values, want) {
+		t.Errorf("GetComparison(revision) values = %v, want %v", values, want)
+	}
+
+	if _, err := GetComparison(context.Background(), "campaign", fc.Now().Add(-time.Hour), fc.Now()); err == nil {
+		t.Error("GetComparison(campaign) succeeded, want an error")
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// ConsentCookie is the cookie holding whether the shopper agreed to their
+// activities being logged. Shoppers who never said are tracked; setting it
+// to ConsentDeclined stops it.
+const ConsentCookie = "shop_tracking"
+
+// ConsentDeclined is the value of ConsentCookie of shoppers who opted out
+const ConsentDeclined = "off"
+
+// untracked counts the requests not logged because the shopper opted out
+var untracked = expvar.NewInt("activity_log_untracked_total")
+
+// TrackingAllowed tells whether the activities of r may be logged
+func TrackingAllowed(r *http.Request) bool {
+	c, err := r.Cookie(ConsentCookie)
+	return err != nil || c.Value != ConsentDeclined
+}
@@ -0,0 +1,158 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type ctxKeyDetails struct{}
+
+// requestDetails collects the details handlers attach to the activity of the
+// request they are serving.
+type requestDetails struct {
+	mu     sync.Mutex
+	values map[string]interface{}
+	items  []ActivityItem
+	userID string
+	skip   bool
+	// clientEvent is the event a browser reported, for requests to
+	// IngestPath
+	clientEvent *ClientEvent
+}
+
+// AddDetail attaches a detail to the activity logged for the request ctx
+// belongs to, for information only the handler knows. A later value for the
+// same key replaces the earlier one. It does nothing for requests that
+// don't pass through the activity middleware.
+func AddDetail(ctx context.Context, key string, value interface{}) {
+	d, ok := ctx.Value(ctxKeyDetails{}).(*requestDetails)
+	if !ok {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.values == nil {
+		d.values = make(map[string]interface{})
+	}
+	d.values[key] = value
+}
+
+// AddDetails attaches typed details, such as CheckoutDetails, to the
+// activity logged for the request ctx belongs to. It is AddDetail for each
+// of their values that is set.
+func AddDetails(ctx context.Context, details interface{}) {
+	for key, value := range detailsMap(details) {
+		AddDetail(ctx, key, value)
+	}
+}
+
+// AddDuration adds d, in milliseconds, to the detail under key of the
+// activity logged for the request ctx belongs to, so that durations of
+// something done several times during a request add up. Like AddDetail it
+// does nothing for requests that don't pass through the activity middleware.
+func AddDuration(ctx context.Context, key string, d time.Duration) {
+	details, ok := ctx.Value(ctxKeyDetails{}).(*requestDetails)
+	if !ok {
+		return
+	}
+	details.mu.Lock()
+	defer details.mu.Unlock()
+	if details.values == nil {
+		details.values = make(map[string]interface{})
+	}
+	ms, _ := details.values[key].(float64)
+	details.values[key] = ms + float64(d.Microseconds())/1000
+}
+
+// AddItem attaches a line item, such as a product of a placed order, to the
+// activity logged for the request ctx belongs to. Items whose product ID
+// isn't one are dropped.
+func AddItem(ctx context.Context, productID string, quantity int) {
+	d, ok := ctx.Value(ctxKeyDetails{}).(*requestDetails)
+	if !ok || !detailFormats["product_id"].MatchString(productID) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.items = append(d.items, ActivityItem{ProductID: productID, Quantity: quantity})
+}
+
+// SetUser attributes the activity logged for the request ctx belongs to to
+// a user, for the request signing them in: the activities logged before it
+// are attributed with AttributeSession, those after carry the user in their
+// context.
+func SetUser(ctx context.Context, userID string) {
+	d, ok := ctx.Value(ctxKeyDetails{}).(*requestDetails)
+	if !ok {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.userID = userID
+}
+
+// SkipActivity keeps the request ctx belongs to out of the activity log,
+// for instance when logging it would undo what the request did.
+func SkipActivity(ctx context.Context) {
+	d, ok := ctx.Value(ctxKeyDetails{}).(*requestDetails)
+	if !ok {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.skip = true
+}
+
+// skipped tells whether the handler asked for the activity not to be logged
+func (d *requestDetails) skipped() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.skip
+}
+
+// renderFailed tells whether the handler recorded a template that failed
+// to render, which makes the request a render_error
+func (d *requestDetails) renderFailed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.values["template"]
+	return ok
+}
+
+// mergeInto copies the collected details into details
+func (d *requestDetails) mergeInto(details map[string]interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, v := range d.values {
+		details[k] = v
+	}
+}
+
+// lineItems returns the line items the handler attached
+func (d *requestDetails) lineItems() []ActivityItem {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.items
+}
+
+// user returns the user the handler attributed the activity to
+func (d *requestDetails) user() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.userID
+}
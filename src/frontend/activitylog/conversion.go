@@ -0,0 +1,150 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// conversionBuckets are the upper bounds of the time to conversion
+// histogram buckets. Longer durations fall in a last, unbounded bucket.
+var conversionBuckets = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	4 * time.Hour,
+	24 * time.Hour,
+}
+
+// DurationStats summarizes how long sessions, or devices, took, in seconds
+type DurationStats struct {
+	Count  int     `json:"count"`
+	Median float64 `json:"median_seconds"`
+	P90    float64 `json:"p90_seconds"`
+	// Histogram has a bucket per entry of conversionBuckets and a last
+	// bucket for longer durations, empty ones included
+	Histogram []DurationBucket `json:"histogram"`
+	// Instant counts the sessions that had nothing logged before, which
+	// count as taking no time at all
+	Instant int `json:"instant"`
+}
+
+// DurationBucket counts the durations of at least the previous bucket's
+// bound and less than LessThan seconds, which is 0 for the last bucket
+type DurationBucket struct {
+	Label    string  `json:"label"`
+	LessThan float64 `json:"less_than_seconds,omitempty"`
+	Count    int     `json:"count"`
+}
+
+// GetTimeToConversion returns how long the sessions that first checked out
+// during a given time period took from their first activity, whenever that
+// was, to that checkout. Failed checkouts don't count.
+func GetTimeToConversion(ctx context.Context, startTime, endTime time.Time) (DurationStats, error) {
+	return GetTimeToConversionBy(ctx, startTime, endTime, IdentitySession)
+}
+
+// GetTimeToConversionBy is GetTimeToConversion timing sessions or devices,
+// as identity says. Devices are timed from the first activity of their
+// first session.
+func GetTimeToConversionBy(ctx context.Context, startTime, endTime time.Time, identity string) (DurationStats, error) {
+	if _, err := ParseIdentity(identity); err != nil {
+		return DurationStats{}, err
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return DurationStats{}, err
+	}
+	defer release()
+	defer observeQuery(ctx, "time to conversion", time.Now())
+
+	id := identityColumn(identity, "")
+	query := `
+		SELECT ROUND((julianday(converted_at) - julianday(first_at)) * 86400, 3)
+		FROM (
+			SELECT MIN(created_at) AS first_at,
+				   MIN(CASE WHEN activity_type = ?
+							 AND NOT COALESCE(json_extract(` + detailsJSON + `, '$.failed'), 0)
+						THEN created_at END) AS converted_at
+			FROM activities
+			WHERE deleted_at IS NULL AND ` + id + ` IN (
+				SELECT ` + id + ` FROM activities
+				WHERE activity_type = ? AND ` + createdIn("created_at") + ` AND deleted_at IS NULL)
+			GROUP BY ` + id + `)
+		WHERE ` + createdIn("converted_at")
+
+	rows, err := getReadDB().QueryContext(ctx, query, ActivityTypeCheckout, ActivityTypeCheckout,
+		startTime.UTC(), endTime.UTC(), startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return DurationStats{}, err
+	}
+	defer rows.Close()
+
+	var seconds []float64
+	for rows.Next() {
+		var s float64
+		if err := rows.Scan(&s); err != nil {
+			return DurationStats{}, err
+		}
+		seconds = append(seconds, s)
+	}
+	if err := rows.Err(); err != nil {
+		return DurationStats{}, err
+	}
+	return durationStats(seconds), nil
+}
+
+// durationStats summarizes durations given in seconds
+func durationStats(seconds []float64) DurationStats {
+	stats := DurationStats{Count: len(seconds), Histogram: make([]DurationBucket, len(conversionBuckets)+1)}
+	for i, bound := range conversionBuckets {
+		stats.Histogram[i] = DurationBucket{Label: "< " + shortDuration(bound), LessThan: bound.Seconds()}
+	}
+	last := conversionBuckets[len(conversionBuckets)-1]
+	stats.Histogram[len(conversionBuckets)] = DurationBucket{Label: ">= " + shortDuration(last)}
+	if len(seconds) == 0 {
+		return stats
+	}
+
+	sort.Float64s(seconds)
+	for _, s := range seconds {
+		if s <= 0 {
+			stats.Instant++
+		}
+		i := sort.Search(len(conversionBuckets), func(i int) bool {
+			return s < conversionBuckets[i].Seconds()
+		})
+		stats.Histogram[i].Count++
+	}
+	stats.Median = percentile(seconds, 50)
+	stats.P90 = percentile(seconds, 90)
+	return stats
+}
+
+// shortDuration formats whole minutes, hours or days as 5m, 4h or 1d
+func shortDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		return strconv.Itoa(int(d/(24*time.Hour))) + "d"
+	case d >= time.Hour && d%time.Hour == 0:
+		return strconv.Itoa(int(d/time.Hour)) + "h"
+	default:
+		return strconv.Itoa(int(d/time.Minute)) + "m"
+	}
+}
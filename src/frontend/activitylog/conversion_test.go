@@ -0,0 +1,83 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGetTimeToConversion(t *testing.T) {
+	fc := setupTestDB(t)
+	// An early visit, converting a day later within the window
+	logJourney(t, fc, "returning", ActivityTypePageView)
+	fc.Advance(24 * time.Hour)
+	start := fc.Now()
+	logJourney(t, fc, "returning", ActivityTypeCheckout)
+	// Four steps a minute apart, checking out a second time later on
+	logJourney(t, fc, "buyer", ActivityTypeProductView, ActivityTypeAddToCart, ActivityTypeViewCart, ActivityTypeCheckout)
+	logJourney(t, fc, "buyer", ActivityTypeCheckout)
+	// Checking out with nothing logged before
+	mustLog(t, &ActivityLog{SessionID: "direct", ActivityType: ActivityTypeCheckout})
+	logJourney(t, fc, "failed", ActivityTypeProductView, ActivityTypeCheckout)
+	logJourney(t, fc, "browser", ActivityTypeProductView)
+	end := fc.Now().Add(time.Minute)
+
+	got, err := GetTimeToConversion(context.Background(), start, end)
+	if err != nil {
+		t.Fatalf("GetTimeToConversion() failed: %v", err)
+	}
+	if got.Count != 3 || got.Instant != 1 {
+		t.Errorf("count = %d, instant = %d, want 3 sessions, 1 instant", got.Count, got.Instant)
+	}
+	day := (24*time.Hour + time.Minute).Seconds()
+	if got.Median != 180 || got.P90 != day {
+		t.Errorf("median = %v, p90 = %v, want 180 and %v", got.Median, got.P90, day)
+	}
+	wantCounts := map[string]int{"< 1m": 1, "< 5m": 1, ">= 1d": 1}
+	for _, b := range got.Histogram {
+		if b.Count != wantCounts[b.Label] {
+			t.Errorf("bucket %s has %d sessions, want %d", b.Label, b.Count, wantCounts[b.Label])
+		}
+	}
+
+	// The buyer's second checkout isn't a conversion of its own
+	got, err = GetTimeToConversion(context.Background(), fc.Now().Add(-2*time.Minute), end)
+	if err != nil {
+		t.Fatalf("GetTimeToConversion() failed: %v", err)
+	}
+	if got.Count != 0 {
+		t.Errorf("count = %d for a window without first checkouts, want 0", got.Count)
+	}
+}
+
+func TestDurationStatsBuckets(t *testing.T) {
+	stats := durationStats(nil)
+	if len(stats.Histogram) != len(conversionBuckets)+1 {
+		t.Fatalf("histogram has %d buckets, want %d", len(stats.Histogram), len(conversionBuckets)+1)
+	}
+	labels := []string{"< 1m", "< 5m", "< 15m", "< 1h", "< 4h", "< 1d", ">= 1d"}
+	for i, b := range stats.Histogram {
+		if b.Label != labels[i] || b.Count != 0 {
+			t.Errorf("bucket %d = %+v, want an empty %q bucket", i, b, labels[i])
+		}
+	}
+
+	stats = durationStats([]float64{59.999, 60, 86400})
+	if stats.Histogram[0].Count != 1 || stats.Histogram[1].Count != 1 || stats.Histogram[6].Count != 1 {
+		t.Errorf("histogram = %+v, want bounds to belong to the next bucket", stats.Histogram)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CurrencyInvalid is the currency of activities whose currency cookie isn't
+// a currency the shop knows. The cookie's value is kept in raw_invalid.
+const CurrencyInvalid = "INVALID"
+
+// DefaultCurrencyCacheTTL is how long the list of known currencies is
+// trusted before it is refreshed
+const DefaultCurrencyCacheTTL = 10 * time.Minute
+
+// currencyFetchTimeout bounds each call to the currency service
+const currencyFetchTimeout = 5 * time.Second
+
+// CurrencyValidator checks currency codes against the currencies the
+// currency service supports, caching the list. It fails open: while there
+// is no list fresher than the TTL, such as when the currency service is
+// unreachable, every code is accepted, so that activities are never dropped
+// or mislabeled over it.
+type CurrencyValidator struct {
+	fetch func(context.Context) ([]string, error)
+	ttl   time.Duration
+
+	mu        sync.RWMutex
+	codes     map[string]bool
+	fetchedAt time.Time
+}
+
+// NewCurrencyValidator creates a validator listing the known currencies
+// with fetch. A non-positive ttl means DefaultCurrencyCacheTTL.
+func NewCurrencyValidator(fetch func(context.Context) ([]string, error), ttl time.Duration) *CurrencyValidator {
+	if ttl <= 0 {
+		ttl = DefaultCurrencyCacheTTL
+	}
+	return &CurrencyValidator{fetch: fetch, ttl: ttl}
+}
+
+// Start fetches the list of currencies in the background, then refreshes
+// it until ctx is done, well before it expires so that a single failed
+// refresh doesn't open the validator. Until the first fetch, anything goes.
+func (v *CurrencyValidator) Start(ctx context.Context) {
+	go func() {
+		v.Refresh(ctx)
+		ticker := time.NewTicker(v.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				v.Refresh(ctx)
+			}
+		}
+	}()
+}
+
+// Refresh fetches the list of currencies. Failures keep the list there was.
+func (v *CurrencyValidator) Refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, currencyFetchTimeout)
+	defer cancel()
+	currencies, err := v.fetch(ctx)
+	if err != nil {
+		logger.WithError(err).Warn("failed to refresh the known currencies")
+		return err
+	}
+	codes := make(map[string]bool, len(currencies))
+	for _, c := range currencies {
+		codes[c] = true
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.codes = codes
+	v.fetchedAt = Now()
+	return nil
+}
+
+// Valid tells whether code is a known currency, or whether the list of
+// them is missing or expired, in which case anything goes
+func (v *CurrencyValidator) Valid(code string) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.codes == nil || Now().Sub(v.fetchedAt) > v.ttl {
+		return true
+	}
+	return v.codes[code]
+}
+
+// WithCurrencyValidator records activities whose currency v doesn't know
+// under CurrencyInvalid, keeping the currency they had in raw_invalid
+func WithCurrencyValidator(v *CurrencyValidator) Option {
+	return func(m *ActivityMiddleware) {
+		m.currencies = v
+	}
+}
+
+// ObservedCurrencies are the currencies activities were logged with
+type ObservedCurrencies struct {
+	// Currencies are the currencies seen, busiest first. Invalid ones are
+	// listed by the value the activities had.
+	Currencies []ObservedCurrency `json:"currencies"`
+	// Valid and Invalid are the number of activities with a currency that
+	// was accepted or rejected when they were logged
+	Valid   int `json:"valid"`
+	Invalid int `json:"invalid"`
+}
+
+// ObservedCurrency is the number of activities logged with a currency
+type ObservedCurrency struct {
+	Currency string `json:"currency"`
+	Count    int    `json:"count"`
+	Valid    bool   `json:"valid"`
+}
+
+// GetObservedCurrencies returns the currencies of the activities in a given
+// time period. Activities logged before currencies were checked against the
+// currency service count as valid if they were well-formed; those that
+// weren't had their currency dropped, and count as invalid.
+func GetObservedCurrencies(ctx context.Context, startTime, endTime time.Time) (*ObservedCurrencies, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "observed currencies", time.Now())
+
+	query := `
+		SELECT currency, valid, COUNT(*) FROM (
+			SELECT CASE WHEN raw IS NULL THEN user_currency ELSE raw END AS currency,
+				   raw IS NULL AND user_currency != ? AS valid
+			FROM (
+				SELECT COALESCE(user_currency, '') AS user_currency,
+					   CASE WHEN json_valid(` + detailsJSON + `)
+					   THEN json_extract(` + detailsJSON + `, '$.raw_invalid.user_currency') END AS raw
+				FROM activities
+				WHERE ` + createdIn("created_at") + ` AND deleted_at IS NULL
+			)
+			WHERE user_currency != '' OR raw IS NOT NULL
+		)
+		GROUP BY currency, valid`
+	rows, err := getReadDB().QueryContext(ctx, query, CurrencyInvalid, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	observed := &ObservedCurrencies{Currencies: []ObservedCurrency{}}
+	for rows.Next() {
+		var c ObservedCurrency
+		if err := rows.Scan(&c.Currency, &c.Valid, &c.Count); err != nil {
+			return nil, err
+		}
+		if c.Valid {
+			observed.Valid += c.Count
+		} else {
+			observed.Invalid += c.Count
+		}
+		observed.Currencies = append(observed.Currencies, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(observed.Currencies, func(i, j int) bool {
+		a, b := observed.Currencies[i], observed.Currencies[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Currency < b.Currency
+	})
+	return observed, nil
+}
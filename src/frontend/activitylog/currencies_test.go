@@ -0,0 +1,123 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCurrencyValidatorFailsOpen(t *testing.T) {
+	fc := setupTestDB(t)
+	var fail bool
+	v := NewCurrencyValidator(func(context.Context) ([]string, error) {
+		if fail {
+			return nil, errors.New("connection refused")
+		}
+		return []string{"USD", "EUR"}, nil
+	}, time.Minute)
+
+	if !v.Valid("XXX") {
+		t.Error("Valid(XXX) = false before the currencies were fetched, want anything to go")
+	}
+	if err := v.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() failed: %v", err)
+	}
+	if !v.Valid("EUR") || v.Valid("XXX") {
+		t.Errorf("Valid(EUR), Valid(XXX) = %v, %v, want true, false", v.Valid("EUR"), v.Valid("XXX"))
+	}
+
+	// A failed refresh keeps the list until it expires
+	fail = true
+	if err := v.Refresh(context.Background()); err == nil {
+		t.Fatal("Refresh() succeeded with the currency service down")
+	}
+	if v.Valid("XXX") {
+		t.Error("Valid(XXX) = true right after a failed refresh, want the cached list used")
+	}
+	fc.Advance(2 * time.Minute)
+	if !v.Valid("XXX") {
+		t.Error("Valid(XXX) = false with the list expired, want anything to go")
+	}
+}
+
+func TestMiddlewareRejectsUnknownCurrencies(t *testing.T) {
+	setupTestDB(t)
+	v := NewCurrencyValidator(func(context.Context) ([]string, error) {
+		return []string{"USD", "EUR"}, nil
+	}, time.Minute)
+	if err := v.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() failed: %v", err)
+	}
+	router := newTestRouter(WithCurrencyValidator(v))
+
+	req := postForm("/cart", "product_id=OLJCESPC7Z&quantity=1")
+	req.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "XXX"})
+	serve(router, req, "session-1")
+	a := lastActivity(t)
+	if a.UserCurrency != CurrencyInvalid {
+		t.Errorf("UserCurrency = %q, want %s", a.UserCurrency, CurrencyInvalid)
+	}
+	raw, _ := detailsOf(t, a)["raw_invalid"].(map[string]interface{})
+	if raw["user_currency"] != "XXX" {
+		t.Errorf("raw_invalid = %v, want the currency kept", raw)
+	}
+
+	req = postForm("/cart", "product_id=OLJCESPC7Z&quantity=1")
+	req.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "EUR"})
+	serve(router, req, "session-1")
+	if a := lastActivity(t); a.UserCurrency != "EUR" {
+		t.Errorf("UserCurrency = %q, want EUR", a.UserCurrency)
+	}
+}
+
+func TestGetObservedCurrencies(t *testing.T) {
+	fc := setupTestDB(t)
+	for _, a := range []ActivityLog{
+		{SessionID: "a", UserCurrency: "USD"},
+		{SessionID: "a", UserCurrency: "USD"},
+		{SessionID: "b", UserCurrency: "EUR"},
+		{SessionID: "c", UserCurrency: CurrencyInvalid, Details: `{"raw_invalid":{"user_currency":"XXX"}}`},
+		// Dropped before invalid currencies were recorded as such
+		{SessionID: "d", Details: `{"raw_invalid":{"user_currency":"&lt;svg&gt;"}}`},
+		{SessionID: "e"},
+	} {
+		a.ActivityType = ActivityTypePageView
+		a.CreatedAt = fc.Now()
+		mustLog(t, &a)
+	}
+
+	observed, err := GetObservedCurrencies(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetObservedCurrencies() failed: %v", err)
+	}
+	want := &ObservedCurrencies{
+		Currencies: []ObservedCurrency{
+			{Currency: "USD", Count: 2, Valid: true},
+			{Currency: "&lt;svg&gt;", Count: 1},
+			{Currency: "EUR", Count: 1, Valid: true},
+			{Currency: "XXX", Count: 1},
+		},
+		Valid:   3,
+		Invalid: 2,
+	}
+	if !reflect.DeepEqual(observed, want) {
+		t.Errorf("GetObservedCurrencies() = %+v, want %+v", observed, want)
+	}
+}
@@ -20,15 +20,15 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
 )
 
 const (
 	dbFileName = "activities.db"
-	schema = `
+	schema     = `
 	CREATE TABLE IF NOT EXISTS activities (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		session_id TEXT NOT NULL,
@@ -47,65 +47,393 @@ const (
 	`
 )
 
+// migrations evolve the schema after its initial version. They run in order
+// and PRAGMA user_version records how many have been applied, so only append
+// to this list. Once a database is split by month, activities is a view:
+// a migration changing activities has to change every monthly partition,
+// and partitionTable, instead.
+var migrations = []string{
+	`ALTER TABLE activities ADD COLUMN source TEXT;
+	CREATE INDEX IF NOT EXISTS idx_source ON activities(source);`,
+	`ALTER TABLE activities ADD COLUMN latency_ms INTEGER;
+	CREATE TABLE IF NOT EXISTS activity_rollups (
+		date TEXT NOT NULL,
+		activity_type TEXT NOT NULL,
+		source TEXT NOT NULL,
+		count INTEGER NOT NULL,
+		unique_sessions INTEGER NOT NULL,
+		error_count INTEGER NOT NULL,
+		total_latency_ms INTEGER NOT NULL,
+		PRIMARY KEY (date, activity_type, source)
+	);
+	CREATE TABLE IF NOT EXISTS activity_rollup_days (
+		date TEXT PRIMARY KEY,
+		rolled_up_at DATETIME NOT NULL
+	);`,
+	`ALTER TABLE activities ADD COLUMN parent_request_id TEXT;
+	CREATE INDEX IF NOT EXISTS idx_request_id ON activities(request_id);`,
+	`CREATE TABLE IF NOT EXISTS activity_alerts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		metric TEXT NOT NULL,
+		expected REAL NOT NULL,
+		observed REAL NOT NULL,
+		window_start DATETIME NOT NULL,
+		created_at DATETIME NOT NULL,
+		acknowledged_at DATETIME
+	);`,
+	// Activities used to be stored with the offset of whatever clock
+	// logged them, or without one when defaulting to CURRENT_TIMESTAMP.
+	// created_at is compared as text, so convert them all to the UTC form
+	// the driver writes for UTC times.
+	`UPDATE activities
+	SET created_at = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', created_at), '0'), '.') || '+00:00'
+	WHERE created_at NOT LIKE '%+00:00';`,
+	`ALTER TABLE activities ADD COLUMN utm_campaign TEXT;
+	CREATE INDEX IF NOT EXISTS idx_utm_campaign ON activities(utm_campaign);`,
+	// Product IDs used to live in details only. Rows logged before are
+	// filled in by BackfillProducts.
+	`ALTER TABLE activities ADD COLUMN product_id TEXT;
+	CREATE INDEX IF NOT EXISTS idx_product_id ON activities(product_id, created_at);
+	CREATE TABLE IF NOT EXISTS activity_items (
+		activity_id INTEGER NOT NULL,
+		product_id TEXT NOT NULL,
+		quantity INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_activity_items_activity ON activity_items(activity_id);
+	CREATE INDEX IF NOT EXISTS idx_activity_items_product ON activity_items(product_id);
+	CREATE TRIGGER IF NOT EXISTS delete_activity_items AFTER DELETE ON activities
+	BEGIN
+		DELETE FROM activity_items WHERE activity_id = OLD.id;
+	END;`,
+	// Deleted sessions are hidden by setting deleted_at, which every read
+	// excludes, and removed once their grace period is over.
+	`ALTER TABLE activities ADD COLUMN deleted_at DATETIME;
+	CREATE INDEX IF NOT EXISTS idx_deleted_at ON activities(deleted_at) WHERE deleted_at IS NOT NULL;
+	CREATE TABLE IF NOT EXISTS admin_audit (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		operation TEXT NOT NULL,
+		filter TEXT NOT NULL,
+		affected INTEGER NOT NULL,
+		requester TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);`,
+	// The build and deployment that served each activity, so that stats can
+	// be compared across deploys
+	`ALTER TABLE activities ADD COLUMN version TEXT;
+	ALTER TABLE activities ADD COLUMN revision TEXT;
+	CREATE INDEX IF NOT EXISTS idx_version ON activities(version, created_at);`,
+	// The signed-in user of each activity, including those logged in the
+	// session before the user signed in
+	`ALTER TABLE activities ADD COLUMN user_id TEXT;
+	CREATE INDEX IF NOT EXISTS idx_user_id ON activities(user_id, created_at);`,
+	// Where each request was navigated from
+	`ALTER TABLE activities ADD COLUMN referrer_type TEXT;
+	ALTER TABLE activities ADD COLUMN referrer_domain TEXT;`,
+	sessionCountersMigration(),
+	// The mux route each request matched, so that activities can be
+	// aggregated per route rather than per path. Rows logged before, and
+	// unrouted requests, are left NULL.
+	`ALTER TABLE activities ADD COLUMN route_template TEXT;
+	CREATE INDEX IF NOT EXISTS idx_route_template ON activities(activity_type, route_template, created_at);`,
+	// The queue of activities to forward to external sinks, see outbox.go
+	outboxMigration,
+	// The feature flags each activity was served with, see flags.go
+	flagSetsMigration,
+	// Whether each activity rendered a page, redirected, failed or
+	// answered an API call, see responseclass.go
+	responseClassMigration,
+	// Which activity each session arrived with, see entry.go
+	entryMigration,
+	// The browser each activity came from, across sessions, see device.go
+	deviceMigration,
+	// Session counters that don't depend on this process to read details,
+	// see sessioncounters.go
+	recountSessionsMigration(),
+}
+
+const (
+	// readConns is how many read-only connections the database keeps
+	// open. WAL lets them read while the single writer commits.
+	readConns = 4
+	// busyTimeoutMs is how long a connection waits for a lock held by
+	// another, such as a checkpoint, before failing with SQLITE_BUSY
+	busyTimeoutMs = 5000
+)
+
 var (
-	db   *sql.DB
-	once sync.Once
+	// db writes, over a single connection, since SQLite has a single
+	// writer anyway; readDB is a pool of read-only connections. They are
+	// swapped atomically, as they can be opened while requests use them.
+	db     atomic.Pointer[sql.DB]
+	readDB atomic.Pointer[sql.DB]
+	// initMu serializes initializations, initialized is set once one
+	// succeeded
+	initMu      sync.Mutex
+	initialized bool
+	logger      logrus.FieldLogger = logrus.StandardLogger()
 )
 
 // ActivityLog represents a single activity entry
 type ActivityLog struct {
-	ID           int64     `json:"id"`
-	SessionID    string    `json:"session_id"`
-	RequestID    string    `json:"request_id"`
-	ActivityType string    `json:"activity_type"`
-	Path         string    `json:"path"`
-	Method       string    `json:"method"`
-	StatusCode   int       `json:"status_code"`
-	UserCurrency string    `json:"user_currency"`
-	Details      string    `json:"details"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID        int64  `json:"id"`
+	SessionID string `json:"session_id"`
+	// UserID is the user signed in to the session, empty for anonymous
+	// sessions.
+	UserID    string `json:"user_id,omitempty"`
+	RequestID string `json:"request_id"`
+	// ParentRequestID is the request that rendered the page this activity
+	// was triggered from, or that redirected to it, empty when unknown.
+	ParentRequestID string `json:"parent_request_id"`
+	ActivityType    string `json:"activity_type"`
+	Path            string `json:"path"`
+	// RouteTemplate is the mux path template the request matched, such as
+	// /product/{id}, empty for unrouted requests and for activities logged
+	// before it was recorded.
+	RouteTemplate string `json:"route_template,omitempty"`
+	Method        string `json:"method"`
+	StatusCode    int    `json:"status_code"`
+	// ResponseClass is what the response was: ResponseClassPage,
+	// ResponseClassRedirect, ResponseClassError or ResponseClassAPI. It is
+	// empty for activities without a status, and those logged before it
+	// was recorded.
+	ResponseClass string `json:"response_class,omitempty"`
+	UserCurrency  string `json:"user_currency"`
+	Source        string `json:"source"`
+	// Campaign is the utm_campaign of the link the session arrived
+	// through, empty for direct traffic.
+	Campaign string `json:"utm_campaign"`
+	// ProductID is the product viewed or added to the cart, empty for
+	// other activities.
+	ProductID string `json:"product_id,omitempty"`
+	// Items are the line items of a checkout. They are written along with
+	// the activity, but not read back with it.
+	Items []ActivityItem `json:"items,omitempty"`
+	// Version and Revision are the frontend build and deployment that
+	// served the activity, empty for activities logged before they were
+	// recorded.
+	Version  string `json:"version,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	// FlagsHash identifies the feature flags the frontend served the
+	// activity with, see GetFlagSets. It is empty for activities logged
+	// before they were recorded.
+	FlagsHash string `json:"flags_hash,omitempty"`
+	// ReferrerType is where the request was navigated from: another page
+	// of the shop, another site or nowhere. Of other sites only the
+	// registrable domain is kept as ReferrerDomain, never the whole URL.
+	ReferrerType   string `json:"referrer_type,omitempty"`
+	ReferrerDomain string `json:"referrer_domain,omitempty"`
+	// IsEntry is set on the first activity of a session, the request that
+	// started it, whose details tell where the session landed and came
+	// from.
+	IsEntry bool `json:"is_entry,omitempty"`
+	// DeviceID identifies the browser across its sessions, when the
+	// frontend sets a device cookie and the shopper allows tracking.
+	DeviceID  string    `json:"device_id,omitempty"`
+	LatencyMs int64     `json:"latency_ms"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ActivityItem is a line item of a checkout
+type ActivityItem struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
 }
 
+// defaultDataDir is where the database is kept unless InitDBAt or
+// InitDBLazily say otherwise, under the working directory
+const defaultDataDir = "data"
+
 // InitDB initializes the SQLite database connection and creates the schema
 func InitDB(log logrus.FieldLogger) error {
-	var err error
-	once.Do(func() {
-		// Create data directory if it doesn't exist
-		dataDir := "data"
-		if err = os.MkdirAll(dataDir, 0755); err != nil {
-			return
-		}
+	return InitDBAt(log, defaultDataDir)
+}
 
-		dbPath := filepath.Join(dataDir, dbFileName)
-		db, err = sql.Open("sqlite3", dbPath)
-		if err != nil {
-			return
-		}
+// InitDBAt is InitDB with the database kept in dataDir instead of the data
+// directory under the working directory. Once the database is initialized
+// further calls do nothing, after a failure the next call tries again.
+func InitDBAt(log logrus.FieldLogger, dataDir string) error {
+	initMu.Lock()
+	defer initMu.Unlock()
+	if initialized {
+		return nil
+	}
+
+	// Create data directory if it doesn't exist
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return err
+	}
 
-		// Enable WAL mode for better concurrent performance
-		if _, err = db.Exec("PRAGMA journal_mode=WAL"); err != nil {
-			return
+	dbPath := filepath.Join(dataDir, dbFileName)
+	err := useDB(dbPath)
+	if isCorruption(err) {
+		// Start over rather than fail to start at all
+		corruptions.Add(1)
+		var movedTo string
+		if movedTo, err = moveAside(dbPath, Now().UTC()); err != nil {
+			return err
 		}
+		log.WithField("moved_to", movedTo).Error("ACTIVITY DATABASE CORRUPT, starting with an empty one")
+		err = useDB(dbPath)
+	}
+	if err != nil {
+		return err
+	}
 
-		// Create tables
-		if _, err = db.Exec(schema); err != nil {
-			return
+	initialized = true
+	lazyInit.pending.Store(false)
+	logger = log
+	log.Infof("Activity logging database initialized at: %s", dbPath)
+	// Catch queries that stopped using their index before the table
+	// grows large enough for it to hurt
+	CheckQueryPlans(log)
+	return nil
+}
+
+// useDB opens the database at dbPath for writing and for reading, and makes
+// the package use it
+func useDB(dbPath string) error {
+	conn, err := openDB(dbPath)
+	if err != nil {
+		return err
+	}
+	reader, err := openReadDB(dbPath)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	p, err := loadPartitions(conn)
+	if err != nil {
+		conn.Close()
+		reader.Close()
+		return err
+	}
+	db.Store(conn)
+	readDB.Store(reader)
+	partitions.Store(p)
+	forgetSessionRows()
+	return nil
+}
+
+// openDB opens the SQLite database at dbPath for writing and creates the
+// schema
+func openDB(dbPath string) (*sql.DB, error) {
+	conn, err := sql.Open(sqliteDriver, sqliteDSN(dbPath, dsnOptions{busyTimeoutMs: busyTimeoutMs}))
+	if err != nil {
+		return nil, err
+	}
+	// More connections would only queue for the write lock inside SQLite,
+	// where waiting isn't fair and ends with SQLITE_BUSY
+	conn.SetMaxOpenConns(1)
+
+	// Enable WAL mode for better concurrent performance
+	if _, err := conn.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Create tables, unless activities was split by month into a view the
+	// schema can't index
+	partitioned, err := isPartitioned(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !partitioned {
+		if _, err := conn.Exec(schema); err != nil {
+			conn.Close()
+			return nil, err
 		}
+	}
+	if err := migrate(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
 
-		log.Infof("Activity logging database initialized at: %s", dbPath)
-	})
-	return err
+// openReadDB opens a pool of read-only connections to the database at
+// dbPath, which openDB has created
+func openReadDB(dbPath string) (*sql.DB, error) {
+	conn, err := sql.Open(sqliteDriver, sqliteDSN(dbPath, dsnOptions{readOnly: true, busyTimeoutMs: busyTimeoutMs}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetMaxOpenConns(readConns)
+	conn.SetMaxIdleConns(readConns)
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
 }
 
-// GetDB returns the database instance
+// migrate applies the migrations the database hasn't seen yet
+func migrate(conn *sql.DB) error {
+	var version int
+	if err := conn.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	p, err := loadPartitions(conn)
+	if err != nil {
+		return err
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := conn.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(partitionedMigration(migrations[i], p)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("schema migration %d: %w", i+1, err)
+		}
+		// PRAGMA doesn't accept placeholders
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDB returns the database instance. It is the one to write through;
+// reads that may take a while go through getReadDB.
 func GetDB() *sql.DB {
-	return db
+	return db.Load()
 }
 
-// CloseDB closes the database connection
+// getReadDB returns the read-only pool, or the database instance when
+// there is none. Like writes, it initializes the database on first use
+// when InitDB wasn't called.
+func getReadDB() *sql.DB {
+	// A failure leaves no database, as before InitDB, and is reported by
+	// Status
+	ensureDB()
+	if reader := readDB.Load(); reader != nil {
+		return reader
+	}
+	return db.Load()
+}
+
+// SchemaVersion returns the number of schema migrations applied to the
+// activities database
+func SchemaVersion() (int, error) {
+	var version int
+	err := GetDB().QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// CloseDB closes the database connections
 func CloseDB() error {
-	if db != nil {
-		return db.Close()
+	if reader := readDB.Load(); reader != nil {
+		reader.Close()
+	}
+	if conn := db.Load(); conn != nil {
+		return conn.Close()
 	}
 	return nil
-}
\ No newline at end of file
+}
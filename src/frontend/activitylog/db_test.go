@@ -0,0 +1,267 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupTestDB points the package at a fresh database in a temporary
+// directory and installs a fake clock, restoring both when the test ends.
+func setupTestDB(t testing.TB) *FakeClock {
+	t.Helper()
+	return setupTestDBIn(t, t.TempDir())
+}
+
+// setupTestDBIn is setupTestDB with the database in dir
+func setupTestDBIn(t testing.TB, dir string) *FakeClock {
+	t.Helper()
+	if err := useDB(filepath.Join(dir, dbFileName)); err != nil {
+		t.Fatalf("useDB() failed: %v", err)
+	}
+	fc := NewFakeClock(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	SetClock(fc)
+	ConfigureWriteBreaker(0, 0)
+	ConfigureOverload(0, 0)
+	productViewCache.entries = make(map[productViewKey]productViewEntry)
+	lastWrite.at, lastWrite.err = time.Time{}, nil
+	readOnly.Store(false)
+	t.Cleanup(func() {
+		SetClock(nil)
+		CloseDB()
+		db.Store(nil)
+		readDB.Store(nil)
+	})
+	return fc
+}
+
+func TestSchemaVersion(t *testing.T) {
+	setupTestDB(t)
+	version, err := SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion() failed: %v", err)
+	}
+	if version != len(migrations) {
+		t.Errorf("SchemaVersion() = %d, want %d", version, len(migrations))
+	}
+}
+
+func TestOpenDBIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), dbFileName)
+	for i := 0; i < 2; i++ {
+		conn, err := openDB(path)
+		if err != nil {
+			t.Fatalf("openDB() #%d failed: %v", i+1, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestOpenDBMigratesExistingDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), dbFileName)
+
+	// A database created before any migrations existed
+	old, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	if _, err := old.Exec(schema); err != nil {
+		t.Fatalf("creating the initial schema failed: %v", err)
+	}
+	if _, err := old.Exec(`INSERT INTO activities (session_id, request_id, activity_type, path, method)
+		VALUES ('s', 'r', 'page_view', '/', 'GET')`); err != nil {
+		t.Fatalf("inserting an old activity failed: %v", err)
+	}
+	old.Close()
+
+	conn, err := openDB(path)
+	if err != nil {
+		t.Fatalf("openDB() failed: %v", err)
+	}
+	defer conn.Close()
+
+	var version int
+	if err := conn.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatalf("reading user_version failed: %v", err)
+	}
+	if version != len(migrations) {
+		t.Errorf("user_version = %d, want %d", version, len(migrations))
+	}
+	var source sql.NullString
+	if err := conn.QueryRow("SELECT source FROM activities").Scan(&source); err != nil {
+		t.Fatalf("reading the migrated activity failed: %v", err)
+	}
+	if source.Valid {
+		t.Errorf("source of an old activity = %q, want NULL", source.String)
+	}
+}
+
+func TestOpenDBNormalizesTimestampsToUTC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), dbFileName)
+	// A database created before timestamps were normalized, by the fifth
+	// migration
+	all := migrations
+	migrations = all[:4]
+	conn, err := openDB(path)
+	migrations = all
+	if err != nil {
+		t.Fatalf("openDB() failed: %v", err)
+	}
+	for _, createdAt := range []string{
+		"2025-06-01 14:00:00.25+02:00",
+		"2025-06-01 07:00:10-05:00",
+		"2025-06-01 12:00:20",
+		"2025-06-01 12:00:30.5+00:00",
+	} {
+		if _, err := conn.Exec(`INSERT INTO activities (session_id, request_id, activity_type, path, method, created_at)
+			VALUES ('s', 'r', 'page_view', '/', 'GET', ?)`, createdAt); err != nil {
+			t.Fatalf("inserting an old activity failed: %v", err)
+		}
+	}
+	conn.Close()
+
+	if conn, err = openDB(path); err != nil {
+		t.Fatalf("openDB() failed: %v", err)
+	}
+	defer conn.Close()
+	rows, err := conn.Query("SELECT CAST(created_at AS TEXT) FROM activities ORDER BY id")
+	if err != nil {
+		t.Fatalf("reading the migrated activities failed: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			t.Fatalf("reading the migrated activities failed: %v", err)
+		}
+		got = append(got, s)
+	}
+	want := []string{
+		"2025-06-01 12:00:00.25+00:00",
+		"2025-06-01 12:00:10+00:00",
+		"2025-06-01 12:00:20+00:00",
+		"2025-06-01 12:00:30.5+00:00",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("migrated created_at = %q, want %q", got, want)
+	}
+	// Which is how the driver writes them
+	for i, s := range want {
+		parsed, _ := time.Parse("2006-01-02 15:04:05.999999999-07:00", s)
+		if f := parsed.UTC().Format(timestampFormat); f != s {
+			t.Errorf("created_at #%d = %q, the driver writes %q", i+1, s, f)
+		}
+	}
+}
+
+func TestReadPoolIsReadOnly(t *testing.T) {
+	setupTestDB(t)
+	mustLog(t, &ActivityLog{SessionID: "session-1", ActivityType: ActivityTypePageView})
+
+	if _, err := getReadDB().Exec("DELETE FROM activities"); err == nil {
+		t.Error("deleting through the read pool succeeded, want it read-only")
+	}
+	// Writes are visible to reads as soon as they are committed
+	if got, err := GetRecentActivities(10); err != nil || len(got) != 1 {
+		t.Errorf("GetRecentActivities() = %d activities, %v, want the one logged", len(got), err)
+	}
+
+	stats, err := GetDBStats(context.Background())
+	if err != nil {
+		t.Fatalf("GetDBStats() failed: %v", err)
+	}
+	if stats.WritePool.MaxOpen != 1 || stats.ReadPool.MaxOpen != readConns || stats.ReadPool.Open == 0 {
+		t.Errorf("GetDBStats() pools = %+v and %+v, want 1 writer and %d readers", stats.WritePool, stats.ReadPool, readConns)
+	}
+}
+
+// BenchmarkReadsDuringBatchCommits reads activity stats while batches of
+// activities are committed in the background, through the read pool and,
+// as before it existed, through the writer's connection.
+func BenchmarkReadsDuringBatchCommits(b *testing.B) {
+	setupTestDB(b)
+	commit := func(day string) error {
+		batch := make([]string, 500)
+		for i := range batch {
+			batch[i] = fmt.Sprintf("('s%d', 'r', '%s', '/', 'GET', 200, 'USD', '{}', '%s 11:%02d:00+00:00')",
+				i%50, ActivityTypePageView, day, i%60)
+		}
+		tx, err := GetDB().Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec(`INSERT INTO activities (session_id, request_id, activity_type, path, method, status_code, user_currency, details, created_at)
+			VALUES ` + strings.Join(batch, ", ")); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if err := commit("2025-06-01"); err != nil {
+		b.Fatal(err)
+	}
+	filter := Filter{Start: Now().Add(-2 * time.Hour), End: Now()}
+
+	for _, tt := range []struct {
+		name   string
+		reader *sql.DB
+	}{
+		{"read_pool", readDB.Load()},
+		{"writer", db.Load()},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			defer func(pool *sql.DB) { readDB.Store(pool) }(readDB.Load())
+			readDB.Store(tt.reader)
+
+			stop := make(chan struct{})
+			done := make(chan error)
+			go func() {
+				for {
+					select {
+					case <-stop:
+						done <- nil
+						return
+					default:
+					}
+					// Outside the period read, so that reads don't slow
+					// down as the batches pile up
+					if err := commit("2025-05-01"); err != nil {
+						done <- err
+						return
+					}
+				}
+			}()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := GetActivityStats(context.Background(), filter); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			close(stop)
+			if err := <-done; err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSnapshotInProgress is returned by SnapshotDB while the previous
+// snapshot is still being taken or read
+var ErrSnapshotInProgress = errors.New("activitylog: a snapshot is already in progress")
+
+// snapshotting is set from the start of a snapshot until it is closed, so
+// that at most one copy of the database takes up disk space at a time
+var snapshotting atomic.Bool
+
+// DBSnapshot is a consistent copy of the activities database as it was at
+// TakenAt, read like the file it is. Closing it removes the copy.
+type DBSnapshot struct {
+	*os.File
+	// Size is the size of the copy in bytes
+	Size    int64
+	TakenAt time.Time
+
+	dir       string
+	closeOnce sync.Once
+}
+
+// SnapshotDB copies the database with VACUUM INTO, over a read-only
+// connection, so that writes go on while it is copied and the copy is a
+// valid database, unlike a copy of the live file. The copy is written next
+// to the database, which has room for it, and removed by Close. Only one
+// snapshot is taken at a time, others fail with ErrSnapshotInProgress.
+func SnapshotDB(ctx context.Context) (*DBSnapshot, error) {
+	if !snapshotting.CompareAndSwap(false, true) {
+		return nil, ErrSnapshotInProgress
+	}
+	s, err := takeDBSnapshot(ctx)
+	if err != nil {
+		snapshotting.Store(false)
+	}
+	return s, err
+}
+
+func takeDBSnapshot(ctx context.Context) (*DBSnapshot, error) {
+	if GetDB() == nil {
+		return nil, ErrNotInitialized
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	path, err := databaseFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	s := &DBSnapshot{TakenAt: Now().UTC(), dir: dir}
+	target := filepath.Join(dir, dbFileName)
+	start := time.Now()
+	_, err = getReadDB().ExecContext(ctx, "VACUUM INTO ?", target)
+	observeQuery(ctx, "snapshot", start)
+	if err == nil {
+		s.File, err = os.Open(target)
+	}
+	if err == nil {
+		var info os.FileInfo
+		if info, err = s.Stat(); err == nil {
+			s.Size = info.Size()
+		}
+	}
+	if err != nil {
+		if s.File != nil {
+			s.File.Close()
+		}
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes and removes the copy, letting the next snapshot be taken
+func (s *DBSnapshot) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		err = s.File.Close()
+		if rmErr := os.RemoveAll(s.dir); err == nil {
+			err = rmErr
+		}
+		snapshotting.Store(false)
+	})
+	return err
+}
@@ -0,0 +1,74 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotDB(t *testing.T) {
+	setupTestDB(t)
+	for i := 0; i < 3; i++ {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Path: "/"})
+	}
+
+	s, err := SnapshotDB(context.Background())
+	if err != nil {
+		t.Fatalf("SnapshotDB() failed: %v", err)
+	}
+	defer s.Close()
+	if _, err := SnapshotDB(context.Background()); !errors.Is(err, ErrSnapshotInProgress) {
+		t.Errorf("second SnapshotDB() = %v, want ErrSnapshotInProgress", err)
+	}
+	// Activities logged while it is read aren't in it
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Path: "/"})
+
+	path := filepath.Join(t.TempDir(), "copy.db")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := io.Copy(f, s); err != nil || n != s.Size {
+		t.Fatalf("copied %d bytes, %v; want %d", n, err, s.Size)
+	}
+	f.Close()
+	copied, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	var count int
+	if err := copied.QueryRow("SELECT COUNT(*) FROM activities").Scan(&count); err != nil || count != 3 {
+		t.Errorf("snapshot holds %d activities, %v; want the 3 logged before it", count, err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if _, err := os.Stat(s.Name()); !os.IsNotExist(err) {
+		t.Errorf("snapshot file left behind: %v", err)
+	}
+	next, err := SnapshotDB(context.Background())
+	if err != nil {
+		t.Fatalf("SnapshotDB() after Close() failed: %v", err)
+	}
+	next.Close()
+}
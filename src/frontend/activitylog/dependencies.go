@@ -0,0 +1,79 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"time"
+)
+
+// ResultFailed is the result detail of cart adds that didn't add anything
+const ResultFailed = "failed"
+
+// failedAttempt is true for activities that didn't go through: checkouts
+// marked failed and cart adds whose result is ResultFailed
+var failedAttempt = `(activity_type = '` + ActivityTypeCheckout + `' AND COALESCE(json_extract(` + detailsJSON + `, '$.failed'), 0)
+	OR activity_type = '` + ActivityTypeAddToCart + `' AND COALESCE(json_extract(` + detailsJSON + `, '$.result') = '` + ResultFailed + `', 0))`
+
+// DependencyFailureCount is the number of activities of a type during which
+// a backend service call failed with a gRPC code
+type DependencyFailureCount struct {
+	Service      string `json:"service"`
+	ActivityType string `json:"activity_type"`
+	Code         string `json:"code"`
+	Count        int    `json:"count"`
+}
+
+// GetDependencyFailures returns the number of activities of a given time
+// period during which the cart service failed, recorded by the cart_service_ok
+// and cart_service_code details, per activity type and gRPC code, most
+// frequent first
+func GetDependencyFailures(ctx context.Context, startTime, endTime time.Time) ([]DependencyFailureCount, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "dependency failures", time.Now())
+
+	query := `
+		SELECT activity_type, code, COUNT(*) AS count
+		FROM (
+			SELECT activity_type, COALESCE(json_extract(details, '$.cart_service_code'), '') AS code
+			FROM (
+				SELECT activity_type, CASE WHEN json_valid(` + detailsJSON + `) THEN ` + detailsJSON + ` END AS details
+				FROM activities
+				WHERE ` + createdIn("created_at") + ` AND deleted_at IS NULL
+			)
+			WHERE json_extract(details, '$.cart_service_ok') = 0
+		)
+		GROUP BY activity_type, code
+		ORDER BY count DESC, activity_type, code`
+	rows, err := getReadDB().QueryContext(ctx, query, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := []DependencyFailureCount{}
+	for rows.Next() {
+		f := DependencyFailureCount{Service: "cart"}
+		if err := rows.Scan(&f.ActivityType, &f.Code, &f.Count); err != nil {
+			return nil, err
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
@@ -0,0 +1,50 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetDependencyFailures(t *testing.T) {
+	fc := setupTestDB(t)
+	for _, a := range []ActivityLog{
+		{ActivityType: ActivityTypeViewCart, Details: `{"cart_service_ok":false,"cart_service_code":"Unavailable"}`},
+		{ActivityType: ActivityTypeViewCart, Details: `{"cart_service_ok":false,"cart_service_code":"Unavailable"}`},
+		{ActivityType: ActivityTypeAddToCart, Details: `{"result":"failed","cart_service_ok":false,"cart_service_code":"DeadlineExceeded"}`},
+		{ActivityType: ActivityTypeEmptyCart, Details: `{"cart_service_ok":false}`},
+		// Requests the cart service served don't count
+		{ActivityType: ActivityTypeViewCart, Details: `{"cart_items":2}`},
+		{ActivityType: ActivityTypeViewCart, Details: `not json`},
+	} {
+		mustLog(t, &a)
+	}
+
+	got, err := GetDependencyFailures(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetDependencyFailures() failed: %v", err)
+	}
+	want := []DependencyFailureCount{
+		{Service: "cart", ActivityType: ActivityTypeViewCart, Code: "Unavailable", Count: 2},
+		{Service: "cart", ActivityType: ActivityTypeAddToCart, Code: "DeadlineExceeded", Count: 1},
+		{Service: "cart", ActivityType: ActivityTypeEmptyCart, Code: "", Count: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetDependencyFailures() = %+v, want %+v", got, want)
+	}
+}
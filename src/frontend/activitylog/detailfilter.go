@@ -0,0 +1,97 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MaxDetailFilters is how many detail keys a filter can match on. Each is
+// a scan of the details of every activity in range, which no index helps.
+const MaxDetailFilters = 3
+
+// detailKey are the detail keys a filter can match on: top-level keys, so
+// that they can be put in a JSON path as they are
+var detailKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,63}$`)
+
+// ValidateDetailKey checks that a filter can match on a detail key
+func ValidateDetailKey(key string) error {
+	if !detailKey.MatchString(key) {
+		return fmt.Errorf("invalid detail key %q, must be a letter followed by up to 63 letters, digits or underscores", key)
+	}
+	return nil
+}
+
+// detailClauses returns the conditions matching details, and the arguments
+// they reference. Values compare as text, so that "2" matches the number
+// 2 as well as the string, and "true" and "false" match booleans.
+func detailClauses(details map[string]string) ([]string, []interface{}) {
+	keys := make([]string, 0, len(details))
+	for key := range details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var clauses []string
+	var args []interface{}
+	for _, key := range keys {
+		path := "$." + key
+		clauses = append(clauses, "(CASE json_type("+detailsJSON+", ?) WHEN 'true' THEN 'true' WHEN 'false' THEN 'false'"+
+			" ELSE CAST(json_extract("+detailsJSON+", ?) AS TEXT) END) = ?")
+		args = append(args, path, path, details[key])
+	}
+	noteDetailKeys(keys)
+	return clauses, args
+}
+
+// detailKeySuggestAfter is how many queries in an hour can match on a
+// detail key before promoting it to a column is suggested
+var detailKeySuggestAfter = 100
+
+// detailKeyUsage counts the queries matching on each detail key in the
+// current hour, and the keys a promotion was suggested for in it
+var detailKeyUsage = struct {
+	sync.Mutex
+	hour      time.Time
+	queries   map[string]int
+	suggested map[string]bool
+}{}
+
+// noteDetailKeys counts a query matching on keys, and logs a suggestion to
+// promote those queried more than detailKeySuggestAfter times this hour to
+// a column, once an hour
+func noteDetailKeys(keys []string) {
+	hour := Now().UTC().Truncate(time.Hour)
+	detailKeyUsage.Lock()
+	defer detailKeyUsage.Unlock()
+	if !hour.Equal(detailKeyUsage.hour) {
+		detailKeyUsage.hour = hour
+		detailKeyUsage.queries = make(map[string]int)
+		detailKeyUsage.suggested = make(map[string]bool)
+	}
+	for _, key := range keys {
+		detailKeyUsage.queries[key]++
+		if n := detailKeyUsage.queries[key]; n > detailKeySuggestAfter && !detailKeyUsage.suggested[key] {
+			detailKeyUsage.suggested[key] = true
+			logger.WithFields(logrus.Fields{"detail_key": key, "queries": n}).
+				Warn("activities are often filtered on a detail, consider promoting it to an indexed column")
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestFilterByDetail(t *testing.T) {
+	setupTestDB(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeProductView, Details: `{"product_id":"OLJCESPC7Z"}`})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeProductView, Details: `{"product_id":"66VCHSJNUP"}`})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeAddToCart, Details: `{"product_id":"OLJCESPC7Z","quantity":"2","quantity_int":2,"suspicious":false}`})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeAddToCart, Details: `{"product_id":"OLJCESPC7Z","quantity":"10","quantity_int":10,"suspicious":true}`})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	// Compressed details are matched as well
+	useDetailsCodec(t, DetailsCodecGzip, 0)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeProductView, Details: `{"product_id":"OLJCESPC7Z","raw_path":"/` + strings.Repeat("a", 1000) + `"}`})
+
+	tests := []struct {
+		details map[string]string
+		want    map[string]int
+	}{
+		{map[string]string{"product_id": "OLJCESPC7Z"}, map[string]int{ActivityTypeProductView: 2, ActivityTypeAddToCart: 2}},
+		{map[string]string{"product_id": "OLJCESPC7Z", "suspicious": "true"}, map[string]int{ActivityTypeAddToCart: 1}},
+		{map[string]string{"suspicious": "false"}, map[string]int{ActivityTypeAddToCart: 1}},
+		// Numbers match whether they were stored as strings or numbers
+		{map[string]string{"quantity": "2"}, map[string]int{ActivityTypeAddToCart: 1}},
+		{map[string]string{"quantity_int": "10"}, map[string]int{ActivityTypeAddToCart: 1}},
+		// Activities without the key don't match, even an empty value
+		{map[string]string{"currency_code": ""}, map[string]int{}},
+		{map[string]string{"product_id": "OLJCESPC7Z", "quantity": "2", "cart_items": "1"}, map[string]int{}},
+	}
+	for _, tt := range tests {
+		got, err := GetActivityStats(context.Background(), Filter{Details: tt.details})
+		if err != nil {
+			t.Fatalf("GetActivityStats(%v) failed: %v", tt.details, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetActivityStats(%v) = %v, want %v", tt.details, got, tt.want)
+		}
+	}
+
+	activities, err := GetActivities(Filter{Details: map[string]string{"quantity": "10"}}, 10)
+	if err != nil {
+		t.Fatalf("GetActivities() failed: %v", err)
+	}
+	if len(activities) != 1 || !strings.Contains(activities[0].Details, `"quantity":"10"`) {
+		t.Errorf("GetActivities() = %+v, want the cart add of 10", activities)
+	}
+}
+
+func TestFilterValidatesDetailKeys(t *testing.T) {
+	for key, valid := range map[string]bool{
+		"product_id":            true,
+		"Quantity2":             true,
+		strings.Repeat("a", 64): true,
+		strings.Repeat("a", 65): false,
+		"":                      false,
+		"2fa":                   false,
+		"tags.tier":             false,
+		"a-b":                   false,
+		`x') OR 1=1 --`:         false,
+	} {
+		err := Filter{Details: map[string]string{key: "v"}}.Validate()
+		if (err == nil) != valid {
+			t.Errorf("Validate() with detail key %q = %v, want valid %v", key, err, valid)
+		}
+	}
+	if err := (Filter{Details: map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}}).Validate(); err == nil {
+		t.Errorf("Validate() with %d detail keys succeeded, want at most %d", 4, MaxDetailFilters)
+	}
+}
+
+func TestDetailKeyPromotionSuggested(t *testing.T) {
+	setupTestDB(t)
+	defer func(l logrus.FieldLogger, n int) { logger, detailKeySuggestAfter = l, n }(logger, detailKeySuggestAfter)
+	var hook *test.Hook
+	logger, hook = test.NewNullLogger()
+	detailKeySuggestAfter = 2
+	// Starting the hour over, whatever other tests queried
+	detailKeyUsage.hour = time.Time{}
+
+	filter := Filter{Details: map[string]string{"product_id": "OLJCESPC7Z"}}
+	for i := 0; i < 5; i++ {
+		if _, err := GetActivities(filter, 10); err != nil {
+			t.Fatalf("GetActivities() failed: %v", err)
+		}
+	}
+	var suggestions []string
+	for _, e := range hook.AllEntries() {
+		if key, ok := e.Data["detail_key"]; ok {
+			suggestions = append(suggestions, key.(string))
+		}
+	}
+	if !reflect.DeepEqual(suggestions, []string{"product_id"}) {
+		t.Errorf("suggested promoting %v, want product_id once", suggestions)
+	}
+}
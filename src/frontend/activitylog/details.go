@@ -0,0 +1,253 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidDetails is returned when logging an activity whose details
+// don't fit the details of its type, such as a quantity that isn't a number
+var ErrInvalidDetails = errors.New("activitylog: details don't match the activity type")
+
+// CommonDetails are the details any activity can have. The typed details
+// of each activity type embed them.
+type CommonDetails struct {
+	// UTMSource, UTMMedium and UTMCampaign are the utm_* parameters the
+	// request came with
+	UTMSource   string `json:"utm_source,omitempty"`
+	UTMMedium   string `json:"utm_medium,omitempty"`
+	UTMCampaign string `json:"utm_campaign,omitempty"`
+	// Tags are the values of the tag cookies, by cookie
+	Tags map[string]string `json:"tags,omitempty"`
+	// Experiments are the variants the session was assigned, by experiment
+	Experiments map[string]string `json:"experiments,omitempty"`
+	// RawInvalid keeps, escaped, the values that were rejected
+	RawInvalid map[string]string `json:"raw_invalid,omitempty"`
+	// RawPath is the path as sent of a failed request no route served
+	RawPath string `json:"raw_path,omitempty"`
+	// Error is what failed, for server errors, and Handler the frontend
+	// handler it failed in
+	Error   string `json:"error,omitempty"`
+	Handler string `json:"handler,omitempty"`
+	// ErrorSnippet is the start of a server error response
+	ErrorSnippet string `json:"error_snippet,omitempty"`
+	// LandingPath and TrafficSource are only kept on the entry activity
+	// of a session: the path as sent it arrived on, and its utm_source,
+	// else the domain or type of its referrer
+	LandingPath   string `json:"landing_path,omitempty"`
+	TrafficSource string `json:"traffic_source,omitempty"`
+}
+
+// CartServiceDetails record that the cart service failed a request
+type CartServiceDetails struct {
+	// CartServiceOK is false when the cart service failed
+	CartServiceOK *bool `json:"cart_service_ok,omitempty"`
+	// CartServiceCode is the gRPC code it failed with
+	CartServiceCode string `json:"cart_service_code,omitempty"`
+}
+
+// ProductViewDetails are the details of a product_view
+type ProductViewDetails struct {
+	CommonDetails
+	ProductID string `json:"product_id,omitempty"`
+	// PopularityBadgeShown is whether the page showed how many sessions
+	// viewed the product, unset when the badge is disabled
+	PopularityBadgeShown *bool `json:"popularity_badge_shown,omitempty"`
+	// ProductUnavailable is set when the product couldn't be retrieved
+	ProductUnavailable bool `json:"product_unavailable,omitempty"`
+}
+
+// AddToCartDetails are the details of an add_to_cart
+type AddToCartDetails struct {
+	CommonDetails
+	CartServiceDetails
+	ProductID string `json:"product_id,omitempty"`
+	// RawQuantity is the quantity as sent, Quantity the number it is,
+	// capped to MaxQuantity. Quantity is unset when RawQuantity isn't a
+	// number.
+	RawQuantity string `json:"quantity,omitempty"`
+	Quantity    *int   `json:"quantity_int,omitempty"`
+	// Suspicious is set when the quantity was out of range
+	Suspicious bool `json:"suspicious,omitempty"`
+	// Result is ResultFailed when the product couldn't be added
+	Result string `json:"result,omitempty"`
+	// CartItems, CartSubtotal and CartCurrency are the cart after the
+	// add, when cart value details are enabled
+	CartItems    *int     `json:"cart_items,omitempty"`
+	CartSubtotal *float64 `json:"cart_subtotal,omitempty"`
+	CartCurrency string   `json:"cart_currency,omitempty"`
+}
+
+// ViewCartDetails are the details of a view_cart
+type ViewCartDetails struct {
+	CommonDetails
+	CartServiceDetails
+	// CartItems is the number of items in the cart
+	CartItems *int `json:"cart_items,omitempty"`
+}
+
+// EmptyCartDetails are the details of an empty_cart
+type EmptyCartDetails struct {
+	CommonDetails
+	CartServiceDetails
+}
+
+// CheckoutDetails are the details of a checkout. The products ordered are
+// the Items of the activity.
+type CheckoutDetails struct {
+	CommonDetails
+	// Country is the country the order ships to
+	Country string `json:"country,omitempty"`
+	// Failed is set when no order was placed, FailureReason and
+	// FailureCode telling why
+	Failed        bool   `json:"failed,omitempty"`
+	FailureReason string `json:"failure_reason,omitempty"`
+	FailureCode   string `json:"failure_code,omitempty"`
+	// ShippingCost and OrderTotal are amounts of ShippingCurrency and
+	// OrderCurrency, the total including shipping
+	ShippingCost     *float64 `json:"shipping_cost,omitempty"`
+	ShippingCurrency string   `json:"shipping_currency,omitempty"`
+	OrderTotal       *float64 `json:"order_total,omitempty"`
+	OrderCurrency    string   `json:"order_currency,omitempty"`
+}
+
+// CurrencyChangeDetails are the details of a currency_change
+type CurrencyChangeDetails struct {
+	CommonDetails
+	NewCurrency string `json:"new_currency,omitempty"`
+	// PreviousCurrency is empty when the default currency was shown
+	PreviousCurrency string `json:"previous_currency"`
+}
+
+// AssistantMessageDetails are the details of an assistant_message
+type AssistantMessageDetails struct {
+	CommonDetails
+	// MessageLength is the length of the message in characters
+	MessageLength *int   `json:"message_length,omitempty"`
+	Intent        string `json:"intent,omitempty"`
+	HasImage      *bool  `json:"has_image,omitempty"`
+	// Message is the text itself, only kept when configured to
+	Message string `json:"message,omitempty"`
+	// AssistantMs is how long the assistant took to answer
+	AssistantMs *float64 `json:"assistant_ms,omitempty"`
+}
+
+// RenderErrorDetails are the details of a render_error: Error is what the
+// template failed with
+type RenderErrorDetails struct {
+	CommonDetails
+	// Template is the name of the template that failed
+	Template string `json:"template,omitempty"`
+}
+
+// typedDetails returns new typed details for each activity type that has
+// them
+var typedDetails = map[string]func() interface{}{
+	ActivityTypeProductView:      func() interface{} { return new(ProductViewDetails) },
+	ActivityTypeAddToCart:        func() interface{} { return new(AddToCartDetails) },
+	ActivityTypeViewCart:         func() interface{} { return new(ViewCartDetails) },
+	ActivityTypeEmptyCart:        func() interface{} { return new(EmptyCartDetails) },
+	ActivityTypeCheckout:         func() interface{} { return new(CheckoutDetails) },
+	ActivityTypeCurrencyChange:   func() interface{} { return new(CurrencyChangeDetails) },
+	ActivityTypeAssistantMessage: func() interface{} { return new(AssistantMessageDetails) },
+	ActivityTypeRenderError:      func() interface{} { return new(RenderErrorDetails) },
+}
+
+// MarshalDetails encodes details, typically typed ones, for the Details of
+// an activity. Details without any value encode to nothing.
+func MarshalDetails(details interface{}) (string, error) {
+	b, err := json.Marshal(details)
+	if err != nil {
+		return "", err
+	}
+	if s := string(b); s != "{}" && s != "null" {
+		return s, nil
+	}
+	return "", nil
+}
+
+// UnmarshalDetails decodes the Details of an activity of activityType: to
+// a pointer to its typed details, such as *CheckoutDetails, or to a
+// map[string]interface{} for types without typed details. Keys the typed
+// details don't know, such as durations, are left out of them. Empty
+// details decode to empty typed details, or a nil map.
+func UnmarshalDetails(activityType, raw string) (interface{}, error) {
+	newDetails, typed := typedDetails[activityType]
+	if !typed {
+		var details map[string]interface{}
+		if raw == "" {
+			return details, nil
+		}
+		err := json.Unmarshal([]byte(raw), &details)
+		return details, err
+	}
+	details := newDetails()
+	if raw == "" {
+		return details, nil
+	}
+	if err := json.Unmarshal([]byte(raw), details); err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// validateDetails checks that the details of an activity fit its typed
+// details. Types without typed details take any details, and so do the
+// others when their details aren't JSON, as activities always could: what
+// is rejected is a known detail of the wrong type.
+func validateDetails(activityType, raw string) error {
+	if _, typed := typedDetails[activityType]; !typed || !json.Valid([]byte(raw)) {
+		return nil
+	}
+	if _, err := UnmarshalDetails(activityType, raw); err != nil {
+		return fmt.Errorf("%w: %s details: %v", ErrInvalidDetails, activityType, err)
+	}
+	return nil
+}
+
+// detailsMap returns typed details as the map the middleware builds details
+// in, without the values that aren't set
+func detailsMap(details interface{}) map[string]interface{} {
+	b, err := json.Marshal(details)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil
+	}
+	return m
+}
+
+// appendTypedDetails appends the details of a to b as the JSON of their
+// typed details. Details that don't fit them, logged before they were
+// checked, are appended as they are when JSON and as a string otherwise.
+func appendTypedDetails(b []byte, a *ActivityLog) []byte {
+	if a.Details == "" {
+		return append(b, "null"...)
+	}
+	if details, err := UnmarshalDetails(a.ActivityType, a.Details); err == nil {
+		if typed, err := json.Marshal(details); err == nil {
+			return append(b, typed...)
+		}
+	}
+	if json.Valid([]byte(a.Details)) {
+		return append(b, a.Details...)
+	}
+	return appendJSONString(b, a.Details)
+}
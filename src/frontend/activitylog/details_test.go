@@ -0,0 +1,233 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var updateDetails = flag.Bool("update", false, "update the golden details in testdata/details")
+
+// sampleDetails are typed details of each activity type with every field
+// set, whose encoding testdata/details pins
+func sampleDetails() map[string]interface{} {
+	yes, no := true, false
+	n, amount := 3, 42.5
+	common := CommonDetails{
+		UTMSource:     "newsletter",
+		UTMMedium:     "email",
+		UTMCampaign:   "spring",
+		Tags:          map[string]string{"loyalty_tier": "gold"},
+		Experiments:   map[string]string{"checkout_button": "green"},
+		RawInvalid:    map[string]string{"user_currency": "XX"},
+		RawPath:       "/%2e%2e/etc",
+		Error:         "could not retrieve cart",
+		Handler:       "viewCartHandler",
+		ErrorSnippet:  "<h1>Error</h1>",
+		LandingPath:   "/product/OLJCESPC7Z",
+		TrafficSource: "newsletter",
+	}
+	cartService := CartServiceDetails{CartServiceOK: &no, CartServiceCode: "Unavailable"}
+	return map[string]interface{}{
+		ActivityTypeProductView: &ProductViewDetails{
+			CommonDetails:        common,
+			ProductID:            "OLJCESPC7Z",
+			PopularityBadgeShown: &yes,
+			ProductUnavailable:   true,
+		},
+		ActivityTypeAddToCart: &AddToCartDetails{
+			CommonDetails:      common,
+			CartServiceDetails: cartService,
+			ProductID:          "OLJCESPC7Z",
+			RawQuantity:        "3",
+			Quantity:           &n,
+			Suspicious:         true,
+			Result:             ResultFailed,
+			CartItems:          &n,
+			CartSubtotal:       &amount,
+			CartCurrency:       "EUR",
+		},
+		ActivityTypeViewCart: &ViewCartDetails{
+			CommonDetails:      common,
+			CartServiceDetails: cartService,
+			CartItems:          &n,
+		},
+		ActivityTypeEmptyCart: &EmptyCartDetails{
+			CommonDetails:      common,
+			CartServiceDetails: cartService,
+		},
+		ActivityTypeCheckout: &CheckoutDetails{
+			CommonDetails:    common,
+			Country:          "France",
+			Failed:           true,
+			FailureReason:    FailurePaymentDeclined,
+			FailureCode:      "Internal",
+			ShippingCost:     &amount,
+			ShippingCurrency: "EUR",
+			OrderTotal:       &amount,
+			OrderCurrency:    "EUR",
+		},
+		ActivityTypeCurrencyChange: &CurrencyChangeDetails{
+			CommonDetails:    common,
+			NewCurrency:      "JPY",
+			PreviousCurrency: "EUR",
+		},
+		ActivityTypeAssistantMessage: &AssistantMessageDetails{
+			CommonDetails: common,
+			MessageLength: &n,
+			Intent:        "search",
+			HasImage:      &yes,
+			Message:       "red shoes",
+			AssistantMs:   &amount,
+		},
+		ActivityTypeRenderError: &RenderErrorDetails{
+			CommonDetails: common,
+			Template:      "cart",
+		},
+	}
+}
+
+// The encoding of typed details is what the activities stored say and what
+// exporters read, so it must not change by accident: go test -update
+// rewrites the golden files when it changes on purpose.
+func TestTypedDetailsGolden(t *testing.T) {
+	samples := sampleDetails()
+	if len(samples) != len(typedDetails) {
+		t.Fatalf("%d samples for %d typed details, every type needs one", len(samples), len(typedDetails))
+	}
+	for activityType, details := range samples {
+		raw, err := MarshalDetails(details)
+		if err != nil {
+			t.Fatalf("MarshalDetails(%s) failed: %v", activityType, err)
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, []byte(raw), "", "  "); err != nil {
+			t.Fatal(err)
+		}
+		indented.WriteByte('\n')
+
+		path := filepath.Join("testdata", "details", activityType+".json")
+		if *updateDetails {
+			if err := os.WriteFile(path, indented.Bytes(), 0o644); err != nil {
+				t.Fatalf("updating %s failed: %v", path, err)
+			}
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", path, err)
+		}
+		if !bytes.Equal(indented.Bytes(), want) {
+			t.Errorf("%s details encode as\n%s\nwant\n%s", activityType, indented.Bytes(), want)
+		}
+
+		// And decode back to the same
+		got, err := UnmarshalDetails(activityType, string(want))
+		if err != nil {
+			t.Fatalf("UnmarshalDetails(%s) failed: %v", activityType, err)
+		}
+		if !reflect.DeepEqual(got, details) {
+			t.Errorf("UnmarshalDetails(%s) = %+v, want %+v", activityType, got, details)
+		}
+	}
+}
+
+func TestUnmarshalDetails(t *testing.T) {
+	// Keys the typed details don't know are left out
+	got, err := UnmarshalDetails(ActivityTypeCheckout, `{"failed":true,"grpc_ms":12.5}`)
+	if err != nil {
+		t.Fatalf("UnmarshalDetails(checkout) failed: %v", err)
+	}
+	if d := got.(*CheckoutDetails); !d.Failed {
+		t.Errorf("UnmarshalDetails(checkout) = %+v, want failed", d)
+	}
+
+	// Types without typed details decode to a map
+	got, err = UnmarshalDetails(ActivityTypePageView, `{"grpc_ms":12.5}`)
+	if err != nil {
+		t.Fatalf("UnmarshalDetails(page_view) failed: %v", err)
+	}
+	if want := map[string]interface{}{"grpc_ms": 12.5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmarshalDetails(page_view) = %#v, want %#v", got, want)
+	}
+
+	// Empty details are empty typed details
+	if got, err := UnmarshalDetails(ActivityTypeViewCart, ""); err != nil || !reflect.DeepEqual(got, &ViewCartDetails{}) {
+		t.Errorf("UnmarshalDetails(view_cart, \"\") = %+v, %v, want empty details", got, err)
+	}
+	if got, err := MarshalDetails(ViewCartDetails{}); err != nil || got != "" {
+		t.Errorf("MarshalDetails(empty) = %q, %v, want nothing", got, err)
+	}
+}
+
+func TestLogActivityValidatesDetails(t *testing.T) {
+	setupTestDB(t)
+	err := LogActivity(&ActivityLog{SessionID: "s1", ActivityType: ActivityTypeAddToCart, Details: `{"quantity_int":"four"}`})
+	if !errors.Is(err, ErrInvalidDetails) {
+		t.Errorf("LogActivity() with a quantity that isn't a number: err = %v, want ErrInvalidDetails", err)
+	}
+
+	for _, a := range []ActivityLog{
+		// Details that aren't JSON were always accepted
+		{SessionID: "s1", ActivityType: ActivityTypeAddToCart, Details: "quantity=4"},
+		// Types without typed details take anything
+		{SessionID: "s1", ActivityType: ActivityTypePageView, Details: `{"quantity_int":"four"}`},
+		// Unknown keys are fine
+		{SessionID: "s1", ActivityType: ActivityTypeAddToCart, Details: `{"quantity_int":4,"grpc_ms":3}`},
+	} {
+		if err := LogActivity(&a); err != nil {
+			t.Errorf("LogActivity(%s with %s) failed: %v", a.ActivityType, a.Details, err)
+		}
+	}
+}
+
+func TestEncodeTypedDetails(t *testing.T) {
+	var buf bytes.Buffer
+	aw := NewActivityArrayWriter(&buf)
+	aw.TypedDetails()
+	for _, a := range []ActivityLog{
+		{ActivityType: ActivityTypeAddToCart, Details: `{"quantity":"3","quantity_int":3,"grpc_ms":1}`},
+		{ActivityType: ActivityTypePageView, Details: `{"grpc_ms":1}`},
+		{ActivityType: ActivityTypePageView, Details: "not json"},
+		{ActivityType: ActivityTypeViewCart},
+	} {
+		if err := aw.Encode(&a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []struct {
+		Details json.RawMessage `json:"details"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("typed activities aren't JSON: %v\n%s", err, buf.Bytes())
+	}
+	want := []string{`{"quantity":"3","quantity_int":3}`, `{"grpc_ms":1}`, `"not json"`, `null`}
+	for i := range want {
+		if s := strings.TrimSpace(string(got[i].Details)); s != want[i] {
+			t.Errorf("details of activity %d = %s, want %s", i, s, want[i])
+		}
+	}
+}
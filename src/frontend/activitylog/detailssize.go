@@ -0,0 +1,126 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// detailsSizeBuckets are the upper bounds, in bytes, of the buckets of the
+// details size histogram. A last bucket, +Inf, holds the rest.
+var detailsSizeBuckets = []float64{2, 64, 128, 256, 512, 1024, 2048, 4096, 16384, 65536}
+
+// detailsSizes is the size of the details JSON of the activities written,
+// before the details codec compresses it. It is published as
+// activity_log_details_size_bytes in the shape of a Prometheus histogram,
+// like the pipeline latency. The first bucket holds empty details and {}.
+var detailsSizes = newSizeHistogram(detailsSizeBuckets)
+
+func init() {
+	expvar.Publish("activity_log_details_size_bytes", expvar.Func(func() interface{} {
+		return detailsSizes.snapshot()
+	}))
+}
+
+// sizeHistogram counts sizes per bucket
+type sizeHistogram struct {
+	bounds []float64
+
+	mu sync.Mutex
+	// counts has a count per bound and a last one for +Inf, not cumulative
+	counts []int64
+	sum    int64
+	count  int64
+}
+
+func newSizeHistogram(bounds []float64) *sizeHistogram {
+	return &sizeHistogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+// observe records a size in bytes
+func (h *sizeHistogram) observe(size int) {
+	i := 0
+	for i < len(h.bounds) && float64(size) > h.bounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += int64(size)
+	h.count++
+}
+
+// snapshot returns the histogram in the shape of a Prometheus one, with
+// cumulative bucket counts keyed by their upper bound
+func (h *sizeHistogram) snapshot() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return map[string]interface{}{"buckets": cumulativeBuckets(h.bounds, h.counts), "sum": h.sum, "count": h.count}
+}
+
+// DetailsSizeStats is how much room the details of an activity type take
+type DetailsSizeStats struct {
+	ActivityType string `json:"activity_type"`
+	Count        int    `json:"count"`
+	// AvgBytes, MaxBytes and TotalBytes are of the details as stored, so
+	// after compression for those the details codec compressed
+	AvgBytes   float64 `json:"avg_bytes"`
+	MaxBytes   int64   `json:"max_bytes"`
+	TotalBytes int64   `json:"total_bytes"`
+	// EmptyFraction is the fraction of the activities without details,
+	// stored as NULL, an empty string or {}
+	EmptyFraction float64 `json:"empty_fraction"`
+}
+
+// GetDetailsSizeStats returns the size of the details of each activity type
+// in a given time period, those taking the most room first
+func GetDetailsSizeStats(ctx context.Context, startTime, endTime time.Time) ([]DetailsSizeStats, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "details size stats", time.Now())
+
+	// length() counts the characters of text, its bytes once cast to a blob
+	query := `
+		SELECT activity_type, COUNT(*), AVG(size), MAX(size), SUM(size),
+			   AVG(CASE WHEN details IS NULL OR details IN ('', '{}') THEN 1.0 ELSE 0.0 END)
+		FROM (
+			SELECT activity_type, details, COALESCE(length(CAST(details AS BLOB)), 0) AS size
+			FROM activities
+			WHERE ` + createdIn("created_at") + ` AND deleted_at IS NULL)
+		GROUP BY activity_type
+		ORDER BY SUM(size) DESC, activity_type`
+
+	rows, err := getReadDB().QueryContext(ctx, query, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []DetailsSizeStats{}
+	for rows.Next() {
+		var s DetailsSizeStats
+		if err := rows.Scan(&s.ActivityType, &s.Count, &s.AvgBytes, &s.MaxBytes, &s.TotalBytes, &s.EmptyFraction); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,83 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetDetailsSizeStats(t *testing.T) {
+	fc := setupTestDB(t)
+	for _, details := range []string{"", "{}", `{"k":"é"}`} {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Details: details})
+	}
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout, Details: `{"failed":true}`})
+	// Outside the period
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout, Details: `{"failed":true}`, CreatedAt: fc.Now().Add(-2 * time.Hour)})
+
+	got, err := GetDetailsSizeStats(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetDetailsSizeStats() failed: %v", err)
+	}
+	// Bytes rather than characters, é takes two
+	want := []DetailsSizeStats{
+		{ActivityType: ActivityTypeCheckout, Count: 1, AvgBytes: 15, MaxBytes: 15, TotalBytes: 15},
+		{ActivityType: ActivityTypePageView, Count: 3, AvgBytes: 4, MaxBytes: 10, TotalBytes: 12, EmptyFraction: 2.0 / 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetDetailsSizeStats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetDetailsSizeStatsCountsCompressedBytes(t *testing.T) {
+	fc := setupTestDB(t)
+	useDetailsCodec(t, DetailsCodecGzip, 0)
+	details := `{"tags":["` + strings.Repeat("a", 1000) + `"]}`
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Details: details})
+
+	got, err := GetDetailsSizeStats(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetDetailsSizeStats() failed: %v", err)
+	}
+	if len(got) != 1 || got[0].TotalBytes == 0 || got[0].TotalBytes >= int64(len(details)) {
+		t.Errorf("GetDetailsSizeStats() = %+v, want the %d bytes of details counted compressed", got, len(details))
+	}
+}
+
+func TestDetailsSizeHistogram(t *testing.T) {
+	setupTestDB(t)
+	before := detailsSizes.snapshot()
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Details: "{}"})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Details: `{"k":"é"}`})
+
+	after := detailsSizes.snapshot()
+	bucket := func(s map[string]interface{}, le string) int64 { return s["buckets"].(map[string]int64)[le] }
+	if n := after["count"].(int64) - before["count"].(int64); n != 2 {
+		t.Fatalf("%d sizes observed, want 2", n)
+	}
+	if n := bucket(after, "2") - bucket(before, "2"); n != 1 {
+		t.Errorf("2 byte bucket grew by %d, want 1 for {}", n)
+	}
+	if n := bucket(after, "64") - bucket(before, "64"); n != 2 {
+		t.Errorf("64 byte bucket grew by %d, want 2", n)
+	}
+	if got := after["sum"].(int64) - before["sum"].(int64); got != 12 {
+		t.Errorf("sum grew by %d, want 12", got)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// deviceMigration records the long-lived device ID of the browser each
+// activity came from, which the sessions of a returning browser share.
+// Rows logged before, and those of browsers without one, are left NULL.
+const deviceMigration = `ALTER TABLE activities ADD COLUMN device_id TEXT;
+	CREATE INDEX IF NOT EXISTS idx_device_id ON activities(device_id, created_at);`
+
+// maxDeviceSessions is the most sessions GetDeviceHistory returns, the
+// latest ones
+const maxDeviceSessions = 500
+
+// Identities the stats can count shoppers by
+const (
+	// IdentitySession counts each session apart, the default
+	IdentitySession = "session"
+	// IdentityDevice counts the sessions of a device together. Sessions
+	// without a device ID count as devices of their own.
+	IdentityDevice = "device"
+)
+
+// ParseIdentity checks an identity given as a query parameter, empty
+// meaning IdentitySession
+func ParseIdentity(s string) (string, error) {
+	switch s {
+	case "", IdentitySession:
+		return IdentitySession, nil
+	case IdentityDevice:
+		return IdentityDevice, nil
+	}
+	return "", fmt.Errorf("unknown identity %q, want %q or %q", s, IdentitySession, IdentityDevice)
+}
+
+// identityColumn is the expression identifying the shopper of a row of
+// alias, if any, by identity
+func identityColumn(identity, alias string) string {
+	if alias != "" {
+		alias += "."
+	}
+	if identity == IdentityDevice {
+		return "COALESCE(" + alias + "device_id, " + alias + "session_id)"
+	}
+	return alias + "session_id"
+}
+
+// GetDeviceHistory sums up the sessions of a device, oldest first, such as
+// the visits of a returning shopper. Only the latest maxDeviceSessions are
+// returned.
+func GetDeviceHistory(ctx context.Context, deviceID string) ([]SessionSummary, error) {
+	if deviceID == "" {
+		return nil, ErrEmptyFilter
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "device history", time.Now())
+
+	query := `
+		SELECT session_id, COALESCE(MAX(user_id), ''), COUNT(*),
+			   SUM(` + isType("activities", ActivityTypePageView) + `),
+			   SUM(` + isType("activities", ActivityTypeProductView) + `),
+			   SUM(` + isCartAdd("activities") + `),
+			   SUM(` + isCheckout("activities") + `), MIN(created_at) AS first_seen, MAX(created_at)
+		FROM activities
+		WHERE device_id = ? AND deleted_at IS NULL
+		GROUP BY session_id
+		ORDER BY first_seen DESC, session_id
+		LIMIT ?`
+	rows, err := getReadDB().QueryContext(ctx, query, deviceID, maxDeviceSessions)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := scanSessionSummaries(rows)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(sessions)-1; i < j; i, j = i+1, j-1 {
+		sessions[i], sessions[j] = sessions[j], sessions[i]
+	}
+	return sessions, nil
+}
+
+// DeleteDevice soft-deletes every activity of a device, whichever session
+// logged it, and returns their number, as DeleteSession does for a session
+func DeleteDevice(ctx context.Context, deviceID string) (int64, error) {
+	return softDeleteBy(ctx, "device_id", deviceID, AuditDeleteDevice)
+}
@@ -0,0 +1,137 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// logDeviceJourney logs the given activity types for a session of a
+// device, a minute apart
+func logDeviceJourney(t *testing.T, fc *FakeClock, deviceID, sessionID string, types ...string) {
+	t.Helper()
+	for _, activityType := range types {
+		fc.Advance(time.Minute)
+		mustLog(t, &ActivityLog{DeviceID: deviceID, SessionID: sessionID, ActivityType: activityType})
+	}
+}
+
+func TestGetDeviceHistory(t *testing.T) {
+	fc := setupTestDB(t)
+	logDeviceJourney(t, fc, "device-1", "monday", ActivityTypeProductView, ActivityTypeAddToCart)
+	fc.Advance(day)
+	logDeviceJourney(t, fc, "device-1", "tuesday", ActivityTypeViewCart, ActivityTypeCheckout)
+	logDeviceJourney(t, fc, "device-2", "other", ActivityTypePageView)
+	logJourney(t, fc, "no-device", ActivityTypePageView)
+
+	sessions, err := GetDeviceHistory(context.Background(), "device-1")
+	if err != nil {
+		t.Fatalf("GetDeviceHistory() failed: %v", err)
+	}
+	var ids []string
+	for _, s := range sessions {
+		ids = append(ids, s.SessionID)
+	}
+	if !reflect.DeepEqual(ids, []string{"monday", "tuesday"}) {
+		t.Fatalf("GetDeviceHistory() sessions = %v, want monday then tuesday", ids)
+	}
+	if s := sessions[1]; s.Activities != 2 || s.Checkouts != 1 || !s.FirstSeen.Before(s.LastSeen) {
+		t.Errorf("tuesday = %+v, want 2 activities and a checkout", s)
+	}
+	if a := lastActivity(t); a.DeviceID != "" {
+		t.Errorf("activity without a device read back with device %q", a.DeviceID)
+	}
+	if _, err := GetDeviceHistory(context.Background(), ""); err != ErrEmptyFilter {
+		t.Errorf("GetDeviceHistory(\"\") = %v, want ErrEmptyFilter", err)
+	}
+}
+
+func TestDeleteDevice(t *testing.T) {
+	fc := setupTestDB(t)
+	logDeviceJourney(t, fc, "device-1", "monday", ActivityTypeProductView, ActivityTypeAddToCart)
+	logDeviceJourney(t, fc, "device-1", "tuesday", ActivityTypeViewCart)
+	logDeviceJourney(t, fc, "device-2", "other", ActivityTypePageView)
+
+	deleted, err := DeleteDevice(context.Background(), "device-1")
+	if err != nil {
+		t.Fatalf("DeleteDevice() failed: %v", err)
+	}
+	if deleted != 3 || countActivities(t) != 1 {
+		t.Errorf("DeleteDevice() deleted %d rows, %d left; want 3 deleted, 1 left", deleted, countActivities(t))
+	}
+	if sessions, err := GetDeviceHistory(context.Background(), "device-1"); err != nil || len(sessions) != 0 {
+		t.Errorf("GetDeviceHistory() after DeleteDevice() = %v, %v; want none", sessions, err)
+	}
+	entries, err := GetAuditEntries(1)
+	if err != nil {
+		t.Fatalf("GetAuditEntries() failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Operation != AuditDeleteDevice {
+		t.Errorf("audit trail = %+v, want the device deletion", entries)
+	}
+}
+
+func TestIdentityDevice(t *testing.T) {
+	fc := setupTestDB(t)
+	start := fc.Now()
+	// A device browsing in one session and buying in the next
+	logDeviceJourney(t, fc, "device-1", "browse", ActivityTypeProductView, ActivityTypeAddToCart)
+	logDeviceJourney(t, fc, "device-1", "buy", ActivityTypeViewCart, ActivityTypeCheckout)
+	// Sessions without a device count on their own
+	logJourney(t, fc, "anonymous", ActivityTypeProductView)
+	end := fc.Now().Add(time.Minute)
+
+	counts := func(identity string) []int {
+		t.Helper()
+		funnel, err := GetFunnelBy(context.Background(), start, end, DefaultFunnel, identity)
+		if err != nil {
+			t.Fatalf("GetFunnelBy(%s) failed: %v", identity, err)
+		}
+		n := make([]int, len(funnel))
+		for i, step := range funnel {
+			n[i] = step.Sessions
+		}
+		return n
+	}
+	if got, want := counts(IdentitySession), []int{2, 1, 0, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("funnel of sessions = %v, want %v", got, want)
+	}
+	if got, want := counts(IdentityDevice), []int{2, 1, 1, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("funnel of devices = %v, want %v", got, want)
+	}
+
+	stats, err := GetTimeToConversionBy(context.Background(), start, end, IdentityDevice)
+	if err != nil {
+		t.Fatalf("GetTimeToConversionBy() failed: %v", err)
+	}
+	if stats.Count != 1 || stats.Median != 3*60 {
+		t.Errorf("time to conversion of devices = %+v, want one of 3 minutes", stats)
+	}
+
+	report, err := GetCohortReportBy(context.Background(), 1, time.UTC, IdentityDevice)
+	if err != nil {
+		t.Fatalf("GetCohortReportBy() failed: %v", err)
+	}
+	if report.Note != DeviceCohortNote || report.Cohorts[0].Sessions != 2 {
+		t.Errorf("cohort report of devices = %+v, want 2 devices", report)
+	}
+
+	if _, err := GetFunnelBy(context.Background(), start, end, DefaultFunnel, "user"); err == nil {
+		t.Error("GetFunnelBy() accepts an unknown identity")
+	}
+}
@@ -0,0 +1,52 @@
+//go:build !purego
+
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("activity_details", decodeStoredDetails, true)
+		},
+	})
+}
+
+// sqliteDSN returns the go-sqlite3 DSN opening the database at path with o.
+// Times are read back in UTC, the zone they are stored in.
+func sqliteDSN(path string, o dsnOptions) string {
+	dsn := path + "?_loc=UTC"
+	if o.readOnly {
+		dsn = "file:" + path + "?mode=ro&immutable=0&_loc=UTC"
+	}
+	if o.busyTimeoutMs > 0 {
+		dsn += fmt.Sprintf("&_busy_timeout=%d", o.busyTimeoutMs)
+	}
+	return dsn
+}
+
+// isCorruption tells whether err is SQLite finding a database file corrupt
+func isCorruption(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrNotADB)
+}
@@ -0,0 +1,172 @@
+//go:build purego
+
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func init() {
+	// Functions are registered with the package's one driver rather than
+	// per connection
+	sqlite.MustRegisterDeterministicScalarFunction("activity_details", 1,
+		func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			switch stored := args[0].(type) {
+			case []byte:
+				return decodeStoredDetails(stored)
+			case string:
+				return stored, nil
+			default:
+				return nil, nil
+			}
+		})
+	// The package keeps that driver to itself, so register it again under
+	// sqliteDriver through a handle that never connects
+	db, err := sql.Open("sqlite", "")
+	if err != nil {
+		panic(err)
+	}
+	sql.Register(sqliteDriver, utcDriver{db.Driver()})
+	db.Close()
+}
+
+// utcDriver reads times back in UTC, the zone they are stored in, like
+// go-sqlite3 does with _loc=UTC. modernc.org/sqlite has no such option and
+// leaves them in whatever zone parsing the offset gave.
+type utcDriver struct {
+	driver.Driver
+}
+
+func (d utcDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return utcConn{conn.(sqliteConn)}, nil
+}
+
+// sqliteConn is what a modernc.org/sqlite connection implements that
+// database/sql makes use of
+type sqliteConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+}
+
+type utcConn struct {
+	sqliteConn
+}
+
+func (c utcConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c utcConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.sqliteConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return utcStmt{stmt.(sqliteStmt)}, nil
+}
+
+func (c utcConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.sqliteConn.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return utcRows{rows}, nil
+}
+
+// sqliteStmt is what a modernc.org/sqlite statement implements that
+// database/sql makes use of
+type sqliteStmt interface {
+	driver.Stmt
+	driver.StmtExecContext
+	driver.StmtQueryContext
+}
+
+type utcStmt struct {
+	sqliteStmt
+}
+
+func (s utcStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.sqliteStmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+	return utcRows{rows}, nil
+}
+
+func (s utcStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := s.sqliteStmt.QueryContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return utcRows{rows}, nil
+}
+
+type utcRows struct {
+	driver.Rows
+}
+
+func (r utcRows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	for i, v := range dest {
+		if t, ok := v.(time.Time); ok {
+			dest[i] = t.UTC()
+		}
+	}
+	return nil
+}
+
+// sqliteDSN returns the modernc.org/sqlite DSN opening the database at path
+// with o. Times are written the way go-sqlite3 writes them, so a database
+// can move between builds.
+func sqliteDSN(path string, o dsnOptions) string {
+	dsn := path + "?_time_format=sqlite"
+	if o.readOnly {
+		dsn = "file:" + path + "?mode=ro&_time_format=sqlite"
+	}
+	if o.busyTimeoutMs > 0 {
+		dsn += fmt.Sprintf("&_pragma=busy_timeout(%d)", o.busyTimeoutMs)
+	}
+	return dsn
+}
+
+// isCorruption tells whether err is SQLite finding a database file corrupt
+func isCorruption(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	// Connections report extended codes, whose low byte is the primary one
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_CORRUPT || code == sqlite3.SQLITE_NOTADB
+}
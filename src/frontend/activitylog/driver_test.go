@@ -0,0 +1,162 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestDriverBehaviour pins down what the package relies on from the SQLite
+// driver, so that running the suite with and without -tags purego catches
+// where the two drivers differ
+func TestDriverBehaviour(t *testing.T) {
+	path := filepath.Join(t.TempDir(), dbFileName)
+	writer, err := sql.Open(sqliteDriver, sqliteDSN(path, dsnOptions{busyTimeoutMs: busyTimeoutMs}))
+	if err != nil {
+		t.Fatalf("opening the writer failed: %v", err)
+	}
+	defer writer.Close()
+	if _, err := writer.Exec("CREATE TABLE times (at DATETIME, details TEXT)"); err != nil {
+		t.Fatalf("creating the table failed: %v", err)
+	}
+	west := time.FixedZone("UTC-7", -7*60*60)
+	at := time.Date(2025, 6, 1, 5, 0, 0, 250_000_000, west)
+	if _, err := writer.Exec("INSERT INTO times VALUES (?, ?)", at, `{"a":1}`); err != nil {
+		t.Fatalf("inserting failed: %v", err)
+	}
+	reader, err := sql.Open(sqliteDriver, sqliteDSN(path, dsnOptions{readOnly: true, busyTimeoutMs: busyTimeoutMs}))
+	if err != nil {
+		t.Fatalf("opening the reader failed: %v", err)
+	}
+	defer reader.Close()
+
+	for _, tt := range []struct {
+		name  string
+		check func(t *testing.T)
+	}{
+		{"times are read back in UTC", func(t *testing.T) {
+			var got time.Time
+			if err := reader.QueryRow("SELECT at FROM times").Scan(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(at) || got.Location() != time.UTC {
+				t.Errorf("at = %v, want %v in UTC", got, at)
+			}
+		}},
+		{"times are written as go-sqlite3 writes them", func(t *testing.T) {
+			var got string
+			if err := reader.QueryRow("SELECT CAST(at AS TEXT) FROM times").Scan(&got); err != nil {
+				t.Fatal(err)
+			}
+			// Stored in the zone given, which the package always makes UTC
+			if want := at.Format(timestampFormat); got != want {
+				t.Errorf("at stored as %q, want %q", got, want)
+			}
+		}},
+		{"busy timeout is set", func(t *testing.T) {
+			for name, conn := range map[string]*sql.DB{"writer": writer, "reader": reader} {
+				var got int
+				if err := conn.QueryRow("PRAGMA busy_timeout").Scan(&got); err != nil {
+					t.Fatal(err)
+				}
+				if got != busyTimeoutMs {
+					t.Errorf("%s busy_timeout = %d, want %d", name, got, busyTimeoutMs)
+				}
+			}
+		}},
+		{"read-only connections refuse writes", func(t *testing.T) {
+			if _, err := reader.Exec("DELETE FROM times"); err == nil {
+				t.Error("deleting through the reader succeeded, want it refused")
+			}
+		}},
+		{"activity_details passes text through", func(t *testing.T) {
+			var got sql.NullString
+			if err := reader.QueryRow("SELECT activity_details(details) FROM times").Scan(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.String != `{"a":1}` {
+				t.Errorf("activity_details() = %q, want the details as stored", got.String)
+			}
+		}},
+		{"garbage is corruption", func(t *testing.T) {
+			garbage := filepath.Join(t.TempDir(), dbFileName)
+			if err := os.WriteFile(garbage, []byte("not a database, just garbage on the volume"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			conn, err := sql.Open(sqliteDriver, sqliteDSN(garbage, dsnOptions{readOnly: true}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			_, err = conn.Exec("PRAGMA integrity_check")
+			if !isCorruption(err) {
+				t.Errorf("integrity_check error = %v, want corruption", err)
+			}
+		}},
+	} {
+		t.Run(tt.name, tt.check)
+	}
+}
+
+// BenchmarkLogActivity measures inserts, one activity per transaction as
+// the middleware logs them
+func BenchmarkLogActivity(b *testing.B) {
+	setupTestDB(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := LogActivity(&ActivityLog{
+			SessionID:    "session-1",
+			RequestID:    "request-1",
+			ActivityType: ActivityTypeProductView,
+			Path:         "/product/OLJCESPC7Z",
+			Details:      `{"product_id":"OLJCESPC7Z"}`,
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkActivityStats measures the dashboard's counts over a day of
+// 10,000 activities
+func BenchmarkActivityStats(b *testing.B) {
+	setupTestDB(b)
+	tx, err := GetDB().Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 10_000; i++ {
+		if _, err := tx.Exec(`INSERT INTO activities (session_id, request_id, activity_type, path, method, status_code, user_currency, details, created_at)
+			VALUES (?, 'r', ?, '/', 'GET', 200, 'USD', '{}', ?)`,
+			i%500, []string{ActivityTypePageView, ActivityTypeProductView, ActivityTypeAddToCart}[i%3],
+			Now().Add(-time.Duration(i)*time.Second)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	filter := Filter{Start: Now().Add(-24 * time.Hour), End: Now()}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := GetActivityStats(context.Background(), filter); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
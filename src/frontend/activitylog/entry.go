@@ -0,0 +1,105 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// entryMigration marks the first activity of each session, the request
+// it arrived with. Rows logged before are left NULL, as are those of
+// sessions already under way.
+const entryMigration = `ALTER TABLE activities ADD COLUMN is_entry INTEGER;`
+
+// entryDetails adds to the details of the entry activity of a session
+// where it landed and where it came from. Other activities of the session
+// don't repeat them.
+func entryDetails(r *http.Request, activity *ActivityLog, utm map[string]string, details map[string]interface{}) {
+	details["landing_path"] = truncatePath(r.URL.EscapedPath())
+	details["traffic_source"] = trafficSource(activity, utm)
+}
+
+// trafficSource sums up where a session came from: the utm_source of the
+// link it arrived through, else the site that linked to it, else the type
+// of its referrer
+func trafficSource(activity *ActivityLog, utm map[string]string) string {
+	if source := utm["utm_source"]; utmValue.MatchString(source) {
+		return source
+	}
+	if activity.ReferrerDomain != "" {
+		return activity.ReferrerDomain
+	}
+	return activity.ReferrerType
+}
+
+// LandingPage is a path sessions arrived on and how many of them went on
+// to place an order
+type LandingPage struct {
+	Path           string  `json:"path"`
+	Sessions       int     `json:"sessions"`
+	Conversions    int     `json:"conversions"`
+	ConversionRate float64 `json:"conversion_rate"`
+}
+
+// GetLandingPages returns the paths most sessions starting in a given time
+// period arrived on, with the share of those sessions that checked out
+// afterwards, whenever that was. Failed checkouts don't count.
+func GetLandingPages(ctx context.Context, startTime, endTime time.Time, limit int) ([]LandingPage, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "landing pages", time.Now())
+
+	query := `
+		WITH entries AS (
+			SELECT session_id, path, MIN(created_at) AS created_at
+			FROM activities
+			WHERE is_entry = 1 AND ` + createdIn("created_at") + ` AND deleted_at IS NULL
+			GROUP BY session_id
+		), converted AS (
+			SELECT session_id, MAX(created_at) AS converted_at
+			FROM activities
+			WHERE activity_type = ? AND COALESCE(json_extract(` + detailsJSON + `, '$.failed'), 0) = 0
+			  AND deleted_at IS NULL AND session_id IN (SELECT session_id FROM entries)
+			GROUP BY session_id
+		)
+		SELECT e.path, COUNT(*) AS sessions, COUNT(c.session_id)
+		FROM entries e
+		LEFT JOIN converted c ON c.session_id = e.session_id AND c.converted_at >= e.created_at
+		GROUP BY e.path
+		ORDER BY sessions DESC, e.path
+		LIMIT ?`
+
+	rows, err := getReadDB().QueryContext(ctx, query, startTime.UTC(), endTime.UTC(), ActivityTypeCheckout, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := []LandingPage{}
+	for rows.Next() {
+		var p LandingPage
+		if err := rows.Scan(&p.Path, &p.Sessions, &p.Conversions); err != nil {
+			return nil, err
+		}
+		p.ConversionRate = float64(p.Conversions) / float64(p.Sessions)
+		pages = append(pages, p)
+	}
+	return pages, rows.Err()
+}
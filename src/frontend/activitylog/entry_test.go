@@ -0,0 +1,90 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareRecordsEntry(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	req := httptest.NewRequest(http.MethodGet, "/product/OLJCESPC7Z?utm_source=newsletter&utm_campaign=spring", nil)
+	req.Header.Set("Referer", "https://news.example.co.uk/today")
+	serve(router, req.WithContext(context.WithValue(req.Context(), CtxKeyNewSession{}, true)), "session-1")
+	got := lastActivity(t)
+	if !got.IsEntry {
+		t.Errorf("first activity of the session isn't its entry: %+v", got)
+	}
+	details := detailsOf(t, got)
+	if details["landing_path"] != "/product/OLJCESPC7Z" || details["traffic_source"] != "newsletter" {
+		t.Errorf("entry details = %v, want the landing path and utm_source", details)
+	}
+
+	// Without a campaign the referrer tells where the session came from
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Referer", "https://news.example.co.uk/today")
+	serve(router, req.WithContext(context.WithValue(req.Context(), CtxKeyNewSession{}, true)), "session-2")
+	if details := detailsOf(t, lastActivity(t)); details["traffic_source"] != "example.co.uk" {
+		t.Errorf("entry details = %v, want the referring domain as traffic source", details)
+	}
+
+	serve(router, httptest.NewRequest(http.MethodGet, "/cart", nil), "session-1")
+	got = lastActivity(t)
+	if got.IsEntry {
+		t.Error("later activity of the session logged as its entry")
+	}
+	for _, key := range []string{"landing_path", "traffic_source"} {
+		if v, ok := detailsOf(t, got)[key]; ok {
+			t.Errorf("later activity has %s = %v, only entries keep it", key, v)
+		}
+	}
+}
+
+func TestGetLandingPages(t *testing.T) {
+	fc := setupTestDB(t)
+	start := fc.Now()
+	for _, a := range []ActivityLog{
+		{SessionID: "home-converted", ActivityType: ActivityTypePageView, Path: "/", IsEntry: true},
+		{SessionID: "home-converted", ActivityType: ActivityTypeCheckout, Path: "/cart/checkout"},
+		{SessionID: "home-browsing", ActivityType: ActivityTypePageView, Path: "/", IsEntry: true},
+		{SessionID: "product-failed", ActivityType: ActivityTypeProductView, Path: "/product/OLJCESPC7Z", IsEntry: true},
+		{SessionID: "product-failed", ActivityType: ActivityTypeCheckout, Path: "/cart/checkout", Details: `{"failed":true}`},
+		// Activities of sessions under way aren't entries
+		{SessionID: "returning", ActivityType: ActivityTypeViewCart, Path: "/cart"},
+		{SessionID: "returning", ActivityType: ActivityTypeCheckout, Path: "/cart/checkout"},
+	} {
+		fc.Advance(time.Second)
+		mustLog(t, &a)
+	}
+
+	pages, err := GetLandingPages(context.Background(), start, fc.Now().Add(time.Second), 10)
+	if err != nil {
+		t.Fatalf("GetLandingPages() failed: %v", err)
+	}
+	want := []LandingPage{
+		{Path: "/", Sessions: 2, Conversions: 1, ConversionRate: 0.5},
+		{Path: "/product/OLJCESPC7Z", Sessions: 1},
+	}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("GetLandingPages() = %+v, want %+v", pages, want)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"time"
+)
+
+// ErrorRate is how many requests of an activity type failed
+type ErrorRate struct {
+	ActivityType string `json:"activity_type"`
+	Requests     int    `json:"requests"`
+	// ClientErrors are 4xx responses, ServerErrors 5xx ones
+	ClientErrors int `json:"client_errors"`
+	ServerErrors int `json:"server_errors"`
+	// ServerErrorRate is the fraction of the requests that got a 5xx
+	ServerErrorRate float64 `json:"server_error_rate"`
+}
+
+// GetErrorRates returns the error responses per activity type in a given
+// time period, busiest type first
+func GetErrorRates(ctx context.Context, startTime, endTime time.Time) ([]ErrorRate, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "error rates", time.Now())
+
+	query := `
+		SELECT activity_type, COUNT(*) AS requests,
+			   COALESCE(SUM(status_code >= 400 AND status_code < 500), 0),
+			   COALESCE(SUM(status_code >= 500), 0)
+		FROM activities
+		WHERE ` + createdIn("created_at") + ` AND deleted_at IS NULL
+		GROUP BY activity_type
+		ORDER BY requests DESC, activity_type`
+
+	rows, err := getReadDB().QueryContext(ctx, query, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []ErrorRate{}
+	for rows.Next() {
+		var e ErrorRate
+		if err := rows.Scan(&e.ActivityType, &e.Requests, &e.ClientErrors, &e.ServerErrors); err != nil {
+			return nil, err
+		}
+		e.ServerErrorRate = float64(e.ServerErrors) / float64(e.Requests)
+		rates = append(rates, e)
+	}
+	return rates, rows.Err()
+}
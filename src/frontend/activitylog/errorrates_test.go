@@ -0,0 +1,49 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetErrorRates(t *testing.T) {
+	fc := setupTestDB(t)
+	for _, a := range []ActivityLog{
+		{SessionID: "a", ActivityType: ActivityTypePageView, StatusCode: 200},
+		{SessionID: "a", ActivityType: ActivityTypePageView, StatusCode: 200},
+		{SessionID: "a", ActivityType: ActivityTypePageView, StatusCode: 502},
+		{SessionID: "a", ActivityType: ActivityTypePageView, StatusCode: 500},
+		{SessionID: "a", ActivityType: ActivityTypeAddToCart, StatusCode: 422},
+	} {
+		mustLog(t, &a)
+	}
+	// Outside the period
+	mustLog(t, &ActivityLog{SessionID: "a", ActivityType: ActivityTypeAddToCart, StatusCode: 500, CreatedAt: fc.Now().Add(-2 * time.Hour)})
+
+	got, err := GetErrorRates(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetErrorRates() failed: %v", err)
+	}
+	want := []ErrorRate{
+		{ActivityType: ActivityTypePageView, Requests: 4, ServerErrors: 2, ServerErrorRate: 0.5},
+		{ActivityType: ActivityTypeAddToCart, Requests: 1, ClientErrors: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetErrorRates() = %+v, want %+v", got, want)
+	}
+}
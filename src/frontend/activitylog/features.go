@@ -0,0 +1,106 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"sort"
+	"sync"
+)
+
+// APIVersion is the version of the activity HTTP API. It changes only when
+// an endpoint changes in a way older clients can't cope with; additions are
+// advertised as features instead.
+const APIVersion = 1
+
+// Features of the activity API. Their names are part of the API: clients
+// look them up, so they never change once released.
+const (
+	// FeatureSSE is the live feed of /activities/stream
+	FeatureSSE = "sse"
+	// FeatureFiltersV2 are the filters beyond types, session and time range:
+	// type!, sessions, user_id, path_prefix, status_class, source,
+	// experiment, variant, version and tag
+	FeatureFiltersV2 = "filters_v2"
+	// FeatureGroupBy is the group_by parameter of /activities/stats
+	FeatureGroupBy = "group_by"
+	// FeatureStatsAsOf is the as_of parameter of /activities/stats
+	FeatureStatsAsOf = "stats_as_of"
+	// FeatureExport is /activities/export
+	FeatureExport = "export"
+	// FeatureSessionSummaries is /activities/sessions
+	FeatureSessionSummaries = "session_summaries"
+	// FeatureClientEvents is IngestPath, enabled with client events
+	FeatureClientEvents = "client_events"
+	// FeatureResponseClass is the response_class filter, and group_by
+	// dimension of /activities/stats
+	FeatureResponseClass = "response_class"
+	// FeatureTypedDetails is the typed parameter of /activities and
+	// /activities/session
+	FeatureTypedDetails = "typed_details"
+	// FeatureDetailFilters are the detail.<key> filters
+	FeatureDetailFilters = "detail_filters"
+	// FeatureCursor is the before_id parameter of /activities and
+	// /activities/session, which list activities latest logged first
+	FeatureCursor = "cursor"
+	// FeatureLongPoll is /activities/poll
+	FeatureLongPoll = "long_poll"
+)
+
+// ServerVersion is what /activities/version answers: the versions of the
+// schema and of the API, and the features the frontend serves
+type ServerVersion struct {
+	SchemaVersion int      `json:"schema_version"`
+	APIVersion    int      `json:"api_version"`
+	Features      []string `json:"features"`
+}
+
+// features is the registry the endpoints of the activity API register the
+// features they serve into
+var features = struct {
+	sync.RWMutex
+	names map[string]bool
+}{names: make(map[string]bool)}
+
+// RegisterFeature records that the frontend serves the given features.
+// Registering a feature twice is harmless.
+func RegisterFeature(names ...string) {
+	features.Lock()
+	defer features.Unlock()
+	for _, name := range names {
+		features.names[name] = true
+	}
+}
+
+// Features returns the registered features, sorted
+func Features() []string {
+	features.RLock()
+	defer features.RUnlock()
+	names := make([]string, 0, len(features.names))
+	for name := range features.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetServerVersion describes the schema, API version and features of this
+// frontend
+func GetServerVersion() (ServerVersion, error) {
+	schemaVersion, err := SchemaVersion()
+	if err != nil {
+		return ServerVersion{}, err
+	}
+	return ServerVersion{SchemaVersion: schemaVersion, APIVersion: APIVersion, Features: Features()}, nil
+}
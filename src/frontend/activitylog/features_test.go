@@ -0,0 +1,71 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestFeatureNamesArePinned guards the feature strings clients of other
+// versions look up. Changing one breaks them; add a new feature instead.
+func TestFeatureNamesArePinned(t *testing.T) {
+	for got, want := range map[string]string{
+		FeatureSSE:              "sse",
+		FeatureFiltersV2:        "filters_v2",
+		FeatureGroupBy:          "group_by",
+		FeatureStatsAsOf:        "stats_as_of",
+		FeatureExport:           "export",
+		FeatureSessionSummaries: "session_summaries",
+		FeatureClientEvents:     "client_events",
+		FeatureCursor:           "cursor",
+		FeatureLongPoll:         "long_poll",
+	} {
+		if got != want {
+			t.Errorf("feature %q was renamed from %q", got, want)
+		}
+	}
+	if APIVersion != 1 {
+		t.Errorf("APIVersion = %d, want 1", APIVersion)
+	}
+}
+
+func TestGetServerVersion(t *testing.T) {
+	setupTestDB(t)
+	RegisterFeature(FeatureSSE, FeatureGroupBy)
+	RegisterFeature(FeatureSSE)
+
+	got, err := GetServerVersion()
+	if err != nil {
+		t.Fatalf("GetServerVersion() failed: %v", err)
+	}
+	if got.SchemaVersion != len(migrations) || got.APIVersion != APIVersion {
+		t.Errorf("GetServerVersion() = %+v, want schema version %d and API version %d", got, len(migrations), APIVersion)
+	}
+	// Other tests may register more, but never twice
+	seen := map[string]bool{}
+	for _, f := range got.Features {
+		if seen[f] {
+			t.Errorf("feature %s listed twice in %v", f, got.Features)
+		}
+		seen[f] = true
+	}
+	if !seen[FeatureSSE] || !seen[FeatureGroupBy] {
+		t.Errorf("features = %v, want %s and %s", got.Features, FeatureSSE, FeatureGroupBy)
+	}
+	if !reflect.DeepEqual(got.Features, Features()) {
+		t.Errorf("features = %v, want the sorted registry %v", got.Features, Features())
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var experimentName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// detailsJSON is the details column as a JSON expression. Activities without
+// details store an empty string, which json_extract rejects as malformed.
+// Compressed details are BLOBs, decompressed in Go by activity_details() so
+// that every query reads them like the rest. That costs a call into Go and
+// a gunzip per compressed row each time a query reads its details, rather
+// than skipping those rows and getting stats that depend on the codec.
+// Rows stored as text don't pay for it.
+const detailsJSON = "CASE WHEN typeof(details) = 'blob' THEN activity_details(details) ELSE NULLIF(details, '') END"
+
+// createdIn is the condition selecting the activities created in
+// [start, end), given as the next two UTC arguments. The driver stores
+// times as text with their offset, so comparisons only hold between times
+// in the same zone, and every time bound to a query must be UTC.
+func createdIn(column string) string {
+	return column + " >= ? AND " + column + " < ?"
+}
+
+// Filter narrows down the activities a query looks at. Zero-valued fields
+// don't filter.
+type Filter struct {
+	// Start and End bound created_at to [Start, End): adjacent ranges
+	// don't share activities.
+	Start time.Time
+	End   time.Time
+	// Types restricts the activity types, TypesNot excludes some.
+	Types    []string
+	TypesNot []string
+	// SessionID restricts the activities to a single session, SessionIDs
+	// to any of several.
+	SessionID  string
+	SessionIDs []string
+	// UserID restricts the activities to a signed-in user, across all of
+	// their sessions.
+	UserID string
+	// PathPrefix restricts the activities to paths starting with it. Routed
+	// requests are stored under their route template, such as
+	// /product/{id}.
+	PathPrefix string
+	// StatusClasses restricts the response status classes, 4 standing for
+	// 4xx and so on.
+	StatusClasses []int
+	// ResponseClasses restricts the response classes, such as
+	// ResponseClassRedirect.
+	ResponseClasses []string
+	// Source restricts the activities to a traffic source, such as
+	// SourceLoadGenerator.
+	Source string
+	// Experiment and Variant restrict the activities to sessions assigned
+	// to the given variant of an experiment. Variant requires Experiment.
+	Experiment string
+	Variant    string
+	// Version restricts the activities to those served by a frontend
+	// version.
+	Version string
+	// Tags restrict the activities to those tagged with every given value
+	// of a tag cookie, see WithTagCookies.
+	Tags map[string]string
+	// Details restrict the activities to those with every given value
+	// under a top-level key of their details, at most MaxDetailFilters.
+	// Values are matched as text, whatever JSON type they were stored as.
+	Details map[string]string
+	// BeforeID restricts the activities to those logged before the one
+	// with this ID. Passing the ID of the last activity of a list gets its
+	// next page.
+	BeforeID int64
+}
+
+// maxFilterValues bounds the values of a filter's lists combined, keeping
+// queries well below SQLite's limit on parameters
+const maxFilterValues = 900
+
+// Validate checks that the filter can be turned into a query
+func (f Filter) Validate() error {
+	if n := len(f.Types) + len(f.TypesNot) + len(f.SessionIDs) + len(f.StatusClasses) + len(f.ResponseClasses) + len(f.Tags) + len(f.Details); n > maxFilterValues {
+		return fmt.Errorf("filter has %d values, at most %d are supported", n, maxFilterValues)
+	}
+	for _, c := range f.StatusClasses {
+		if c < 1 || c > 5 {
+			return fmt.Errorf("invalid status class %d, must be between 1 and 5", c)
+		}
+	}
+	for _, c := range f.ResponseClasses {
+		if err := validateResponseClass(c); err != nil {
+			return err
+		}
+	}
+	if f.Experiment != "" && !experimentName.MatchString(f.Experiment) {
+		return errors.New("experiment name must only contain letters, digits and underscores")
+	}
+	if f.BeforeID < 0 {
+		return fmt.Errorf("invalid before_id %d, must be positive", f.BeforeID)
+	}
+	if f.Variant != "" && f.Experiment == "" {
+		return errors.New("variant requires an experiment")
+	}
+	for name := range f.Tags {
+		if err := ValidateTagName(name); err != nil {
+			return err
+		}
+	}
+	if len(f.Details) > MaxDetailFilters {
+		return fmt.Errorf("filter matches %d detail keys, at most %d are supported", len(f.Details), MaxDetailFilters)
+	}
+	for key := range f.Details {
+		if err := ValidateDetailKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// where builds the SQL WHERE clause for the filter, including the WHERE
+// keyword, and the arguments it references. Soft-deleted activities never
+// match.
+func (f Filter) where() (string, []interface{}) {
+	clauses, args := f.clauses()
+	clauses = append([]string{"deleted_at IS NULL"}, clauses...)
+	return "WHERE " + strings.Join(clauses, " AND "), args
+}
+
+// clauses returns the conditions of the filter and the arguments they
+// reference, none for a filter that matches everything
+func (f Filter) clauses() ([]string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+	if !f.Start.IsZero() {
+		clauses = append(clauses, "created_at >= ?")
+		args = append(args, f.Start.UTC())
+	}
+	if !f.End.IsZero() {
+		clauses = append(clauses, "created_at < ?")
+		args = append(args, f.End.UTC())
+	}
+	if len(f.Types) > 0 {
+		clauses = append(clauses, "activity_type IN ("+placeholders(len(f.Types))+")")
+		for _, t := range f.Types {
+			args = append(args, t)
+		}
+	}
+	if len(f.TypesNot) > 0 {
+		clauses = append(clauses, "activity_type NOT IN ("+placeholders(len(f.TypesNot))+")")
+		for _, t := range f.TypesNot {
+			args = append(args, t)
+		}
+	}
+	if f.SessionID != "" {
+		clauses = append(clauses, "session_id = ?")
+		args = append(args, f.SessionID)
+	}
+	if len(f.SessionIDs) > 0 {
+		clauses = append(clauses, "session_id IN ("+placeholders(len(f.SessionIDs))+")")
+		for _, id := range f.SessionIDs {
+			args = append(args, id)
+		}
+	}
+	if f.UserID != "" {
+		clauses = append(clauses, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if f.PathPrefix != "" {
+		// Unlike LIKE, substr has no wildcards to escape
+		clauses = append(clauses, "substr(path, 1, ?) = ?")
+		args = append(args, utf8.RuneCountInString(f.PathPrefix), f.PathPrefix)
+	}
+	if len(f.StatusClasses) > 0 {
+		clauses = append(clauses, "status_code / 100 IN ("+placeholders(len(f.StatusClasses))+")")
+		for _, c := range f.StatusClasses {
+			args = append(args, c)
+		}
+	}
+	if len(f.ResponseClasses) > 0 {
+		clauses = append(clauses, "response_class IN ("+placeholders(len(f.ResponseClasses))+")")
+		for _, c := range f.ResponseClasses {
+			args = append(args, c)
+		}
+	}
+	if f.Source != "" {
+		clauses = append(clauses, "source = ?")
+		args = append(args, f.Source)
+	}
+	if f.Version != "" {
+		clauses = append(clauses, "version = ?")
+		args = append(args, f.Version)
+	}
+	if f.Experiment != "" {
+		path := "$.experiments." + f.Experiment
+		if f.Variant != "" {
+			clauses = append(clauses, "json_extract("+detailsJSON+", ?) = ?")
+			args = append(args, path, f.Variant)
+		} else {
+			clauses = append(clauses, "json_extract("+detailsJSON+", ?) IS NOT NULL")
+			args = append(args, path)
+		}
+	}
+	names := make([]string, 0, len(f.Tags))
+	for name := range f.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		clauses = append(clauses, "json_extract("+detailsJSON+", ?) = ?")
+		args = append(args, tagPath(name), f.Tags[name])
+	}
+	if len(f.Details) > 0 {
+		more, moreArgs := detailClauses(f.Details)
+		clauses = append(clauses, more...)
+		args = append(args, moreArgs...)
+	}
+	if f.BeforeID > 0 {
+		clauses = append(clauses, "id < ?")
+		args = append(args, f.BeforeID)
+	}
+	return clauses, args
+}
+
+// rollupCompatible tells whether the roll-ups, which only keep the day,
+// type and source of activities, can answer the filter
+func (f Filter) rollupCompatible() bool {
+	return f.SessionID == "" && len(f.SessionIDs) == 0 && f.UserID == "" && f.PathPrefix == "" &&
+		len(f.StatusClasses) == 0 && len(f.ResponseClasses) == 0 && f.Experiment == "" && f.Version == "" && len(f.Tags) == 0 && len(f.Details) == 0 && f.BeforeID == 0
+}
+
+// placeholders returns n comma-separated SQL placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,175 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// matches is the brute-force version of Filter.where, without experiments
+func (f Filter) matches(a ActivityLog) bool {
+	in := func(list []string, v string) bool {
+		for _, item := range list {
+			if item == v {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case !f.Start.IsZero() && a.CreatedAt.Before(f.Start),
+		!f.End.IsZero() && !a.CreatedAt.Before(f.End),
+		len(f.Types) > 0 && !in(f.Types, a.ActivityType),
+		in(f.TypesNot, a.ActivityType),
+		f.SessionID != "" && a.SessionID != f.SessionID,
+		len(f.SessionIDs) > 0 && !in(f.SessionIDs, a.SessionID),
+		!strings.HasPrefix(a.Path, f.PathPrefix),
+		f.Source != "" && a.Source != f.Source,
+		f.Version != "" && a.Version != f.Version,
+		len(f.ResponseClasses) > 0 && !in(f.ResponseClasses, a.ResponseClass):
+		return false
+	}
+	if len(f.StatusClasses) > 0 {
+		for _, c := range f.StatusClasses {
+			if a.StatusCode/100 == c {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// pick returns a random subset of values, possibly empty
+func pick(rnd *rand.Rand, values []string) []string {
+	var subset []string
+	for _, v := range values {
+		if rnd.Intn(3) == 0 {
+			subset = append(subset, v)
+		}
+	}
+	return subset
+}
+
+func TestFilterMatchesBruteForce(t *testing.T) {
+	fc := setupTestDB(t)
+	rnd := rand.New(rand.NewSource(1))
+	types := []string{ActivityTypePageView, ActivityTypeProductView, ActivityTypeAddToCart, ActivityTypeCheckout, "other"}
+	sessions := []string{"s1", "s2", "s3", "s4", "s5", "s6"}
+	paths := []string{"/", "/product/A1", "/product/B2", "/cart", "/cart/checkout", "/100%_off"}
+	sources := []string{SourceWeb, SourceLoadGenerator, ""}
+	versions := []string{"1.0.0", "1.1.0", ""}
+	statuses := []int{200, 302, 404, 422, 500, 503}
+	responseClasses := []string{ResponseClassPage, ResponseClassRedirect, ResponseClassError, ResponseClassAPI}
+
+	start := fc.Now()
+	var all []ActivityLog
+	for i := 0; i < 300; i++ {
+		a := ActivityLog{
+			SessionID:    sessions[rnd.Intn(len(sessions))],
+			RequestID:    fmt.Sprintf("request-%d", i),
+			ActivityType: types[rnd.Intn(len(types))],
+			Path:         paths[rnd.Intn(len(paths))],
+			Method:       "GET",
+			StatusCode:   statuses[rnd.Intn(len(statuses))],
+			Source:       sources[rnd.Intn(len(sources))],
+			Version:      versions[rnd.Intn(len(versions))],
+			CreatedAt:    start.Add(time.Duration(i) * time.Minute),
+		}
+		mustLog(t, &a)
+		all = append(all, a)
+	}
+
+	for i := 0; i < 500; i++ {
+		f := Filter{
+			Types:           pick(rnd, types),
+			TypesNot:        pick(rnd, types),
+			SessionIDs:      pick(rnd, sessions),
+			ResponseClasses: pick(rnd, responseClasses),
+		}
+		if rnd.Intn(2) == 0 {
+			f.Start = start.Add(time.Duration(rnd.Intn(300)) * time.Minute)
+		}
+		if rnd.Intn(2) == 0 {
+			f.End = start.Add(time.Duration(rnd.Intn(300)) * time.Minute)
+		}
+		if rnd.Intn(4) == 0 {
+			f.SessionID = sessions[rnd.Intn(len(sessions))]
+		}
+		if rnd.Intn(2) == 0 {
+			p := paths[rnd.Intn(len(paths))]
+			f.PathPrefix = p[:1+rnd.Intn(len(p))]
+		}
+		for c := 1; c <= 5; c++ {
+			if rnd.Intn(3) == 0 {
+				f.StatusClasses = append(f.StatusClasses, c)
+			}
+		}
+		if rnd.Intn(3) == 0 {
+			f.Source = sources[rnd.Intn(2)]
+		}
+		if rnd.Intn(3) == 0 {
+			f.Version = versions[rnd.Intn(2)]
+		}
+
+		var want []int64
+		for _, a := range all {
+			if f.matches(a) {
+				want = append(want, a.ID)
+			}
+		}
+		got, err := GetActivities(f, len(all))
+		if err != nil {
+			t.Fatalf("GetActivities(%+v) failed: %v", f, err)
+		}
+		var gotIDs []int64
+		for _, a := range got {
+			gotIDs = append(gotIDs, a.ID)
+		}
+		sort.Slice(gotIDs, func(i, j int) bool { return gotIDs[i] < gotIDs[j] })
+		if !reflect.DeepEqual(gotIDs, want) {
+			t.Fatalf("GetActivities(%+v) = %v, want %v", f, gotIDs, want)
+		}
+	}
+}
+
+func TestFilterRejectsTooManyValues(t *testing.T) {
+	setupTestDB(t)
+	ids := make([]string, maxFilterValues+1)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("session-%d", i)
+	}
+	_, err := GetActivities(Filter{SessionIDs: ids}, 10)
+	if err == nil || !strings.Contains(err.Error(), "at most 900") {
+		t.Errorf("GetActivities() with %d sessions: err = %v, want the value limit", len(ids), err)
+	}
+
+	// Right at the limit is fine
+	if _, err := GetActivities(Filter{SessionIDs: ids[:maxFilterValues]}, 10); err != nil {
+		t.Errorf("GetActivities() with %d sessions failed: %v", maxFilterValues, err)
+	}
+}
+
+func TestFilterRejectsInvalidStatusClass(t *testing.T) {
+	if err := (Filter{StatusClasses: []int{6}}).Validate(); err == nil {
+		t.Error("Validate() accepted status class 6")
+	}
+}
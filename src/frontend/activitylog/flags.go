@@ -0,0 +1,108 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// flagSetsMigration records the feature flags each activity was served
+// with: the hash of the set on the activity, and the set of each hash in
+// flag_sets
+const flagSetsMigration = `
+	ALTER TABLE activities ADD COLUMN flags_hash TEXT;
+	CREATE TABLE IF NOT EXISTS flag_sets (
+		hash TEXT PRIMARY KEY,
+		flags TEXT NOT NULL,
+		first_seen_at DATETIME NOT NULL
+	);`
+
+// FlagSet is a set of feature flags the frontend ran with
+type FlagSet struct {
+	Hash  string          `json:"hash"`
+	Flags map[string]bool `json:"flags"`
+	// FirstSeenAt is when a frontend first started with the set
+	FirstSeenAt time.Time `json:"first_seen_at"`
+}
+
+// HashFlags returns the hash identifying a set of feature flags. It only
+// depends on the flags and their values, not on the order they come in.
+func HashFlags(flags map[string]bool) string {
+	// Maps are encoded with their keys sorted
+	encoded, _ := json.Marshal(flags)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:8])
+}
+
+// RegisterFlagSet records the feature flags a frontend runs with, so that
+// GetFlagSets resolves their hash, and returns the hash to stamp on
+// activities with WithFlagSet
+func RegisterFlagSet(ctx context.Context, flags map[string]bool) (string, error) {
+	if flags == nil {
+		flags = map[string]bool{}
+	}
+	hash := HashFlags(flags)
+	if err := allowWrite(); err != nil {
+		return hash, err
+	}
+	encoded, err := json.Marshal(flags)
+	if err != nil {
+		return hash, err
+	}
+	_, err = GetDB().ExecContext(ctx, `
+		INSERT INTO flag_sets (hash, flags, first_seen_at) VALUES (?, ?, ?)
+		ON CONFLICT (hash) DO NOTHING`, hash, string(encoded), Now().UTC())
+	return hash, err
+}
+
+// GetFlagSets returns the flag sets with the given hashes, or every one
+// when none is given, in the order they were first seen. Unknown hashes
+// are left out.
+func GetFlagSets(ctx context.Context, hashes []string) ([]FlagSet, error) {
+	query := "SELECT hash, flags, first_seen_at FROM flag_sets"
+	args := make([]interface{}, len(hashes))
+	if len(hashes) > 0 {
+		for i, h := range hashes {
+			args[i] = h
+		}
+		query += " WHERE hash IN (?" + strings.Repeat(", ?", len(hashes)-1) + ")"
+	}
+	query += " ORDER BY first_seen_at, hash"
+
+	rows, err := getReadDB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sets := []FlagSet{}
+	for rows.Next() {
+		var set FlagSet
+		var flags string
+		if err := rows.Scan(&set.Hash, &flags, &set.FirstSeenAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(flags), &set.Flags); err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	return sets, rows.Err()
+}
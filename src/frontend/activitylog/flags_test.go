@@ -0,0 +1,101 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHashFlags(t *testing.T) {
+	a := map[string]bool{"assistant": true, "popularity_badge": false}
+	b := map[string]bool{"popularity_badge": false, "assistant": true}
+	if HashFlags(a) != HashFlags(b) {
+		t.Errorf("HashFlags() depends on the order of the flags")
+	}
+	if flipped := (map[string]bool{"assistant": true, "popularity_badge": true}); HashFlags(flipped) == HashFlags(a) {
+		t.Errorf("HashFlags() doesn't change with the value of a flag")
+	}
+	if HashFlags(a) == HashFlags(nil) || len(HashFlags(a)) != 16 {
+		t.Errorf("HashFlags() = %q, want 16 hex digits telling sets apart", HashFlags(a))
+	}
+}
+
+func TestRegisterFlagSet(t *testing.T) {
+	fc := setupTestDB(t)
+	ctx := context.Background()
+	before := map[string]bool{"assistant": false, "cart_value_details": true}
+	after := map[string]bool{"assistant": true, "cart_value_details": true}
+
+	first, err := RegisterFlagSet(ctx, before)
+	if err != nil {
+		t.Fatalf("RegisterFlagSet() failed: %v", err)
+	}
+	firstSeen := fc.Now()
+	fc.Advance(time.Hour)
+	second, err := RegisterFlagSet(ctx, after)
+	if err != nil {
+		t.Fatalf("RegisterFlagSet() failed: %v", err)
+	}
+	// Restarting with the same flags keeps when they were first seen
+	fc.Advance(time.Hour)
+	if again, err := RegisterFlagSet(ctx, before); err != nil || again != first {
+		t.Fatalf("registering again = %q, %v, want %q", again, err, first)
+	}
+
+	sets, err := GetFlagSets(ctx, nil)
+	if err != nil {
+		t.Fatalf("GetFlagSets() failed: %v", err)
+	}
+	want := []FlagSet{
+		{Hash: first, Flags: before, FirstSeenAt: firstSeen},
+		{Hash: second, Flags: after, FirstSeenAt: firstSeen.Add(time.Hour)},
+	}
+	if !reflect.DeepEqual(sets, want) {
+		t.Errorf("GetFlagSets() = %+v, want %+v", sets, want)
+	}
+
+	sets, err = GetFlagSets(ctx, []string{second, "unknown"})
+	if err != nil {
+		t.Fatalf("GetFlagSets() failed: %v", err)
+	}
+	if len(sets) != 1 || sets[0].Hash != second {
+		t.Errorf("GetFlagSets(%s, unknown) = %+v, want only %s", second, sets, second)
+	}
+}
+
+func TestMiddlewareRecordsFlagSet(t *testing.T) {
+	setupTestDB(t)
+	hash, err := RegisterFlagSet(context.Background(), map[string]bool{"assistant": true})
+	if err != nil {
+		t.Fatalf("RegisterFlagSet() failed: %v", err)
+	}
+	serve(newTestRouter(WithFlagSet(hash)), httptest.NewRequest(http.MethodGet, "/", nil), "session-1")
+	if a := lastActivity(t); a.FlagsHash != hash {
+		t.Errorf("flags_hash = %q, want %q", a.FlagsHash, hash)
+	}
+
+	stats, err := GetGroupedStats(context.Background(), Now().Add(-time.Hour), Now().Add(time.Hour), []string{"flags_hash"})
+	if err != nil {
+		t.Fatalf("GetGroupedStats() failed: %v", err)
+	}
+	if !reflect.DeepEqual(stats, map[string]interface{}{hash: 1}) {
+		t.Errorf("GetGroupedStats(flags_hash) = %v, want 1 activity with %s", stats, hash)
+	}
+}
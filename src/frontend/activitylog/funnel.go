@@ -0,0 +1,154 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFunnelSteps bounds the number of joins of a funnel query
+const maxFunnelSteps = 8
+
+// DefaultFunnel is the path from looking at a product to buying it
+var DefaultFunnel = []string{
+	ActivityTypeProductView,
+	ActivityTypeAddToCart,
+	ActivityTypeViewCart,
+	ActivityTypeCheckout,
+}
+
+// FunnelStep is how many sessions, or devices, got to one step of a funnel
+type FunnelStep struct {
+	ActivityType string `json:"activity_type"`
+	Sessions     int    `json:"sessions"`
+	// Failed is how many sessions at the previous step attempted this one
+	// without it going through, such as a cart add the cart service
+	// refused. They aren't counted in Sessions.
+	Failed int `json:"failed"`
+	// Conversion is the fraction of the sessions at the previous step that
+	// got to this one, 1 for the first step and 0 after an empty one.
+	Conversion float64 `json:"conversion"`
+}
+
+// ValidateFunnel checks that steps are between one and maxFunnelSteps
+// registered activity types
+func ValidateFunnel(steps []string) error {
+	if len(steps) == 0 {
+		return errors.New("a funnel needs at least one step")
+	}
+	if len(steps) > maxFunnelSteps {
+		return fmt.Errorf("funnel has %d steps, at most %d are supported", len(steps), maxFunnelSteps)
+	}
+	for _, step := range steps {
+		if !IsActivityType(step) {
+			return fmt.Errorf("unknown activity type %q", step)
+		}
+	}
+	return nil
+}
+
+// GetFunnel counts the sessions that went through the steps of a funnel,
+// in order, during a given time period. A session reaches a step with its
+// first activity of that type after it reached the previous step, so the
+// same type can appear twice. Failed checkouts and cart adds don't count,
+// they are counted apart. Neither do the pages shown right after a
+// redirect, such as the cart after a cart add: the redirect already is the
+// action.
+func GetFunnel(ctx context.Context, startTime, endTime time.Time, steps []string) ([]FunnelStep, error) {
+	return GetFunnelBy(ctx, startTime, endTime, steps, IdentitySession)
+}
+
+// GetFunnelBy is GetFunnel counting the sessions or the devices, as
+// identity says, that went through the steps. A device can reach a step
+// in a later session than the previous one.
+func GetFunnelBy(ctx context.Context, startTime, endTime time.Time, steps []string, identity string) ([]FunnelStep, error) {
+	if err := ValidateFunnel(steps); err != nil {
+		return nil, err
+	}
+	if _, err := ParseIdentity(identity); err != nil {
+		return nil, err
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "funnel", time.Now())
+	return funnel(ctx, getReadDB(), startTime, endTime, steps, identity)
+}
+
+// funnel is GetFunnelBy run by q, once the steps and identity are
+// validated. Callers hold an analytical query slot.
+func funnel(ctx context.Context, q queryer, startTime, endTime time.Time, steps []string, identity string) ([]FunnelStep, error) {
+	id := identityColumn(identity, "a")
+	// Each step is a CTE holding when every session first reached it
+	var ctes, counts, failed []string
+	var args, failedArgs []interface{}
+	for i, step := range steps {
+		name := "step" + strconv.Itoa(i)
+		from := `
+			FROM activities a`
+		if i > 0 {
+			from += `
+			JOIN step` + strconv.Itoa(i-1) + ` p ON p.shopper = ` + id + ` AND a.created_at > p.at`
+		}
+		from += `
+			WHERE a.activity_type = ? AND ` + createdIn("a.created_at") + ` AND a.deleted_at IS NULL
+			  AND NOT ` + redirectFollowUp("a")
+		ctes = append(ctes, name+` AS (
+			SELECT `+id+` AS shopper, MIN(a.created_at) AS at`+from+`
+			  AND NOT `+failedAttempt+`
+			GROUP BY shopper)`)
+		counts = append(counts, "(SELECT COUNT(*) FROM "+name+")")
+		args = append(args, step, startTime.UTC(), endTime.UTC())
+		// Sessions that only failed at the step
+		failed = append(failed, `(SELECT COUNT(DISTINCT `+id+`)`+from+`
+			  AND `+failedAttempt+`
+			  AND `+id+` NOT IN (SELECT shopper FROM `+name+`))`)
+		failedArgs = append(failedArgs, step, startTime.UTC(), endTime.UTC())
+	}
+	query := "WITH " + strings.Join(ctes, ",\n") + "\nSELECT " + strings.Join(append(counts, failed...), ", ")
+	args = append(args, failedArgs...)
+
+	sessions := make([]int, len(steps))
+	failures := make([]int, len(steps))
+	dest := make([]interface{}, 0, 2*len(steps))
+	for i := range sessions {
+		dest = append(dest, &sessions[i])
+	}
+	for i := range failures {
+		dest = append(dest, &failures[i])
+	}
+	if err := q.QueryRowContext(ctx, query, args...).Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	funnel := make([]FunnelStep, len(steps))
+	for i, step := range steps {
+		funnel[i] = FunnelStep{ActivityType: step, Sessions: sessions[i], Failed: failures[i], Conversion: 1}
+		if i > 0 {
+			funnel[i].Conversion = 0
+			if sessions[i-1] > 0 {
+				funnel[i].Conversion = float64(sessions[i]) / float64(sessions[i-1])
+			}
+		}
+	}
+	return funnel, nil
+}
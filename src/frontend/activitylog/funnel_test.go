@@ -0,0 +1,109 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// logJourney logs the given activity types for a session, a minute apart
+func logJourney(t *testing.T, fc *FakeClock, sessionID string, types ...string) {
+	t.Helper()
+	for _, activityType := range types {
+		fc.Advance(time.Minute)
+		a := ActivityLog{SessionID: sessionID, ActivityType: activityType}
+		if activityType == ActivityTypeCheckout && sessionID == "failed" {
+			a.Details = `{"failed":true}`
+		}
+		mustLog(t, &a)
+	}
+}
+
+func TestGetFunnel(t *testing.T) {
+	fc := setupTestDB(t)
+	start := fc.Now()
+	logJourney(t, fc, "buyer", ActivityTypeProductView, ActivityTypeAddToCart, ActivityTypeViewCart, ActivityTypeCheckout)
+	logJourney(t, fc, "browser", ActivityTypeProductView, ActivityTypeProductView)
+	logJourney(t, fc, "abandoner", ActivityTypeProductView, ActivityTypeAddToCart, ActivityTypeViewCart)
+	// Steps out of order don't count
+	logJourney(t, fc, "shortcut", ActivityTypeViewCart, ActivityTypeProductView, ActivityTypeAddToCart)
+	logJourney(t, fc, "failed", ActivityTypeProductView, ActivityTypeAddToCart, ActivityTypeViewCart, ActivityTypeCheckout)
+	end := fc.Now().Add(time.Minute)
+
+	got, err := GetFunnel(context.Background(), start, end, DefaultFunnel)
+	if err != nil {
+		t.Fatalf("GetFunnel() failed: %v", err)
+	}
+	want := []FunnelStep{
+		{ActivityType: ActivityTypeProductView, Sessions: 5, Conversion: 1},
+		{ActivityType: ActivityTypeAddToCart, Sessions: 4, Conversion: 0.8},
+		{ActivityType: ActivityTypeViewCart, Sessions: 3, Conversion: 0.75},
+		{ActivityType: ActivityTypeCheckout, Sessions: 1, Failed: 1, Conversion: 1.0 / 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetFunnel(default) = %+v, want %+v", got, want)
+	}
+
+	// A custom funnel, repeating a step
+	got, err = GetFunnel(context.Background(), start, end, []string{ActivityTypeProductView, ActivityTypeProductView})
+	if err != nil {
+		t.Fatalf("GetFunnel() failed: %v", err)
+	}
+	if got[1].Sessions != 1 {
+		t.Errorf("GetFunnel(product_view twice) = %+v, want one session viewing twice", got)
+	}
+}
+
+func TestGetFunnelCountsFailedCartAddsApart(t *testing.T) {
+	fc := setupTestDB(t)
+	start := fc.Now()
+	logJourney(t, fc, "buyer", ActivityTypeProductView, ActivityTypeAddToCart)
+	// The cart service refused the add, then took it on the second try
+	logJourney(t, fc, "retrier", ActivityTypeProductView)
+	fc.Advance(time.Minute)
+	mustLog(t, &ActivityLog{SessionID: "retrier", ActivityType: ActivityTypeAddToCart, Details: `{"result":"failed","cart_service_ok":false}`})
+	logJourney(t, fc, "retrier", ActivityTypeAddToCart)
+	// The cart service refused every add
+	logJourney(t, fc, "refused", ActivityTypeProductView)
+	fc.Advance(time.Minute)
+	mustLog(t, &ActivityLog{SessionID: "refused", ActivityType: ActivityTypeAddToCart, Details: `{"result":"failed","cart_service_ok":false}`})
+	end := fc.Now().Add(time.Minute)
+
+	got, err := GetFunnel(context.Background(), start, end, []string{ActivityTypeProductView, ActivityTypeAddToCart})
+	if err != nil {
+		t.Fatalf("GetFunnel() failed: %v", err)
+	}
+	if got[1].Sessions != 2 || got[1].Failed != 1 || got[1].Conversion != 2.0/3 {
+		t.Errorf("add_to_cart step = %+v, want 2 sessions and 1 that only failed", got[1])
+	}
+}
+
+func TestValidateFunnel(t *testing.T) {
+	for _, steps := range [][]string{
+		nil,
+		{ActivityTypeProductView, "wishlist"},
+		make([]string, maxFunnelSteps+1),
+	} {
+		if err := ValidateFunnel(steps); err == nil {
+			t.Errorf("ValidateFunnel(%q) succeeded", steps)
+		}
+	}
+	if err := ValidateFunnel(DefaultFunnel); err != nil {
+		t.Errorf("ValidateFunnel(DefaultFunnel) = %v", err)
+	}
+}
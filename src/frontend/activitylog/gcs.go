@@ -0,0 +1,250 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// Archive exporter defaults
+const (
+	defaultArchiveMaxRetries   = 3
+	defaultArchiveRetryBackoff = 2 * time.Second
+	defaultArchiveTimeout      = 5 * time.Minute
+)
+
+// Bucket stores archive objects
+type Bucket interface {
+	// Upload creates or replaces an object with the content read from r
+	Upload(ctx context.Context, object string, r io.Reader) error
+}
+
+// GCSBucket is a Cloud Storage bucket, written to with the Cloud Storage
+// client as the application default credentials, which under workload
+// identity are those of the workload's service account, so no key is
+// needed. Objects are sent in resumable uploads.
+type GCSBucket struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+}
+
+// NewGCSBucket returns the bucket with the given name, reached with the
+// given client options on top of the defaults
+func NewGCSBucket(ctx context.Context, name string, opts ...option.ClientOption) (*GCSBucket, error) {
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating the Cloud Storage client: %w", err)
+	}
+	return &GCSBucket{client: client, bucket: client.Bucket(name)}, nil
+}
+
+// Upload writes the object, replacing it if it exists. An upload that
+// fails partway is abandoned rather than leaving a partial object.
+func (b *GCSBucket) Upload(ctx context.Context, object string, r io.Reader) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultArchiveTimeout)
+	defer cancel()
+	w := b.bucket.Object(object).NewWriter(ctx)
+	w.ContentType = "application/gzip"
+	if _, err := io.Copy(w, r); err != nil {
+		// Canceling the context of a Writer aborts its upload
+		cancel()
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Close releases the client of the bucket
+func (b *GCSBucket) Close() error {
+	return b.client.Close()
+}
+
+// permanentUploadError reports whether err is an error retrying the
+// upload won't fix, such as a missing bucket or permission
+func permanentUploadError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return apiErr.Code >= 400 && apiErr.Code < 500
+}
+
+// ArchiveUpload describes an archive uploaded by an ArchiveExporter
+type ArchiveUpload struct {
+	Object     string    `json:"object"`
+	Day        string    `json:"day"`
+	Activities int       `json:"activities"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// ArchiveStatus is what an ArchiveExporter last did
+type ArchiveStatus struct {
+	Bucket      string         `json:"bucket"`
+	LastSuccess *ArchiveUpload `json:"last_success"`
+	// LastError is the error of the last export, empty once an export
+	// succeeded again
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// archived is the end of the last day an ArchiveExporter uploaded, which
+// the retention job doesn't expire activities past while one is started
+var archived = struct {
+	sync.Mutex
+	started bool
+	before  time.Time
+}{}
+
+// archivedBefore returns the end of the last archived day, and whether
+// activities are archived at all
+func archivedBefore() (time.Time, bool) {
+	archived.Lock()
+	defer archived.Unlock()
+	return archived.before, archived.started
+}
+
+// ArchiveExporter uploads the archive of each UTC day to a bucket
+type ArchiveExporter struct {
+	bucket     Bucket
+	maxRetries int
+	backoff    time.Duration
+
+	// exporting serializes exports, so that two exports of the same day
+	// can't race on its object
+	exporting sync.Mutex
+	mu        sync.Mutex
+	status    ArchiveStatus
+}
+
+// NewArchiveExporter creates an exporter uploading to bucket, called
+// bucketName in its status
+func NewArchiveExporter(bucket Bucket, bucketName string) *ArchiveExporter {
+	return &ArchiveExporter{
+		bucket:     bucket,
+		maxRetries: defaultArchiveMaxRetries,
+		backoff:    defaultArchiveRetryBackoff,
+		status:     ArchiveStatus{Bucket: bucketName},
+	}
+}
+
+// Start exports the previous UTC day right away and then every interval,
+// until ctx is done. Exporting a day again replaces its archive, which
+// picks up activities that were logged late. From then on, the retention
+// job keeps the activities until their day is archived.
+func (e *ArchiveExporter) Start(ctx context.Context, interval time.Duration) {
+	archived.Lock()
+	archived.started = true
+	archived.Unlock()
+	go func() {
+		for {
+			if _, err := e.ExportDay(ctx, truncateDay(Now()).Add(-day)); err != nil && ctx.Err() == nil {
+				logger.WithError(err).Warn("failed to export the activity archive")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+// ExportDay writes the archive of the UTC day d falls in to a temporary
+// file and uploads it, retrying failed uploads with a growing backoff unless
+// the bucket refused them for good
+func (e *ArchiveExporter) ExportDay(ctx context.Context, d time.Time) (ArchiveUpload, error) {
+	e.exporting.Lock()
+	defer e.exporting.Unlock()
+
+	upload, err := e.exportDay(ctx, truncateDay(d))
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		now := Now()
+		e.status.LastError = err.Error()
+		e.status.LastErrorAt = &now
+		return upload, err
+	}
+	e.status.LastSuccess = &upload
+	archived.Lock()
+	if end := truncateDay(d).Add(day); end.After(archived.before) {
+		archived.before = end
+	}
+	archived.Unlock()
+	e.status.LastError = ""
+	e.status.LastErrorAt = nil
+	logger.WithFields(logrus.Fields{
+		"object":     upload.Object,
+		"activities": upload.Activities,
+	}).Info("exported the activity archive")
+	return upload, nil
+}
+
+func (e *ArchiveExporter) exportDay(ctx context.Context, start time.Time) (ArchiveUpload, error) {
+	upload := ArchiveUpload{Object: ArchiveObject(start, 0), Day: start.Format(dateLayout)}
+	f, err := os.CreateTemp("", "activities-*.ndjson.gz")
+	if err != nil {
+		return upload, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if upload.Activities, err = WriteArchive(ctx, f, start, start.Add(day)); err != nil {
+		return upload, fmt.Errorf("writing the archive: %w", err)
+	}
+
+	backoff := e.backoff
+	for attempt := 0; ; attempt++ {
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return upload, err
+		}
+		if err = e.bucket.Upload(ctx, upload.Object, f); err == nil {
+			upload.UploadedAt = Now()
+			return upload, nil
+		}
+		if attempt >= e.maxRetries || permanentUploadError(err) {
+			return upload, err
+		}
+		select {
+		case <-ctx.Done():
+			return upload, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Status returns the last successful upload and the last error
+func (e *ArchiveExporter) Status() ArchiveStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status
+}
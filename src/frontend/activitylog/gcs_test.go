@@ -0,0 +1,205 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// fakeBucket keeps uploaded objects in memory, failing the first failures
+// uploads with err, a 503 by default
+type fakeBucket struct {
+	failures int
+	err      error
+	attempts int
+	objects  map[string][]byte
+}
+
+func (b *fakeBucket) Upload(_ context.Context, object string, r io.Reader) error {
+	b.attempts++
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if b.attempts <= b.failures {
+		if b.err != nil {
+			return b.err
+		}
+		return errors.New("503 Service Unavailable")
+	}
+	if b.objects == nil {
+		b.objects = make(map[string][]byte)
+	}
+	b.objects[object] = content
+	return nil
+}
+
+// readArchive decodes the activities of a gzipped NDJSON archive
+func readArchive(t *testing.T, archive []byte) []ActivityLog {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("archive isn't gzipped: %v", err)
+	}
+	var activities []ActivityLog
+	lines := bufio.NewScanner(zr)
+	for lines.Scan() {
+		var a ActivityLog
+		if err := json.Unmarshal(lines.Bytes(), &a); err != nil {
+			t.Fatalf("archive line %q isn't an activity: %v", lines.Text(), err)
+		}
+		activities = append(activities, a)
+	}
+	if err := lines.Err(); err != nil {
+		t.Fatalf("reading the archive failed: %v", err)
+	}
+	return activities
+}
+
+func TestArchiveObject(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	for _, tc := range []struct {
+		day  time.Time
+		part int
+		want string
+	}{
+		{time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), 0, "activities/dt=2025-06-01/part-0.ndjson.gz"},
+		{time.Date(2025, 6, 1, 23, 59, 59, 0, time.UTC), 3, "activities/dt=2025-06-01/part-3.ndjson.gz"},
+		// Partitions are UTC days
+		{time.Date(2025, 6, 2, 8, 0, 0, 0, tokyo), 0, "activities/dt=2025-06-01/part-0.ndjson.gz"},
+	} {
+		if got := ArchiveObject(tc.day, tc.part); got != tc.want {
+			t.Errorf("ArchiveObject(%v, %d) = %q, want %q", tc.day, tc.part, got, tc.want)
+		}
+	}
+}
+
+func TestExportDayRetriesAndRecordsStatus(t *testing.T) {
+	fc := setupTestDB(t)
+	dayStart := fc.Now().Add(-12 * time.Hour)
+	mustLog(t, &ActivityLog{SessionID: "early", ActivityType: ActivityTypePageView, CreatedAt: dayStart.Add(-time.Second)})
+	mustLog(t, &ActivityLog{SessionID: "first", ActivityType: ActivityTypePageView, CreatedAt: dayStart})
+	mustLog(t, &ActivityLog{SessionID: "last", ActivityType: ActivityTypeCheckout, CreatedAt: dayStart.Add(day - time.Second)})
+	mustLog(t, &ActivityLog{SessionID: "next day", ActivityType: ActivityTypePageView, CreatedAt: dayStart.Add(day)})
+
+	bucket := &fakeBucket{failures: 2}
+	e := NewArchiveExporter(bucket, "shop-archives")
+	e.backoff = time.Millisecond
+	upload, err := e.ExportDay(context.Background(), fc.Now())
+	if err != nil {
+		t.Fatalf("ExportDay() failed: %v", err)
+	}
+	if bucket.attempts != 3 {
+		t.Errorf("uploaded %d times, want 2 failures and a success", bucket.attempts)
+	}
+	const object = "activities/dt=2025-06-01/part-0.ndjson.gz"
+	if upload.Object != object || upload.Day != "2025-06-01" || upload.Activities != 2 {
+		t.Errorf("upload = %+v", upload)
+	}
+	archived := readArchive(t, bucket.objects[object])
+	if len(archived) != 2 || archived[0].SessionID != "first" || archived[1].SessionID != "last" {
+		t.Errorf("archived %+v, want the two activities of the day, oldest first", archived)
+	}
+	if s := e.Status(); s.Bucket != "shop-archives" || s.LastSuccess == nil || *s.LastSuccess != upload || s.LastError != "" {
+		t.Errorf("status = %+v, want the upload as the last success", s)
+	}
+
+	// A failed export keeps the last success
+	bucket.failures, bucket.attempts = 10, 0
+	if _, err := e.ExportDay(context.Background(), fc.Now()); err == nil {
+		t.Fatal("ExportDay() succeeded although every upload failed")
+	}
+	if bucket.attempts != defaultArchiveMaxRetries+1 {
+		t.Errorf("uploaded %d times, want %d", bucket.attempts, defaultArchiveMaxRetries+1)
+	}
+	if s := e.Status(); s.LastSuccess == nil || *s.LastSuccess != upload || s.LastError == "" || s.LastErrorAt == nil {
+		t.Errorf("status = %+v, want the error next to the last success", s)
+	}
+}
+
+func TestExportDayGivesUpOnRefusedUploads(t *testing.T) {
+	fc := setupTestDB(t)
+	bucket := &fakeBucket{failures: 10, err: &googleapi.Error{Code: http.StatusForbidden, Message: "no access"}}
+	e := NewArchiveExporter(bucket, "shop-archives")
+	e.backoff = time.Millisecond
+	if _, err := e.ExportDay(context.Background(), fc.Now()); err == nil {
+		t.Fatal("ExportDay() succeeded although the bucket refused the upload")
+	}
+	if bucket.attempts != 1 {
+		t.Errorf("uploaded %d times, want a refused upload not retried", bucket.attempts)
+	}
+
+	bucket.err, bucket.attempts = &googleapi.Error{Code: http.StatusTooManyRequests}, 0
+	e.ExportDay(context.Background(), fc.Now())
+	if bucket.attempts != defaultArchiveMaxRetries+1 {
+		t.Errorf("uploaded %d times, want a throttled upload retried", bucket.attempts)
+	}
+}
+
+func TestGCSBucketUpload(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if bytes.Contains(body, []byte(`"name":"forbidden"`)) {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"error":{"code":403,"message":"no access"}}`)
+			return
+		}
+		io.WriteString(w, `{"bucket":"shop archives","name":"object"}`)
+	}))
+	defer srv.Close()
+
+	b, err := NewGCSBucket(context.Background(), "shop archives", option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("NewGCSBucket() failed: %v", err)
+	}
+	defer b.Close()
+	const object = "activities/dt=2025-06-01/part-0.ndjson.gz"
+	if err := b.Upload(context.Background(), object, bytes.NewReader([]byte("archive"))); err != nil {
+		t.Fatalf("Upload() failed: %v", err)
+	}
+	if got.Method != http.MethodPost || got.URL.EscapedPath() != "/upload/storage/v1/b/shop%20archives/o" {
+		t.Errorf("request = %s %s", got.Method, got.URL.EscapedPath())
+	}
+	for _, want := range []string{`"name":"` + object + `"`, `"contentType":"application/gzip"`, "archive"} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("body = %q, want %s in it", body, want)
+		}
+	}
+
+	err = b.Upload(context.Background(), "forbidden", bytes.NewReader(nil))
+	if err == nil {
+		t.Fatal("Upload() succeeded although the bucket refused it")
+	}
+	if !permanentUploadError(err) {
+		t.Errorf("refused upload failed with %v, want a permanent error", err)
+	}
+}
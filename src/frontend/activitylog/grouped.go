@@ -0,0 +1,151 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxGroupBy is how many dimensions activities can be grouped by at once
+const MaxGroupBy = 2
+
+// groupDimensions are the expressions of the dimensions activities can be
+// grouped by. They are columns with few distinct values, so that the groups
+// stay few. Activities without a value, such as those logged before the
+// column was added, are grouped under SplitUnknown.
+var groupDimensions = map[string]string{
+	"activity_type": "activity_type",
+	"method":        "method",
+	"user_currency": "COALESCE(NULLIF(user_currency, ''), '" + SplitUnknown + "')",
+	"source":        "COALESCE(NULLIF(source, ''), '" + SplitUnknown + "')",
+	"version":       "COALESCE(NULLIF(version, ''), '" + SplitUnknown + "')",
+	"revision":      "COALESCE(NULLIF(revision, ''), '" + SplitUnknown + "')",
+	"referrer_type": "COALESCE(NULLIF(referrer_type, ''), '" + SplitUnknown + "')",
+	"utm_campaign":  "COALESCE(NULLIF(utm_campaign, ''), '" + SplitUnknown + "')",
+	// Routes are few, unlike the paths matching them
+	"route_template": "COALESCE(NULLIF(route_template, ''), '" + SplitUnknown + "')",
+	"response_class": "COALESCE(response_class, '" + SplitUnknown + "')",
+	// The feature flags change with deployments, a few sets at a time
+	"flags_hash":   "COALESCE(NULLIF(flags_hash, ''), '" + SplitUnknown + "')",
+	"status_class": "CASE WHEN COALESCE(status_code, 0) BETWEEN 100 AND 599 THEN (status_code / 100) || 'xx' ELSE '" + SplitUnknown + "' END",
+}
+
+// highCardinalityDimensions are columns that can't be grouped by, because
+// there would be about as many groups as activities
+var highCardinalityDimensions = map[string]bool{
+	"session_id":      true,
+	"user_id":         true,
+	"request_id":      true,
+	"path":            true,
+	"product_id":      true,
+	"referrer_domain": true,
+}
+
+// GroupDimensions returns the dimensions activities can be grouped by,
+// sorted
+func GroupDimensions() []string {
+	dims := make([]string, 0, len(groupDimensions))
+	for d := range groupDimensions {
+		dims = append(dims, d)
+	}
+	sort.Strings(dims)
+	return dims
+}
+
+// ValidateGroupBy checks that activities can be grouped by dims: one or
+// two distinct dimensions of GroupDimensions
+func ValidateGroupBy(dims []string) error {
+	if len(dims) == 0 || len(dims) > MaxGroupBy {
+		return fmt.Errorf("must group by 1 to %d dimensions, got %d", MaxGroupBy, len(dims))
+	}
+	for i, d := range dims {
+		if highCardinalityDimensions[d] {
+			return fmt.Errorf("can't group by %s, it has too many distinct values", d)
+		}
+		if _, ok := groupDimensions[d]; !ok {
+			return fmt.Errorf("unknown dimension %q, must be one of %s", d, strings.Join(GroupDimensions(), ", "))
+		}
+		if i > 0 && dims[0] == d {
+			return fmt.Errorf("can't group by %s twice", d)
+		}
+	}
+	return nil
+}
+
+// GetGroupedStats returns the number of activities of a given time period
+// per value of each dimension in dims. Grouped by one dimension, counts are
+// keyed by its values; by two, they are keyed by the values of the first,
+// then of the second, such as {"page_view": {"USD": 3, "EUR": 1}}.
+func GetGroupedStats(ctx context.Context, startTime, endTime time.Time, dims []string) (map[string]interface{}, error) {
+	if err := ValidateGroupBy(dims); err != nil {
+		return nil, err
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Only expressions of the registry make it into the query
+	groups := make([]string, len(dims))
+	for i, d := range dims {
+		groups[i] = groupDimensions[d] + fmt.Sprintf(" AS g%d", i)
+	}
+	groupBy := "g0"
+	if len(dims) == 2 {
+		groupBy = "g0, g1"
+	}
+	query := `
+		SELECT ` + strings.Join(groups, ", ") + `, COUNT(*)
+		FROM activities
+		WHERE ` + createdIn("created_at") + ` AND deleted_at IS NULL
+		GROUP BY ` + groupBy
+
+	start := time.Now()
+	rows, err := getReadDB().QueryContext(ctx, query, startTime.UTC(), endTime.UTC())
+	observeQuery(ctx, "grouped stats", start)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := map[string]interface{}{}
+	for rows.Next() {
+		var first, second string
+		var count int
+		dest := []interface{}{&first, &count}
+		if len(dims) == 2 {
+			dest = []interface{}{&first, &second, &count}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if len(dims) == 1 {
+			stats[first] = count
+			continue
+		}
+		inner, ok := stats[first].(map[string]int)
+		if !ok {
+			inner = map[string]int{}
+			stats[first] = inner
+		}
+		inner[second] = count
+	}
+	return stats, rows.Err()
+}
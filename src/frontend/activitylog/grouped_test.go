@@ -0,0 +1,154 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// groupValues computes the value of each dimension of an activity the way
+// the database should
+var groupValues = map[string]func(a ActivityLog) string{
+	"activity_type":  func(a ActivityLog) string { return a.ActivityType },
+	"method":         func(a ActivityLog) string { return a.Method },
+	"user_currency":  func(a ActivityLog) string { return orUnknown(a.UserCurrency) },
+	"source":         func(a ActivityLog) string { return orUnknown(a.Source) },
+	"version":        func(a ActivityLog) string { return orUnknown(a.Version) },
+	"revision":       func(a ActivityLog) string { return orUnknown(a.Revision) },
+	"referrer_type":  func(a ActivityLog) string { return orUnknown(a.ReferrerType) },
+	"utm_campaign":   func(a ActivityLog) string { return orUnknown(a.Campaign) },
+	"route_template": func(a ActivityLog) string { return orUnknown(a.RouteTemplate) },
+	"flags_hash":     func(a ActivityLog) string { return orUnknown(a.FlagsHash) },
+	"response_class": func(a ActivityLog) string { return orUnknown(a.ResponseClass) },
+	"status_class": func(a ActivityLog) string {
+		if a.StatusCode == 0 {
+			return SplitUnknown
+		}
+		return strconv.Itoa(a.StatusCode/100) + "xx"
+	},
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return SplitUnknown
+	}
+	return s
+}
+
+func seedGroupedActivities(t *testing.T) []ActivityLog {
+	seeded := []ActivityLog{
+		{ActivityType: ActivityTypePageView, Method: "GET", StatusCode: 200, UserCurrency: "USD", Source: SourceWeb,
+			Version: "v1", Revision: "r1", ReferrerType: ReferrerDirect, RouteTemplate: "/", FlagsHash: "a1"},
+		{ActivityType: ActivityTypePageView, Method: "GET", StatusCode: 200, UserCurrency: "EUR", Source: SourceWeb,
+			Version: "v2", Revision: "r2", ReferrerType: ReferrerExternal, Campaign: "spring", RouteTemplate: "/", FlagsHash: "b2"},
+		{ActivityType: ActivityTypeAddToCart, Method: "POST", StatusCode: 302, UserCurrency: "USD", Source: SourceLoadGenerator,
+			Version: "v2", Revision: "r2", ReferrerType: ReferrerInternal, Campaign: "spring", RouteTemplate: "/cart", FlagsHash: "b2"},
+		{ActivityType: ActivityTypeCheckout, Method: "POST", StatusCode: 500, UserCurrency: "EUR", Source: SourceWeb,
+			Version: "v1", Revision: "r1", ReferrerType: ReferrerInternal},
+		// Logged before any of the optional columns were recorded
+		{ActivityType: ActivityTypeProductView, Method: "GET"},
+	}
+	for i := range seeded {
+		mustLog(t, &seeded[i])
+	}
+	return seeded
+}
+
+func TestGetGroupedStatsEveryPair(t *testing.T) {
+	fc := setupTestDB(t)
+	seeded := seedGroupedActivities(t)
+	start, end := fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour)
+
+	dims := GroupDimensions()
+	if len(dims) != len(groupValues) {
+		t.Fatalf("GroupDimensions() = %v, the test knows %d of them", dims, len(groupValues))
+	}
+	for _, first := range dims {
+		want := map[string]interface{}{}
+		for _, a := range seeded {
+			v := groupValues[first](a)
+			n, _ := want[v].(int)
+			want[v] = n + 1
+		}
+		got, err := GetGroupedStats(context.Background(), start, end, []string{first})
+		if err != nil {
+			t.Fatalf("GetGroupedStats(%s) failed: %v", first, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("GetGroupedStats(%s) = %v, want %v", first, got, want)
+		}
+
+		for _, second := range dims {
+			if second == first {
+				continue
+			}
+			want := map[string]interface{}{}
+			for _, a := range seeded {
+				v := groupValues[first](a)
+				inner, ok := want[v].(map[string]int)
+				if !ok {
+					inner = map[string]int{}
+					want[v] = inner
+				}
+				inner[groupValues[second](a)]++
+			}
+			got, err := GetGroupedStats(context.Background(), start, end, []string{first, second})
+			if err != nil {
+				t.Fatalf("GetGroupedStats(%s, %s) failed: %v", first, second, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("GetGroupedStats(%s, %s) = %v, want %v", first, second, got, want)
+			}
+		}
+	}
+}
+
+func TestGetGroupedStatsTimeRange(t *testing.T) {
+	fc := setupTestDB(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	fc.Advance(2 * time.Hour)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+
+	got, err := GetGroupedStats(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour), []string{"activity_type"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{ActivityTypePageView: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetGroupedStats() = %v, want %v", got, want)
+	}
+}
+
+func TestValidateGroupBy(t *testing.T) {
+	for _, dims := range [][]string{
+		nil,
+		{"session_id"},
+		{"activity_type", "path"},
+		{"activity_type", "activity_type"},
+		{"activity_type", "source", "version"},
+		{"activity_type; DROP TABLE activities"},
+		{""},
+	} {
+		if err := ValidateGroupBy(dims); err == nil {
+			t.Errorf("ValidateGroupBy(%q) succeeded, want an error", dims)
+		}
+		if _, err := GetGroupedStats(context.Background(), time.Time{}, time.Now(), dims); err == nil {
+			t.Errorf("GetGroupedStats(%q) succeeded, want an error", dims)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// MaxHeatmapRange is the longest time period a heatmap covers
+const MaxHeatmapRange = 366 * day
+
+// GetActivityHeatmap counts the activities of a type, or of every type
+// when activityType is empty, created during a given time period by day of
+// the week and hour of the day in UTC. Days are indexed by time.Weekday,
+// Sunday first.
+func GetActivityHeatmap(ctx context.Context, startTime, endTime time.Time, activityType string) ([7][24]int, error) {
+	return GetActivityHeatmapIn(ctx, startTime, endTime, activityType, time.UTC)
+}
+
+// GetActivityHeatmapIn is GetActivityHeatmap with days and hours on the
+// wall clock of loc
+func GetActivityHeatmapIn(ctx context.Context, startTime, endTime time.Time, activityType string, loc *time.Location) ([7][24]int, error) {
+	var heatmap [7][24]int
+	if endTime.Sub(startTime) > MaxHeatmapRange {
+		return heatmap, errors.New("a heatmap covers at most 366 days")
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return heatmap, err
+	}
+	defer release()
+	defer observeQuery(ctx, "activity heatmap", time.Now())
+
+	filter := Filter{Start: startTime, End: endTime}
+	if activityType != "" {
+		filter.Types = []string{activityType}
+	}
+	where, args := filter.where()
+	local, localArgs := localSeconds("created_at", loc, startTime, endTime)
+	// The epoch fell on a Thursday
+	query := `
+		SELECT (s / 86400 + 4) % 7 AS weekday, (s % 86400) / 3600 AS hour, COUNT(*)
+		FROM (SELECT ` + local + ` AS s FROM ` + filter.from() + ` ` + where + `)
+		GROUP BY weekday, hour`
+
+	rows, err := getReadDB().QueryContext(ctx, query, append(localArgs, args...)...)
+	if err != nil {
+		return heatmap, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var weekday, hour, count int
+		if err := rows.Scan(&weekday, &hour, &count); err != nil {
+			return heatmap, err
+		}
+		heatmap[weekday][hour] = count
+	}
+	return heatmap, rows.Err()
+}
+
+// HeatmapSamples returns how many hours of [start, end) fall in each cell
+// of a heatmap on loc's wall clock, including hours the range only partly
+// covers. A range that isn't a whole number of weeks covers some cells
+// more often than others, so cells compare by their count per sample.
+func HeatmapSamples(start, end time.Time, loc *time.Location) [7][24]int {
+	var samples [7][24]int
+	if end.Sub(start) > MaxHeatmapRange {
+		end = start.Add(MaxHeatmapRange)
+	}
+	s := start.In(loc)
+	// Step by elapsed hours, so that the hour repeated when clocks go back
+	// is sampled twice and the one skipped when they go forward not at all
+	for t := time.Date(s.Year(), s.Month(), s.Day(), s.Hour(), 0, 0, 0, loc); t.Before(end); t = t.Add(time.Hour) {
+		if !t.Add(time.Hour).After(start) {
+			continue
+		}
+		w := t.In(loc)
+		samples[w.Weekday()][w.Hour()]++
+	}
+	return samples
+}
@@ -0,0 +1,108 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGetActivityHeatmap(t *testing.T) {
+	setupTestDB(t)
+	// Sunday, June 1st 2025
+	sunday := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	mustLog(t, &ActivityLog{SessionID: "a", ActivityType: ActivityTypeCheckout, CreatedAt: sunday.Add(23*time.Hour + 30*time.Minute)})
+	mustLog(t, &ActivityLog{SessionID: "b", ActivityType: ActivityTypeCheckout, CreatedAt: sunday.Add(23 * time.Hour)})
+	mustLog(t, &ActivityLog{SessionID: "c", ActivityType: ActivityTypeCheckout, CreatedAt: sunday.Add(day + 9*time.Hour)})
+	mustLog(t, &ActivityLog{SessionID: "d", ActivityType: ActivityTypePageView, CreatedAt: sunday.Add(day + 9*time.Hour)})
+	// Outside the range
+	mustLog(t, &ActivityLog{SessionID: "e", ActivityType: ActivityTypeCheckout, CreatedAt: sunday.Add(-time.Second)})
+
+	start, end := sunday, sunday.Add(7*day)
+	utc, err := GetActivityHeatmap(context.Background(), start, end, ActivityTypeCheckout)
+	if err != nil {
+		t.Fatalf("GetActivityHeatmap() failed: %v", err)
+	}
+	var want [7][24]int
+	want[time.Sunday][23] = 2
+	want[time.Monday][9] = 1
+	if utc != want {
+		t.Errorf("UTC heatmap = %v, want %v", utc, want)
+	}
+
+	all, err := GetActivityHeatmap(context.Background(), start, end, "")
+	if err != nil {
+		t.Fatalf("GetActivityHeatmap() failed: %v", err)
+	}
+	if all[time.Monday][9] != 2 {
+		t.Errorf("Monday 9:00 has %d activities of any type, want 2", all[time.Monday][9])
+	}
+
+	// Berlin is two hours ahead in summer, so late Sunday is early Monday
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	local, err := GetActivityHeatmapIn(context.Background(), start, end, ActivityTypeCheckout, berlin)
+	if err != nil {
+		t.Fatalf("GetActivityHeatmapIn() failed: %v", err)
+	}
+	want = [7][24]int{}
+	want[time.Monday][1] = 2
+	want[time.Monday][11] = 1
+	if local != want {
+		t.Errorf("Berlin heatmap = %v, want %v", local, want)
+	}
+
+	if _, err := GetActivityHeatmap(context.Background(), start, start.Add(MaxHeatmapRange+time.Hour), ""); err == nil {
+		t.Error("GetActivityHeatmap() accepted a range longer than MaxHeatmapRange")
+	}
+}
+
+func TestHeatmapSamples(t *testing.T) {
+	sunday := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// Ten days sample the first three days of the week twice
+	samples := HeatmapSamples(sunday, sunday.Add(10*day), time.UTC)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		want := 1
+		if d <= time.Tuesday {
+			want = 2
+		}
+		for h := 0; h < 24; h++ {
+			if samples[d][h] != want {
+				t.Fatalf("%s %d:00 sampled %d times, want %d", d, h, samples[d][h], want)
+			}
+		}
+	}
+
+	// Partly covered hours count
+	samples = HeatmapSamples(sunday.Add(90*time.Minute), sunday.Add(150*time.Minute), time.UTC)
+	if samples[time.Sunday][1] != 1 || samples[time.Sunday][2] != 1 || samples[time.Sunday][3] != 0 {
+		t.Errorf("samples around 1:30-2:30 = %v", samples[time.Sunday][:4])
+	}
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	// Clocks went back at 3:00 on Sunday, October 26th 2025, repeating 2:00
+	fallBack := time.Date(2025, 10, 26, 0, 0, 0, 0, berlin)
+	samples = HeatmapSamples(fallBack, fallBack.Add(day), berlin)
+	if samples[time.Sunday][2] != 2 || samples[time.Sunday][3] != 1 {
+		t.Errorf("fall back day sampled 2:00 %d and 3:00 %d times, want 2 and 1", samples[time.Sunday][2], samples[time.Sunday][3])
+	}
+}
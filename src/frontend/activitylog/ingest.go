@@ -0,0 +1,215 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// IngestPath is the route browsers report client events to. Its requests
+// are logged as the event they carry rather than as themselves.
+const IngestPath = "/activities/ingest"
+
+// Client event types, reported by the frontend's JavaScript
+var (
+	ActivityTypePageUnload          = RegisterActivityType("page_unload", "A page left, with the time spent on it, reported by the browser")
+	ActivityTypeScrollDepth         = RegisterActivityType("scroll_depth", "How far down a page was scrolled, reported by the browser")
+	ActivityTypeCarouselInteraction = RegisterActivityType("carousel_interaction", "A product image carousel used, reported by the browser")
+)
+
+// Kinds of client event detail values
+const (
+	clientNumber = "number"
+	clientString = "string"
+)
+
+// clientEventDetails are the client event types accepted, with the details
+// each may carry and the kind of their values. Anything else is rejected.
+var clientEventDetails = map[string]map[string]string{
+	ActivityTypePageUnload:          {"time_on_page_ms": clientNumber, "scroll_depth_percent": clientNumber},
+	ActivityTypeScrollDepth:         {"percent": clientNumber},
+	ActivityTypeCarouselInteraction: {"action": clientString, "index": clientNumber, "product_id": clientString},
+}
+
+const (
+	// MaxClientEventBytes caps the body of a client event
+	MaxClientEventBytes = 2 << 10
+	// maxClientString caps the string details of a client event
+	maxClientString = 64
+
+	// DefaultClientEventsPerMinute is how many client events a session may
+	// report per minute, on average, by default
+	DefaultClientEventsPerMinute = 60
+	// maxClientLimiterSessions bounds the sessions the rate limiter tracks,
+	// so that a flood of fresh session cookies can't exhaust memory
+	maxClientLimiterSessions = 100000
+)
+
+// clientStringFormat matches the string details of client events
+var clientStringFormat = regexp.MustCompile(`^[A-Za-z0-9_-]*$`)
+
+// ErrClientEventRejected wraps the reasons a client event is invalid
+var ErrClientEventRejected = errors.New("invalid client event")
+
+var (
+	// clientEventsAccepted counts client events handed to the middleware;
+	// clientEventsRejected counts those refused, per reason.
+	clientEventsAccepted = expvar.NewInt("activity_log_client_events_total")
+	clientEventsRejected = expvar.NewMap("activity_log_client_events_rejected")
+)
+
+// ClientEvent is what a browser reports to IngestPath
+type ClientEvent struct {
+	Type    string                 `json:"type"`
+	Details map[string]interface{} `json:"details"`
+}
+
+// ParseClientEvent decodes a client event and checks it against the
+// allow-list of types and their details. The caller caps the body at
+// MaxClientEventBytes.
+func ParseClientEvent(body io.Reader) (ClientEvent, error) {
+	var ev ClientEvent
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err := dec.Decode(&ev); err != nil {
+		return ev, fmt.Errorf("%w: %w", ErrClientEventRejected, err)
+	}
+	allowed, ok := clientEventDetails[ev.Type]
+	if !ok {
+		return ev, fmt.Errorf("%w: unknown type %q", ErrClientEventRejected, ev.Type)
+	}
+	for key, value := range ev.Details {
+		kind, ok := allowed[key]
+		if !ok {
+			return ev, fmt.Errorf("%w: %s doesn't take detail %q", ErrClientEventRejected, ev.Type, key)
+		}
+		switch v := value.(type) {
+		case json.Number:
+			f, err := v.Float64()
+			if kind != clientNumber || err != nil || f < 0 || math.IsInf(f, 0) {
+				return ev, fmt.Errorf("%w: detail %s must be a %s", ErrClientEventRejected, key, kind)
+			}
+			ev.Details[key] = f
+		case string:
+			if kind != clientString || len(v) > maxClientString || !clientStringFormat.MatchString(v) {
+				return ev, fmt.Errorf("%w: detail %s must be a %s of up to %d letters, digits, _ and -",
+					ErrClientEventRejected, key, kind, maxClientString)
+			}
+		default:
+			return ev, fmt.Errorf("%w: detail %s must be a %s", ErrClientEventRejected, key, kind)
+		}
+	}
+	return ev, nil
+}
+
+// RecordClientEvent makes the activity logged for the request ctx belongs
+// to the client event it reported, with SourceClient
+func RecordClientEvent(ctx context.Context, ev ClientEvent) {
+	d, ok := ctx.Value(ctxKeyDetails{}).(*requestDetails)
+	if !ok {
+		return
+	}
+	clientEventsAccepted.Add(1)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clientEvent = &ev
+}
+
+// recordedClientEvent returns the client event the handler recorded, if any
+func (d *requestDetails) recordedClientEvent() *ClientEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.clientEvent
+}
+
+// RejectClientEvent counts a client event refused for reason, such as
+// "rate_limited"
+func RejectClientEvent(reason string) {
+	clientEventsRejected.Add(reason, 1)
+}
+
+// clientBucket is the token bucket of a session
+type clientBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// clientLimiter allows each session a number of client events per minute,
+// with bursts of up to that many
+var clientLimiter = struct {
+	sync.Mutex
+	perMinute int
+	buckets   map[string]*clientBucket
+}{perMinute: DefaultClientEventsPerMinute, buckets: make(map[string]*clientBucket)}
+
+// ConfigureClientEvents sets how many client events a session may report
+// per minute. Non-positive values restore the default. Sessions start over
+// with a full allowance.
+func ConfigureClientEvents(perMinute int) {
+	if perMinute <= 0 {
+		perMinute = DefaultClientEventsPerMinute
+	}
+	clientLimiter.Lock()
+	defer clientLimiter.Unlock()
+	clientLimiter.perMinute = perMinute
+	clientLimiter.buckets = make(map[string]*clientBucket)
+}
+
+// AllowClientEvent tells whether a session may report another client event
+// now, and takes it from its allowance if so
+func AllowClientEvent(sessionID string) bool {
+	now := Now()
+	clientLimiter.Lock()
+	defer clientLimiter.Unlock()
+	limit := float64(clientLimiter.perMinute)
+	refill := func(b *clientBucket) {
+		b.tokens = math.Min(limit, b.tokens+now.Sub(b.at).Minutes()*limit)
+		b.at = now
+	}
+
+	b := clientLimiter.buckets[sessionID]
+	if b == nil {
+		if len(clientLimiter.buckets) >= maxClientLimiterSessions {
+			// Forget the sessions back to a full allowance, which are
+			// the same as untracked ones
+			for id, other := range clientLimiter.buckets {
+				if refill(other); other.tokens >= limit {
+					delete(clientLimiter.buckets, id)
+				}
+			}
+			if len(clientLimiter.buckets) >= maxClientLimiterSessions {
+				return false
+			}
+		}
+		b = &clientBucket{tokens: limit, at: now}
+		clientLimiter.buckets[sessionID] = b
+	}
+	refill(b)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,109 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClientEvent(t *testing.T) {
+	for _, tt := range []struct {
+		name, body string
+		ok         bool
+	}{
+		{"page unload", `{"type":"page_unload","details":{"time_on_page_ms":1500}}`, true},
+		{"no details", `{"type":"scroll_depth"}`, true},
+		{"carousel", `{"type":"carousel_interaction","details":{"action":"next","index":2,"product_id":"OLJCESPC7Z"}}`, true},
+		{"unknown type", `{"type":"checkout","details":{}}`, false},
+		{"unknown detail", `{"type":"scroll_depth","details":{"percent":50,"user_id":"u1"}}`, false},
+		{"unknown field", `{"type":"scroll_depth","session_id":"someone-else"}`, false},
+		{"string for number", `{"type":"scroll_depth","details":{"percent":"50"}}`, false},
+		{"number for string", `{"type":"carousel_interaction","details":{"action":1}}`, false},
+		{"negative", `{"type":"page_unload","details":{"time_on_page_ms":-1}}`, false},
+		{"nested", `{"type":"scroll_depth","details":{"percent":{"value":50}}}`, false},
+		{"markup", `{"type":"carousel_interaction","details":{"action":"<script>"}}`, false},
+		{"long string", `{"type":"carousel_interaction","details":{"action":"` + strings.Repeat("a", maxClientString+1) + `"}}`, false},
+		{"not JSON", `type=page_unload`, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, err := ParseClientEvent(strings.NewReader(tt.body))
+			if tt.ok && err != nil {
+				t.Errorf("ParseClientEvent() failed: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrClientEventRejected) {
+				t.Errorf("ParseClientEvent() = %+v, %v, want it rejected", ev, err)
+			}
+		})
+	}
+}
+
+func TestAllowClientEventRefillsPerSession(t *testing.T) {
+	fc := setupTestDB(t)
+	ConfigureClientEvents(2)
+	t.Cleanup(func() { ConfigureClientEvents(0) })
+
+	for i := 0; i < 2; i++ {
+		if !AllowClientEvent("session-1") {
+			t.Fatalf("event %d refused, want a burst of 2 allowed", i+1)
+		}
+	}
+	if AllowClientEvent("session-1") {
+		t.Error("third event allowed, want it over the allowance")
+	}
+	if !AllowClientEvent("session-2") {
+		t.Error("another session's event refused, want sessions limited separately")
+	}
+
+	fc.Advance(30 * time.Second)
+	if !AllowClientEvent("session-1") {
+		t.Error("event refused after half a minute, want one token refilled")
+	}
+	if AllowClientEvent("session-1") {
+		t.Error("second event allowed after half a minute, want one token only")
+	}
+}
+
+func TestMiddlewareLogsClientEvent(t *testing.T) {
+	setupTestDB(t)
+	r := newTestRouter(WithStrictWrites())
+	r.HandleFunc(IngestPath, func(w http.ResponseWriter, r *http.Request) {
+		ev, err := ParseClientEvent(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		RecordClientEvent(r.Context(), ev)
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods(http.MethodPost)
+
+	req := httptest.NewRequest(http.MethodPost, IngestPath,
+		strings.NewReader(`{"type":"page_unload","details":{"time_on_page_ms":1500}}`))
+	serve(r, req, "session-1")
+
+	got := lastActivity(t)
+	if got.ActivityType != ActivityTypePageUnload || got.Source != SourceClient {
+		t.Errorf("activity = %s from %s, want %s from %s", got.ActivityType, got.Source, ActivityTypePageUnload, SourceClient)
+	}
+	if ms := detailsOf(t, got)["time_on_page_ms"]; ms != 1500.0 {
+		t.Errorf("time_on_page_ms = %v, want 1500", ms)
+	}
+	if n := countActivities(t); n != 1 {
+		t.Errorf("%d activities logged, want 1", n)
+	}
+}
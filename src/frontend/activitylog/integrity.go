@@ -0,0 +1,250 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// integrityCheckHour is the UTC hour the nightly integrity check runs at,
+// clear of the roll-ups done right after midnight
+const integrityCheckHour = 3 * time.Hour
+
+// corruptSuffixLayout is the timestamp appended to the files of a corrupt
+// database when they are moved aside
+const corruptSuffixLayout = "20060102T150405Z"
+
+// replacedPoolGrace is how long a replaced read pool stays open for the
+// reads that picked it just before, and how often it is checked for reads
+// still running after that
+const replacedPoolGrace = time.Second
+
+var (
+	// integrityChecks counts integrity checks, corruptions those that found
+	// the database corrupt.
+	integrityChecks = expvar.NewInt("activity_log_integrity_checks_total")
+	corruptions     = expvar.NewInt("activity_log_corruptions_total")
+)
+
+// IntegrityResult is what an integrity check found, and what was done
+// about it
+type IntegrityResult struct {
+	At         time.Time `json:"at"`
+	DurationMs int64     `json:"duration_ms"`
+	OK         bool      `json:"ok"`
+	// Problems are what SQLite reported, capped at the first few
+	Problems []string `json:"problems,omitempty"`
+	// MovedTo is where the corrupt database file was moved to before a
+	// fresh one replaced it, empty when it wasn't corrupt
+	MovedTo string `json:"moved_to,omitempty"`
+	// Recovered is set once a fresh database is in use
+	Recovered bool `json:"recovered"`
+}
+
+// integrity serializes checks and keeps the outcome of the last one, for
+// GetDBStats and the health check
+var integrity = struct {
+	sync.Mutex
+	last *IntegrityResult
+}{}
+
+// maxIntegrityProblems caps the problems an integrity check reports
+const maxIntegrityProblems = 10
+
+// VerifyDB runs PRAGMA integrity_check on a read-only connection to the
+// database. When it finds the database corrupt, the file is moved aside,
+// timestamped, and a fresh database takes its place: the activities logged
+// so far are lost, but logging goes on. Writes wait for the swap rather
+// than fail.
+func VerifyDB(ctx context.Context) (IntegrityResult, error) {
+	integrity.Lock()
+	defer integrity.Unlock()
+
+	path, err := databaseFile(ctx)
+	if err != nil {
+		return IntegrityResult{}, err
+	}
+	start := time.Now()
+	r := IntegrityResult{At: Now().UTC()}
+	r.Problems, err = integrityProblems(ctx, path)
+	r.DurationMs = time.Since(start).Milliseconds()
+	observeQuery(ctx, "integrity check", start)
+	if err != nil {
+		return r, err
+	}
+	integrityChecks.Add(1)
+	r.OK = len(r.Problems) == 0
+	if !r.OK {
+		corruptions.Add(1)
+		logger.WithFields(logrus.Fields{"path": path, "problems": r.Problems}).
+			Error("ACTIVITY DATABASE CORRUPT, replacing it with an empty one")
+		r.MovedTo, err = replaceDB(path, r.At)
+		if err != nil {
+			logger.WithError(err).Error("failed to replace the corrupt activity database")
+		} else {
+			r.Recovered = true
+			logger.WithField("moved_to", r.MovedTo).Error("replaced the corrupt activity database, the activities it held are lost")
+		}
+	}
+	integrity.last = &r
+	return r, err
+}
+
+// integrityProblems returns what PRAGMA integrity_check reports about the
+// database at path, nothing when it is fine. Files too damaged to be read
+// are reported by the error SQLite returns.
+func integrityProblems(ctx context.Context, path string) ([]string, error) {
+	conn, err := sql.Open(sqliteDriver, sqliteDSN(path, dsnOptions{readOnly: true}))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("PRAGMA integrity_check(%d)", maxIntegrityProblems))
+	if err != nil {
+		return corruptionError(err)
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		if p != "ok" {
+			problems = append(problems, p)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return corruptionError(err)
+	}
+	return problems, nil
+}
+
+// corruptionError turns the errors of a database too damaged to be checked
+// into the problem they describe, and returns others as they are
+func corruptionError(err error) ([]string, error) {
+	if isCorruption(err) {
+		return []string{err.Error()}, nil
+	}
+	return nil, err
+}
+
+// replaceDB moves the database at path aside, along with its write-ahead
+// log, and opens a fresh one in its place. It returns where the database
+// was moved to.
+//
+// Writes wait on writeGate meanwhile, so the write connection is closed
+// first: closing it later would remove the write-ahead log of the fresh
+// database, which has its name. Reads go on in the old read pool until the
+// fresh one replaces it, and it is only closed once they are done.
+func replaceDB(path string, at time.Time) (string, error) {
+	writeGate.Lock()
+	defer writeGate.Unlock()
+
+	if conn := db.Load(); conn != nil {
+		if err := conn.Close(); err != nil {
+			logger.WithError(err).Warn("failed to close the corrupt activity database")
+		}
+	}
+	movedTo, err := moveAside(path, at)
+	if err != nil {
+		return "", err
+	}
+	reader := readDB.Load()
+	if err := useDB(path); err != nil {
+		return movedTo, err
+	}
+	if reader != nil {
+		go closeWhenIdle(reader, replacedPoolGrace)
+	}
+	forgetProductViews()
+	return movedTo, nil
+}
+
+// closeWhenIdle closes a read pool that was replaced once the reads that
+// may still have picked it, within grace of the replacement, are done
+func closeWhenIdle(reader *sql.DB, grace time.Duration) {
+	time.Sleep(grace)
+	for reader.Stats().InUse > 0 {
+		time.Sleep(grace)
+	}
+	reader.Close()
+}
+
+// moveAside renames the database at path and its write-ahead log, suffixed
+// with at, and returns the new name of the database
+func moveAside(path string, at time.Time) (string, error) {
+	movedTo := path + ".corrupt-" + at.Format(corruptSuffixLayout)
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		err := os.Rename(path+suffix, movedTo+suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return movedTo, nil
+}
+
+// LastIntegrityCheck returns the outcome of the last integrity check, and
+// false when none ran yet
+func LastIntegrityCheck() (IntegrityResult, bool) {
+	integrity.Lock()
+	defer integrity.Unlock()
+	if integrity.last == nil {
+		return IntegrityResult{}, false
+	}
+	return *integrity.last, true
+}
+
+// StartIntegrityCheck verifies the database every night, replacing it
+// when it is corrupt, and then refreshes the statistics of the query
+// planner, until ctx is done. Corruption otherwise only shows once queries
+// start failing.
+func StartIntegrityCheck(ctx context.Context) {
+	go func() {
+		for {
+			now := Now()
+			next := truncateDay(now).Add(integrityCheckHour)
+			if !next.After(now) {
+				next = next.Add(day)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(next.Sub(now)):
+			}
+			if _, err := VerifyDB(ctx); err != nil && ctx.Err() == nil {
+				logger.WithError(err).Warn("failed to check the integrity of the activity database")
+			}
+			if ReadOnly() {
+				continue
+			}
+			if took, err := Analyze(ctx); err != nil && ctx.Err() == nil {
+				logger.WithError(err).Warn("failed to analyze the activity database")
+			} else if err == nil {
+				logger.WithField("took", took).Info("analyzed the activity database")
+			}
+		}
+	}()
+}
@@ -0,0 +1,203 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVerifyDBHealthy(t *testing.T) {
+	setupTestDB(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+
+	r, err := VerifyDB(context.Background())
+	if err != nil {
+		t.Fatalf("VerifyDB() failed: %v", err)
+	}
+	if !r.OK || len(r.Problems) != 0 || r.MovedTo != "" || r.Recovered {
+		t.Errorf("VerifyDB() = %+v, want a healthy database left alone", r)
+	}
+	stats, err := GetDBStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LastIntegrityCheck == nil || !stats.LastIntegrityCheck.OK {
+		t.Errorf("DBStats.LastIntegrityCheck = %+v, want the healthy check", stats.LastIntegrityCheck)
+	}
+	if n := countActivities(t); n != 1 {
+		t.Errorf("%d activities after the check, want 1", n)
+	}
+}
+
+// corruptCopy fills a database, then opens a copy of its file as the
+// activities database and truncates it, the way a flaky volume would
+func corruptCopy(t *testing.T) string {
+	t.Helper()
+	setupTestDB(t)
+	for i := 0; i < 2000; i++ {
+		mustLog(t, &ActivityLog{SessionID: strings.Repeat("s", 64), ActivityType: ActivityTypePageView,
+			Details: `{"product_id":"` + strings.Repeat("p", 200) + `"}`})
+	}
+	// Not CheckpointWAL, which GetDBStats would report in other tests
+	if _, err := GetDB().Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		t.Fatal(err)
+	}
+	good, err := databaseFile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), dbFileName)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	CloseDB()
+	if err := useDB(path); err != nil {
+		t.Fatalf("useDB() of the copy failed: %v", err)
+	}
+	t.Cleanup(func() { CloseDB() })
+	if err := os.Truncate(path, int64(len(data)/2)); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerifyDBReplacesCorruptDatabase(t *testing.T) {
+	path := corruptCopy(t)
+	before := corruptions.Value()
+
+	r, err := VerifyDB(context.Background())
+	if err != nil {
+		t.Fatalf("VerifyDB() failed: %v", err)
+	}
+	if r.OK || len(r.Problems) == 0 || !r.Recovered {
+		t.Fatalf("VerifyDB() = %+v, want corruption found and recovered from", r)
+	}
+	if want := path + ".corrupt-20250601T120000Z"; r.MovedTo != want {
+		t.Errorf("moved to %s, want %s", r.MovedTo, want)
+	}
+	if _, err := os.Stat(r.MovedTo); err != nil {
+		t.Errorf("corrupt file wasn't kept: %v", err)
+	}
+	if got := corruptions.Value(); got != before+1 {
+		t.Errorf("corruptions = %d, want %d", got, before+1)
+	}
+
+	// Logging goes on in a fresh database
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	if n := countActivities(t); n != 1 {
+		t.Errorf("%d activities in the fresh database, want 1", n)
+	}
+	if r, err := VerifyDB(context.Background()); err != nil || !r.OK {
+		t.Errorf("VerifyDB() of the fresh database = %+v, %v", r, err)
+	}
+	if last, ok := LastIntegrityCheck(); !ok || !last.OK {
+		t.Errorf("LastIntegrityCheck() = %+v, %v, want the last, healthy check", last, ok)
+	}
+}
+
+func TestReplaceDBLetsRunningReadsFinish(t *testing.T) {
+	dir := t.TempDir()
+	setupTestDBIn(t, dir)
+	for i := 0; i < 10; i++ {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	}
+	rows, err := getReadDB().Query("SELECT id FROM activities")
+	if err != nil {
+		t.Fatalf("reading the database failed: %v", err)
+	}
+	defer rows.Close()
+
+	// Reads go on while the database is replaced
+	stop := make(chan struct{})
+	failed := make(chan error, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				var n int
+				if err := getReadDB().QueryRow("SELECT COUNT(*) FROM activities").Scan(&n); err != nil {
+					select {
+					case failed <- err:
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	_, err = replaceDB(filepath.Join(dir, dbFileName), Now())
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("replaceDB() failed: %v", err)
+	}
+	select {
+	case err := <-failed:
+		t.Errorf("read during the replacement failed: %v", err)
+	default:
+	}
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if err := rows.Err(); err != nil || n != 10 {
+		t.Errorf("read running during the replacement got %d rows, %v, want all 10", n, err)
+	}
+	if got := countActivities(t); got != 0 {
+		t.Errorf("%d activities in the fresh database, want 0", got)
+	}
+}
+
+func TestInitDBReplacesCorruptDatabase(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, dbFileName)
+	if err := os.WriteFile(path, []byte("not a database, just garbage on the volume"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Store(nil)
+		readDB.Store(nil)
+		initialized = false
+	}()
+	if err := InitDBAt(logger, dir); err != nil {
+		t.Fatalf("InitDBAt() failed: %v", err)
+	}
+	defer CloseDB()
+	if moved, _ := filepath.Glob(path + ".corrupt-*"); len(moved) != 1 {
+		t.Errorf("corrupt files kept: %v, want the database", moved)
+	}
+	if n := countActivities(t); n != 0 {
+		t.Errorf("%d activities in the fresh database, want none", n)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// jsonFlushSize is how much of a JSON array is buffered before it is
+// written out
+const jsonFlushSize = 32 << 10
+
+// jsonBuffers are the buffers of the ActivityArrayWriters, reused since a
+// large list grows one to jsonFlushSize each time
+var jsonBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, jsonFlushSize+4<<10)
+		return &buf
+	},
+}
+
+// ActivityArrayWriter writes activities to an io.Writer as a JSON array,
+// byte for byte as json.Encoder encodes a slice of them, a few kilobytes
+// at a time instead of once they are all in memory
+type ActivityArrayWriter struct {
+	w       io.Writer
+	buf     *[]byte
+	n       int
+	flushed bool
+	err     error
+	// typed encodes details as typed details rather than a string
+	typed bool
+	// anonymous leaves out the user and device IDs
+	anonymous bool
+}
+
+// NewActivityArrayWriter returns a writer of a JSON array to w. Close
+// must be called to end the array.
+func NewActivityArrayWriter(w io.Writer) *ActivityArrayWriter {
+	return &ActivityArrayWriter{w: w, buf: jsonBuffers.Get().(*[]byte)}
+}
+
+// TypedDetails makes the writer encode the details of activities as the
+// JSON of their typed details, see UnmarshalDetails, rather than as the
+// string they are stored as
+func (aw *ActivityArrayWriter) TypedDetails() {
+	aw.typed = true
+}
+
+// WithoutIdentities makes the writer leave out the user and device IDs of
+// activities, see ActivityLog.WithoutIdentities
+func (aw *ActivityArrayWriter) WithoutIdentities() {
+	aw.anonymous = true
+}
+
+// Encode appends an activity to the array
+func (aw *ActivityArrayWriter) Encode(activity *ActivityLog) error {
+	if aw.err != nil {
+		return aw.err
+	}
+	if aw.anonymous && (activity.UserID != "" || activity.DeviceID != "") {
+		a := activity.WithoutIdentities()
+		activity = &a
+	}
+	b := *aw.buf
+	if aw.n == 0 {
+		b = append(b, '[')
+	} else {
+		b = append(b, ',')
+	}
+	*aw.buf = activity.appendJSON(b, aw.typed)
+	aw.n++
+	if len(*aw.buf) >= jsonFlushSize {
+		aw.flush()
+	}
+	return aw.err
+}
+
+// Flushed is whether anything was written to the underlying writer yet.
+// Until then an error can still be answered instead of the array.
+func (aw *ActivityArrayWriter) Flushed() bool {
+	return aw.flushed
+}
+
+// Close ends the array and writes what is left of it. An empty array is
+// written as null, as json.Encoder does a nil slice.
+func (aw *ActivityArrayWriter) Close() error {
+	if aw.buf == nil {
+		return aw.err
+	}
+	if aw.err == nil {
+		if aw.n == 0 {
+			*aw.buf = append(*aw.buf, "null\n"...)
+		} else {
+			*aw.buf = append(*aw.buf, ']', '\n')
+		}
+		aw.flush()
+	}
+	aw.Discard()
+	return aw.err
+}
+
+// Discard drops what wasn't written yet, for when the array can't be
+// finished. It does nothing after Close.
+func (aw *ActivityArrayWriter) Discard() {
+	if aw.buf == nil {
+		return
+	}
+	*aw.buf = (*aw.buf)[:0]
+	jsonBuffers.Put(aw.buf)
+	aw.buf = nil
+}
+
+func (aw *ActivityArrayWriter) flush() {
+	aw.flushed = true
+	_, aw.err = aw.w.Write(*aw.buf)
+	*aw.buf = (*aw.buf)[:0]
+}
+
+// WithoutIdentities returns the activity without its user and device IDs,
+// for callers that may not look them up: the device ID lets whoever has it
+// pass for the device, and signing in takes any user ID.
+func (a ActivityLog) WithoutIdentities() ActivityLog {
+	a.UserID, a.DeviceID = "", ""
+	return a
+}
+
+// AppendJSON appends the activity to b as JSON, the way json.Marshal does
+// but without reflection. Fields added to ActivityLog must be added here
+// too.
+func (a *ActivityLog) AppendJSON(b []byte) []byte {
+	return a.appendJSON(b, false)
+}
+
+// appendJSON is AppendJSON, with the details as typed details when typed
+// is set
+func (a *ActivityLog) appendJSON(b []byte, typed bool) []byte {
+	b = append(b, `{"id":`...)
+	b = strconv.AppendInt(b, a.ID, 10)
+	b = appendJSONField(b, "session_id", a.SessionID)
+	if a.UserID != "" {
+		b = appendJSONField(b, "user_id", a.UserID)
+	}
+	b = appendJSONField(b, "request_id", a.RequestID)
+	b = appendJSONField(b, "parent_request_id", a.ParentRequestID)
+	b = appendJSONField(b, "activity_type", a.ActivityType)
+	b = appendJSONField(b, "path", a.Path)
+	if a.RouteTemplate != "" {
+		b = appendJSONField(b, "route_template", a.RouteTemplate)
+	}
+	b = appendJSONField(b, "method", a.Method)
+	b = append(b, `,"status_code":`...)
+	b = strconv.AppendInt(b, int64(a.StatusCode), 10)
+	if a.ResponseClass != "" {
+		b = appendJSONField(b, "response_class", a.ResponseClass)
+	}
+	b = appendJSONField(b, "user_currency", a.UserCurrency)
+	b = appendJSONField(b, "source", a.Source)
+	b = appendJSONField(b, "utm_campaign", a.Campaign)
+	if a.ProductID != "" {
+		b = appendJSONField(b, "product_id", a.ProductID)
+	}
+	if len(a.Items) > 0 {
+		b = append(b, `,"items":[`...)
+		for i, item := range a.Items {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = append(b, `{"product_id":`...)
+			b = appendJSONString(b, item.ProductID)
+			b = append(b, `,"quantity":`...)
+			b = strconv.AppendInt(b, int64(item.Quantity), 10)
+			b = append(b, '}')
+		}
+		b = append(b, ']')
+	}
+	if a.Version != "" {
+		b = appendJSONField(b, "version", a.Version)
+	}
+	if a.Revision != "" {
+		b = appendJSONField(b, "revision", a.Revision)
+	}
+	if a.FlagsHash != "" {
+		b = appendJSONField(b, "flags_hash", a.FlagsHash)
+	}
+	if a.ReferrerType != "" {
+		b = appendJSONField(b, "referrer_type", a.ReferrerType)
+	}
+	if a.ReferrerDomain != "" {
+		b = appendJSONField(b, "referrer_domain", a.ReferrerDomain)
+	}
+	if a.IsEntry {
+		b = append(b, `,"is_entry":true`...)
+	}
+	if a.DeviceID != "" {
+		b = appendJSONField(b, "device_id", a.DeviceID)
+	}
+	b = append(b, `,"latency_ms":`...)
+	b = strconv.AppendInt(b, a.LatencyMs, 10)
+	if typed {
+		b = append(b, `,"details":`...)
+		b = appendTypedDetails(b, a)
+	} else {
+		b = appendJSONField(b, "details", a.Details)
+	}
+	b = append(b, `,"created_at":"`...)
+	b = a.CreatedAt.AppendFormat(b, time.RFC3339Nano)
+	return append(b, '"', '}')
+}
+
+// appendJSONField appends ,"name":value to b, name being safe as is
+func appendJSONField(b []byte, name, value string) []byte {
+	b = append(b, ',', '"')
+	b = append(b, name...)
+	b = append(b, '"', ':')
+	return appendJSONString(b, value)
+}
+
+const jsonHex = "0123456789abcdef"
+
+// appendJSONString appends s to b as a JSON string, escaped as
+// json.Marshal does, HTML characters, U+2028 and U+2029 included
+func appendJSONString(b []byte, s string) []byte {
+	begin := len(b)
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', jsonHex[c>>4], jsonHex[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			// How invalid UTF-8 is replaced depends on the Go release, so
+			// such rare strings are left to encoding/json
+			replaced, _ := json.Marshal(s)
+			return append(b[:begin], replaced...)
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', jsonHex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
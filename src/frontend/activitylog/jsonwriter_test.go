@@ -0,0 +1,152 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// awkward is a string with every character encoding/json escapes
+const awkward = "<a href=\"x\">&amp;</a>\\ \b\f\n\r\t\x00\x1f\x7f \u00e9 \u2028\u2029 end"
+
+// fullActivity returns an activity with every field set, failing when
+// ActivityLog has a field it doesn't know how to set
+func fullActivity(t testing.TB, id int64) ActivityLog {
+	t.Helper()
+	var a ActivityLog
+	v := reflect.ValueOf(&a).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch name := v.Type().Field(i).Name; {
+		case f.Kind() == reflect.String:
+			f.SetString(fmt.Sprintf("%s %d %s", name, id, awkward))
+		case f.Kind() == reflect.Int || f.Kind() == reflect.Int64:
+			f.SetInt(id*1000 + int64(i))
+		case f.Kind() == reflect.Bool:
+			f.SetBool(true)
+		case name == "Items":
+			a.Items = []ActivityItem{{ProductID: "OLJCESPC7Z", Quantity: 2}, {ProductID: awkward, Quantity: -1}}
+		case name == "CreatedAt":
+			a.CreatedAt = time.Date(2025, 6, 1, 12, 0, 0, int(id)*1001, time.FixedZone("", -7*3600))
+		default:
+			t.Fatalf("ActivityLog.%s isn't covered, add it to AppendJSON and here", name)
+		}
+	}
+	return a
+}
+
+func TestAppendJSONMatchesEncodingJSON(t *testing.T) {
+	for name, a := range map[string]ActivityLog{
+		"full":          fullActivity(t, 7),
+		"empty":         {},
+		"invalid utf-8": {Path: "/\xff" + awkward, Items: []ActivityItem{{ProductID: "\xc3"}}},
+		"utc":           {ID: 1, ActivityType: ActivityTypePageView, Details: `{"a":1}`, CreatedAt: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)},
+	} {
+		want, err := json.Marshal(a)
+		if err != nil {
+			t.Fatalf("%s: json.Marshal() failed: %v", name, err)
+		}
+		if got := a.AppendJSON(nil); !bytes.Equal(got, want) {
+			t.Errorf("%s: AppendJSON() =\n%s\nwant\n%s", name, got, want)
+		}
+	}
+}
+
+func TestActivityArrayWriterMatchesEncoder(t *testing.T) {
+	for _, n := range []int{0, 1, 3, 500} {
+		var activities []ActivityLog
+		for i := 0; i < n; i++ {
+			activities = append(activities, fullActivity(t, int64(i)))
+		}
+		var want bytes.Buffer
+		if err := json.NewEncoder(&want).Encode(activities); err != nil {
+			t.Fatalf("Encode() failed: %v", err)
+		}
+
+		var got bytes.Buffer
+		aw := NewActivityArrayWriter(&got)
+		for i := range activities {
+			if err := aw.Encode(&activities[i]); err != nil {
+				t.Fatalf("%d activities: Encode() failed: %v", n, err)
+			}
+		}
+		if err := aw.Close(); err != nil {
+			t.Fatalf("%d activities: Close() failed: %v", n, err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("%d activities: wrote %d bytes that differ from the %d json.Encoder writes", n, got.Len(), want.Len())
+		}
+		if flushed := got.Len() > jsonFlushSize; flushed != (n == 500) {
+			t.Errorf("%d activities: wrote %d bytes, expected the flush size to be crossed only with 500", n, got.Len())
+		}
+	}
+}
+
+// discard is io.Discard, without the allocations-free fast paths a
+// ResponseWriter wouldn't have either
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
+
+func encodeWithJSON(activities []ActivityLog) {
+	json.NewEncoder(discard{}).Encode(activities)
+}
+
+func encodeWithWriter(activities []ActivityLog) {
+	aw := NewActivityArrayWriter(discard{})
+	for i := range activities {
+		aw.Encode(&activities[i])
+	}
+	aw.Close()
+}
+
+func largeResponse(t testing.TB) []ActivityLog {
+	activities := make([]ActivityLog, 10000)
+	for i := range activities {
+		activities[i] = ActivityLog{ID: int64(i), SessionID: "5c9c7a4e-1f2b-4e55-9d0e-2b1f3c4d5e6f", RequestID: "0d6f8b7a-3c2e-4f1a-8b9c-7d6e5f4a3b2c",
+			ActivityType: ActivityTypeAddToCart, Path: "/cart", Method: "POST", StatusCode: 302, UserCurrency: "EUR", Source: "web",
+			ProductID: "OLJCESPC7Z", LatencyMs: 42, Details: `{"product_id":"OLJCESPC7Z","quantity":"2"}`, CreatedAt: time.Now().UTC()}
+	}
+	return activities
+}
+
+func TestActivityArrayWriterAllocations(t *testing.T) {
+	activities := largeResponse(t)
+	before := testing.AllocsPerRun(5, func() { encodeWithJSON(activities) })
+	after := testing.AllocsPerRun(5, func() { encodeWithWriter(activities) })
+	if after*3 > before {
+		t.Errorf("encoding 10k activities took %.0f allocations, want at most a third of the %.0f of json.Encoder", after, before)
+	}
+}
+
+func BenchmarkEncodeActivities(b *testing.B) {
+	activities := largeResponse(b)
+	for name, encode := range map[string]func([]ActivityLog){
+		"json.Encoder":        encodeWithJSON,
+		"ActivityArrayWriter": encodeWithWriter,
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				encode(activities)
+			}
+		})
+	}
+}
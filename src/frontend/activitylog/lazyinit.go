@@ -0,0 +1,118 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lazyInitRetry is how long activities are dropped after the database
+// failed to initialize on first use, before a write tries again
+const lazyInitRetry = 30 * time.Second
+
+// lazyInit is the database to initialize on first use. It is pending until
+// InitDB succeeds, so the store initializes itself in the default data
+// directory when InitDB is skipped; InitDBLazily picks another directory.
+var lazyInit struct {
+	// pending is set until the database is initialized, for reads and
+	// writes to check without taking the lock
+	pending atomic.Bool
+	// failure is why the last initialization failed, for Status to read
+	// without waiting on a slow one in progress
+	failure atomic.Pointer[string]
+
+	sync.Mutex
+	// log is the logger of the package unless InitDBLazily sets one
+	log     logrus.FieldLogger
+	dataDir string
+	// init initializes the database, InitDBAt unless a test replaces it
+	init     func(logrus.FieldLogger, string) error
+	err      error
+	failedAt time.Time
+}
+
+func init() {
+	lazyInit.dataDir = defaultDataDir
+	lazyInit.pending.Store(true)
+}
+
+// InitDBLazily has the database in dataDir initialized by the first read
+// or write rather than at startup, so the middleware can be installed
+// before, or without, InitDB. Until then, and for a while after it fails to,
+// activities are dropped as without a database, with Status reporting
+// logging degraded on failure; requests are served regardless. Without it
+// the database is initialized the same way, in the data directory under
+// the working directory. InitDB can still be called to initialize it
+// eagerly and fail startup on errors.
+func InitDBLazily(log logrus.FieldLogger, dataDir string) {
+	lazyInit.Lock()
+	defer lazyInit.Unlock()
+	lazyInit.log, lazyInit.dataDir = log, dataDir
+	initMu.Lock()
+	lazyInit.pending.Store(!initialized)
+	initMu.Unlock()
+}
+
+// ensureDB initializes the database on first use unless InitDB did, or a
+// database is already open. Of the reads and writes racing to be first one
+// initializes it, the others wait for it to be done.
+func ensureDB() error {
+	if !lazyInit.pending.Load() || GetDB() != nil {
+		return nil
+	}
+	lazyInit.Lock()
+	defer lazyInit.Unlock()
+	if !lazyInit.pending.Load() || GetDB() != nil {
+		return nil
+	}
+	if lazyInit.err != nil && Now().Sub(lazyInit.failedAt) < lazyInitRetry {
+		return lazyInit.err
+	}
+
+	log := lazyInit.log
+	if log == nil {
+		log = logger
+	}
+	init := lazyInit.init
+	if init == nil {
+		init = InitDBAt
+	}
+	if err := init(log, lazyInit.dataDir); err != nil {
+		lazyInit.err = fmt.Errorf("%w: %v", ErrNotInitialized, err)
+		lazyInit.failedAt = Now()
+		msg := err.Error()
+		lazyInit.failure.Store(&msg)
+		log.WithError(err).Error("Failed to initialize the activity database on first use, dropping activities")
+		return lazyInit.err
+	}
+	lazyInit.err = nil
+	lazyInit.failure.Store(nil)
+	lazyInit.pending.Store(false)
+	return nil
+}
+
+// lazyInitFailure returns why the database failed to initialize on first
+// use, empty unless it did
+func lazyInitFailure() string {
+	if msg := lazyInit.failure.Load(); msg != nil {
+		return *msg
+	}
+	return ""
+}
@@ -0,0 +1,180 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// setupLazyDB leaves the store uninitialized, to be initialized on first
+// use in a temporary directory by init instead of InitDBAt
+func setupLazyDB(t *testing.T, init func(logrus.FieldLogger, string) error) *FakeClock {
+	t.Helper()
+	fc := setupTestDB(t)
+	CloseDB()
+	db.Store(nil)
+	readDB.Store(nil)
+	lazyInit.init = init
+	InitDBLazily(logger, t.TempDir())
+	t.Cleanup(func() {
+		initialized = false
+		lazyInit.pending.Store(false)
+		lazyInit.failure.Store(nil)
+		lazyInit.init, lazyInit.err = nil, nil
+	})
+	return fc
+}
+
+func TestLazyInitRacesFirstWrites(t *testing.T) {
+	var inits atomic.Int32
+	setupLazyDB(t, func(log logrus.FieldLogger, dir string) error {
+		inits.Add(1)
+		// Slow enough for every writer to arrive while it runs
+		time.Sleep(50 * time.Millisecond)
+		return InitDBAt(log, dir)
+	})
+
+	const writers = 100
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	start := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs <- LogActivity(&ActivityLog{SessionID: "session-1", ActivityType: "page_view", Path: "/"})
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("LogActivity() failed: %v", err)
+		}
+	}
+
+	if n := inits.Load(); n != 1 {
+		t.Errorf("database initialized %d times, want once", n)
+	}
+	if n := countActivities(t); n != writers {
+		t.Errorf("%d activities written, want %d", n, writers)
+	}
+	if s := Status(); !s.Initialized || s.InitError != "" {
+		t.Errorf("Status() = %+v, want initialized without error", s)
+	}
+}
+
+func TestLazyInitFailureDegrades(t *testing.T) {
+	var inits atomic.Int32
+	fail := errors.New("volume not mounted")
+	fc := setupLazyDB(t, func(log logrus.FieldLogger, dir string) error {
+		if inits.Add(1) == 1 {
+			return fail
+		}
+		return InitDBAt(log, dir)
+	})
+	router := newTestRouter()
+
+	w := serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "session-1")
+	if w.Code != http.StatusOK {
+		t.Errorf("request while the database fails to initialize: status %d, want 200", w.Code)
+	}
+	s := Status()
+	if s.State != StateDegraded || s.InitError != fail.Error() {
+		t.Errorf("Status() = %+v, want %q with the init error", s, StateDegraded)
+	}
+	// Writes don't retry on every request
+	if err := LogActivity(&ActivityLog{SessionID: "session-1", ActivityType: "page_view"}); !errors.Is(err, ErrNotInitialized) {
+		t.Errorf("LogActivity() = %v, want ErrNotInitialized", err)
+	}
+	if n := inits.Load(); n != 1 {
+		t.Errorf("database initialized %d times before the retry delay, want once", n)
+	}
+
+	fc.Advance(lazyInitRetry)
+	mustLog(t, &ActivityLog{SessionID: "session-1", ActivityType: "page_view"})
+	if s := Status(); s.State != StateOK || s.InitError != "" {
+		t.Errorf("Status() after the retry = %+v, want %q", s, StateOK)
+	}
+}
+
+func TestInitDBAfterLazyInitIsEager(t *testing.T) {
+	var inits atomic.Int32
+	setupLazyDB(t, func(log logrus.FieldLogger, dir string) error {
+		inits.Add(1)
+		return InitDBAt(log, dir)
+	})
+	if err := InitDBAt(logger, t.TempDir()); err != nil {
+		t.Fatalf("InitDBAt() failed: %v", err)
+	}
+	mustLog(t, &ActivityLog{SessionID: "session-1", ActivityType: "page_view"})
+	if n := inits.Load(); n != 0 {
+		t.Errorf("database initialized %d times on first use after InitDBAt, want none", n)
+	}
+}
+
+func TestStoreInitializesItselfWithoutInitDB(t *testing.T) {
+	setupLazyDB(t, nil)
+	// As at startup: nothing but the defaults, in a scratch working directory
+	lazyInit.log, lazyInit.dataDir = nil, defaultDataDir
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd() failed: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir() failed: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	mustLog(t, &ActivityLog{SessionID: "session-1", ActivityType: "page_view"})
+	if _, err := os.Stat(filepath.Join(dir, defaultDataDir, dbFileName)); err != nil {
+		t.Errorf("no database in the default data directory after the first write: %v", err)
+	}
+	if s := Status(); !s.Initialized {
+		t.Errorf("Status() = %+v, want initialized", s)
+	}
+}
+
+func TestLazyInitOnFirstRead(t *testing.T) {
+	var inits atomic.Int32
+	setupLazyDB(t, func(log logrus.FieldLogger, dir string) error {
+		inits.Add(1)
+		return InitDBAt(log, dir)
+	})
+
+	got, err := GetActivitiesBySession("session-1", 10)
+	if err != nil || len(got) != 0 {
+		t.Errorf("GetActivitiesBySession() before any write = %v, %v; want none", got, err)
+	}
+	if n := inits.Load(); n != 1 {
+		t.Errorf("database initialized %d times by the first read, want once", n)
+	}
+	if s := Status(); !s.Initialized || s.State != StateOK {
+		t.Errorf("Status() after the first read = %+v, want %q", s, StateOK)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAnalyticalQueryLimit = 2
+	defaultAnalyticalQueryWait  = 2 * time.Second
+)
+
+// ErrBusy is returned by analytical queries when too many of them are
+// already running and no slot freed up within the configured wait.
+var ErrBusy = errors.New("activitylog: too many concurrent analytical queries")
+
+// analyticalSlots bounds how many heavy aggregate queries run at once so a
+// burst of dashboard requests can't starve the single SQLite writer.
+var analyticalSlots = struct {
+	sync.RWMutex
+	slots chan struct{}
+	wait  time.Duration
+}{
+	slots: make(chan struct{}, defaultAnalyticalQueryLimit),
+	wait:  defaultAnalyticalQueryWait,
+}
+
+// ConfigureAnalyticalQueries sets how many analytical queries may run
+// concurrently and how long a query waits for a free slot before failing
+// with ErrBusy. Non-positive values keep the defaults.
+func ConfigureAnalyticalQueries(limit int, wait time.Duration) {
+	if limit <= 0 {
+		limit = defaultAnalyticalQueryLimit
+	}
+	if wait <= 0 {
+		wait = defaultAnalyticalQueryWait
+	}
+	analyticalSlots.Lock()
+	defer analyticalSlots.Unlock()
+	analyticalSlots.slots = make(chan struct{}, limit)
+	analyticalSlots.wait = wait
+}
+
+// AnalyticalQueryLimit returns how many analytical queries may run
+// concurrently, which callers fanning out queries should stay within.
+func AnalyticalQueryLimit() int {
+	analyticalSlots.RLock()
+	defer analyticalSlots.RUnlock()
+	return cap(analyticalSlots.slots)
+}
+
+// AnalyticalQueryWait returns how long analytical queries wait for a slot,
+// which is also a sensible Retry-After for callers that got ErrBusy.
+func AnalyticalQueryWait() time.Duration {
+	analyticalSlots.RLock()
+	defer analyticalSlots.RUnlock()
+	return analyticalSlots.wait
+}
+
+// acquireAnalytical waits for an analytical query slot, unless ctx is done
+// first. The returned function releases the slot.
+func acquireAnalytical(ctx context.Context) (func(), error) {
+	analyticalSlots.RLock()
+	slots, wait := analyticalSlots.slots, analyticalSlots.wait
+	analyticalSlots.RUnlock()
+
+	release := func() { <-slots }
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	analyticalQueriesQueued.Add(1)
+	defer analyticalQueriesQueued.Add(-1)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		analyticalQueriesRejected.Add(1)
+		return nil, ErrBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAnalyticalQueriesAreLimited(t *testing.T) {
+	fc := setupTestDB(t)
+	ConfigureAnalyticalQueries(2, 20*time.Millisecond)
+	t.Cleanup(func() { ConfigureAnalyticalQueries(0, 0) })
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+
+	// Two stats queries are already in flight.
+	for i := 0; i < 2; i++ {
+		release, err := acquireAnalytical(context.Background())
+		if err != nil {
+			t.Fatalf("acquireAnalytical() #%d failed: %v", i+1, err)
+		}
+		defer release()
+	}
+
+	if _, err := GetActivityStats(context.Background(), Filter{Start: fc.Now().Add(-time.Hour), End: fc.Now()}); !errors.Is(err, ErrBusy) {
+		t.Errorf("third GetActivityStats() error = %v, want ErrBusy", err)
+	}
+	if got := analyticalQueriesQueued.Value(); got != 0 {
+		t.Errorf("queued gauge = %d after rejection, want 0", got)
+	}
+
+	// Session queries don't compete for analytical slots.
+	got, err := GetActivitiesBySession("session-1", 10)
+	if err != nil {
+		t.Fatalf("GetActivitiesBySession() failed: %v", err)
+	}
+	if len(got) != 1 {
+		t.Errorf("GetActivitiesBySession() returned %d activities, want 1", len(got))
+	}
+}
+
+func TestAnalyticalQueryWaitsForFreeSlot(t *testing.T) {
+	fc := setupTestDB(t)
+	ConfigureAnalyticalQueries(1, time.Second)
+	t.Cleanup(func() { ConfigureAnalyticalQueries(0, 0) })
+
+	release, err := acquireAnalytical(context.Background())
+	if err != nil {
+		t.Fatalf("acquireAnalytical() failed: %v", err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+
+	if _, err := GetActivityStats(context.Background(), Filter{Start: fc.Now().Add(-time.Hour), End: fc.Now()}); err != nil {
+		t.Errorf("GetActivityStats() failed after a slot freed up: %v", err)
+	}
+}
+
+func TestAnalyticalQueryStopsWaitingWhenCanceled(t *testing.T) {
+	fc := setupTestDB(t)
+	ConfigureAnalyticalQueries(1, time.Minute)
+	t.Cleanup(func() { ConfigureAnalyticalQueries(0, 0) })
+
+	release, err := acquireAnalytical(context.Background())
+	if err != nil {
+		t.Fatalf("acquireAnalytical() failed: %v", err)
+	}
+	defer release()
+
+	// The caller goes away while queued, and doesn't take the slot later
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := GetActivityStats(ctx, Filter{Start: fc.Now().Add(-time.Hour), End: fc.Now()}); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetActivityStats() with a canceled context error = %v, want context.Canceled", err)
+	}
+	if got := analyticalQueriesQueued.Value(); got != 0 {
+		t.Errorf("queued gauge = %d after cancellation, want 0", got)
+	}
+}
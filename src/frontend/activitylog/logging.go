@@ -0,0 +1,86 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultSlowQueryThreshold = 500 * time.Millisecond
+
+// CtxKeyLog is the context key under which the frontend stores the
+// logrus.FieldLogger of the current request.
+type CtxKeyLog struct{}
+
+// slowQueryThreshold is how long a query may take, in nanoseconds, before
+// it is logged as slow
+var slowQueryThreshold atomic.Int64
+
+func init() {
+	slowQueryThreshold.Store(int64(defaultSlowQueryThreshold))
+}
+
+// ConfigureSlowQueries sets how long a query may take before it is logged
+// as slow. A non-positive threshold keeps the default.
+func ConfigureSlowQueries(threshold time.Duration) {
+	if threshold <= 0 {
+		threshold = defaultSlowQueryThreshold
+	}
+	slowQueryThreshold.Store(int64(threshold))
+}
+
+// LoggerFrom returns the logger of the request ctx belongs to, falling back
+// to the one the database was initialized with, with the request and
+// session IDs of ctx as the request_id and session_id fields, so that log
+// lines of the activity log can be correlated with the request.
+func LoggerFrom(ctx context.Context) logrus.FieldLogger {
+	return requestLogger(ctx, logger)
+}
+
+// requestLogger is LoggerFrom with the logger to fall back to
+func requestLogger(ctx context.Context, fallback logrus.FieldLogger) logrus.FieldLogger {
+	log := fallback
+	if l, ok := ctx.Value(CtxKeyLog{}).(logrus.FieldLogger); ok {
+		log = l
+	}
+	fields := logrus.Fields{}
+	if id, _ := ctx.Value(CtxKeyRequestID{}).(string); id != "" {
+		fields["request_id"] = id
+	}
+	if id, _ := ctx.Value(CtxKeySessionID{}).(string); id != "" {
+		fields["session_id"] = id
+	}
+	if len(fields) == 0 {
+		return log
+	}
+	return log.WithFields(fields)
+}
+
+// observeQuery logs the query called name, started at start, if it was
+// slow
+func observeQuery(ctx context.Context, name string, start time.Time) {
+	took := time.Since(start)
+	if took < time.Duration(slowQueryThreshold.Load()) {
+		return
+	}
+	LoggerFrom(ctx).WithFields(logrus.Fields{
+		"query":   name,
+		"took_ms": took.Milliseconds(),
+	}).Warn("slow activity log query")
+}
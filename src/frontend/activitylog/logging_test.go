@@ -0,0 +1,117 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// requestContext is the context of a request of session-1, logging to the
+// returned hook
+func requestContext() (context.Context, *test.Hook) {
+	log, hook := test.NewNullLogger()
+	ctx := context.WithValue(context.Background(), CtxKeyLog{}, logrus.FieldLogger(log.WithField("http.req.path", "/")))
+	ctx = context.WithValue(ctx, CtxKeySessionID{}, "session-1")
+	ctx = context.WithValue(ctx, CtxKeyRequestID{}, "request-session-1")
+	return ctx, hook
+}
+
+func checkRequestFields(t *testing.T, entry *logrus.Entry) {
+	t.Helper()
+	for k, want := range map[string]string{"request_id": "request-session-1", "session_id": "session-1", "http.req.path": "/"} {
+		if got := entry.Data[k]; got != want {
+			t.Errorf("%q logged with %s = %v, want %q", entry.Message, k, got, want)
+		}
+	}
+}
+
+func TestSlowQueriesAreLoggedWithTheRequest(t *testing.T) {
+	setupTestDB(t)
+	ConfigureSlowQueries(time.Nanosecond)
+	defer ConfigureSlowQueries(0)
+	ctx, hook := requestContext()
+
+	if err := LogActivityContext(ctx, &ActivityLog{SessionID: "session-1", ActivityType: ActivityTypePageView}); err != nil {
+		t.Fatalf("LogActivityContext() failed: %v", err)
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "slow activity log query" || entry.Data["query"] != "insert activity" {
+		t.Fatalf("logged %+v, want the slow insert", entry)
+	}
+	checkRequestFields(t, entry)
+
+	hook.Reset()
+	ConfigureSlowQueries(time.Hour)
+	if err := LogActivityContext(ctx, &ActivityLog{SessionID: "session-1", ActivityType: ActivityTypePageView}); err != nil {
+		t.Fatalf("LogActivityContext() failed: %v", err)
+	}
+	if entries := hook.AllEntries(); len(entries) != 0 {
+		t.Errorf("logged %d entries for a fast query, want none", len(entries))
+	}
+}
+
+func TestMiddlewareLogsWriteFailuresWithFields(t *testing.T) {
+	setupTestDB(t)
+	repair := breakWrites(t)
+	defer repair()
+	ctx, hook := requestContext()
+
+	req := httptest.NewRequest("GET", "/product/OLJCESPC7Z", nil).WithContext(ctx)
+	serve(newTestRouter(), req, "session-1")
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "failed to log activity" || entry.Data[logrus.ErrorKey] == nil {
+		t.Fatalf("logged %+v, want the write failure", entry)
+	}
+	if entry.Data["activity_type"] != ActivityTypeProductView || entry.Data["path"] != "/product/{id}" {
+		t.Errorf("failure logged with %v, want the activity type and path", entry.Data)
+	}
+	checkRequestFields(t, entry)
+}
+
+func TestSlowStatsQueriesAreLoggedWithTheRequest(t *testing.T) {
+	fc := setupTestDB(t)
+	ConfigureSlowQueries(time.Nanosecond)
+	defer ConfigureSlowQueries(0)
+	ctx, hook := requestContext()
+
+	if _, err := GetActivityStats(ctx, Filter{Start: fc.Now().Add(-time.Hour), End: fc.Now()}); err != nil {
+		t.Fatalf("GetActivityStats() failed: %v", err)
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Data["query"] != "activity stats" {
+		t.Fatalf("logged %+v, want the slow stats query", entry)
+	}
+	checkRequestFields(t, entry)
+}
+
+func TestStatsQueriesStopWithTheRequest(t *testing.T) {
+	fc := setupTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := GetActivityStats(ctx, Filter{Start: fc.Now().Add(-time.Hour), End: fc.Now()}); err != context.Canceled {
+		t.Errorf("GetActivityStats() of a canceled request = %v, want %v", err, context.Canceled)
+	}
+	if _, err := GetFunnel(ctx, fc.Now().Add(-time.Hour), fc.Now(), DefaultFunnel); err != context.Canceled {
+		t.Errorf("GetFunnel() of a canceled request = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrActivityNotFound is returned when looking up an activity that isn't
+// on record, or was deleted
+var ErrActivityNotFound = errors.New("activitylog: activity not found")
+
+// GetActivityByID returns the activity with the given ID, such as the one
+// a response reported in ActivityIDHeader
+func GetActivityByID(ctx context.Context, id int64) (ActivityLog, error) {
+	return getActivity(ctx, "get activity by id", "id = ?", id)
+}
+
+// GetActivityByRequestID returns the activity of the request with the
+// given ID, such as the one a response reported in
+// ActivityRequestIDHeader. It is the first one logged should several
+// share it.
+func GetActivityByRequestID(ctx context.Context, requestID string) (ActivityLog, error) {
+	return getActivity(ctx, "get activity by request id", "request_id = ?", requestID)
+}
+
+// getActivity runs the query called name, returning the first live
+// activity matching where, whose argument is arg
+func getActivity(ctx context.Context, name, where string, arg interface{}) (ActivityLog, error) {
+	query := `
+		SELECT ` + activityColumns + `
+		FROM activities
+		WHERE ` + where + ` AND deleted_at IS NULL
+		ORDER BY id
+		LIMIT 1`
+	start := time.Now()
+	rows, err := getReadDB().QueryContext(ctx, query, arg)
+	observeQuery(ctx, name, start)
+	if err != nil {
+		return ActivityLog{}, err
+	}
+	defer rows.Close()
+
+	var activity ActivityLog
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return ActivityLog{}, err
+		}
+		return ActivityLog{}, ErrActivityNotFound
+	}
+	if err := scanActivity(rows, &activity); err != nil {
+		return ActivityLog{}, err
+	}
+	return activity, rows.Err()
+}
@@ -0,0 +1,84 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestGetActivityByID(t *testing.T) {
+	setupTestDB(t)
+	first := &ActivityLog{ActivityType: ActivityTypePageView, RequestID: "request-1"}
+	mustLog(t, first)
+	// A client event logged under the same request
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, RequestID: "request-1"})
+	other := &ActivityLog{SessionID: "session-2", ActivityType: ActivityTypeViewCart, RequestID: "request-2"}
+	mustLog(t, other)
+	ctx := context.Background()
+
+	if got, err := GetActivityByID(ctx, other.ID); err != nil || got.ID != other.ID || got.ActivityType != ActivityTypeViewCart {
+		t.Errorf("GetActivityByID(%d) = %+v, %v; want the cart view", other.ID, got, err)
+	}
+	if got, err := GetActivityByRequestID(ctx, "request-1"); err != nil || got.ID != first.ID {
+		t.Errorf("GetActivityByRequestID() = %+v, %v; want the first activity of the request, %d", got, err, first.ID)
+	}
+	if _, err := GetActivityByID(ctx, other.ID+100); !errors.Is(err, ErrActivityNotFound) {
+		t.Errorf("GetActivityByID() of a missing ID = %v, want ErrActivityNotFound", err)
+	}
+
+	if _, err := DeleteSession(ctx, "session-2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetActivityByRequestID(ctx, "request-2"); !errors.Is(err, ErrActivityNotFound) {
+		t.Errorf("GetActivityByRequestID() of a deleted activity = %v, want ErrActivityNotFound", err)
+	}
+}
+
+func TestExposedIDs(t *testing.T) {
+	setupTestDB(t)
+
+	// Logged after the response: only the request ID is known in time
+	w := serve(newTestRouter(WithExposedIDs()), httptest.NewRequest(http.MethodGet, "/cart", nil), "session-1")
+	if got := w.Header().Get(ActivityRequestIDHeader); got != "request-session-1" {
+		t.Errorf("%s = %q, want the request ID", ActivityRequestIDHeader, got)
+	}
+	if got := w.Header().Get(ActivityIDHeader); got != "" {
+		t.Errorf("%s = %q before the activity was logged", ActivityIDHeader, got)
+	}
+	if a, err := GetActivityByRequestID(context.Background(), "request-session-1"); err != nil || a.ActivityType != ActivityTypeViewCart {
+		t.Errorf("GetActivityByRequestID() = %+v, %v; want the cart view", a, err)
+	}
+
+	// Logged before the response in strict mode
+	w = serve(newTestRouter(WithExposedIDs(), WithStrictWrites()), postForm("/cart/checkout", "email=a@example.com"), "session-2")
+	id, err := strconv.ParseInt(w.Header().Get(ActivityIDHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("%s = %q, want the activity ID", ActivityIDHeader, w.Header().Get(ActivityIDHeader))
+	}
+	if a, err := GetActivityByID(context.Background(), id); err != nil || a.SessionID != "session-2" || a.ActivityType != ActivityTypeCheckout {
+		t.Errorf("GetActivityByID(%d) = %+v, %v; want the checkout", id, a, err)
+	}
+
+	// Not without the option
+	w = serve(newTestRouter(), httptest.NewRequest(http.MethodGet, "/cart", nil), "session-1")
+	if w.Header().Get(ActivityIDHeader) != "" || w.Header().Get(ActivityRequestIDHeader) != "" {
+		t.Errorf("headers = %v, want no activity ID without WithExposedIDs", w.Header())
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// purgeBatchSize is the number of rows deleted per statement, small enough
+// to keep each write transaction (and the lock it holds) short.
+const purgeBatchSize = 1000
+
+// ErrEmptyFilter is returned by destructive operations that refuse to run
+// with a filter matching every activity.
+var ErrEmptyFilter = errors.New("activitylog: refusing to run with an empty filter")
+
+// DeleteByFilter deletes every activity matching the filter, soft-deleted
+// ones included, in batches, and returns the number of deleted rows. It
+// stops between batches when ctx is cancelled, returning the rows deleted
+// so far. The purge is recorded in the audit trail, which each batch
+// updates in its transaction.
+func DeleteByFilter(ctx context.Context, filter Filter) (int64, error) {
+	if err := filter.Validate(); err != nil {
+		return 0, err
+	}
+	clauses, args := filter.clauses()
+	if len(clauses) == 0 {
+		return 0, ErrEmptyFilter
+	}
+	// The purged days no longer match their roll-ups, serve them from raw
+	// activities until they are rolled up again
+	if err := invalidateRollups(filter.Start, filter.End); err != nil {
+		return 0, err
+	}
+
+	query := func(table string) string {
+		return `
+		DELETE FROM ` + table + `
+		WHERE id IN (SELECT id FROM ` + table + ` WHERE ` + strings.Join(clauses, " AND ") + ` LIMIT ?)`
+	}
+	args = append(args, purgeBatchSize)
+	return deleteBatches(ctx, query, args, &auditRecord{operation: AuditPurge, filter: auditFilter(filter)})
+}
+
+// deleteBatches runs a batched DELETE on each of the tables activities are
+// written to, until it deletes fewer rows than a batch, counting each batch
+// in the audit entry in the same transaction
+func deleteBatches(ctx context.Context, query func(table string) string, args []interface{}, audit *auditRecord) (int64, error) {
+	var deleted int64
+	for _, table := range activityTables() {
+		n, err := deleteTableBatches(ctx, query(table), args, audit)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// deleteTableBatches runs a batched DELETE until it deletes fewer rows than
+// a batch
+func deleteTableBatches(ctx context.Context, query string, args []interface{}, audit *auditRecord) (int64, error) {
+	var deleted int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		n, err := deleteBatch(ctx, query, args, audit)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+		if n < purgeBatchSize {
+			return deleted, nil
+		}
+		logger.WithField("deleted", deleted).Info("purging activities")
+	}
+}
+
+func deleteBatch(ctx context.Context, query string, args []interface{}, audit *auditRecord) (int64, error) {
+	tx, err := GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	start := time.Now()
+	res, err := tx.ExecContext(ctx, query, args...)
+	observeQuery(ctx, "delete activities", start)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := audit.add(ctx, tx, n); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// DeleteSession soft-deletes every activity of a session and returns their
+// number. They vanish from every read right away and are removed for good
+// by the retention job once their grace period is over. Only the roll-ups
+// of the days the session was active on are invalidated.
+func DeleteSession(ctx context.Context, sessionID string) (int64, error) {
+	return softDeleteBy(ctx, "session_id", sessionID, AuditDeleteSession)
+}
+
+// softDeleteBy soft-deletes every activity whose column is id, recording
+// operation in the audit trail, as DeleteSession does for sessions
+func softDeleteBy(ctx context.Context, column, id, operation string) (int64, error) {
+	if id == "" {
+		return 0, ErrEmptyFilter
+	}
+	var first, last time.Time
+	query := "SELECT created_at FROM activities WHERE " + column + " = ? AND deleted_at IS NULL ORDER BY created_at %s LIMIT 1"
+	err := GetDB().QueryRowContext(ctx, fmt.Sprintf(query, "ASC"), id).Scan(&first)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := GetDB().QueryRowContext(ctx, fmt.Sprintf(query, "DESC"), id).Scan(&last); err != nil {
+		return 0, err
+	}
+	if err := invalidateRollups(first, last.Add(time.Nanosecond)); err != nil {
+		return 0, err
+	}
+
+	tx, err := GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	n, err := execEachTable(ctx, tx, func(table string) string {
+		return "UPDATE " + table + " SET deleted_at = ? WHERE " + column + " = ? AND deleted_at IS NULL"
+	}, Now().UTC(), id)
+	if err != nil {
+		return 0, err
+	}
+	audit := auditRecord{operation: operation, filter: map[string]string{column: id}}
+	if err := audit.add(ctx, tx, n); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	forgetProductViews()
+	return n, nil
+}
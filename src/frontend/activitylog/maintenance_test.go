@@ -0,0 +1,243 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countActivities counts the activities every read sees, leaving out
+// soft-deleted ones
+func countActivities(t *testing.T) int {
+	t.Helper()
+	var n int
+	if err := GetDB().QueryRow("SELECT COUNT(*) FROM activities WHERE deleted_at IS NULL").Scan(&n); err != nil {
+		t.Fatalf("counting activities failed: %v", err)
+	}
+	return n
+}
+
+func TestDeleteByFilterBySource(t *testing.T) {
+	setupTestDB(t)
+	// Enough load generator rows to need several batches
+	for i := 0; i < 2*purgeBatchSize+10; i++ {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Source: SourceLoadGenerator})
+	}
+	for i := 0; i < 5; i++ {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Source: SourceWeb})
+	}
+
+	deleted, err := DeleteByFilter(context.Background(), Filter{Source: SourceLoadGenerator})
+	if err != nil {
+		t.Fatalf("DeleteByFilter() failed: %v", err)
+	}
+	if deleted != 2*purgeBatchSize+10 {
+		t.Errorf("DeleteByFilter() deleted %d rows, want %d", deleted, 2*purgeBatchSize+10)
+	}
+	if got := countActivities(t); got != 5 {
+		t.Errorf("%d activities left, want the 5 web activities", got)
+	}
+}
+
+func TestDeleteByFilterByTimeWindow(t *testing.T) {
+	fc := setupTestDB(t)
+	start := fc.Now()
+	for i := 0; i < 6; i++ {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout})
+		fc.Advance(time.Hour)
+	}
+
+	// The bad deploy window covers hours 2 and 3
+	deleted, err := DeleteByFilter(context.Background(), Filter{
+		Start: start.Add(2 * time.Hour),
+		End:   start.Add(4 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("DeleteByFilter() failed: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("DeleteByFilter() deleted %d rows, want 2", deleted)
+	}
+	if got := countActivities(t); got != 4 {
+		t.Errorf("%d activities left, want 4", got)
+	}
+}
+
+func TestDeleteByFilterRefusesEmptyFilter(t *testing.T) {
+	setupTestDB(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+
+	if _, err := DeleteByFilter(context.Background(), Filter{}); !errors.Is(err, ErrEmptyFilter) {
+		t.Errorf("DeleteByFilter() with empty filter error = %v, want ErrEmptyFilter", err)
+	}
+	if got := countActivities(t); got != 1 {
+		t.Errorf("%d activities left, want 1", got)
+	}
+}
+
+func TestDeleteSession(t *testing.T) {
+	fc := setupTestDB(t)
+	seedDays(t, fc, 3)
+	if _, err := CatchUpRollups(context.Background()); err != nil {
+		t.Fatalf("CatchUpRollups() failed: %v", err)
+	}
+	// A session only active yesterday
+	yesterday := truncateDay(fc.Now()).Add(-day)
+	for i := 0; i < 3; i++ {
+		mustLog(t, &ActivityLog{SessionID: "mine", ActivityType: ActivityTypePageView, CreatedAt: yesterday.Add(time.Duration(i) * time.Hour)})
+	}
+	before := countActivities(t)
+
+	deleted, err := DeleteSession(context.Background(), "mine")
+	if err != nil {
+		t.Fatalf("DeleteSession() failed: %v", err)
+	}
+	if deleted != 3 || countActivities(t) != before-3 {
+		t.Errorf("DeleteSession() deleted %d rows, %d left; want 3 deleted, %d left", deleted, countActivities(t), before-3)
+	}
+	ranges, err := rolledUpDays(context.Background(), getReadDB(), Filter{})
+	if err != nil {
+		t.Fatalf("rolledUpDays() failed: %v", err)
+	}
+	want := []dayRange{{start: yesterday.Add(-2 * day), end: yesterday}}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("rolled up days after DeleteSession() = %v, want only yesterday invalidated: %v", ranges, want)
+	}
+
+	if deleted, err := DeleteSession(context.Background(), "mine"); err != nil || deleted != 0 {
+		t.Errorf("DeleteSession() of a session without activities = %d, %v; want 0, nil", deleted, err)
+	}
+	if _, err := DeleteSession(context.Background(), ""); !errors.Is(err, ErrEmptyFilter) {
+		t.Errorf("DeleteSession(\"\") error = %v, want ErrEmptyFilter", err)
+	}
+}
+
+func TestDeleteSessionHidesActivitiesFromEveryRead(t *testing.T) {
+	fc := setupTestDB(t)
+	now := fc.Now()
+	start, end := now.Add(-day), now.Add(time.Hour)
+	// Yesterday's roll-up has to be rebuilt without the deleted session
+	yesterday := truncateDay(now).Add(-day)
+
+	readers := []struct {
+		name string
+		read func() (interface{}, error)
+	}{
+		{"GetActivities", func() (interface{}, error) { return GetActivities(Filter{}, 0) }},
+		{"GetActivitiesBySession", func() (interface{}, error) { return GetActivitiesBySession("gone", 0) }},
+		{"QueryStream", func() (interface{}, error) {
+			var all []ActivityLog
+			err := QueryStream(context.Background(), Filter{}, func(a ActivityLog) error {
+				all = append(all, a)
+				return nil
+			})
+			return all, err
+		}},
+		{"WriteArchive", func() (interface{}, error) {
+			var b strings.Builder
+			_, err := WriteArchive(context.Background(), &b, start, end)
+			return b.String(), err
+		}},
+		{"GetActivityItems", func() (interface{}, error) { return GetActivityItems(3) }},
+		{"GetActivityStats", func() (interface{}, error) {
+			return GetActivityStats(context.Background(), Filter{Start: yesterday, End: end})
+		}},
+		{"GetActivityTimeSeries", func() (interface{}, error) {
+			return GetActivityTimeSeries(context.Background(), Filter{Start: yesterday, End: end}, day)
+		}},
+		{"GetActivityHeatmap", func() (interface{}, error) { return GetActivityHeatmap(context.Background(), start, end, "") }},
+		{"GetProductViewCount", func() (interface{}, error) { return GetProductViewCount("OLJCESPC7Z", 3*time.Hour) }},
+		{"GetCurrencyTransitions", func() (interface{}, error) { return GetCurrencyTransitions(context.Background(), start, end) }},
+		{"GetCheckoutsByCountry", func() (interface{}, error) { return GetCheckoutsByCountry(context.Background(), start, end) }},
+		{"GetActionAttribution", func() (interface{}, error) { return GetActionAttribution(context.Background(), start, end) }},
+		{"GetFunnel", func() (interface{}, error) {
+			return GetFunnel(context.Background(), start, end, []string{ActivityTypeProductView, ActivityTypeAddToCart, ActivityTypeCheckout})
+		}},
+		{"GetTimeToConversion", func() (interface{}, error) { return GetTimeToConversion(context.Background(), start, end) }},
+		{"GetCampaignPerformance", func() (interface{}, error) { return GetCampaignPerformance(context.Background(), start, end) }},
+		{"GetCohortReport", func() (interface{}, error) { return GetCohortReport(context.Background(), 2) }},
+		{"GetTopNotFoundPaths", func() (interface{}, error) { return GetTopNotFoundPaths(context.Background(), start, end, 10) }},
+		{"GetAssistantUsage", func() (interface{}, error) { return GetAssistantUsage(context.Background(), start, end) }},
+		{"GetRenderTimeStats", func() (interface{}, error) { return GetRenderTimeStats(context.Background(), start, end) }},
+	}
+	snapshot := func() map[string]string {
+		t.Helper()
+		forgetProductViews()
+		got := make(map[string]string)
+		for _, r := range readers {
+			v, err := r.read()
+			if err != nil {
+				t.Fatalf("%s failed: %v", r.name, err)
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				t.Fatalf("encoding what %s returned failed: %v", r.name, err)
+			}
+			got[r.name] = string(b)
+		}
+		return got
+	}
+	empty := snapshot()
+
+	at := now.Add(-2 * time.Hour)
+	for _, a := range []ActivityLog{
+		{RequestID: "page", ActivityType: ActivityTypePageView, Campaign: "summer", CreatedAt: yesterday.Add(time.Hour)},
+		{RequestID: "view", ActivityType: ActivityTypeProductView, ProductID: "OLJCESPC7Z", Details: `{"render_ms":12}`, CreatedAt: at},
+		{RequestID: "order", ActivityType: ActivityTypeCheckout, CreatedAt: at.Add(3 * time.Minute),
+			Details: `{"country":"FR","shipping_cost":8.99,"shipping_currency":"EUR"}`,
+			Items:   []ActivityItem{{ProductID: "OLJCESPC7Z", Quantity: 1}}},
+		{RequestID: "ask", ActivityType: ActivityTypeAssistantMessage, CreatedAt: at.Add(time.Minute)},
+		{RequestID: "add", ParentRequestID: "view", ActivityType: ActivityTypeAddToCart, ProductID: "OLJCESPC7Z", CreatedAt: at.Add(2 * time.Minute)},
+		{RequestID: "currency", ActivityType: ActivityTypeCurrencyChange, CreatedAt: at.Add(4 * time.Minute),
+			Details: `{"previous_currency":"USD","new_currency":"EUR"}`},
+		{RequestID: "missing", ActivityType: ActivityTypeNotFound, Path: "/nope", CreatedAt: at.Add(5 * time.Minute)},
+	} {
+		a.SessionID = "gone"
+		mustLog(t, &a)
+	}
+	if _, err := CatchUpRollups(context.Background()); err != nil {
+		t.Fatalf("CatchUpRollups() failed: %v", err)
+	}
+	logged := snapshot()
+	for name, v := range empty {
+		if logged[name] == v {
+			t.Errorf("%s doesn't see the session to begin with, so it proves nothing", name)
+		}
+	}
+
+	if _, err := DeleteSession(context.Background(), "gone"); err != nil {
+		t.Fatalf("DeleteSession() failed: %v", err)
+	}
+	if !reflect.DeepEqual(snapshot(), empty) {
+		for name, v := range snapshot() {
+			if v != empty[name] {
+				t.Errorf("%s still sees the deleted session: %s", name, v)
+			}
+		}
+	}
+	// Nor do roll-ups rebuilt afterwards
+	if _, err := CatchUpRollups(context.Background()); err != nil {
+		t.Fatalf("CatchUpRollups() failed: %v", err)
+	}
+	if got := snapshot(); got["GetActivityStats"] != empty["GetActivityStats"] {
+		t.Errorf("rebuilt roll-ups count the deleted session: %s", got["GetActivityStats"])
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import "expvar"
+
+// Metrics are published through expvar; the frontend serves them to
+// admins at /debug/vars.
+var (
+	// analyticalQueriesQueued is the number of analytical queries waiting for
+	// a free slot.
+	analyticalQueriesQueued = expvar.NewInt("activity_log_analytical_queries_queued")
+	// analyticalQueriesRejected counts analytical queries that gave up
+	// waiting and returned ErrBusy.
+	analyticalQueriesRejected = expvar.NewInt("activity_log_analytical_queries_rejected_total")
+	// streamDropped counts activities not delivered to a live subscriber
+	// because it fell behind.
+	streamDropped = expvar.NewInt("activity_log_stream_dropped_total")
+	// writeBreakerOpen is 1 while activity writes are suspended by the
+	// circuit breaker, including while a probe write is in flight, and 0
+	// otherwise.
+	writeBreakerOpen = expvar.NewInt("activity_log_write_breaker_open")
+	// writesShortCircuited counts activities dropped by the open breaker.
+	writesShortCircuited = expvar.NewInt("activity_log_writes_short_circuited_total")
+	// activityWritesPending is the number of activity writes in flight.
+	activityWritesPending = expvar.NewInt("activity_log_writes_pending")
+	// activityLoggingDegraded is 1 while a write backlog limits logging to
+	// essential activities, and 0 otherwise.
+	activityLoggingDegraded = expvar.NewInt("activity_log_degraded")
+	// activitiesShed counts activities not logged in essential mode.
+	activitiesShed = expvar.NewInt("activity_log_activities_shed_total")
+	// strictRejections counts requests refused in strict mode because
+	// their activity couldn't be logged.
+	strictRejections = expvar.NewInt("activity_log_strict_rejections_total")
+	// pollWaiters is the number of long polls waiting for an activity.
+	pollWaiters = expvar.NewInt("activity_log_poll_waiters")
+	// webhookSent counts the activities the webhook sink delivered;
+	// webhookFailedRequests counts failed POSTs, including those retried
+	// successfully. Those it gave up on are parked in the outbox.
+	webhookSent           = expvar.NewInt("activity_log_webhook_sent_total")
+	webhookFailedRequests = expvar.NewInt("activity_log_webhook_failed_requests_total")
+)
@@ -15,28 +15,145 @@
 package activitylog
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"maps"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
-	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend"
 )
 
+const cookieCurrency = "shop_currency"
+
+// CtxKeySessionID is the context key under which the frontend stores the
+// session ID of the current request.
+type CtxKeySessionID struct{}
+
+// CtxKeyUserID is the context key under which the frontend stores the ID
+// of the user signed in to the session of the current request, if any.
+type CtxKeyUserID struct{}
+
+// CtxKeyNewSession is the context key under which the frontend marks, with
+// true, the request a session started with, which came without a session
+// cookie.
+type CtxKeyNewSession struct{}
+
+// CtxKeyDeviceID is the context key under which the frontend stores the
+// device ID of the browser of the current request, when it sets one.
+type CtxKeyDeviceID struct{}
+
+// CtxKeyRequestID is the context key under which the frontend stores the
+// request ID of the current request.
+type CtxKeyRequestID struct{}
+
+// ActivityIDHeader is the response header the middleware reports the ID of
+// the activity of a request in, with WithExposedIDs, when the activity is
+// logged before the response is sent, as in strict mode
+const ActivityIDHeader = "X-Activity-Id"
+
+// ActivityRequestIDHeader is the response header the middleware reports
+// the request ID in, with WithExposedIDs, when the activity is only logged
+// once the response is sent. GetActivityByRequestID looks the activity up.
+const ActivityRequestIDHeader = "X-Activity-Request-Id"
+
 // ActivityMiddleware wraps an http.Handler and logs activities
 type ActivityMiddleware struct {
-	log  logrus.FieldLogger
-	next http.Handler
+	log         logrus.FieldLogger
+	next        http.Handler
+	experiments func(sessionID string) map[string]string
+	strict      bool
+	// exposeIDs reports the activity of each request in its response
+	exposeIDs bool
+	// version, revision and flagsHash are stamped on every activity
+	version   string
+	revision  string
+	flagsHash string
+	// tagCookies are the cookies copied into the details of every activity
+	tagCookies []string
+	// currencies, when set, is what currencies are checked against
+	currencies *CurrencyValidator
+	// rateLimiter, when set, throttles sessions making too many requests
+	rateLimiter *SessionRateLimiter
+}
+
+// Option configures optional ActivityMiddleware behavior
+type Option func(*ActivityMiddleware)
+
+// WithExperiments records the experiment assignments returned by assign for
+// the session in the details of every activity, under "experiments".
+func WithExperiments(assign func(sessionID string) map[string]string) Option {
+	return func(m *ActivityMiddleware) {
+		m.experiments = assign
+	}
+}
+
+// WithDeployment stamps every activity with the frontend version and the
+// deployment revision serving it, so that stats can be compared across
+// deploys. Both are constant for the life of the process.
+func WithDeployment(version, revision string) Option {
+	return func(m *ActivityMiddleware) {
+		m.version = version
+		m.revision = revision
+	}
+}
+
+// WithFlagSet stamps every activity with the hash of the feature flags the
+// frontend runs with, as returned by RegisterFlagSet
+func WithFlagSet(hash string) Option {
+	return func(m *ActivityMiddleware) {
+		m.flagsHash = hash
+	}
+}
+
+// WithStrictWrites makes activity logging part of every request that
+// changes state: its activity is written before the handler runs, and the
+// request fails with 503 Service Unavailable when that write fails. Other
+// requests are logged best-effort as usual.
+func WithStrictWrites() Option {
+	return func(m *ActivityMiddleware) {
+		m.strict = true
+	}
+}
+
+// WithExposedIDs reports in each response which activity it was logged as,
+// for a shopper's report to be matched with its activity: the activity ID
+// in ActivityIDHeader when it is logged before the response, as in strict
+// mode, and the request ID in ActivityRequestIDHeader otherwise.
+func WithExposedIDs() Option {
+	return func(m *ActivityMiddleware) {
+		m.exposeIDs = true
+	}
 }
 
 // NewActivityMiddleware creates a new activity logging middleware
-func NewActivityMiddleware(log logrus.FieldLogger, next http.Handler) *ActivityMiddleware {
-	return &ActivityMiddleware{
+func NewActivityMiddleware(log logrus.FieldLogger, next http.Handler, opts ...Option) *ActivityMiddleware {
+	m := &ActivityMiddleware{
 		log:  log,
 		next: next,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// ClassifyRequest returns the activity type of a request routed by mux,
+// and the variables of the route it matched, such as the id of
+// /product/{id}. Only the route template and the method decide the type,
+// never headers, cookies, the query or the path as sent, so a page is
+// classified the same for every shopper. Requests no route matched are
+// ActivityTypeUnknown, routes without a type of their own
+// ActivityTypeOther. The middleware classifies requests with it, route
+// owners can test the classification of the pages they add with it.
+func ClassifyRequest(r *http.Request) (string, map[string]string) {
+	return getActivityType(r), maps.Clone(mux.Vars(r))
 }
 
 // getActivityType determines the type of activity based on the request
@@ -44,15 +161,21 @@ func getActivityType(r *http.Request) string {
 	// Get the route pattern from mux router
 	route := mux.CurrentRoute(r)
 	if route == nil {
-		return "unknown"
+		return ActivityTypeUnknown
 	}
 
 	path, _ := route.GetPathTemplate()
 	method := r.Method
+	// A HEAD request is the same view as a GET, without the body
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
 
 	switch {
 	case path == "/" && method == "GET":
 		return ActivityTypePageView
+	case path == "/cart" && method == "GET":
+		return ActivityTypeViewCart
 	case path == "/cart" && method == "POST":
 		return ActivityTypeAddToCart
 	case path == "/cart/empty" && method == "POST":
@@ -61,74 +184,478 @@ func getActivityType(r *http.Request) string {
 		return ActivityTypeCheckout
 	case path == "/setCurrency" && method == "POST":
 		return ActivityTypeCurrencyChange
+	case path == "/bot" && method == "POST":
+		return ActivityTypeAssistantMessage
 	case strings.HasPrefix(path, "/product/") && method == "GET":
 		return ActivityTypeProductView
 	default:
-		return "other"
+		return ActivityTypeOther
 	}
 }
 
 func (m *ActivityMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(StatusHeader, Status().State)
+	// OPTIONS requests are CORS preflights and the like, asked by browsers
+	// on their own rather than by shoppers
+	if r.Method == http.MethodOptions {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+	// Throttled sessions are refused whether or not they are tracked, or
+	// opting out would get a scraper around the limit
+	if m.rateLimiter != nil {
+		if d := m.rateLimiter.check(r); d.throttled {
+			m.throttle(w, r, d)
+			return
+		}
+	}
+	if !TrackingAllowed(r) {
+		untracked.Add(1)
+		m.next.ServeHTTP(w, r)
+		return
+	}
 	start := time.Now()
 	rr := &responseRecorder{w: w}
+	redirectedFrom := redirectedFrom(w, r)
 
-	// Extract common fields
-	sessionID := r.Context().Value(ctxKeySessionID{}).(string)
-	requestID := r.Context().Value(ctxKeyRequestID{}).(string)
+	// Extract common fields. They are missing when the middleware wraps
+	// the handlers that assign them, so empty values are fine.
+	sessionID, _ := r.Context().Value(CtxKeySessionID{}).(string)
+	userID, _ := r.Context().Value(CtxKeyUserID{}).(string)
+	requestID, _ := r.Context().Value(CtxKeyRequestID{}).(string)
+	isEntry, _ := r.Context().Value(CtxKeyNewSession{}).(bool)
+	deviceID, _ := r.Context().Value(CtxKeyDeviceID{}).(string)
+	routed := mux.CurrentRoute(r) != nil
+	activityType, vars := ClassifyRequest(r)
 	userCurrency := currentCurrency(r)
+	rr.requestID = requestID
 
 	// Create the activity log entry
 	activity := &ActivityLog{
 		SessionID:    sessionID,
+		UserID:       userID,
 		RequestID:    requestID,
-		ActivityType: getActivityType(r),
-		Path:        r.URL.Path,
-		Method:      r.Method,
+		ActivityType: activityType,
+		Path:         normalizePath(r),
+		Method:       r.Method,
 		UserCurrency: userCurrency,
+		Source:       sourceOf(r),
+		Version:      m.version,
+		Revision:     m.revision,
+		FlagsHash:    m.flagsHash,
+		IsEntry:      isEntry,
+		DeviceID:     deviceID,
 	}
+	activity.ReferrerType, activity.ReferrerDomain = classifyReferrer(r)
+	activity.RouteTemplate = routeTemplate(r)
+
+	// The campaign cookie has to be set before the handler writes the
+	// response
+	campaign, utm := trackCampaign(w, r)
+	activity.Campaign = campaign
 
-	// Call the next handler
-	m.next.ServeHTTP(rr, r)
+	inputs := captureInputs(r, activity.ActivityType, vars)
 
-	// Record the response status
+	// In strict mode a change is only made once its activity is on record,
+	// and the response and handler details are filled in afterwards.
+	// Unrouted requests change nothing, so they stay best-effort.
+	strict := m.strict && routed && mutating(r.Method) && !ingesting(r)
+	var details map[string]interface{}
+	var createdAt time.Time
+	// A session over its quota has the change counted, not stored, so
+	// there's no record to complete
+	var suppressed bool
+	if strict {
+		activity.ParentRequestID = parentRequestID(r)
+		details = m.requestDetails(r, activity, inputs, utm)
+		encodeDetails(activity, details)
+		var err error
+		createdAt, err = insertActivity(r.Context(), activity, false)
+		suppressed = errors.Is(err, ErrSessionQuota)
+		if err != nil && !suppressed {
+			requestLogger(r.Context(), m.log).WithError(err).WithFields(logrus.Fields{
+				"activity_type": activity.ActivityType,
+				"path":          activity.Path,
+			}).Error("refusing request: failed to log activity")
+			strictRejections.Add(1)
+			writeUnavailable(w)
+			return
+		}
+		if m.exposeIDs && !suppressed {
+			w.Header().Set(ActivityIDHeader, strconv.FormatInt(activity.ID, 10))
+		}
+	} else if m.exposeIDs && requestID != "" {
+		w.Header().Set(ActivityRequestIDHeader, requestID)
+	}
+
+	// Let the handler attach details of its own
+	handlerDetails := &requestDetails{}
+	r = r.WithContext(context.WithValue(r.Context(), ctxKeyDetails{}, handlerDetails))
+
+	// Call the next handler. What is read of the request afterwards is
+	// read from the one actually handed down, should r be replaced.
+	handled := r
+	capture := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled = r
+		m.next.ServeHTTP(w, r)
+	})
+	capture.ServeHTTP(rr, r)
+	// A request signing the user in belongs to them too
+	if userID := handlerDetails.user(); userID != "" {
+		activity.UserID = userID
+	}
+
+	if strict {
+		if suppressed {
+			return
+		}
+		activity.CreatedAt = createdAt
+		m.completeActivity(r.Context(), activity, details, rr, handlerDetails, time.Since(start))
+		return
+	}
+
+	if handlerDetails.skipped() {
+		return
+	}
+	ev := handlerDetails.recordedClientEvent()
+	if ev != nil {
+		activity.ActivityType = ev.Type
+		activity.Source = SourceClient
+	}
+
+	// Record the response status and how long the request took
+	activity.StatusCode = rr.status
+	activity.ResponseClass = ResponseClassOf(rr.status, rr.Header().Get("Content-Type"))
+	activity.LatencyMs = time.Since(start).Milliseconds()
+	activity.ParentRequestID = parentRequestID(handled)
+	if activity.ParentRequestID == "" {
+		activity.ParentRequestID = redirectedFrom
+	}
+	if !routed && rr.status == http.StatusNotFound {
+		activity.ActivityType = ActivityTypeNotFound
+	}
+	if handlerDetails.renderFailed() {
+		activity.ActivityType = ActivityTypeRenderError
+	}
+	// Under a write backlog only what can't be reconstructed is kept
+	if !essential(activity) && CurrentLoggingMode() == LoggingEssential {
+		activitiesShed.Add(1)
+		return
+	}
+
+	details = m.requestDetails(handled, activity, inputs, utm)
+	mergeDetails(details, outcomeDetails(rr, handlerDetails))
+	if ev != nil {
+		mergeDetails(details, ev.Details)
+	}
+	encodeDetails(activity, details)
+	activity.Items = handlerDetails.lineItems()
+
+	// Log the activity
+	// Dropped activities are counted by the breaker, as skipped while
+	// read-only or as suppressed over a session quota, not logged one by
+	// one, and there is nothing to log to without a database
+	if err := LogActivityContext(r.Context(), activity); err != nil &&
+		!errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrNotInitialized) && !errors.Is(err, ErrReadOnly) &&
+		!errors.Is(err, ErrSessionQuota) {
+		requestLogger(r.Context(), m.log).WithError(err).WithFields(logrus.Fields{
+			"activity_type": activity.ActivityType,
+			"path":          activity.Path,
+		}).Warn("failed to log activity")
+	}
+}
+
+// completeActivity records the response to a request whose activity was
+// logged before it was handled, and publishes the complete activity.
+// Requests that asked not to be logged keep their record: the change was
+// made, so it stays on it.
+func (m *ActivityMiddleware) completeActivity(ctx context.Context, activity *ActivityLog, details map[string]interface{}, rr *responseRecorder, handlerDetails *requestDetails, latency time.Duration) {
 	activity.StatusCode = rr.status
+	activity.ResponseClass = ResponseClassOf(rr.status, rr.Header().Get("Content-Type"))
+	activity.LatencyMs = latency.Milliseconds()
+	log := requestLogger(ctx, m.log).WithFields(logrus.Fields{
+		"activity_type": activity.ActivityType,
+		"path":          activity.Path,
+	})
+	if err := updateActivityResponse(ctx, activity.ID, activity.StatusCode, activity.ResponseClass, latency); err != nil {
+		log.WithError(err).Warn("failed to record the activity status")
+	}
 
+	if items := handlerDetails.lineItems(); len(items) > 0 {
+		if err := addActivityItems(ctx, activity.ID, items); err != nil {
+			log.WithError(err).Warn("failed to record the activity items")
+		}
+		activity.Items = items
+	}
+	if outcome := outcomeDetails(rr, handlerDetails); len(outcome) > 0 {
+		outcomeJSON, err := json.Marshal(outcome)
+		if err == nil {
+			err = mergeActivityDetails(ctx, activity.ID, string(outcomeJSON))
+		}
+		if err != nil {
+			log.WithError(err).Warn("failed to record the activity details")
+		}
+		mergeDetails(details, outcome)
+		encodeDetails(activity, details)
+	}
+	if err := enqueueOutbox(ctx, activity); err != nil {
+		log.WithError(err).Warn("failed to queue the activity for forwarding")
+	}
+	publish(*activity)
+}
+
+// requestInputs are what the details of an activity are made of that the
+// handler of its request can change or consume: the vars of the route,
+// the form, read from the body, and the currency cookie, which the
+// currency handler overwrites. They are captured before it runs.
+type requestInputs struct {
+	vars map[string]string
+	form map[string]string
+	// previousCurrency is empty for sessions still on the default currency
+	previousCurrency string
+}
+
+// formFields are the form values the details of an activity type are
+// made of
+var formFields = map[string][]string{
+	ActivityTypeAddToCart:      {"product_id", "quantity"},
+	ActivityTypeCurrencyChange: {"currency_code"},
+}
+
+// captureInputs copies the inputs of a request of the given activity type,
+// which matched a route with vars. Reading the form parses it into r, so
+// the handler still gets it.
+func captureInputs(r *http.Request, activityType string, vars map[string]string) requestInputs {
+	inputs := requestInputs{vars: vars}
+	if fields := formFields[activityType]; len(fields) > 0 {
+		inputs.form = make(map[string]string, len(fields))
+		for _, field := range fields {
+			inputs.form[field] = r.FormValue(field)
+		}
+	}
+	if activityType == ActivityTypeCurrencyChange {
+		if c, err := r.Cookie(cookieCurrency); err == nil {
+			inputs.previousCurrency = c.Value
+		}
+	}
+	return inputs
+}
+
+// requestDetails returns the details of activity that come from the request
+// itself, and normalizes its currency
+func (m *ActivityMiddleware) requestDetails(r *http.Request, activity *ActivityLog, inputs requestInputs, utm map[string]string) map[string]interface{} {
 	// Add any relevant details based on the activity type
 	details := make(map[string]interface{})
 	switch activity.ActivityType {
 	case ActivityTypeAddToCart:
-		details["product_id"] = r.FormValue("product_id")
-		details["quantity"] = r.FormValue("quantity")
+		cartAdd := quantityDetails(inputs.form["quantity"])
+		cartAdd.ProductID = inputs.form["product_id"]
+		mergeDetails(details, detailsMap(cartAdd))
 	case ActivityTypeProductView:
-		if route := mux.CurrentRoute(r); route != nil {
-			vars := mux.Vars(r)
-			details["product_id"] = vars["id"]
-		}
+		mergeDetails(details, detailsMap(ProductViewDetails{ProductID: inputs.vars["id"]}))
 	case ActivityTypeCurrencyChange:
-		details["new_currency"] = r.FormValue("currency_code")
+		mergeDetails(details, detailsMap(CurrencyChangeDetails{
+			NewCurrency:      inputs.form["currency_code"],
+			PreviousCurrency: inputs.previousCurrency,
+		}))
+	}
+	rawPathDetail(r, activity, details)
+	if activity.IsEntry {
+		entryDetails(r, activity, utm, details)
 	}
 
-	if len(details) > 0 {
-		detailsJSON, err := json.Marshal(details)
-		if err == nil {
-			activity.Details = string(detailsJSON)
+	for param, value := range utm {
+		details[param] = value
+	}
+	if tags := cookieTags(r, m.tagCookies); len(tags) > 0 {
+		details["tags"] = tags
+	}
+
+	sanitizeDetails(details)
+	// Details keep the product for whoever reads them, the column is what
+	// queries use. Product IDs that didn't pass sanitizing aren't kept.
+	if productID, _ := details["product_id"].(string); productID != "" {
+		activity.ProductID = productID
+	}
+	currency, invalid := sanitizeCurrency(activity.UserCurrency)
+	if invalid == "" && currency != "" && m.currencies != nil && !m.currencies.Valid(currency) {
+		currency, invalid = CurrencyInvalid, currency
+	}
+	if invalid != "" {
+		activity.UserCurrency = currency
+		mergeDetails(details, map[string]interface{}{
+			"raw_invalid": map[string]string{"user_currency": invalid},
+		})
+	}
+
+	if m.experiments != nil {
+		if assignments := m.experiments(activity.SessionID); len(assignments) > 0 {
+			details["experiments"] = assignments
 		}
 	}
+	return details
+}
 
-	// Log the activity
-	if err := LogActivity(activity); err != nil {
-		m.log.Warnf("Failed to log activity: %v", err)
+// outcomeDetails returns the details that are only known once the handler
+// ran: those it attached and the start of a server error response
+func outcomeDetails(rr *responseRecorder, handlerDetails *requestDetails) map[string]interface{} {
+	details := make(map[string]interface{})
+	handlerDetails.mergeInto(details)
+	sanitizeDetails(details)
+	if rr.snippet != nil {
+		details["error_snippet"] = string(rr.snippet)
+	}
+	return details
+}
+
+// mergeDetails adds src to dst, combining the invalid raw values both kept
+func mergeDetails(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if k == "raw_invalid" {
+			raw, _ := dst[k].(map[string]string)
+			if raw == nil {
+				raw = make(map[string]string)
+				dst[k] = raw
+			}
+			src, _ := v.(map[string]string)
+			for field, value := range src {
+				raw[field] = value
+			}
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// encodeDetails stores details in activity, leaving it without details when
+// there are none
+func encodeDetails(activity *ActivityLog, details map[string]interface{}) {
+	if len(details) == 0 {
+		return
+	}
+	if detailsJSON, ok := appendDetails(make([]byte, 0, 512), details); ok {
+		activity.Details = string(detailsJSON)
+		return
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err == nil {
+		activity.Details = string(detailsJSON)
+	}
+}
+
+// appendDetails appends details to b as json.Marshal encodes them, which
+// costs it an allocation per value. Only the types of value the middleware
+// records itself are handled; false means details hold another.
+func appendDetails(b []byte, details map[string]interface{}) ([]byte, bool) {
+	b = append(b, '{')
+	for i, key := range sortedKeys(details) {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendJSONString(b, key)
+		b = append(b, ':')
+		switch v := details[key].(type) {
+		case string:
+			b = appendJSONString(b, v)
+		case bool:
+			b = strconv.AppendBool(b, v)
+		case int:
+			b = strconv.AppendInt(b, int64(v), 10)
+		case float64:
+			// Where json.Marshal doesn't switch to exponents, or fails
+			if a := math.Abs(v); a >= 1e21 || (a != 0 && a < 1e-6) || math.IsNaN(v) {
+				return nil, false
+			}
+			b = strconv.AppendFloat(b, v, 'f', -1, 64)
+		case map[string]string:
+			b = append(b, '{')
+			for j, k := range sortedKeys(v) {
+				if j > 0 {
+					b = append(b, ',')
+				}
+				b = appendJSONString(b, k)
+				b = append(b, ':')
+				b = appendJSONString(b, v[k])
+			}
+			b = append(b, '}')
+		default:
+			return nil, false
+		}
+	}
+	return append(b, '}'), true
+}
+
+// sortedKeys returns the keys of m in order, as maps are encoded
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// ingesting tells whether a request reports a client event. Those change
+// nothing, and are only known to carry a valid one once handled.
+func ingesting(r *http.Request) bool {
+	return strings.HasSuffix(routeTemplate(r), IngestPath)
+}
+
+// routeTemplate returns the path template of the route a request matched,
+// or "" for unrouted requests
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	path, _ := route.GetPathTemplate()
+	return path
+}
+
+// mutating tells whether a request with the given method changes state
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// writeUnavailable answers a request that was refused because its activity
+// couldn't be logged, in the JSON error format of the activity API
+func writeUnavailable(w http.ResponseWriter) {
+	writeRefusal(w, http.StatusServiceUnavailable, 1, "the request can't be recorded right now, please try again")
+}
+
+// writeRefusal answers a request the middleware refused with code, in the
+// JSON error format of the activity API, asking to retry after retryAfter
+// seconds
+func writeRefusal(w http.ResponseWriter, code, retryAfter int, message string) {
+	var body struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
 	}
+	body.Error.Code = code
+	body.Error.Message = message
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
 }
 
-// Import context keys from main package
-// Use the context key types from the main package
-type ctxKeySessionID = main.CtxKeySessionID
-type ctxKeyRequestID = main.CtxKeyRequestID
+// maxErrorSnippet is how much of a server error response body is kept
+const maxErrorSnippet = 1024
 
 type responseRecorder struct {
 	w      http.ResponseWriter
 	status int
+	// requestID is passed on to the request a redirect leads to
+	requestID string
+	// snippet holds the start of the body of server error responses. It
+	// stays nil, and costs nothing, for every other response.
+	snippet []byte
 }
 
 func (r *responseRecorder) Header() http.Header {
@@ -139,19 +666,58 @@ func (r *responseRecorder) Write(b []byte) (int, error) {
 	if r.status == 0 {
 		r.status = http.StatusOK
 	}
+	if r.snippet != nil && len(r.snippet) < maxErrorSnippet {
+		n := min(len(b), maxErrorSnippet-len(r.snippet))
+		r.snippet = append(r.snippet, b[:n]...)
+	}
 	return r.w.Write(b)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer, for
+// instance to flush streamed responses
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.w
+}
+
 func (r *responseRecorder) WriteHeader(status int) {
 	r.status = status
+	if status >= 300 && status < 400 && r.requestID != "" {
+		setRedirectCookie(r.w.Header(), r.requestID)
+	}
+	if status >= http.StatusInternalServerError && r.snippet == nil {
+		r.snippet = make([]byte, 0, maxErrorSnippet)
+	}
 	r.w.WriteHeader(status)
 }
 
+// parentRequestID returns the ID of the request that rendered the page r
+// was sent from, as embedded by the frontend's forms or set by scripts.
+// Anything that isn't a request ID is ignored.
+func parentRequestID(r *http.Request) string {
+	id := r.Header.Get("X-Parent-Request")
+	if id == "" {
+		id = r.PostFormValue("parent_request_id")
+	}
+	if !requestIDFormat.MatchString(id) {
+		return ""
+	}
+	return id
+}
+
+// sourceOf tells traffic from the bundled load generator (Locust) apart
+// from real visitors
+func sourceOf(r *http.Request) string {
+	if strings.Contains(strings.ToLower(r.UserAgent()), "locust") {
+		return SourceLoadGenerator
+	}
+	return SourceWeb
+}
+
 // currentCurrency gets the currency from the request context
 func currentCurrency(r *http.Request) string {
-	c, _ := r.Cookie("currency")
+	c, _ := r.Cookie(cookieCurrency)
 	if c != nil {
 		return c.Value
 	}
 	return "USD"
-}
\ No newline at end of file
+}
@@ -0,0 +1,137 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// Allocation budgets: how many allocations the middleware may add to a
+// request of each shape, with nowhere to store activities.
+// TestMiddlewareAllocBudget fails above them: a new capture feature that
+// needs more should say so by raising them.
+const (
+	pageViewAllocBudget    = 40
+	productViewAllocBudget = 50
+	addToCartAllocBudget   = 70
+)
+
+// requestShapes are representative requests of the activity types logged
+// the most, as browsers send them
+var requestShapes = []struct {
+	name        string
+	request     func() *http.Request
+	allocBudget int
+}{
+	{ActivityTypePageView, func() *http.Request {
+		return browserRequest(httptest.NewRequest(http.MethodGet, "/", nil))
+	}, pageViewAllocBudget},
+	{ActivityTypeProductView, func() *http.Request {
+		return browserRequest(httptest.NewRequest(http.MethodGet, "/product/OLJCESPC7Z", nil))
+	}, productViewAllocBudget},
+	{ActivityTypeAddToCart, func() *http.Request {
+		// Parsing the form accounts for a dozen allocations, which the
+		// frontend's handler would otherwise make
+		return browserRequest(postForm("/cart", "product_id=OLJCESPC7Z&quantity=2"))
+	}, addToCartAllocBudget},
+}
+
+func browserRequest(req *http.Request) *http.Request {
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0")
+	req.Header.Set("Referer", "https://example.com/")
+	req.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "EUR"})
+	req.AddCookie(&http.Cookie{Name: "loyalty_tier", Value: "gold"})
+	return req
+}
+
+// benchRouter returns newTestRouter with every option the frontend runs
+// the middleware with
+func benchRouter(tb testing.TB) http.Handler {
+	currencies := NewCurrencyValidator(func(context.Context) ([]string, error) {
+		return []string{"EUR", "USD", "JPY"}, nil
+	}, 0)
+	if err := currencies.Refresh(context.Background()); err != nil {
+		tb.Fatalf("Refresh() failed: %v", err)
+	}
+	return newTestRouter(
+		WithExperiments(func(string) map[string]string { return map[string]string{"checkout_button": "blue"} }),
+		WithDeployment("v0.10.3", "rev-1"),
+		WithFlagSet("59196e765d5de285"),
+		WithCurrencyValidator(currencies),
+		WithTagCookies("loyalty_tier"),
+		WithSessionRateLimiter(NewSessionRateLimiter(1<<30)),
+	)
+}
+
+// memoryDir returns a directory in memory for a database to live in, on
+// the tmpfs at /dev/shm where there is one
+func memoryDir(tb testing.TB) string {
+	if _, err := os.Stat("/dev/shm"); err != nil {
+		return tb.TempDir()
+	}
+	dir, err := os.MkdirTemp("/dev/shm", "activitylog-bench")
+	if err != nil {
+		tb.Fatalf("creating a directory in /dev/shm failed: %v", err)
+	}
+	tb.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// BenchmarkMiddleware measures what logging costs a request of each shape:
+// with no database to store the activity in, which is the middleware
+// alone, and with one in memory. "bare" is the same request without the
+// middleware, the baseline both compare to.
+func BenchmarkMiddleware(b *testing.B) {
+	for _, store := range []string{"bare", "noop", "sqlite"} {
+		for _, shape := range requestShapes {
+			b.Run(store+"/"+shape.name, func(b *testing.B) {
+				var h http.Handler
+				switch store {
+				case "bare":
+					h = newBareRouter()
+				case "noop":
+					h = benchRouter(b)
+				case "sqlite":
+					setupTestDBIn(b, memoryDir(b))
+					h = benchRouter(b)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					serve(h, shape.request(), "session-1")
+				}
+			})
+		}
+	}
+}
+
+// TestMiddlewareAllocBudget keeps the middleware within the allocation
+// budget of each request shape
+func TestMiddlewareAllocBudget(t *testing.T) {
+	bare, h := newBareRouter(), benchRouter(t)
+	for _, shape := range requestShapes {
+		t.Run(shape.name, func(t *testing.T) {
+			baseline := testing.AllocsPerRun(100, func() { serve(bare, shape.request(), "session-1") })
+			allocs := testing.AllocsPerRun(100, func() { serve(h, shape.request(), "session-1") })
+			if added := allocs - baseline; added > float64(shape.allocBudget) {
+				t.Errorf("the middleware adds %.0f allocations to a %s, over the budget of %d", added, shape.name, shape.allocBudget)
+			}
+		})
+	}
+}
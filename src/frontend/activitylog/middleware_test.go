@@ -0,0 +1,610 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestRouter returns a router with stub handlers for the frontend routes
+// the middleware classifies, wrapped in the activity middleware.
+func newTestRouter(opts ...Option) *mux.Router {
+	log := logrus.New()
+	log.Out = io.Discard
+	r := newBareRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return NewActivityMiddleware(log, next, opts...)
+	})
+	r.NotFoundHandler = NewActivityMiddleware(log, http.NotFoundHandler(), opts...)
+	return r
+}
+
+// newBareRouter returns the routes of newTestRouter without the middleware
+func newBareRouter() *mux.Router {
+	ok := func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }
+	redirect := func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusFound) }
+
+	r := mux.NewRouter()
+	r.HandleFunc("/", ok).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/product/{id}", ok).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/cart", ok).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/cart", redirect).Methods(http.MethodPost)
+	r.HandleFunc("/cart/empty", redirect).Methods(http.MethodPost)
+	r.HandleFunc("/cart/checkout", ok).Methods(http.MethodPost)
+	r.HandleFunc("/setCurrency", redirect).Methods(http.MethodPost)
+	r.HandleFunc("/bot", ok).Methods(http.MethodPost)
+	return r
+}
+
+// serve sends req through h as the frontend's outer handlers would, with the
+// session and request IDs already in the context.
+func serve(h http.Handler, req *http.Request, sessionID string) *httptest.ResponseRecorder {
+	ctx := context.WithValue(req.Context(), CtxKeySessionID{}, sessionID)
+	ctx = context.WithValue(ctx, CtxKeyRequestID{}, "request-"+sessionID)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req.WithContext(ctx))
+	return w
+}
+
+func postForm(path, form string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// lastActivity returns the most recently logged activity. It orders by ID
+// because the fake clock gives activities identical timestamps.
+func lastActivity(t *testing.T) ActivityLog {
+	t.Helper()
+	got, err := queryActivities("SELECT " + activityColumns + " FROM activities ORDER BY id DESC LIMIT 1")
+	if err != nil {
+		t.Fatalf("querying the last activity failed: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatal("no activity was logged")
+	}
+	return got[0]
+}
+
+func detailsOf(t *testing.T, a ActivityLog) map[string]interface{} {
+	t.Helper()
+	details := make(map[string]interface{})
+	if a.Details == "" {
+		return details
+	}
+	if err := json.Unmarshal([]byte(a.Details), &details); err != nil {
+		t.Fatalf("activity details %q are not JSON: %v", a.Details, err)
+	}
+	return details
+}
+
+func TestClassifyRequest(t *testing.T) {
+	type classification struct {
+		activityType string
+		vars         map[string]string
+	}
+	var got classification
+	router := newBareRouter()
+	router.Use(func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got.activityType, got.vars = ClassifyRequest(r)
+		})
+	})
+
+	for _, tc := range []struct {
+		req  *http.Request
+		want classification
+	}{
+		{httptest.NewRequest(http.MethodGet, "/product/OLJCESPC7Z?utm_source=x", nil), classification{ActivityTypeProductView, map[string]string{"id": "OLJCESPC7Z"}}},
+		{httptest.NewRequest(http.MethodHead, "/cart", nil), classification{ActivityTypeViewCart, map[string]string{}}},
+		{postForm("/cart", "product_id=OLJCESPC7Z"), classification{ActivityTypeAddToCart, map[string]string{}}},
+		{httptest.NewRequest(http.MethodPost, "/cart/empty", nil), classification{ActivityTypeEmptyCart, map[string]string{}}},
+	} {
+		// Headers and cookies don't change the type
+		tc.req.Header.Set("Accept-Language", "fr-FR")
+		tc.req.Header.Set("User-Agent", "curl/8.0")
+		tc.req.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "JPY"})
+		got = classification{}
+		router.ServeHTTP(httptest.NewRecorder(), tc.req)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ClassifyRequest(%s %s) = %+v, want %+v", tc.req.Method, tc.req.URL, got, tc.want)
+		}
+	}
+
+	// Requests that no route matched
+	if typ, vars := ClassifyRequest(httptest.NewRequest(http.MethodGet, "/cart", nil)); typ != ActivityTypeUnknown || vars != nil {
+		t.Errorf("ClassifyRequest() of an unrouted request = %s, %v; want unknown, no vars", typ, vars)
+	}
+}
+
+func TestMiddlewareClassifiesHeadLikeGet(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	for path, want := range map[string]string{
+		"/":                   ActivityTypePageView,
+		"/product/OLJCESPC7Z": ActivityTypeProductView,
+		"/cart":               ActivityTypeViewCart,
+	} {
+		serve(router, httptest.NewRequest(http.MethodHead, path, nil), "session-1")
+		if a := lastActivity(t); a.ActivityType != want || a.Method != http.MethodHead {
+			t.Errorf("HEAD %s logged as %s %s, want %s", path, a.Method, a.ActivityType, want)
+		}
+	}
+}
+
+func TestMiddlewareSkipsOptions(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	// Neither routed nor unrouted preflights are shopper activity
+	for _, path := range []string{"/", "/activities"} {
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", "https://dashboard.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		serve(router, req, "session-1")
+	}
+	if n := countActivities(t); n != 0 {
+		t.Errorf("%d activities logged for OPTIONS requests, want none", n)
+	}
+}
+
+func TestMiddlewareRespectsConsent(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: ConsentCookie, Value: ConsentDeclined})
+	if w := serve(router, req, "session-1"); w.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", w.Code)
+	}
+	if n := countActivities(t); n != 0 {
+		t.Errorf("%d activities logged after opting out, want none", n)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: ConsentCookie, Value: "on"})
+	serve(router, req, "session-1")
+	if n := countActivities(t); n != 1 {
+		t.Errorf("%d activities logged after opting in, want 1", n)
+	}
+}
+
+func TestMiddlewareRecordsPreviousCurrency(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	tests := []struct {
+		name   string
+		cookie string
+		want   string
+	}{
+		{"default currency", "", ""},
+		{"previously selected", "EUR", "EUR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := postForm("/setCurrency", "currency_code=JPY")
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: cookieCurrency, Value: tt.cookie})
+			}
+			serve(router, req, "session-1")
+
+			a := lastActivity(t)
+			want := map[string]interface{}{"new_currency": "JPY", "previous_currency": tt.want}
+			if got := detailsOf(t, a); a.ActivityType != ActivityTypeCurrencyChange || !reflect.DeepEqual(got, want) {
+				t.Errorf("logged %s with details %v, want currency_change with %v", a.ActivityType, got, want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareSanitizesCartDetails(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	req := postForm("/cart", url.Values{
+		"product_id": {"<script>alert(1)</script>"},
+		"quantity":   {"1\"><img src=x onerror=alert(1)>"},
+	}.Encode())
+	req.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "<svg/onload=alert(1)>"})
+	serve(router, req, "session-1")
+
+	a := lastActivity(t)
+	if strings.ContainsAny(a.Details, "<>") {
+		t.Errorf("stored details %s contain markup", a.Details)
+	}
+	want := map[string]interface{}{
+		"raw_invalid": map[string]interface{}{
+			"product_id":    "&lt;script&gt;alert(1)&lt;/script&gt;",
+			"quantity":      "1&#34;&gt;&lt;img src=x onerror=alert(1)&gt;",
+			"user_currency": "&lt;svg/onload=alert(1)&gt;",
+		},
+	}
+	if got := detailsOf(t, a); !reflect.DeepEqual(got, want) {
+		t.Errorf("details = %v, want %v", got, want)
+	}
+	if a.UserCurrency != CurrencyInvalid {
+		t.Errorf("UserCurrency = %q, want %s", a.UserCurrency, CurrencyInvalid)
+	}
+	if a.ProductID != "" {
+		t.Errorf("ProductID = %q, want it dropped", a.ProductID)
+	}
+}
+
+func TestEscapeInvalidRoundTrips(t *testing.T) {
+	for _, value := range []string{
+		"<script>alert(1)</script>",
+		"&lt;b&gt; & \"quoted\"",
+		"1&amp;2",
+	} {
+		escaped := escapeInvalid(value)
+		if again := escapeInvalid(escaped); again != escaped {
+			t.Errorf("escapeInvalid(%q) = %q, escaped again %q", value, escaped, again)
+		}
+		if got, want := html.UnescapeString(escaped), html.UnescapeString(value); got != want {
+			t.Errorf("escapeInvalid(%q) unescapes to %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestMiddlewareKeepsValidCartDetails(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	req := postForm("/cart", "product_id=OLJCESPC7Z&quantity=3")
+	req.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "EUR"})
+	serve(router, req, "session-1")
+
+	a := lastActivity(t)
+	want := map[string]interface{}{"product_id": "OLJCESPC7Z", "quantity": "3", "quantity_int": float64(3)}
+	if got := detailsOf(t, a); !reflect.DeepEqual(got, want) || a.UserCurrency != "EUR" {
+		t.Errorf("logged %v in %s, want %v in EUR", got, a.UserCurrency, want)
+	}
+	if a.ProductID != "OLJCESPC7Z" {
+		t.Errorf("ProductID = %q, want OLJCESPC7Z", a.ProductID)
+	}
+}
+
+func TestMiddlewareRecordsParentRequest(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+	const parent = "0d6f8b7a-3c2e-4f1a-8b9c-7d6e5f4a3b2c"
+
+	tests := []struct {
+		name string
+		req  func() *http.Request
+		want string
+	}{
+		{"form field", func() *http.Request {
+			return postForm("/cart", "product_id=OLJCESPC7Z&quantity=1&parent_request_id="+parent)
+		}, parent},
+		{"header", func() *http.Request {
+			req := postForm("/cart/empty", "")
+			req.Header.Set("X-Parent-Request", parent)
+			return req
+		}, parent},
+		{"missing", func() *http.Request {
+			return postForm("/cart/empty", "")
+		}, ""},
+		{"not a request ID", func() *http.Request {
+			return postForm("/cart/empty", "parent_request_id=%3Cscript%3E")
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serve(router, tt.req(), "session-1")
+			if got := lastActivity(t).ParentRequestID; got != tt.want {
+				t.Errorf("ParentRequestID = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRecordsExperiments(t *testing.T) {
+	setupTestDB(t)
+	assignments := map[string]string{"home_personalization": "treatment"}
+	router := newTestRouter(WithExperiments(func(sessionID string) map[string]string {
+		if sessionID != "session-1" {
+			t.Errorf("experiments assigned for session %q, want session-1", sessionID)
+		}
+		return assignments
+	}))
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/", nil),
+		httptest.NewRequest(http.MethodGet, "/product/OLJCESPC7Z", nil),
+		postForm("/cart", "product_id=OLJCESPC7Z&quantity=1"),
+	} {
+		serve(router, req, "session-1")
+		a := lastActivity(t)
+		got := detailsOf(t, a)["experiments"]
+		if !reflect.DeepEqual(got, map[string]interface{}{"home_personalization": "treatment"}) {
+			t.Errorf("%s %s: experiments = %v, want %v", req.Method, req.URL.Path, got, assignments)
+		}
+	}
+}
+
+func TestMiddlewareRecordsDeployment(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter(WithDeployment("1.2.0", "green"))
+
+	serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "session-1")
+	a := lastActivity(t)
+	if a.Version != "1.2.0" || a.Revision != "green" {
+		t.Errorf("deployment = %q/%q, want 1.2.0/green", a.Version, a.Revision)
+	}
+
+	setupTestDB(t)
+	serve(newTestRouter(), httptest.NewRequest(http.MethodGet, "/", nil), "session-1")
+	if a := lastActivity(t); a.Version != "" || a.Revision != "" {
+		t.Errorf("deployment without WithDeployment = %q/%q, want none", a.Version, a.Revision)
+	}
+}
+
+func TestMiddlewareRecordsUser(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+	router.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		SetUser(r.Context(), "alice")
+		w.WriteHeader(http.StatusFound)
+	}).Methods(http.MethodPost)
+
+	serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "session-1")
+	if got := lastActivity(t).UserID; got != "" {
+		t.Errorf("anonymous UserID = %q, want none", got)
+	}
+	serve(router, postForm("/login", "user_id=alice"), "session-1")
+	if got := lastActivity(t).UserID; got != "alice" {
+		t.Errorf("UserID of the sign-in = %q, want alice", got)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/cart", nil)
+	serve(router, req.WithContext(context.WithValue(req.Context(), CtxKeyUserID{}, "alice")), "session-1")
+	if got := lastActivity(t).UserID; got != "alice" {
+		t.Errorf("signed-in UserID = %q, want alice", got)
+	}
+}
+
+func TestMiddlewareRecordsSource(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"Mozilla/5.0 (X11; Linux x86_64)", SourceWeb},
+		{"locust/2.20.0", SourceLoadGenerator},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("User-Agent", tt.userAgent)
+		serve(router, req, "session-1")
+		if got := lastActivity(t).Source; got != tt.want {
+			t.Errorf("source for User-Agent %q = %q, want %q", tt.userAgent, got, tt.want)
+		}
+	}
+}
+
+func TestMiddlewareRecordsCartViews(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	serve(router, httptest.NewRequest(http.MethodGet, "/cart", nil), "session-1")
+	if got := lastActivity(t).ActivityType; got != ActivityTypeViewCart {
+		t.Errorf("GET /cart logged as %q, want %q", got, ActivityTypeViewCart)
+	}
+	serve(router, postForm("/cart", "product_id=OLJCESPC7Z&quantity=1"), "session-1")
+	if got := lastActivity(t).ActivityType; got != ActivityTypeAddToCart {
+		t.Errorf("POST /cart logged as %q, want %q", got, ActivityTypeAddToCart)
+	}
+}
+
+func TestMiddlewareTracksCampaign(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	w := serve(router, httptest.NewRequest(http.MethodGet, "/?utm_source=newsletter&utm_campaign=spring-sale", nil), "session-1")
+	entry := lastActivity(t)
+	if entry.Campaign != "spring-sale" {
+		t.Errorf("entry campaign = %q, want spring-sale", entry.Campaign)
+	}
+	details := detailsOf(t, entry)
+	if details["utm_source"] != "newsletter" || details["utm_campaign"] != "spring-sale" {
+		t.Errorf("entry details = %v, want the utm parameters", details)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != cookieCampaign || cookies[0].Value != "spring-sale" {
+		t.Fatalf("cookies = %v, want the campaign cookie", cookies)
+	}
+	req := postForm("/cart", "product_id=OLJCESPC7Z&quantity=1")
+	req.AddCookie(cookies[0])
+	serve(router, req, "session-1")
+	if got := lastActivity(t); got.Campaign != "spring-sale" {
+		t.Errorf("later campaign = %q, want it inherited from the cookie", got.Campaign)
+	}
+
+	// Malformed campaigns are neither remembered nor stored as-is
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/?utm_campaign=%3Cscript%3E", nil), "session-2")
+	if got := lastActivity(t); got.Campaign != "" || detailsOf(t, got)["raw_invalid"] == nil {
+		t.Errorf("invalid campaign logged as %+v", got)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("invalid campaign set cookies %v", cookies)
+	}
+}
+
+func TestMiddlewareCapturesServerErrorSnippet(t *testing.T) {
+	setupTestDB(t)
+	page := strings.Repeat("x", 2*maxErrorSnippet)
+	r := newTestRouter()
+	r.HandleFunc("/fails", func(w http.ResponseWriter, r *http.Request) {
+		AddDetail(r.Context(), "error", "checkout service unavailable")
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, page[:maxErrorSnippet/2])
+		io.WriteString(w, page[maxErrorSnippet/2:])
+	})
+	r.HandleFunc("/ok", func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, page)
+	})
+	r.HandleFunc("/missing", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "no such page", http.StatusNotFound)
+	})
+
+	serve(r, httptest.NewRequest(http.MethodGet, "/fails", nil), "session-1")
+	details := detailsOf(t, lastActivity(t))
+	if got := details["error_snippet"]; got != page[:maxErrorSnippet] {
+		t.Errorf("error_snippet = %q, want the first %d bytes of the body", got, maxErrorSnippet)
+	}
+	if got := details["error"]; got != "checkout service unavailable" {
+		t.Errorf("error = %q, want the error placed by the handler", got)
+	}
+
+	for _, path := range []string{"/ok", "/missing"} {
+		serve(r, httptest.NewRequest(http.MethodGet, path, nil), "session-1")
+		if got, ok := detailsOf(t, lastActivity(t))["error_snippet"]; ok {
+			t.Errorf("%s: error_snippet = %q, want none", path, got)
+		}
+	}
+}
+
+func TestMiddlewareAddsUpDurations(t *testing.T) {
+	setupTestDB(t)
+	r := newTestRouter()
+	r.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		AddDuration(r.Context(), "grpc_ms", 1500*time.Microsecond)
+		AddDuration(r.Context(), "grpc_ms", 2*time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serve(r, httptest.NewRequest(http.MethodGet, "/slow", nil), "session-1")
+	if got := detailsOf(t, lastActivity(t))["grpc_ms"]; got != 3.5 {
+		t.Errorf("grpc_ms = %v, want 3.5", got)
+	}
+}
+
+func TestMiddlewareSkipsActivity(t *testing.T) {
+	setupTestDB(t)
+	r := newTestRouter()
+	r.HandleFunc("/forget", func(w http.ResponseWriter, r *http.Request) {
+		SkipActivity(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}).Methods(http.MethodPost)
+
+	serve(r, httptest.NewRequest(http.MethodPost, "/forget", nil), "session-1")
+	if got := countActivities(t); got != 0 {
+		t.Errorf("%d activities logged, want none", got)
+	}
+}
+
+// Handlers and middlewares may replace the request, consume its body or
+// change its route vars; what is logged is what the request asked for.
+func TestMiddlewareSurvivesReplacedRequests(t *testing.T) {
+	setupTestDB(t)
+	log := logrus.New()
+	log.Out = io.Discard
+	type ctxKeyReplaced struct{}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/product/{id}", func(w http.ResponseWriter, r *http.Request) {
+		mux.Vars(r)["id"] = "changed-by-handler"
+		w.WriteHeader(http.StatusOK)
+	}).Methods(http.MethodGet)
+	r.HandleFunc("/cart", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("product_id") == "" {
+			t.Error("the handler didn't get the form")
+		}
+		w.WriteHeader(http.StatusFound)
+	}).Methods(http.MethodPost)
+	r.Use(
+		// Before the activity middleware, a new request with the same route
+		func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKeyReplaced{}, true)))
+			})
+		},
+		func(next http.Handler) http.Handler { return NewActivityMiddleware(log, next) },
+		// After it, a clone sharing the body, which the handler reads
+		func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.Clone(r.Context()))
+			})
+		},
+	)
+
+	serve(r, httptest.NewRequest(http.MethodGet, "/product/OLJCESPC7Z", nil), "session-1")
+	a := lastActivity(t)
+	if a.ActivityType != ActivityTypeProductView || a.RouteTemplate != "/product/{id}" || a.ProductID != "OLJCESPC7Z" {
+		t.Errorf("product view logged as %s %q of %q, want the product requested", a.ActivityType, a.RouteTemplate, a.ProductID)
+	}
+
+	serve(r, postForm("/cart", "product_id=OLJCESPC7Z&quantity=2"), "session-1")
+	a = lastActivity(t)
+	if details := detailsOf(t, a); a.ActivityType != ActivityTypeAddToCart || details["product_id"] != "OLJCESPC7Z" || details["quantity"] != "2" {
+		t.Errorf("cart add logged as %s with %v, want the product and quantity posted", a.ActivityType, details)
+	}
+}
+
+func TestResponseRecorderDoesNotAllocateForSuccess(t *testing.T) {
+	body := []byte("<html>ok</html>")
+	allocs := testing.AllocsPerRun(100, func() {
+		rr := responseRecorder{w: discardResponseWriter{}}
+		rr.WriteHeader(http.StatusOK)
+		rr.Write(body)
+	})
+	if allocs != 0 {
+		t.Errorf("recording a successful response allocated %v times, want 0", allocs)
+	}
+}
+
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Header() http.Header         { return nil }
+func (discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardResponseWriter) WriteHeader(int)             {}
+
+func TestEncodeDetailsAsJSONMarshal(t *testing.T) {
+	for _, details := range []map[string]interface{}{
+		{"product_id": "OLJCESPC7Z", "quantity": 2.0, "quantity_int": 2, "suspicious": false},
+		{"tags": map[string]string{"tier": "<gold> &  ", "b": "\"quoted\"\n"}, "a": "\x01"},
+		{"total": 12.5, "tiny": 1e-7, "huge": 1e21, "negative": -3.25},
+		{"items": []string{"a"}, "nested": map[string]interface{}{"x": 1}},
+		{"invalid_utf8": "\xff"},
+	} {
+		want, err := json.Marshal(details)
+		if err != nil {
+			t.Fatalf("json.Marshal(%v) failed: %v", details, err)
+		}
+		var a ActivityLog
+		encodeDetails(&a, details)
+		if a.Details != string(want) {
+			t.Errorf("encodeDetails(%v) = %s, want %s", details, a.Details, want)
+		}
+	}
+}
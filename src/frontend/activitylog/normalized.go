@@ -0,0 +1,157 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"time"
+)
+
+// Ratio is a normalized metric along with the counts it was computed from,
+// so that consumers can recompute it or add up several periods. Value is
+// Numerator over Denominator, scaled, or 0 when Denominator is 0.
+type Ratio struct {
+	Numerator   int     `json:"numerator"`
+	Denominator int     `json:"denominator"`
+	Value       float64 `json:"value"`
+}
+
+func newRatio(numerator, denominator int, scale float64) Ratio {
+	r := Ratio{Numerator: numerator, Denominator: denominator}
+	if denominator > 0 {
+		r.Value = float64(numerator) / float64(denominator) * scale
+	}
+	return r
+}
+
+// NormalizedStats are metrics of a time period relative to its traffic, so
+// that periods with more or fewer visitors can be compared
+type NormalizedStats struct {
+	Sessions             int   `json:"sessions"`
+	ActivitiesPerSession Ratio `json:"activities_per_session"`
+	// SessionPercent is the percentage of sessions with at least one
+	// activity of each type
+	SessionPercent map[string]Ratio `json:"session_percent"`
+	// CartAddsPer100Views is the number of cart adds that went through per
+	// hundred product views
+	CartAddsPer100Views Ratio `json:"cart_adds_per_100_views"`
+}
+
+// typeCounts are the activities of a type, the sessions they were logged
+// in and how many of them went through
+type typeCounts struct {
+	activities, sessions, succeeded int
+}
+
+// GetNormalizedStats returns the normalized stats of a given time period
+func GetNormalizedStats(ctx context.Context, startTime, endTime time.Time) (*NormalizedStats, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "normalized stats", time.Now())
+	return normalizedTotals(ctx, getReadDB(), startTime, endTime)
+}
+
+// normalizedTotals is GetNormalizedStats run by q. Callers hold an
+// analytical query slot.
+func normalizedTotals(ctx context.Context, q queryer, startTime, endTime time.Time) (*NormalizedStats, error) {
+	stats, err := normalizedStats(ctx, q, "''", startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := stats[""]; ok {
+		return s, nil
+	}
+	return normalize(0, nil), nil
+}
+
+// normalizedStats returns the normalized stats of a given time period per
+// value of group, an expression of the activities columns, run by q.
+// Callers hold an analytical query slot.
+func normalizedStats(ctx context.Context, q queryer, group string, startTime, endTime time.Time) (map[string]*NormalizedStats, error) {
+	sessions := map[string]int{}
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+group+` AS value, COUNT(DISTINCT session_id)
+		FROM activities
+		WHERE `+createdIn("created_at")+` AND deleted_at IS NULL
+		GROUP BY value`, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value string
+		var n int
+		if err := rows.Scan(&value, &n); err != nil {
+			return nil, err
+		}
+		sessions[value] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	types := map[string]map[string]typeCounts{}
+	rows, err = q.QueryContext(ctx, `
+		SELECT `+group+` AS value, activity_type, COUNT(*), COUNT(DISTINCT session_id),
+			   COALESCE(SUM(NOT `+failedAttempt+`), 0)
+		FROM activities
+		WHERE `+createdIn("created_at")+` AND deleted_at IS NULL
+		GROUP BY value, activity_type`, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value, activityType string
+		var c typeCounts
+		if err := rows.Scan(&value, &activityType, &c.activities, &c.sessions, &c.succeeded); err != nil {
+			return nil, err
+		}
+		if types[value] == nil {
+			types[value] = map[string]typeCounts{}
+		}
+		types[value][activityType] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]*NormalizedStats, len(sessions))
+	for value, n := range sessions {
+		stats[value] = normalize(n, types[value])
+	}
+	return stats, nil
+}
+
+// normalize computes the normalized stats of sessions sessions, whose
+// activities are counted per type
+func normalize(sessions int, types map[string]typeCounts) *NormalizedStats {
+	var activities int
+	percent := make(map[string]Ratio, len(types))
+	for activityType, c := range types {
+		activities += c.activities
+		percent[activityType] = newRatio(c.sessions, sessions, 100)
+	}
+	return &NormalizedStats{
+		Sessions:             sessions,
+		ActivitiesPerSession: newRatio(activities, sessions, 1),
+		SessionPercent:       percent,
+		CartAddsPer100Views: newRatio(types[ActivityTypeAddToCart].succeeded,
+			types[ActivityTypeProductView].activities, 100),
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetNormalizedStats(t *testing.T) {
+	fc := setupTestDB(t)
+	for _, a := range []ActivityLog{
+		{SessionID: "a", ActivityType: ActivityTypeProductView},
+		{SessionID: "a", ActivityType: ActivityTypeProductView},
+		{SessionID: "a", ActivityType: ActivityTypeAddToCart},
+		{SessionID: "b", ActivityType: ActivityTypeProductView},
+		{SessionID: "b", ActivityType: ActivityTypeAddToCart, Details: `{"result":"failed"}`},
+		{SessionID: "c", ActivityType: ActivityTypeProductView},
+		{SessionID: "d", ActivityType: ActivityTypePageView},
+	} {
+		mustLog(t, &a)
+	}
+	// Outside the period
+	mustLog(t, &ActivityLog{SessionID: "e", ActivityType: ActivityTypeAddToCart, CreatedAt: fc.Now().Add(-2 * time.Hour)})
+
+	got, err := GetNormalizedStats(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetNormalizedStats() failed: %v", err)
+	}
+	want := &NormalizedStats{
+		Sessions:             4,
+		ActivitiesPerSession: Ratio{Numerator: 7, Denominator: 4, Value: 1.75},
+		SessionPercent: map[string]Ratio{
+			ActivityTypeProductView: {Numerator: 3, Denominator: 4, Value: 75},
+			ActivityTypeAddToCart:   {Numerator: 2, Denominator: 4, Value: 50},
+			ActivityTypePageView:    {Numerator: 1, Denominator: 4, Value: 25},
+		},
+		// The failed cart add doesn't count
+		CartAddsPer100Views: Ratio{Numerator: 1, Denominator: 4, Value: 25},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetNormalizedStats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetNormalizedStatsOfAnEmptyPeriod(t *testing.T) {
+	fc := setupTestDB(t)
+	got, err := GetNormalizedStats(context.Background(), fc.Now().Add(-time.Hour), fc.Now())
+	if err != nil {
+		t.Fatalf("GetNormalizedStats() failed: %v", err)
+	}
+	want := &NormalizedStats{SessionPercent: map[string]Ratio{}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetNormalizedStats() = %+v, want %+v", got, want)
+	}
+	// No NaN or Inf, which JSON can't encode
+	if _, err := json.Marshal(got); err != nil {
+		t.Errorf("encoding the stats of an empty period failed: %v", err)
+	}
+}
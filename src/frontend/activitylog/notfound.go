@@ -0,0 +1,62 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"time"
+)
+
+// NotFoundPath is a path that was requested but isn't served
+type NotFoundPath struct {
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+}
+
+// GetTopNotFoundPaths returns the paths most often requested without
+// being found in a given time period, to spot broken links. Paths are
+// reported as sent, before unescaping.
+func GetTopNotFoundPaths(ctx context.Context, startTime, endTime time.Time, limit int) ([]NotFoundPath, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "top not found paths", time.Now())
+
+	query := `
+		SELECT COALESCE(json_extract(` + detailsJSON + `, '$.raw_path'), path) AS raw_path, COUNT(*) AS count
+		FROM activities
+		WHERE activity_type = ? AND ` + createdIn("created_at") + ` AND deleted_at IS NULL
+		GROUP BY raw_path
+		ORDER BY count DESC, raw_path
+		LIMIT ?`
+
+	rows, err := getReadDB().QueryContext(ctx, query, ActivityTypeNotFound, startTime.UTC(), endTime.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := []NotFoundPath{}
+	for rows.Next() {
+		var p NotFoundPath
+		if err := rows.Scan(&p.Path, &p.Count); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, rows.Err()
+}
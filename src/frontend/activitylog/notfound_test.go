@@ -0,0 +1,135 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMiddlewareRecordsNotFound(t *testing.T) {
+	fc := setupTestDB(t)
+	router := newTestRouter()
+
+	for _, path := range []string{"/no/such%20page", "/no/such%20page", "/old-link"} {
+		if w := serve(router, httptest.NewRequest(http.MethodGet, path, nil), "session-1"); w.Code != http.StatusNotFound {
+			t.Fatalf("GET %s: status = %d, want 404", path, w.Code)
+		}
+	}
+	got := lastActivity(t)
+	if got.ActivityType != ActivityTypeNotFound || got.StatusCode != http.StatusNotFound || got.SessionID != "session-1" {
+		t.Errorf("logged %+v, want a not_found activity", got)
+	}
+	if details := detailsOf(t, got); details["raw_path"] != "/old-link" {
+		t.Errorf("details = %v, want the raw path", details)
+	}
+
+	// Paths of the served routes aren't not_found, even with an error status
+	serve(router, httptest.NewRequest(http.MethodGet, "/product/MISSING", nil), "session-1")
+	if got := lastActivity(t); got.ActivityType != ActivityTypeProductView {
+		t.Errorf("routed request logged as %q", got.ActivityType)
+	}
+
+	paths, err := GetTopNotFoundPaths(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("GetTopNotFoundPaths() failed: %v", err)
+	}
+	want := []NotFoundPath{{Path: "/no/such%20page", Count: 2}, {Path: "/old-link", Count: 1}}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("GetTopNotFoundPaths() = %+v, want %+v", paths, want)
+	}
+}
+
+func TestMiddlewareCapsNotFoundPath(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	path := "/" + strings.Repeat("a", 2*maxPath)
+	serve(router, httptest.NewRequest(http.MethodGet, path, nil), "session-1")
+	got := lastActivity(t)
+	if len(got.Path) != maxPath {
+		t.Errorf("path is %d bytes long, want %d", len(got.Path), maxPath)
+	}
+	if raw, _ := detailsOf(t, got)["raw_path"].(string); len(raw) != maxPath {
+		t.Errorf("raw_path is %d bytes long, want %d", len(raw), maxPath)
+	}
+}
+
+func TestMiddlewareNormalizesPaths(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	long := "/" + strings.Repeat("é", maxPath)
+	tests := []struct {
+		name, target, wantPath, wantRaw string
+	}{
+		{"routed", "/product/OLJCESPC7Z", "/product/{id}", ""},
+		{"mixed case", "/Old-LINK", "/old-link", "/Old-LINK"},
+		{"unicode", "/Caf%C3%A9/%C3%9CBER", "/café/über", "/Caf%C3%A9/%C3%9CBER"},
+		{"newlines", "/a%0Ab%0D%0Ac", "/abc", "/a%0Ab%0D%0Ac"},
+		{"invisible", "/a%E2%80%8Bb%00", "/ab", "/a%E2%80%8Bb%00"},
+		{"invalid utf-8", "/a%FFb", "/ab", "/a%FFb"},
+		// Cut before the character that would go over the cap
+		{"very long", long, "/" + strings.Repeat("é", (maxPath-1)/2), ("/" + strings.Repeat("%C3%A9", maxPath))[:maxPath]},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			serve(router, httptest.NewRequest(http.MethodGet, tc.target, nil), "session-1")
+			got := lastActivity(t)
+			if got.Path != tc.wantPath {
+				t.Errorf("path = %q, want %q", got.Path, tc.wantPath)
+			}
+			if len(got.Path) > maxPath || !utf8.ValidString(got.Path) || strings.ContainsAny(got.Path, "\r\n") {
+				t.Errorf("path %q isn't safe to store", got.Path)
+			}
+			raw, _ := detailsOf(t, got)["raw_path"].(string)
+			if raw != tc.wantRaw {
+				t.Errorf("raw_path = %q, want %q", raw, tc.wantRaw)
+			}
+			if strings.ContainsAny(raw, "\r\n") || len(raw) > maxPath {
+				t.Errorf("raw_path %q isn't safe to store", raw)
+			}
+		})
+	}
+}
+
+func TestMiddlewareOutsideRouter(t *testing.T) {
+	setupTestDB(t)
+	log := logrus.New()
+	log.Out = io.Discard
+	r := mux.NewRouter()
+	r.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := NewActivityMiddleware(log, r)
+
+	// Without the session and request IDs of the outer handlers either
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+	if got := lastActivity(t); got.ActivityType != ActivityTypeNotFound || got.Path != "/missing" {
+		t.Errorf("logged %+v, want a not_found activity", got)
+	}
+}
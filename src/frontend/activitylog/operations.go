@@ -15,98 +15,732 @@
 package activitylog
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"sort"
 	"time"
 )
 
-// Common activity types
+// Activity types. Every type the middleware logs is registered, so that it
+// shows up in ActivityTypes.
+var (
+	ActivityTypePageView         = RegisterActivityType("page_view", "A view of the home page")
+	ActivityTypeProductView      = RegisterActivityType("product_view", "A view of a product page")
+	ActivityTypeAddToCart        = RegisterActivityType("add_to_cart", "A product added to the cart")
+	ActivityTypeViewCart         = RegisterActivityType("view_cart", "A view of the cart page")
+	ActivityTypeEmptyCart        = RegisterActivityType("empty_cart", "The cart emptied")
+	ActivityTypeCheckout         = RegisterActivityType("checkout", "An order placed, or attempted when details.failed is set")
+	ActivityTypeCurrencyChange   = RegisterActivityType("currency_change", "A switch to another display currency")
+	ActivityTypeAssistantMessage = RegisterActivityType("assistant_message", "A message sent to the shopping assistant")
+	ActivityTypeNotFound         = RegisterActivityType("not_found", "A request for a path no route serves")
+	ActivityTypeRenderError      = RegisterActivityType("render_error", "A page whose template failed to render")
+	ActivityTypeOther            = RegisterActivityType("other", "A request to any other route")
+	ActivityTypeUnknown          = RegisterActivityType("unknown", "A request served without a matched route")
+)
+
+// Activity sources
 const (
-	ActivityTypePageView      = "page_view"
-	ActivityTypeAddToCart     = "add_to_cart"
-	ActivityTypeEmptyCart     = "empty_cart"
-	ActivityTypeCheckout      = "checkout"
-	ActivityTypeCurrencyChange = "currency_change"
-	ActivityTypeProductView   = "product_view"
+	SourceWeb           = "web"
+	SourceLoadGenerator = "loadgenerator"
+	// SourceClient is events reported by browsers to IngestPath rather
+	// than observed by the frontend. Nothing vouches for them.
+	SourceClient = "client"
 )
 
-// LogActivity records a new activity in the database
+// MaxActivities is the most activities the slice-returning queries return.
+// Larger limits are lowered to it; use QueryStream to go through more.
+const MaxActivities = 1000
+
+// activityColumns is the column list scanned by scanActivity
+const activityColumns = `id, session_id, COALESCE(user_id, ''), request_id, COALESCE(parent_request_id, ''), activity_type, path, method,
+			   status_code, user_currency, COALESCE(source, ''), COALESCE(utm_campaign, ''),
+			   COALESCE(product_id, ''), COALESCE(version, ''), COALESCE(revision, ''),
+			   COALESCE(referrer_type, ''), COALESCE(referrer_domain, ''), COALESCE(route_template, ''),
+			   COALESCE(latency_ms, 0), details, created_at, COALESCE(flags_hash, ''),
+			   COALESCE(response_class, ''), COALESCE(is_entry, 0), COALESCE(device_id, '')`
+
+// LogActivity records a new activity in the database. The activity keeps its
+// CreatedAt when one is set, otherwise it is stamped with the store's clock.
+// While the database keeps failing writes, activities are dropped with
+// ErrCircuitOpen instead, and while the activity log is read-only with
+// ErrReadOnly.
 func LogActivity(activity *ActivityLog) error {
+	return LogActivityContext(context.Background(), activity)
+}
+
+// LogActivityContext is LogActivity on behalf of the request ctx belongs to,
+// which slow writes are logged with. The write isn't canceled with ctx, so
+// the activities of abandoned requests are still recorded.
+func LogActivityContext(ctx context.Context, activity *ActivityLog) error {
+	createdAt, err := insertActivity(ctx, activity, true)
+	if err != nil {
+		return err
+	}
+	published := *activity
+	published.CreatedAt = createdAt
+	publish(published)
+	return nil
+}
+
+// insertActivity writes activity without publishing it, sets its ID and
+// returns the time it was recorded at. Only outboxed activities are queued
+// for the outbox sinks; the others are queued once complete.
+func insertActivity(ctx context.Context, activity *ActivityLog, outboxed bool) (time.Time, error) {
+	createdAt := activity.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = Now()
+	}
+	// Times compare as text, which only works when they share a zone
+	createdAt = createdAt.UTC()
+	// Activities logged outside the middleware only have their status
+	if activity.ResponseClass == "" {
+		activity.ResponseClass = ResponseClassOf(activity.StatusCode, "")
+	}
+
+	if err := ensureDB(); err != nil {
+		return createdAt, err
+	}
+	if GetDB() == nil {
+		return createdAt, ErrNotInitialized
+	}
+	if err := validateDetails(activity.ActivityType, activity.Details); err != nil {
+		return createdAt, err
+	}
+	if ReadOnly() {
+		readOnlySkipped.Add(1)
+		return createdAt, ErrReadOnly
+	}
+	if err := allowWrite(); err != nil {
+		return createdAt, err
+	}
+	if over, err := overSessionQuota(ctx, activity); err != nil {
+		return createdAt, err
+	} else if over {
+		return createdAt, suppressActivity(ctx, activity, createdAt)
+	}
+
+	// The pipeline starts before waiting for checkpoints to let the write
+	// through, the query only once it is let through
+	enqueued := time.Now()
+	done := beginWrite()
+	start := time.Now()
+	id, err := insertRows(ctx, activity, createdAt, outboxed)
+	done()
+	observeQuery(ctx, "insert activity", start)
+	recordWrite(err)
+	noteWrite(err)
+	if err != nil {
+		return createdAt, err
+	}
+	now := time.Now()
+	pipelineLatency.observe(now.Sub(enqueued), now)
+	detailsSizes.observe(len(activity.Details))
+	countSessionRow(activity.SessionID)
+	activity.ID = id
+	return createdAt, nil
+}
+
+// insertRows inserts the activity and its line items in one transaction,
+// queued in the outbox too when outboxed, and returns the activity's ID
+func insertRows(ctx context.Context, activity *ActivityLog, createdAt time.Time, outboxed bool) (int64, error) {
+	table, err := partitionFor(ctx, createdAt)
+	if err != nil {
+		return 0, err
+	}
 	query := `
-		INSERT INTO activities (
-			session_id, request_id, activity_type, path, method, 
-			status_code, user_currency, details, created_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		INSERT INTO ` + table + ` (
+			id, session_id, user_id, request_id, parent_request_id, activity_type, path, method,
+			status_code, user_currency, source, utm_campaign, product_id, version, revision,
+			referrer_type, referrer_domain, route_template, latency_ms, details, created_at, flags_hash,
+			response_class, is_entry, device_id
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err := GetDB().Exec(
+	tx, err := GetDB().Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// The activities table numbers its rows itself, partitions share a
+	// sequence
+	var id sql.NullInt64
+	if table != "activities" {
+		if id.Int64, err = nextActivityID(tx); err != nil {
+			return 0, err
+		}
+		id.Valid = true
+	}
+	details, err := encodeStoredDetails(activity.ActivityType, activity.Details)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(
 		query,
+		id,
 		activity.SessionID,
+		sql.NullString{String: activity.UserID, Valid: activity.UserID != ""},
 		activity.RequestID,
+		sql.NullString{String: activity.ParentRequestID, Valid: activity.ParentRequestID != ""},
 		activity.ActivityType,
 		activity.Path,
 		activity.Method,
 		activity.StatusCode,
 		activity.UserCurrency,
-		activity.Details,
-		time.Now(),
+		activity.Source,
+		sql.NullString{String: activity.Campaign, Valid: activity.Campaign != ""},
+		sql.NullString{String: activity.ProductID, Valid: activity.ProductID != ""},
+		sql.NullString{String: activity.Version, Valid: activity.Version != ""},
+		sql.NullString{String: activity.Revision, Valid: activity.Revision != ""},
+		sql.NullString{String: activity.ReferrerType, Valid: activity.ReferrerType != ""},
+		sql.NullString{String: activity.ReferrerDomain, Valid: activity.ReferrerDomain != ""},
+		sql.NullString{String: activity.RouteTemplate, Valid: activity.RouteTemplate != ""},
+		activity.LatencyMs,
+		details,
+		createdAt,
+		sql.NullString{String: activity.FlagsHash, Valid: activity.FlagsHash != ""},
+		sql.NullString{String: activity.ResponseClass, Valid: activity.ResponseClass != ""},
+		sql.NullInt64{Int64: 1, Valid: activity.IsEntry},
+		sql.NullString{String: activity.DeviceID, Valid: activity.DeviceID != ""},
 	)
+	if err != nil {
+		return 0, err
+	}
+	rowID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := insertItems(tx, rowID, activity.Items); err != nil {
+		return 0, err
+	}
+	if outboxed {
+		if err := queueOutbox(tx, activity, rowID, createdAt); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if outboxed {
+		wakeOutbox()
+	}
+	return rowID, nil
+}
+
+// insertItems records the line items of the activity with the given ID
+func insertItems(tx *sql.Tx, activityID int64, items []ActivityItem) error {
+	for _, item := range items {
+		if _, err := tx.Exec("INSERT INTO activity_items (activity_id, product_id, quantity) VALUES (?, ?, ?)",
+			activityID, item.ProductID, item.Quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addActivityItems records line items of an activity logged without them
+func addActivityItems(ctx context.Context, activityID int64, items []ActivityItem) error {
+	if err := allowWrite(); err != nil {
+		return err
+	}
+	done := beginWrite()
+	defer done()
+	start := time.Now()
+	defer observeQuery(ctx, "insert activity items", start)
+	tx, err := GetDB().Begin()
+	if err != nil {
+		recordWrite(err)
+		return err
+	}
+	defer tx.Rollback()
+	err = insertItems(tx, activityID, items)
+	if err == nil {
+		err = tx.Commit()
+	}
+	recordWrite(err)
 	return err
 }
 
-// GetActivitiesBySession retrieves all activities for a given session
+const activityItemsQuery = `
+	SELECT i.product_id, i.quantity
+	FROM activity_items i
+	JOIN activities a ON a.id = i.activity_id
+	WHERE i.activity_id = ? AND a.deleted_at IS NULL
+	ORDER BY i.rowid`
+
+// GetActivityItems returns the line items of the activity with the given ID
+func GetActivityItems(activityID int64) ([]ActivityItem, error) {
+	rows, err := getReadDB().Query(activityItemsQuery, activityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ActivityItem
+	for rows.Next() {
+		var item ActivityItem
+		if err := rows.Scan(&item.ProductID, &item.Quantity); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// updateStatusQuery records the response and latency of the activity with
+// a given ID in table
+func updateStatusQuery(table string) string {
+	return `UPDATE ` + table + ` SET status_code = ?, response_class = ?, latency_ms = ? WHERE id = ?`
+}
+
+// UpdateActivityStatus records the response status and latency of the
+// activity with the given ID, logged before its request was handled. It is
+// matched by ID rather than request ID, which the client events of the page
+// the request served share.
+func UpdateActivityStatus(ctx context.Context, id int64, status int, latency time.Duration) error {
+	return updateActivityResponse(ctx, id, status, ResponseClassOf(status, ""), latency)
+}
+
+// updateActivityResponse is UpdateActivityStatus with the response class
+// known
+func updateActivityResponse(ctx context.Context, id int64, status int, class string, latency time.Duration) error {
+	if err := allowWrite(); err != nil {
+		return err
+	}
+	done := beginWrite()
+	start := time.Now()
+	_, err := execEachTable(ctx, GetDB(), updateStatusQuery, status,
+		sql.NullString{String: class, Valid: class != ""}, latency.Milliseconds(), id)
+	done()
+	observeQuery(ctx, "update activity status", start)
+	recordWrite(err)
+	return err
+}
+
+// mergeActivityDetails adds details to those of the activity with the
+// given ID, replacing keys it already has
+func mergeActivityDetails(ctx context.Context, id int64, details string) error {
+	if err := allowWrite(); err != nil {
+		return err
+	}
+	done := beginWrite()
+	start := time.Now()
+	_, err := execEachTable(ctx, GetDB(), func(table string) string {
+		return `UPDATE ` + table + ` SET details = json_patch(COALESCE(` + detailsJSON + `, '{}'), ?) WHERE id = ?`
+	}, details, id)
+	done()
+	observeQuery(ctx, "merge activity details", start)
+	recordWrite(err)
+	return err
+}
+
+// listOrder orders the activities lists, latest logged first. IDs are
+// handed out by the single writer in the order it logs activities, across
+// partitions too, unlike created_at, which clock adjustments and
+// backdated activities put out of order and which activities can share.
+// Pages of a list, see Filter.BeforeID, neither skip nor repeat any.
+const listOrder = "id DESC"
+
+// GetActivitiesBySession retrieves the most recently logged activities, at
+// most MaxActivities, of a given session
 func GetActivitiesBySession(sessionID string, limit int) ([]ActivityLog, error) {
-	query := `
-		SELECT id, session_id, request_id, activity_type, path, method,
-			   status_code, user_currency, details, created_at
-		FROM activities 
-		WHERE session_id = ?
-		ORDER BY created_at DESC
-		LIMIT ?`
+	return queryActivities(sessionActivitiesQuery, sessionID, boundLimit(limit))
+}
+
+const sessionActivitiesQuery = `
+	SELECT ` + activityColumns + `
+	FROM activities
+	WHERE session_id = ? AND deleted_at IS NULL
+	ORDER BY ` + listOrder + `
+	LIMIT ?`
 
-	return queryActivities(query, sessionID, limit)
+// ProductView is a product a session viewed
+type ProductView struct {
+	ProductID string `json:"product_id"`
+	// Views is how many times the session viewed it, LastViewed when it
+	// last did
+	Views      int       `json:"views"`
+	LastViewed time.Time `json:"last_viewed"`
+}
+
+// RecentProductViews returns the products a session viewed, most recently
+// viewed first, at most limit of them
+func RecentProductViews(sessionID string, limit int) ([]ProductView, error) {
+	rows, err := getReadDB().Query(`
+		SELECT product_id, COUNT(*), MAX(created_at) AS last_viewed
+		FROM activities
+		WHERE session_id = ? AND activity_type = ? AND product_id IS NOT NULL AND deleted_at IS NULL
+		GROUP BY product_id
+		ORDER BY last_viewed DESC, product_id
+		LIMIT ?`, sessionID, ActivityTypeProductView, boundLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	views := []ProductView{}
+	for rows.Next() {
+		var v ProductView
+		var last string
+		if err := rows.Scan(&v.ProductID, &v.Views, &last); err != nil {
+			return nil, err
+		}
+		if v.LastViewed, err = parseSQLiteTime(last); err != nil {
+			return nil, err
+		}
+		views = append(views, v)
+	}
+	return views, rows.Err()
 }
 
 // GetRecentActivities retrieves recent activities across all sessions
 func GetRecentActivities(limit int) ([]ActivityLog, error) {
+	return GetActivities(Filter{}, limit)
+}
+
+// GetActivities retrieves the most recently logged activities matching the
+// filter, at most MaxActivities
+func GetActivities(filter Filter, limit int) ([]ActivityLog, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	query, args := listQuery(filter, limit)
+	return queryActivities(query, args...)
+}
+
+// listQuery selects the most recently logged activities matching the
+// filter, at most limit of them
+func listQuery(filter Filter, limit int) (string, []interface{}) {
+	where, args := filter.where()
 	query := `
-		SELECT id, session_id, request_id, activity_type, path, method,
-			   status_code, user_currency, details, created_at
-		FROM activities
-		ORDER BY created_at DESC
+		SELECT ` + activityColumns + `
+		FROM ` + filter.from() + `
+		` + where + `
+		ORDER BY ` + listOrder + `
 		LIMIT ?`
+	return query, append(args, boundLimit(limit))
+}
 
-	return queryActivities(query, limit)
+// boundLimit lowers limits above MaxActivities, and treats non-positive
+// ones as asking for as many as allowed
+func boundLimit(limit int) int {
+	if limit <= 0 || limit > MaxActivities {
+		return MaxActivities
+	}
+	return limit
 }
 
-// GetActivityStats returns activity statistics for a given time period
-func GetActivityStats(startTime, endTime time.Time) (map[string]int, error) {
+// QueryStream calls fn with every activity matching the filter, oldest
+// first, without holding more than one in memory. It stops at the first
+// error returned by fn, which it returns, or when ctx is done. The rows are
+// read while fn runs, so a slow fn keeps a read transaction open.
+func QueryStream(ctx context.Context, filter Filter, fn func(ActivityLog) error) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+	where, args := filter.where()
 	query := `
-		SELECT activity_type, COUNT(*) as count
-		FROM activities
-		WHERE created_at BETWEEN ? AND ?
+		SELECT ` + activityColumns + `
+		FROM ` + filter.from() + `
+		` + where + `
+		ORDER BY created_at, id`
+	return streamActivities(ctx, "stream activities", query, args, fn)
+}
+
+// GetActivitiesStream calls fn with the activities GetActivities would
+// return, in the same order, as they are read instead of once they all
+// are. It stops at the first error returned by fn, which it returns.
+func GetActivitiesStream(ctx context.Context, filter Filter, limit int, fn func(ActivityLog) error) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+	query, args := listQuery(filter, limit)
+	return streamActivities(ctx, "list activities", query, args, fn)
+}
+
+// streamActivities runs the query called name, selecting activityColumns,
+// and calls fn with every activity it returns
+func streamActivities(ctx context.Context, name, query string, args []interface{}, fn func(ActivityLog) error) error {
+	start := time.Now()
+	rows, err := getReadDB().QueryContext(ctx, query, args...)
+	observeQuery(ctx, name, start)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var activity ActivityLog
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := scanActivity(rows, &activity); err != nil {
+			return err
+		}
+		if err := fn(activity); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// GetActivityStats returns the number of activities per type matching the
+// filter, typically a time period. Rolled up days are counted from the
+// roll-ups, the rest from raw activities.
+func GetActivityStats(ctx context.Context, filter Filter) (map[string]int, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "activity stats", time.Now())
+	return activityStats(ctx, getReadDB(), filter)
+}
+
+// activityStats is GetActivityStats run by q. Callers hold an analytical
+// query slot.
+func activityStats(ctx context.Context, q queryer, filter Filter) (map[string]int, error) {
+	rolled, err := rolledUpDays(ctx, q, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]int)
+	add := func(query string, args []interface{}) error {
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var activityType string
+			var count int
+			if err := rows.Scan(&activityType, &count); err != nil {
+				return err
+			}
+			stats[activityType] += count
+		}
+		return rows.Err()
+	}
+
+	if len(rolled) > 0 {
+		if err := add(rollupStatsQuery(filter, rolled)); err != nil {
+			return nil, err
+		}
+	}
+	if err := add(statsQuery(filter, rolled)); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// rollupStatsQuery counts the activities per type matching the filter in
+// the rolled up days
+func rollupStatsQuery(filter Filter, rolled []dayRange) (string, []interface{}) {
+	where, args := rollupWhere(filter, rolled)
+	query := `
+		SELECT activity_type, SUM(count)
+		FROM activity_rollups
+		` + where + `
 		GROUP BY activity_type`
+	return query, args
+}
 
-	rows, err := GetDB().Query(query, startTime, endTime)
+// statsQuery counts the activities per type matching the filter outside
+// the rolled up days
+func statsQuery(filter Filter, rolled []dayRange) (string, []interface{}) {
+	where, args := filter.where()
+	where, args = excludeDays(where, args, rolled)
+	query := `
+		SELECT activity_type, SUM(` + storedCount + `) as count
+		FROM ` + filter.from() + `
+		` + where + `
+		GROUP BY activity_type`
+	return query, args
+}
+
+// TimeSeriesPoint is the number of activities per type in one bucket of a
+// time series
+type TimeSeriesPoint struct {
+	Start  time.Time      `json:"start"`
+	Counts map[string]int `json:"counts"`
+}
+
+// GetActivityTimeSeries returns the number of activities per type matching
+// the filter in consecutive buckets of the given size, aligned to the Unix
+// epoch. Buckets without activities are left out. Daily buckets are served
+// from the roll-ups where possible.
+func GetActivityTimeSeries(ctx context.Context, filter Filter, bucket time.Duration) ([]TimeSeriesPoint, error) {
+	return GetActivityTimeSeriesIn(ctx, filter, bucket, time.UTC)
+}
+
+// GetActivityTimeSeriesIn is GetActivityTimeSeries with the buckets aligned
+// on the wall clock of loc instead of UTC, so that daily buckets start at
+// local midnight, even when daylight saving time makes them 23 or 25 hours
+// long. Bucket starts are returned in loc. Outside UTC the filter needs both
+// a start and an end.
+func GetActivityTimeSeriesIn(ctx context.Context, filter Filter, bucket time.Duration, loc *time.Location) ([]TimeSeriesPoint, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	if bucket < time.Minute || bucket%time.Second != 0 {
+		return nil, errors.New("bucket must be a whole number of seconds, at least a minute")
+	}
+	if loc != time.UTC && (filter.Start.IsZero() || filter.End.IsZero()) {
+		return nil, errors.New("a time series outside UTC needs a start and an end")
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "activity time series", time.Now())
+
+	// The roll-ups are daily in UTC
+	var rolled []dayRange
+	if bucket == day && loc == time.UTC {
+		if rolled, err = rolledUpDays(ctx, getReadDB(), filter); err != nil {
+			return nil, err
+		}
+	}
+
+	points := make(map[int64]map[string]int)
+	add := func(query string, args ...interface{}) error {
+		rows, err := getReadDB().QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var start int64
+			var activityType string
+			var count int
+			if err := rows.Scan(&start, &activityType, &count); err != nil {
+				return err
+			}
+			if points[start] == nil {
+				points[start] = make(map[string]int)
+			}
+			points[start][activityType] += count
+		}
+		return rows.Err()
+	}
+
+	if len(rolled) > 0 {
+		where, args := rollupWhere(filter, rolled)
+		query := `
+			SELECT CAST(strftime('%s', date) AS INTEGER), activity_type, SUM(count)
+			FROM activity_rollups
+			` + where + `
+			GROUP BY date, activity_type`
+		if err := add(query, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	seconds := int64(bucket / time.Second)
+	local, localArgs := localSeconds("created_at", loc, filter.Start, filter.End)
+	where, args := filter.where()
+	where, args = excludeDays(where, args, rolled)
+	query := `
+		SELECT ((` + local + `) / ?) * ? AS bucket,
+			   activity_type, SUM(` + storedCount + `)
+		FROM ` + filter.from() + `
+		` + where + `
+		GROUP BY bucket, activity_type`
+	args = append(append(localArgs, seconds, seconds), args...)
+	if err := add(query, args...); err != nil {
+		return nil, err
+	}
+
+	series := make([]TimeSeriesPoint, 0, len(points))
+	for start, counts := range points {
+		series = append(series, TimeSeriesPoint{Start: fromWallSeconds(start, loc), Counts: counts})
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Start.Before(series[j].Start)
+	})
+	return series, nil
+}
+
+// CurrencyCount is the number of times a currency was selected.
+type CurrencyCount struct {
+	Currency string `json:"currency"`
+	Count    int    `json:"count"`
+}
+
+// CurrencyTransitions summarizes currency changes over a time period
+type CurrencyTransitions struct {
+	// Transitions maps the previous currency to the newly selected currency
+	// and the number of changes between them. Sessions that were still on
+	// the default currency have an empty previous currency.
+	Transitions map[string]map[string]int `json:"transitions"`
+	// MostSelected lists the selected currencies, most popular first.
+	MostSelected []CurrencyCount `json:"most_selected"`
+}
+
+// GetCurrencyTransitions returns the from→to currency change counts and the
+// most selected currencies for a given time period
+func GetCurrencyTransitions(ctx context.Context, startTime, endTime time.Time) (*CurrencyTransitions, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "currency transitions", time.Now())
+
+	query := `
+		SELECT json_extract(` + detailsJSON + `, '$.previous_currency') AS from_currency,
+			   json_extract(` + detailsJSON + `, '$.new_currency') AS to_currency,
+			   COUNT(*) as count
+		FROM activities
+		WHERE activity_type = ? AND ` + createdIn("created_at") + ` AND deleted_at IS NULL
+		GROUP BY from_currency, to_currency`
+
+	rows, err := getReadDB().QueryContext(ctx, query, ActivityTypeCurrencyChange, startTime.UTC(), endTime.UTC())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	stats := make(map[string]int)
+	result := &CurrencyTransitions{Transitions: make(map[string]map[string]int)}
+	selected := make(map[string]int)
 	for rows.Next() {
-		var activityType string
+		var from, to sql.NullString
 		var count int
-		if err := rows.Scan(&activityType, &count); err != nil {
+		if err := rows.Scan(&from, &to, &count); err != nil {
 			return nil, err
 		}
-		stats[activityType] = count
+		if result.Transitions[from.String] == nil {
+			result.Transitions[from.String] = make(map[string]int)
+		}
+		result.Transitions[from.String][to.String] += count
+		selected[to.String] += count
 	}
-	return stats, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result.MostSelected = make([]CurrencyCount, 0, len(selected))
+	for currency, count := range selected {
+		result.MostSelected = append(result.MostSelected, CurrencyCount{Currency: currency, Count: count})
+	}
+	sort.Slice(result.MostSelected, func(i, j int) bool {
+		if result.MostSelected[i].Count != result.MostSelected[j].Count {
+			return result.MostSelected[i].Count > result.MostSelected[j].Count
+		}
+		return result.MostSelected[i].Currency < result.MostSelected[j].Currency
+	})
+	return result, nil
 }
 
 // Helper function to query and scan activities
 func queryActivities(query string, args ...interface{}) ([]ActivityLog, error) {
-	rows, err := GetDB().Query(query, args...)
+	rows, err := getReadDB().Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -115,22 +749,184 @@ func queryActivities(query string, args ...interface{}) ([]ActivityLog, error) {
 	var activities []ActivityLog
 	for rows.Next() {
 		var activity ActivityLog
-		err := rows.Scan(
-			&activity.ID,
-			&activity.SessionID,
-			&activity.RequestID,
-			&activity.ActivityType,
-			&activity.Path,
-			&activity.Method,
-			&activity.StatusCode,
-			&activity.UserCurrency,
-			&activity.Details,
-			&activity.CreatedAt,
-		)
-		if err != nil {
+		if err := scanActivity(rows, &activity); err != nil {
 			return nil, err
 		}
 		activities = append(activities, activity)
 	}
 	return activities, rows.Err()
-}
\ No newline at end of file
+}
+
+// scanActivity reads a row selected with activityColumns into activity
+func scanActivity(rows *sql.Rows, activity *ActivityLog) error {
+	var details []byte
+	err := rows.Scan(
+		&activity.ID,
+		&activity.SessionID,
+		&activity.UserID,
+		&activity.RequestID,
+		&activity.ParentRequestID,
+		&activity.ActivityType,
+		&activity.Path,
+		&activity.Method,
+		&activity.StatusCode,
+		&activity.UserCurrency,
+		&activity.Source,
+		&activity.Campaign,
+		&activity.ProductID,
+		&activity.Version,
+		&activity.Revision,
+		&activity.ReferrerType,
+		&activity.ReferrerDomain,
+		&activity.RouteTemplate,
+		&activity.LatencyMs,
+		&details,
+		&activity.CreatedAt,
+		&activity.FlagsHash,
+		&activity.ResponseClass,
+		&activity.IsEntry,
+		&activity.DeviceID,
+	)
+	if err != nil {
+		return err
+	}
+	activity.Details, err = decodeStoredDetails(details)
+	return err
+}
+
+// CountryCheckouts summarizes the checkouts shipping to one country
+type CountryCheckouts struct {
+	Country string `json:"country"`
+	// Orders is the number of successful checkouts
+	Orders int `json:"orders"`
+	// Failed is the number of checkouts that failed validation or could
+	// not be placed
+	Failed int `json:"failed"`
+	// ShippingCost sums the shipping cost of the orders per currency, since
+	// orders are quoted in the shopper's currency
+	ShippingCost map[string]float64 `json:"shipping_cost"`
+}
+
+// GetCheckoutsByCountry returns the checkouts per destination country for a
+// given time period, most orders first. Checkouts without a country are
+// left out.
+func GetCheckoutsByCountry(ctx context.Context, startTime, endTime time.Time) ([]CountryCheckouts, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "checkouts by country", time.Now())
+
+	query := `
+		SELECT json_extract(` + detailsJSON + `, '$.country') AS country,
+			   COALESCE(json_extract(` + detailsJSON + `, '$.failed'), 0) AS failed,
+			   COALESCE(json_extract(` + detailsJSON + `, '$.shipping_currency'), '') AS currency,
+			   COUNT(*),
+			   COALESCE(SUM(json_extract(` + detailsJSON + `, '$.shipping_cost')), 0)
+		FROM activities
+		WHERE activity_type = ? AND ` + createdIn("created_at") + ` AND country IS NOT NULL AND deleted_at IS NULL
+		GROUP BY country, failed, currency`
+
+	rows, err := getReadDB().QueryContext(ctx, query, ActivityTypeCheckout, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byCountry := make(map[string]*CountryCheckouts)
+	for rows.Next() {
+		var country, currency string
+		var failed bool
+		var count int
+		var shippingCost float64
+		if err := rows.Scan(&country, &failed, &currency, &count, &shippingCost); err != nil {
+			return nil, err
+		}
+		c, ok := byCountry[country]
+		if !ok {
+			c = &CountryCheckouts{Country: country, ShippingCost: make(map[string]float64)}
+			byCountry[country] = c
+		}
+		if failed {
+			c.Failed += count
+			continue
+		}
+		c.Orders += count
+		if currency != "" {
+			c.ShippingCost[currency] += shippingCost
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]CountryCheckouts, 0, len(byCountry))
+	for _, c := range byCountry {
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Orders != result[j].Orders {
+			return result[i].Orders > result[j].Orders
+		}
+		return result[i].Country < result[j].Country
+	})
+	return result, nil
+}
+
+// actionTypes are the activity types triggered from a page rather than
+// page views themselves
+var actionTypes = []string{
+	ActivityTypeAddToCart,
+	ActivityTypeEmptyCart,
+	ActivityTypeCheckout,
+	ActivityTypeCurrencyChange,
+}
+
+// OriginDirect is the origin of actions whose parent page is unknown
+const OriginDirect = "direct"
+
+// GetActionAttribution returns, for each action type, how many of the
+// actions in a given time period were taken from each type of page. Actions
+// without a known parent count as OriginDirect.
+func GetActionAttribution(ctx context.Context, startTime, endTime time.Time) (map[string]map[string]int, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "action attribution", time.Now())
+
+	query := `
+		SELECT a.activity_type, COALESCE(p.activity_type, ?) AS origin, COUNT(*)
+		FROM activities a
+		LEFT JOIN activities p ON p.request_id = a.parent_request_id AND p.deleted_at IS NULL
+		WHERE a.activity_type IN (` + placeholders(len(actionTypes)) + `)
+		  AND ` + createdIn("a.created_at") + ` AND a.deleted_at IS NULL
+		GROUP BY a.activity_type, origin`
+
+	args := []interface{}{OriginDirect}
+	for _, t := range actionTypes {
+		args = append(args, t)
+	}
+	args = append(args, startTime.UTC(), endTime.UTC())
+	rows, err := getReadDB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attribution := make(map[string]map[string]int)
+	for rows.Next() {
+		var action, origin string
+		var count int
+		if err := rows.Scan(&action, &origin, &count); err != nil {
+			return nil, err
+		}
+		if attribution[action] == nil {
+			attribution[action] = make(map[string]int)
+		}
+		attribution[action][origin] += count
+	}
+	return attribution, rows.Err()
+}
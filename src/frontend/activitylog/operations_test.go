@@ -0,0 +1,442 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func mustLog(t *testing.T, a *ActivityLog) {
+	t.Helper()
+	if a.SessionID == "" {
+		a.SessionID = "session-1"
+	}
+	if a.RequestID == "" {
+		a.RequestID = "request-1"
+	}
+	if a.Path == "" {
+		a.Path = "/"
+	}
+	if a.Method == "" {
+		a.Method = "GET"
+	}
+	if err := LogActivity(a); err != nil {
+		t.Fatalf("LogActivity() failed: %v", err)
+	}
+}
+
+func TestLogActivityUsesClock(t *testing.T) {
+	fc := setupTestDB(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+
+	got, err := GetRecentActivities(1)
+	if err != nil {
+		t.Fatalf("GetRecentActivities() failed: %v", err)
+	}
+	if len(got) != 1 || !got[0].CreatedAt.Equal(fc.Now()) {
+		t.Errorf("CreatedAt = %v, want %v", got, fc.Now())
+	}
+}
+
+func TestLogActivityKeepsCreatedAt(t *testing.T) {
+	fc := setupTestDB(t)
+	backdated := fc.Now().Add(-72 * time.Hour)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, CreatedAt: backdated})
+
+	got, err := GetRecentActivities(1)
+	if err != nil {
+		t.Fatalf("GetRecentActivities() failed: %v", err)
+	}
+	if len(got) != 1 || !got[0].CreatedAt.Equal(backdated) {
+		t.Errorf("CreatedAt = %v, want %v", got, backdated)
+	}
+}
+
+func TestGetActivityStatsTimeWindow(t *testing.T) {
+	fc := setupTestDB(t)
+	start := fc.Now()
+
+	// One activity per hour: page views for the first three hours, then
+	// two checkouts.
+	for _, typ := range []string{
+		ActivityTypePageView, ActivityTypePageView, ActivityTypePageView,
+		ActivityTypeCheckout, ActivityTypeCheckout,
+	} {
+		mustLog(t, &ActivityLog{ActivityType: typ})
+		fc.Advance(time.Hour)
+	}
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       map[string]int
+	}{
+		{"everything", start, fc.Now(), map[string]int{ActivityTypePageView: 3, ActivityTypeCheckout: 2}},
+		{"first two hours", start, start.Add(90 * time.Minute), map[string]int{ActivityTypePageView: 2}},
+		{"last two hours", start.Add(150 * time.Minute), fc.Now(), map[string]int{ActivityTypeCheckout: 2}},
+		{"before any activity", start.Add(-2 * time.Hour), start.Add(-time.Hour), map[string]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetActivityStats(context.Background(), Filter{Start: tt.start, End: tt.end})
+			if err != nil {
+				t.Fatalf("GetActivityStats() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetActivityStats() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetActivityStatsExperimentFilter(t *testing.T) {
+	fc := setupTestDB(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Details: `{"experiments":{"home_personalization":"treatment"}}`})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout, Details: `{"experiments":{"home_personalization":"treatment"}}`})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Details: `{"experiments":{"home_personalization":"control"}}`})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+
+	tests := []struct {
+		name                string
+		experiment, variant string
+		want                map[string]int
+	}{
+		{"treatment", "home_personalization", "treatment", map[string]int{ActivityTypePageView: 1, ActivityTypeCheckout: 1}},
+		{"control", "home_personalization", "control", map[string]int{ActivityTypePageView: 1}},
+		{"any variant", "home_personalization", "", map[string]int{ActivityTypePageView: 2, ActivityTypeCheckout: 1}},
+		{"unknown experiment", "rec_above_fold", "treatment", map[string]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetActivityStats(context.Background(), Filter{
+				Start:      fc.Now().Add(-time.Hour),
+				End:        fc.Now().Add(time.Hour),
+				Experiment: tt.experiment,
+				Variant:    tt.variant,
+			})
+			if err != nil {
+				t.Fatalf("GetActivityStats() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetActivityStats() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetActivityStatsRejectsInvalidFilter(t *testing.T) {
+	setupTestDB(t)
+	for _, f := range []Filter{
+		{Experiment: "$.session_id"},
+		{Variant: "treatment"},
+	} {
+		if _, err := GetActivityStats(context.Background(), f); err == nil {
+			t.Errorf("GetActivityStats(%+v) succeeded, want error", f)
+		}
+	}
+}
+
+func TestGetCurrencyTransitions(t *testing.T) {
+	fc := setupTestDB(t)
+	for _, d := range []string{
+		`{"new_currency":"EUR","previous_currency":""}`,
+		`{"new_currency":"EUR","previous_currency":""}`,
+		`{"new_currency":"JPY","previous_currency":"EUR"}`,
+	} {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypeCurrencyChange, Details: d})
+	}
+
+	got, err := GetCurrencyTransitions(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetCurrencyTransitions() failed: %v", err)
+	}
+	want := &CurrencyTransitions{
+		Transitions: map[string]map[string]int{
+			"":    {"EUR": 2},
+			"EUR": {"JPY": 1},
+		},
+		MostSelected: []CurrencyCount{{"EUR", 2}, {"JPY", 1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetCurrencyTransitions() = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetCheckoutsByCountry(t *testing.T) {
+	fc := setupTestDB(t)
+	for _, details := range []string{
+		`{"country":"Canada","shipping_cost":8.99,"shipping_currency":"CAD"}`,
+		`{"country":"Canada","shipping_cost":1.01,"shipping_currency":"CAD"}`,
+		`{"country":"Canada","shipping_cost":7.5,"shipping_currency":"USD"}`,
+		`{"country":"Canada","failed":true}`,
+		`{"country":"Japan","failed":true}`,
+		`{"failed":true}`,
+	} {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout, Details: details})
+	}
+	// Not a checkout
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Details: `{"country":"Japan"}`})
+
+	got, err := GetCheckoutsByCountry(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetCheckoutsByCountry() failed: %v", err)
+	}
+	want := []CountryCheckouts{
+		{Country: "Canada", Orders: 3, Failed: 1, ShippingCost: map[string]float64{"CAD": 10, "USD": 7.5}},
+		{Country: "Japan", Orders: 0, Failed: 1, ShippingCost: map[string]float64{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetCheckoutsByCountry() = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetActionAttribution(t *testing.T) {
+	fc := setupTestDB(t)
+	mustLog(t, &ActivityLog{RequestID: "view-1", ActivityType: ActivityTypeProductView})
+	mustLog(t, &ActivityLog{RequestID: "view-2", ActivityType: ActivityTypeProductView})
+	mustLog(t, &ActivityLog{RequestID: "cart-1", ActivityType: "other"})
+	for _, a := range []ActivityLog{
+		{ActivityType: ActivityTypeAddToCart, ParentRequestID: "view-1"},
+		{ActivityType: ActivityTypeAddToCart, ParentRequestID: "view-2"},
+		{ActivityType: ActivityTypeAddToCart},
+		{ActivityType: ActivityTypeCheckout, ParentRequestID: "cart-1"},
+		// The parent page was purged or never logged
+		{ActivityType: ActivityTypeCheckout, ParentRequestID: "gone"},
+	} {
+		mustLog(t, &a)
+	}
+
+	got, err := GetActionAttribution(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetActionAttribution() failed: %v", err)
+	}
+	want := map[string]map[string]int{
+		ActivityTypeAddToCart: {ActivityTypeProductView: 2, OriginDirect: 1},
+		ActivityTypeCheckout:  {"other": 1, OriginDirect: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetActionAttribution() = %v, want %v", got, want)
+	}
+}
+
+// seedActivities inserts n page views of the given session in one statement
+func seedActivities(t *testing.T, sessionID string, n int) {
+	t.Helper()
+	_, err := GetDB().Exec(`
+		WITH RECURSIVE seq(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM seq WHERE i < ?)
+		INSERT INTO activities (session_id, request_id, activity_type, path, method, status_code, user_currency, details, created_at)
+		SELECT ?, 'request-' || i, 'page_view', '/', 'GET', 200, 'USD', '', ? FROM seq`,
+		n, sessionID, Now().UTC())
+	if err != nil {
+		t.Fatalf("seeding %d activities failed: %v", n, err)
+	}
+}
+
+func TestQueryStreamKeepsMemoryConstant(t *testing.T) {
+	setupTestDB(t)
+	const n = 100000
+	seedActivities(t, "loadgen", n)
+
+	var start runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&start)
+
+	var count int
+	var lastID int64
+	var maxGrowth int64
+	err := QueryStream(context.Background(), Filter{SessionIDs: []string{"loadgen"}}, func(a ActivityLog) error {
+		if a.ID <= lastID {
+			t.Fatalf("activity %d streamed after %d", a.ID, lastID)
+		}
+		lastID = a.ID
+		count++
+		if count%10000 == 0 {
+			var m runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&m)
+			maxGrowth = max(maxGrowth, int64(m.HeapAlloc)-int64(start.HeapAlloc))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("QueryStream() failed: %v", err)
+	}
+	if count != n {
+		t.Errorf("streamed %d activities, want %d", count, n)
+	}
+	// Holding them all would take tens of megabytes
+	if maxGrowth > 4<<20 {
+		t.Errorf("live heap grew by %d bytes while streaming", maxGrowth)
+	}
+}
+
+func TestQueryStreamAllocationsPerRow(t *testing.T) {
+	setupTestDB(t)
+	seedActivities(t, "small", 500)
+	seedActivities(t, "large", 2000)
+
+	perRow := func(sessionID string, rows int) float64 {
+		allocs := testing.AllocsPerRun(3, func() {
+			if err := QueryStream(context.Background(), Filter{SessionIDs: []string{sessionID}},
+				func(ActivityLog) error { return nil }); err != nil {
+				t.Fatalf("QueryStream() failed: %v", err)
+			}
+		})
+		return allocs / float64(rows)
+	}
+	small, large := perRow("small", 500), perRow("large", 2000)
+	if large > small*1.1 {
+		t.Errorf("allocations per row grew from %.1f to %.1f with four times the rows", small, large)
+	}
+}
+
+func TestQueryStreamStops(t *testing.T) {
+	setupTestDB(t)
+	seedActivities(t, "session-1", 10)
+
+	stop := errors.New("stop")
+	var count int
+	err := QueryStream(context.Background(), Filter{}, func(ActivityLog) error {
+		count++
+		if count == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || count != 3 {
+		t.Errorf("QueryStream() = %v after %d activities, want the callback error after 3", err, count)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	count = 0
+	err = QueryStream(ctx, Filter{}, func(ActivityLog) error {
+		count++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || count != 1 {
+		t.Errorf("QueryStream() = %v after %d activities, want context.Canceled after 1", err, count)
+	}
+}
+
+func TestGetActivitiesBoundsLimit(t *testing.T) {
+	setupTestDB(t)
+	seedActivities(t, "session-1", MaxActivities+5)
+
+	for _, limit := range []int{0, -1, MaxActivities + 1, 1 << 30} {
+		got, err := GetActivities(Filter{}, limit)
+		if err != nil {
+			t.Fatalf("GetActivities(%d) failed: %v", limit, err)
+		}
+		if len(got) != MaxActivities {
+			t.Errorf("GetActivities(%d) returned %d activities, want %d", limit, len(got), MaxActivities)
+		}
+	}
+	if got, _ := GetActivitiesBySession("session-1", MaxActivities*2); len(got) != MaxActivities {
+		t.Errorf("GetActivitiesBySession() returned %d activities, want %d", len(got), MaxActivities)
+	}
+}
+
+func TestRecentProductViews(t *testing.T) {
+	fc := setupTestDB(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeProductView, ProductID: "OLJCESPC7Z"})
+	fc.Advance(time.Minute)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeProductView, ProductID: "66VCHSJNUP"})
+	fc.Advance(time.Minute)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeProductView, ProductID: "OLJCESPC7Z"})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeAddToCart, ProductID: "1YMWWN1N4O"})
+	mustLog(t, &ActivityLog{SessionID: "session-2", ActivityType: ActivityTypeProductView, ProductID: "1YMWWN1N4O"})
+
+	views, err := RecentProductViews("session-1", 10)
+	if err != nil {
+		t.Fatalf("RecentProductViews() failed: %v", err)
+	}
+	want := []ProductView{
+		{ProductID: "OLJCESPC7Z", Views: 2, LastViewed: Now().UTC()},
+		{ProductID: "66VCHSJNUP", Views: 1, LastViewed: Now().Add(-time.Minute).UTC()},
+	}
+	if !reflect.DeepEqual(views, want) {
+		t.Errorf("RecentProductViews() = %+v, want %+v", views, want)
+	}
+}
+
+func TestPagesIgnoreClockSkew(t *testing.T) {
+	for _, partitioned := range []bool{false, true} {
+		name := "single table"
+		if partitioned {
+			name = "partitioned"
+		}
+		t.Run(name, func(t *testing.T) {
+			fc := setupTestDB(t)
+			if partitioned {
+				partitionByMonth(t)
+			}
+			// Timestamps out of logging order, a few shared, straddling a
+			// month boundary as after the clock stepped back
+			monthEnd := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+			offsets := []int{3, -2, 5, 5, -7, 0, 1, -2, 4, -9, 2, 2, -1, 6, -4, 0, 3, -6}
+			logged := map[int64]bool{}
+			for _, h := range offsets {
+				a := &ActivityLog{SessionID: "skewed", ActivityType: ActivityTypePageView, CreatedAt: monthEnd.Add(time.Duration(h) * time.Hour)}
+				mustLog(t, a)
+				logged[a.ID] = true
+			}
+			fc.Advance(time.Minute)
+
+			seen := map[int64]bool{}
+			var before int64
+			for page := 0; ; page++ {
+				if page > len(offsets) {
+					t.Fatal("pages never end")
+				}
+				activities, err := GetActivities(Filter{SessionID: "skewed", BeforeID: before}, 4)
+				if err != nil {
+					t.Fatalf("GetActivities() failed: %v", err)
+				}
+				if len(activities) == 0 {
+					break
+				}
+				for _, a := range activities {
+					if seen[a.ID] {
+						t.Errorf("activity %d on page %d was already listed", a.ID, page)
+					}
+					if before > 0 && a.ID >= before {
+						t.Errorf("activity %d on page %d isn't before %d", a.ID, page, before)
+					}
+					seen[a.ID] = true
+					before = a.ID
+				}
+			}
+			if !reflect.DeepEqual(seen, logged) {
+				t.Errorf("pages listed activities %v, want every one of %v once", seen, logged)
+			}
+
+			bySession, err := GetActivitiesBySession("skewed", 0)
+			if err != nil {
+				t.Fatalf("GetActivitiesBySession() failed: %v", err)
+			}
+			for i := 1; i < len(bySession); i++ {
+				if bySession[i].ID >= bySession[i-1].ID {
+					t.Fatalf("GetActivitiesBySession() lists %d after %d, want latest logged first", bySession[i].ID, bySession[i-1].ID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,575 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The outbox forwards activities to external sinks without losing any.
+// While a sink is registered, every activity written is also queued in
+// activity_outbox, in the same transaction. A dispatcher per sink reads
+// the queue in order from its cursor, saved in activity_outbox_cursors
+// once a batch is delivered, so activities are delivered at least once,
+// across restarts and outages. Failures are retried with exponential
+// backoff; rows the sink rejects for good are retried one at a time, and
+// parked in activity_outbox_parked after MaxAttempts. Rows every
+// registered sink is past are pruned.
+
+// outboxMigration creates the outbox tables
+const outboxMigration = `
+	CREATE TABLE IF NOT EXISTS activity_outbox (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		activity_id INTEGER NOT NULL,
+		-- The activity as written, JSON encoded
+		payload TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS activity_outbox_cursors (
+		sink TEXT PRIMARY KEY,
+		-- The last outbox row delivered
+		last_id INTEGER NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS activity_outbox_parked (
+		sink TEXT NOT NULL,
+		outbox_id INTEGER NOT NULL,
+		payload TEXT NOT NULL,
+		error TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		parked_at DATETIME NOT NULL,
+		PRIMARY KEY (sink, outbox_id)
+	);`
+
+// Outbox defaults, used for zero OutboxConfig fields
+const (
+	defaultOutboxBatchSize    = 100
+	defaultOutboxPollInterval = time.Second
+	defaultOutboxRetryBackoff = 500 * time.Millisecond
+	defaultOutboxMaxBackoff   = time.Minute
+	defaultOutboxMaxAttempts  = 5
+	// outboxPruneInterval is how often delivered rows are pruned
+	outboxPruneInterval = time.Minute
+)
+
+var (
+	// outboxDelivered and outboxParked count the activities delivered and
+	// parked, per sink
+	outboxDelivered = expvar.NewMap("activity_log_outbox_delivered_total")
+	outboxParked    = expvar.NewMap("activity_log_outbox_parked_total")
+	// outboxLagRows and outboxLagSeconds are how many activities each sink
+	// has yet to receive, and how long the oldest of them has waited
+	outboxLagRows    = expvar.NewMap("activity_log_outbox_lag_rows")
+	outboxLagSeconds = expvar.NewMap("activity_log_outbox_lag_seconds")
+)
+
+// OutboxSink is an external system the outbox delivers activities to
+type OutboxSink interface {
+	// Name identifies the sink's cursor, parked rows and metrics, so it
+	// must stay the same across restarts
+	Name() string
+	// Send delivers activities, oldest first. Errors are retried, with
+	// the same activities and maybe more; wrap those retrying won't fix
+	// with Permanent.
+	Send(ctx context.Context, activities []ActivityLog) error
+}
+
+// permanentError is a delivery failure retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error returned by OutboxSink.Send as one retrying
+// won't fix, such as the sink rejecting the payload
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// OutboxConfig configures the dispatcher of a sink
+type OutboxConfig struct {
+	// BatchSize is how many activities are sent at once at most
+	BatchSize int
+	// PollInterval is how often the outbox is looked at when idle. Writes
+	// also wake the dispatcher up.
+	PollInterval time.Duration
+	// RetryBackoff is the wait after a failure, doubled after each
+	// further one up to MaxBackoff
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+	// MaxAttempts is how many times an activity the sink rejects is sent
+	// before it is parked
+	MaxAttempts int
+}
+
+// outboxDispatchers are the dispatchers running, by sink name. Activities
+// are only queued while there is one.
+var outboxDispatchers = struct {
+	sync.RWMutex
+	byName map[string]*OutboxDispatcher
+}{byName: make(map[string]*OutboxDispatcher)}
+
+// outboxEnabled is whether a dispatcher is running
+var outboxEnabled atomic.Bool
+
+// OutboxDispatcher delivers the activities queued in the outbox to a sink
+type OutboxDispatcher struct {
+	sink OutboxSink
+	cfg  OutboxConfig
+	wake chan struct{}
+	done chan struct{}
+
+	mu sync.Mutex
+	// cursor is the last outbox row delivered
+	cursor      int64
+	failures    int
+	lastError   string
+	lastErrorAt time.Time
+	deliveredAt time.Time
+}
+
+// NewOutboxDispatcher creates the dispatcher of sink, filling in defaults
+func NewOutboxDispatcher(sink OutboxSink, cfg OutboxConfig) *OutboxDispatcher {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultOutboxBatchSize
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultOutboxPollInterval
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultOutboxRetryBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultOutboxMaxBackoff
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultOutboxMaxAttempts
+	}
+	return &OutboxDispatcher{
+		sink: sink,
+		cfg:  cfg,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+}
+
+// Start queues the activities written from now on for the sink, and
+// delivers them, along with those queued for it before, until ctx is done.
+// A sink delivered to for the first time starts with the activities
+// written from now on.
+func (d *OutboxDispatcher) Start(ctx context.Context) error {
+	name := d.sink.Name()
+	outboxDispatchers.Lock()
+	defer outboxDispatchers.Unlock()
+	if _, dup := outboxDispatchers.byName[name]; dup {
+		return fmt.Errorf("activitylog: outbox sink %s already running", name)
+	}
+	cursor, err := loadOutboxCursor(ctx, name)
+	if err != nil {
+		return err
+	}
+	d.cursor = cursor
+	outboxDispatchers.byName[name] = d
+	outboxEnabled.Store(true)
+
+	go func() {
+		defer close(d.done)
+		defer func() {
+			outboxDispatchers.Lock()
+			delete(outboxDispatchers.byName, name)
+			outboxEnabled.Store(len(outboxDispatchers.byName) > 0)
+			outboxDispatchers.Unlock()
+		}()
+		d.run(ctx)
+	}()
+	return nil
+}
+
+// Wait blocks until the dispatcher has stopped after its context was done
+func (d *OutboxDispatcher) Wait() {
+	<-d.done
+}
+
+// loadOutboxCursor returns the cursor of a sink, creating it at the end
+// of the outbox for a new one
+func loadOutboxCursor(ctx context.Context, sink string) (int64, error) {
+	var cursor int64
+	err := GetDB().QueryRowContext(ctx, "SELECT last_id FROM activity_outbox_cursors WHERE sink = ?", sink).Scan(&cursor)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return cursor, err
+	}
+	_, err = GetDB().ExecContext(ctx, `
+		INSERT INTO activity_outbox_cursors (sink, last_id, updated_at)
+		SELECT ?, COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'activity_outbox'), 0), ?`,
+		sink, Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	err = GetDB().QueryRowContext(ctx, "SELECT last_id FROM activity_outbox_cursors WHERE sink = ?", sink).Scan(&cursor)
+	return cursor, err
+}
+
+// queueOutbox queues the activity, as written with the given ID and time,
+// for the sinks running, in tx
+func queueOutbox(tx *sql.Tx, activity *ActivityLog, id int64, createdAt time.Time) error {
+	if !outboxEnabled.Load() {
+		return nil
+	}
+	written := *activity
+	written.ID, written.CreatedAt = id, createdAt
+	payload, err := json.Marshal(written)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO activity_outbox (activity_id, payload, created_at) VALUES (?, ?, ?)",
+		id, string(payload), createdAt)
+	return err
+}
+
+// enqueueOutbox queues an activity already written, as it stands now
+func enqueueOutbox(ctx context.Context, activity *ActivityLog) error {
+	if !outboxEnabled.Load() {
+		return nil
+	}
+	if err := allowWrite(); err != nil {
+		return err
+	}
+	done := beginWrite()
+	tx, err := GetDB().BeginTx(ctx, nil)
+	if err == nil {
+		defer tx.Rollback()
+		if err = queueOutbox(tx, activity, activity.ID, activity.CreatedAt.UTC()); err == nil {
+			err = tx.Commit()
+		}
+	}
+	done()
+	recordWrite(err)
+	if err == nil {
+		wakeOutbox()
+	}
+	return err
+}
+
+// wakeOutbox tells the dispatchers there is something new to deliver
+func wakeOutbox() {
+	if !outboxEnabled.Load() {
+		return
+	}
+	outboxDispatchers.RLock()
+	defer outboxDispatchers.RUnlock()
+	for _, d := range outboxDispatchers.byName {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// outboxRow is an activity queued in the outbox
+type outboxRow struct {
+	id       int64
+	payload  string
+	activity ActivityLog
+}
+
+// run delivers batches until ctx is done
+func (d *OutboxDispatcher) run(ctx context.Context) {
+	name := d.sink.Name()
+	poll := time.NewTicker(d.cfg.PollInterval)
+	defer poll.Stop()
+	var pruned time.Time
+	// isolating is set once a batch is rejected, to send its rows one at
+	// a time until the culprit is found; attempts counts the rejections
+	// of the row at the head of the queue
+	isolating, attempts := false, 0
+	backoff := d.cfg.RetryBackoff
+
+	for ctx.Err() == nil {
+		limit := d.cfg.BatchSize
+		if isolating {
+			limit = 1
+		}
+		rows, err := d.next(ctx, limit)
+		d.observeLag(ctx)
+		if err == nil && len(rows) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-d.wake:
+			case <-poll.C:
+			}
+			continue
+		}
+		if err == nil && ReadOnly() {
+			// Cursors are writes too, and wait for writes to be allowed
+			err = ErrReadOnly
+		}
+		if err == nil {
+			err = d.deliver(ctx, rows)
+		}
+		if err == nil {
+			isolating, attempts = false, 0
+			backoff = d.cfg.RetryBackoff
+			if time.Since(pruned) >= outboxPruneInterval {
+				pruned = time.Now()
+				if err := pruneOutbox(ctx); err != nil {
+					logger.WithError(err).Warn("failed to prune the activity outbox")
+				}
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		d.fail(err)
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			if !isolating && len(rows) > 1 {
+				// Find which of the batch is rejected without waiting
+				isolating = true
+				continue
+			}
+			if attempts++; attempts >= d.cfg.MaxAttempts {
+				if err := d.park(ctx, rows[0], err, attempts); err != nil {
+					logger.WithError(err).WithField("sink", name).Warn("failed to park an activity")
+				} else {
+					isolating, attempts = false, 0
+					backoff = d.cfg.RetryBackoff
+					continue
+				}
+			}
+		}
+		logger.WithError(err).WithField("sink", name).Warn("failed to deliver activities, retrying")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, d.cfg.MaxBackoff)
+	}
+}
+
+// next reads up to limit rows after the cursor, oldest first
+func (d *OutboxDispatcher) next(ctx context.Context, limit int) ([]outboxRow, error) {
+	d.mu.Lock()
+	cursor := d.cursor
+	d.mu.Unlock()
+	rows, err := getReadDB().QueryContext(ctx,
+		"SELECT id, payload FROM activity_outbox WHERE id > ? ORDER BY id LIMIT ?", cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var batch []outboxRow
+	for rows.Next() {
+		var row outboxRow
+		if err := rows.Scan(&row.id, &row.payload); err != nil {
+			return nil, err
+		}
+		batch = append(batch, row)
+	}
+	return batch, rows.Err()
+}
+
+// deliver sends rows to the sink and moves the cursor past them
+func (d *OutboxDispatcher) deliver(ctx context.Context, rows []outboxRow) error {
+	activities := make([]ActivityLog, len(rows))
+	for i := range rows {
+		if err := json.Unmarshal([]byte(rows[i].payload), &activities[i]); err != nil {
+			return Permanent(fmt.Errorf("decoding outbox row %d: %w", rows[i].id, err))
+		}
+	}
+	if err := d.sink.Send(ctx, activities); err != nil {
+		return err
+	}
+	last := rows[len(rows)-1].id
+	if err := d.advance(ctx, last); err != nil {
+		return err
+	}
+	outboxDelivered.Add(d.sink.Name(), int64(len(rows)))
+	d.mu.Lock()
+	d.failures = 0
+	d.deliveredAt = Now()
+	d.mu.Unlock()
+	return nil
+}
+
+// advance saves the cursor at the given outbox row
+func (d *OutboxDispatcher) advance(ctx context.Context, id int64) error {
+	_, err := GetDB().ExecContext(ctx,
+		"UPDATE activity_outbox_cursors SET last_id = ?, updated_at = ? WHERE sink = ?",
+		id, Now().UTC(), d.sink.Name())
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.cursor = id
+	d.mu.Unlock()
+	return nil
+}
+
+// park sets aside a row the sink keeps rejecting, and moves past it
+func (d *OutboxDispatcher) park(ctx context.Context, row outboxRow, cause error, attempts int) error {
+	_, err := GetDB().ExecContext(ctx, `
+		INSERT OR REPLACE INTO activity_outbox_parked (sink, outbox_id, payload, error, attempts, parked_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		d.sink.Name(), row.id, row.payload, cause.Error(), attempts, Now().UTC())
+	if err != nil {
+		return err
+	}
+	if err := d.advance(ctx, row.id); err != nil {
+		return err
+	}
+	outboxParked.Add(d.sink.Name(), 1)
+	logger.WithError(cause).WithField("sink", d.sink.Name()).WithField("outbox_id", row.id).
+		Warn("parked an activity the sink keeps rejecting")
+	return nil
+}
+
+// fail records a failed delivery
+func (d *OutboxDispatcher) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures++
+	d.lastError = err.Error()
+	d.lastErrorAt = Now()
+}
+
+// observeLag updates the lag metrics of the sink
+func (d *OutboxDispatcher) observeLag(ctx context.Context) {
+	pending, oldest, err := d.pending(ctx)
+	if err != nil {
+		return
+	}
+	lag := new(expvar.Float)
+	if !oldest.IsZero() {
+		lag.Set(Now().Sub(oldest).Seconds())
+	}
+	n := new(expvar.Int)
+	n.Set(int64(pending))
+	outboxLagRows.Set(d.sink.Name(), n)
+	outboxLagSeconds.Set(d.sink.Name(), lag)
+}
+
+// pending returns how many rows the sink has yet to receive, and when the
+// oldest of them was queued
+func (d *OutboxDispatcher) pending(ctx context.Context) (int, time.Time, error) {
+	d.mu.Lock()
+	cursor := d.cursor
+	d.mu.Unlock()
+	var n int
+	var oldest time.Time
+	err := getReadDB().QueryRowContext(ctx,
+		"SELECT created_at FROM activity_outbox WHERE id > ? ORDER BY id LIMIT 1", cursor).Scan(&oldest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	err = getReadDB().QueryRowContext(ctx, "SELECT COUNT(*) FROM activity_outbox WHERE id > ?", cursor).Scan(&n)
+	return n, oldest, err
+}
+
+// pruneOutbox deletes the rows every running sink is past
+func pruneOutbox(ctx context.Context) error {
+	outboxDispatchers.RLock()
+	var floor int64 = -1
+	for _, d := range outboxDispatchers.byName {
+		d.mu.Lock()
+		if floor < 0 || d.cursor < floor {
+			floor = d.cursor
+		}
+		d.mu.Unlock()
+	}
+	outboxDispatchers.RUnlock()
+	if floor <= 0 {
+		return nil
+	}
+	_, err := GetDB().ExecContext(ctx, "DELETE FROM activity_outbox WHERE id <= ?", floor)
+	return err
+}
+
+// OutboxSinkStatus is how far behind a sink of the outbox is
+type OutboxSinkStatus struct {
+	Sink string `json:"sink"`
+	// Cursor is the last outbox row delivered
+	Cursor int64 `json:"cursor"`
+	// Pending is how many activities are yet to be delivered, the oldest
+	// queued LagSeconds ago
+	Pending    int     `json:"pending"`
+	LagSeconds float64 `json:"lag_seconds"`
+	// Parked is how many activities the sink rejected for good
+	Parked int `json:"parked"`
+	// ConsecutiveFailures counts the failed deliveries since the last one
+	// that went through
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	LastDeliveredAt     *time.Time `json:"last_delivered_at,omitempty"`
+}
+
+// OutboxStatus returns the status of every sink running, by name
+func OutboxStatus(ctx context.Context) ([]OutboxSinkStatus, error) {
+	outboxDispatchers.RLock()
+	dispatchers := make([]*OutboxDispatcher, 0, len(outboxDispatchers.byName))
+	for _, d := range outboxDispatchers.byName {
+		dispatchers = append(dispatchers, d)
+	}
+	outboxDispatchers.RUnlock()
+
+	statuses := make([]OutboxSinkStatus, 0, len(dispatchers))
+	for _, d := range dispatchers {
+		pending, oldest, err := d.pending(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s := OutboxSinkStatus{Sink: d.sink.Name(), Pending: pending}
+		if !oldest.IsZero() {
+			s.LagSeconds = Now().Sub(oldest).Seconds()
+		}
+		if err := getReadDB().QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM activity_outbox_parked WHERE sink = ?", s.Sink).Scan(&s.Parked); err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		s.Cursor = d.cursor
+		s.ConsecutiveFailures = d.failures
+		s.LastError = d.lastError
+		if !d.lastErrorAt.IsZero() {
+			at := d.lastErrorAt.UTC()
+			s.LastErrorAt = &at
+		}
+		if !d.deliveredAt.IsZero() {
+			at := d.deliveredAt.UTC()
+			s.LastDeliveredAt = &at
+		}
+		d.mu.Unlock()
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Sink < statuses[j].Sink })
+	return statuses, nil
+}
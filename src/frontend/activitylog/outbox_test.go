@@ -0,0 +1,217 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSink records the batches sent to it. fail decides the error of a
+// batch, if any.
+type fakeSink struct {
+	mu      sync.Mutex
+	batches [][]ActivityLog
+	fail    func(batch []ActivityLog) error
+}
+
+func (s *fakeSink) Name() string { return "fake" }
+
+func (s *fakeSink) Send(ctx context.Context, batch []ActivityLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		if err := s.fail(batch); err != nil {
+			return err
+		}
+	}
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+// paths returns the paths of the activities delivered, in order
+func (s *fakeSink) paths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var paths []string
+	for _, batch := range s.batches {
+		for _, a := range batch {
+			paths = append(paths, a.Path)
+		}
+	}
+	return paths
+}
+
+// startDispatcher runs a dispatcher of sink, returning the func that
+// stops it
+func startDispatcher(t *testing.T, sink OutboxSink, cfg OutboxConfig) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	d := NewOutboxDispatcher(sink, cfg)
+	if err := d.Start(ctx); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			d.Wait()
+		})
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func countOutbox(t *testing.T) int {
+	t.Helper()
+	var n int
+	if err := GetDB().QueryRow("SELECT COUNT(*) FROM activity_outbox").Scan(&n); err != nil {
+		t.Fatalf("counting the outbox failed: %v", err)
+	}
+	return n
+}
+
+func TestOutboxOnlyQueuesWhileASinkRuns(t *testing.T) {
+	setupTestDB(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	if n := countOutbox(t); n != 0 {
+		t.Fatalf("outbox has %d rows with no sink running, want 0", n)
+	}
+
+	sink := &fakeSink{}
+	startDispatcher(t, sink, OutboxConfig{})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Path: "/after"})
+	waitFor(t, func() bool { return len(sink.paths()) == 1 })
+	if got := sink.paths(); got[0] != "/after" {
+		t.Errorf("delivered %v, want only what was logged after Start", got)
+	}
+}
+
+func TestOutboxResumesFromTheCursor(t *testing.T) {
+	setupTestDB(t)
+	down := &fakeSink{fail: func([]ActivityLog) error { return errors.New("unavailable") }}
+	stop := startDispatcher(t, down, OutboxConfig{RetryBackoff: time.Millisecond})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Path: "/a"})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Path: "/b"})
+	stop()
+
+	sink := &fakeSink{}
+	startDispatcher(t, sink, OutboxConfig{})
+	waitFor(t, func() bool { return len(sink.paths()) == 2 })
+	if got := sink.paths(); got[0] != "/a" || got[1] != "/b" {
+		t.Errorf("delivered %v after the restart, want /a and /b", got)
+	}
+
+	status, err := OutboxStatus(context.Background())
+	if err != nil {
+		t.Fatalf("OutboxStatus() failed: %v", err)
+	}
+	if len(status) != 1 || status[0].Sink != "fake" || status[0].Pending != 0 || status[0].LastDeliveredAt == nil {
+		t.Errorf("OutboxStatus() = %+v, want fake caught up", status)
+	}
+}
+
+func TestOutboxParksPoisonActivities(t *testing.T) {
+	setupTestDB(t)
+	var transient sync.Once
+	sink := &fakeSink{fail: func(batch []ActivityLog) error {
+		var err error
+		transient.Do(func() { err = errors.New("timeout") })
+		if err != nil {
+			return err
+		}
+		for _, a := range batch {
+			if a.Path == "/poison" {
+				return Permanent(errors.New("rejected"))
+			}
+		}
+		return nil
+	}}
+	// The activities are queued while the first batch is failing, so that
+	// the poison arrives in the middle of the next one
+	startDispatcher(t, sink, OutboxConfig{RetryBackoff: 50 * time.Millisecond, MaxAttempts: 2})
+	for _, path := range []string{"/first", "/poison", "/last"} {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Path: path})
+	}
+
+	waitFor(t, func() bool { return len(sink.paths()) == 2 })
+	if got := sink.paths(); got[0] != "/first" || got[1] != "/last" {
+		t.Errorf("delivered %v, want all but the poison", got)
+	}
+	var path, cause string
+	var attempts int
+	err := GetDB().QueryRow(`SELECT json_extract(payload, '$.path'), error, attempts FROM activity_outbox_parked WHERE sink = 'fake'`).
+		Scan(&path, &cause, &attempts)
+	if err != nil {
+		t.Fatalf("reading the parked activity failed: %v", err)
+	}
+	if path != "/poison" || cause != "rejected" || attempts != 2 {
+		t.Errorf("parked %s after %d attempts (%s), want /poison after 2 (rejected)", path, attempts, cause)
+	}
+}
+
+func TestOutboxStatusReportsTheLag(t *testing.T) {
+	fc := setupTestDB(t)
+	sink := &fakeSink{fail: func([]ActivityLog) error { return errors.New("unavailable") }}
+	startDispatcher(t, sink, OutboxConfig{RetryBackoff: time.Hour})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	fc.Advance(90 * time.Second)
+
+	waitFor(t, func() bool {
+		status, err := OutboxStatus(context.Background())
+		return err == nil && len(status) == 1 && status[0].ConsecutiveFailures > 0
+	})
+	status, err := OutboxStatus(context.Background())
+	if err != nil {
+		t.Fatalf("OutboxStatus() failed: %v", err)
+	}
+	s := status[0]
+	if s.Pending != 2 || s.LagSeconds != 90 || s.LastError != "unavailable" || s.LastErrorAt == nil {
+		t.Errorf("OutboxStatus() = %+v, want 2 pending for 90s after unavailable", s)
+	}
+}
+
+func TestPruneOutboxKeepsWhatASinkStillNeeds(t *testing.T) {
+	setupTestDB(t)
+	sink := &fakeSink{}
+	startDispatcher(t, sink, OutboxConfig{})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	waitFor(t, func() bool { return len(sink.paths()) == 2 })
+
+	slow := &namedSink{name: "slow", OutboxSink: &fakeSink{fail: func([]ActivityLog) error { return errors.New("down") }}}
+	startDispatcher(t, slow, OutboxConfig{RetryBackoff: time.Hour})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	waitFor(t, func() bool { return len(sink.paths()) == 3 })
+
+	if err := pruneOutbox(context.Background()); err != nil {
+		t.Fatalf("pruneOutbox() failed: %v", err)
+	}
+	if n := countOutbox(t); n != 1 {
+		t.Errorf("outbox has %d rows after pruning, want the 1 slow hasn't delivered", n)
+	}
+}
+
+// namedSink renames a sink
+type namedSink struct {
+	OutboxSink
+	name string
+}
+
+func (s *namedSink) Name() string { return s.name }
@@ -0,0 +1,161 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOverloadHighWater = 32
+	defaultOverloadSustain   = 10 * time.Second
+)
+
+// LoggingMode tells which activities the middleware logs
+type LoggingMode int
+
+const (
+	// LoggingFull logs every activity
+	LoggingFull LoggingMode = iota
+	// LoggingEssential logs only checkouts and server errors, to relieve a
+	// database that can't keep up with the traffic
+	LoggingEssential
+)
+
+func (m LoggingMode) String() string {
+	switch m {
+	case LoggingFull:
+		return "full"
+	case LoggingEssential:
+		return "essential"
+	}
+	return "unknown"
+}
+
+// writeBacklog counts the activity writes in flight. SQLite has a single
+// writer, so when the database can't keep up they queue behind each other
+// and hold up the requests that made them. Once at least highWater writes
+// have been pending for sustain, the middleware switches to essential
+// logging; once the backlog has drained to half the mark for as long, it
+// switches back.
+var writeBacklog = struct {
+	sync.Mutex
+	highWater int
+	sustain   time.Duration
+	pending   int
+	mode      LoggingMode
+	// since is when the backlog crossed the mark that leaves the current
+	// mode, zero while it hasn't
+	since time.Time
+}{
+	highWater: defaultOverloadHighWater,
+	sustain:   defaultOverloadSustain,
+}
+
+// ConfigureOverload sets how many activity writes may be pending, and for
+// how long, before only essential activities are logged. Non-positive
+// values keep the defaults. Logging starts out in full mode.
+func ConfigureOverload(highWater int, sustain time.Duration) {
+	if highWater <= 0 {
+		highWater = defaultOverloadHighWater
+	}
+	if sustain <= 0 {
+		sustain = defaultOverloadSustain
+	}
+	writeBacklog.Lock()
+	defer writeBacklog.Unlock()
+	writeBacklog.highWater = highWater
+	writeBacklog.sustain = sustain
+	writeBacklog.since = time.Time{}
+	setLoggingMode(LoggingFull)
+}
+
+// CurrentLoggingMode returns which activities are currently logged
+func CurrentLoggingMode() LoggingMode {
+	writeBacklog.Lock()
+	defer writeBacklog.Unlock()
+	updateLoggingMode()
+	return writeBacklog.mode
+}
+
+// beginWrite counts a write as pending until the returned function is
+// called, holding off WAL checkpoints until then
+func beginWrite() func() {
+	writeBacklog.Lock()
+	writeBacklog.pending++
+	activityWritesPending.Set(int64(writeBacklog.pending))
+	updateLoggingMode()
+	writeBacklog.Unlock()
+	// Writes waiting for a checkpoint count as pending
+	writeGate.RLock()
+	return func() {
+		writeBacklog.Lock()
+		defer writeBacklog.Unlock()
+		writeBacklog.pending--
+		activityWritesPending.Set(int64(writeBacklog.pending))
+		updateLoggingMode()
+		writeGate.RUnlock()
+	}
+}
+
+// updateLoggingMode switches modes when the backlog has been past the mark
+// for long enough. The caller holds writeBacklog's lock.
+func updateLoggingMode() {
+	b := &writeBacklog
+	crossed := b.pending >= b.highWater
+	if b.mode == LoggingEssential {
+		crossed = b.pending <= b.highWater/2
+	}
+	if !crossed {
+		b.since = time.Time{}
+		return
+	}
+	now := Now()
+	if b.since.IsZero() {
+		b.since = now
+	}
+	if now.Sub(b.since) < b.sustain {
+		return
+	}
+
+	b.since = time.Time{}
+	if b.mode == LoggingFull {
+		logger.Warnf("%d activity writes pending for %v; logging only checkouts and server errors",
+			b.pending, b.sustain)
+		setLoggingMode(LoggingEssential)
+	} else {
+		logger.Infof("activity write backlog drained to %d; logging all activities again", b.pending)
+		setLoggingMode(LoggingFull)
+	}
+}
+
+// setLoggingMode changes the mode, keeping the gauge in sync. The caller
+// holds writeBacklog's lock.
+func setLoggingMode(m LoggingMode) {
+	writeBacklog.mode = m
+	if m == LoggingFull {
+		activityLoggingDegraded.Set(0)
+	} else {
+		activityLoggingDegraded.Set(1)
+	}
+}
+
+// essential tells whether an activity is logged even in essential mode
+func essential(activity *ActivityLog) bool {
+	return activity.ActivityType == ActivityTypeCheckout ||
+		activity.StatusCode >= http.StatusInternalServerError
+}
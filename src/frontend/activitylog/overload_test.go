@@ -0,0 +1,114 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stallWrites counts n writes as pending, as if they were stuck behind a
+// slow database, until the returned function is called
+func stallWrites(n int) func() {
+	var done []func()
+	for i := 0; i < n; i++ {
+		done = append(done, beginWrite())
+	}
+	return func() {
+		for _, d := range done {
+			d()
+		}
+	}
+}
+
+func TestOverloadLogsOnlyEssentialActivities(t *testing.T) {
+	fc := setupTestDB(t)
+	ConfigureOverload(4, 10*time.Second)
+	router := newTestRouter()
+
+	drain := stallWrites(4)
+	fc.Advance(9 * time.Second)
+	if m := CurrentLoggingMode(); m != LoggingFull {
+		t.Fatalf("mode = %v before the backlog lasted long enough, want full", m)
+	}
+	fc.Advance(time.Second)
+	if m := CurrentLoggingMode(); m != LoggingEssential {
+		t.Fatalf("mode = %v after a sustained backlog, want essential", m)
+	}
+	if got := activityLoggingDegraded.Value(); got != 1 {
+		t.Errorf("gauge = %d while degraded, want 1", got)
+	}
+
+	shed := activitiesShed.Value()
+	serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "session-1")
+	serve(router, postForm("/cart", "product_id=OLJCESPC7Z&quantity=1"), "session-1")
+	if n := countActivities(t); n != 0 {
+		t.Errorf("%d activities logged in essential mode, want the page view and cart add shed", n)
+	}
+	if got := activitiesShed.Value() - shed; got != 2 {
+		t.Errorf("shed counter grew by %d, want 2", got)
+	}
+	serve(router, postForm("/cart/checkout", "email=a@example.com"), "session-1")
+	if a := lastActivity(t); a.ActivityType != ActivityTypeCheckout {
+		t.Errorf("logged %q, want the checkout", a.ActivityType)
+	}
+
+	// Recovery waits for the backlog to stay drained
+	drain()
+	fc.Advance(9 * time.Second)
+	if m := CurrentLoggingMode(); m != LoggingEssential {
+		t.Fatalf("mode = %v right after draining, want essential", m)
+	}
+	fc.Advance(time.Second)
+	if m := CurrentLoggingMode(); m != LoggingFull {
+		t.Fatalf("mode = %v after draining, want full", m)
+	}
+	serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "session-1")
+	if a := lastActivity(t); a.ActivityType != ActivityTypePageView {
+		t.Errorf("logged %q after recovering, want the page view", a.ActivityType)
+	}
+}
+
+func TestOverloadKeepsServerErrors(t *testing.T) {
+	fc := setupTestDB(t)
+	ConfigureOverload(1, time.Second)
+	defer stallWrites(1)()
+	fc.Advance(time.Second)
+
+	r := newTestRouter()
+	r.HandleFunc("/fails", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	serve(r, httptest.NewRequest(http.MethodGet, "/fails", nil), "session-1")
+	if a := lastActivity(t); a.StatusCode != http.StatusInternalServerError {
+		t.Errorf("logged status %d, want the server error", a.StatusCode)
+	}
+}
+
+func TestBriefBacklogKeepsFullLogging(t *testing.T) {
+	fc := setupTestDB(t)
+	ConfigureOverload(2, 10*time.Second)
+
+	for i := 0; i < 3; i++ {
+		drain := stallWrites(2)
+		fc.Advance(5 * time.Second)
+		drain()
+	}
+	if m := CurrentLoggingMode(); m != LoggingFull {
+		t.Errorf("mode = %v after brief backlogs, want full", m)
+	}
+}
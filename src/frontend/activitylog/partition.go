@@ -0,0 +1,631 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Activities are kept either in the single activities table, or split by
+// month into activities_YYYYMM tables, with activities a view over them.
+// Reads don't tell the two apart: they go through the view, or through
+// Filter.from, which only unions the months a filter's time range
+// overlaps. Writes go to the tables activityTables returns. Splitting a
+// database is a one-way conversion, PartitionByMonth; from then on the
+// partition of each new month is created by its first write, and
+// DropPartitionsBefore removes whole months at once.
+
+const (
+	// partitionPrefix starts the name of every monthly partition, followed
+	// by its month in partitionMonthLayout
+	partitionPrefix      = "activities_"
+	partitionMonthLayout = "200601"
+	// partitionGlob matches the names of the monthly partitions
+	partitionGlob = "activities_[0-9][0-9][0-9][0-9][0-9][0-9]"
+)
+
+// ErrNotPartitioned is returned by the operations on monthly partitions for
+// a database that isn't split by month.
+var ErrNotPartitioned = errors.New("activitylog: activities aren't split by month")
+
+// partitionSet is where activities are stored: in the activities table, or
+// in the monthly partitions listed in tables, oldest first
+type partitionSet struct {
+	byMonth bool
+	tables  []string
+}
+
+// partitions is the current partitionSet. It is only changed on the write
+// connection, and replaced before that connection is released, so that a
+// write always sees the tables that exist.
+var partitions atomic.Pointer[partitionSet]
+
+// partitionChanges serializes the changes to the set of partitions
+var partitionChanges sync.Mutex
+
+// partitionRetention is how many months of partitions retention keeps,
+// the current one included, or 0 to keep every month
+var partitionRetention = struct {
+	sync.RWMutex
+	months int
+}{}
+
+// ConfigurePartitionRetention sets how many months of activities are kept
+// in a database split by month, the current one included. The retention
+// job drops the partitions of older months. Non-positive values keep
+// every month, the default.
+func ConfigurePartitionRetention(months int) {
+	partitionRetention.Lock()
+	defer partitionRetention.Unlock()
+	partitionRetention.months = max(months, 0)
+}
+
+// PartitionRetention returns how many months of activities are kept in a
+// database split by month, 0 meaning all of them
+func PartitionRetention() int {
+	partitionRetention.RLock()
+	defer partitionRetention.RUnlock()
+	return partitionRetention.months
+}
+
+// dropExpiredPartitions drops the partitions of the months past their
+// retention, if there is one
+func dropExpiredPartitions(ctx context.Context) {
+	months := PartitionRetention()
+	if months == 0 || !Partitioned() {
+		return
+	}
+	now := Now().UTC()
+	before := time.Date(now.Year(), now.Month()-time.Month(months-1), 1, 0, 0, 0, 0, time.UTC)
+	if n, err := DropPartitionsBefore(ctx, before); err != nil && ctx.Err() == nil {
+		logger.WithError(err).Warn("failed to drop expired activity partitions")
+	} else if n > 0 {
+		logger.WithField("removed", n).Info("dropped expired activity partitions")
+	}
+}
+
+// partitionName is the name of the partition holding the activities
+// created in the month of t
+func partitionName(t time.Time) string {
+	return partitionPrefix + t.UTC().Format(partitionMonthLayout)
+}
+
+// partitionMonth is the first instant of the month a partition holds
+func partitionMonth(table string) (time.Time, error) {
+	return time.Parse(partitionMonthLayout, strings.TrimPrefix(table, partitionPrefix))
+}
+
+// loadPartitions reads how the activities of the database are stored
+func loadPartitions(conn *sql.DB) (*partitionSet, error) {
+	var p partitionSet
+	byMonth, err := isPartitioned(conn)
+	if err != nil || !byMonth {
+		return &p, err
+	}
+	rows, err := conn.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name GLOB ? ORDER BY name",
+		partitionGlob)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	p.byMonth = true
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		p.tables = append(p.tables, name)
+	}
+	return &p, rows.Err()
+}
+
+// isPartitioned tells whether activities is the view over monthly
+// partitions rather than a table
+func isPartitioned(conn *sql.DB) (bool, error) {
+	var kind string
+	err := conn.QueryRow("SELECT type FROM sqlite_master WHERE name = 'activities'").Scan(&kind)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return kind == "view", err
+}
+
+// Partitioned tells whether the activities are split by month
+func Partitioned() bool {
+	p := partitions.Load()
+	return p != nil && p.byMonth
+}
+
+// Partitions returns the names of the monthly partitions, oldest first, or
+// nothing when the activities aren't split by month
+func Partitions() []string {
+	if !Partitioned() {
+		return nil
+	}
+	return slices.Clone(partitions.Load().tables)
+}
+
+// activityTables returns the tables activities are written to: activities
+// itself, or every monthly partition
+func activityTables() []string {
+	if !Partitioned() {
+		return []string{"activities"}
+	}
+	return partitions.Load().tables
+}
+
+// partitionsBetween returns the monthly partitions holding activities
+// created in [start, end), zero times leaving the range open
+func partitionsBetween(p *partitionSet, start, end time.Time) []string {
+	var tables []string
+	for _, table := range p.tables {
+		month, err := partitionMonth(table)
+		if err != nil {
+			continue
+		}
+		if !end.IsZero() && !month.Before(end) {
+			continue
+		}
+		if !start.IsZero() && !month.AddDate(0, 1, 0).After(start) {
+			continue
+		}
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+// unionOf selects every row of tables, which have the same columns
+func unionOf(tables []string) string {
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = "SELECT * FROM " + table
+	}
+	return strings.Join(selects, " UNION ALL ")
+}
+
+// from is what the activities matching the filter are selected from,
+// named activities: the activities table or view, or the monthly
+// partitions its time range overlaps
+func (f Filter) from() string {
+	p := partitions.Load()
+	if p == nil || !p.byMonth || (f.Start.IsZero() && f.End.IsZero()) {
+		return "activities"
+	}
+	tables := partitionsBetween(p, f.Start, f.End)
+	switch len(tables) {
+	case len(p.tables):
+		return "activities"
+	case 0:
+		return "(SELECT * FROM activities WHERE 0) AS activities"
+	}
+	return "(" + unionOf(tables) + ") AS activities"
+}
+
+// partitionTable creates a monthly partition, named %[1]s, with the
+// columns of activities once every migration has run, in the same order.
+// IDs come from activity_ids, so that they stay unique across partitions.
+const partitionTable = `
+	CREATE TABLE %[1]s (
+		id INTEGER PRIMARY KEY,
+		session_id TEXT NOT NULL,
+		request_id TEXT NOT NULL,
+		activity_type TEXT NOT NULL,
+		path TEXT NOT NULL,
+		method TEXT NOT NULL,
+		status_code INTEGER,
+		user_currency TEXT,
+		details TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		source TEXT,
+		latency_ms INTEGER,
+		parent_request_id TEXT,
+		utm_campaign TEXT,
+		product_id TEXT,
+		deleted_at DATETIME,
+		version TEXT,
+		revision TEXT,
+		user_id TEXT,
+		referrer_type TEXT,
+		referrer_domain TEXT,
+		route_template TEXT,
+		flags_hash TEXT,
+		response_class TEXT,
+		is_entry INTEGER,
+		device_id TEXT
+	);`
+
+// addActivityColumn matches the statements of migrations adding a column
+// to activities
+var addActivityColumn = regexp.MustCompile(`ALTER TABLE activities ADD COLUMN ([^;]+);`)
+
+// addActivityIndex matches the statements of migrations indexing
+// activities
+var addActivityIndex = regexp.MustCompile(`CREATE INDEX IF NOT EXISTS (\w+) ON activities([^;]+);`)
+
+// partitionedMigration returns the statements of a migration for the
+// activities stored as in p. Columns added to activities are added to each
+// partition instead once it is a view, which picks them up, and indexes
+// and session counter triggers are created on each partition. New partitions are created with them by
+// partitionTable and partitionIndexes.
+func partitionedMigration(stmts string, p *partitionSet) string {
+	if !p.byMonth {
+		return strings.ReplaceAll(stmts, sessionCounterTriggersPlaceholder,
+			replaceSessionCounterTriggers([]string{"activities"}, false))
+	}
+	stmts = strings.ReplaceAll(stmts, sessionCounterTriggersPlaceholder, replaceSessionCounterTriggers(p.tables, true))
+	stmts = addActivityColumn.ReplaceAllStringFunc(stmts, func(stmt string) string {
+		column := addActivityColumn.FindStringSubmatch(stmt)[1]
+		alters := make([]string, len(p.tables))
+		for i, table := range p.tables {
+			alters[i] = "ALTER TABLE " + table + " ADD COLUMN " + column + ";"
+		}
+		return strings.Join(alters, "\n\t")
+	})
+	return addActivityIndex.ReplaceAllStringFunc(stmts, func(stmt string) string {
+		m := addActivityIndex.FindStringSubmatch(stmt)
+		creates := make([]string, len(p.tables))
+		for i, table := range p.tables {
+			creates[i] = "CREATE INDEX IF NOT EXISTS " + table + "_" + m[1] + " ON " + table + m[2] + ";"
+		}
+		return strings.Join(creates, "\n\t")
+	})
+}
+
+// partitionIndexes are the indexes of activities for the partition %[1]s.
+// Their names end with those of the indexes of activities, which the
+// registered query plans look for.
+const partitionIndexes = `
+	CREATE INDEX %[1]s_idx_session ON %[1]s(session_id);
+	CREATE INDEX %[1]s_idx_created_at ON %[1]s(created_at);
+	CREATE INDEX %[1]s_idx_activity_type ON %[1]s(activity_type);
+	CREATE INDEX %[1]s_idx_source ON %[1]s(source);
+	CREATE INDEX %[1]s_idx_request_id ON %[1]s(request_id);
+	CREATE INDEX %[1]s_idx_utm_campaign ON %[1]s(utm_campaign);
+	CREATE INDEX %[1]s_idx_product_id ON %[1]s(product_id, created_at);
+	CREATE INDEX %[1]s_idx_deleted_at ON %[1]s(deleted_at) WHERE deleted_at IS NOT NULL;
+	CREATE INDEX %[1]s_idx_version ON %[1]s(version, created_at);
+	CREATE INDEX %[1]s_idx_user_id ON %[1]s(user_id, created_at);
+	CREATE INDEX %[1]s_idx_route_template ON %[1]s(activity_type, route_template, created_at);
+	CREATE INDEX %[1]s_idx_device_id ON %[1]s(device_id, created_at);`
+
+// partitionTriggers are the triggers of activities for a partition
+func partitionTriggers(table string) string {
+	return `
+	CREATE TRIGGER ` + table + `_delete_activity_items AFTER DELETE ON ` + table + `
+	BEGIN
+		DELETE FROM activity_items WHERE activity_id = OLD.id;
+	END;` + sessionCounterTriggers(table, table+"_")
+}
+
+// activityIDsTable hands out the IDs of the activities of a database split
+// by month, never reusing one, like the AUTOINCREMENT of activities did
+const activityIDsTable = `CREATE TABLE IF NOT EXISTS activity_ids (id INTEGER PRIMARY KEY AUTOINCREMENT)`
+
+// nextActivityID takes the next activity ID from activity_ids
+func nextActivityID(tx *sql.Tx) (int64, error) {
+	res, err := tx.Exec("INSERT INTO activity_ids DEFAULT VALUES")
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	// The sequence remembers it, the row isn't needed
+	_, err = tx.Exec("DELETE FROM activity_ids")
+	return id, err
+}
+
+// createView (re)creates the activities view over tables
+func createView(ctx context.Context, tx *sql.Tx, tables []string) error {
+	if _, err := tx.ExecContext(ctx, "DROP VIEW IF EXISTS activities"); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "CREATE VIEW activities AS "+unionOf(tables))
+	return err
+}
+
+// dropTriggers drops the triggers on table, before it is dropped itself so
+// that none of them runs for its rows
+func dropTriggers(ctx context.Context, tx *sql.Tx, table string) error {
+	rows, err := tx.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'trigger' AND tbl_name = ?", table)
+	if err != nil {
+		return err
+	}
+	var triggers []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		triggers = append(triggers, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, name := range triggers {
+		if _, err := tx.ExecContext(ctx, "DROP TRIGGER "+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changePartitions runs change in a transaction on the write connection,
+// and makes the partitionSet it returns current before that connection is
+// released
+func changePartitions(ctx context.Context, change func(tx *sql.Tx, p partitionSet) (partitionSet, error)) error {
+	partitionChanges.Lock()
+	defer partitionChanges.Unlock()
+	if GetDB() == nil {
+		return ErrNotInitialized
+	}
+	conn, err := GetDB().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	next, err := change(tx, *partitions.Load())
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	partitions.Store(&next)
+	return nil
+}
+
+// partitionFor returns the table an activity created at createdAt is
+// written to, creating its month's partition if it is the first
+func partitionFor(ctx context.Context, createdAt time.Time) (string, error) {
+	if !Partitioned() {
+		return "activities", nil
+	}
+	name := partitionName(createdAt)
+	if slices.Contains(partitions.Load().tables, name) {
+		return name, nil
+	}
+	err := changePartitions(ctx, func(tx *sql.Tx, p partitionSet) (partitionSet, error) {
+		if slices.Contains(p.tables, name) {
+			return p, nil
+		}
+		for _, ddl := range []string{partitionTable, partitionIndexes} {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(ddl, name)); err != nil {
+				return p, err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, partitionTriggers(name)); err != nil {
+			return p, err
+		}
+		p.tables = append(slices.Clone(p.tables), name)
+		slices.Sort(p.tables)
+		logger.WithField("partition", name).Info("created activity partition")
+		return p, createView(ctx, tx, p.tables)
+	})
+	return name, err
+}
+
+// execEachTable runs the statement query returns for each of the tables
+// activities are written to, and returns the number of rows they changed
+func execEachTable(ctx context.Context, exec interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+}, query func(table string) string, args ...interface{}) (int64, error) {
+	var changed int64
+	for _, table := range activityTables() {
+		res, err := exec.ExecContext(ctx, query(table), args...)
+		if err != nil {
+			return changed, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return changed, err
+		}
+		changed += n
+	}
+	return changed, nil
+}
+
+// PartitionByMonth splits the activities table by month, once: each month's
+// activities move to their own partition with the same IDs, and
+// activities becomes the view over the partitions. Line items, session
+// counters and roll-ups stay as they are. It runs in one transaction,
+// which a database with many activities holds for a while, and returns how
+// many activities were moved. Databases already split are left alone.
+func PartitionByMonth(ctx context.Context) (int64, error) {
+	if ReadOnly() {
+		return 0, ErrReadOnly
+	}
+	var moved int64
+	err := changePartitions(ctx, func(tx *sql.Tx, p partitionSet) (partitionSet, error) {
+		if p.byMonth {
+			return p, nil
+		}
+		var total, lastID int64
+		if err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*), MAX(COALESCE(MAX(id), 0),
+				COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'activities'), 0))
+			FROM activities`).Scan(&total, &lastID); err != nil {
+			return p, err
+		}
+
+		months := []string{partitionName(Now())}
+		rows, err := tx.QueryContext(ctx, "SELECT DISTINCT strftime('%Y%m', created_at) FROM activities")
+		if err != nil {
+			return p, err
+		}
+		for rows.Next() {
+			var month sql.NullString
+			if err := rows.Scan(&month); err != nil {
+				rows.Close()
+				return p, err
+			}
+			if month.Valid {
+				months = append(months, partitionPrefix+month.String)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return p, err
+		}
+		slices.Sort(months)
+		months = slices.Compact(months)
+
+		next := partitionSet{byMonth: true}
+		for _, name := range months {
+			month, err := partitionMonth(name)
+			if err != nil {
+				return p, err
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(partitionTable, name)); err != nil {
+				return p, err
+			}
+			// Both tables have the same columns in the same order
+			res, err := tx.ExecContext(ctx, "INSERT INTO "+name+" SELECT * FROM activities WHERE "+createdIn("created_at"),
+				month, month.AddDate(0, 1, 0))
+			if err != nil {
+				return p, err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return p, err
+			}
+			moved += n
+			next.tables = append(next.tables, name)
+		}
+		if moved != total {
+			return p, fmt.Errorf("activitylog: %d of %d activities have a creation time outside any month", total-moved, total)
+		}
+
+		if err := dropTriggers(ctx, tx, "activities"); err != nil {
+			return p, err
+		}
+		if _, err := tx.ExecContext(ctx, "DROP TABLE activities"); err != nil {
+			return p, err
+		}
+		if _, err := tx.ExecContext(ctx, activityIDsTable); err != nil {
+			return p, err
+		}
+		if lastID > 0 {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO activity_ids (id) VALUES (?)", lastID); err != nil {
+				return p, err
+			}
+			if _, err := tx.ExecContext(ctx, "DELETE FROM activity_ids"); err != nil {
+				return p, err
+			}
+		}
+		for _, name := range next.tables {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(partitionIndexes, name)+partitionTriggers(name)); err != nil {
+				return p, err
+			}
+		}
+		return next, createView(ctx, tx, next.tables)
+	})
+	if err != nil {
+		return 0, err
+	}
+	logger.WithField("activities", moved).Info("split the activities by month")
+	return moved, nil
+}
+
+// DropPartitionsBefore drops the monthly partitions holding only
+// activities created before a time, without the cost of deleting their
+// rows one by one, and returns how many activities they held. Their line
+// items go with them, and the counters of their sessions are recounted
+// from the activities left. The roll-ups of their days are kept, so daily
+// stats still cover them. The newest partition is never dropped. Each drop
+// is recorded in the audit trail.
+func DropPartitionsBefore(ctx context.Context, before time.Time) (int64, error) {
+	if !Partitioned() {
+		return 0, ErrNotPartitioned
+	}
+	if ReadOnly() {
+		return 0, ErrReadOnly
+	}
+	var dropped int64
+	err := changePartitions(ctx, func(tx *sql.Tx, p partitionSet) (partitionSet, error) {
+		if len(p.tables) == 0 {
+			return p, nil
+		}
+		newest := p.tables[len(p.tables)-1]
+		keep := []string{newest}
+		var drop []string
+		for _, table := range p.tables[:len(p.tables)-1] {
+			month, err := partitionMonth(table)
+			if err == nil && !month.AddDate(0, 1, 0).After(before) {
+				drop = append(drop, table)
+			} else {
+				keep = append(keep, table)
+			}
+		}
+		if len(drop) == 0 {
+			return p, nil
+		}
+		slices.Sort(keep)
+		if err := createView(ctx, tx, keep); err != nil {
+			return p, err
+		}
+		for _, table := range drop {
+			var n int64
+			if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+				return p, err
+			}
+			sessions := " AND session_id IN (SELECT session_id FROM " + table + ")"
+			for _, stmt := range []string{
+				"DELETE FROM activity_items WHERE activity_id IN (SELECT id FROM " + table + ")",
+				"DELETE FROM session_counters WHERE 1" + sessions,
+				"INSERT INTO session_counters (" + sessionCountersColumns + ")" + countSessions(sessions),
+			} {
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return p, err
+				}
+			}
+			if err := dropTriggers(ctx, tx, table); err != nil {
+				return p, err
+			}
+			if _, err := tx.ExecContext(ctx, "DROP TABLE "+table); err != nil {
+				return p, err
+			}
+			audit := auditRecord{operation: AuditDropPartition, filter: map[string]string{"partition": table}}
+			if err := audit.add(ctx, tx, n); err != nil {
+				return p, err
+			}
+			dropped += n
+			logger.WithField("partition", table).WithField("activities", n).Info("dropped activity partition")
+		}
+		return partitionSet{byMonth: true, tables: keep}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	forgetProductViews()
+	return dropped, nil
+}
@@ -0,0 +1,358 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// partitionByMonth splits the test database by month
+func partitionByMonth(t *testing.T) int64 {
+	t.Helper()
+	n, err := PartitionByMonth(context.Background())
+	if err != nil {
+		t.Fatalf("PartitionByMonth() failed: %v", err)
+	}
+	return n
+}
+
+// rowsIn counts the rows of a table of the test database
+func rowsIn(t *testing.T, table string) int {
+	t.Helper()
+	var n int
+	if err := GetDB().QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatalf("counting the rows of %s failed: %v", table, err)
+	}
+	return n
+}
+
+// schemaOf describes the columns, indexes and triggers of a table, with
+// the indexes named without the prefix partitions add
+func schemaOf(t *testing.T, conn *sql.DB, table, indexPrefix string) []string {
+	t.Helper()
+	var schema []string
+	query := func(q string, args ...interface{}) {
+		rows, err := conn.Query(q, args...)
+		if err != nil {
+			t.Fatalf("%s failed: %v", q, err)
+		}
+		defer rows.Close()
+		cols, _ := rows.Columns()
+		for rows.Next() {
+			values := make([]interface{}, len(cols))
+			ptrs := make([]interface{}, len(cols))
+			for i := range values {
+				ptrs[i] = &values[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				t.Fatalf("%s failed: %v", q, err)
+			}
+			schema = append(schema, fmt.Sprint(values...))
+		}
+	}
+	query("SELECT name, type, \"notnull\", dflt_value, pk FROM pragma_table_info(?) ORDER BY cid", table)
+	query(`SELECT substr(l.name, ?), l.partial, group_concat(i.name)
+		FROM pragma_index_list(?) l, pragma_index_info(l.name) i
+		GROUP BY l.name ORDER BY substr(l.name, ?)`, len(indexPrefix)+1, table, len(indexPrefix)+1)
+	query("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND tbl_name = ?", table)
+	return schema
+}
+
+func TestPartitionsMatchActivitiesTable(t *testing.T) {
+	single, err := openDB(filepath.Join(t.TempDir(), dbFileName))
+	if err != nil {
+		t.Fatalf("openDB() failed: %v", err)
+	}
+	defer single.Close()
+	want := schemaOf(t, single, "activities", "")
+
+	setupTestDB(t)
+	partitionByMonth(t)
+	if got := schemaOf(t, GetDB(), "activities_202506", "activities_202506_"); !reflect.DeepEqual(got, want) {
+		t.Errorf("partition schema =\n%q\nwant that of activities\n%q", got, want)
+	}
+}
+
+func TestPartitionByMonthKeepsActivities(t *testing.T) {
+	fc := setupTestDB(t)
+	may := fc.Now().AddDate(0, -1, 0)
+	order := &ActivityLog{SessionID: "s1", RequestID: "r1", ActivityType: ActivityTypeCheckout, CreatedAt: may,
+		Items: []ActivityItem{{ProductID: "OLJCESPC7Z", Quantity: 2}}}
+	mustLog(t, order)
+	mustLog(t, &ActivityLog{SessionID: "s1", RequestID: "r2", ActivityType: ActivityTypePageView})
+	mustLog(t, &ActivityLog{SessionID: "s2", RequestID: "r3", ActivityType: ActivityTypeProductView})
+	before, err := GetActivities(Filter{}, 10)
+	if err != nil {
+		t.Fatalf("GetActivities() failed: %v", err)
+	}
+	summary, err := GetSessionSummary("s1")
+	if err != nil {
+		t.Fatalf("GetSessionSummary() failed: %v", err)
+	}
+
+	if n := partitionByMonth(t); n != 3 {
+		t.Errorf("PartitionByMonth() moved %d activities, want 3", n)
+	}
+	if got, want := Partitions(), []string{"activities_202505", "activities_202506"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Partitions() = %q, want %q", got, want)
+	}
+	if n := partitionByMonth(t); n != 0 {
+		t.Errorf("PartitionByMonth() moved %d activities again, want 0", n)
+	}
+
+	after, err := GetActivities(Filter{}, 10)
+	if err != nil {
+		t.Fatalf("GetActivities() failed after the split: %v", err)
+	}
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("GetActivities() = %+v after the split, want %+v", after, before)
+	}
+	if items, err := GetActivityItems(order.ID); err != nil || len(items) != 1 {
+		t.Errorf("GetActivityItems() = %v, %v after the split, want the order's item", items, err)
+	}
+	if got, err := GetSessionSummary("s1"); err != nil || got != summary {
+		t.Errorf("GetSessionSummary() = %+v, %v after the split, want %+v", got, err, summary)
+	}
+
+	// IDs keep increasing across partitions, and counters keep counting
+	next := &ActivityLog{SessionID: "s1", RequestID: "r4", ActivityType: ActivityTypeAddToCart, CreatedAt: may}
+	mustLog(t, next)
+	if next.ID <= before[0].ID {
+		t.Errorf("new activity got ID %d, want more than %d", next.ID, before[0].ID)
+	}
+	if got, err := GetSessionSummary("s1"); err != nil || got.Activities != 3 || got.AddToCarts != 1 {
+		t.Errorf("GetSessionSummary() = %+v, %v, want 3 activities with a cart add", got, err)
+	}
+	if n := rowsIn(t, "activities_202505"); n != 2 {
+		t.Errorf("%d activities in May's partition, want 2", n)
+	}
+}
+
+func TestPartitionBoundaries(t *testing.T) {
+	setupTestDB(t)
+	partitionByMonth(t)
+	june := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	mustLog(t, &ActivityLog{RequestID: "last", ActivityType: ActivityTypePageView, CreatedAt: june.Add(-time.Millisecond)})
+	mustLog(t, &ActivityLog{RequestID: "first", ActivityType: ActivityTypePageView, CreatedAt: june})
+	mustLog(t, &ActivityLog{RequestID: "july", ActivityType: ActivityTypePageView, CreatedAt: june.AddDate(0, 1, 0)})
+
+	for table, want := range map[string]int{"activities_202505": 1, "activities_202506": 1, "activities_202507": 1} {
+		if n := rowsIn(t, table); n != want {
+			t.Errorf("%d activities in %s, want %d", n, table, want)
+		}
+	}
+	for _, tc := range []struct {
+		filter Filter
+		want   []string
+	}{
+		{Filter{Start: june, End: june.AddDate(0, 1, 0)}, []string{"first"}},
+		{Filter{Start: june.Add(-time.Hour), End: june.Add(time.Hour)}, []string{"first", "last"}},
+		{Filter{End: june}, []string{"last"}},
+		{Filter{Start: june.Add(time.Millisecond)}, []string{"july"}},
+		{Filter{Start: june.AddDate(0, -1, 0), End: june.AddDate(0, 2, 0)}, []string{"july", "first", "last"}},
+		{Filter{Start: june.AddDate(-1, 0, 0), End: june.AddDate(-1, 1, 0)}, nil},
+	} {
+		activities, err := GetActivities(tc.filter, 10)
+		if err != nil {
+			t.Fatalf("GetActivities(%+v) failed: %v", tc.filter, err)
+		}
+		var got []string
+		for _, a := range activities {
+			got = append(got, a.RequestID)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("GetActivities(%v to %v) = %q, want %q", tc.filter.Start, tc.filter.End, got, tc.want)
+		}
+	}
+	stats, err := GetActivityStats(context.Background(), Filter{Start: june.Add(-time.Hour), End: june.Add(time.Hour)})
+	if err != nil || stats[ActivityTypePageView] != 2 {
+		t.Errorf("GetActivityStats() across months = %v, %v, want 2 page views", stats, err)
+	}
+}
+
+func TestPartitionedWritesSpanMonths(t *testing.T) {
+	fc := setupTestDB(t)
+	partitionByMonth(t)
+	ctx := context.Background()
+	may := fc.Now().AddDate(0, -1, 0)
+	inMay := &ActivityLog{SessionID: "s1", RequestID: "r1", ActivityType: ActivityTypePageView, CreatedAt: may}
+	mustLog(t, inMay)
+	mustLog(t, &ActivityLog{SessionID: "s1", RequestID: "r2", ActivityType: ActivityTypePageView})
+	inJune := &ActivityLog{SessionID: "s2", RequestID: "r1", ActivityType: ActivityTypePageView}
+	mustLog(t, inJune)
+
+	for _, a := range []*ActivityLog{inMay, inJune} {
+		if err := UpdateActivityStatus(ctx, a.ID, 404, time.Millisecond); err != nil {
+			t.Fatalf("UpdateActivityStatus() failed: %v", err)
+		}
+	}
+	var updated int
+	if err := GetDB().QueryRow("SELECT COUNT(*) FROM activities WHERE status_code = 404").Scan(&updated); err != nil || updated != 2 {
+		t.Errorf("%d activities updated in both months, %v, want 2", updated, err)
+	}
+	if n, err := AttributeSession(ctx, "s1", "user-1"); err != nil || n != 2 {
+		t.Errorf("AttributeSession() = %d, %v, want both months' activities", n, err)
+	}
+	if n, err := DeleteSession(ctx, "s1"); err != nil || n != 2 {
+		t.Errorf("DeleteSession() = %d, %v, want both months' activities", n, err)
+	}
+	if n, err := DeleteByFilter(ctx, Filter{SessionID: "s1"}); err != nil || n != 2 {
+		t.Errorf("DeleteByFilter() = %d, %v, want both months' activities", n, err)
+	}
+	if n, err := ResetForTesting(ctx); err != nil || n != 1 {
+		t.Errorf("ResetForTesting() = %d, %v, want the activity left", n, err)
+	}
+	if n := countActivities(t); n != 0 {
+		t.Errorf("%d activities left after the reset, want none", n)
+	}
+}
+
+func TestDropPartitionsBefore(t *testing.T) {
+	fc := setupTestDB(t)
+	ctx := context.Background()
+	june := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := DropPartitionsBefore(ctx, june); err != ErrNotPartitioned {
+		t.Errorf("DropPartitionsBefore() = %v before the split, want ErrNotPartitioned", err)
+	}
+	partitionByMonth(t)
+
+	old := &ActivityLog{SessionID: "s1", RequestID: "r1", ActivityType: ActivityTypeCheckout, CreatedAt: june.AddDate(0, -2, 0),
+		Items: []ActivityItem{{ProductID: "OLJCESPC7Z", Quantity: 1}}}
+	mustLog(t, old)
+	mustLog(t, &ActivityLog{SessionID: "s1", RequestID: "r2", ActivityType: ActivityTypePageView, CreatedAt: june.AddDate(0, -1, 0)})
+	mustLog(t, &ActivityLog{SessionID: "s1", RequestID: "r3", ActivityType: ActivityTypePageView})
+
+	// May ends on the first of June, so only April and May go
+	n, err := DropPartitionsBefore(ctx, june.Add(time.Hour))
+	if err != nil || n != 2 {
+		t.Fatalf("DropPartitionsBefore() = %d, %v, want 2 activities", n, err)
+	}
+	if got, want := Partitions(), []string{"activities_202506"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Partitions() = %q after the drop, want %q", got, want)
+	}
+	if n := countActivities(t); n != 1 {
+		t.Errorf("%d activities left, want 1", n)
+	}
+	if n := rowsIn(t, "activity_items"); n != 0 {
+		t.Errorf("%d line items left, want the dropped order's gone", n)
+	}
+	summary, err := GetSessionSummary("s1")
+	if err != nil || summary.Activities != 1 || summary.Checkouts != 0 || !summary.FirstSeen.Equal(fc.Now()) {
+		t.Errorf("GetSessionSummary() = %+v, %v, want only June's page view", summary, err)
+	}
+	entries, err := GetAuditEntries(10)
+	if err != nil || len(entries) != 2 || entries[0].Operation != AuditDropPartition {
+		t.Errorf("GetAuditEntries() = %+v, %v, want a drop for each partition", entries, err)
+	}
+
+	// The newest partition stays, whatever the time
+	if n, err := DropPartitionsBefore(ctx, june.AddDate(1, 0, 0)); err != nil || n != 0 || len(Partitions()) != 1 {
+		t.Errorf("DropPartitionsBefore() = %d, %v, want the newest partition kept", n, err)
+	}
+
+	// A month dropped comes back with its next activity
+	mustLog(t, &ActivityLog{SessionID: "s2", RequestID: "r4", ActivityType: ActivityTypePageView, CreatedAt: june.AddDate(0, -1, 0)})
+	if got := Partitions(); !slices.Contains(got, "activities_202505") {
+		t.Errorf("Partitions() = %q, want May's recreated", got)
+	}
+	if got, err := GetActivities(Filter{End: june}, 10); err != nil || len(got) != 1 || got[0].RequestID != "r4" {
+		t.Errorf("GetActivities() of May = %+v, %v, want the new activity", got, err)
+	}
+}
+
+func TestPartitionedQueryPlansUseIndexes(t *testing.T) {
+	setupTestDB(t)
+	partitionByMonth(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, CreatedAt: time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)})
+	plans, err := ExplainQueries()
+	if err != nil {
+		t.Fatalf("ExplainQueries() failed: %v", err)
+	}
+	for _, p := range plans {
+		if len(p.Problems) > 0 {
+			t.Errorf("plan of %s has problems %q: %q", p.Name, p.Problems, p.Plan)
+		}
+	}
+}
+
+func TestMigrationsAddColumnsToPartitions(t *testing.T) {
+	fc := setupTestDB(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	fc.Advance(-31 * 24 * time.Hour)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	partitionByMonth(t)
+
+	p, err := loadPartitions(GetDB())
+	if err != nil {
+		t.Fatalf("loadPartitions() failed: %v", err)
+	}
+	stmts := partitionedMigration(`ALTER TABLE activities ADD COLUMN extra TEXT;
+	CREATE INDEX IF NOT EXISTS idx_extra ON activities(extra, created_at);
+	CREATE TABLE IF NOT EXISTS extras (id INTEGER PRIMARY KEY);`, p)
+	if _, err := GetDB().Exec(stmts); err != nil {
+		t.Fatalf("running the migration failed: %v\n%s", err, stmts)
+	}
+	var n int
+	if err := GetDB().QueryRow("SELECT COUNT(*) FROM activities WHERE extra IS NULL").Scan(&n); err != nil {
+		t.Fatalf("reading the new column through the view failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("%d activities have the new column, want 2", n)
+	}
+	if got := rowsIn(t, "extras"); got != 0 {
+		t.Errorf("extras has %d rows, want the table created", got)
+	}
+	if err := GetDB().QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name LIKE '%_idx_extra'").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(p.tables) {
+		t.Errorf("%d partitions have the new index, want all %d", n, len(p.tables))
+	}
+}
+
+func TestRecountSessionsMigrationReplacesPartitionTriggers(t *testing.T) {
+	fc := setupTestDB(t)
+	mustLog(t, &ActivityLog{SessionID: "s", ActivityType: ActivityTypeAddToCart})
+	fc.Advance(-31 * 24 * time.Hour)
+	mustLog(t, &ActivityLog{SessionID: "s", ActivityType: ActivityTypeAddToCart, Details: `{"result":"failed"}`})
+	partitionByMonth(t)
+
+	p, err := loadPartitions(GetDB())
+	if err != nil {
+		t.Fatalf("loadPartitions() failed: %v", err)
+	}
+	stmts := partitionedMigration(recountSessionsMigration(), p)
+	if _, err := GetDB().Exec(stmts); err != nil {
+		t.Fatalf("running the migration failed: %v\n%s", err, stmts)
+	}
+	var n int
+	if err := GetDB().QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE '%_count_session_details'").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(p.tables) {
+		t.Errorf("%d partitions have the session counter triggers, want all %d", n, len(p.tables))
+	}
+	mustLog(t, &ActivityLog{SessionID: "s", ActivityType: ActivityTypeAddToCart})
+	if got, err := GetSessionSummary("s"); err != nil || got.Activities != 3 || got.AddToCarts != 2 {
+		t.Errorf("GetSessionSummary() = %+v, %v, want 3 activities and 2 cart adds", got, err)
+	}
+}
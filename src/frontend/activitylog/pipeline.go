@@ -0,0 +1,139 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PipelineLagWarning is the pipeline latency above which persistence is
+// reported as lagging behind traffic
+const PipelineLagWarning = time.Second
+
+// pipelineBuckets are the upper bounds, in seconds, of the buckets of the
+// pipeline latency histogram. A last bucket, +Inf, holds the rest.
+var pipelineBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// pipelineLatency is how long activities take from being handed to the
+// store, once their request is complete, to being durable: waiting for a
+// WAL checkpoint to let writes through, waiting for the writer connection,
+// and committing.
+//
+// It is published as activity_log_pipeline_latency_seconds, in the shape
+// of a Prometheus histogram: cumulative counts per upper bound in
+// "buckets", and "sum" and "count". On a healthy store nearly every
+// activity is durable within 10ms, the time of a commit to local disk.
+// Tens of milliseconds mean writes are queuing behind checkpoints or each
+// other; sustained hundreds mean persistence is falling behind traffic,
+// and logging is about to be limited to essential activities.
+var pipelineLatency = newLatencyHistogram(pipelineBuckets)
+
+func init() {
+	expvar.Publish("activity_log_pipeline_latency_seconds", expvar.Func(func() interface{} {
+		return pipelineLatency.snapshot()
+	}))
+}
+
+// latencyHistogram counts latencies per bucket, and keeps the highest of
+// the current and the previous minute
+type latencyHistogram struct {
+	bounds []float64
+
+	mu sync.Mutex
+	// counts has a count per bound and a last one for +Inf, not cumulative
+	counts []int64
+	sum    float64
+	count  int64
+	// minute is the start of the current minute; max and prevMax are the
+	// highest latencies of it and of the minute before
+	minute       time.Time
+	max, prevMax time.Duration
+}
+
+func newLatencyHistogram(bounds []float64) *latencyHistogram {
+	return &latencyHistogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+// observe records a latency measured at now
+func (h *latencyHistogram) observe(d time.Duration, now time.Time) {
+	seconds := d.Seconds()
+	i := 0
+	for i < len(h.bounds) && seconds > h.bounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+	h.rotate(now)
+	h.max = max(h.max, d)
+}
+
+// rotate moves on to the minute of now. The caller holds h.mu.
+func (h *latencyHistogram) rotate(now time.Time) {
+	minute := now.Truncate(time.Minute)
+	switch {
+	case minute.Equal(h.minute):
+		return
+	case minute.Sub(h.minute) == time.Minute:
+		h.prevMax = h.max
+	default:
+		h.prevMax = 0
+	}
+	h.minute, h.max = minute, 0
+}
+
+// recentMax returns the highest latency observed in the current or the
+// previous minute of now
+func (h *latencyHistogram) recentMax(now time.Time) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rotate(now)
+	return max(h.max, h.prevMax)
+}
+
+// snapshot returns the histogram in the shape of a Prometheus one, with
+// cumulative bucket counts keyed by their upper bound
+func (h *latencyHistogram) snapshot() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return map[string]interface{}{"buckets": cumulativeBuckets(h.bounds, h.counts), "sum": h.sum, "count": h.count}
+}
+
+// cumulativeBuckets turns counts per bucket, the last one for +Inf, into
+// the cumulative counts of a Prometheus histogram keyed by upper bound
+func cumulativeBuckets(bounds []float64, counts []int64) map[string]int64 {
+	buckets := make(map[string]int64, len(counts))
+	var cumulative int64
+	for i, n := range counts {
+		cumulative += n
+		le := "+Inf"
+		if i < len(bounds) {
+			le = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+		}
+		buckets[le] = cumulative
+	}
+	return buckets
+}
+
+// PipelineLatencyMax returns the longest an activity took to become
+// durable in the last minute or two
+func PipelineLatencyMax() time.Duration {
+	return pipelineLatency.recentMax(time.Now())
+}
@@ -0,0 +1,85 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPipelineLatencyReflectsSlowStore(t *testing.T) {
+	setupTestDB(t)
+	const delay = 60 * time.Millisecond
+	before := pipelineLatency.snapshot()
+
+	// Hold writes off the way a long checkpoint would
+	locked := make(chan struct{})
+	go func() {
+		writeGate.Lock()
+		close(locked)
+		time.Sleep(delay)
+		writeGate.Unlock()
+	}()
+	<-locked
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+
+	after := pipelineLatency.snapshot()
+	bucket := func(s map[string]interface{}, le string) int64 { return s["buckets"].(map[string]int64)[le] }
+	if n := after["count"].(int64) - before["count"].(int64); n != 1 {
+		t.Fatalf("%d latencies observed, want 1", n)
+	}
+	if n := bucket(after, "0.05") - bucket(before, "0.05"); n != 0 {
+		t.Errorf("the delayed write fell in the 50ms bucket, want it above %v", delay)
+	}
+	if n := bucket(after, "+Inf") - bucket(before, "+Inf"); n != 1 {
+		t.Errorf("+Inf bucket grew by %d, want 1", n)
+	}
+	if got := after["sum"].(float64) - before["sum"].(float64); got < delay.Seconds() {
+		t.Errorf("sum grew by %vs, want at least %v", got, delay)
+	}
+
+	stats, err := GetDBStats(context.Background())
+	if err != nil {
+		t.Fatalf("GetDBStats() failed: %v", err)
+	}
+	if stats.PipelineLatencyMaxMs < delay.Milliseconds() {
+		t.Errorf("PipelineLatencyMaxMs = %d, want at least %d", stats.PipelineLatencyMaxMs, delay.Milliseconds())
+	}
+}
+
+func TestLatencyHistogramRecentMax(t *testing.T) {
+	h := newLatencyHistogram([]float64{0.01, 0.1})
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	h.observe(30*time.Millisecond, start)
+	h.observe(5*time.Millisecond, start.Add(time.Minute))
+
+	for _, tt := range []struct {
+		at   time.Duration
+		want time.Duration
+	}{
+		{time.Minute, 30 * time.Millisecond},
+		{2 * time.Minute, 5 * time.Millisecond},
+		{4 * time.Minute, 0},
+	} {
+		if got := h.recentMax(start.Add(tt.at)); got != tt.want {
+			t.Errorf("recentMax(+%v) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+	buckets := h.snapshot()["buckets"].(map[string]int64)
+	if buckets["0.01"] != 1 || buckets["0.1"] != 2 || buckets["+Inf"] != 2 {
+		t.Errorf("buckets = %v, want cumulative counts", buckets)
+	}
+}
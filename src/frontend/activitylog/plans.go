@@ -0,0 +1,232 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// plannedQuery is a query whose plan is checked against the schema: it
+// has to mention each of expect, typically the index it is meant to use.
+// build returns the query and representative arguments, put together the
+// way the code running it does, so that changes to the filter builder are
+// checked too.
+type plannedQuery struct {
+	name   string
+	build  func() (string, []interface{})
+	expect []string
+}
+
+// filterQuery builds the SELECT of the activities matching f the way
+// GetActivities and QueryStream do
+func filterQuery(f Filter, order string) func() (string, []interface{}) {
+	return func() (string, []interface{}) {
+		where, args := f.where()
+		return `SELECT ` + activityColumns + ` FROM ` + f.from() + ` ` + where + ` ORDER BY ` + order, args
+	}
+}
+
+// activitiesName matches the name of the activities table in a query
+var activitiesName = regexp.MustCompile(`\bactivities\b`)
+
+// planDay is the arbitrary day the registered queries look at
+var planDay = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// plannedQueries are the queries that must never scan every activity
+var plannedQueries = []plannedQuery{
+	{
+		name: "activities by session",
+		build: func() (string, []interface{}) {
+			return sessionActivitiesQuery, []interface{}{"session", MaxActivities}
+		},
+		expect: []string{"idx_session"},
+	},
+	{
+		name:   "activities by time range",
+		build:  filterQuery(Filter{Start: planDay, End: planDay.Add(day)}, listOrder),
+		expect: []string{"idx_created_at"},
+	},
+	{
+		name:   "activity stream by time range",
+		build:  filterQuery(Filter{Start: planDay, End: planDay.Add(day)}, "created_at, id"),
+		expect: []string{"idx_created_at"},
+	},
+	{
+		name:   "activities by session filter",
+		build:  filterQuery(Filter{SessionID: "session"}, listOrder),
+		expect: []string{"idx_session"},
+	},
+	{
+		name:   "activities by user",
+		build:  filterQuery(Filter{UserID: "user"}, listOrder),
+		expect: []string{"idx_user_id"},
+	},
+	{
+		name:   "activities by version",
+		build:  filterQuery(Filter{Version: "1.0.0"}, listOrder),
+		expect: []string{"idx_version"},
+	},
+	{
+		name:   "activities by type",
+		build:  filterQuery(Filter{Types: []string{ActivityTypeCheckout}}, listOrder),
+		expect: []string{"idx_activity_type"},
+	},
+	{
+		name: "activity counts by time range",
+		build: func() (string, []interface{}) {
+			f := Filter{Start: planDay, End: planDay.Add(day)}
+			where, args := f.where()
+			return `SELECT activity_type, SUM(` + storedCount + `) FROM ` + f.from() + ` ` + where + ` GROUP BY activity_type`, args
+		},
+		expect: []string{"idx_created_at"},
+	},
+	{
+		name: "product views",
+		build: func() (string, []interface{}) {
+			return productViewsQuery, []interface{}{"OLJCESPC7Z", planDay, ActivityTypeProductView, SourceLoadGenerator}
+		},
+		expect: []string{"idx_product_id"},
+	},
+	{
+		name: "items of an activity",
+		build: func() (string, []interface{}) {
+			return activityItemsQuery, []interface{}{1}
+		},
+		expect: []string{"idx_activity_items_activity"},
+	},
+	{
+		name: "expired deleted activities",
+		build: func() (string, []interface{}) {
+			return expiredActivitiesQuery, []interface{}{planDay}
+		},
+		expect: []string{"idx_deleted_at"},
+	},
+	{
+		name: "activity status update",
+		build: func() (string, []interface{}) {
+			return updateStatusQuery("activities"), []interface{}{200, ResponseClassPage, 0, 1}
+		},
+		expect: []string{"INTEGER PRIMARY KEY"},
+	},
+	{
+		name: "rolled up days",
+		build: func() (string, []interface{}) {
+			return `SELECT date FROM activity_rollup_days WHERE date >= ? AND date < ?`,
+				[]interface{}{planDay.Format(dateLayout), planDay.Add(day).Format(dateLayout)}
+		},
+		expect: []string{"sqlite_autoindex_activity_rollup_days"},
+	},
+}
+
+// QueryPlan is the plan SQLite chose for a registered query
+type QueryPlan struct {
+	Name     string   `json:"name"`
+	Query    string   `json:"query"`
+	Plan     []string `json:"plan"`
+	Expected []string `json:"expected"`
+	// Problems lists how the plan falls short, such as a full table scan
+	// or an expected index going unused. It is empty for a good plan.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// ExplainQueries returns the plans of the registered queries against the
+// live schema
+func ExplainQueries() ([]QueryPlan, error) {
+	plans := make([]QueryPlan, 0, len(plannedQueries))
+	tables := activityTables()
+	for _, q := range plannedQueries {
+		query, args := q.build()
+		// The partitions of a database split by month all have the indexes
+		// of activities: the plan of the newest stands for them all, and
+		// isn't cluttered with the view's union
+		if Partitioned() {
+			query = activitiesName.ReplaceAllString(query, tables[len(tables)-1])
+		}
+		plan, err := explain(query, args)
+		if err != nil {
+			return nil, fmt.Errorf("explaining %s: %w", q.name, err)
+		}
+		plans = append(plans, QueryPlan{
+			Name:     q.name,
+			Query:    strings.Join(strings.Fields(query), " "),
+			Plan:     plan,
+			Expected: q.expect,
+			Problems: planProblems(plan, q.expect),
+		})
+	}
+	return plans, nil
+}
+
+// CheckQueryPlans logs a warning for every registered query whose plan
+// doesn't use the expected indexes
+func CheckQueryPlans(log logrus.FieldLogger) {
+	plans, err := ExplainQueries()
+	if err != nil {
+		log.WithError(err).Warn("failed to check the activity query plans")
+		return
+	}
+	for _, p := range plans {
+		for _, problem := range p.Problems {
+			log.WithFields(logrus.Fields{
+				"query": p.Name,
+				"plan":  strings.Join(p.Plan, "; "),
+			}).Warnf("activity query plan: %s", problem)
+		}
+	}
+}
+
+// explain returns the steps of the query plan, one per line
+func explain(query string, args []interface{}) ([]string, error) {
+	rows, err := GetDB().Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return nil, err
+		}
+		plan = append(plan, detail)
+	}
+	return plan, rows.Err()
+}
+
+// planProblems reports full table scans in plan and the expected strings
+// it doesn't mention
+func planProblems(plan []string, expect []string) []string {
+	var problems []string
+	for _, step := range plan {
+		// SEARCH looks rows up by index. SCAN reads every row, even when
+		// walking an index to save the sort.
+		if strings.HasPrefix(step, "SCAN ") {
+			problems = append(problems, "full table scan: "+step)
+		}
+	}
+	joined := strings.Join(plan, "\n")
+	for _, e := range expect {
+		if !strings.Contains(joined, e) {
+			problems = append(problems, "doesn't use "+e)
+		}
+	}
+	return problems
+}
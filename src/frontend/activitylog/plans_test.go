@@ -0,0 +1,80 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestRegisteredQueriesUseTheirIndexes(t *testing.T) {
+	setupTestDB(t)
+	plans, err := ExplainQueries()
+	if err != nil {
+		t.Fatalf("ExplainQueries() failed: %v", err)
+	}
+	if len(plans) != len(plannedQueries) {
+		t.Fatalf("got %d plans, want one per registered query", len(plans))
+	}
+	for _, p := range plans {
+		if len(p.Problems) > 0 {
+			t.Errorf("%s: %v, plan %q", p.Name, p.Problems, p.Plan)
+		}
+	}
+}
+
+func TestCheckQueryPlansWarnsAboutMissingIndexes(t *testing.T) {
+	setupTestDB(t)
+	if _, err := GetDB().Exec("DROP INDEX idx_session"); err != nil {
+		t.Fatalf("dropping the index failed: %v", err)
+	}
+	log, hook := test.NewNullLogger()
+	CheckQueryPlans(log)
+
+	var scans, unused int
+	for _, e := range hook.AllEntries() {
+		if e.Data["query"] != "activities by session" {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(e.Message, "activity query plan: full table scan"):
+			scans++
+		case e.Message == "activity query plan: doesn't use idx_session":
+			unused++
+		}
+	}
+	if scans != 1 || unused != 1 {
+		t.Errorf("logged %d scans and %d unused indexes for the session query, want one each: %v", scans, unused, hook.AllEntries())
+	}
+}
+
+func TestPlanProblems(t *testing.T) {
+	for _, tc := range []struct {
+		plan   []string
+		expect []string
+		want   int
+	}{
+		{[]string{"SEARCH activities USING INDEX idx_session (session_id=?)"}, []string{"idx_session"}, 0},
+		{[]string{"SCAN activities USING INDEX idx_created_at"}, []string{"idx_created_at"}, 1},
+		{[]string{"SCAN activities"}, nil, 1},
+		{[]string{"SCAN TABLE activities"}, []string{"idx_session"}, 2},
+	} {
+		if got := planProblems(tc.plan, tc.expect); len(got) != tc.want {
+			t.Errorf("planProblems(%q, %q) = %q, want %d problems", tc.plan, tc.expect, got, tc.want)
+		}
+	}
+}
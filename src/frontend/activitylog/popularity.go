@@ -0,0 +1,145 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// productViewCacheTTL is how long a product's view count is reused
+	// before it is counted again
+	productViewCacheTTL = time.Minute
+	// maxProductViewCacheSize bounds the cache; product IDs come from URLs
+	maxProductViewCacheSize = 1000
+)
+
+type productViewKey struct {
+	productID string
+	window    time.Duration
+}
+
+type productViewEntry struct {
+	count   int
+	expires time.Time
+}
+
+// productViewCache keeps recent view counts so product pages don't query
+// the database on every render
+var productViewCache = struct {
+	sync.Mutex
+	entries map[productViewKey]productViewEntry
+}{entries: make(map[productViewKey]productViewEntry)}
+
+// productViewsQuery counts the sessions that viewed a product since a time
+const productViewsQuery = `
+	SELECT COUNT(DISTINCT session_id)
+	FROM activities
+	WHERE product_id = ? AND created_at >= ?
+	  AND activity_type = ? AND COALESCE(source, '') != ? AND deleted_at IS NULL`
+
+// GetProductViewCount returns how many sessions viewed a product within the
+// window up to now. Load generator traffic doesn't count. Counts are cached
+// per product for a minute.
+func GetProductViewCount(productID string, window time.Duration) (int, error) {
+	key := productViewKey{productID, window}
+	now := Now()
+
+	productViewCache.Lock()
+	entry, ok := productViewCache.entries[key]
+	productViewCache.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.count, nil
+	}
+
+	var count int
+	err := getReadDB().QueryRow(productViewsQuery, productID, now.Add(-window).UTC(), ActivityTypeProductView, SourceLoadGenerator).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	productViewCache.Lock()
+	defer productViewCache.Unlock()
+	if len(productViewCache.entries) >= maxProductViewCacheSize {
+		for k, e := range productViewCache.entries {
+			if !now.Before(e.expires) {
+				delete(productViewCache.entries, k)
+			}
+		}
+		if len(productViewCache.entries) >= maxProductViewCacheSize {
+			productViewCache.entries = make(map[productViewKey]productViewEntry)
+		}
+	}
+	productViewCache.entries[key] = productViewEntry{count: count, expires: now.Add(productViewCacheTTL)}
+	return count, nil
+}
+
+// forgetProductViews drops the cached view counts, for changes that must
+// show right away
+func forgetProductViews() {
+	productViewCache.Lock()
+	productViewCache.entries = make(map[productViewKey]productViewEntry)
+	productViewCache.Unlock()
+}
+
+// ProductActivity is how often a product was viewed and added to carts
+type ProductActivity struct {
+	ProductID string `json:"product_id"`
+	Views     int    `json:"views"`
+	CartAdds  int    `json:"cart_adds"`
+}
+
+// GetTopProducts returns the most viewed products in a given time period,
+// at most limit of them, with how often each was added to a cart
+func GetTopProducts(ctx context.Context, startTime, endTime time.Time, limit int) ([]ProductActivity, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "top products", time.Now())
+	return topProducts(ctx, getReadDB(), startTime, endTime, limit)
+}
+
+// topProducts is GetTopProducts run by q. Callers hold an analytical query
+// slot.
+func topProducts(ctx context.Context, q queryer, startTime, endTime time.Time, limit int) ([]ProductActivity, error) {
+	query := `
+		SELECT product_id, COALESCE(SUM(activity_type = ?), 0) AS views, COALESCE(SUM(activity_type = ?), 0) AS cart_adds
+		FROM activities
+		WHERE product_id IS NOT NULL AND ` + createdIn("created_at") + ` AND deleted_at IS NULL
+		GROUP BY product_id
+		ORDER BY views DESC, cart_adds DESC, product_id
+		LIMIT ?`
+
+	rows, err := q.QueryContext(ctx, query, ActivityTypeProductView, ActivityTypeAddToCart,
+		startTime.UTC(), endTime.UTC(), boundLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []ProductActivity{}
+	for rows.Next() {
+		var p ProductActivity
+		if err := rows.Scan(&p.ProductID, &p.Views, &p.CartAdds); err != nil {
+			return nil, err
+		}
+		products = append(products, p)
+	}
+	return products, rows.Err()
+}
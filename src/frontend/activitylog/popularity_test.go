@@ -0,0 +1,98 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func logProductView(t *testing.T, sessionID, productID, source string, at time.Time) {
+	t.Helper()
+	mustLog(t, &ActivityLog{
+		SessionID:    sessionID,
+		ActivityType: ActivityTypeProductView,
+		Source:       source,
+		ProductID:    productID,
+		Details:      `{"product_id":"` + productID + `"}`,
+		CreatedAt:    at,
+	})
+}
+
+func TestGetProductViewCount(t *testing.T) {
+	fc := setupTestDB(t)
+	now := fc.Now()
+	logProductView(t, "a", "OLJCESPC7Z", SourceWeb, now.Add(-time.Hour))
+	// The same session viewing again counts once
+	logProductView(t, "a", "OLJCESPC7Z", SourceWeb, now.Add(-time.Minute))
+	logProductView(t, "b", "OLJCESPC7Z", "", now.Add(-2*time.Hour))
+	// Outside the window, another product and load generator traffic
+	logProductView(t, "c", "OLJCESPC7Z", SourceWeb, now.Add(-25*time.Hour))
+	logProductView(t, "d", "66VCHSJNUP", SourceWeb, now)
+	logProductView(t, "e", "OLJCESPC7Z", SourceLoadGenerator, now)
+
+	got, err := GetProductViewCount("OLJCESPC7Z", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("GetProductViewCount() failed: %v", err)
+	}
+	if got != 2 {
+		t.Errorf("GetProductViewCount() = %d, want 2", got)
+	}
+}
+
+func TestGetProductViewCountIsCached(t *testing.T) {
+	fc := setupTestDB(t)
+	const product = "1YMWWN1N4O"
+	logProductView(t, "a", product, SourceWeb, fc.Now())
+	if got, _ := GetProductViewCount(product, time.Hour); got != 1 {
+		t.Fatalf("GetProductViewCount() = %d, want 1", got)
+	}
+
+	logProductView(t, "b", product, SourceWeb, fc.Now())
+	if got, _ := GetProductViewCount(product, time.Hour); got != 1 {
+		t.Errorf("GetProductViewCount() = %d within the TTL, want the cached 1", got)
+	}
+	fc.Advance(productViewCacheTTL)
+	if got, _ := GetProductViewCount(product, time.Hour); got != 2 {
+		t.Errorf("GetProductViewCount() = %d after the TTL, want 2", got)
+	}
+}
+
+func TestGetTopProducts(t *testing.T) {
+	fc := setupTestDB(t)
+	now := fc.Now()
+	logProductView(t, "a", "OLJCESPC7Z", SourceWeb, now)
+	logProductView(t, "b", "OLJCESPC7Z", SourceWeb, now)
+	logProductView(t, "a", "66VCHSJNUP", SourceWeb, now)
+	logProductView(t, "a", "1YMWWN1N4O", SourceWeb, now)
+	mustLog(t, &ActivityLog{SessionID: "a", ActivityType: ActivityTypeAddToCart, ProductID: "66VCHSJNUP", CreatedAt: now})
+	// Outside the period
+	logProductView(t, "c", "1YMWWN1N4O", SourceWeb, now.Add(-2*time.Hour))
+	logProductView(t, "c", "1YMWWN1N4O", SourceWeb, now.Add(-2*time.Hour))
+
+	got, err := GetTopProducts(context.Background(), now.Add(-time.Hour), now.Add(time.Hour), 2)
+	if err != nil {
+		t.Fatalf("GetTopProducts() failed: %v", err)
+	}
+	want := []ProductActivity{
+		{ProductID: "OLJCESPC7Z", Views: 2},
+		{ProductID: "66VCHSJNUP", Views: 1, CartAdds: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetTopProducts() = %+v, want %+v", got, want)
+	}
+}
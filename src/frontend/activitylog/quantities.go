@@ -0,0 +1,217 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxQuantity is the largest quantity the product page offers
+const DefaultMaxQuantity = 10
+
+var maxQuantity = struct {
+	sync.RWMutex
+	n int
+}{n: DefaultMaxQuantity}
+
+// ConfigureMaxQuantity sets the largest plausible quantity of a cart add.
+// Larger ones are capped to it and flagged as suspicious. A non-positive
+// value restores the default.
+func ConfigureMaxQuantity(n int) {
+	if n <= 0 {
+		n = DefaultMaxQuantity
+	}
+	maxQuantity.Lock()
+	defer maxQuantity.Unlock()
+	maxQuantity.n = n
+}
+
+// MaxQuantity returns the largest plausible quantity of a cart add
+func MaxQuantity() int {
+	maxQuantity.RLock()
+	defer maxQuantity.RUnlock()
+	return maxQuantity.n
+}
+
+// quantityDetails returns the details of a cart add of quantity, as sent:
+// the quantity as an integer, capped to between 0 and MaxQuantity, and
+// whether it is suspicious, being out of range, as zero and negative
+// quantities are.
+// Quantities that aren't numbers only keep what was sent.
+func quantityDetails(quantity string) AddToCartDetails {
+	details := AddToCartDetails{RawQuantity: quantity}
+	if quantity == "" {
+		return details
+	}
+	digits := strings.TrimPrefix(quantity, "-")
+	if digits == "" {
+		return details
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return details
+		}
+	}
+	n, err := strconv.Atoi(quantity)
+	if err != nil {
+		// Only digits, so too large for an int
+		n = math.MaxInt
+		if digits != quantity {
+			n = math.MinInt
+		}
+	}
+	limit := MaxQuantity()
+	details.Suspicious = n < 1 || n > limit
+	n = max(min(n, limit), 0)
+	details.Quantity = &n
+	return details
+}
+
+// QuantityDistribution is how many items cart adds added
+type QuantityDistribution struct {
+	// Quantities are the number of cart adds of each plausible quantity,
+	// smallest first
+	Quantities []QuantityCount `json:"quantities"`
+	// Suspicious is the number of cart adds with an out of range quantity,
+	// which Quantities leaves out
+	Suspicious int `json:"suspicious"`
+	// Unknown is the number of cart adds without a numeric quantity, such
+	// as those logged before quantities were recorded as numbers and not
+	// backfilled yet
+	Unknown int `json:"unknown"`
+}
+
+// QuantityCount is the number of cart adds of a quantity
+type QuantityCount struct {
+	Quantity int `json:"quantity"`
+	Count    int `json:"count"`
+}
+
+// GetQuantityDistribution returns the histogram of the quantities of the
+// cart adds in a given time period
+func GetQuantityDistribution(ctx context.Context, startTime, endTime time.Time) (*QuantityDistribution, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "quantity distribution", time.Now())
+
+	query := `
+		SELECT json_extract(details, '$.quantity_int') AS quantity,
+			   COALESCE(json_extract(details, '$.suspicious'), 0) AS suspicious,
+			   COUNT(*)
+		FROM (
+			SELECT CASE WHEN json_valid(` + detailsJSON + `) THEN ` + detailsJSON + ` END AS details
+			FROM activities
+			WHERE activity_type = ? AND ` + createdIn("created_at") + ` AND deleted_at IS NULL
+		)
+		GROUP BY quantity, suspicious
+		ORDER BY quantity`
+	rows, err := getReadDB().QueryContext(ctx, query, ActivityTypeAddToCart, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dist := &QuantityDistribution{Quantities: []QuantityCount{}}
+	for rows.Next() {
+		var quantity *int
+		var suspicious bool
+		var count int
+		if err := rows.Scan(&quantity, &suspicious, &count); err != nil {
+			return nil, err
+		}
+		switch {
+		case quantity == nil:
+			dist.Unknown += count
+		case suspicious:
+			dist.Suspicious += count
+		default:
+			dist.Quantities = append(dist.Quantities, QuantityCount{Quantity: *quantity, Count: count})
+		}
+	}
+	return dist, rows.Err()
+}
+
+// backfillQuantitiesQuery records the quantity of the cart adds in a range
+// of IDs as an integer, like quantityDetails does, from the quantity they
+// were logged with. Quantities too long for the format check were moved to
+// raw_invalid, so they are read from there. Details that aren't JSON,
+// quantities that aren't numbers and cart adds that already have one are
+// left alone.
+func backfillQuantitiesQuery(table string) string {
+	return `
+	UPDATE ` + table + ` SET details = CASE
+		WHEN batch.quantity < 1 OR batch.quantity > ?
+		THEN json_set(batch.details, '$.quantity_int', MAX(MIN(batch.quantity, ?), 0), '$.suspicious', json('true'))
+		ELSE json_set(batch.details, '$.quantity_int', batch.quantity)
+	END
+	FROM (
+		SELECT id, details, CAST(raw AS INTEGER) AS quantity,
+			   CASE WHEN raw GLOB '-*' THEN substr(raw, 2) ELSE raw END AS digits
+		FROM (
+			SELECT id, details,
+				   COALESCE(json_extract(details, '$.quantity'), json_extract(details, '$.raw_invalid.quantity')) AS raw
+			FROM (
+				SELECT id, CASE WHEN json_valid(` + detailsJSON + `) THEN ` + detailsJSON + ` END AS details
+				FROM ` + table + `
+				WHERE id > ? AND id <= ? AND activity_type = ?
+			)
+			WHERE json_extract(details, '$.quantity_int') IS NULL
+		)
+		WHERE typeof(raw) = 'text' AND digits != '' AND digits NOT GLOB '*[^0-9]*'
+	) AS batch
+	WHERE ` + table + `.id = batch.id`
+}
+
+// BackfillQuantities records the quantity of the cart adds logged before
+// quantities were recorded as integers, in batches like BackfillProducts,
+// calling progress after each. It can be run again: cart adds that already
+// have one are skipped.
+func BackfillQuantities(ctx context.Context, progress func(BackfillProgress)) (BackfillProgress, error) {
+	var p BackfillProgress
+	if err := GetDB().QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM activities").Scan(&p.MaxID); err != nil {
+		return p, err
+	}
+	limit := MaxQuantity()
+	for p.LastID < p.MaxID {
+		if err := ctx.Err(); err != nil {
+			return p, err
+		}
+		next := min(p.LastID+backfillBatchSize, p.MaxID)
+		start := time.Now()
+		n, err := execEachTable(ctx, GetDB(), backfillQuantitiesQuery, limit, limit, p.LastID, next, ActivityTypeAddToCart)
+		observeQuery(ctx, "backfill quantities", start)
+		if err != nil {
+			return p, err
+		}
+		p.LastID = next
+		p.Updated += n
+		if progress != nil && p.LastID < p.MaxID {
+			progress(p)
+		}
+	}
+	p.Done = true
+	if progress != nil {
+		progress(p)
+	}
+	return p, nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareRecordsQuantities(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	for _, tc := range []struct {
+		quantity       string
+		wantInt        interface{}
+		wantSuspicious bool
+	}{
+		{"3", float64(3), false},
+		{"10", float64(10), false},
+		{"999999", float64(DefaultMaxQuantity), true},
+		{"99999999999999999999999", float64(DefaultMaxQuantity), true},
+		{"0", float64(0), true},
+		{"-1", float64(0), true},
+		{"-99999999999999999999999", float64(0), true},
+		{"-", nil, false},
+		{"two", nil, false},
+		{"", nil, false},
+	} {
+		serve(router, postForm("/cart", "product_id=OLJCESPC7Z&quantity="+tc.quantity), "session-1")
+		details := detailsOf(t, lastActivity(t))
+		if got := details["quantity_int"]; got != tc.wantInt {
+			t.Errorf("quantity %q: quantity_int = %v, want %v", tc.quantity, got, tc.wantInt)
+		}
+		if got := details["suspicious"] == true; got != tc.wantSuspicious {
+			t.Errorf("quantity %q: suspicious = %v, want %v", tc.quantity, got, tc.wantSuspicious)
+		}
+	}
+}
+
+func TestConfigureMaxQuantity(t *testing.T) {
+	setupTestDB(t)
+	ConfigureMaxQuantity(100)
+	t.Cleanup(func() { ConfigureMaxQuantity(0) })
+
+	if d := quantityDetails("50"); d.Quantity == nil || *d.Quantity != 50 || d.Suspicious {
+		t.Errorf("quantityDetails(50) = %+v with a maximum of 100, want 50", d)
+	}
+	if d := quantityDetails("101"); d.Quantity == nil || *d.Quantity != 100 || !d.Suspicious {
+		t.Errorf("quantityDetails(101) = %+v with a maximum of 100, want 100, suspicious", d)
+	}
+	ConfigureMaxQuantity(-1)
+	if n := MaxQuantity(); n != DefaultMaxQuantity {
+		t.Errorf("MaxQuantity() = %d after configuring -1, want the default %d", n, DefaultMaxQuantity)
+	}
+}
+
+func TestGetQuantityDistribution(t *testing.T) {
+	fc := setupTestDB(t)
+	for _, details := range []string{
+		`{"quantity":"1","quantity_int":1}`,
+		`{"quantity":"1","quantity_int":1}`,
+		`{"quantity":"3","quantity_int":3}`,
+		`{"raw_invalid":{"quantity":"999999"},"quantity_int":10,"suspicious":true}`,
+		`{"quantity":"0","quantity_int":0,"suspicious":true}`,
+		`{"quantity":"2"}`,
+	} {
+		mustLog(t, &ActivityLog{SessionID: "s", ActivityType: ActivityTypeAddToCart, Details: details, CreatedAt: fc.Now()})
+	}
+	mustLog(t, &ActivityLog{SessionID: "s", ActivityType: ActivityTypePageView, Details: `{"quantity_int":5}`, CreatedAt: fc.Now()})
+	mustLog(t, &ActivityLog{SessionID: "s", ActivityType: ActivityTypeAddToCart, Details: `{"quantity_int":7}`, CreatedAt: fc.Now().Add(-2 * time.Hour)})
+
+	dist, err := GetQuantityDistribution(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetQuantityDistribution() failed: %v", err)
+	}
+	want := &QuantityDistribution{
+		Quantities: []QuantityCount{{Quantity: 1, Count: 2}, {Quantity: 3, Count: 1}},
+		Suspicious: 2,
+		Unknown:    1,
+	}
+	if !reflect.DeepEqual(dist, want) {
+		t.Errorf("GetQuantityDistribution() = %+v, want %+v", dist, want)
+	}
+}
+
+func TestBackfillQuantities(t *testing.T) {
+	fc := setupTestDB(t)
+	// Logged before quantities were recorded as integers
+	for _, a := range []ActivityLog{
+		{SessionID: "valid", ActivityType: ActivityTypeAddToCart, Details: `{"product_id":"OLJCESPC7Z","quantity":"4"}`},
+		{SessionID: "fuzzed", ActivityType: ActivityTypeAddToCart, Details: `{"raw_invalid":{"quantity":"999999"}}`},
+		{SessionID: "zero", ActivityType: ActivityTypeAddToCart, Details: `{"quantity":"0"}`},
+		{SessionID: "negative", ActivityType: ActivityTypeAddToCart, Details: `{"raw_invalid":{"quantity":"-2"}}`},
+		{SessionID: "dash", ActivityType: ActivityTypeAddToCart, Details: `{"quantity":"-"}`},
+		{SessionID: "garbage", ActivityType: ActivityTypeAddToCart, Details: `{"raw_invalid":{"quantity":"1&#34;&gt;"}}`},
+		{SessionID: "malformed", ActivityType: ActivityTypeAddToCart, Details: `{"quantity":`},
+		{SessionID: "view", ActivityType: ActivityTypeProductView, Details: `{"quantity":"2"}`},
+		{SessionID: "new", ActivityType: ActivityTypeAddToCart, Details: `{"quantity":"5","quantity_int":5}`},
+	} {
+		a.CreatedAt = fc.Now()
+		mustLog(t, &a)
+	}
+
+	p, err := BackfillQuantities(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("BackfillQuantities() failed: %v", err)
+	}
+	if p.Updated != 4 || !p.Done {
+		t.Errorf("BackfillQuantities() = %+v, want 4 updated", p)
+	}
+
+	dist, err := GetQuantityDistribution(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetQuantityDistribution() failed: %v", err)
+	}
+	want := &QuantityDistribution{
+		Quantities: []QuantityCount{{Quantity: 4, Count: 1}, {Quantity: 5, Count: 1}},
+		Suspicious: 3,
+		Unknown:    3,
+	}
+	if !reflect.DeepEqual(dist, want) {
+		t.Errorf("GetQuantityDistribution() after the backfill = %+v, want %+v", dist, want)
+	}
+
+	// Running it again changes nothing
+	if p, err := BackfillQuantities(context.Background(), nil); err != nil || p.Updated != 0 {
+		t.Errorf("BackfillQuantities() again = %+v, %v, want nothing updated", p, err)
+	}
+}
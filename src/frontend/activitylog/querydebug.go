@@ -0,0 +1,229 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// QueryList selects the activities matching a filter, like GET
+	// /activities
+	QueryList = "list"
+	// QueryStats counts them per type, like GET /activities/stats
+	QueryStats = "stats"
+
+	// analysisLimit bounds the rows ANALYZE samples in each index, so that
+	// it stays quick on a large database
+	analysisLimit = 1000
+	// redactedArg stands for the session IDs bound to a query
+	redactedArg = "[redacted]"
+)
+
+// DebugQuery is a query run to answer a request, with how SQLite plans to
+// run it, for reproducing slow requests without running them
+type DebugQuery struct {
+	Name string `json:"name"`
+	// SQL has placeholders for Args, in which session IDs are redacted
+	SQL  string        `json:"sql"`
+	Args []interface{} `json:"args"`
+	Plan []string      `json:"plan"`
+	// Estimates are what the last ANALYZE recorded about the indexes and
+	// tables the plan uses, empty if it never ran
+	Estimates []IndexEstimate `json:"estimates,omitempty"`
+}
+
+// IndexEstimate is what ANALYZE recorded about an index, or a table when
+// Index is empty: how many rows it has, and how many rows share a value of
+// its first column on average
+type IndexEstimate struct {
+	Table      string `json:"table"`
+	Index      string `json:"index,omitempty"`
+	Rows       int64  `json:"rows"`
+	RowsPerKey int64  `json:"rows_per_key,omitempty"`
+}
+
+// ExplainFilterQueries returns the queries a request of the given kind,
+// QueryList or QueryStats, runs for the filter, without running them
+func ExplainFilterQueries(ctx context.Context, kind string, filter Filter, limit int) ([]DebugQuery, error) {
+	if GetDB() == nil {
+		return nil, ErrNotInitialized
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	type built struct {
+		name  string
+		query string
+		args  []interface{}
+	}
+	var queries []built
+	switch kind {
+	case QueryList:
+		query, args := listQuery(filter, limit)
+		queries = append(queries, built{"activities", query, args})
+	case QueryStats:
+		rolled, err := rolledUpDays(ctx, getReadDB(), filter)
+		if err != nil {
+			return nil, err
+		}
+		if len(rolled) > 0 {
+			query, args := rollupStatsQuery(filter, rolled)
+			queries = append(queries, built{"rolled up days", query, args})
+		}
+		query, args := statsQuery(filter, rolled)
+		queries = append(queries, built{"raw activities", query, args})
+	default:
+		return nil, fmt.Errorf("activitylog: unknown query %q, want %q or %q", kind, QueryList, QueryStats)
+	}
+
+	stats, err := analyzedStats()
+	if err != nil {
+		return nil, err
+	}
+	debug := make([]DebugQuery, 0, len(queries))
+	for _, q := range queries {
+		plan, err := explain(q.query, q.args)
+		if err != nil {
+			return nil, fmt.Errorf("explaining %s: %w", q.name, err)
+		}
+		debug = append(debug, DebugQuery{
+			Name:      q.name,
+			SQL:       strings.Join(strings.Fields(q.query), " "),
+			Args:      redactSessions(filter, q.args),
+			Plan:      plan,
+			Estimates: estimatesFor(plan, stats),
+		})
+	}
+	return debug, nil
+}
+
+// redactSessions replaces the session IDs of the filter among args
+func redactSessions(filter Filter, args []interface{}) []interface{} {
+	sessions := map[string]bool{filter.SessionID: filter.SessionID != ""}
+	for _, id := range filter.SessionIDs {
+		sessions[id] = true
+	}
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		if s, ok := arg.(string); ok && sessions[s] {
+			arg = redactedArg
+		}
+		redacted[i] = arg
+	}
+	return redacted
+}
+
+// planIndex finds the indexes, and the tables looked up by rowid, in the
+// steps of a plan
+var planIndex = regexp.MustCompile(`^(?:SEARCH|SCAN) (\S+)(?: USING (?:COVERING )?INDEX (\S+)| USING INTEGER PRIMARY KEY)?`)
+
+// estimatesFor picks the estimates of the indexes and tables plan uses
+func estimatesFor(plan []string, stats []IndexEstimate) []IndexEstimate {
+	var estimates []IndexEstimate
+	for _, step := range plan {
+		m := planIndex.FindStringSubmatch(step)
+		if m == nil {
+			continue
+		}
+		for _, s := range stats {
+			if s.Index == m[2] && (m[2] != "" || s.Table == m[1]) {
+				estimates = append(estimates, s)
+			}
+		}
+	}
+	return estimates
+}
+
+// analyzedStats reads sqlite_stat1, which ANALYZE fills in and the planner
+// estimates row counts from. It is empty until ANALYZE runs.
+func analyzedStats() ([]IndexEstimate, error) {
+	var exists int
+	if err := GetDB().QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'sqlite_stat1'").Scan(&exists); err != nil || exists == 0 {
+		return nil, err
+	}
+	rows, err := GetDB().Query("SELECT tbl, COALESCE(idx, ''), stat FROM sqlite_stat1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stats []IndexEstimate
+	for rows.Next() {
+		var s IndexEstimate
+		var stat string
+		if err := rows.Scan(&s.Table, &s.Index, &stat); err != nil {
+			return nil, err
+		}
+		// The row count, then the average number of rows per value of the
+		// first column, of the first two, and so on
+		fields := strings.Fields(stat)
+		if len(fields) > 0 {
+			s.Rows, _ = strconv.ParseInt(fields[0], 10, 64)
+		}
+		if len(fields) > 1 {
+			s.RowsPerKey, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// lastAnalyze is when ANALYZE last ran
+var lastAnalyze = struct {
+	sync.Mutex
+	at time.Time
+}{}
+
+// Analyze refreshes the statistics the query planner estimates row counts
+// from, sampling each index, and returns how long it took. Without them the
+// planner guesses.
+func Analyze(ctx context.Context) (time.Duration, error) {
+	if GetDB() == nil {
+		return 0, ErrNotInitialized
+	}
+	if ReadOnly() {
+		return 0, ErrReadOnly
+	}
+	start := time.Now()
+	conn, err := GetDB().Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA analysis_limit = %d", analysisLimit)); err != nil {
+		return 0, err
+	}
+	if _, err := conn.ExecContext(ctx, "ANALYZE"); err != nil {
+		return 0, err
+	}
+	lastAnalyze.Lock()
+	lastAnalyze.at = Now()
+	lastAnalyze.Unlock()
+	return time.Since(start), nil
+}
+
+// LastAnalyze returns when ANALYZE last ran, zero if it hasn't since the
+// process started
+func LastAnalyze() time.Time {
+	lastAnalyze.Lock()
+	defer lastAnalyze.Unlock()
+	return lastAnalyze.at
+}
@@ -0,0 +1,161 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SQL the filters generate is spelled out so that changes to the
+// WHERE builder show up here, not as different results
+func TestExplainFilterQueriesSQL(t *testing.T) {
+	setupTestDB(t)
+	june := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	const stats = "SELECT activity_type, SUM(" + storedCount + ") as count FROM activities WHERE deleted_at IS NULL"
+	for _, tc := range []struct {
+		name     string
+		filter   Filter
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "everything",
+			wantSQL:  stats + " GROUP BY activity_type",
+			wantArgs: []interface{}{},
+		},
+		{
+			name:     "time range",
+			filter:   Filter{Start: june, End: june.Add(day)},
+			wantSQL:  stats + " AND created_at >= ? AND created_at < ? GROUP BY activity_type",
+			wantArgs: []interface{}{june, june.Add(day)},
+		},
+		{
+			name:     "session and types",
+			filter:   Filter{SessionID: "s1", Types: []string{ActivityTypeCheckout, ActivityTypeAddToCart}},
+			wantSQL:  stats + " AND activity_type IN (?, ?) AND session_id = ? GROUP BY activity_type",
+			wantArgs: []interface{}{ActivityTypeCheckout, ActivityTypeAddToCart, redactedArg},
+		},
+		{
+			name:     "excluded type, path and statuses",
+			filter:   Filter{TypesNot: []string{ActivityTypePageView}, PathPrefix: "/product", StatusClasses: []int{4, 5}},
+			wantSQL:  stats + " AND activity_type NOT IN (?) AND substr(path, 1, ?) = ? AND status_code / 100 IN (?, ?) GROUP BY activity_type",
+			wantArgs: []interface{}{ActivityTypePageView, 8, "/product", 4, 5},
+		},
+		{
+			name:   "experiment and tag",
+			filter: Filter{Experiment: "e", Variant: "v", Tags: map[string]string{"tier": "gold"}},
+			wantSQL: stats + " AND json_extract(" + detailsJSON + ", ?) = ? AND json_extract(" + detailsJSON + ", ?) = ?" +
+				" GROUP BY activity_type",
+			wantArgs: []interface{}{"$.experiments.e", "v", `$.tags."tier"`, "gold"},
+		},
+		{
+			name:     "sessions, user, source and version",
+			filter:   Filter{SessionIDs: []string{"a", "b"}, UserID: "u", Source: SourceLoadGenerator, Version: "1.0"},
+			wantSQL:  stats + " AND session_id IN (?, ?) AND user_id = ? AND source = ? AND version = ? GROUP BY activity_type",
+			wantArgs: []interface{}{redactedArg, redactedArg, "u", SourceLoadGenerator, "1.0"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			queries, err := ExplainFilterQueries(context.Background(), QueryStats, tc.filter, 0)
+			if err != nil {
+				t.Fatalf("ExplainFilterQueries() failed: %v", err)
+			}
+			if len(queries) != 1 {
+				t.Fatalf("ExplainFilterQueries() = %+v, want the raw activities query", queries)
+			}
+			q := queries[0]
+			if q.SQL != tc.wantSQL {
+				t.Errorf("SQL =\n%s\nwant\n%s", q.SQL, tc.wantSQL)
+			}
+			if !reflect.DeepEqual(q.Args, tc.wantArgs) {
+				t.Errorf("Args = %#v, want %#v", q.Args, tc.wantArgs)
+			}
+			if len(q.Plan) == 0 {
+				t.Error("Plan is empty")
+			}
+		})
+	}
+}
+
+func TestExplainFilterQueriesList(t *testing.T) {
+	setupTestDB(t)
+	queries, err := ExplainFilterQueries(context.Background(), QueryList, Filter{SessionID: "s1"}, 20)
+	if err != nil {
+		t.Fatalf("ExplainFilterQueries() failed: %v", err)
+	}
+	want := "SELECT " + strings.Join(strings.Fields(activityColumns), " ") +
+		" FROM activities WHERE deleted_at IS NULL AND session_id = ? ORDER BY " + listOrder + " LIMIT ?"
+	if len(queries) != 1 || queries[0].SQL != want {
+		t.Fatalf("ExplainFilterQueries() = %+v, want %s", queries, want)
+	}
+	if args := queries[0].Args; !reflect.DeepEqual(args, []interface{}{redactedArg, 20}) {
+		t.Errorf("Args = %#v, want the session redacted and the limit", args)
+	}
+	if plan := strings.Join(queries[0].Plan, "\n"); !strings.Contains(plan, "idx_session") {
+		t.Errorf("Plan = %q, want idx_session used", plan)
+	}
+
+	if _, err := ExplainFilterQueries(context.Background(), "export", Filter{}, 0); err == nil {
+		t.Error("ExplainFilterQueries() of an unknown query succeeded")
+	}
+}
+
+func TestExplainFilterQueriesRollups(t *testing.T) {
+	fc := setupTestDB(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, CreatedAt: fc.Now().Add(-2 * day)})
+	if _, err := CatchUpRollups(context.Background()); err != nil {
+		t.Fatalf("CatchUpRollups() failed: %v", err)
+	}
+	queries, err := ExplainFilterQueries(context.Background(), QueryStats, Filter{Start: truncateDay(fc.Now()).Add(-3 * day), End: fc.Now()}, 0)
+	if err != nil {
+		t.Fatalf("ExplainFilterQueries() failed: %v", err)
+	}
+	if len(queries) != 2 || !strings.Contains(queries[0].SQL, "FROM activity_rollups") {
+		t.Errorf("ExplainFilterQueries() = %+v, want the roll-ups queried first", queries)
+	}
+}
+
+func TestAnalyzeRecordsEstimates(t *testing.T) {
+	setupTestDB(t)
+	for _, session := range []string{"s1", "s2", "s3", "s4", "s5"} {
+		mustLog(t, &ActivityLog{SessionID: session, ActivityType: ActivityTypePageView})
+	}
+	queries, err := ExplainFilterQueries(context.Background(), QueryList, Filter{SessionID: "s1"}, 0)
+	if err != nil {
+		t.Fatalf("ExplainFilterQueries() failed: %v", err)
+	}
+	if len(queries[0].Estimates) != 0 {
+		t.Errorf("Estimates = %+v before ANALYZE, want none", queries[0].Estimates)
+	}
+
+	if _, err := Analyze(context.Background()); err != nil {
+		t.Fatalf("Analyze() failed: %v", err)
+	}
+	if LastAnalyze().IsZero() {
+		t.Error("LastAnalyze() is zero after Analyze()")
+	}
+	queries, err = ExplainFilterQueries(context.Background(), QueryList, Filter{SessionID: "s1"}, 0)
+	if err != nil {
+		t.Fatalf("ExplainFilterQueries() failed: %v", err)
+	}
+	want := []IndexEstimate{{Table: "activities", Index: "idx_session", Rows: 5, RowsPerKey: 1}}
+	if got := queries[0].Estimates; !reflect.DeepEqual(got, want) {
+		t.Errorf("Estimates = %+v after ANALYZE, want %+v", got, want)
+	}
+}
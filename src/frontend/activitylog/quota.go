@@ -0,0 +1,209 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ActivityTypeSuppressed stands for the activities of a session over its
+// quota: one row per session and hour, with details.count the activities
+// it replaces and details.types their count per type. Stats count it as
+// that many activities.
+var ActivityTypeSuppressed = RegisterActivityType("suppressed", "Activities of a session over its quota, counted per hour rather than stored")
+
+// storedCount is how many activities a row stands for: those it replaces
+// for a suppressed activity, one otherwise
+const storedCount = "CASE WHEN activity_type = 'suppressed' THEN COALESCE(json_extract(" + detailsJSON + ", '$.count'), 1) ELSE 1 END"
+
+// Session quota modes: what becomes of the activities of a session over
+// its quota
+const (
+	// QuotaDrop drops them, counting them only in expvar
+	QuotaDrop = "drop"
+	// QuotaAggregate counts them in the session's suppressed activity of
+	// the hour
+	QuotaAggregate = "aggregate"
+)
+
+// maxQuotaSessions bounds the sessions whose stored activities are counted
+// in memory. Beyond it the counts are forgotten and read again from
+// session_counters.
+const maxQuotaSessions = 100000
+
+// ErrSessionQuota is returned by LogActivity for an activity that wasn't
+// stored because its session is over its quota. In QuotaAggregate mode it
+// was counted in the session's suppressed activity.
+var ErrSessionQuota = errors.New("activitylog: session over its activity quota")
+
+// quotaSuppressed counts the activities over a session quota, per mode
+var quotaSuppressed = expvar.NewMap("activity_log_quota_suppressed_total")
+
+// sessionQuota caps the activities stored per session. rows counts the
+// stored activities of the sessions written to, read once from
+// session_counters and kept up to date in memory from then on, so that
+// the cap costs no query per write. Deleted activities aren't taken off:
+// a session whose activities were purged stays capped until its count is
+// forgotten.
+var sessionQuota = struct {
+	sync.Mutex
+	maxRows int
+	mode    string
+	rows    map[string]int
+}{mode: QuotaAggregate, rows: make(map[string]int)}
+
+// suppressWrites serializes the updates of suppressed activities, so that
+// two writes over the quota don't both create the row of the hour
+var suppressWrites sync.Mutex
+
+// ConfigureSessionQuota caps the activities stored per session at maxRows,
+// beyond which they are dropped or aggregated per mode. Checkouts and
+// server errors are always stored. A non-positive maxRows lifts the cap;
+// a mode other than QuotaDrop aggregates.
+func ConfigureSessionQuota(maxRows int, mode string) {
+	if mode != QuotaDrop {
+		mode = QuotaAggregate
+	}
+	sessionQuota.Lock()
+	defer sessionQuota.Unlock()
+	sessionQuota.maxRows = max(maxRows, 0)
+	sessionQuota.mode = mode
+	sessionQuota.rows = make(map[string]int)
+}
+
+// SessionQuota returns the activities stored per session at most, 0 when
+// unlimited, and what becomes of those over it
+func SessionQuota() (maxRows int, mode string) {
+	sessionQuota.Lock()
+	defer sessionQuota.Unlock()
+	return sessionQuota.maxRows, sessionQuota.mode
+}
+
+// forgetSessionRows forgets the counted activities of every session, for
+// a database switched to
+func forgetSessionRows() {
+	sessionQuota.Lock()
+	defer sessionQuota.Unlock()
+	sessionQuota.rows = make(map[string]int)
+}
+
+// overSessionQuota tells whether activity is beyond its session's quota
+func overSessionQuota(ctx context.Context, activity *ActivityLog) (bool, error) {
+	maxRows, _ := SessionQuota()
+	if maxRows == 0 || activity.SessionID == "" || essential(activity) {
+		return false, nil
+	}
+	sessionQuota.Lock()
+	n, ok := sessionQuota.rows[activity.SessionID]
+	sessionQuota.Unlock()
+	if !ok {
+		err := getReadDB().QueryRowContext(ctx,
+			"SELECT activities FROM session_counters WHERE session_id = ?",
+			activity.SessionID).Scan(&n)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return false, err
+		}
+		sessionQuota.Lock()
+		if len(sessionQuota.rows) >= maxQuotaSessions {
+			sessionQuota.rows = make(map[string]int)
+		}
+		// Writes counted while the count was read are already in it
+		n = max(n, sessionQuota.rows[activity.SessionID])
+		sessionQuota.rows[activity.SessionID] = n
+		sessionQuota.Unlock()
+	}
+	return n >= maxRows, nil
+}
+
+// countSessionRow counts an activity stored for sessionID
+func countSessionRow(sessionID string) {
+	sessionQuota.Lock()
+	defer sessionQuota.Unlock()
+	if _, ok := sessionQuota.rows[sessionID]; ok {
+		sessionQuota.rows[sessionID]++
+	}
+}
+
+// suppressActivity disposes of an activity over its session's quota, and
+// returns ErrSessionQuota once it has
+func suppressActivity(ctx context.Context, activity *ActivityLog, createdAt time.Time) error {
+	_, mode := SessionQuota()
+	quotaSuppressed.Add(mode, 1)
+	if mode == QuotaDrop {
+		return ErrSessionQuota
+	}
+
+	done := beginWrite()
+	start := time.Now()
+	err := addSuppressed(ctx, activity, createdAt)
+	done()
+	observeQuery(ctx, "suppress activity", start)
+	recordWrite(err)
+	noteWrite(err)
+	if err != nil {
+		return err
+	}
+	return ErrSessionQuota
+}
+
+// addSuppressed counts activity in the suppressed activity of its session
+// and hour, creating it for the hour's first
+func addSuppressed(ctx context.Context, activity *ActivityLog, createdAt time.Time) error {
+	hour := createdAt.Truncate(time.Hour)
+	table, err := partitionFor(ctx, hour)
+	if err != nil {
+		return err
+	}
+	typePath := `$.types."` + strings.ReplaceAll(activity.ActivityType, `"`, "") + `"`
+
+	suppressWrites.Lock()
+	defer suppressWrites.Unlock()
+	res, err := GetDB().ExecContext(ctx, `
+		UPDATE `+table+`
+		SET details = json_set(`+detailsJSON+`,
+			'$.count', COALESCE(json_extract(`+detailsJSON+`, '$.count'), 0) + 1,
+			?, COALESCE(json_extract(`+detailsJSON+`, ?), 0) + 1)
+		WHERE session_id = ? AND activity_type = ? AND created_at = ? AND deleted_at IS NULL`,
+		typePath, typePath, activity.SessionID, ActivityTypeSuppressed, hour)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	suppressed := &ActivityLog{
+		SessionID:    activity.SessionID,
+		UserID:       activity.UserID,
+		ActivityType: ActivityTypeSuppressed,
+		Source:       activity.Source,
+		Version:      activity.Version,
+		Revision:     activity.Revision,
+		FlagsHash:    activity.FlagsHash,
+	}
+	encodeDetails(suppressed, map[string]interface{}{
+		"count": 1,
+		"types": map[string]int{activity.ActivityType: 1},
+	})
+	// Suppressed activities stand for others, which aren't forwarded
+	_, err = insertRows(ctx, suppressed, hour, false)
+	return err
+}
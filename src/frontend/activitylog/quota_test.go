@@ -0,0 +1,170 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+)
+
+// limitSessions caps the activities stored per session for the test
+func limitSessions(t *testing.T, maxRows int, mode string) {
+	t.Helper()
+	ConfigureSessionQuota(maxRows, mode)
+	t.Cleanup(func() { ConfigureSessionQuota(0, "") })
+}
+
+// suppressedRows returns the details of the suppressed activities of a
+// session, oldest first
+func suppressedRows(t *testing.T, sessionID string) []map[string]interface{} {
+	t.Helper()
+	got, err := GetActivities(Filter{SessionID: sessionID, Types: []string{ActivityTypeSuppressed}}, 100)
+	if err != nil {
+		t.Fatalf("GetActivities() failed: %v", err)
+	}
+	rows := make([]map[string]interface{}, len(got))
+	for i, a := range got {
+		rows[len(got)-1-i] = detailsOf(t, a)
+	}
+	return rows
+}
+
+func TestSessionQuotaAggregatesOverflow(t *testing.T) {
+	fc := setupTestDB(t)
+	limitSessions(t, 3, QuotaAggregate)
+
+	for i := 0; i < 3; i++ {
+		mustLog(t, &ActivityLog{SessionID: "hot", ActivityType: ActivityTypeProductView})
+	}
+	mustLog(t, &ActivityLog{SessionID: "calm", ActivityType: ActivityTypeProductView})
+	over := []string{
+		ActivityTypeProductView, ActivityTypeProductView, ActivityTypeAddToCart,
+		ActivityTypeProductView, ActivityTypeProductView,
+	}
+	for _, typ := range over {
+		fc.Advance(time.Minute)
+		err := LogActivity(&ActivityLog{SessionID: "hot", ActivityType: typ, Path: "/"})
+		if !errors.Is(err, ErrSessionQuota) {
+			t.Fatalf("LogActivity(%s) over the quota = %v, want ErrSessionQuota", typ, err)
+		}
+	}
+	// Checkouts are kept whatever the quota
+	mustLog(t, &ActivityLog{SessionID: "hot", ActivityType: ActivityTypeCheckout})
+	fc.Advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		if err := LogActivity(&ActivityLog{SessionID: "hot", ActivityType: ActivityTypeProductView, Path: "/"}); !errors.Is(err, ErrSessionQuota) {
+			t.Fatalf("LogActivity() the next hour = %v, want ErrSessionQuota", err)
+		}
+	}
+
+	rows := suppressedRows(t, "hot")
+	if len(rows) != 2 {
+		t.Fatalf("%d suppressed activities, want one per hour: %v", len(rows), rows)
+	}
+	if rows[0]["count"] != 5.0 {
+		t.Errorf("first hour count = %v, want 5", rows[0]["count"])
+	}
+	types, _ := rows[0]["types"].(map[string]interface{})
+	if types[ActivityTypeProductView] != 4.0 || types[ActivityTypeAddToCart] != 1.0 {
+		t.Errorf("first hour types = %v, want 4 product views and 1 cart add", rows[0]["types"])
+	}
+	if rows[1]["count"] != 2.0 {
+		t.Errorf("second hour count = %v, want 2", rows[1]["count"])
+	}
+	// Three views, a checkout and the two suppressed rows, and the calm
+	// session's view
+	if n := countActivities(t); n != 7 {
+		t.Errorf("%d activities stored, want 7", n)
+	}
+
+	stats, err := GetActivityStats(context.Background(), Filter{})
+	if err != nil {
+		t.Fatalf("GetActivityStats() failed: %v", err)
+	}
+	if stats[ActivityTypeSuppressed] != 7 || stats[ActivityTypeProductView] != 4 || stats[ActivityTypeCheckout] != 1 {
+		t.Errorf("stats = %v, want 7 suppressed, 4 product views and 1 checkout", stats)
+	}
+}
+
+// droppedOverQuota returns how many activities were dropped over a quota
+func droppedOverQuota() int64 {
+	if n, ok := quotaSuppressed.Get(QuotaDrop).(*expvar.Int); ok {
+		return n.Value()
+	}
+	return 0
+}
+
+func TestSessionQuotaDrop(t *testing.T) {
+	setupTestDB(t)
+	limitSessions(t, 2, QuotaDrop)
+	dropped := droppedOverQuota()
+
+	for i := 0; i < 2; i++ {
+		mustLog(t, &ActivityLog{SessionID: "hot", ActivityType: ActivityTypePageView})
+	}
+	for i := 0; i < 3; i++ {
+		if err := LogActivity(&ActivityLog{SessionID: "hot", ActivityType: ActivityTypePageView, Path: "/"}); !errors.Is(err, ErrSessionQuota) {
+			t.Fatalf("LogActivity() over the quota = %v, want ErrSessionQuota", err)
+		}
+	}
+	if n := countActivities(t); n != 2 {
+		t.Errorf("%d activities stored, want the 2 under the quota", n)
+	}
+	if rows := suppressedRows(t, "hot"); len(rows) != 0 {
+		t.Errorf("suppressed activities %v stored when dropping", rows)
+	}
+	if got := droppedOverQuota() - dropped; got != 3 {
+		t.Errorf("%d activities counted as dropped, want 3", got)
+	}
+}
+
+func TestSessionQuotaCountsStoredActivities(t *testing.T) {
+	setupTestDB(t)
+	// Stored before the quota was set, and counted from session_counters
+	for i := 0; i < 3; i++ {
+		mustLog(t, &ActivityLog{SessionID: "hot", ActivityType: ActivityTypePageView})
+	}
+	limitSessions(t, 3, QuotaAggregate)
+
+	if err := LogActivity(&ActivityLog{SessionID: "hot", ActivityType: ActivityTypePageView, Path: "/"}); !errors.Is(err, ErrSessionQuota) {
+		t.Errorf("LogActivity() = %v, want ErrSessionQuota for a session already at the quota", err)
+	}
+	mustLog(t, &ActivityLog{SessionID: "fresh", ActivityType: ActivityTypePageView})
+}
+
+func TestSuppressedActivitiesRollUp(t *testing.T) {
+	fc := setupTestDB(t)
+	limitSessions(t, 1, QuotaAggregate)
+	mustLog(t, &ActivityLog{SessionID: "hot", ActivityType: ActivityTypePageView})
+	for i := 0; i < 4; i++ {
+		LogActivity(&ActivityLog{SessionID: "hot", ActivityType: ActivityTypePageView, Path: "/"})
+	}
+
+	day := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	fc.Set(day.Add(36 * time.Hour))
+	if err := RollupDay(context.Background(), day); err != nil {
+		t.Fatalf("RollupDay() failed: %v", err)
+	}
+	stats, err := GetActivityStats(context.Background(), Filter{Start: day, End: day.Add(24 * time.Hour)})
+	if err != nil {
+		t.Fatalf("GetActivityStats() failed: %v", err)
+	}
+	if stats[ActivityTypeSuppressed] != 4 || stats[ActivityTypePageView] != 1 {
+		t.Errorf("stats of the rolled up day = %v, want 4 suppressed and 1 page view", stats)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// logAround logs a checkout, a currency change and a rendered page view
+// exactly at t and one nanosecond before it
+func logAround(t *testing.T, at time.Time) {
+	t.Helper()
+	for _, createdAt := range []time.Time{at.Add(-time.Nanosecond), at} {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout, CreatedAt: createdAt, Details: `{"country":"Canada"}`})
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypeCurrencyChange, CreatedAt: createdAt, Details: `{"new_currency":"EUR"}`})
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, CreatedAt: createdAt, Details: `{"render_ms":1}`})
+	}
+}
+
+// rangeCounts counts what every time-ranged query sees in [start, end)
+func rangeCounts(t *testing.T, start, end time.Time) map[string]int {
+	t.Helper()
+	counts := make(map[string]int)
+	stats, err := GetActivityStats(context.Background(), Filter{Start: start, End: end})
+	if err != nil {
+		t.Fatalf("GetActivityStats() failed: %v", err)
+	}
+	counts["stats"] = stats[ActivityTypeCheckout]
+
+	series, err := GetActivityTimeSeries(context.Background(), Filter{Start: start, End: end, Types: []string{ActivityTypeCheckout}}, time.Hour)
+	if err != nil {
+		t.Fatalf("GetActivityTimeSeries() failed: %v", err)
+	}
+	for _, p := range series {
+		counts["timeseries"] += p.Counts[ActivityTypeCheckout]
+	}
+
+	activities, err := GetActivities(Filter{Start: start, End: end, Types: []string{ActivityTypeCheckout}}, 100)
+	if err != nil {
+		t.Fatalf("GetActivities() failed: %v", err)
+	}
+	counts["activities"] = len(activities)
+
+	transitions, err := GetCurrencyTransitions(context.Background(), start, end)
+	if err != nil {
+		t.Fatalf("GetCurrencyTransitions() failed: %v", err)
+	}
+	counts["currencies"] = transitions.Transitions[""]["EUR"]
+
+	countries, err := GetCheckoutsByCountry(context.Background(), start, end)
+	if err != nil {
+		t.Fatalf("GetCheckoutsByCountry() failed: %v", err)
+	}
+	for _, c := range countries {
+		counts["geo"] += c.Orders
+	}
+
+	attribution, err := GetActionAttribution(context.Background(), start, end)
+	if err != nil {
+		t.Fatalf("GetActionAttribution() failed: %v", err)
+	}
+	counts["attribution"] = attribution[ActivityTypeCheckout][OriginDirect]
+
+	render, err := GetRenderTimeStats(context.Background(), start, end)
+	if err != nil {
+		t.Fatalf("GetRenderTimeStats() failed: %v", err)
+	}
+	counts["render"] = render[PageHome].Count
+	return counts
+}
+
+// wantCounts is what rangeCounts returns when every query sees n checkouts
+func wantCounts(n int) map[string]int {
+	want := make(map[string]int)
+	for _, k := range []string{"stats", "timeseries", "activities", "currencies", "geo", "attribution", "render"} {
+		want[k] = n
+	}
+	return want
+}
+
+func TestAdjacentRangesDontShareBoundary(t *testing.T) {
+	fc := setupTestDB(t)
+	midnight := truncateDay(fc.Now())
+	logAround(t, midnight)
+
+	yesterday := rangeCounts(t, midnight.Add(-day), midnight)
+	today := rangeCounts(t, midnight, midnight.Add(day))
+	if !reflect.DeepEqual(yesterday, wantCounts(1)) {
+		t.Errorf("yesterday = %v, want only the activities before midnight", yesterday)
+	}
+	if !reflect.DeepEqual(today, wantCounts(1)) {
+		t.Errorf("today = %v, want only the activities at midnight", today)
+	}
+
+	// Same once yesterday is served from roll-ups
+	if _, err := CatchUpRollups(context.Background()); err != nil {
+		t.Fatalf("CatchUpRollups() failed: %v", err)
+	}
+	if got := rangeCounts(t, midnight.Add(-day), midnight); !reflect.DeepEqual(got, yesterday) {
+		t.Errorf("yesterday from roll-ups = %v, want %v", got, yesterday)
+	}
+	if got := rangeCounts(t, midnight, midnight.Add(day)); !reflect.DeepEqual(got, today) {
+		t.Errorf("today after roll-ups = %v, want %v", got, today)
+	}
+}
+
+func TestRangesInNonUTCZones(t *testing.T) {
+	fc := setupTestDB(t)
+	east := time.FixedZone("UTC+2", 2*60*60)
+	west := time.FixedZone("UTC-5", -5*60*60)
+	// The clock and the activities use one zone, the queries another
+	fc.Set(fc.Now().In(east))
+	midnight := truncateDay(fc.Now()).In(east)
+	logAround(t, midnight)
+
+	if got := rangeCounts(t, midnight.Add(-day).In(west), midnight.In(west)); !reflect.DeepEqual(got, wantCounts(1)) {
+		t.Errorf("range ending at midnight = %v, want only the activities before it", got)
+	}
+	if got := rangeCounts(t, midnight.In(west), midnight.Add(day).In(west)); !reflect.DeepEqual(got, wantCounts(1)) {
+		t.Errorf("range starting at midnight = %v, want only the activities at it", got)
+	}
+
+	activities, err := GetActivities(Filter{Types: []string{ActivityTypeCheckout}}, 1)
+	if err != nil {
+		t.Fatalf("GetActivities() failed: %v", err)
+	}
+	if got := activities[0].CreatedAt; !got.Equal(midnight) || got.Location() != time.UTC {
+		t.Errorf("CreatedAt = %v, want %v in UTC", got, midnight)
+	}
+}
+
+func TestRangesAcrossDaylightSavingTime(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	setupTestDB(t)
+	// Clocks in New York skipped from 2:00 to 3:00 that day, so it only
+	// had 23 hours
+	start := time.Date(2025, 3, 9, 0, 0, 0, 0, newYork)
+	end := time.Date(2025, 3, 10, 0, 0, 0, 0, newYork)
+	for at := start.Add(-2 * time.Hour); at.Before(end.Add(2 * time.Hour)); at = at.Add(time.Hour) {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout, CreatedAt: at})
+	}
+
+	stats, err := GetActivityStats(context.Background(), Filter{Start: start, End: end})
+	if err != nil {
+		t.Fatalf("GetActivityStats() failed: %v", err)
+	}
+	if got := stats[ActivityTypeCheckout]; got != 23 {
+		t.Errorf("GetActivityStats() counted %d hourly checkouts, want 23", got)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"errors"
+	"expvar"
+	"sync/atomic"
+)
+
+// ErrReadOnly is returned by writes while the activity log is read-only.
+// The middleware drops activities silently in that case.
+var ErrReadOnly = errors.New("activitylog: read-only, writes are frozen")
+
+// readOnly freezes writes to the activity log while set. Reads are served
+// as usual.
+var readOnly atomic.Bool
+
+// readOnlySkipped counts the activities not written because the activity
+// log was read-only
+var readOnlySkipped = expvar.NewInt("activity_log_read_only_skipped_total")
+
+// SetReadOnly freezes writes to the activity log, or lets them through
+// again, during migrations or incidents. It is safe to call while
+// activities are being logged: writes already past the check complete.
+// It tells whether the mode changed.
+func SetReadOnly(on bool) bool {
+	if readOnly.Swap(on) == on {
+		return false
+	}
+	if on {
+		logger.Warn("activity log is read-only, activities are not recorded")
+	} else {
+		logger.Info("activity log writable again")
+	}
+	return true
+}
+
+// ReadOnly tells whether writes to the activity log are frozen
+func ReadOnly() bool {
+	return readOnly.Load()
+}
+
+// ReadOnlySkipped returns how many activities weren't written because the
+// activity log was read-only
+func ReadOnlySkipped() int64 {
+	return readOnlySkipped.Value()
+}
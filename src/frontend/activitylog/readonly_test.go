@@ -0,0 +1,116 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestReadOnlySkipsWrites(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+	skipped := ReadOnlySkipped()
+
+	if !SetReadOnly(true) || SetReadOnly(true) {
+		t.Fatal("SetReadOnly(true) should only report the first call as a change")
+	}
+	err := LogActivity(&ActivityLog{SessionID: "session-1", ActivityType: ActivityTypePageView, Path: "/"})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("LogActivity() = %v, want ErrReadOnly", err)
+	}
+	w := serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "session-1")
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want the page served", w.Code)
+	}
+	if got := w.Header().Get(StatusHeader); got != StateReadOnly {
+		t.Errorf("%s = %q, want %q", StatusHeader, got, StateReadOnly)
+	}
+	if n := countActivities(t); n != 0 {
+		t.Errorf("%d activities written while read-only", n)
+	}
+	if got := ReadOnlySkipped() - skipped; got != 2 {
+		t.Errorf("%d activities counted as skipped, want 2", got)
+	}
+	if s := Status(); !s.ReadOnly || s.State != StateReadOnly || s.LastWriteError != "" {
+		t.Errorf("Status() = %+v, want read-only without a write error", s)
+	}
+	// Reads keep working
+	if _, err := GetActivityStats(context.Background(), Filter{}); err != nil {
+		t.Errorf("GetActivityStats() failed while read-only: %v", err)
+	}
+
+	SetReadOnly(false)
+	serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "session-1")
+	if n := countActivities(t); n != 1 {
+		t.Errorf("%d activities written once writable, want 1", n)
+	}
+	if s := Status(); s.State != StateOK {
+		t.Errorf("State = %q once writable, want %q", s.State, StateOK)
+	}
+}
+
+func TestReadOnlyToggledUnderLoad(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+	skipped := ReadOnlySkipped()
+	// Every flip is logged
+	defer func(l logrus.FieldLogger) { logger = l }(logger)
+	logger, _ = test.NewNullLogger()
+
+	const workers, requests = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				serve(router, httptest.NewRequest(http.MethodGet, "/", nil), fmt.Sprintf("session-%d", i))
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for on := true; ; on = !on {
+		select {
+		case <-done:
+			SetReadOnly(false)
+			// Every request was either written or counted as skipped
+			written, dropped := countActivities(t), int(ReadOnlySkipped()-skipped)
+			if written+dropped != workers*requests {
+				t.Errorf("%d written + %d skipped, want %d requests", written, dropped, workers*requests)
+			}
+			if written == 0 || dropped == 0 {
+				t.Logf("%d written, %d skipped: the flag didn't flip mid-load", written, dropped)
+			}
+			return
+		default:
+			SetReadOnly(on)
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Where requests are navigated from
+const (
+	// ReferrerInternal is another page of the shop
+	ReferrerInternal = "internal"
+	// ReferrerExternal is another site
+	ReferrerExternal = "external"
+	// ReferrerDirect is no page at all, such as a bookmark or a typed URL,
+	// or a referrer that couldn't be read
+	ReferrerDirect = "direct"
+	// ReferrerUnknown is the type of sessions landing before referrers
+	// were classified
+	ReferrerUnknown = "(unknown)"
+)
+
+// topReferrerDomains is how many external domains GetTrafficSources lists
+const topReferrerDomains = 10
+
+// classifyReferrer tells where r was navigated from by comparing the host
+// of its Referer to the host it was sent to, ports aside. Behind the load
+// balancer that is the forwarded host. For other sites it also returns
+// their registrable domain, such as example.co.uk for news.example.co.uk,
+// which is all that is kept of the referrer.
+func classifyReferrer(r *http.Request) (string, string) {
+	referer := r.Referer()
+	if referer == "" {
+		return ReferrerDirect, ""
+	}
+	ref, err := url.Parse(referer)
+	if err != nil || (ref.Scheme != "http" && ref.Scheme != "https") || ref.Hostname() == "" {
+		return ReferrerDirect, ""
+	}
+	host := normalizeHost(ref.Hostname())
+	if host == requestHost(r) {
+		return ReferrerInternal, ""
+	}
+	return ReferrerExternal, registrableDomain(host)
+}
+
+// requestHost returns the host r was sent to, without its port
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		// Each proxy appends the host it was sent to, the first one is
+		// what the browser asked for
+		host = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return normalizeHost(strings.Trim(host, "[]"))
+}
+
+// normalizeHost lowercases a host name and drops the dot of fully qualified
+// names, so that equal names compare equal
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// registrableDomain returns the eTLD+1 of host, or nothing for IP
+// addresses and hosts that have none, such as localhost
+func registrableDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return ""
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return ""
+	}
+	return domain
+}
+
+// TrafficSources is where the sessions of a time period came from
+type TrafficSources struct {
+	// Sessions is the number of sessions per referrer type of the request
+	// they landed with
+	Sessions map[string]int `json:"sessions"`
+	// Domains are the external sites sessions landed from most, busiest
+	// first
+	Domains []ReferrerDomainSessions `json:"domains"`
+}
+
+// ReferrerDomainSessions is the number of sessions landing from a site
+type ReferrerDomainSessions struct {
+	Domain   string `json:"domain"`
+	Sessions int    `json:"sessions"`
+}
+
+// GetTrafficSources returns where the sessions active in a given time period
+// came from, judging by the first of their activities in it. Sessions
+// landing from sites without a registrable domain, such as IP addresses,
+// count as external but aren't listed by domain.
+func GetTrafficSources(ctx context.Context, startTime, endTime time.Time) (*TrafficSources, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "traffic sources", time.Now())
+
+	query := `
+		SELECT COALESCE(NULLIF(a.referrer_type, ''), ?) AS referrer_type,
+			   COALESCE(a.referrer_domain, '') AS referrer_domain, COUNT(*)
+		FROM activities a
+		JOIN (
+			SELECT MIN(id) AS id FROM activities
+			WHERE ` + createdIn("created_at") + ` AND deleted_at IS NULL
+			GROUP BY session_id
+		) landing ON a.id = landing.id
+		GROUP BY referrer_type, referrer_domain`
+	rows, err := getReadDB().QueryContext(ctx, query, ReferrerUnknown, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := &TrafficSources{
+		Sessions: map[string]int{ReferrerInternal: 0, ReferrerExternal: 0, ReferrerDirect: 0},
+		Domains:  []ReferrerDomainSessions{},
+	}
+	for rows.Next() {
+		var referrerType, domain string
+		var sessions int
+		if err := rows.Scan(&referrerType, &domain, &sessions); err != nil {
+			return nil, err
+		}
+		sources.Sessions[referrerType] += sessions
+		if referrerType == ReferrerExternal && domain != "" {
+			sources.Domains = append(sources.Domains, ReferrerDomainSessions{Domain: domain, Sessions: sessions})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sources.Domains, func(i, j int) bool {
+		a, b := sources.Domains[i], sources.Domains[j]
+		if a.Sessions != b.Sessions {
+			return a.Sessions > b.Sessions
+		}
+		return a.Domain < b.Domain
+	})
+	if len(sources.Domains) > topReferrerDomains {
+		sources.Domains = sources.Domains[:topReferrerDomains]
+	}
+	return sources, nil
+}
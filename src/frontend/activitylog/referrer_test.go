@@ -0,0 +1,115 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClassifyReferrer(t *testing.T) {
+	tests := []struct {
+		name, host, forwarded, referer string
+		wantType, wantDomain           string
+	}{
+		{"no referrer", "shop.example.com", "", "", ReferrerDirect, ""},
+		{"same host", "shop.example.com", "", "https://shop.example.com/cart", ReferrerInternal, ""},
+		{"same host, other port", "shop.example.com:8080", "", "http://shop.example.com/", ReferrerInternal, ""},
+		{"same host, other case", "shop.example.com", "", "https://SHOP.Example.com./", ReferrerInternal, ""},
+		{"forwarded host", "frontend:8080", "shop.example.com", "https://shop.example.com/", ReferrerInternal, ""},
+		{"forwarded through proxies", "frontend:8080", "shop.example.com:443, lb.internal", "https://shop.example.com/", ReferrerInternal, ""},
+		{"pod host behind the load balancer", "frontend:8080", "shop.example.com", "http://frontend:8080/", ReferrerExternal, ""},
+		{"other site", "shop.example.com", "", "https://www.google.com/search?q=shoes", ReferrerExternal, "google.com"},
+		{"public suffix", "shop.example.com", "", "https://news.bbc.co.uk/a", ReferrerExternal, "bbc.co.uk"},
+		{"sibling subdomain", "shop.example.com", "", "https://blog.example.com/post", ReferrerExternal, "example.com"},
+		{"IP address", "shop.example.com", "", "http://10.0.0.1/", ReferrerExternal, ""},
+		{"IPv6 same host", "[::1]:8080", "", "http://[::1]/", ReferrerInternal, ""},
+		{"malformed", "shop.example.com", "", "http://%zz/", ReferrerDirect, ""},
+		{"relative", "shop.example.com", "", "/cart", ReferrerDirect, ""},
+		{"no host", "shop.example.com", "", "https:///cart", ReferrerDirect, ""},
+		{"other scheme", "shop.example.com", "", "android-app://com.example", ReferrerDirect, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = tt.host
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-Host", tt.forwarded)
+		}
+		if tt.referer != "" {
+			req.Header.Set("Referer", tt.referer)
+		}
+		gotType, gotDomain := classifyReferrer(req)
+		if gotType != tt.wantType || gotDomain != tt.wantDomain {
+			t.Errorf("%s: classifyReferrer = %q, %q, want %q, %q", tt.name, gotType, gotDomain, tt.wantType, tt.wantDomain)
+		}
+	}
+}
+
+func TestMiddlewareRecordsReferrer(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	req := httptest.NewRequest(http.MethodGet, "http://shop.example.com/", nil)
+	req.Header.Set("Referer", "https://www.reddit.com/r/shoes/comments/123")
+	serve(router, req, "session-1")
+	if a := lastActivity(t); a.ReferrerType != ReferrerExternal || a.ReferrerDomain != "reddit.com" {
+		t.Errorf("referrer = %q, %q, want external, reddit.com", a.ReferrerType, a.ReferrerDomain)
+	}
+	if got := detailsOf(t, lastActivity(t)); len(got) != 0 {
+		t.Errorf("details = %v, want the referrer URL kept out of them", got)
+	}
+}
+
+func TestGetTrafficSources(t *testing.T) {
+	fc := setupTestDB(t)
+	now := fc.Now()
+	landings := []struct{ referrerType, domain string }{
+		{ReferrerExternal, "google.com"},
+		{ReferrerExternal, "google.com"},
+		{ReferrerExternal, "reddit.com"},
+		{ReferrerExternal, ""},
+		{ReferrerDirect, ""},
+		{ReferrerInternal, ""},
+		{"", ""},
+	}
+	for i, l := range landings {
+		session := fmt.Sprintf("session-%d", i)
+		mustLog(t, &ActivityLog{SessionID: session, ActivityType: ActivityTypePageView,
+			ReferrerType: l.referrerType, ReferrerDomain: l.domain, CreatedAt: now.Add(-time.Hour)})
+		// Browsing on doesn't change where the session came from
+		mustLog(t, &ActivityLog{SessionID: session, ActivityType: ActivityTypeProductView,
+			ReferrerType: ReferrerInternal, CreatedAt: now.Add(-time.Hour + time.Minute)})
+	}
+	// Landing before the time period
+	mustLog(t, &ActivityLog{SessionID: "early", ActivityType: ActivityTypePageView,
+		ReferrerType: ReferrerExternal, ReferrerDomain: "bing.com", CreatedAt: now.Add(-3 * day)})
+
+	got, err := GetTrafficSources(context.Background(), now.Add(-day), now)
+	if err != nil {
+		t.Fatalf("GetTrafficSources failed: %v", err)
+	}
+	want := &TrafficSources{
+		Sessions: map[string]int{ReferrerExternal: 4, ReferrerDirect: 1, ReferrerInternal: 1, ReferrerUnknown: 1},
+		Domains:  []ReferrerDomainSessions{{"google.com", 2}, {"reddit.com", 1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetTrafficSources = %+v, want %+v", got, want)
+	}
+}
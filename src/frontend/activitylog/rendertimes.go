@@ -0,0 +1,115 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Page types of GetRenderTimeStats
+const (
+	PageHome    = "home"
+	PageProduct = "product"
+	PageCart    = "cart"
+	PageOther   = "other"
+)
+
+// RenderTimeStats summarizes how long pages of one type took to render,
+// in milliseconds. Time spent waiting for backends isn't included.
+type RenderTimeStats struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// pageType tells which page an activity rendered
+func pageType(activityType, path string) string {
+	switch {
+	case activityType == ActivityTypePageView:
+		return PageHome
+	case activityType == ActivityTypeProductView:
+		return PageProduct
+	// Cart views were logged as "other" before they had a type of their own
+	case activityType == ActivityTypeViewCart || strings.HasSuffix(path, "/cart"):
+		return PageCart
+	default:
+		return PageOther
+	}
+}
+
+// GetRenderTimeStats returns render time percentiles per page type for a
+// given time period, from the render_ms detail recorded by the page
+// handlers. Pages rendered before render times were recorded are left out.
+func GetRenderTimeStats(ctx context.Context, startTime, endTime time.Time) (map[string]RenderTimeStats, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "render time stats", time.Now())
+
+	query := `
+		SELECT activity_type, path, json_extract(` + detailsJSON + `, '$.render_ms') AS render_ms
+		FROM activities
+		WHERE ` + createdIn("created_at") + ` AND render_ms IS NOT NULL AND deleted_at IS NULL`
+
+	rows, err := getReadDB().QueryContext(ctx, query, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byPage := make(map[string][]float64)
+	for rows.Next() {
+		var activityType, path string
+		var ms float64
+		if err := rows.Scan(&activityType, &path, &ms); err != nil {
+			return nil, err
+		}
+		page := pageType(activityType, path)
+		byPage[page] = append(byPage[page], ms)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]RenderTimeStats, len(byPage))
+	for page, times := range byPage {
+		sort.Float64s(times)
+		stats[page] = RenderTimeStats{
+			Count: len(times),
+			P50:   percentile(times, 50),
+			P90:   percentile(times, 90),
+			P99:   percentile(times, 99),
+			Max:   times[len(times)-1],
+		}
+	}
+	return stats, nil
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
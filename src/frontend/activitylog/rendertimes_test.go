@@ -0,0 +1,53 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetRenderTimeStats(t *testing.T) {
+	fc := setupTestDB(t)
+	start := fc.Now()
+	// Home pages rendering in 1 to 100ms
+	for i := 1; i <= 100; i++ {
+		mustLog(t, &ActivityLog{
+			ActivityType: ActivityTypePageView, Path: "/",
+			Details: fmt.Sprintf(`{"grpc_ms": 50, "render_ms": %d}`, i),
+		})
+	}
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeProductView, Path: "/product/OLJCESPC7Z", Details: `{"render_ms": 2.5}`})
+	mustLog(t, &ActivityLog{ActivityType: "other", Path: "/cart", Method: "GET", Details: `{"render_ms": 4}`})
+	// Not rendered, or before render times were recorded
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout, Path: "/cart/checkout"})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Path: "/", Details: `{"grpc_ms": 50}`})
+
+	stats, err := GetRenderTimeStats(context.Background(), start, fc.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetRenderTimeStats() failed: %v", err)
+	}
+	want := map[string]RenderTimeStats{
+		PageHome:    {Count: 100, P50: 50, P90: 90, P99: 99, Max: 100},
+		PageProduct: {Count: 1, P50: 2.5, P90: 2.5, P99: 2.5, Max: 2.5},
+		PageCart:    {Count: 1, P50: 4, P90: 4, P99: 4, Max: 4},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("GetRenderTimeStats() = %+v, want %+v", stats, want)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"time"
+)
+
+// ResetForTesting deletes every activity, along with the roll-ups and
+// alerts computed from them and the outbox queue, and restarts their IDs.
+// The audit trail is kept, with the reset added to it. It also clears the
+// in-memory state built from past writes: cached view counts, the write
+// breaker and essential-only logging. It returns how many activities there
+// were. End-to-end tests use it to start from a known state; nothing else
+// should.
+func ResetForTesting(ctx context.Context) (int64, error) {
+	tx, err := GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var count int64
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM activities").Scan(&count); err != nil {
+		return 0, err
+	}
+	if _, err := execEachTable(ctx, tx, func(table string) string { return "DELETE FROM " + table }); err != nil {
+		return 0, err
+	}
+	for _, stmt := range []string{
+		"DELETE FROM activity_rollups",
+		"DELETE FROM activity_rollup_days",
+		"DELETE FROM activity_alerts",
+		"DELETE FROM activity_outbox",
+		"DELETE FROM activity_outbox_parked",
+		"DELETE FROM sqlite_sequence WHERE name IN ('activities', 'activity_ids', 'activity_alerts')",
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return 0, err
+		}
+	}
+	audit := auditRecord{operation: AuditReset, filter: map[string]interface{}{}}
+	if err := audit.add(ctx, tx, count); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	forgetProductViews()
+
+	writeBreaker.Lock()
+	writeBreaker.failures = 0
+	setBreakerState(BreakerClosed)
+	writeBreaker.Unlock()
+
+	writeBacklog.Lock()
+	writeBacklog.since = time.Time{}
+	setLoggingMode(LoggingFull)
+	writeBacklog.Unlock()
+	return count, nil
+}
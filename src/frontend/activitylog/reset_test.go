@@ -0,0 +1,56 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResetForTesting(t *testing.T) {
+	setupTestDB(t)
+	for i := 0; i < 3; i++ {
+		mustLog(t, &ActivityLog{SessionID: "session-1", ActivityType: ActivityTypePageView})
+	}
+	if _, err := GetProductViewCount("OLJCESPC7Z", time.Hour); err != nil {
+		t.Fatalf("GetProductViewCount() failed: %v", err)
+	}
+	setLoggingMode(LoggingEssential)
+
+	n, err := ResetForTesting(context.Background())
+	if err != nil {
+		t.Fatalf("ResetForTesting() failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("ResetForTesting() = %d, want the 3 activities there were", n)
+	}
+	if n := countActivities(t); n != 0 {
+		t.Errorf("%d activities left after the reset", n)
+	}
+	if len(productViewCache.entries) != 0 {
+		t.Error("view counts are still cached")
+	}
+	if m := CurrentLoggingMode(); m != LoggingFull {
+		t.Errorf("logging mode = %v after the reset, want full", m)
+	}
+
+	// IDs start over
+	a := &ActivityLog{SessionID: "session-1", ActivityType: ActivityTypePageView}
+	mustLog(t, a)
+	if a.ID != 1 {
+		t.Errorf("first activity after the reset has ID %d, want 1", a.ID)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// Response classes, what the response to the request of an activity was
+const (
+	// ResponseClassPage is a page rendered for the shopper
+	ResponseClassPage = "page"
+	// ResponseClassRedirect sent the shopper elsewhere, typically after an
+	// action such as a cart add
+	ResponseClassRedirect = "redirect"
+	// ResponseClassError is an error page or response
+	ResponseClassError = "error"
+	// ResponseClassAPI is anything else that isn't HTML, such as JSON
+	ResponseClassAPI = "api"
+)
+
+// responseClasses are the valid response classes
+var responseClasses = map[string]bool{
+	ResponseClassPage:     true,
+	ResponseClassRedirect: true,
+	ResponseClassError:    true,
+	ResponseClassAPI:      true,
+}
+
+// responseClassMigration records the class of the response of each
+// activity. Rows logged before are left NULL.
+const responseClassMigration = `ALTER TABLE activities ADD COLUMN response_class TEXT;`
+
+// ResponseClassOf classifies a response from its status and Content-Type.
+// Successful responses without a Content-Type are pages: templates are
+// rendered without setting one, leaving it to be sniffed. Activities
+// without a status have no class.
+func ResponseClassOf(status int, contentType string) string {
+	switch {
+	case status == 0:
+		return ""
+	case status >= 300 && status < 400:
+		return ResponseClassRedirect
+	case status >= 400:
+		return ResponseClassError
+	}
+	if contentType == "" {
+		return ResponseClassPage
+	}
+	if media, _, err := mime.ParseMediaType(contentType); err == nil && media == "text/html" {
+		return ResponseClassPage
+	}
+	return ResponseClassAPI
+}
+
+// validateResponseClass checks that class is one of the response classes
+func validateResponseClass(class string) error {
+	if !responseClasses[class] {
+		return fmt.Errorf("invalid response class %q, must be page, redirect, error or api", class)
+	}
+	return nil
+}
+
+// cookieRedirectedFrom holds the request ID of a redirect response, for
+// the request it redirects to to record as its parent
+const cookieRedirectedFrom = "shop_redirected_from"
+
+// redirectWindow is how soon after a redirect the request following it
+// arrives, which is what the cookie lives for
+const redirectWindow = 2 * time.Second
+
+// setRedirectCookie makes the request following the redirect answered
+// with h carry the redirecting request's ID
+func setRedirectCookie(h http.Header, requestID string) {
+	h.Add("Set-Cookie", (&http.Cookie{
+		Name:     cookieRedirectedFrom,
+		Value:    requestID,
+		Path:     "/",
+		MaxAge:   int(redirectWindow / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}).String())
+}
+
+// redirectedFrom returns the ID of the request that redirected a GET
+// here, and expires the cookie that carried it so that the following
+// requests don't claim it too. It must be called before the response is
+// written.
+func redirectedFrom(w http.ResponseWriter, r *http.Request) string {
+	if r.Method != http.MethodGet {
+		return ""
+	}
+	c, err := r.Cookie(cookieRedirectedFrom)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{Name: cookieRedirectedFrom, Path: "/", MaxAge: -1})
+	if !requestIDFormat.MatchString(c.Value) {
+		return ""
+	}
+	return c.Value
+}
+
+// redirectFollowUp is true for the activities of alias that only followed
+// a redirect: GETs whose parent answered with a redirect less than
+// redirectWindow before, such as the cart shown after a cart add. They
+// belong to the action that redirected rather than being a step of their
+// own.
+func redirectFollowUp(alias string) string {
+	return fmt.Sprintf(`(%[1]s.method = 'GET' AND %[1]s.parent_request_id IS NOT NULL AND EXISTS (
+		SELECT 1 FROM activities r
+		WHERE r.request_id = %[1]s.parent_request_id AND r.session_id = %[1]s.session_id
+		  AND r.response_class = '%[2]s' AND r.deleted_at IS NULL
+		  AND (julianday(%[1]s.created_at) - julianday(r.created_at)) * 86400 BETWEEN 0 AND %[3]g))`,
+		alias, ResponseClassRedirect, redirectWindow.Seconds())
+}
@@ -0,0 +1,149 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResponseClassOf(t *testing.T) {
+	tests := []struct {
+		status      int
+		contentType string
+		want        string
+	}{
+		{0, "", ""},
+		{http.StatusOK, "", ResponseClassPage},
+		{http.StatusOK, "text/html; charset=utf-8", ResponseClassPage},
+		{http.StatusOK, "application/json", ResponseClassAPI},
+		{http.StatusOK, "text/event-stream", ResponseClassAPI},
+		{http.StatusFound, "text/html; charset=utf-8", ResponseClassRedirect},
+		{http.StatusSeeOther, "", ResponseClassRedirect},
+		{http.StatusNotFound, "text/plain; charset=utf-8", ResponseClassError},
+		{http.StatusServiceUnavailable, "application/json", ResponseClassError},
+	}
+	for _, tt := range tests {
+		if got := ResponseClassOf(tt.status, tt.contentType); got != tt.want {
+			t.Errorf("ResponseClassOf(%d, %q) = %q, want %q", tt.status, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestMiddlewareRecordsResponseClass(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+
+	for _, tt := range []struct {
+		req  *http.Request
+		want string
+	}{
+		{httptest.NewRequest(http.MethodGet, "/", nil), ResponseClassPage},
+		{postForm("/cart", "product_id=OLJCESPC7Z&quantity=1"), ResponseClassRedirect},
+		{httptest.NewRequest(http.MethodGet, "/nowhere", nil), ResponseClassError},
+	} {
+		serve(router, tt.req, "session-1")
+		if a := lastActivity(t); a.ResponseClass != tt.want {
+			t.Errorf("%s %s: ResponseClass = %q, want %q", tt.req.Method, tt.req.URL.Path, a.ResponseClass, tt.want)
+		}
+	}
+}
+
+// serveAs is serve with a request ID of the frontend's format
+func serveAs(h http.Handler, req *http.Request, sessionID, requestID string) *httptest.ResponseRecorder {
+	ctx := context.WithValue(req.Context(), CtxKeySessionID{}, sessionID)
+	ctx = context.WithValue(ctx, CtxKeyRequestID{}, requestID)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req.WithContext(ctx))
+	return w
+}
+
+func TestMiddlewareLinksRedirectFollowUp(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter()
+	const add = "0d6f8b7a-3c2e-4f1a-8b9c-7d6e5f4a3b2c"
+
+	w := serveAs(router, postForm("/cart", "product_id=OLJCESPC7Z&quantity=1"), "session-1", add)
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == cookieRedirectedFrom {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value != add || cookie.MaxAge != 2 {
+		t.Fatalf("redirect cookie = %v, want %s for 2 seconds", cookie, add)
+	}
+
+	// The browser follows the redirect with the cookie
+	req := httptest.NewRequest(http.MethodGet, "/cart", nil)
+	req.AddCookie(cookie)
+	w = serveAs(router, req, "session-1", "1e7f9c8b-4d3f-4a2b-9cad-8e7f6a5b4c3d")
+	if a := lastActivity(t); a.ParentRequestID != add || a.ResponseClass != ResponseClassPage {
+		t.Errorf("follow-up GET /cart: parent %q, class %q, want %s, page", a.ParentRequestID, a.ResponseClass, add)
+	}
+	expired := false
+	for _, c := range w.Result().Cookies() {
+		expired = expired || c.Name == cookieRedirectedFrom && c.MaxAge < 0
+	}
+	if !expired {
+		t.Error("the follow-up GET didn't expire the redirect cookie")
+	}
+
+	// A non-GET doesn't claim the cookie
+	req = postForm("/cart/empty", "")
+	req.AddCookie(cookie)
+	serveAs(router, req, "session-1", "2f8a0d9c-5e4a-4b3c-8dbe-9f8a7b6c5d4e")
+	if a := lastActivity(t); a.ParentRequestID != "" {
+		t.Errorf("POST /cart/empty: parent %q, want none", a.ParentRequestID)
+	}
+}
+
+func TestGetFunnelSkipsRedirectFollowUps(t *testing.T) {
+	fc := setupTestDB(t)
+	start := fc.Now()
+	// journey logs a product view then a cart add redirecting to the cart,
+	// shown after delay with the add as its parent when linked
+	journey := func(sessionID string, delay time.Duration, linked bool) {
+		logJourney(t, fc, sessionID, ActivityTypeProductView)
+		fc.Advance(time.Minute)
+		add := ActivityLog{SessionID: sessionID, RequestID: sessionID + "-add", ActivityType: ActivityTypeAddToCart,
+			Method: http.MethodPost, StatusCode: http.StatusFound}
+		mustLog(t, &add)
+		fc.Advance(delay)
+		view := ActivityLog{SessionID: sessionID, ActivityType: ActivityTypeViewCart, Method: http.MethodGet, StatusCode: http.StatusOK}
+		if linked {
+			view.ParentRequestID = add.RequestID
+		}
+		mustLog(t, &view)
+	}
+	journey("followed", 500*time.Millisecond, true)
+	journey("unlinked", 500*time.Millisecond, false)
+	journey("later", 3*time.Second, true)
+	// Coming back to the cart is a view of its own
+	journey("returned", 500*time.Millisecond, true)
+	logJourney(t, fc, "returned", ActivityTypeViewCart)
+	end := fc.Now().Add(time.Minute)
+
+	got, err := GetFunnel(context.Background(), start, end, []string{ActivityTypeProductView, ActivityTypeAddToCart, ActivityTypeViewCart})
+	if err != nil {
+		t.Fatalf("GetFunnel() failed: %v", err)
+	}
+	if got[1].Sessions != 4 || got[2].Sessions != 3 {
+		t.Errorf("GetFunnel() = %+v, want 4 cart adds and 3 cart views", got)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDeletedSessionGrace = 24 * time.Hour
+	// retentionInterval is how often the retention job looks for
+	// soft-deleted activities past their grace period
+	retentionInterval = time.Hour
+	// retentionRequester is who the audit trail says removed them
+	retentionRequester = "retention job"
+	// RetentionDefault is the retention of the activity types without one
+	// of their own
+	RetentionDefault = "default"
+)
+
+// typeRetention is how long activities are kept per activity type, or
+// under RetentionDefault for the types not listed. Types without either
+// are kept forever.
+var typeRetention = struct {
+	sync.RWMutex
+	ttls map[string]time.Duration
+}{}
+
+// ParseRetention parses retentions per activity type, such as
+// "page_view:168h,checkout:8760h,default:720h". Types must be registered,
+// listed once and kept for a positive duration.
+func ParseRetention(s string) (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration)
+	for _, entry := range strings.Split(s, ",") {
+		activityType, ttl, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("retention %q isn't type:duration", entry)
+		}
+		if activityType != RetentionDefault && !IsActivityType(activityType) {
+			return nil, fmt.Errorf("unknown activity type %q", activityType)
+		}
+		if _, dup := ttls[activityType]; dup {
+			return nil, fmt.Errorf("retention of %s given twice", activityType)
+		}
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("retention of %s: %w", activityType, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("retention of %s must be positive", activityType)
+		}
+		ttls[activityType] = d
+	}
+	return ttls, nil
+}
+
+// ConfigureRetention sets how long the retention job keeps activities of
+// each type, see ParseRetention. Roll-ups of expired days are kept. Empty
+// retentions keep every activity, the default.
+func ConfigureRetention(ttls map[string]time.Duration) {
+	copied := make(map[string]time.Duration, len(ttls))
+	for activityType, ttl := range ttls {
+		copied[activityType] = ttl
+	}
+	typeRetention.Lock()
+	defer typeRetention.Unlock()
+	typeRetention.ttls = copied
+}
+
+// Retention returns how long activities of each type are kept
+func Retention() map[string]time.Duration {
+	typeRetention.RLock()
+	defer typeRetention.RUnlock()
+	ttls := make(map[string]time.Duration, len(typeRetention.ttls))
+	for activityType, ttl := range typeRetention.ttls {
+		ttls[activityType] = ttl
+	}
+	return ttls
+}
+
+// ExpireActivities removes the activities older than the retention of
+// their type, in batches, and returns their number. While activities are
+// archived, those not archived yet are kept whatever their age. Each type
+// removed from is recorded in the audit trail.
+func ExpireActivities(ctx context.Context) (int64, error) {
+	ttls := Retention()
+	listed := make([]string, 0, len(ttls))
+	for activityType := range ttls {
+		if activityType != RetentionDefault {
+			listed = append(listed, activityType)
+		}
+	}
+	sort.Strings(listed)
+
+	now := Now()
+	var expired int64
+	for _, activityType := range append(listed, RetentionDefault) {
+		ttl, ok := ttls[activityType]
+		if !ok {
+			continue
+		}
+		before := now.Add(-ttl).UTC()
+		if archived, ok := archivedBefore(); ok && archived.Before(before) {
+			before = archived.UTC()
+		}
+		// The default covers the types not listed
+		var clauses []string
+		var args []interface{}
+		if activityType != RetentionDefault {
+			clauses, args = []string{"activity_type = ?"}, []interface{}{activityType}
+		} else if len(listed) > 0 {
+			clauses = []string{"activity_type NOT IN (?" + strings.Repeat(", ?", len(listed)-1) + ")"}
+			for _, t := range listed {
+				args = append(args, t)
+			}
+		}
+		clause := strings.Join(append(clauses, "created_at < ?"), " AND ")
+		args = append(args, before)
+
+		var id int64
+		err := GetDB().QueryRowContext(ctx, "SELECT id FROM activities WHERE "+clause+" LIMIT 1", args...).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		query := func(table string) string {
+			return `
+			DELETE FROM ` + table + `
+			WHERE id IN (SELECT id FROM ` + table + ` WHERE ` + clause + ` LIMIT ?)`
+		}
+		n, err := deleteBatches(ctx, query, append(args, purgeBatchSize), &auditRecord{
+			operation: AuditExpire,
+			filter:    map[string]interface{}{"activity_type": activityType, "created_before": before},
+		})
+		expired += n
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// deletedSessionGrace is how long soft-deleted activities stay in the
+// database before the retention job removes them
+var deletedSessionGrace = struct {
+	sync.RWMutex
+	grace time.Duration
+}{grace: defaultDeletedSessionGrace}
+
+// ConfigureDeletedSessionGrace sets how long the activities of deleted
+// sessions are kept, hidden, before being removed for good. Non-positive
+// values keep the default of a day.
+func ConfigureDeletedSessionGrace(grace time.Duration) {
+	if grace <= 0 {
+		grace = defaultDeletedSessionGrace
+	}
+	deletedSessionGrace.Lock()
+	defer deletedSessionGrace.Unlock()
+	deletedSessionGrace.grace = grace
+}
+
+// DeletedSessionGrace returns how long the activities of deleted sessions
+// are kept before being removed
+func DeletedSessionGrace() time.Duration {
+	deletedSessionGrace.RLock()
+	defer deletedSessionGrace.RUnlock()
+	return deletedSessionGrace.grace
+}
+
+// expiredActivitiesQuery finds an activity soft-deleted before a time
+const expiredActivitiesQuery = `SELECT id FROM activities WHERE deleted_at < ? LIMIT 1`
+
+// RemoveDeletedActivities removes the soft-deleted activities whose grace
+// period is over, in batches, and returns their number. Removals are
+// recorded in the audit trail; runs that find nothing to remove aren't.
+func RemoveDeletedActivities(ctx context.Context) (int64, error) {
+	before := Now().Add(-DeletedSessionGrace()).UTC()
+	var id int64
+	err := GetDB().QueryRowContext(ctx, expiredActivitiesQuery, before).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	query := func(table string) string {
+		return `
+		DELETE FROM ` + table + `
+		WHERE id IN (SELECT id FROM ` + table + ` WHERE deleted_at < ? LIMIT ?)`
+	}
+	return deleteBatches(ctx, query, []interface{}{before, purgeBatchSize}, &auditRecord{
+		operation: AuditRemoveDeleted,
+		filter:    map[string]time.Time{"deleted_before": before},
+	})
+}
+
+// StartRetentionJob removes the soft-deleted activities past their grace
+// period, the activities past the retention of their type and the monthly
+// partitions past their retention, right away and then every hour, until
+// ctx is done
+func StartRetentionJob(ctx context.Context) {
+	ctx = WithRequester(ctx, retentionRequester)
+	go func() {
+		for {
+			// Removed on the next run once writable again
+			if ReadOnly() {
+				logger.Info("activity log is read-only, not removing deleted activities")
+			} else if n, err := RemoveDeletedActivities(ctx); err != nil && ctx.Err() == nil {
+				logger.WithError(err).Warn("failed to remove deleted activities")
+			} else if n > 0 {
+				logger.WithField("removed", n).Info("removed deleted activities")
+			}
+			if !ReadOnly() {
+				if n, err := ExpireActivities(ctx); err != nil && ctx.Err() == nil {
+					logger.WithError(err).Warn("failed to remove expired activities")
+				} else if n > 0 {
+					logger.WithField("removed", n).Info("removed expired activities")
+				}
+				dropExpiredPartitions(ctx)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retentionInterval):
+			}
+		}
+	}()
+}
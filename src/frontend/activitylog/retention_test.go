@@ -0,0 +1,194 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRemoveDeletedActivities(t *testing.T) {
+	fc := setupTestDB(t)
+	ConfigureDeletedSessionGrace(2 * time.Hour)
+	t.Cleanup(func() { ConfigureDeletedSessionGrace(0) })
+
+	order := &ActivityLog{SessionID: "gone", ActivityType: ActivityTypeCheckout, Items: []ActivityItem{{ProductID: "OLJCESPC7Z", Quantity: 1}}}
+	mustLog(t, order)
+	mustLog(t, &ActivityLog{SessionID: "kept", ActivityType: ActivityTypePageView})
+	if _, err := DeleteSession(context.Background(), "gone"); err != nil {
+		t.Fatalf("DeleteSession() failed: %v", err)
+	}
+	rows := func() int {
+		t.Helper()
+		var n int
+		if err := GetDB().QueryRow("SELECT (SELECT COUNT(*) FROM activities) + (SELECT COUNT(*) FROM activity_items)").Scan(&n); err != nil {
+			t.Fatalf("counting rows failed: %v", err)
+		}
+		return n
+	}
+
+	// Within the grace period the rows stay
+	fc.Advance(time.Hour)
+	ctx := WithRequester(context.Background(), retentionRequester)
+	if n, err := RemoveDeletedActivities(ctx); err != nil || n != 0 {
+		t.Errorf("RemoveDeletedActivities() = %d, %v within the grace period, want 0", n, err)
+	}
+	if n := rows(); n != 3 {
+		t.Errorf("%d rows left within the grace period, want 3", n)
+	}
+
+	fc.Advance(2 * time.Hour)
+	if n, err := RemoveDeletedActivities(ctx); err != nil || n != 1 {
+		t.Errorf("RemoveDeletedActivities() = %d, %v after the grace period, want 1", n, err)
+	}
+	if n := rows(); n != 1 {
+		t.Errorf("%d rows left after the grace period, want only the kept session's activity", n)
+	}
+
+	entries, err := GetAuditEntries(0)
+	if err != nil {
+		t.Fatalf("GetAuditEntries() failed: %v", err)
+	}
+	// Only the run that removed something is recorded
+	if len(entries) != 2 || entries[0].Operation != AuditRemoveDeleted || entries[0].Requester != retentionRequester || entries[0].Affected != 1 {
+		t.Errorf("GetAuditEntries() = %+v, want the removal on top of the session delete", entries)
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	got, err := ParseRetention("page_view:168h, checkout:8760h,default:720h")
+	if err != nil {
+		t.Fatalf("ParseRetention() failed: %v", err)
+	}
+	want := map[string]time.Duration{ActivityTypePageView: 168 * time.Hour, ActivityTypeCheckout: 8760 * time.Hour, RetentionDefault: 720 * time.Hour}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRetention() = %v, want %v", got, want)
+	}
+	for _, invalid := range []string{"", "page_view", "page_view:soon", "page_view:0s", "page_view:-1h", "wishlist:24h", "page_view:1h,page_view:2h"} {
+		if _, err := ParseRetention(invalid); err == nil {
+			t.Errorf("ParseRetention(%q) succeeded, want an error", invalid)
+		}
+	}
+}
+
+func TestExpireActivities(t *testing.T) {
+	for _, partitioned := range []bool{false, true} {
+		name := "single table"
+		if partitioned {
+			name = "partitioned"
+		}
+		t.Run(name, func(t *testing.T) {
+			fc := setupTestDB(t)
+			if partitioned {
+				partitionByMonth(t)
+			}
+			ConfigureRetention(map[string]time.Duration{
+				ActivityTypePageView:    7 * 24 * time.Hour,
+				ActivityTypeProductView: 30 * 24 * time.Hour,
+				ActivityTypeCheckout:    365 * 24 * time.Hour,
+				RetentionDefault:        30 * 24 * time.Hour,
+			})
+			t.Cleanup(func() { ConfigureRetention(nil) })
+
+			types := []string{ActivityTypePageView, ActivityTypeProductView, ActivityTypeCheckout, ActivityTypeAddToCart}
+			for _, typ := range types {
+				for _, days := range []int{1, 10, 40, 400} {
+					mustLog(t, &ActivityLog{
+						SessionID:    fmt.Sprintf("%s/%d", typ, days),
+						ActivityType: typ,
+						CreatedAt:    fc.Now().Add(-time.Duration(days) * 24 * time.Hour),
+					})
+				}
+			}
+
+			ctx := WithRequester(context.Background(), retentionRequester)
+			n, err := ExpireActivities(ctx)
+			if err != nil {
+				t.Fatalf("ExpireActivities() failed: %v", err)
+			}
+			want := []string{
+				"add_to_cart/1", "add_to_cart/10",
+				"checkout/1", "checkout/10", "checkout/40",
+				"page_view/1",
+				"product_view/1", "product_view/10",
+			}
+			if n != int64(4*len(types)-len(want)) {
+				t.Errorf("ExpireActivities() = %d, want %d", n, 4*len(types)-len(want))
+			}
+			var got []string
+			rows, err := GetDB().Query("SELECT session_id FROM activities ORDER BY session_id")
+			if err != nil {
+				t.Fatalf("listing activities failed: %v", err)
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var session string
+				if err := rows.Scan(&session); err != nil {
+					t.Fatalf("scanning activities failed: %v", err)
+				}
+				got = append(got, session)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("kept %v, want %v", got, want)
+			}
+
+			// One entry per type expired
+			entries, err := GetAuditEntries(0)
+			if err != nil {
+				t.Fatalf("GetAuditEntries() failed: %v", err)
+			}
+			if len(entries) != 4 || entries[0].Operation != AuditExpire || entries[0].Requester != retentionRequester {
+				t.Errorf("GetAuditEntries() = %+v, want an expiry per type", entries)
+			}
+		})
+	}
+}
+
+func TestExpireActivitiesKeepsUnarchivedDays(t *testing.T) {
+	fc := setupTestDB(t)
+	ConfigureRetention(map[string]time.Duration{RetentionDefault: time.Hour})
+	t.Cleanup(func() { ConfigureRetention(nil) })
+	// As Start does, without its background export
+	archived.Lock()
+	archived.started, archived.before = true, time.Time{}
+	archived.Unlock()
+	t.Cleanup(func() {
+		archived.Lock()
+		archived.started, archived.before = false, time.Time{}
+		archived.Unlock()
+	})
+	e := NewArchiveExporter(&fakeBucket{}, "test")
+
+	today := truncateDay(fc.Now())
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, CreatedAt: today.Add(-36 * time.Hour)})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, CreatedAt: today.Add(-12 * time.Hour)})
+	// Nothing is archived yet
+	if n, err := ExpireActivities(context.Background()); err != nil || n != 0 {
+		t.Errorf("ExpireActivities() = %d, %v before archiving, want 0", n, err)
+	}
+
+	if _, err := e.ExportDay(context.Background(), today.Add(-36*time.Hour)); err != nil {
+		t.Fatalf("ExportDay() failed: %v", err)
+	}
+	if n, err := ExpireActivities(context.Background()); err != nil || n != 1 {
+		t.Errorf("ExpireActivities() = %d, %v, want only the archived day's activity", n, err)
+	}
+	if n := countActivities(t); n != 1 {
+		t.Errorf("%d activities left, want 1", n)
+	}
+}
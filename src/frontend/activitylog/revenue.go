@@ -0,0 +1,66 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"time"
+)
+
+// CurrencyRevenue is what the orders paid in one currency added up to
+type CurrencyRevenue struct {
+	Currency string  `json:"currency"`
+	Orders   int     `json:"orders"`
+	Revenue  float64 `json:"revenue"`
+}
+
+// GetRevenueByCurrency returns the number and total, shipping included, of
+// the orders placed in a given time period per currency they were paid in.
+// Amounts in different currencies aren't converted, so they don't add up.
+// Checkouts logged before order totals were recorded aren't counted.
+func GetRevenueByCurrency(ctx context.Context, startTime, endTime time.Time) ([]CurrencyRevenue, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "revenue by currency", time.Now())
+
+	query := `
+		SELECT json_extract(` + detailsJSON + `, '$.order_currency') AS currency,
+			   COUNT(*), ROUND(SUM(json_extract(` + detailsJSON + `, '$.order_total')), 2)
+		FROM activities
+		WHERE activity_type = ? AND ` + createdIn("created_at") + ` AND deleted_at IS NULL
+		  AND NOT COALESCE(json_extract(` + detailsJSON + `, '$.failed'), 0)
+		  AND json_extract(` + detailsJSON + `, '$.order_total') IS NOT NULL
+		GROUP BY currency
+		ORDER BY currency`
+
+	rows, err := getReadDB().QueryContext(ctx, query, ActivityTypeCheckout, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revenue := []CurrencyRevenue{}
+	for rows.Next() {
+		var c CurrencyRevenue
+		if err := rows.Scan(&c.Currency, &c.Orders, &c.Revenue); err != nil {
+			return nil, err
+		}
+		revenue = append(revenue, c)
+	}
+	return revenue, rows.Err()
+}
@@ -0,0 +1,51 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetRevenueByCurrency(t *testing.T) {
+	fc := setupTestDB(t)
+	for _, details := range []string{
+		`{"order_total":10.25,"order_currency":"USD"}`,
+		`{"order_total":5.5,"order_currency":"USD"}`,
+		`{"order_total":1000,"order_currency":"JPY"}`,
+		// Failed, and logged before totals were recorded
+		`{"failed":true,"order_total":99,"order_currency":"USD"}`,
+		`{"shipping_cost":8.99}`,
+	} {
+		mustLog(t, &ActivityLog{SessionID: "a", ActivityType: ActivityTypeCheckout, Details: details})
+	}
+	// Outside the period
+	mustLog(t, &ActivityLog{SessionID: "a", ActivityType: ActivityTypeCheckout, Details: `{"order_total":1,"order_currency":"EUR"}`,
+		CreatedAt: fc.Now().Add(-2 * time.Hour)})
+
+	got, err := GetRevenueByCurrency(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetRevenueByCurrency() failed: %v", err)
+	}
+	want := []CurrencyRevenue{
+		{Currency: "JPY", Orders: 1, Revenue: 1000},
+		{Currency: "USD", Orders: 2, Revenue: 15.75},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetRevenueByCurrency() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Roll-ups aggregate a whole UTC day of activities per type and source into
+// activity_rollups. Days listed in activity_rollup_days are served from the
+// roll-ups by the stats queries, everything else from the raw activities.
+
+const (
+	day        = 24 * time.Hour
+	dateLayout = "2006-01-02"
+)
+
+// dayRange is the half-open range of whole UTC days [start, end)
+type dayRange struct {
+	start, end time.Time
+}
+
+// truncateDay returns the start of the UTC day t falls in
+func truncateDay(t time.Time) time.Time {
+	return t.UTC().Truncate(day)
+}
+
+// RollupDay (re)aggregates the UTC day containing the given time. Requests
+// answered with a 5xx status count as errors.
+func RollupDay(ctx context.Context, t time.Time) error {
+	start := truncateDay(t)
+	date := start.Format(dateLayout)
+
+	tx, err := GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM activity_rollups WHERE date = ?", date); err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO activity_rollups (
+			date, activity_type, source, count, unique_sessions, error_count, total_latency_ms
+		)
+		SELECT ?, activity_type, COALESCE(source, ''), SUM(` + storedCount + `), COUNT(DISTINCT session_id),
+			   SUM(CASE WHEN status_code >= 500 THEN 1 ELSE 0 END), COALESCE(SUM(latency_ms), 0)
+		FROM activities
+		WHERE created_at >= ? AND created_at < ? AND deleted_at IS NULL
+		GROUP BY activity_type, COALESCE(source, '')`
+	if _, err := tx.ExecContext(ctx, query, date, start, start.Add(day)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT OR REPLACE INTO activity_rollup_days (date, rolled_up_at) VALUES (?, ?)",
+		date, Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RebuildRollups re-aggregates every day from from to to, both inclusive,
+// and returns the number of days rolled up. Days that haven't ended yet are
+// skipped since their activities are still coming in.
+func RebuildRollups(ctx context.Context, from, to time.Time) (int, error) {
+	last := truncateDay(Now()).Add(-day)
+	if to = truncateDay(to); to.After(last) {
+		to = last
+	}
+	n := 0
+	for d := truncateDay(from); !d.After(to); d = d.Add(day) {
+		if err := RollupDay(ctx, d); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// CatchUpRollups rolls up every past day since the oldest activity that
+// hasn't been rolled up yet, and returns the number of days rolled up.
+func CatchUpRollups(ctx context.Context) (int, error) {
+	var earliest sql.NullString
+	err := GetDB().QueryRowContext(ctx, "SELECT MIN(date(created_at)) FROM activities").Scan(&earliest)
+	if err != nil || !earliest.Valid {
+		return 0, err
+	}
+	first, err := time.Parse(dateLayout, earliest.String)
+	if err != nil {
+		return 0, err
+	}
+
+	rolled := make(map[string]bool)
+	rows, err := GetDB().QueryContext(ctx, "SELECT date FROM activity_rollup_days WHERE date >= ?", earliest.String)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		rolled[date] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	today := truncateDay(Now())
+	for d := first; d.Before(today); d = d.Add(day) {
+		if rolled[d.Format(dateLayout)] {
+			continue
+		}
+		if err := RollupDay(ctx, d); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// StartRollupJob catches up on missing roll-ups right away and then after
+// every UTC midnight, rolling up the day that just ended, until ctx is done.
+func StartRollupJob(ctx context.Context) {
+	go func() {
+		for {
+			// Days missed while read-only are caught up the next day
+			if ReadOnly() {
+				logger.Info("activity log is read-only, not rolling up activities")
+			} else if n, err := CatchUpRollups(ctx); err != nil {
+				logger.WithError(err).Warn("failed to roll up activities")
+			} else if n > 0 {
+				logger.WithField("days", n).Info("rolled up activities")
+			}
+
+			now := Now()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(truncateDay(now).Add(day).Sub(now)):
+			}
+		}
+	}()
+}
+
+// invalidateRollups drops the roll-ups of the days overlapping [start, end),
+// so they are served from raw activities until they are rolled up again.
+// Zero times are unbounded.
+func invalidateRollups(start, end time.Time) error {
+	var clauses []string
+	var args []interface{}
+	if !start.IsZero() {
+		clauses = append(clauses, "date >= ?")
+		args = append(args, truncateDay(start).Format(dateLayout))
+	}
+	if !end.IsZero() {
+		clauses = append(clauses, "date < ?")
+		args = append(args, truncateDay(end.Add(day-time.Nanosecond)).Format(dateLayout))
+	}
+	where := ""
+	if len(clauses) > 0 {
+		where = " WHERE " + strings.Join(clauses, " AND ")
+	}
+	if _, err := GetDB().Exec("DELETE FROM activity_rollup_days"+where, args...); err != nil {
+		return err
+	}
+	_, err := GetDB().Exec("DELETE FROM activity_rollups"+where, args...)
+	return err
+}
+
+// rolledUpDays returns the days fully covered by the filter that can be
+// served from roll-ups, merged into contiguous ranges. Roll-ups don't keep
+// sessions, paths, statuses or details, so filters on those always use raw
+// activities.
+func rolledUpDays(ctx context.Context, q queryer, f Filter) ([]dayRange, error) {
+	if !f.rollupCompatible() {
+		return nil, nil
+	}
+
+	var clauses []string
+	var args []interface{}
+	if !f.Start.IsZero() {
+		first := truncateDay(f.Start)
+		if first.Before(f.Start) {
+			first = first.Add(day)
+		}
+		clauses = append(clauses, "date >= ?")
+		args = append(args, first.Format(dateLayout))
+	}
+	if !f.End.IsZero() {
+		// End is exclusive, the day it falls in is only covered when End
+		// is the midnight it ends at
+		clauses = append(clauses, "date < ?")
+		args = append(args, truncateDay(f.End).Format(dateLayout))
+	}
+	query := "SELECT date FROM activity_rollup_days"
+	if len(clauses) > 0 {
+		query += " WHERE " + strings.Join(clauses, " AND ")
+	}
+	query += " ORDER BY date"
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ranges []dayRange
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			return nil, err
+		}
+		d, err := time.Parse(dateLayout, date)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].end.Equal(d) {
+			ranges[n-1].end = d.Add(day)
+			continue
+		}
+		ranges = append(ranges, dayRange{start: d, end: d.Add(day)})
+	}
+	return ranges, rows.Err()
+}
+
+// rollupWhere builds the WHERE clause selecting the roll-ups of the given
+// days that match the filter's types and source. The filter must be
+// rollupCompatible.
+func rollupWhere(f Filter, ranges []dayRange) (string, []interface{}) {
+	var days []string
+	var args []interface{}
+	for _, r := range ranges {
+		days = append(days, "(date >= ? AND date < ?)")
+		args = append(args, r.start.Format(dateLayout), r.end.Format(dateLayout))
+	}
+	where := "WHERE (" + strings.Join(days, " OR ") + ")"
+	if len(f.Types) > 0 {
+		where += " AND activity_type IN (" + placeholders(len(f.Types)) + ")"
+		for _, t := range f.Types {
+			args = append(args, t)
+		}
+	}
+	if len(f.TypesNot) > 0 {
+		where += " AND activity_type NOT IN (" + placeholders(len(f.TypesNot)) + ")"
+		for _, t := range f.TypesNot {
+			args = append(args, t)
+		}
+	}
+	if f.Source != "" {
+		where += " AND source = ?"
+		args = append(args, f.Source)
+	}
+	return where, args
+}
+
+// excludeDays narrows a raw activities WHERE clause to skip the given days
+func excludeDays(where string, args []interface{}, ranges []dayRange) (string, []interface{}) {
+	for _, r := range ranges {
+		if where == "" {
+			where = "WHERE "
+		} else {
+			where += " AND "
+		}
+		where += "NOT (created_at >= ? AND created_at < ?)"
+		args = append(args, r.start, r.end)
+	}
+	return where, args
+}
@@ -0,0 +1,205 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// seedDays logs an activity every 90 minutes for the given number of days
+// up to the clock's current time, cycling through types, sources, sessions
+// and status codes.
+func seedDays(t *testing.T, fc *FakeClock, days int) {
+	t.Helper()
+	types := []string{ActivityTypePageView, ActivityTypeProductView, ActivityTypeAddToCart, ActivityTypeCheckout}
+	sources := []string{SourceWeb, SourceLoadGenerator, ""}
+	start := fc.Now().Add(-time.Duration(days) * day)
+	for i := 0; start.Add(time.Duration(i) * 90 * time.Minute).Before(fc.Now()); i++ {
+		status := 200
+		if i%7 == 0 {
+			status = 500
+		}
+		mustLog(t, &ActivityLog{
+			SessionID:    fmt.Sprintf("session-%d", i%5),
+			ActivityType: types[i%len(types)],
+			Source:       sources[i%len(sources)],
+			StatusCode:   status,
+			LatencyMs:    int64(i % 40),
+			CreatedAt:    start.Add(time.Duration(i) * 90 * time.Minute),
+		})
+	}
+}
+
+func TestRollupsMatchRawActivities(t *testing.T) {
+	fc := setupTestDB(t)
+	seedDays(t, fc, 5)
+	now := fc.Now()
+
+	filters := []Filter{
+		{},
+		{Start: now.Add(-3 * day), End: now},
+		{Start: now.Add(-80 * time.Hour), End: now.Add(-30 * time.Hour)},
+		{Start: truncateDay(now).Add(-2 * day), End: truncateDay(now).Add(-time.Nanosecond)},
+		{Start: truncateDay(now).Add(-2 * day), End: truncateDay(now)},
+		{Types: []string{ActivityTypeCheckout, ActivityTypePageView}},
+		{Source: SourceLoadGenerator, Start: now.Add(-4 * day)},
+		{SessionID: "session-2"},
+		{TypesNot: []string{ActivityTypePageView}, Start: now.Add(-50 * time.Hour)},
+		{StatusClasses: []int{5}},
+	}
+	buckets := []time.Duration{time.Hour, day}
+
+	type answer struct {
+		stats  map[string]int
+		series map[time.Duration][]TimeSeriesPoint
+	}
+	query := func() []answer {
+		var answers []answer
+		for _, f := range filters {
+			stats, err := GetActivityStats(context.Background(), f)
+			if err != nil {
+				t.Fatalf("GetActivityStats(%+v) failed: %v", f, err)
+			}
+			a := answer{stats: stats, series: make(map[time.Duration][]TimeSeriesPoint)}
+			for _, b := range buckets {
+				series, err := GetActivityTimeSeries(context.Background(), f, b)
+				if err != nil {
+					t.Fatalf("GetActivityTimeSeries(%+v, %v) failed: %v", f, b, err)
+				}
+				a.series[b] = series
+			}
+			answers = append(answers, a)
+		}
+		return answers
+	}
+
+	raw := query()
+	n, err := CatchUpRollups(context.Background())
+	if err != nil {
+		t.Fatalf("CatchUpRollups() failed: %v", err)
+	}
+	if n != 5 {
+		t.Errorf("CatchUpRollups() rolled up %d days, want 5", n)
+	}
+	rolled := query()
+	for i, f := range filters {
+		if !reflect.DeepEqual(rolled[i], raw[i]) {
+			t.Errorf("filter %+v: rolled up answer = %+v, raw answer = %+v", f, rolled[i], raw[i])
+		}
+	}
+}
+
+func TestRolledUpDaysAreServedFromRollups(t *testing.T) {
+	fc := setupTestDB(t)
+	seedDays(t, fc, 2)
+	if _, err := CatchUpRollups(context.Background()); err != nil {
+		t.Fatalf("CatchUpRollups() failed: %v", err)
+	}
+	want, err := GetActivityStats(context.Background(), Filter{})
+	if err != nil {
+		t.Fatalf("GetActivityStats() failed: %v", err)
+	}
+
+	// Dropping the raw activities of rolled up days behind the roll-ups'
+	// back doesn't change the answer
+	if _, err := GetDB().Exec("DELETE FROM activities WHERE created_at < ?", truncateDay(fc.Now())); err != nil {
+		t.Fatalf("deleting raw activities failed: %v", err)
+	}
+	got, err := GetActivityStats(context.Background(), Filter{})
+	if err != nil {
+		t.Fatalf("GetActivityStats() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetActivityStats() = %v, want %v", got, want)
+	}
+}
+
+func TestRollupDayAggregates(t *testing.T) {
+	fc := setupTestDB(t)
+	dayStart := truncateDay(fc.Now()).Add(-day)
+	for i, a := range []ActivityLog{
+		{SessionID: "a", StatusCode: 200, LatencyMs: 10},
+		{SessionID: "a", StatusCode: 500, LatencyMs: 20},
+		{SessionID: "b", StatusCode: 503, LatencyMs: 30},
+	} {
+		a.ActivityType = ActivityTypeCheckout
+		a.Source = SourceWeb
+		a.CreatedAt = dayStart.Add(time.Duration(i) * time.Hour)
+		mustLog(t, &a)
+	}
+	// Outside of the day
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout, Source: SourceWeb, CreatedAt: dayStart.Add(day)})
+
+	if err := RollupDay(context.Background(), dayStart.Add(12*time.Hour)); err != nil {
+		t.Fatalf("RollupDay() failed: %v", err)
+	}
+	var count, sessions, errs, latency int
+	err := GetDB().QueryRow(`
+		SELECT count, unique_sessions, error_count, total_latency_ms
+		FROM activity_rollups WHERE date = ? AND activity_type = ? AND source = ?`,
+		dayStart.Format(dateLayout), ActivityTypeCheckout, SourceWeb).Scan(&count, &sessions, &errs, &latency)
+	if err != nil {
+		t.Fatalf("reading roll-up failed: %v", err)
+	}
+	if count != 3 || sessions != 2 || errs != 2 || latency != 60 {
+		t.Errorf("roll-up = count %d, sessions %d, errors %d, latency %d; want 3, 2, 2, 60",
+			count, sessions, errs, latency)
+	}
+}
+
+func TestRebuildRollupsSkipsToday(t *testing.T) {
+	fc := setupTestDB(t)
+	seedDays(t, fc, 3)
+	today := truncateDay(fc.Now())
+
+	n, err := RebuildRollups(context.Background(), today.Add(-2*day), today)
+	if err != nil {
+		t.Fatalf("RebuildRollups() failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("RebuildRollups() rolled up %d days, want 2", n)
+	}
+	ranges, err := rolledUpDays(context.Background(), getReadDB(), Filter{})
+	if err != nil {
+		t.Fatalf("rolledUpDays() failed: %v", err)
+	}
+	want := []dayRange{{start: today.Add(-2 * day), end: today}}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("rolledUpDays() = %v, want %v", ranges, want)
+	}
+}
+
+func TestDeleteByFilterInvalidatesRollups(t *testing.T) {
+	fc := setupTestDB(t)
+	seedDays(t, fc, 2)
+	if _, err := CatchUpRollups(context.Background()); err != nil {
+		t.Fatalf("CatchUpRollups() failed: %v", err)
+	}
+
+	if _, err := DeleteByFilter(context.Background(), Filter{Source: SourceLoadGenerator}); err != nil {
+		t.Fatalf("DeleteByFilter() failed: %v", err)
+	}
+	stats, err := GetActivityStats(context.Background(), Filter{Source: SourceLoadGenerator})
+	if err != nil {
+		t.Fatalf("GetActivityStats() failed: %v", err)
+	}
+	if len(stats) != 0 {
+		t.Errorf("GetActivityStats() = %v after purge, want none", stats)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+// SampleSummary is the last record of a sampled export, telling how it was
+// sampled and how many activities of each type made it in
+type SampleSummary struct {
+	Seed int64 `json:"seed"`
+	// Rates are the sampling probabilities that were asked for, types not
+	// listed were exported in full
+	Rates  map[string]float64 `json:"rates"`
+	Counts map[string]int     `json:"counts"`
+}
+
+// sampleRecord wraps the summary so that it can't be mistaken for an
+// activity when reading the export back
+type sampleRecord struct {
+	Summary SampleSummary `json:"summary"`
+}
+
+type exportConfig struct {
+	filter Filter
+	seed   int64
+	seeded bool
+}
+
+// ExportOption configures optional ExportSampled behavior
+type ExportOption func(*exportConfig)
+
+// ExportFilter restricts the export to the activities matching f before
+// they are sampled
+func ExportFilter(f Filter) ExportOption {
+	return func(c *exportConfig) {
+		c.filter = f
+	}
+}
+
+// ExportSeed makes the sample reproducible: exports with the same seed keep
+// the same activities. Without one a random seed is used, which the
+// summary reports.
+func ExportSeed(seed int64) ExportOption {
+	return func(c *exportConfig) {
+		c.seed = seed
+		c.seeded = true
+	}
+}
+
+// ValidateSampleSpec checks that a sampling spec only lists known activity
+// types with probabilities between 0 and 1, which ExportSampled requires
+func ValidateSampleSpec(spec map[string]float64) error {
+	for t, rate := range spec {
+		if !IsActivityType(t) {
+			return fmt.Errorf("unknown activity type %q", t)
+		}
+		if !(rate >= 0 && rate <= 1) {
+			return fmt.Errorf("sampling rate of %s must be between 0 and 1, got %v", t, rate)
+		}
+	}
+	return nil
+}
+
+// ExportSampled streams a sample of the activities to w, oldest first, as
+// newline-delimited JSON, followed by a SampleSummary record. spec maps
+// activity types to the probability of each activity of that type being
+// kept; types it doesn't list are kept in full. Whether an activity is kept
+// depends only on the seed and its ID, so with a seed a later export keeps
+// the same activities along with those logged since. The export holds an
+// analytical query slot while it runs, and fails with ErrBusy before
+// writing anything when none frees up in time.
+func ExportSampled(ctx context.Context, w io.Writer, spec map[string]float64, opts ...ExportOption) error {
+	if err := ValidateSampleSpec(spec); err != nil {
+		return err
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	var cfg exportConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if !cfg.seeded {
+		cfg.seed = rand.Int63()
+	}
+
+	enc := json.NewEncoder(w)
+	summary := SampleSummary{Seed: cfg.seed, Rates: spec, Counts: make(map[string]int)}
+	if summary.Rates == nil {
+		summary.Rates = map[string]float64{}
+	}
+	err = QueryStream(ctx, cfg.filter, func(a ActivityLog) error {
+		if rate, ok := spec[a.ActivityType]; ok && sampleDraw(cfg.seed, a.ID) >= rate {
+			return nil
+		}
+		summary.Counts[a.ActivityType]++
+		return enc.Encode(a)
+	})
+	if err != nil {
+		return err
+	}
+	return enc.Encode(sampleRecord{Summary: summary})
+}
+
+// sampleDraw is the uniform draw in [0, 1) deciding whether the activity
+// with the given ID is sampled. It is SplitMix64 seeded from both the seed
+// and the ID rather than one generator shared by the whole export, so that
+// draws don't depend on which other activities are exported.
+func sampleDraw(seed, id int64) float64 {
+	z := uint64(seed) + uint64(id)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return float64(z>>11) / (1 << 53)
+}
@@ -0,0 +1,138 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readSample splits a sampled export into the IDs of its activities and
+// its summary
+func readSample(t *testing.T, export []byte) ([]int64, SampleSummary) {
+	t.Helper()
+	var ids []int64
+	var summary *SampleSummary
+	sc := bufio.NewScanner(bytes.NewReader(export))
+	for sc.Scan() {
+		if summary != nil {
+			t.Fatalf("line after the summary: %s", sc.Text())
+		}
+		var line struct {
+			ActivityLog
+			Summary *SampleSummary `json:"summary"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("line %q isn't JSON: %v", sc.Text(), err)
+		}
+		if line.Summary != nil {
+			summary = line.Summary
+			continue
+		}
+		ids = append(ids, line.ID)
+	}
+	if summary == nil {
+		t.Fatal("export has no summary")
+	}
+	return ids, *summary
+}
+
+func TestExportSampled(t *testing.T) {
+	setupTestDB(t)
+	for i := 0; i < 2000; i++ {
+		mustLog(t, &ActivityLog{SessionID: "session-1", ActivityType: ActivityTypePageView})
+	}
+	for i := 0; i < 20; i++ {
+		mustLog(t, &ActivityLog{SessionID: "session-1", ActivityType: ActivityTypeCheckout})
+	}
+	mustLog(t, &ActivityLog{SessionID: "session-1", ActivityType: ActivityTypeAddToCart})
+
+	spec := map[string]float64{ActivityTypePageView: 0.05, ActivityTypeAddToCart: 0}
+	export := func(opts ...ExportOption) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := ExportSampled(context.Background(), &buf, spec, opts...); err != nil {
+			t.Fatalf("ExportSampled() failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	ids, summary := readSample(t, export(ExportSeed(42)))
+	views := summary.Counts[ActivityTypePageView]
+	if views < 60 || views > 140 {
+		t.Errorf("kept %d of 2000 page views, want about 5%%", views)
+	}
+	if summary.Counts[ActivityTypeCheckout] != 20 || summary.Counts[ActivityTypeAddToCart] != 0 {
+		t.Errorf("counts = %v, want every checkout and no cart add", summary.Counts)
+	}
+	if len(ids) != views+20 || summary.Seed != 42 || !reflect.DeepEqual(summary.Rates, spec) {
+		t.Errorf("exported %d activities with summary %+v, want %d with seed 42", len(ids), summary, views+20)
+	}
+
+	// The same seed keeps the same activities, another seed others
+	if again, _ := readSample(t, export(ExportSeed(42))); !reflect.DeepEqual(again, ids) {
+		t.Error("exports with the same seed differ")
+	}
+	if other, _ := readSample(t, export(ExportSeed(7))); reflect.DeepEqual(other, ids) {
+		t.Error("exports with different seeds are the same")
+	}
+	// Activities logged since don't change which earlier ones are kept
+	mustLog(t, &ActivityLog{SessionID: "session-1", ActivityType: ActivityTypePageView})
+	if later, _ := readSample(t, export(ExportSeed(42))); !reflect.DeepEqual(later[:len(ids)], ids) {
+		t.Error("a later export with the same seed keeps other activities")
+	}
+
+	// Filtered before sampling
+	if ids, summary := readSample(t, export(ExportSeed(42), ExportFilter(Filter{Types: []string{ActivityTypeCheckout}}))); len(ids) != 20 || len(summary.Counts) != 1 {
+		t.Errorf("filtered export has %d activities, counts %v; want the 20 checkouts", len(ids), summary.Counts)
+	}
+}
+
+func TestExportSampledRejectsInvalidSpecs(t *testing.T) {
+	setupTestDB(t)
+	for _, spec := range []map[string]float64{
+		{"no_such_type": 0.5},
+		{ActivityTypePageView: 1.5},
+		{ActivityTypePageView: -0.1},
+	} {
+		var buf bytes.Buffer
+		if err := ExportSampled(context.Background(), &buf, spec); err == nil || buf.Len() != 0 {
+			t.Errorf("ExportSampled(%v) = %v after writing %d bytes, want an error and nothing written", spec, err, buf.Len())
+		}
+	}
+}
+
+func TestExportSampledWaitsForAnAnalyticalSlot(t *testing.T) {
+	setupTestDB(t)
+	ConfigureAnalyticalQueries(1, 20*time.Millisecond)
+	t.Cleanup(func() { ConfigureAnalyticalQueries(0, 0) })
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+
+	release, err := acquireAnalytical(context.Background())
+	if err != nil {
+		t.Fatalf("acquireAnalytical() failed: %v", err)
+	}
+	defer release()
+	var buf bytes.Buffer
+	if err := ExportSampled(context.Background(), &buf, nil); !errors.Is(err, ErrBusy) || buf.Len() != 0 {
+		t.Errorf("ExportSampled() with every slot taken = %v after writing %d bytes, want ErrBusy and nothing written", err, buf.Len())
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// maxPath is how much of a path is stored
+const maxPath = 256
+
+// maxInvalidValue is how much of a rejected value is kept in raw_invalid
+const maxInvalidValue = 64
+
+// detailFormats are the formats of details taken straight from requests.
+// Values that don't match are moved to raw_invalid.
+var detailFormats = map[string]*regexp.Regexp{
+	"product_id":        regexp.MustCompile(`^[A-Za-z0-9]{1,64}$`),
+	"quantity":          regexp.MustCompile(`^[0-9]{1,4}$`),
+	"new_currency":      currencyCode,
+	"previous_currency": currencyCode,
+	"utm_source":        utmValue,
+	"utm_medium":        utmValue,
+	"utm_campaign":      utmValue,
+}
+
+// currencyCode is an ISO 4217 currency code
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// requestIDFormat matches the UUIDs the frontend assigns to requests
+var requestIDFormat = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// sanitizeDetails makes request-controlled details safe to store and show.
+// Details with a known format that don't match it are replaced by an
+// escaped, truncated copy under raw_invalid; control characters are
+// stripped from every other string.
+func sanitizeDetails(details map[string]interface{}) {
+	var invalid map[string]string
+	for key, value := range details {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		format, known := detailFormats[key]
+		switch {
+		case !known:
+			details[key] = stripControl(s)
+		case s == "" || format.MatchString(s):
+		default:
+			delete(details, key)
+			if invalid == nil {
+				invalid = make(map[string]string)
+			}
+			invalid[key] = escapeInvalid(s)
+		}
+	}
+	if len(invalid) > 0 {
+		details["raw_invalid"] = invalid
+	}
+}
+
+// sanitizeCurrency returns the currency if it is a currency code, and
+// otherwise CurrencyInvalid and the escaped value for raw_invalid. No
+// currency at all stays empty.
+func sanitizeCurrency(currency string) (string, string) {
+	if currency == "" || currencyCode.MatchString(currency) {
+		return currency, ""
+	}
+	return CurrencyInvalid, escapeInvalid(currency)
+}
+
+// escapeInvalid turns a rejected value into something inert to keep for
+// debugging. Values already escaped stay as they are, so html.UnescapeString
+// always gives back what was rejected, truncated.
+func escapeInvalid(s string) string {
+	s = stripControl(html.UnescapeString(s))
+	if len(s) > maxInvalidValue {
+		s = strings.ToValidUTF8(s[:maxInvalidValue], "")
+	}
+	return html.EscapeString(s)
+}
+
+// stripControl removes control characters such as NUL, turning line breaks
+// and tabs into spaces
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// normalizePath returns the path stored for a request. Routed requests
+// are stored under their route template, so there are only as many paths
+// as routes; the templates are kept as registered. Any other path, which
+// can be anything a client sent, is stripped of what isn't printable,
+// lowercased and capped to maxPath bytes.
+func normalizePath(r *http.Request) string {
+	if template := routeTemplate(r); template != "" {
+		return template
+	}
+	path := strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(r.URL.Path, ""))
+	return truncatePath(strings.ToLower(path))
+}
+
+// truncatePath caps a path to maxPath bytes, without splitting a character
+func truncatePath(path string) string {
+	if len(path) > maxPath {
+		path = strings.ToValidUTF8(path[:maxPath], "")
+	}
+	return path
+}
+
+// rawPathDetail keeps the path of a request as sent, before unescaping,
+// when the stored path can't tell what was requested: for failed
+// requests that didn't match a route.
+func rawPathDetail(r *http.Request, activity *ActivityLog, details map[string]interface{}) {
+	if activity.RouteTemplate == "" && activity.StatusCode >= http.StatusBadRequest {
+		details["raw_path"] = truncatePath(r.URL.EscapedPath())
+	}
+}
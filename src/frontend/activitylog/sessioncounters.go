@@ -0,0 +1,431 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrSessionNotFound is returned for sessions without activities left
+var ErrSessionNotFound = errors.New("activitylog: session not found")
+
+// sessionCounterSample is how many sessions the nightly consistency check
+// compares against their raw activities
+const sessionCounterSample = 100
+
+// sessionCountersDrift is the number of sessions whose counters the last
+// consistency check found out of step with their activities
+var sessionCountersDrift = expvar.NewInt("activity_log_session_counters_drift")
+
+// storedDetail is the value at path of the details of row, NULL unless
+// they are stored as JSON text. The triggers run wherever the database is
+// written from, where activity_details() may not be registered, so they
+// read details as stored; encodeStoredDetails never compresses the details
+// they read.
+func storedDetail(row, path string) string {
+	return "CASE WHEN typeof(" + row + ".details) = 'text' AND json_valid(" + row + ".details) THEN json_extract(" +
+		row + ".details, '" + path + "') END"
+}
+
+// isType is 1 when row is an activity of the given type, and 0 otherwise
+func isType(row, activityType string) string {
+	return "(" + row + ".activity_type = '" + activityType + "')"
+}
+
+// isCartAdd is 1 when row is a cart add that didn't fail, which is what
+// session summaries count, and 0 otherwise. Details that aren't JSON can't
+// say it failed.
+func isCartAdd(row string) string {
+	return "(" + row + ".activity_type = '" + ActivityTypeAddToCart + "' AND COALESCE(" +
+		storedDetail(row, "$.result") + ", '') != '" + ResultFailed + "')"
+}
+
+// isCheckout is 1 when row is a checkout that didn't fail, which is what
+// session summaries count, and 0 otherwise. Details that aren't JSON can't
+// say it failed.
+func isCheckout(row string) string {
+	return "(" + row + ".activity_type = '" + ActivityTypeCheckout + "' AND NOT COALESCE(" +
+		storedDetail(row, "$.failed") + ", 0))"
+}
+
+// countedDetails tells whether the session counters read the details of
+// activities of a type
+func countedDetails(activityType string) bool {
+	return activityType == ActivityTypeAddToCart || activityType == ActivityTypeCheckout
+}
+
+// sessionCountersColumns are the columns of session_counters
+const sessionCountersColumns = `session_id, user_id, first_seen, last_seen, activities,
+	page_views, product_views, add_to_carts, checkouts, has_checkout`
+
+// countSessions sums up the sessions of the live activities matching where,
+// in the order of sessionCountersColumns
+func countSessions(where string) string {
+	checkout := isCheckout("activities")
+	return `
+		SELECT session_id, MAX(user_id), MIN(created_at), MAX(created_at), COUNT(*),
+			   SUM(` + isType("activities", ActivityTypePageView) + `),
+			   SUM(` + isType("activities", ActivityTypeProductView) + `),
+			   SUM(` + isCartAdd("activities") + `),
+			   SUM(` + checkout + `), SUM(` + checkout + `) > 0
+		FROM activities
+		WHERE deleted_at IS NULL` + where + `
+		GROUP BY session_id`
+}
+
+// uncountActivity takes the activity OLD out of its session's counters,
+// dropping them once the session has no activities left. The session's
+// first or last activity being removed is the only case that reads the
+// activities of the session.
+func uncountActivity() string {
+	checkout := isCheckout("OLD")
+	return `
+		UPDATE session_counters SET
+			activities = activities - 1,
+			page_views = page_views - ` + isType("OLD", ActivityTypePageView) + `,
+			product_views = product_views - ` + isType("OLD", ActivityTypeProductView) + `,
+			add_to_carts = add_to_carts - ` + isCartAdd("OLD") + `,
+			checkouts = checkouts - ` + checkout + `,
+			has_checkout = checkouts - ` + checkout + ` > 0,
+			first_seen = CASE WHEN first_seen = OLD.created_at THEN COALESCE(
+				(SELECT MIN(created_at) FROM activities WHERE session_id = OLD.session_id AND deleted_at IS NULL),
+				first_seen) ELSE first_seen END,
+			last_seen = CASE WHEN last_seen = OLD.created_at THEN COALESCE(
+				(SELECT MAX(created_at) FROM activities WHERE session_id = OLD.session_id AND deleted_at IS NULL),
+				last_seen) ELSE last_seen END
+		WHERE session_id = OLD.session_id;
+		DELETE FROM session_counters WHERE session_id = OLD.session_id AND activities <= 0;`
+}
+
+// sessionCountersMigration creates session_counters, which sums up every
+// session so that session summaries don't scan its activities. Triggers
+// keep it up to date in the transaction of each write to activities, so
+// no code path can forget to: an upsert for each new activity, and
+// adjustments for removals, soft deletes, attributions and cart adds and
+// checkouts marked failed. It is filled in from the activities already logged.
+func sessionCountersMigration() string {
+	return `
+	CREATE TABLE IF NOT EXISTS session_counters (
+		session_id TEXT PRIMARY KEY,
+		user_id TEXT,
+		-- Copies of created_at, text like aggregates of it return
+		first_seen TEXT NOT NULL,
+		last_seen TEXT NOT NULL,
+		activities INTEGER NOT NULL,
+		page_views INTEGER NOT NULL,
+		product_views INTEGER NOT NULL,
+		add_to_carts INTEGER NOT NULL,
+		checkouts INTEGER NOT NULL,
+		has_checkout INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_session_counters_last_seen ON session_counters(last_seen);
+	CREATE INDEX IF NOT EXISTS idx_session_counters_user ON session_counters(user_id, last_seen);` + sessionCounterTriggers("activities", "") + `
+	INSERT INTO session_counters (` + sessionCountersColumns + `)` + countSessions("") + `;`
+}
+
+// sessionCounterTriggers are the triggers keeping session_counters up to
+// date with the writes to table, named with prefix
+func sessionCounterTriggers(table, prefix string) string {
+	return `
+	CREATE TRIGGER IF NOT EXISTS ` + prefix + `count_session_insert AFTER INSERT ON ` + table + `
+	WHEN NEW.deleted_at IS NULL
+	BEGIN
+		INSERT INTO session_counters (` + sessionCountersColumns + `)
+		VALUES (NEW.session_id, NEW.user_id, NEW.created_at, NEW.created_at, 1,
+			` + isType("NEW", ActivityTypePageView) + `,
+			` + isType("NEW", ActivityTypeProductView) + `,
+			` + isCartAdd("NEW") + `,
+			` + isCheckout("NEW") + `,
+			` + isCheckout("NEW") + `)
+		ON CONFLICT (session_id) DO UPDATE SET
+			user_id = COALESCE(user_id, excluded.user_id),
+			first_seen = MIN(first_seen, excluded.first_seen),
+			last_seen = MAX(last_seen, excluded.last_seen),
+			activities = activities + 1,
+			page_views = page_views + excluded.page_views,
+			product_views = product_views + excluded.product_views,
+			add_to_carts = add_to_carts + excluded.add_to_carts,
+			checkouts = checkouts + excluded.checkouts,
+			has_checkout = checkouts + excluded.checkouts > 0;
+	END;
+	CREATE TRIGGER IF NOT EXISTS ` + prefix + `count_session_delete AFTER DELETE ON ` + table + `
+	WHEN OLD.deleted_at IS NULL
+	BEGIN` + uncountActivity() + `
+	END;
+	CREATE TRIGGER IF NOT EXISTS ` + prefix + `count_session_soft_delete AFTER UPDATE OF deleted_at ON ` + table + `
+	WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL
+	BEGIN` + uncountActivity() + `
+	END;
+	CREATE TRIGGER IF NOT EXISTS ` + prefix + `count_session_user AFTER UPDATE OF user_id ON ` + table + `
+	WHEN NEW.user_id IS NOT NULL AND NEW.deleted_at IS NULL
+	BEGIN
+		UPDATE session_counters SET user_id = NEW.user_id
+		WHERE session_id = NEW.session_id AND user_id IS NULL;
+	END;
+	CREATE TRIGGER IF NOT EXISTS ` + prefix + `count_session_details AFTER UPDATE OF details ON ` + table + `
+	WHEN NEW.activity_type IN ('` + ActivityTypeAddToCart + `', '` + ActivityTypeCheckout + `') AND NEW.deleted_at IS NULL
+	BEGIN
+		UPDATE session_counters SET
+			add_to_carts = add_to_carts + ` + isCartAdd("NEW") + ` - ` + isCartAdd("OLD") + `,
+			checkouts = checkouts + ` + isCheckout("NEW") + ` - ` + isCheckout("OLD") + `,
+			has_checkout = checkouts + ` + isCheckout("NEW") + ` - ` + isCheckout("OLD") + ` > 0
+		WHERE session_id = NEW.session_id;
+	END;`
+}
+
+// sessionCounterTriggerNames are the names of the triggers
+// sessionCounterTriggers creates, and of those it no longer does
+var sessionCounterTriggerNames = []string{
+	"count_session_insert", "count_session_delete", "count_session_soft_delete",
+	"count_session_user", "count_session_details", "count_session_checkout",
+}
+
+// sessionCounterTriggersPlaceholder stands in a migration for the session
+// counter triggers of every table activities are stored in, which
+// partitionedMigration replaces with replaceSessionCounterTriggers
+const sessionCounterTriggersPlaceholder = "-- session counter triggers\n"
+
+// replaceSessionCounterTriggers drops the session counter triggers of
+// tables and creates them again. Partitions name theirs after themselves.
+func replaceSessionCounterTriggers(tables []string, prefixed bool) string {
+	var b strings.Builder
+	for _, table := range tables {
+		prefix := ""
+		if prefixed {
+			prefix = table + "_"
+		}
+		for _, name := range sessionCounterTriggerNames {
+			b.WriteString("\n\tDROP TRIGGER IF EXISTS " + prefix + name + ";")
+		}
+		b.WriteString(sessionCounterTriggers(table, prefix))
+	}
+	return b.String()
+}
+
+// recountSessionsMigration replaces the session counter triggers of
+// databases whose triggers decompressed details with activity_details(),
+// which only this process registers, and counted failed cart adds, and
+// counts every session again
+func recountSessionsMigration() string {
+	return sessionCounterTriggersPlaceholder + `
+	DELETE FROM session_counters;
+	INSERT INTO session_counters (` + sessionCountersColumns + `)` + countSessions("") + `;`
+}
+
+// sessionSummaryColumns select a SessionSummary from session_counters
+const sessionSummaryColumns = `session_id, COALESCE(user_id, ''), activities, page_views, product_views,
+	add_to_carts, checkouts, first_seen, last_seen`
+
+// GetSessionSummary sums up a session from its counters, without reading
+// its activities. It returns ErrSessionNotFound when the session has none.
+func GetSessionSummary(sessionID string) (SessionSummary, error) {
+	rows, err := getReadDB().Query("SELECT "+sessionSummaryColumns+" FROM session_counters WHERE session_id = ?", sessionID)
+	if err != nil {
+		return SessionSummary{}, err
+	}
+	sessions, err := scanSessionSummaries(rows)
+	if err != nil {
+		return SessionSummary{}, err
+	}
+	if len(sessions) == 0 {
+		return SessionSummary{}, ErrSessionNotFound
+	}
+	return sessions[0], nil
+}
+
+// sessionsOnly tells whether f restricts activities to sessions or a user
+// and nothing else, so that whole sessions match and their counters can
+// sum them up
+func (f Filter) sessionsOnly() bool {
+	rest := f
+	rest.SessionID, rest.SessionIDs, rest.UserID = "", nil, ""
+	if len(rest.Tags) == 0 {
+		rest.Tags = nil
+	}
+	if len(rest.Details) == 0 {
+		rest.Details = nil
+	}
+	return reflect.DeepEqual(rest, Filter{})
+}
+
+// listSessionCounters is GetSessionSummaries for filters that only pick
+// sessions, served from their counters
+func listSessionCounters(f Filter, limit int) ([]SessionSummary, error) {
+	clauses, args := f.clauses()
+	where := ""
+	for i, clause := range clauses {
+		if i == 0 {
+			where = "WHERE "
+		} else {
+			where += " AND "
+		}
+		where += clause
+	}
+	query := `
+		SELECT ` + sessionSummaryColumns + `
+		FROM session_counters
+		` + where + `
+		ORDER BY last_seen DESC, session_id
+		LIMIT ?`
+	rows, err := getReadDB().Query(query, append(args, boundLimit(limit))...)
+	if err != nil {
+		return nil, err
+	}
+	return scanSessionSummaries(rows)
+}
+
+func scanSessionSummaries(rows *sql.Rows) ([]SessionSummary, error) {
+	defer rows.Close()
+	sessions := []SessionSummary{}
+	for rows.Next() {
+		var s SessionSummary
+		var first, last string
+		if err := rows.Scan(&s.SessionID, &s.UserID, &s.Activities, &s.PageViews, &s.ProductViews,
+			&s.AddToCarts, &s.Checkouts, &first, &last); err != nil {
+			return nil, err
+		}
+		var err error
+		if s.FirstSeen, err = parseSQLiteTime(first); err != nil {
+			return nil, err
+		}
+		if s.LastSeen, err = parseSQLiteTime(last); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// RebuildSessionCounters recomputes the counters of every session from
+// their activities, repairing any drift, and returns the number of sessions
+func RebuildSessionCounters(ctx context.Context) (int64, error) {
+	tx, err := GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM session_counters"); err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, "INSERT INTO session_counters ("+sessionCountersColumns+")"+countSessions(""))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// sessionCounters is a session_counters row, or what it should be
+type sessionCounters struct {
+	UserID                                                     string
+	FirstSeen, LastSeen                                        string
+	Activities, PageViews, ProductViews, AddToCarts, Checkouts int
+	HasCheckout                                                bool
+}
+
+// CheckSessionCounters compares the counters of a random sample of
+// sessions, including sessions only their activities or only their
+// counters know about, with their activities. Sessions that drifted are
+// logged and their number returned; RebuildSessionCounters repairs them.
+func CheckSessionCounters(ctx context.Context, sample int) (int, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	query := `
+		WITH sampled AS (
+			SELECT session_id FROM (
+				SELECT DISTINCT session_id FROM activities WHERE deleted_at IS NULL ORDER BY RANDOM() LIMIT ?)
+			UNION
+			SELECT session_id FROM (SELECT session_id FROM session_counters ORDER BY RANDOM() LIMIT ?)
+		), raw (` + sessionCountersColumns + `) AS (` +
+		countSessions(" AND session_id IN (SELECT session_id FROM sampled)") + `
+		)
+		SELECT s.session_id,
+			   COALESCE(r.user_id, ''), COALESCE(r.first_seen, ''), COALESCE(r.last_seen, ''),
+			   COALESCE(r.activities, 0), COALESCE(r.page_views, 0), COALESCE(r.product_views, 0),
+			   COALESCE(r.add_to_carts, 0), COALESCE(r.checkouts, 0), COALESCE(r.has_checkout, 0),
+			   COALESCE(c.user_id, ''), COALESCE(c.first_seen, ''), COALESCE(c.last_seen, ''),
+			   COALESCE(c.activities, 0), COALESCE(c.page_views, 0), COALESCE(c.product_views, 0),
+			   COALESCE(c.add_to_carts, 0), COALESCE(c.checkouts, 0), COALESCE(c.has_checkout, 0)
+		FROM sampled s
+		LEFT JOIN raw r ON r.session_id = s.session_id
+		LEFT JOIN session_counters c ON c.session_id = s.session_id`
+	rows, err := getReadDB().QueryContext(ctx, query, sample, sample)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	drifted := 0
+	for rows.Next() {
+		var sessionID string
+		var raw, counted sessionCounters
+		if err := rows.Scan(&sessionID,
+			&raw.UserID, &raw.FirstSeen, &raw.LastSeen, &raw.Activities, &raw.PageViews,
+			&raw.ProductViews, &raw.AddToCarts, &raw.Checkouts, &raw.HasCheckout,
+			&counted.UserID, &counted.FirstSeen, &counted.LastSeen, &counted.Activities, &counted.PageViews,
+			&counted.ProductViews, &counted.AddToCarts, &counted.Checkouts, &counted.HasCheckout); err != nil {
+			return 0, err
+		}
+		if raw != counted {
+			drifted++
+			logger.WithFields(logrus.Fields{
+				"session_id": sessionID,
+				"activities": raw,
+				"counters":   counted,
+			}).Warn("session counters drifted from the activities")
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	sessionCountersDrift.Set(int64(drifted))
+	return drifted, nil
+}
+
+// StartSessionCounterCheck checks a sample of session counters after every
+// UTC midnight, until ctx is done
+func StartSessionCounterCheck(ctx context.Context) {
+	go func() {
+		for {
+			now := Now()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(truncateDay(now).Add(day).Sub(now)):
+			}
+
+			if n, err := CheckSessionCounters(ctx, sessionCounterSample); err != nil && ctx.Err() == nil {
+				logger.WithError(err).Warn("failed to check the session counters")
+			} else if n > 0 {
+				logger.WithField("sessions", n).Warn("session counters drifted, rebuild them to repair")
+			}
+		}
+	}()
+}
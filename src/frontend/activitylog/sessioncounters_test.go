@@ -0,0 +1,202 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionCountersFollowWrites(t *testing.T) {
+	fc := setupTestDB(t)
+	ctx := context.Background()
+	first := fc.Now()
+	view := &ActivityLog{SessionID: "s", ActivityType: ActivityTypePageView}
+	mustLog(t, view)
+	fc.Advance(time.Minute)
+	mustLog(t, &ActivityLog{SessionID: "s", ActivityType: ActivityTypeProductView})
+	mustLog(t, &ActivityLog{SessionID: "s", ActivityType: ActivityTypeAddToCart})
+	cartAdd := &ActivityLog{SessionID: "s", ActivityType: ActivityTypeAddToCart}
+	mustLog(t, cartAdd)
+	mustLog(t, &ActivityLog{SessionID: "s", ActivityType: ActivityTypeAddToCart, Details: `{"result":"failed"}`})
+	fc.Advance(time.Minute)
+	checkout := &ActivityLog{SessionID: "s", ActivityType: ActivityTypeCheckout}
+	mustLog(t, checkout)
+
+	want := SessionSummary{SessionID: "s", Activities: 6, PageViews: 1, ProductViews: 1, AddToCarts: 2, Checkouts: 1,
+		FirstSeen: first, LastSeen: fc.Now()}
+	if got, err := GetSessionSummary("s"); err != nil || got != want {
+		t.Errorf("GetSessionSummary() = %+v, %v, want %+v", got, err, want)
+	}
+
+	// A checkout marked failed once handled stops counting
+	if err := mergeActivityDetails(ctx, checkout.ID, `{"failed":true}`); err != nil {
+		t.Fatalf("mergeActivityDetails() failed: %v", err)
+	}
+	want.Checkouts = 0
+	if got, _ := GetSessionSummary("s"); got != want {
+		t.Errorf("GetSessionSummary() after a failed checkout = %+v, want %+v", got, want)
+	}
+	// So does a cart add that failed
+	if err := mergeActivityDetails(ctx, cartAdd.ID, `{"result":"failed"}`); err != nil {
+		t.Fatalf("mergeActivityDetails() failed: %v", err)
+	}
+	want.AddToCarts = 1
+	if got, _ := GetSessionSummary("s"); got != want {
+		t.Errorf("GetSessionSummary() after a failed cart add = %+v, want %+v", got, want)
+	}
+
+	if _, err := AttributeSession(ctx, "s", "alice"); err != nil {
+		t.Fatalf("AttributeSession() failed: %v", err)
+	}
+	want.UserID = "alice"
+	if got, _ := GetSessionSummary("s"); got != want {
+		t.Errorf("GetSessionSummary() after attribution = %+v, want %+v", got, want)
+	}
+
+	// Removing the first activity moves the session's start
+	if _, err := DeleteByFilter(ctx, Filter{Types: []string{ActivityTypePageView}}); err != nil {
+		t.Fatalf("DeleteByFilter() failed: %v", err)
+	}
+	want.Activities, want.PageViews, want.FirstSeen = 5, 0, first.Add(time.Minute)
+	if got, _ := GetSessionSummary("s"); got != want {
+		t.Errorf("GetSessionSummary() after a purge = %+v, want %+v", got, want)
+	}
+
+	if _, err := DeleteSession(ctx, "s"); err != nil {
+		t.Fatalf("DeleteSession() failed: %v", err)
+	}
+	if _, err := GetSessionSummary("s"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("GetSessionSummary() of a deleted session = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestSessionCounterTriggersReadDetailsAsStored(t *testing.T) {
+	setupTestDB(t)
+	var n int
+	if err := GetDB().QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND sql LIKE '%activity_details(%'").Scan(&n); err != nil {
+		t.Fatalf("reading the triggers failed: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("%d triggers decompress details with activity_details(), which only this process registers", n)
+	}
+}
+
+func TestSessionCountersMatchActivities(t *testing.T) {
+	fc := setupTestDB(t)
+	if err := ConfigureDetailsCodec(DetailsCodecGzip, 1); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ConfigureDetailsCodec(DetailsCodecNone, 0) })
+	types := []string{ActivityTypePageView, ActivityTypeProductView, ActivityTypeAddToCart, ActivityTypeCheckout, ActivityTypeViewCart}
+	for i := 0; i < 60; i++ {
+		a := &ActivityLog{SessionID: fmt.Sprintf("session-%d", i%7), ActivityType: types[i%len(types)]}
+		switch i % 11 {
+		case 3:
+			a.Details = `{"failed":true}`
+		case 5:
+			a.Details = `{"failed":`
+		}
+		mustLog(t, a)
+		fc.Advance(time.Second)
+	}
+
+	counted, err := GetSessionSummaries(context.Background(), Filter{}, 10)
+	if err != nil {
+		t.Fatalf("GetSessionSummaries() failed: %v", err)
+	}
+	raw, err := GetSessionSummaries(context.Background(), Filter{End: fc.Now()}, 10)
+	if err != nil {
+		t.Fatalf("GetSessionSummaries() failed: %v", err)
+	}
+	if len(counted) != 7 || !reflect.DeepEqual(counted, raw) {
+		t.Errorf("summaries from the counters = %+v, want those from the activities %+v", counted, raw)
+	}
+	if n, err := CheckSessionCounters(context.Background(), 10); err != nil || n != 0 {
+		t.Errorf("CheckSessionCounters() = %d, %v, want no drift", n, err)
+	}
+
+	// Drift is found and repaired
+	if _, err := GetDB().Exec("UPDATE session_counters SET activities = 99 WHERE session_id = 'session-1'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetDB().Exec("DELETE FROM session_counters WHERE session_id = 'session-2'"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := CheckSessionCounters(context.Background(), 10); err != nil || n != 2 {
+		t.Errorf("CheckSessionCounters() = %d, %v, want 2 sessions drifted", n, err)
+	}
+	if n, err := RebuildSessionCounters(context.Background()); err != nil || n != 7 {
+		t.Errorf("RebuildSessionCounters() = %d, %v, want 7 sessions", n, err)
+	}
+	if n, err := CheckSessionCounters(context.Background(), 10); err != nil || n != 0 {
+		t.Errorf("CheckSessionCounters() after the rebuild = %d, %v, want no drift", n, err)
+	}
+}
+
+func TestFilterSessionsOnly(t *testing.T) {
+	for _, tc := range []struct {
+		f    Filter
+		want bool
+	}{
+		{Filter{}, true},
+		{Filter{UserID: "alice"}, true},
+		{Filter{SessionIDs: []string{"a", "b"}, Tags: map[string]string{}}, true},
+		{Filter{UserID: "alice", Start: time.Now()}, false},
+		{Filter{SessionID: "a", Types: []string{ActivityTypeCheckout}}, false},
+		{Filter{Tags: map[string]string{"tier": "gold"}}, false},
+	} {
+		if got := tc.f.sessionsOnly(); got != tc.want {
+			t.Errorf("%+v.sessionsOnly() = %v, want %v", tc.f, got, tc.want)
+		}
+	}
+}
+
+// BenchmarkSessionSummary compares summing up a session from its counters
+// with summing up its activities, for sessions of growing size
+func BenchmarkSessionSummary(b *testing.B) {
+	for _, size := range []int{10, 100, 1000} {
+		setupTestDB(b)
+		values := make([]string, size)
+		for i := range values {
+			values[i] = fmt.Sprintf("('s', 'r', '%s', '/', 'GET', 200, 'USD', '{}', '2025-06-01 12:00:%02d+00:00')",
+				ActivityTypePageView, i%60)
+		}
+		if _, err := GetDB().Exec(`INSERT INTO activities (session_id, request_id, activity_type, path, method, status_code, user_currency, details, created_at)
+			VALUES ` + strings.Join(values, ", ")); err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(fmt.Sprintf("counters/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := GetSessionSummary("s"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("activities/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := GetSessionSummaries(context.Background(), Filter{SessionID: "s", End: time.Now()}, 1); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// queryer runs read queries, on the read pool or within a snapshot
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// ReadStore runs the analytical queries of the activity log. Those of a
+// ReadStore passed by WithSnapshot all see the same activities, however
+// many are logged meanwhile. Each query is canceled with its own ctx, so
+// one can be given up on without ending the snapshot.
+type ReadStore interface {
+	ActivityStats(ctx context.Context, filter Filter) (map[string]int, error)
+	Funnel(ctx context.Context, startTime, endTime time.Time, steps []string) ([]FunnelStep, error)
+	TopProducts(ctx context.Context, startTime, endTime time.Time, limit int) ([]ProductActivity, error)
+	NormalizedStats(ctx context.Context, startTime, endTime time.Time) (*NormalizedStats, error)
+	UnclassifiedPaths(ctx context.Context, startTime, endTime time.Time, limit int) ([]UnclassifiedPath, error)
+}
+
+// snapshotStore is the ReadStore of a read transaction
+type snapshotStore struct {
+	tx *sql.Tx
+}
+
+// WithSnapshot runs fn with a ReadStore whose queries all see the
+// activities as they were when the first of them ran, so that figures
+// computed by different queries agree with each other. The snapshot takes
+// a single analytical query slot, however many queries fn runs, and ends
+// when fn returns: fn must not keep the ReadStore.
+//
+// In SQLite the snapshot is a deferred read transaction, which in WAL
+// mode keeps reading the database as of its first read while writes go
+// on. A server store would use a repeatable read transaction instead,
+// which the options ask for and SQLite ignores.
+func WithSnapshot(ctx context.Context, fn func(ReadStore) error) error {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	tx, err := getReadDB().BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	// Nothing was written, rolling back just ends the snapshot
+	defer tx.Rollback()
+	return fn(snapshotStore{tx: tx})
+}
+
+func (s snapshotStore) ActivityStats(ctx context.Context, filter Filter) (map[string]int, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	return activityStats(ctx, s.tx, filter)
+}
+
+func (s snapshotStore) Funnel(ctx context.Context, startTime, endTime time.Time, steps []string) ([]FunnelStep, error) {
+	if err := ValidateFunnel(steps); err != nil {
+		return nil, err
+	}
+	return funnel(ctx, s.tx, startTime, endTime, steps, IdentitySession)
+}
+
+func (s snapshotStore) TopProducts(ctx context.Context, startTime, endTime time.Time, limit int) ([]ProductActivity, error) {
+	return topProducts(ctx, s.tx, startTime, endTime, limit)
+}
+
+func (s snapshotStore) NormalizedStats(ctx context.Context, startTime, endTime time.Time) (*NormalizedStats, error) {
+	return normalizedTotals(ctx, s.tx, startTime, endTime)
+}
+
+func (s snapshotStore) UnclassifiedPaths(ctx context.Context, startTime, endTime time.Time, limit int) ([]UnclassifiedPath, error) {
+	return unclassifiedPaths(ctx, s.tx, startTime, endTime, limit)
+}
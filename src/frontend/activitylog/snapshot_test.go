@@ -0,0 +1,52 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSnapshotIgnoresLaterWrites(t *testing.T) {
+	fc := setupTestDB(t)
+	start := fc.Now()
+	mustLog(t, &ActivityLog{SessionID: "s1", ActivityType: ActivityTypePageView})
+	end := start.Add(time.Hour)
+
+	err := WithSnapshot(context.Background(), func(s ReadStore) error {
+		before, err := s.ActivityStats(context.Background(), Filter{Start: start, End: end})
+		if err != nil {
+			return err
+		}
+		mustLog(t, &ActivityLog{SessionID: "s2", ActivityType: ActivityTypePageView})
+		after, err := s.NormalizedStats(context.Background(), start, end)
+		if err != nil {
+			return err
+		}
+		if before[ActivityTypePageView] != 1 || after.Sessions != 1 {
+			t.Errorf("snapshot saw %d page views then %d sessions, want the one logged before", before[ActivityTypePageView], after.Sessions)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithSnapshot() failed: %v", err)
+	}
+
+	// Outside of it, the write shows
+	if got, err := GetNormalizedStats(context.Background(), start, end); err != nil || got.Sessions != 2 {
+		t.Errorf("GetNormalizedStats() = %+v, %v, want 2 sessions", got, err)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// StatusHeader is the response header the middleware reports the State of
+// activity logging in, so that it shows with any request
+const StatusHeader = "X-Activity-Status"
+
+// States of activity logging
+const (
+	// StateOK is logging every activity without errors
+	StateOK = "ok"
+	// StateDegraded is dropping activities: the last write failed, writes
+	// are suspended by the breaker or only essential activities are logged
+	StateDegraded = "degraded"
+	// StateDisabled is the database not being initialized, or not yet
+	// when it is initialized on first use
+	StateDisabled = "disabled"
+	// StateReadOnly is writes being frozen on purpose, see SetReadOnly
+	StateReadOnly = "read_only"
+)
+
+// ErrNotInitialized is returned by writes before the database is
+// initialized. The middleware drops activities silently in that case.
+var ErrNotInitialized = errors.New("activitylog: database not initialized")
+
+// ActivityStatus is a snapshot of the health of activity logging
+type ActivityStatus struct {
+	State       string `json:"state"`
+	Initialized bool   `json:"initialized"`
+	// InitError is why the database failed to initialize on first use,
+	// see InitDBLazily
+	InitError string `json:"init_error,omitempty"`
+	// LastWriteAt is when the last activity write finished, zero before
+	// the first one. LastWriteError is how it failed, empty if it didn't.
+	LastWriteAt    time.Time `json:"last_write_at"`
+	LastWriteError string    `json:"last_write_error,omitempty"`
+	Breaker        string    `json:"breaker"`
+	LoggingMode    string    `json:"logging_mode"`
+	// PendingWrites is the number of activity writes in flight
+	PendingWrites int `json:"pending_writes"`
+	// ReadOnly is whether writes are frozen, ReadOnlySkipped how many
+	// activities weren't written because of it
+	ReadOnly        bool  `json:"read_only"`
+	ReadOnlySkipped int64 `json:"read_only_skipped"`
+}
+
+// lastWrite is the outcome of the last activity write
+var lastWrite = struct {
+	sync.Mutex
+	at  time.Time
+	err error
+}{}
+
+// noteWrite records the outcome of an activity write for Status
+func noteWrite(err error) {
+	lastWrite.Lock()
+	defer lastWrite.Unlock()
+	lastWrite.at = Now()
+	lastWrite.err = err
+}
+
+// Status returns the current health of activity logging. It only reads
+// state kept in memory, so it is cheap enough to call on every request.
+func Status() ActivityStatus {
+	s := ActivityStatus{
+		Initialized:     GetDB() != nil,
+		InitError:       lazyInitFailure(),
+		Breaker:         WriteBreakerState().String(),
+		LoggingMode:     CurrentLoggingMode().String(),
+		ReadOnly:        ReadOnly(),
+		ReadOnlySkipped: ReadOnlySkipped(),
+	}
+	writeBacklog.Lock()
+	s.PendingWrites = writeBacklog.pending
+	writeBacklog.Unlock()
+	lastWrite.Lock()
+	s.LastWriteAt = lastWrite.at
+	if lastWrite.err != nil {
+		s.LastWriteError = lastWrite.err.Error()
+	}
+	lastWrite.Unlock()
+
+	switch {
+	case !s.Initialized && s.InitError != "":
+		s.State = StateDegraded
+	case !s.Initialized:
+		s.State = StateDisabled
+	case s.ReadOnly:
+		s.State = StateReadOnly
+	case s.LastWriteError != "" || s.Breaker != BreakerClosed.String() || s.LoggingMode != LoggingFull.String():
+		s.State = StateDegraded
+	default:
+		s.State = StateOK
+	}
+	return s
+}
@@ -0,0 +1,72 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusHeader(t *testing.T) {
+	fc := setupTestDB(t)
+	router := newTestRouter()
+	get := func() string {
+		t.Helper()
+		return serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "session-1").Header().Get(StatusHeader)
+	}
+
+	if got := get(); got != StateOK {
+		t.Errorf("healthy store: %s = %q, want %q", StatusHeader, got, StateOK)
+	}
+	if s := Status(); s.LastWriteAt.IsZero() || s.LastWriteError != "" {
+		t.Errorf("Status() = %+v after a successful write, want it recorded", s)
+	}
+
+	repair := breakWrites(t)
+	get()
+	if got := get(); got != StateDegraded {
+		t.Errorf("failing store: %s = %q, want %q", StatusHeader, got, StateDegraded)
+	}
+	if s := Status(); s.LastWriteError == "" {
+		t.Errorf("Status() = %+v after a failed write, want its error", s)
+	}
+	repair()
+	get()
+	if got := get(); got != StateOK {
+		t.Errorf("repaired store: %s = %q, want %q", StatusHeader, got, StateOK)
+	}
+
+	// Until the breaker closes again, writes are dropped
+	ConfigureWriteBreaker(1, 0)
+	repair = breakWrites(t)
+	get()
+	repair()
+	if s := Status(); s.State != StateDegraded || s.Breaker != BreakerOpen.String() {
+		t.Errorf("Status() = %+v with the breaker open, want degraded", s)
+	}
+	fc.Advance(defaultBreakerCooldown)
+	get()
+	if got := get(); got != StateOK {
+		t.Errorf("after the probe: %s = %q, want %q", StatusHeader, got, StateOK)
+	}
+
+	db.Store(nil)
+	readDB.Store(nil)
+	w := serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "session-1")
+	if got := w.Header().Get(StatusHeader); got != StateDisabled || w.Code != http.StatusOK {
+		t.Errorf("disabled store: status %d, %s = %q; want 200 and %q", w.Code, StatusHeader, got, StateDisabled)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// subscribers are the channels live activities are published to
+var subscribers = struct {
+	sync.RWMutex
+	chans map[chan ActivityLog]struct{}
+}{chans: make(map[chan ActivityLog]struct{})}
+
+// Subscribe returns a channel receiving every activity logged from now on,
+// and a function to stop receiving them. Activities are dropped rather than
+// slowing down LogActivity when the subscriber falls more than buffer
+// activities behind.
+func Subscribe(buffer int) (<-chan ActivityLog, func()) {
+	ch := make(chan ActivityLog, buffer)
+	subscribers.Lock()
+	subscribers.chans[ch] = struct{}{}
+	subscribers.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			subscribers.Lock()
+			delete(subscribers.chans, ch)
+			subscribers.Unlock()
+		})
+	}
+}
+
+// published is a link in the chain of logged activities long polls follow:
+// done is closed once activity and next are set. Waiting on the current
+// link wakes every poll on the next activity at once.
+type published struct {
+	done     chan struct{}
+	activity ActivityLog
+	next     *published
+}
+
+// logged is the link the next logged activity is published to
+var logged = struct {
+	sync.Mutex
+	next *published
+}{next: &published{done: make(chan struct{})}}
+
+// publish hands a logged activity to the subscribers that keep up and wakes
+// the long polls waiting for it
+func publish(activity ActivityLog) {
+	logged.Lock()
+	link := logged.next
+	link.activity = activity
+	link.next = &published{done: make(chan struct{})}
+	logged.next = link.next
+	close(link.done)
+	logged.Unlock()
+
+	subscribers.RLock()
+	defer subscribers.RUnlock()
+	for ch := range subscribers.chans {
+		select {
+		case ch <- activity:
+		default:
+			streamDropped.Add(1)
+		}
+	}
+}
+
+// ActivitiesAfter returns the activities of the given types, or of every
+// type when none are given, whose ID is above afterID, in ID order and at
+// most limit of them. Replaying them until none are left, and switching to
+// Subscribe then, catches a follower up on what it missed.
+func ActivitiesAfter(ctx context.Context, afterID int64, types []string, limit int) ([]ActivityLog, error) {
+	filter := Filter{Types: types}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	where, args := filter.where()
+	query := `
+		SELECT ` + activityColumns + `
+		FROM ` + filter.from() + `
+		` + where + ` AND id > ?
+		ORDER BY id
+		LIMIT ?`
+
+	start := time.Now()
+	rows, err := getReadDB().QueryContext(ctx, query, append(args, afterID, boundLimit(limit))...)
+	observeQuery(ctx, "replay activities", start)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var activities []ActivityLog
+	for rows.Next() {
+		var activity ActivityLog
+		if err := scanActivity(rows, &activity); err != nil {
+			return nil, err
+		}
+		activities = append(activities, activity)
+	}
+	return activities, rows.Err()
+}
+
+// WaitForActivities is ActivitiesAfter, waiting for one of the activities
+// to be logged when there aren't any yet. Waiters don't poll the database:
+// they are woken together by each activity this process logs and only query
+// again for one they'd return. It returns no activities once ctx is done.
+func WaitForActivities(ctx context.Context, afterID int64, types []string, limit int) ([]ActivityLog, error) {
+	wanted := make(map[string]bool, len(types))
+	for _, activityType := range types {
+		wanted[activityType] = true
+	}
+	for {
+		// Activities logged after the query runs are published past link
+		logged.Lock()
+		link := logged.next
+		logged.Unlock()
+
+		activities, err := ActivitiesAfter(ctx, afterID, types, limit)
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		if err != nil || len(activities) > 0 {
+			return activities, err
+		}
+
+		pollWaiters.Add(1)
+		for {
+			select {
+			case <-ctx.Done():
+				pollWaiters.Add(-1)
+				return nil, nil
+			case <-link.done:
+			}
+			if link.activity.ID > afterID && (len(wanted) == 0 || wanted[link.activity.ActivityType]) {
+				break
+			}
+			link = link.next
+		}
+		pollWaiters.Add(-1)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLogActivityPublishesToSubscribers(t *testing.T) {
+	fc := setupTestDB(t)
+	activities, unsubscribe := Subscribe(1)
+	defer unsubscribe()
+
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	// The subscriber is full, this one is dropped
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout})
+
+	got := <-activities
+	if got.ID == 0 || got.ActivityType != ActivityTypePageView || !got.CreatedAt.Equal(fc.Now()) {
+		t.Errorf("published %+v, want the stored page view", got)
+	}
+	select {
+	case a := <-activities:
+		t.Errorf("published %+v to a subscriber that fell behind", a)
+	default:
+	}
+
+	unsubscribe()
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	select {
+	case a := <-activities:
+		t.Errorf("published %+v after unsubscribing", a)
+	default:
+	}
+}
+
+func TestActivitiesAfter(t *testing.T) {
+	setupTestDB(t)
+	for _, typ := range []string{ActivityTypePageView, ActivityTypeCheckout, ActivityTypePageView, ActivityTypePageView} {
+		mustLog(t, &ActivityLog{ActivityType: typ})
+	}
+
+	got, err := ActivitiesAfter(context.Background(), 1, []string{ActivityTypePageView}, 1)
+	if err != nil {
+		t.Fatalf("ActivitiesAfter() failed: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != 3 {
+		t.Fatalf("ActivitiesAfter(1) = %+v, want the page view with ID 3", got)
+	}
+	got, err = ActivitiesAfter(context.Background(), got[0].ID, nil, 10)
+	if err != nil {
+		t.Fatalf("ActivitiesAfter() failed: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != 4 {
+		t.Errorf("ActivitiesAfter(3) = %+v, want the last activity", got)
+	}
+}
+
+func TestWaitForActivitiesReturnsLoggedActivities(t *testing.T) {
+	setupTestDB(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout})
+
+	got, err := WaitForActivities(context.Background(), 0, nil, 1)
+	if err != nil {
+		t.Fatalf("WaitForActivities() failed: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != 1 {
+		t.Errorf("WaitForActivities(0) = %+v, want the first activity at once", got)
+	}
+}
+
+func TestWaitForActivitiesTimesOut(t *testing.T) {
+	setupTestDB(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	got, err := WaitForActivities(ctx, 1, nil, 10)
+	if err != nil || got != nil {
+		t.Errorf("WaitForActivities() = %+v, %v, want nothing once timed out", got, err)
+	}
+	if n := pollWaiters.Value(); n != 0 {
+		t.Errorf("%d polls still waiting", n)
+	}
+}
+
+func TestWaitForActivitiesWakesOnWrite(t *testing.T) {
+	setupTestDB(t)
+	const waiters = 50
+	results := make(chan []ActivityLog, waiters)
+	for range waiters {
+		go func() {
+			got, err := WaitForActivities(context.Background(), 0, []string{ActivityTypeCheckout}, 10)
+			if err != nil {
+				t.Errorf("WaitForActivities() failed: %v", err)
+			}
+			results <- got
+		}()
+	}
+	for pollWaiters.Value() != waiters {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Only the checkout is waited for
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout})
+	for range waiters {
+		select {
+		case got := <-results:
+			if len(got) != 1 || got[0].ActivityType != ActivityTypeCheckout {
+				t.Errorf("WaitForActivities() = %+v, want the checkout", got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("WaitForActivities() wasn't woken by the checkout")
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+func TestStrictModeRefusesChangesThatCantBeLogged(t *testing.T) {
+	setupTestDB(t)
+	repair := breakWrites(t)
+	defer repair()
+
+	handled := false
+	log := logrus.New()
+	log.Out = io.Discard
+	r := mux.NewRouter()
+	r.HandleFunc("/cart/checkout", func(w http.ResponseWriter, _ *http.Request) {
+		handled = true
+	}).Methods(http.MethodPost)
+	r.Use(func(next http.Handler) http.Handler {
+		return NewActivityMiddleware(log, next, WithStrictWrites())
+	})
+
+	rr := serve(r, postForm("/cart/checkout", "email=a@example.com"), "session-1")
+	if handled {
+		t.Error("the checkout was placed although it couldn't be logged")
+	}
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want JSON", ct)
+	}
+	var body struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil || body.Error.Code != http.StatusServiceUnavailable || body.Error.Message == "" {
+		t.Errorf("body = %+v (%v), want the JSON error", body, err)
+	}
+}
+
+func TestStrictModeLogsBeforeAndCompletesAfterTheHandler(t *testing.T) {
+	setupTestDB(t)
+	log := logrus.New()
+	log.Out = io.Discard
+	r := mux.NewRouter()
+	r.HandleFunc("/cart", func(w http.ResponseWriter, req *http.Request) {
+		// The activity is on record before the change is made
+		a := lastActivity(t)
+		if a.RequestID != "request-session-1" || a.StatusCode != 0 {
+			t.Errorf("activity before the handler = %+v, want it logged without a status", a)
+		}
+		if got := detailsOf(t, a)["product_id"]; got != "OLJCESPC7Z" {
+			t.Errorf("product_id before the handler = %v", got)
+		}
+		AddDetail(req.Context(), "cart_items", 3)
+		w.WriteHeader(http.StatusFound)
+	}).Methods(http.MethodPost)
+	r.Use(func(next http.Handler) http.Handler {
+		return NewActivityMiddleware(log, next, WithStrictWrites())
+	})
+
+	serve(r, postForm("/cart", "product_id=OLJCESPC7Z&quantity=2"), "session-1")
+	if n := countActivities(t); n != 1 {
+		t.Fatalf("logged %d activities, want a single one", n)
+	}
+	a := lastActivity(t)
+	if a.StatusCode != http.StatusFound || a.ActivityType != ActivityTypeAddToCart {
+		t.Errorf("activity = %+v, want the add to cart with the response status", a)
+	}
+	details := detailsOf(t, a)
+	if details["product_id"] != "OLJCESPC7Z" || details["quantity"] != "2" || details["cart_items"] != float64(3) {
+		t.Errorf("details = %v, want the request and handler details", details)
+	}
+}
+
+func TestStrictModeKeepsReadsBestEffort(t *testing.T) {
+	setupTestDB(t)
+	repair := breakWrites(t)
+	defer repair()
+
+	rr := serve(newTestRouter(WithStrictWrites()), httptest.NewRequest(http.MethodGet, "/product/OLJCESPC7Z", nil), "session-1")
+	if rr.Code != http.StatusOK {
+		t.Errorf("status = %d, want reads served although they can't be logged", rr.Code)
+	}
+}
+
+func TestUpdateActivityStatus(t *testing.T) {
+	setupTestDB(t)
+	checkout := &ActivityLog{SessionID: "session-1", RequestID: "request-1", ActivityType: ActivityTypeCheckout}
+	mustLog(t, checkout)
+	// A client event of the page the checkout served shares its request ID
+	mustLog(t, &ActivityLog{SessionID: "session-1", RequestID: "request-1", ActivityType: ActivityTypePageUnload, Source: SourceClient})
+
+	if err := UpdateActivityStatus(context.Background(), checkout.ID, http.StatusOK, 1500*time.Millisecond); err != nil {
+		t.Fatalf("UpdateActivityStatus() failed: %v", err)
+	}
+	if a := lastActivity(t); a.StatusCode != 0 || a.LatencyMs != 0 {
+		t.Errorf("client event = %+v, want it left alone", a)
+	}
+	var status, latency int
+	if err := GetDB().QueryRow("SELECT status_code, latency_ms FROM activities WHERE id = ?", checkout.ID).Scan(&status, &latency); err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK || latency != 1500 {
+		t.Errorf("checkout status = %d after %dms, want 200 after 1500ms", status, latency)
+	}
+}
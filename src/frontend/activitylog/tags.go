@@ -0,0 +1,83 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// maxTagValue is how much of a tag cookie's value is kept, in bytes
+const maxTagValue = 64
+
+// tagName is the format of tag names, which are cookie names that can be
+// used as a JSON path key
+var tagName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidateTagName checks that a cookie can be used as a tag
+func ValidateTagName(name string) error {
+	if !tagName.MatchString(name) {
+		return fmt.Errorf("invalid tag %q, must be 1 to 64 letters, digits, underscores or dashes", name)
+	}
+	return nil
+}
+
+// tagPath is the JSON path of a tag in the details. The key is quoted so
+// that dashes aren't read as operators.
+func tagPath(name string) string {
+	return `$.tags."` + name + `"`
+}
+
+// WithTagCookies copies the values of the named cookies into the details of
+// every activity, under "tags", so that activities can be segmented by
+// them, such as by a loyalty tier set by marketing. Values are stripped of
+// control characters and capped to 64 bytes; cookies the request doesn't
+// have are left out. Names that fail ValidateTagName are ignored.
+func WithTagCookies(names ...string) Option {
+	return func(m *ActivityMiddleware) {
+		for _, name := range names {
+			if ValidateTagName(name) == nil {
+				m.tagCookies = append(m.tagCookies, name)
+			}
+		}
+	}
+}
+
+// cookieTags returns the values of the tag cookies r has
+func cookieTags(r *http.Request, names []string) map[string]string {
+	var tags map[string]string
+	for _, name := range names {
+		c, err := r.Cookie(name)
+		if err != nil || c.Value == "" {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[name] = sanitizeTag(c.Value)
+	}
+	return tags
+}
+
+// sanitizeTag makes a cookie value safe to store as a tag
+func sanitizeTag(value string) string {
+	value = stripControl(value)
+	if len(value) > maxTagValue {
+		value = strings.ToValidUTF8(value[:maxTagValue], "")
+	}
+	return value
+}
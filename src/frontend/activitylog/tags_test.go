@@ -0,0 +1,110 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareRecordsTagCookies(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter(WithTagCookies("loyalty_tier", "ab-bucket", "not a name"))
+
+	tests := []struct {
+		name    string
+		cookies map[string]string
+		want    map[string]interface{}
+	}{
+		{"present", map[string]string{"loyalty_tier": "gold", "ab-bucket": "b"},
+			map[string]interface{}{"loyalty_tier": "gold", "ab-bucket": "b"}},
+		{"one absent", map[string]string{"loyalty_tier": "silver", "other": "x"},
+			map[string]interface{}{"loyalty_tier": "silver"}},
+		{"oversized", map[string]string{"loyalty_tier": strings.Repeat("g", 4*maxTagValue)},
+			map[string]interface{}{"loyalty_tier": strings.Repeat("g", maxTagValue)}},
+		{"empty", map[string]string{"loyalty_tier": "", "ab-bucket": "a"},
+			map[string]interface{}{"ab-bucket": "a"}},
+		{"all absent", nil, nil},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for name, value := range tt.cookies {
+			req.Header.Add("Cookie", name+"="+value)
+		}
+		serve(router, req, "session-1")
+		details := detailsOf(t, lastActivity(t))
+		tags, _ := details["tags"].(map[string]interface{})
+		if !reflect.DeepEqual(tags, tt.want) {
+			t.Errorf("%s: tags = %#v, want %#v", tt.name, details["tags"], tt.want)
+		}
+	}
+
+	setupTestDB(t)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "loyalty_tier", Value: "gold"})
+	serve(newTestRouter(), req, "session-1")
+	if details := detailsOf(t, lastActivity(t)); details["tags"] != nil {
+		t.Errorf("tags without WithTagCookies = %v, want none", details["tags"])
+	}
+}
+
+func TestSanitizeTag(t *testing.T) {
+	tests := map[string]string{
+		"gold":                           "gold",
+		"gold\x00\x07":                   "gold",
+		"line\nbreak":                    "line break",
+		strings.Repeat("é", maxTagValue): strings.Repeat("é", maxTagValue/2),
+	}
+	for value, want := range tests {
+		if got := sanitizeTag(value); got != want {
+			t.Errorf("sanitizeTag(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestFilterByTag(t *testing.T) {
+	setupTestDB(t)
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Details: `{"tags":{"loyalty_tier":"gold","ab-bucket":"a"}}`})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Details: `{"tags":{"loyalty_tier":"gold","ab-bucket":"b"}}`})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout, Details: `{"tags":{"loyalty_tier":"silver"}}`})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout})
+
+	tests := []struct {
+		tags map[string]string
+		want map[string]int
+	}{
+		{map[string]string{"loyalty_tier": "gold"}, map[string]int{ActivityTypePageView: 2}},
+		{map[string]string{"loyalty_tier": "gold", "ab-bucket": "b"}, map[string]int{ActivityTypePageView: 1}},
+		{map[string]string{"loyalty_tier": "silver"}, map[string]int{ActivityTypeCheckout: 1}},
+		{map[string]string{"loyalty_tier": "platinum"}, map[string]int{}},
+	}
+	for _, tt := range tests {
+		got, err := GetActivityStats(context.Background(), Filter{Tags: tt.tags})
+		if err != nil {
+			t.Fatalf("GetActivityStats(%v) failed: %v", tt.tags, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetActivityStats(%v) = %v, want %v", tt.tags, got, tt.want)
+		}
+	}
+
+	if _, err := GetActivities(Filter{Tags: map[string]string{`x") OR 1=1 --`: "gold"}}, 10); err == nil {
+		t.Error("filtering on an invalid tag name succeeded")
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"container/list"
+	"errors"
+	"expvar"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ActivityTypeThrottled is a request refused because its session went over
+// the request rate limit. Only the first of each minute is logged.
+var ActivityTypeThrottled = RegisterActivityType("throttled", "A request refused because its session went over the request rate limit")
+
+// maxRateSessions bounds the sessions the rate limiter tracks. Beyond it the
+// least recently seen are forgotten, so that a flood of fresh session
+// cookies can't exhaust memory.
+const maxRateSessions = 10000
+
+// throttledRequests counts the requests refused, per how many times over
+// the limit their session was: under_2x, 2x_to_5x, 5x_to_10x and
+// 10x_or_more. Shoppers clicking fast land in the first; scrapers in the
+// last.
+var throttledRequests = expvar.NewMap("activity_log_throttled_requests")
+
+// SessionRateLimiter counts the requests of each session per minute, and
+// throttles those over a limit. Requests to exempt path prefixes, and
+// requests without a session, are neither counted nor throttled.
+type SessionRateLimiter struct {
+	perMinute   int
+	exempt      []string
+	maxSessions int
+
+	mu       sync.Mutex
+	sessions map[string]*list.Element
+	// recent orders the sessions by when they were last seen, most recent
+	// first
+	recent *list.List
+}
+
+// sessionRate is the requests of a session in the current minute
+type sessionRate struct {
+	sessionID string
+	window    time.Time
+	requests  int
+	// logged is whether a throttled activity was logged in the window
+	logged bool
+}
+
+// rateDecision is what the rate limiter makes of a request
+type rateDecision struct {
+	throttled bool
+	// log is whether the request is the first throttled in its window
+	log        bool
+	requests   int
+	retryAfter time.Duration
+}
+
+// NewSessionRateLimiter creates a rate limiter allowing each session
+// perMinute requests per minute, except to the given path prefixes
+func NewSessionRateLimiter(perMinute int, exempt ...string) *SessionRateLimiter {
+	return &SessionRateLimiter{
+		perMinute:   perMinute,
+		exempt:      exempt,
+		maxSessions: maxRateSessions,
+		sessions:    make(map[string]*list.Element),
+		recent:      list.New(),
+	}
+}
+
+// WithSessionRateLimiter makes the middleware refuse the requests of
+// sessions over the limiter's rate with 429 Too Many Requests, before the
+// handler runs
+func WithSessionRateLimiter(l *SessionRateLimiter) Option {
+	return func(m *ActivityMiddleware) {
+		m.rateLimiter = l
+	}
+}
+
+// check counts a request and tells whether it is throttled
+func (l *SessionRateLimiter) check(r *http.Request) rateDecision {
+	sessionID, _ := r.Context().Value(CtxKeySessionID{}).(string)
+	if sessionID == "" {
+		return rateDecision{}
+	}
+	for _, prefix := range l.exempt {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return rateDecision{}
+		}
+	}
+
+	now := Now()
+	window := now.Truncate(time.Minute)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var rate *sessionRate
+	if e, ok := l.sessions[sessionID]; ok {
+		l.recent.MoveToFront(e)
+		rate = e.Value.(*sessionRate)
+	} else {
+		rate = &sessionRate{sessionID: sessionID}
+		l.sessions[sessionID] = l.recent.PushFront(rate)
+		for l.recent.Len() > l.maxSessions {
+			oldest := l.recent.Back()
+			delete(l.sessions, oldest.Value.(*sessionRate).sessionID)
+			l.recent.Remove(oldest)
+		}
+	}
+	if !rate.window.Equal(window) {
+		*rate = sessionRate{sessionID: sessionID, window: window}
+	}
+	rate.requests++
+	if rate.requests <= l.perMinute {
+		return rateDecision{}
+	}
+	d := rateDecision{
+		throttled:  true,
+		log:        !rate.logged,
+		requests:   rate.requests,
+		retryAfter: window.Add(time.Minute).Sub(now),
+	}
+	rate.logged = true
+	return d
+}
+
+// rateBucket names how many times over perMinute requests are
+func rateBucket(requests, perMinute int) string {
+	switch over := float64(requests) / float64(perMinute); {
+	case over < 2:
+		return "under_2x"
+	case over < 5:
+		return "2x_to_5x"
+	case over < 10:
+		return "5x_to_10x"
+	default:
+		return "10x_or_more"
+	}
+}
+
+// throttle refuses a request of a session over the rate limit, logging it
+// as throttled when it is the first of its window and the session is
+// tracked
+func (m *ActivityMiddleware) throttle(w http.ResponseWriter, r *http.Request, d rateDecision) {
+	throttledRequests.Add(rateBucket(d.requests, m.rateLimiter.perMinute), 1)
+	retryAfter := int(d.retryAfter.Round(time.Second) / time.Second)
+	writeRefusal(w, http.StatusTooManyRequests, max(retryAfter, 1), "too many requests, please slow down")
+	if !d.log || !TrackingAllowed(r) {
+		return
+	}
+
+	sessionID, _ := r.Context().Value(CtxKeySessionID{}).(string)
+	requestID, _ := r.Context().Value(CtxKeyRequestID{}).(string)
+	activity := &ActivityLog{
+		SessionID:     sessionID,
+		RequestID:     requestID,
+		ActivityType:  ActivityTypeThrottled,
+		Path:          normalizePath(r),
+		RouteTemplate: routeTemplate(r),
+		Method:        r.Method,
+		StatusCode:    http.StatusTooManyRequests,
+		UserCurrency:  currentCurrency(r),
+		Source:        sourceOf(r),
+		Version:       m.version,
+		Revision:      m.revision,
+		FlagsHash:     m.flagsHash,
+	}
+	details := map[string]interface{}{
+		"requests_per_minute": d.requests,
+		"limit":               m.rateLimiter.perMinute,
+	}
+	rawPathDetail(r, activity, details)
+	encodeDetails(activity, details)
+	if err := LogActivityContext(r.Context(), activity); err != nil &&
+		!errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrNotInitialized) && !errors.Is(err, ErrReadOnly) &&
+		!errors.Is(err, ErrSessionQuota) {
+		requestLogger(r.Context(), m.log).WithError(err).Warn("failed to log throttled request")
+	}
+}
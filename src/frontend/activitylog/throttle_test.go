@@ -0,0 +1,120 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareThrottlesHotSession(t *testing.T) {
+	fc := setupTestDB(t)
+	router := newTestRouter(WithSessionRateLimiter(NewSessionRateLimiter(5)))
+	before := throttledCount("under_2x")
+
+	for i := 0; i < 5; i++ {
+		if w := serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "hot"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i+1, w.Code)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		w := serve(router, httptest.NewRequest(http.MethodGet, "/product/OLJCESPC7Z", nil), "hot")
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("request %d over the limit: status = %d, Retry-After %q, want 429 with one",
+				i+6, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+	if w := serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "cool"); w.Code != http.StatusOK {
+		t.Errorf("other session: status = %d, want 200", w.Code)
+	}
+
+	// One throttled activity per window, not one per refused request
+	got, err := queryActivities("SELECT "+activityColumns+" FROM activities WHERE activity_type = ?", ActivityTypeThrottled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].StatusCode != http.StatusTooManyRequests || got[0].RouteTemplate != "/product/{id}" {
+		t.Fatalf("throttled activities = %+v, want one 429 for /product/{id}", got)
+	}
+	if d := detailsOf(t, got[0]); d["limit"] != 5.0 || d["requests_per_minute"] != 6.0 {
+		t.Errorf("details = %v, want the limit and the rate", d)
+	}
+	if n := throttledCount("under_2x") - before; n != 3 {
+		t.Errorf("under_2x throttled = %d, want 3", n)
+	}
+
+	// The next minute starts over
+	fc.Advance(time.Minute)
+	if w := serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "hot"); w.Code != http.StatusOK {
+		t.Errorf("next minute: status = %d, want 200", w.Code)
+	}
+}
+
+func TestSessionRateLimiterExemptsPaths(t *testing.T) {
+	setupTestDB(t)
+	router := newTestRouter(WithSessionRateLimiter(NewSessionRateLimiter(1, "/cart")))
+	for i := 0; i < 3; i++ {
+		if w := serve(router, httptest.NewRequest(http.MethodGet, "/cart", nil), "hot"); w.Code != http.StatusOK {
+			t.Fatalf("exempt request %d: status = %d, want 200", i+1, w.Code)
+		}
+	}
+	serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "hot")
+	if w := serve(router, httptest.NewRequest(http.MethodGet, "/", nil), "hot"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second counted request: status = %d, want 429", w.Code)
+	}
+}
+
+func TestSessionRateLimiterForgetsLeastRecentSessions(t *testing.T) {
+	setupTestDB(t)
+	l := NewSessionRateLimiter(1)
+	l.maxSessions = 3
+	request := func(session string) rateDecision {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		return l.check(req.WithContext(context.WithValue(req.Context(), CtxKeySessionID{}, session)))
+	}
+	request("s0")
+	for i := 1; i <= 3; i++ {
+		request(fmt.Sprintf("s%d", i))
+	}
+	if len(l.sessions) != 3 || l.recent.Len() != 3 {
+		t.Fatalf("tracking %d sessions, want 3", len(l.sessions))
+	}
+	if d := request("s0"); d.throttled {
+		t.Error("forgotten session throttled, want it to start over")
+	}
+	if d := request("s0"); !d.throttled {
+		t.Error("second request of a remembered session allowed, want it throttled")
+	}
+}
+
+func TestRateBucket(t *testing.T) {
+	for requests, want := range map[int]string{11: "under_2x", 20: "2x_to_5x", 60: "5x_to_10x", 100: "10x_or_more"} {
+		if got := rateBucket(requests, 10); got != want {
+			t.Errorf("rateBucket(%d, 10) = %s, want %s", requests, got, want)
+		}
+	}
+}
+
+func throttledCount(bucket string) int64 {
+	if v, ok := throttledRequests.Get(bucket).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
@@ -0,0 +1,80 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// errorPrefixLen is how much of an error groups errors together: enough
+// to tell failures apart, short of the IDs and addresses that follow
+const errorPrefixLen = 80
+
+// ErrorGroup is a kind of error: the errors with the same template,
+// handler and start of the error string
+type ErrorGroup struct {
+	// Template is empty for server errors that weren't rendering ones
+	Template string    `json:"template"`
+	Handler  string    `json:"handler"`
+	Error    string    `json:"error"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// GetTopErrors returns the most frequent errors in a given time period,
+// the render_error activities and those answered with a server error,
+// grouped by template, handler and error prefix.
+func GetTopErrors(ctx context.Context, startTime, endTime time.Time, limit int) ([]ErrorGroup, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "top errors", time.Now())
+
+	query := `
+		SELECT COALESCE(json_extract(` + detailsJSON + `, '$.template'), '') AS template,
+			   COALESCE(json_extract(` + detailsJSON + `, '$.handler'), '') AS handler,
+			   substr(COALESCE(json_extract(` + detailsJSON + `, '$.error'), ''), 1, ?) AS error,
+			   COUNT(*) AS count, MAX(created_at) AS last_seen
+		FROM activities
+		WHERE (activity_type = ? OR status_code >= ?) AND ` + createdIn("created_at") + ` AND deleted_at IS NULL
+		GROUP BY template, handler, error
+		ORDER BY count DESC, last_seen DESC
+		LIMIT ?`
+
+	rows, err := getReadDB().QueryContext(ctx, query, errorPrefixLen, ActivityTypeRenderError, http.StatusInternalServerError,
+		startTime.UTC(), endTime.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []ErrorGroup{}
+	for rows.Next() {
+		var g ErrorGroup
+		var last string
+		if err := rows.Scan(&g.Template, &g.Handler, &g.Error, &g.Count, &last); err != nil {
+			return nil, err
+		}
+		if g.LastSeen, err = parseSQLiteTime(last); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
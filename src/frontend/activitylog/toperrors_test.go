@@ -0,0 +1,83 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestTopErrors(t *testing.T) {
+	fc := setupTestDB(t)
+	log := logrus.New()
+	log.Out = io.Discard
+	router := newBareRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return NewActivityMiddleware(log, next)
+	})
+	// The cart page fails to render, after the status was written; the
+	// product page fails before rendering, with an error mentioning the ID
+	router.HandleFunc("/cart/items", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		AddDetails(r.Context(), RenderErrorDetails{
+			CommonDetails: CommonDetails{Error: `template: cart:12: executing "cart" at <.items>: nil pointer`, Handler: "viewCartHandler"},
+			Template:      "cart",
+		})
+	})
+	router.HandleFunc("/broken/{id}", func(w http.ResponseWriter, r *http.Request) {
+		err := errors.New("could not retrieve product " + strings.Repeat("x", errorPrefixLen) + r.URL.Path)
+		AddDetails(r.Context(), CommonDetails{Error: err.Error(), Handler: "productHandler"})
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	for i := 0; i < 2; i++ {
+		serve(router, httptest.NewRequest(http.MethodGet, "/cart/items", nil), "session-1")
+	}
+	got := lastActivity(t)
+	if got.ActivityType != ActivityTypeRenderError || got.StatusCode != http.StatusOK {
+		t.Errorf("logged %+v, want a render_error", got)
+	}
+	if details := detailsOf(t, got); details["template"] != "cart" || details["handler"] != "viewCartHandler" {
+		t.Errorf("details = %v, want the template and handler", details)
+	}
+	for _, id := range []string{"A", "B", "C"} {
+		fc.Advance(time.Second)
+		serve(router, httptest.NewRequest(http.MethodGet, "/broken/"+id, nil), "session-1")
+	}
+	serve(router, httptest.NewRequest(http.MethodGet, "/cart", nil), "session-1")
+
+	groups, err := GetTopErrors(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("GetTopErrors() failed: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("GetTopErrors() = %+v, want 2 groups", groups)
+	}
+	if g := groups[0]; g.Handler != "productHandler" || g.Template != "" || g.Count != 3 ||
+		len(g.Error) != errorPrefixLen || !g.LastSeen.Equal(fc.Now()) {
+		t.Errorf("top group = %+v, want the 3 product errors, last seen now", g)
+	}
+	if g := groups[1]; g.Handler != "viewCartHandler" || g.Template != "cart" || g.Count != 2 {
+		t.Errorf("second group = %+v, want the 2 cart render errors", g)
+	}
+}
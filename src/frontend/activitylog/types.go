@@ -0,0 +1,71 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"sort"
+	"sync"
+)
+
+// ActivityTypeInfo describes a registered activity type
+type ActivityTypeInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// activityTypes holds the registered activity types by name
+var activityTypes = struct {
+	sync.RWMutex
+	byName map[string]string
+}{byName: make(map[string]string)}
+
+// RegisterActivityType adds an activity type to the registry and returns
+// its name, so that it can be declared as
+//
+//	var ActivityTypeWishlist = RegisterActivityType("wishlist", "...")
+//
+// It panics if the name is empty or already registered.
+func RegisterActivityType(name, description string) string {
+	activityTypes.Lock()
+	defer activityTypes.Unlock()
+	if name == "" {
+		panic("activitylog: empty activity type name")
+	}
+	if _, dup := activityTypes.byName[name]; dup {
+		panic("activitylog: activity type " + name + " registered twice")
+	}
+	activityTypes.byName[name] = description
+	return name
+}
+
+// ActivityTypes returns the registered activity types, sorted by name
+func ActivityTypes() []ActivityTypeInfo {
+	activityTypes.RLock()
+	defer activityTypes.RUnlock()
+	types := make([]ActivityTypeInfo, 0, len(activityTypes.byName))
+	for name, description := range activityTypes.byName {
+		types = append(types, ActivityTypeInfo{Name: name, Description: description})
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types
+}
+
+// IsActivityType tells whether name is a registered activity type
+func IsActivityType(name string) bool {
+	activityTypes.RLock()
+	defer activityTypes.RUnlock()
+	_, ok := activityTypes.byName[name]
+	return ok
+}
@@ -0,0 +1,59 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestActivityTypesListsLoggedTypes(t *testing.T) {
+	setupTestDB(t)
+	registered := make(map[string]bool)
+	for _, info := range ActivityTypes() {
+		if info.Description == "" {
+			t.Errorf("activity type %q has no description", info.Name)
+		}
+		registered[info.Name] = true
+	}
+
+	// Every type the middleware logs is in the registry
+	router := newTestRouter()
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/", nil),
+		httptest.NewRequest(http.MethodGet, "/product/OLJCESPC7Z", nil),
+		httptest.NewRequest(http.MethodGet, "/cart", nil),
+		postForm("/cart", "product_id=OLJCESPC7Z&quantity=1"),
+		postForm("/cart/empty", ""),
+		postForm("/cart/checkout", ""),
+		postForm("/setCurrency", "currency_code=EUR"),
+		httptest.NewRequest(http.MethodGet, "/missing", nil),
+	} {
+		serve(router, req, "session-1")
+		if got := lastActivity(t).ActivityType; !registered[got] {
+			t.Errorf("%s %s logged unregistered type %q", req.Method, req.URL.Path, got)
+		}
+	}
+}
+
+func TestRegisterActivityTypeRejectsDuplicates(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering page_view again didn't panic")
+		}
+	}()
+	RegisterActivityType(ActivityTypePageView, "Again")
+}
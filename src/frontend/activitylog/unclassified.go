@@ -0,0 +1,116 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultUnclassifiedWarning is the share of activities logged as "other"
+// above which routes are reported as missing an activity type
+const DefaultUnclassifiedWarning = 0.1
+
+// unclassifiedWarning is the share of "other" activities to warn above
+var unclassifiedWarning = struct {
+	sync.Mutex
+	fraction float64
+}{fraction: DefaultUnclassifiedWarning}
+
+// ConfigureUnclassifiedWarning sets the share of activities, between 0 and
+// 1, that may be logged as "other" before it is warned about. Values out
+// of range restore the default.
+func ConfigureUnclassifiedWarning(fraction float64) {
+	if fraction <= 0 || fraction >= 1 {
+		fraction = DefaultUnclassifiedWarning
+	}
+	unclassifiedWarning.Lock()
+	defer unclassifiedWarning.Unlock()
+	unclassifiedWarning.fraction = fraction
+}
+
+// UnclassifiedPath is a route whose requests are logged as "other", which
+// usually means it was added without an activity type
+type UnclassifiedPath struct {
+	// RouteTemplate is empty for the activities logged before route
+	// templates were recorded
+	RouteTemplate string `json:"route_template"`
+	Count         int    `json:"count"`
+}
+
+// UnclassifiedShare is how much of the traffic of a period was logged as
+// "other", and whether that is above the configured warning
+type UnclassifiedShare struct {
+	Share     Ratio   `json:"share"`
+	Threshold float64 `json:"threshold"`
+	Warning   bool    `json:"warning"`
+}
+
+// GetUnclassifiedShare computes the share of "other" activities from the
+// counts per type GetActivityStats returns
+func GetUnclassifiedShare(stats map[string]int) UnclassifiedShare {
+	var total int
+	for _, n := range stats {
+		total += n
+	}
+	unclassifiedWarning.Lock()
+	threshold := unclassifiedWarning.fraction
+	unclassifiedWarning.Unlock()
+
+	share := newRatio(stats[ActivityTypeOther], total, 1)
+	return UnclassifiedShare{Share: share, Threshold: threshold, Warning: share.Value > threshold}
+}
+
+// GetUnclassifiedPaths returns the routes most often logged as "other" in
+// a given time period, so that they can be given an activity type. Routes
+// are grouped by their template, so /item/1 and /item/2 count as one.
+func GetUnclassifiedPaths(ctx context.Context, startTime, endTime time.Time, limit int) ([]UnclassifiedPath, error) {
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "unclassified paths", time.Now())
+	return unclassifiedPaths(ctx, getReadDB(), startTime, endTime, limit)
+}
+
+// unclassifiedPaths is GetUnclassifiedPaths run by q. Callers hold an
+// analytical query slot.
+func unclassifiedPaths(ctx context.Context, q queryer, startTime, endTime time.Time, limit int) ([]UnclassifiedPath, error) {
+	query := `
+		SELECT COALESCE(route_template, '') AS route, COUNT(*) AS count
+		FROM activities
+		WHERE activity_type = ? AND ` + createdIn("created_at") + ` AND deleted_at IS NULL
+		GROUP BY route
+		ORDER BY count DESC, route
+		LIMIT ?`
+
+	rows, err := q.QueryContext(ctx, query, ActivityTypeOther, startTime.UTC(), endTime.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := []UnclassifiedPath{}
+	for rows.Next() {
+		var p UnclassifiedPath
+		if err := rows.Scan(&p.RouteTemplate, &p.Count); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, rows.Err()
+}
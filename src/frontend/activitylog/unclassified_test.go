@@ -0,0 +1,70 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetUnclassifiedPaths(t *testing.T) {
+	fc := setupTestDB(t)
+	router := newTestRouter()
+	ok := func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }
+	router.HandleFunc("/wishlist/{id}", ok).Methods(http.MethodGet)
+	router.HandleFunc("/compare", ok).Methods(http.MethodGet)
+
+	for _, path := range []string{"/wishlist/1", "/wishlist/2", "/compare", "/product/OLJCESPC7Z"} {
+		serve(router, httptest.NewRequest(http.MethodGet, path, nil), "session-1")
+	}
+	if got := lastActivity(t); got.RouteTemplate != "/product/{id}" {
+		t.Errorf("route template = %q, want /product/{id}", got.RouteTemplate)
+	}
+	// Logged before route templates were recorded
+	mustLog(t, &ActivityLog{SessionID: "session-0", ActivityType: ActivityTypeOther, Path: "/legacy"})
+
+	paths, err := GetUnclassifiedPaths(context.Background(), fc.Now().Add(-time.Hour), fc.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("GetUnclassifiedPaths() failed: %v", err)
+	}
+	want := []UnclassifiedPath{{"/wishlist/{id}", 2}, {"", 1}, {"/compare", 1}}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("GetUnclassifiedPaths() = %+v, want %+v", paths, want)
+	}
+}
+
+func TestGetUnclassifiedShare(t *testing.T) {
+	ConfigureUnclassifiedWarning(0.25)
+	t.Cleanup(func() { ConfigureUnclassifiedWarning(0) })
+
+	for _, tt := range []struct {
+		stats   map[string]int
+		want    float64
+		warning bool
+	}{
+		{map[string]int{ActivityTypePageView: 3, ActivityTypeOther: 1}, 0.25, false},
+		{map[string]int{ActivityTypePageView: 2, ActivityTypeOther: 1}, 1.0 / 3, true},
+		{map[string]int{}, 0, false},
+	} {
+		got := GetUnclassifiedShare(tt.stats)
+		if got.Share.Value != tt.want || got.Warning != tt.warning || got.Threshold != 0.25 {
+			t.Errorf("GetUnclassifiedShare(%v) = %+v, want share %v and warning %v", tt.stats, got, tt.want, tt.warning)
+		}
+	}
+}
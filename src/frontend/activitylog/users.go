@@ -0,0 +1,137 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrSessionAttributed is returned when attributing a session that already
+// belongs to another user
+var ErrSessionAttributed = errors.New("the session belongs to another user")
+
+// sqliteTimeLayout is how the driver stores times, which aggregates such as
+// MIN(created_at) return as text
+const sqliteTimeLayout = "2006-01-02 15:04:05.999999999-07:00"
+
+// attributeSessionQuery attributes the anonymous activities of a session
+// in table to a user, unless some of its activities belong to another one.
+// It is a single statement for each table, run in one transaction, so that
+// two users can't claim the same session.
+func attributeSessionQuery(table string) string {
+	return `
+	UPDATE ` + table + ` SET user_id = ?
+	WHERE session_id = ? AND user_id IS NULL AND deleted_at IS NULL
+	  AND NOT EXISTS (
+		SELECT 1 FROM activities WHERE session_id = ? AND user_id IS NOT NULL AND user_id != ?)`
+}
+
+// AttributeSession attributes the activities a session logged before its
+// user signed in to that user, and returns their number. Attributing the
+// session to its user again only picks up what was logged anonymously
+// since. A session some activities of which belong to another user is left
+// alone and ErrSessionAttributed returned.
+func AttributeSession(ctx context.Context, sessionID, userID string) (int64, error) {
+	if sessionID == "" || userID == "" {
+		return 0, errors.New("attributing a session requires a session and a user ID")
+	}
+	tx, err := GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	n, err := execEachTable(ctx, tx, attributeSessionQuery, userID, sessionID, sessionID, userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil || n > 0 {
+		return n, err
+	}
+	// Nothing was attributed: either there was nothing left to, or the
+	// session belongs to someone else
+	var other string
+	err = GetDB().QueryRowContext(ctx,
+		"SELECT user_id FROM activities WHERE session_id = ? AND user_id != ? LIMIT 1",
+		sessionID, userID).Scan(&other)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, ErrSessionAttributed
+}
+
+// SessionSummary sums up the activities of one session
+type SessionSummary struct {
+	SessionID string `json:"session_id"`
+	// UserID is the user the session was attributed to, if any
+	UserID       string    `json:"user_id,omitempty"`
+	Activities   int       `json:"activities"`
+	PageViews    int       `json:"page_views"`
+	ProductViews int       `json:"product_views"`
+	AddToCarts   int       `json:"add_to_carts"`
+	Checkouts    int       `json:"checkouts"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// GetSessionSummaries sums up the sessions of the activities matching f,
+// such as all the sessions of a user, most recently active first, at most
+// limit of them. Only the activities matching f are counted. Failed
+// checkouts aren't. Filters that only pick sessions or a user are answered
+// from the session counters, other ones from the activities.
+func GetSessionSummaries(ctx context.Context, f Filter, limit int) ([]SessionSummary, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	release, err := acquireAnalytical(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer observeQuery(ctx, "session summaries", time.Now())
+	if f.sessionsOnly() {
+		return listSessionCounters(f, limit)
+	}
+
+	where, args := f.where()
+	checkout := isCheckout("activities")
+	query := `
+		SELECT session_id, COALESCE(MAX(user_id), ''), COUNT(*),
+			   SUM(` + isType("activities", ActivityTypePageView) + `),
+			   SUM(` + isType("activities", ActivityTypeProductView) + `),
+			   SUM(` + isCartAdd("activities") + `),
+			   SUM(` + checkout + `), MIN(created_at), MAX(created_at) AS last_seen
+		FROM ` + f.from() + `
+		` + where + `
+		GROUP BY session_id
+		ORDER BY last_seen DESC, session_id
+		LIMIT ?`
+	rows, err := getReadDB().QueryContext(ctx, query, append(args, boundLimit(limit))...)
+	if err != nil {
+		return nil, err
+	}
+	return scanSessionSummaries(rows)
+}
+
+// parseSQLiteTime parses a time as the driver stores it, returning it in UTC
+func parseSQLiteTime(s string) (time.Time, error) {
+	t, err := time.Parse(sqliteTimeLayout, s)
+	return t.UTC(), err
+}
@@ -0,0 +1,90 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAttributeSession(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	mustLog(t, &ActivityLog{SessionID: "anonymous", ActivityType: ActivityTypePageView})
+	mustLog(t, &ActivityLog{SessionID: "anonymous", ActivityType: ActivityTypeProductView})
+	mustLog(t, &ActivityLog{SessionID: "other", ActivityType: ActivityTypePageView})
+
+	n, err := AttributeSession(ctx, "anonymous", "alice")
+	if err != nil || n != 2 {
+		t.Fatalf("AttributeSession() = %d, %v; want the 2 activities of the session", n, err)
+	}
+	// Signing in again only picks up what was logged since
+	mustLog(t, &ActivityLog{SessionID: "anonymous", ActivityType: ActivityTypeViewCart})
+	if n, err := AttributeSession(ctx, "anonymous", "alice"); err != nil || n != 1 {
+		t.Errorf("AttributeSession() again = %d, %v; want 1", n, err)
+	}
+	if n, err := AttributeSession(ctx, "anonymous", "alice"); err != nil || n != 0 {
+		t.Errorf("AttributeSession() with nothing new = %d, %v; want 0", n, err)
+	}
+
+	// Another user can't take the session over, even its new activities
+	mustLog(t, &ActivityLog{SessionID: "anonymous", ActivityType: ActivityTypeViewCart})
+	if _, err := AttributeSession(ctx, "anonymous", "bob"); !errors.Is(err, ErrSessionAttributed) {
+		t.Errorf("AttributeSession() to another user = %v, want ErrSessionAttributed", err)
+	}
+	got, err := GetActivities(Filter{UserID: "bob"}, MaxActivities)
+	if err != nil || len(got) != 0 {
+		t.Errorf("activities of bob = %d, %v; want none", len(got), err)
+	}
+	got, err = GetActivities(Filter{UserID: "alice"}, MaxActivities)
+	if err != nil || len(got) != 3 {
+		t.Errorf("activities of alice = %d, %v; want 3", len(got), err)
+	}
+
+	if _, err := AttributeSession(ctx, "", "alice"); err == nil {
+		t.Error("AttributeSession() without a session succeeded, want an error")
+	}
+}
+
+func TestGetSessionSummariesOfUser(t *testing.T) {
+	fc := setupTestDB(t)
+	ctx := context.Background()
+	first := fc.Now()
+	mustLog(t, &ActivityLog{SessionID: "laptop", ActivityType: ActivityTypePageView})
+	mustLog(t, &ActivityLog{SessionID: "laptop", ActivityType: ActivityTypeCheckout})
+	fc.Advance(time.Hour)
+	mustLog(t, &ActivityLog{SessionID: "phone", ActivityType: ActivityTypePageView})
+	mustLog(t, &ActivityLog{SessionID: "someone-else", ActivityType: ActivityTypePageView})
+	for _, session := range []string{"laptop", "phone"} {
+		if _, err := AttributeSession(ctx, session, "alice"); err != nil {
+			t.Fatalf("AttributeSession(%s) failed: %v", session, err)
+		}
+	}
+
+	got, err := GetSessionSummaries(context.Background(), Filter{UserID: "alice"}, 10)
+	if err != nil {
+		t.Fatalf("GetSessionSummaries() failed: %v", err)
+	}
+	want := []SessionSummary{
+		{SessionID: "phone", UserID: "alice", Activities: 1, PageViews: 1, FirstSeen: fc.Now(), LastSeen: fc.Now()},
+		{SessionID: "laptop", UserID: "alice", Activities: 2, PageViews: 1, Checkouts: 1, FirstSeen: first, LastSeen: first},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetSessionSummaries() = %+v, want %+v", got, want)
+	}
+}
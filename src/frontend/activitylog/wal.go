@@ -0,0 +1,278 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultWALCheckpointSize is the size in bytes the write-ahead log may
+// grow to before it is checkpointed and truncated
+const DefaultWALCheckpointSize = 16 << 20
+
+// defaultWALCheckInterval is how often the size of the write-ahead log is
+// checked
+const defaultWALCheckInterval = 30 * time.Second
+
+var walCheckpoint = struct {
+	sync.RWMutex
+	size     int64
+	interval time.Duration
+}{size: DefaultWALCheckpointSize, interval: defaultWALCheckInterval}
+
+// writeGate keeps checkpoints and writes apart: every write holds it for
+// reading between beginWrite and its end, a checkpoint holds it for
+// writing, so that it never lands in the middle of a commit
+var writeGate sync.RWMutex
+
+var (
+	// walBytes is the size of the write-ahead log when it was last checked.
+	walBytes = expvar.NewInt("activity_log_wal_bytes")
+	// walCheckpoints counts checkpoints; walCheckpointMs is how long the
+	// last one took.
+	walCheckpoints  = expvar.NewInt("activity_log_wal_checkpoints_total")
+	walCheckpointMs = expvar.NewInt("activity_log_wal_checkpoint_ms")
+)
+
+// lastCheckpoint is the outcome of the last checkpoint, for GetDBStats
+var lastCheckpoint = struct {
+	sync.Mutex
+	CheckpointResult
+}{}
+
+// ConfigureWALCheckpoint sets the size the write-ahead log is truncated
+// past and how often it is checked. Non-positive values restore the
+// defaults.
+func ConfigureWALCheckpoint(size int64, interval time.Duration) {
+	if size <= 0 {
+		size = DefaultWALCheckpointSize
+	}
+	if interval <= 0 {
+		interval = defaultWALCheckInterval
+	}
+	walCheckpoint.Lock()
+	defer walCheckpoint.Unlock()
+	walCheckpoint.size = size
+	walCheckpoint.interval = interval
+}
+
+// CheckpointResult is what a checkpoint did
+type CheckpointResult struct {
+	At         time.Time `json:"at"`
+	DurationMs int64     `json:"duration_ms"`
+	// Busy is set when readers kept the checkpoint from completing, in
+	// which case the log wasn't truncated
+	Busy bool `json:"busy"`
+	// Frames is the number of pages the log held, Checkpointed how many
+	// of them were copied into the database
+	Frames       int `json:"frames"`
+	Checkpointed int `json:"checkpointed"`
+}
+
+// CheckpointWAL copies the write-ahead log into the database and truncates
+// it. Writes wait for it to finish rather than interleave with it.
+func CheckpointWAL(ctx context.Context) (CheckpointResult, error) {
+	writeGate.Lock()
+	defer writeGate.Unlock()
+
+	start := time.Now()
+	r := CheckpointResult{At: Now().UTC()}
+	err := GetDB().QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&r.Busy, &r.Frames, &r.Checkpointed)
+	r.DurationMs = time.Since(start).Milliseconds()
+	observeQuery(ctx, "checkpoint WAL", start)
+	if err != nil {
+		return r, err
+	}
+	walCheckpoints.Add(1)
+	walCheckpointMs.Set(r.DurationMs)
+	lastCheckpoint.Lock()
+	lastCheckpoint.CheckpointResult = r
+	lastCheckpoint.Unlock()
+	return r, nil
+}
+
+// databaseFile returns the path of the database file
+func databaseFile(ctx context.Context) (string, error) {
+	var seq int
+	var name, file string
+	err := GetDB().QueryRowContext(ctx, "PRAGMA database_list").Scan(&seq, &name, &file)
+	return file, err
+}
+
+// fileSize returns the size of a file, 0 if there is none
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// walSize returns the size of the write-ahead log and records it in the
+// metrics
+func walSize(ctx context.Context) (int64, error) {
+	file, err := databaseFile(ctx)
+	if err != nil {
+		return 0, err
+	}
+	size, err := fileSize(file + "-wal")
+	if err != nil {
+		return 0, err
+	}
+	walBytes.Set(size)
+	return size, nil
+}
+
+// checkpointIfLarge checkpoints the write-ahead log when it grew past the
+// configured size
+func checkpointIfLarge(ctx context.Context) {
+	if GetDB() == nil {
+		return
+	}
+	walCheckpoint.RLock()
+	limit := walCheckpoint.size
+	walCheckpoint.RUnlock()
+
+	size, err := walSize(ctx)
+	if err != nil {
+		logger.WithError(err).Warn("failed to read the WAL size")
+		return
+	}
+	if size <= limit {
+		return
+	}
+	r, err := CheckpointWAL(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.WithError(err).Warn("failed to checkpoint the WAL")
+		}
+		return
+	}
+	fields := logrus.Fields{"wal_bytes": size, "duration_ms": r.DurationMs}
+	if r.Busy {
+		logger.WithFields(fields).Warn("readers kept the WAL from being truncated")
+		return
+	}
+	walSize(ctx)
+	logger.WithFields(fields).Debug("truncated the WAL")
+}
+
+// StartWALCheckpointer checks the size of the write-ahead log periodically,
+// checkpointing and truncating it once it is too large, until ctx is done.
+// SQLite's own checkpoints keep reusing the log without shrinking it, and
+// can't complete while readers hold it.
+func StartWALCheckpointer(ctx context.Context) {
+	walCheckpoint.RLock()
+	interval := walCheckpoint.interval
+	walCheckpoint.RUnlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkpointIfLarge(ctx)
+			}
+		}
+	}()
+}
+
+// DBStats describes the activities database files and how the last
+// maintenance of them went
+type DBStats struct {
+	SizeBytes      int64             `json:"size_bytes"`
+	WALBytes       int64             `json:"wal_bytes"`
+	WALLimitBytes  int64             `json:"wal_limit_bytes"`
+	LastCheckpoint *CheckpointResult `json:"last_checkpoint,omitempty"`
+	// LastIntegrityCheck is the outcome of the last VerifyDB
+	LastIntegrityCheck *IntegrityResult `json:"last_integrity_check,omitempty"`
+	// WritePool and ReadPool are the connections activities are written
+	// through and read through
+	WritePool PoolStats `json:"write_pool"`
+	ReadPool  PoolStats `json:"read_pool"`
+	// PipelineLatencyMaxMs is the longest an activity took to become
+	// durable in the last minute or two
+	PipelineLatencyMaxMs int64 `json:"pipeline_latency_max_ms"`
+}
+
+// PoolStats describe how busy a pool of database connections is
+type PoolStats struct {
+	MaxOpen int `json:"max_open"`
+	Open    int `json:"open"`
+	InUse   int `json:"in_use"`
+	Idle    int `json:"idle"`
+	// WaitCount and WaitMs are how many queries had to wait for a free
+	// connection since the pool was opened, and for how long in all
+	WaitCount int64 `json:"wait_count"`
+	WaitMs    int64 `json:"wait_ms"`
+}
+
+func poolStats(conn *sql.DB) PoolStats {
+	s := conn.Stats()
+	return PoolStats{
+		MaxOpen:   s.MaxOpenConnections,
+		Open:      s.OpenConnections,
+		InUse:     s.InUse,
+		Idle:      s.Idle,
+		WaitCount: s.WaitCount,
+		WaitMs:    s.WaitDuration.Milliseconds(),
+	}
+}
+
+// GetDBStats returns the size of the database and of its write-ahead log,
+// how the last checkpoint went and how busy its connections are
+func GetDBStats(ctx context.Context) (*DBStats, error) {
+	file, err := databaseFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := &DBStats{}
+	if stats.SizeBytes, err = fileSize(file); err != nil {
+		return nil, err
+	}
+	if stats.WALBytes, err = walSize(ctx); err != nil {
+		return nil, err
+	}
+	walCheckpoint.RLock()
+	stats.WALLimitBytes = walCheckpoint.size
+	walCheckpoint.RUnlock()
+	lastCheckpoint.Lock()
+	if !lastCheckpoint.At.IsZero() {
+		r := lastCheckpoint.CheckpointResult
+		stats.LastCheckpoint = &r
+	}
+	lastCheckpoint.Unlock()
+	if r, ok := LastIntegrityCheck(); ok {
+		stats.LastIntegrityCheck = &r
+	}
+	stats.WritePool = poolStats(GetDB())
+	stats.ReadPool = poolStats(getReadDB())
+	stats.PipelineLatencyMaxMs = PipelineLatencyMax().Milliseconds()
+	return stats, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckpointWALTruncates(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	}
+	if stats, err := GetDBStats(ctx); err != nil || stats.WALBytes == 0 || stats.LastCheckpoint != nil {
+		t.Fatalf("GetDBStats() before a checkpoint = %+v, %v, want a WAL", stats, err)
+	}
+
+	r, err := CheckpointWAL(ctx)
+	if err != nil || r.Busy {
+		t.Fatalf("CheckpointWAL() = %+v, %v", r, err)
+	}
+	stats, err := GetDBStats(ctx)
+	if err != nil {
+		t.Fatalf("GetDBStats() failed: %v", err)
+	}
+	if stats.WALBytes != 0 || stats.SizeBytes == 0 || stats.LastCheckpoint == nil || stats.WALLimitBytes != DefaultWALCheckpointSize {
+		t.Errorf("GetDBStats() after a checkpoint = %+v, want an empty WAL", stats)
+	}
+}
+
+func TestCheckpointWaitsForWrites(t *testing.T) {
+	setupTestDB(t)
+	done := beginWrite()
+	checkpointed := make(chan struct{})
+	go func() {
+		CheckpointWAL(context.Background())
+		close(checkpointed)
+	}()
+	select {
+	case <-checkpointed:
+		t.Fatal("CheckpointWAL() ran during a write")
+	case <-time.After(50 * time.Millisecond):
+	}
+	done()
+	select {
+	case <-checkpointed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CheckpointWAL() didn't run once the write was done")
+	}
+}
+
+// TestWALStaysBounded writes 50k activities in concurrent batches, which
+// left to SQLite grow the WAL past 40MB, and checks that the checkpointer
+// keeps it well below the default limit with a 1MB one
+func TestWALStaysBounded(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes 50k activities")
+	}
+	setupTestDB(t)
+	const limit = 1 << 20
+	ConfigureWALCheckpoint(limit, 10*time.Millisecond)
+	t.Cleanup(func() { ConfigureWALCheckpoint(0, 0) })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StartWALCheckpointer(ctx)
+
+	const writers, batches, batchSize = 4, 125, 100
+	row := "(?, 'r', '" + ActivityTypePageView + "', '/', 'GET', 200, 'USD', '{}', ?)"
+	query := `INSERT INTO activities (session_id, request_id, activity_type, path, method, status_code, user_currency, details, created_at)
+		VALUES ` + strings.TrimSuffix(strings.Repeat(row+", ", batchSize), ", ")
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := 0; b < batches; b++ {
+				args := make([]interface{}, 0, 2*batchSize)
+				for i := 0; i < batchSize; i++ {
+					args = append(args, fmt.Sprintf("session-%d", i), Now().UTC())
+				}
+				done := beginWrite()
+				_, err := GetDB().Exec(query, args...)
+				done()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	written := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(written)
+	}()
+
+	var largest int64
+	for {
+		size, err := walSize(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		largest = max(largest, size)
+		select {
+		case <-written:
+			var n int
+			if err := GetDB().QueryRow("SELECT COUNT(*) FROM activities").Scan(&n); err != nil || n != writers*batches*batchSize {
+				t.Errorf("wrote %d activities, %v; want %d", n, err, writers*batches*batchSize)
+			}
+			if largest >= DefaultWALCheckpointSize {
+				t.Errorf("WAL grew to %d bytes, want it kept below %d", largest, DefaultWALCheckpointSize)
+			}
+			return
+		case <-time.After(2 * time.Millisecond):
+		}
+	}
+}
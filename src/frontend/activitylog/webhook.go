@@ -0,0 +1,150 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultWebhookTimeout bounds each POST unless WebhookConfig.Timeout says
+// otherwise
+const defaultWebhookTimeout = 10 * time.Second
+
+// WebhookConfig configures a WebhookSink. Only URL is required.
+type WebhookConfig struct {
+	// URL receives the batches as a JSON array of activities.
+	URL string
+	// Token, when set, is sent as a bearer token.
+	Token string
+	// Secret, when set, signs every payload with HMAC-SHA256 in the
+	// X-Activity-Signature header as "sha256=<hex>".
+	Secret string
+	// BatchSize bounds the activities sent at once.
+	BatchSize int
+	// RetryBackoff is the wait after a network error or 5xx, doubled
+	// after each further one. Batches answered with another error are
+	// retried one activity at a time, and activities rejected MaxAttempts
+	// times are parked in the outbox.
+	RetryBackoff time.Duration
+	MaxAttempts  int
+	// Timeout bounds each POST.
+	Timeout time.Duration
+}
+
+// WebhookSink forwards logged activities to an external endpoint through
+// the outbox, so that LogActivity never waits on the network and the
+// activities logged while the remote is down are delivered once it is
+// back.
+type WebhookSink struct {
+	cfg        WebhookConfig
+	client     *http.Client
+	dispatcher *OutboxDispatcher
+}
+
+// NewWebhookSink creates a sink for cfg, filling in defaults
+func NewWebhookSink(cfg WebhookConfig) *WebhookSink {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultWebhookTimeout
+	}
+	s := &WebhookSink{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+	s.dispatcher = NewOutboxDispatcher(s, OutboxConfig{
+		BatchSize:    cfg.BatchSize,
+		RetryBackoff: cfg.RetryBackoff,
+		MaxAttempts:  cfg.MaxAttempts,
+	})
+	return s
+}
+
+// Name identifies the webhook in the outbox
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Start forwards the activities queued in the outbox until ctx is done
+func (s *WebhookSink) Start(ctx context.Context) error {
+	return s.dispatcher.Start(ctx)
+}
+
+// Wait blocks until the sink has stopped after its context was done
+func (s *WebhookSink) Wait() {
+	s.dispatcher.Wait()
+}
+
+// Send POSTs a batch. Failures other than network errors and server
+// errors are permanent.
+func (s *WebhookSink) Send(ctx context.Context, batch []ActivityLog) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return Permanent(err)
+	}
+	retry, err := s.post(ctx, body)
+	if err != nil {
+		webhookFailedRequests.Add(1)
+		if !retry {
+			return Permanent(err)
+		}
+		return err
+	}
+	webhookSent.Add(int64(len(batch)))
+	return nil
+}
+
+// post sends one request and tells whether a failure is worth retrying
+func (s *WebhookSink) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	}
+	if s.cfg.Secret != "" {
+		req.Header.Set("X-Activity-Signature", SignWebhookPayload(s.cfg.Secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("webhook answered %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+}
+
+// SignWebhookPayload returns the X-Activity-Signature of a payload, for
+// receivers to compare against with hmac.Equal
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,217 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startSink runs a webhook sink until the test ends
+func startSink(t *testing.T, cfg WebhookConfig) *WebhookSink {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := NewWebhookSink(cfg)
+	if err := sink.Start(ctx); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		sink.Wait()
+	})
+	return sink
+}
+
+func TestWebhookSinkDeliversSignedBatches(t *testing.T) {
+	setupTestDB(t)
+	received := make(chan []ActivityLog, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q, want the bearer token", got)
+		}
+		sig := r.Header.Get("X-Activity-Signature")
+		if !hmac.Equal([]byte(sig), []byte(SignWebhookPayload("secret", body))) {
+			t.Errorf("X-Activity-Signature = %q doesn't match the payload", sig)
+		}
+		var batch []ActivityLog
+		if err := json.Unmarshal(body, &batch); err != nil {
+			t.Errorf("payload %s isn't a JSON array of activities: %v", body, err)
+		}
+		received <- batch
+	}))
+	defer srv.Close()
+
+	sent := webhookSent.Value()
+	startSink(t, WebhookConfig{URL: srv.URL, Token: "token", Secret: "secret", BatchSize: 2})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout})
+
+	var got []ActivityLog
+	for len(got) < 2 {
+		select {
+		case batch := <-received:
+			got = append(got, batch...)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the webhook received nothing")
+		}
+	}
+	if len(got) != 2 || got[0].ActivityType != ActivityTypePageView || got[1].ActivityType != ActivityTypeCheckout || got[0].ID == 0 {
+		t.Errorf("received %+v, want the page view and the checkout", got)
+	}
+	waitFor(t, func() bool { return webhookSent.Value() == sent+2 })
+}
+
+func TestWebhookSinkRetriesServerErrors(t *testing.T) {
+	setupTestDB(t)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	sent, failed := webhookSent.Value(), webhookFailedRequests.Value()
+	startSink(t, WebhookConfig{URL: srv.URL, RetryBackoff: time.Millisecond})
+	mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+
+	waitFor(t, func() bool { return webhookSent.Value() == sent+1 })
+	if got := calls.Load(); got != 2 {
+		t.Errorf("webhook called %d times, want 2", got)
+	}
+	if got := webhookFailedRequests.Value() - failed; got != 1 {
+		t.Errorf("failed %d requests, want 1", got)
+	}
+}
+
+func TestWebhookSinkCatchesUpAfterAnOutage(t *testing.T) {
+	setupTestDB(t)
+	var down atomic.Bool
+	down.Store(true)
+	var mu sync.Mutex
+	var got []int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []ActivityLog
+		json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		for _, a := range batch {
+			got = append(got, a.ID)
+		}
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	startSink(t, WebhookConfig{URL: srv.URL, RetryBackoff: time.Millisecond, MaxAttempts: 1})
+	for i := 0; i < 3; i++ {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView})
+	}
+	waitFor(t, func() bool { return webhookFailedRequests.Value() > 0 })
+	down.Store(false)
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) >= 3
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 3 || got[0] >= got[1] || got[1] >= got[2] {
+		t.Errorf("delivered activities %v, want the 3 logged during the outage in order", got)
+	}
+}
+
+func TestWebhookSinkParksRejectedActivities(t *testing.T) {
+	setupTestDB(t)
+	var mu sync.Mutex
+	var delivered []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []ActivityLog
+		json.NewDecoder(r.Body).Decode(&batch)
+		for _, a := range batch {
+			if a.Path == "/poison" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		mu.Lock()
+		for _, a := range batch {
+			delivered = append(delivered, a.Path)
+		}
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	// Everything is queued before the sink starts sending, so that the
+	// poison is in the middle of a batch
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := NewWebhookSink(WebhookConfig{URL: srv.URL, RetryBackoff: time.Millisecond, MaxAttempts: 2})
+	if err := sink.Start(ctx); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	defer sink.Wait()
+	defer cancel()
+	for _, path := range []string{"/first", "/poison", "/last"} {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, Path: path})
+	}
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(delivered) >= 2
+	})
+	mu.Lock()
+	if len(delivered) != 2 || delivered[0] != "/first" || delivered[1] != "/last" {
+		t.Errorf("delivered %v, want all but the poison", delivered)
+	}
+	mu.Unlock()
+	// The cursor moves on once the webhook answered the last batch
+	var status []OutboxSinkStatus
+	waitFor(t, func() bool {
+		var err error
+		if status, err = OutboxStatus(context.Background()); err != nil {
+			t.Fatalf("OutboxStatus() failed: %v", err)
+		}
+		return len(status) == 1 && status[0].Pending == 0
+	})
+	if status[0].Parked != 1 || !strings.Contains(status[0].LastError, "400") {
+		t.Errorf("OutboxStatus() = %+v, want the poison parked after a 400", status)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a while
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"strings"
+	"time"
+)
+
+// Buckets in a time zone other than UTC are aligned on the zone's wall
+// clock: timestamps are turned into seconds since the epoch as if the local
+// time were UTC, bucketed, and the bucket starts turned back into instants.
+// A day bucket then always starts at local midnight, and lasts 23 or 25
+// hours across daylight saving time changes.
+
+// localSeconds returns an SQL expression for the timestamps of column as
+// seconds since the epoch on loc's wall clock, along with its arguments.
+// The zone's offset is looked up for every change between start and end;
+// timestamps outside that range use the offset at the nearest end.
+func localSeconds(column string, loc *time.Location, start, end time.Time) (string, []interface{}) {
+	epoch := "CAST(strftime('%s', " + column + ") AS INTEGER)"
+	if loc == time.UTC {
+		return epoch, nil
+	}
+
+	var cases strings.Builder
+	var args []interface{}
+	t := start.In(loc)
+	for {
+		_, offset := t.Zone()
+		_, zoneEnd := t.ZoneBounds()
+		if zoneEnd.IsZero() || !zoneEnd.Before(end) {
+			args = append(args, offset)
+			break
+		}
+		cases.WriteString(" WHEN " + column + " < ? THEN ?")
+		args = append(args, zoneEnd.UTC(), offset)
+		t = zoneEnd.In(loc)
+	}
+	if len(args) == 1 {
+		return epoch + " + ?", args
+	}
+	return epoch + " + CASE" + cases.String() + " ELSE ? END", args
+}
+
+// wallSeconds is t as seconds since the epoch on loc's wall clock
+func wallSeconds(t time.Time, loc *time.Location) int64 {
+	_, offset := t.In(loc).Zone()
+	return t.Unix() + int64(offset)
+}
+
+// fromWallSeconds is the instant loc's wall clock shows the given seconds
+// since the epoch at
+func fromWallSeconds(seconds int64, loc *time.Location) time.Time {
+	w := time.Unix(seconds, 0).UTC()
+	return time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), w.Second(), 0, loc)
+}
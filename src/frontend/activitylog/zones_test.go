@@ -0,0 +1,136 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activitylog
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	return loc
+}
+
+// hourlyCheckouts logs a checkout at every hour from start to end
+func hourlyCheckouts(t *testing.T, start, end time.Time) {
+	t.Helper()
+	for at := start; at.Before(end); at = at.Add(time.Hour) {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypeCheckout, CreatedAt: at})
+	}
+}
+
+func TestTimeSeriesDaysFollowDaylightSavingTime(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	setupTestDB(t)
+
+	for _, tc := range []struct {
+		name  string
+		day   time.Time
+		hours []int
+	}{
+		// Clocks skipped from 2:00 to 3:00 on March 9 and went back from
+		// 2:00 to 1:00 on November 2
+		{"spring forward", time.Date(2025, 3, 8, 0, 0, 0, 0, newYork), []int{24, 23, 24}},
+		{"fall back", time.Date(2025, 11, 1, 0, 0, 0, 0, newYork), []int{24, 25, 24}},
+	} {
+		start, end := tc.day, tc.day.AddDate(0, 0, len(tc.hours))
+		hourlyCheckouts(t, start, end)
+
+		series, err := GetActivityTimeSeriesIn(context.Background(), Filter{Start: start, End: end}, day, newYork)
+		if err != nil {
+			t.Fatalf("%s: GetActivityTimeSeriesIn() failed: %v", tc.name, err)
+		}
+		if len(series) != len(tc.hours) {
+			t.Fatalf("%s: got %d days, want %d: %+v", tc.name, len(series), len(tc.hours), series)
+		}
+		for i, p := range series {
+			midnight := tc.day.AddDate(0, 0, i)
+			if !p.Start.Equal(midnight) || p.Start.Location() != newYork {
+				t.Errorf("%s: day %d starts at %v, want %v", tc.name, i, p.Start, midnight)
+			}
+			if got := p.Counts[ActivityTypeCheckout]; got != tc.hours[i] {
+				t.Errorf("%s: day %d has %d hours, want %d", tc.name, i, got, tc.hours[i])
+			}
+		}
+	}
+}
+
+func TestTimeSeriesHoursInHalfHourZone(t *testing.T) {
+	kolkata := mustLoadLocation(t, "Asia/Kolkata")
+	setupTestDB(t)
+	for _, at := range []time.Time{
+		time.Date(2025, 6, 1, 10, 15, 0, 0, kolkata),
+		time.Date(2025, 6, 1, 10, 45, 0, 0, kolkata),
+		time.Date(2025, 6, 1, 11, 10, 0, 0, kolkata),
+	} {
+		mustLog(t, &ActivityLog{ActivityType: ActivityTypePageView, CreatedAt: at})
+	}
+
+	start := time.Date(2025, 6, 1, 0, 0, 0, 0, kolkata)
+	series, err := GetActivityTimeSeriesIn(context.Background(), Filter{Start: start, End: start.AddDate(0, 0, 1)}, time.Hour, kolkata)
+	if err != nil {
+		t.Fatalf("GetActivityTimeSeriesIn() failed: %v", err)
+	}
+	if len(series) != 2 ||
+		!series[0].Start.Equal(time.Date(2025, 6, 1, 10, 0, 0, 0, kolkata)) || series[0].Counts[ActivityTypePageView] != 2 ||
+		!series[1].Start.Equal(time.Date(2025, 6, 1, 11, 0, 0, 0, kolkata)) || series[1].Counts[ActivityTypePageView] != 1 {
+		t.Errorf("GetActivityTimeSeriesIn() = %+v, want buckets at 10:00 and 11:00 local time", series)
+	}
+}
+
+func TestTimeSeriesOutsideUTCNeedsRange(t *testing.T) {
+	tokyo := mustLoadLocation(t, "Asia/Tokyo")
+	fc := setupTestDB(t)
+	if _, err := GetActivityTimeSeriesIn(context.Background(), Filter{Start: fc.Now()}, day, tokyo); err == nil {
+		t.Error("GetActivityTimeSeriesIn() without an end succeeded")
+	}
+}
+
+func TestCohortWeeksInTimezone(t *testing.T) {
+	tokyo := mustLoadLocation(t, "Asia/Tokyo")
+	fc := setupTestDB(t)
+	// Wednesday June 4; the week started on Monday June 2 in Tokyo, which
+	// was still Sunday June 1 in UTC
+	fc.Set(time.Date(2025, 6, 4, 12, 0, 0, 0, time.UTC))
+	mustLog(t, &ActivityLog{SessionID: "early", ActivityType: ActivityTypePageView,
+		CreatedAt: time.Date(2025, 6, 1, 16, 0, 0, 0, time.UTC)})
+
+	utc, err := GetCohortReport(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("GetCohortReport() failed: %v", err)
+	}
+	local, err := GetCohortReportIn(context.Background(), 2, tokyo)
+	if err != nil {
+		t.Fatalf("GetCohortReportIn() failed: %v", err)
+	}
+	if utc.Timezone != "UTC" || local.Timezone != "Asia/Tokyo" {
+		t.Errorf("timezones = %q and %q", utc.Timezone, local.Timezone)
+	}
+	if utc.Cohorts[0].Sessions != 1 || utc.Cohorts[1].Sessions != 0 {
+		t.Errorf("UTC cohorts = %+v, want the session in the previous week", utc.Cohorts)
+	}
+	if local.Cohorts[0].Sessions != 0 || local.Cohorts[1].Sessions != 1 {
+		t.Errorf("Tokyo cohorts = %+v, want the session in the current week", local.Cohorts)
+	}
+	if want := time.Date(2025, 6, 2, 0, 0, 0, 0, tokyo); !local.Cohorts[1].WeekStart.Equal(want) {
+		t.Errorf("Tokyo week starts at %v, want %v", local.Cohorts[1].WeekStart, want)
+	}
+}
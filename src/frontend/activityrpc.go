@@ -0,0 +1,163 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+const (
+	// defaultActivityStreams is how many StreamActivities calls are served
+	// at once unless ACTIVITY_GRPC_MAX_STREAMS says otherwise
+	defaultActivityStreams = 16
+	// activityReplayBatch is how many activities a stream replays per query
+	activityReplayBatch = 500
+	// activityStreamBuffer is how far behind the live activities a stream
+	// can fall before they are dropped
+	activityStreamBuffer = 256
+)
+
+// activityRPCServer serves the activity log to other services over gRPC
+type activityRPCServer struct {
+	pb.UnimplementedActivityServiceServer
+	// streams holds a slot per open stream
+	streams chan struct{}
+}
+
+// newActivityRPCServer creates an activity service serving at most
+// maxStreams streams at once, or defaultActivityStreams when not positive
+func newActivityRPCServer(maxStreams int) *activityRPCServer {
+	if maxStreams <= 0 {
+		maxStreams = defaultActivityStreams
+	}
+	return &activityRPCServer{streams: make(chan struct{}, maxStreams)}
+}
+
+// serveActivityRPC serves the activity service on addr until it fails
+func serveActivityRPC(log logrus.FieldLogger, addr string, s *activityRPCServer) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("failed to listen for the activity service: %v", err)
+	}
+	srv := grpc.NewServer()
+	pb.RegisterActivityServiceServer(srv, s)
+	log.Infof("starting activity service on " + addr)
+	log.Fatal(srv.Serve(lis))
+}
+
+// StreamActivities sends the activities logged after req.FromId, then
+// those logged from then on, until the client goes away. Subscribing only
+// once caught up, and replaying once more then, leaves no gap between the
+// two: what the second replay and the subscription both see is sent once,
+// as activities at or below the last replayed ID are skipped.
+func (s *activityRPCServer) StreamActivities(req *pb.StreamRequest, stream pb.ActivityService_StreamActivitiesServer) error {
+	select {
+	case s.streams <- struct{}{}:
+		defer func() { <-s.streams }()
+	default:
+		return status.Errorf(codes.ResourceExhausted, "at most %d activity streams at once", cap(s.streams))
+	}
+	types := make(map[string]bool, len(req.GetTypes()))
+	for _, typ := range req.GetTypes() {
+		if !activitylog.IsActivityType(typ) {
+			return status.Errorf(codes.InvalidArgument, "unknown activity type %q", typ)
+		}
+		types[typ] = true
+	}
+
+	ctx := stream.Context()
+	replayed := req.GetFromId()
+	var err error
+	if replayed > 0 {
+		if replayed, err = replayActivities(ctx, stream, req.GetTypes(), replayed); err != nil {
+			return err
+		}
+	}
+	live, unsubscribe := activitylog.Subscribe(activityStreamBuffer)
+	defer unsubscribe()
+	if replayed > 0 {
+		if replayed, err = replayActivities(ctx, stream, req.GetTypes(), replayed); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case activity := <-live:
+			if activity.ID <= replayed || len(types) > 0 && !types[activity.ActivityType] {
+				continue
+			}
+			if err := stream.Send(activityMessage(activity)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// replayActivities sends the stored activities of the given types with an
+// ID above after, and returns the last ID sent
+func replayActivities(ctx context.Context, stream pb.ActivityService_StreamActivitiesServer, types []string, after int64) (int64, error) {
+	for {
+		activities, err := activitylog.ActivitiesAfter(ctx, after, types, activityReplayBatch)
+		if err != nil {
+			if ctx.Err() != nil {
+				return after, status.FromContextError(ctx.Err()).Err()
+			}
+			return after, status.Errorf(codes.Unavailable, "replaying activities: %v", err)
+		}
+		for _, activity := range activities {
+			if err := stream.Send(activityMessage(activity)); err != nil {
+				return after, err
+			}
+			after = activity.ID
+		}
+		if len(activities) < activityReplayBatch {
+			return after, nil
+		}
+	}
+}
+
+// activityMessage converts an activity to its gRPC message
+func activityMessage(a activitylog.ActivityLog) *pb.Activity {
+	return &pb.Activity{
+		Id:           a.ID,
+		SessionId:    a.SessionID,
+		UserId:       a.UserID,
+		RequestId:    a.RequestID,
+		ActivityType: a.ActivityType,
+		Path:         a.Path,
+		Method:       a.Method,
+		StatusCode:   int32(a.StatusCode),
+		UserCurrency: a.UserCurrency,
+		Source:       a.Source,
+		ProductId:    a.ProductID,
+		LatencyMs:    a.LatencyMs,
+		Details:      a.Details,
+		CreatedAt:    timestamppb.New(a.CreatedAt.In(time.UTC)),
+	}
+}
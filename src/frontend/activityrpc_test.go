@@ -0,0 +1,204 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+// activityRPCClient serves the activity service over an in-memory
+// connection and returns a client of it
+func activityRPCClient(t *testing.T, maxStreams int) pb.ActivityServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterActivityServiceServer(srv, newActivityRPCServer(maxStreams))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.NewClient() failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewActivityServiceClient(conn)
+}
+
+// logRPCActivity logs an activity of the given type and returns its ID
+func logRPCActivity(t *testing.T, typ string) int64 {
+	t.Helper()
+	a := &activitylog.ActivityLog{SessionID: "s1", RequestID: "r1", ActivityType: typ, Path: "/", Method: "GET"}
+	if err := activitylog.LogActivity(a); err != nil {
+		t.Fatalf("LogActivity() failed: %v", err)
+	}
+	return a.ID
+}
+
+// recvActivity receives the next activity of a stream
+func recvActivity(t *testing.T, stream pb.ActivityService_StreamActivitiesClient) *pb.Activity {
+	t.Helper()
+	got, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv() failed: %v", err)
+	}
+	return got
+}
+
+func TestStreamActivitiesReplaysThenFollows(t *testing.T) {
+	emptyActivityLog(t)
+	client := activityRPCClient(t, 1)
+
+	from := logRPCActivity(t, activitylog.ActivityTypePageView)
+	logRPCActivity(t, activitylog.ActivityTypeCheckout)
+	replayed := logRPCActivity(t, activitylog.ActivityTypePageView)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := client.StreamActivities(ctx, &pb.StreamRequest{
+		Types:  []string{activitylog.ActivityTypePageView},
+		FromId: from,
+	})
+	if err != nil {
+		t.Fatalf("StreamActivities() failed: %v", err)
+	}
+	if got := recvActivity(t, stream); got.GetId() != replayed || got.GetActivityType() != activitylog.ActivityTypePageView {
+		t.Fatalf("first activity = %v, want the page view %d replayed", got, replayed)
+	}
+
+	// Live activities follow once the stream has subscribed
+	received, errc := receiveActivities(stream)
+	got, live := awaitLive(t, received, errc)
+	if got.GetActivityType() != activitylog.ActivityTypePageView || got.GetId() <= replayed || got.GetCreatedAt() == nil {
+		t.Fatalf("live activity = %v, want a page view after %d", got, replayed)
+	}
+	// Nothing is sent twice, nor out of order
+	last := got.GetId()
+	for last != live[len(live)-1] {
+		got, ok := <-received
+		if !ok {
+			t.Fatalf("stream ended before page view %d: %v", live[len(live)-1], <-errc)
+		}
+		if got.GetId() <= last {
+			t.Fatalf("activity %d sent after %d", got.GetId(), last)
+		}
+		last = got.GetId()
+	}
+
+	// The only stream slot is taken
+	other, err := client.StreamActivities(ctx, &pb.StreamRequest{})
+	if err != nil {
+		t.Fatalf("StreamActivities() failed: %v", err)
+	}
+	if _, err := other.Recv(); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second stream Recv() = %v, want ResourceExhausted", err)
+	}
+
+	// Cancelling the stream frees its slot
+	cancel()
+	if err := <-errc; status.Code(err) != codes.Canceled {
+		t.Errorf("cancelled stream ended with %v, want Canceled", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stream, err := client.StreamActivities(ctx, &pb.StreamRequest{})
+		if err != nil {
+			t.Fatalf("StreamActivities() failed: %v", err)
+		}
+		received, errc := receiveActivities(stream)
+		select {
+		case got := <-received:
+			t.Fatalf("live-only stream sent %v before anything was logged", got)
+		case err := <-errc:
+			if status.Code(err) != codes.ResourceExhausted || time.Now().After(deadline) {
+				t.Fatalf("stream after cancelling ended with %v", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+			continue
+		case <-time.After(10 * time.Millisecond):
+		}
+		// Streams without a replay position only follow
+		if got, _ := awaitLive(t, received, errc); got.GetId() <= last {
+			t.Errorf("live-only stream sent %d, logged before it", got.GetId())
+		}
+		break
+	}
+}
+
+// receiveActivities receives the activities of a stream until it ends,
+// with the error it ended with
+func receiveActivities(stream pb.ActivityService_StreamActivitiesClient) (<-chan *pb.Activity, <-chan error) {
+	received := make(chan *pb.Activity, 100)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(received)
+		for {
+			got, err := stream.Recv()
+			if err != nil {
+				errc <- err
+				return
+			}
+			received <- got
+		}
+	}()
+	return received, errc
+}
+
+// awaitLive logs page views until the stream sends one, since it only
+// follows those logged once it has subscribed, and returns the first sent
+// and the IDs of those logged
+func awaitLive(t *testing.T, received <-chan *pb.Activity, errc <-chan error) (*pb.Activity, []int64) {
+	t.Helper()
+	var logged []int64
+	for {
+		logRPCActivity(t, activitylog.ActivityTypeCheckout)
+		logged = append(logged, logRPCActivity(t, activitylog.ActivityTypePageView))
+		select {
+		case got, ok := <-received:
+			if !ok {
+				t.Fatalf("stream ended: %v", <-errc)
+			}
+			return got, logged
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestStreamActivitiesRefusesUnknownTypes(t *testing.T) {
+	emptyActivityLog(t)
+	client := activityRPCClient(t, 0)
+	stream, err := client.StreamActivities(context.Background(), &pb.StreamRequest{Types: []string{"nope"}})
+	if err != nil {
+		t.Fatalf("StreamActivities() failed: %v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Recv() = %v, want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+// maxAssistantMessageBytes is the largest message sent to the assistant,
+// images included, that the frontend reads and forwards
+const maxAssistantMessageBytes = 8 << 20
+
+// assistantIntents maps words of a message to what the shopper wants from
+// the assistant, checked in order. Keywords match the start of words, and
+// whole words when they end with a space.
+var assistantIntents = []struct {
+	intent   string
+	keywords []string
+}{
+	{"product_question", []string{"price", "cost", "how much", "size", "colo", "material", "in stock", "available"}},
+	{"recommendation", []string{"recommend", "suggest", "looking for", "idea", "gift", "match", "go with", "similar"}},
+	{"greeting", []string{"hello", "hi ", "hey ", "thank"}},
+}
+
+// assistantIntent guesses what a message to the assistant is about, "other"
+// when no keyword gives it away
+func assistantIntent(text string) string {
+	words := " " + strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ") + " "
+	for _, i := range assistantIntents {
+		for _, k := range i.keywords {
+			if strings.Contains(words, " "+k) {
+				return i.intent
+			}
+		}
+	}
+	return "other"
+}
+
+// recordAssistantMessage attaches the length and intent of the message in
+// body to the activity, and the text itself when logAssistantText is set
+func recordAssistantMessage(r *http.Request, body []byte) {
+	var msg struct {
+		Message string `json:"message"`
+		Image   string `json:"image"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return
+	}
+	length, hasImage := utf8.RuneCountInString(msg.Message), msg.Image != ""
+	details := activitylog.AssistantMessageDetails{
+		MessageLength: &length,
+		Intent:        assistantIntent(msg.Message),
+		HasImage:      &hasImage,
+	}
+	if logAssistantText {
+		details.Message = msg.Message
+	}
+	activitylog.AddDetails(r.Context(), details)
+}
@@ -0,0 +1,50 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAssistantIntent(t *testing.T) {
+	for text, want := range map[string]string{
+		"How much is the typewriter?":            "product_question",
+		"Can you recommend a gift for my dad?":   "recommendation",
+		"What would go with this living room?":   "recommendation",
+		"Hi!":                                    "greeting",
+		"Is this the one with the history book?": "other",
+		"":                                       "other",
+	} {
+		if got := assistantIntent(text); got != want {
+			t.Errorf("assistantIntent(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestChatBotRefusesOversizedMessages(t *testing.T) {
+	fe := &frontendServer{shoppingAssistantSvcAddr: "assistant.invalid"}
+	body := `{"message":"` + strings.Repeat("a", maxAssistantMessageBytes) + `"}`
+	req := sessionRequest("/bot", "session-1", nil)
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	fe.chatBotHandler(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized message answered %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	corsAllowedMethods = "GET, POST"
+	// Authorization carries the admin token, Content-Type the JSON bodies
+	// of admin requests
+	corsAllowedHeaders = "Authorization, Content-Type"
+	corsMaxAge         = 10 * time.Minute
+)
+
+// parseCORSOrigins reads the comma-separated ACTIVITY_CORS_ORIGINS setting,
+// the origins allowed to call the activity endpoints from a browser. "*"
+// allows any origin.
+func parseCORSOrigins(v string) map[string]bool {
+	origins := make(map[string]bool)
+	for _, o := range strings.Split(v, ",") {
+		if o = strings.TrimSuffix(strings.TrimSpace(o), "/"); o != "" {
+			origins[o] = true
+		}
+	}
+	return origins
+}
+
+// withActivityCORS lets pages served from the allowed origins, such as an
+// externally hosted dashboard, call the activity endpoints, and answers
+// their preflight requests. Other paths and origins are passed through
+// untouched. Cookies aren't allowed along, so cross-origin requests never
+// act on a shopper's session.
+func withActivityCORS(next http.Handler, origins map[string]bool) http.HandlerFunc {
+	prefix := baseUrl + "/activities"
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(origins) == 0 || origin == "" ||
+			(r.URL.Path != prefix && !strings.HasPrefix(r.URL.Path, prefix+"/")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !origins["*"] && !origins[origin] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+const dashboardOrigin = "https://dashboard.example.com"
+
+// corsRouter serves /activities behind the activity middleware, as the
+// frontend does, with CORS for the given ACTIVITY_CORS_ORIGINS
+func corsRouter(origins string) http.Handler {
+	log := logrus.New()
+	log.Out = io.Discard
+	r := mux.NewRouter()
+	r.HandleFunc("/activities", func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "[]")
+	}).Methods(http.MethodGet)
+	r.Use(func(next http.Handler) http.Handler {
+		return activitylog.NewActivityMiddleware(log, next)
+	})
+	if o := parseCORSOrigins(origins); len(o) > 0 {
+		return withActivityCORS(r, o)
+	}
+	return r
+}
+
+func preflight(origin string) *http.Request {
+	req := httptest.NewRequest(http.MethodOptions, "/activities", nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	req.Header.Set("Access-Control-Request-Headers", "authorization")
+	return req
+}
+
+func TestActivityCORSPreflight(t *testing.T) {
+	emptyActivityLog(t)
+	for _, tc := range []struct {
+		name    string
+		origins string
+		origin  string
+		allowed bool
+	}{
+		{name: "not configured", origin: dashboardOrigin},
+		{name: "allowed origin", origins: "https://other.example.com, " + dashboardOrigin + "/", origin: dashboardOrigin, allowed: true},
+		{name: "any origin", origins: "*", origin: dashboardOrigin, allowed: true},
+		{name: "other origin", origins: "https://other.example.com", origin: dashboardOrigin},
+	} {
+		w := httptest.NewRecorder()
+		corsRouter(tc.origins).ServeHTTP(w, preflight(tc.origin))
+		if !tc.allowed {
+			if w.Code == http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Errorf("%s: preflight answered with %d %v, want it refused", tc.name, w.Code, w.Header())
+			}
+			continue
+		}
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%s: preflight status = %d, want 204", tc.name, w.Code)
+		}
+		for header, want := range map[string]string{
+			"Access-Control-Allow-Origin":  dashboardOrigin,
+			"Access-Control-Allow-Methods": corsAllowedMethods,
+			"Access-Control-Allow-Headers": corsAllowedHeaders,
+			"Vary":                         "Origin",
+		} {
+			if got := w.Header().Get(header); got != want {
+				t.Errorf("%s: %s = %q, want %q", tc.name, header, got, want)
+			}
+		}
+		if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf("%s: preflight allows credentials", tc.name)
+		}
+	}
+	if got, err := activitylog.GetRecentActivities(10); err != nil || len(got) != 0 {
+		t.Errorf("preflights logged %d activities (%v), want none", len(got), err)
+	}
+}
+
+func TestActivityCORSRequest(t *testing.T) {
+	emptyActivityLog(t)
+	h := corsRouter(dashboardOrigin)
+
+	req := httptest.NewRequest(http.MethodGet, "/activities", nil)
+	req.Header.Set("Origin", dashboardOrigin)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != dashboardOrigin {
+		t.Errorf("cross-origin GET: status %d, Access-Control-Allow-Origin %q; want 200 for %s",
+			w.Code, w.Header().Get("Access-Control-Allow-Origin"), dashboardOrigin)
+	}
+
+	// The shop's own pages aren't shared
+	req = httptest.NewRequest(http.MethodGet, "/activitiesfoo", nil)
+	req.Header.Set("Origin", dashboardOrigin)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("CORS headers set outside of the activity endpoints")
+	}
+}
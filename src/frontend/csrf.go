@@ -0,0 +1,80 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// csrfKey signs the CSRF tokens of forms and the user ID cookie. Set
+// CSRF_SECRET to keep them valid across restarts and replicas; otherwise a
+// random key is used.
+var csrfKey = func() []byte {
+	if secret := os.Getenv("CSRF_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// csrfToken is the token forms of the given session must submit. It's tied
+// to the session, so another site can't obtain one for the shopper's.
+func csrfToken(sessionID string) string {
+	mac := hmac.New(sha256.New, csrfKey)
+	mac.Write([]byte(sessionID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validCSRFToken tells whether the request carries the CSRF token of its
+// session, in the csrf_token form field or the X-CSRF-Token header.
+func validCSRFToken(r *http.Request) bool {
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.PostFormValue("csrf_token")
+	}
+	return token != "" && hmac.Equal([]byte(token), []byte(csrfToken(sessionID(r))))
+}
+
+// signUserID returns the value of the user ID cookie of userID, the user
+// ID followed by its signature, so that a shopper can't sign in as someone
+// else by editing the cookie.
+func signUserID(userID string) string {
+	mac := hmac.New(sha256.New, csrfKey)
+	mac.Write([]byte("user-id:" + userID))
+	return userID + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyUserID returns the user ID of a user ID cookie value made by
+// signUserID, and whether its signature is valid
+func verifyUserID(value string) (string, bool) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return "", false
+	}
+	userID := value[:i]
+	if !hmac.Equal([]byte(value), []byte(signUserID(userID))) {
+		return "", false
+	}
+	return userID, true
+}
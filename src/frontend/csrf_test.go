@@ -0,0 +1,119 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sessionRequest is a form POST made by the given session
+func sessionRequest(path, session string, form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	log := logrus.New()
+	log.Out = io.Discard
+	ctx := context.WithValue(req.Context(), ctxKeySessionID{}, session)
+	ctx = context.WithValue(ctx, ctxKeyLog{}, logrus.FieldLogger(log))
+	return req.WithContext(ctx)
+}
+
+func TestCSRFTokenIsTiedToSession(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{"own session", csrfToken("mine"), true},
+		{"other session", csrfToken("theirs"), false},
+		{"missing", "", false},
+	} {
+		form := url.Values{"csrf_token": {tc.token}}
+		if got := validCSRFToken(sessionRequest("/", "mine", form)); got != tc.want {
+			t.Errorf("%s: validCSRFToken() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	req := sessionRequest("/", "mine", nil)
+	req.Header.Set("X-CSRF-Token", csrfToken("mine"))
+	if !validCSRFToken(req) {
+		t.Error("validCSRFToken() rejects the token in the X-CSRF-Token header")
+	}
+}
+
+func TestClearSessionActivitiesRequiresCSRFToken(t *testing.T) {
+	fe := &frontendServer{}
+	w := httptest.NewRecorder()
+	form := url.Values{"csrf_token": {csrfToken("theirs")}}
+	fe.clearSessionActivitiesHandler(w, sessionRequest("/activities/session/clear", "mine", form))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestFlashIsShownOnce(t *testing.T) {
+	w := httptest.NewRecorder()
+	setFlash(w, "History cleared; 3 activities removed.")
+
+	var got interface{}
+	h := withFlash(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = r.Context().Value(ctxKeyFlash{})
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if got != "History cleared; 3 activities removed." {
+		t.Errorf("flash = %q, want the message", got)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != cookieFlash || cookies[0].MaxAge >= 0 {
+		t.Errorf("cookies = %v, want the flash cookie cleared", cookies)
+	}
+}
+
+func TestUserIDCookieMustBeSigned(t *testing.T) {
+	var got string
+	h := ensureSessionID(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got, _ = r.Context().Value(ctxKeyUserID{}).(string)
+	}))
+	for _, tc := range []struct {
+		name, value, want string
+	}{
+		{"signed at sign-in", signUserID("alice"), "alice"},
+		{"unsigned", "alice", ""},
+		{"signature of another user", "bob." + strings.TrimPrefix(signUserID("alice"), "alice."), ""},
+		{"tampered signature", signUserID("alice") + "0", ""},
+	} {
+		got = ""
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: "session-1"})
+		req.AddCookie(&http.Cookie{Name: cookieUserID, Value: tc.value})
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if got != tc.want {
+			t.Errorf("%s: user ID %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
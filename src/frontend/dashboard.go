@@ -0,0 +1,215 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+// dashboardTopProducts is how many products the dashboard lists
+const dashboardTopProducts = 10
+
+// dashboardUnclassifiedPaths is how many routes logged as "other" the
+// dashboard lists
+const dashboardUnclassifiedPaths = 10
+
+// dashboardSectionTimeout is how long a dashboard section may take before
+// it is given up on and reported as a warning
+var dashboardSectionTimeout = 5 * time.Second
+
+// dashboardStore runs the queries of the dashboard sections, so that tests
+// can make some of them fail
+type dashboardStore interface {
+	// WithSnapshot runs fn with a store whose queries all see the same
+	// activities, so that the sections agree with each other
+	WithSnapshot(ctx context.Context, fn func(activitylog.ReadStore) error) error
+}
+
+// activityStore is the dashboardStore of the activity log
+type activityStore struct{}
+
+func (activityStore) WithSnapshot(ctx context.Context, fn func(activitylog.ReadStore) error) error {
+	return activitylog.WithSnapshot(ctx, fn)
+}
+
+// activityDashboard is the body of GET /activities/dashboard. Sections that
+// failed are null, and named in Warnings along with why.
+type activityDashboard struct {
+	Start       time.Time                     `json:"start"`
+	End         time.Time                     `json:"end"`
+	Stats       map[string]int                `json:"stats"`
+	Funnel      []activitylog.FunnelStep      `json:"funnel"`
+	TopProducts []activitylog.ProductActivity `json:"top_products"`
+	// Normalized puts Stats in proportion to the sessions of the period
+	Normalized *activitylog.NormalizedStats `json:"normalized"`
+	// Unclassified are the routes most logged as "other"
+	Unclassified *unclassifiedReport `json:"unclassified"`
+	Warnings     []dashboardWarning  `json:"warnings"`
+}
+
+// unclassifiedReport is the body of /activities/stats/unclassified and the
+// unclassified section of the dashboard: the routes most logged as "other"
+// and the share of the traffic those are. Share is null when the stats
+// couldn't be had.
+type unclassifiedReport struct {
+	Share *activitylog.UnclassifiedShare `json:"share"`
+	Paths []activitylog.UnclassifiedPath `json:"paths"`
+}
+
+// dashboardWarning names a dashboard section that couldn't be served
+type dashboardWarning struct {
+	Section string `json:"section"`
+	Error   string `json:"error"`
+}
+
+// dashboardSection is a part of the dashboard: query gets it, and set
+// puts it in the dashboard when it succeeds
+type dashboardSection struct {
+	name  string
+	query func(ctx context.Context) (interface{}, error)
+	set   func(interface{})
+}
+
+// sectionResult is the outcome of the query of a dashboard section
+type sectionResult struct {
+	value interface{}
+	err   error
+}
+
+// runDashboardSections runs the queries of the sections concurrently, at
+// most limit at once, and returns their results in order. A query taking
+// longer than timeout is canceled and fails.
+func runDashboardSections(ctx context.Context, sections []dashboardSection, limit int, timeout time.Duration) []sectionResult {
+	results := make([]sectionResult, len(sections))
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, s := range sections {
+		wg.Add(1)
+		go func(i int, s dashboardSection) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			v, err := s.query(ctx)
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("timed out after %v", timeout)
+			}
+			results[i] = sectionResult{value: v, err: err}
+		}(i, s)
+	}
+	wg.Wait()
+	return results
+}
+
+// activityDashboardHandler serves the stats, funnel, top products,
+// normalized stats and unclassified routes of a time period in one
+// response. The sections are queried concurrently, within the limit on
+// analytical queries, from a single snapshot of the activities so that
+// they can be compared: the funnel can't convert more sessions than the
+// stats saw. One section failing doesn't fail the others: it is left out
+// with a warning. Only when every section failed is the response a 503.
+func (fe *frontendServer) activityDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	startTime, endTime := parseTimeRange(r)
+	store := fe.dashboardStore
+	if store == nil {
+		store = activityStore{}
+	}
+
+	dash := activityDashboard{Start: startTime.UTC(), End: endTime.UTC(), Warnings: []dashboardWarning{}}
+	var snapshot activitylog.ReadStore
+	sections := []dashboardSection{
+		{
+			name: "stats",
+			query: func(ctx context.Context) (interface{}, error) {
+				return snapshot.ActivityStats(ctx, activitylog.Filter{Start: startTime, End: endTime})
+			},
+			set: func(v interface{}) { dash.Stats = v.(map[string]int) },
+		},
+		{
+			name: "funnel",
+			query: func(ctx context.Context) (interface{}, error) {
+				return snapshot.Funnel(ctx, startTime, endTime, activitylog.DefaultFunnel)
+			},
+			set: func(v interface{}) { dash.Funnel = v.([]activitylog.FunnelStep) },
+		},
+		{
+			name: "top_products",
+			query: func(ctx context.Context) (interface{}, error) {
+				return snapshot.TopProducts(ctx, startTime, endTime, dashboardTopProducts)
+			},
+			set: func(v interface{}) { dash.TopProducts = v.([]activitylog.ProductActivity) },
+		},
+		{
+			name: "normalized",
+			query: func(ctx context.Context) (interface{}, error) {
+				return snapshot.NormalizedStats(ctx, startTime, endTime)
+			},
+			set: func(v interface{}) { dash.Normalized = v.(*activitylog.NormalizedStats) },
+		},
+		{
+			name: "unclassified",
+			query: func(ctx context.Context) (interface{}, error) {
+				return snapshot.UnclassifiedPaths(ctx, startTime, endTime, dashboardUnclassifiedPaths)
+			},
+			set: func(v interface{}) {
+				dash.Unclassified = &unclassifiedReport{Paths: v.([]activitylog.UnclassifiedPath)}
+			},
+		},
+	}
+
+	var results []sectionResult
+	err := store.WithSnapshot(r.Context(), func(s activitylog.ReadStore) error {
+		snapshot = s
+		results = runDashboardSections(r.Context(), sections, activitylog.AnalyticalQueryLimit(), dashboardSectionTimeout)
+		return nil
+	})
+	if err != nil {
+		// Without a snapshot, no section could be queried
+		results = make([]sectionResult, len(sections))
+		for i := range results {
+			results[i].err = err
+		}
+	}
+	for i, res := range results {
+		if res.err != nil {
+			log.WithError(res.err).WithField("section", sections[i].name).Warn("failed to get a dashboard section")
+			dash.Warnings = append(dash.Warnings, dashboardWarning{Section: sections[i].name, Error: res.err.Error()})
+			continue
+		}
+		sections[i].set(res.value)
+	}
+	if dash.Unclassified != nil && dash.Stats != nil {
+		share := activitylog.GetUnclassifiedShare(dash.Stats)
+		dash.Unclassified.Share = &share
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(dash.Warnings) == len(sections) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(dash)
+}
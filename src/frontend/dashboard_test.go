@@ -0,0 +1,291 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+// fakeDashboardStore serves canned sections, failing those listed in fail
+// and taking delay over each query unless canceled. It records how many
+// queries ran at once and how many were canceled.
+type fakeDashboardStore struct {
+	fail  map[string]bool
+	delay time.Duration
+
+	mu       sync.Mutex
+	running  int
+	peak     int
+	canceled int
+}
+
+func (s *fakeDashboardStore) run(ctx context.Context, section string) error {
+	s.mu.Lock()
+	s.running++
+	s.peak = max(s.peak, s.running)
+	s.mu.Unlock()
+	var err error
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	s.mu.Lock()
+	s.running--
+	if err != nil {
+		s.canceled++
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if s.fail[section] {
+		return errors.New(section + " failed")
+	}
+	return nil
+}
+
+func (s *fakeDashboardStore) WithSnapshot(_ context.Context, fn func(activitylog.ReadStore) error) error {
+	return fn(s)
+}
+
+func (s *fakeDashboardStore) ActivityStats(ctx context.Context, _ activitylog.Filter) (map[string]int, error) {
+	if err := s.run(ctx, "stats"); err != nil {
+		return nil, err
+	}
+	return map[string]int{activitylog.ActivityTypePageView: 3, activitylog.ActivityTypeOther: 1}, nil
+}
+
+func (s *fakeDashboardStore) Funnel(ctx context.Context, _, _ time.Time, _ []string) ([]activitylog.FunnelStep, error) {
+	if err := s.run(ctx, "funnel"); err != nil {
+		return nil, err
+	}
+	return []activitylog.FunnelStep{{ActivityType: activitylog.ActivityTypeProductView, Sessions: 2, Conversion: 1}}, nil
+}
+
+func (s *fakeDashboardStore) TopProducts(ctx context.Context, _, _ time.Time, _ int) ([]activitylog.ProductActivity, error) {
+	if err := s.run(ctx, "top_products"); err != nil {
+		return nil, err
+	}
+	return []activitylog.ProductActivity{{ProductID: "OLJCESPC7Z", Views: 5}}, nil
+}
+
+func (s *fakeDashboardStore) NormalizedStats(ctx context.Context, _, _ time.Time) (*activitylog.NormalizedStats, error) {
+	if err := s.run(ctx, "normalized"); err != nil {
+		return nil, err
+	}
+	return &activitylog.NormalizedStats{Sessions: 2}, nil
+}
+
+func (s *fakeDashboardStore) UnclassifiedPaths(ctx context.Context, _, _ time.Time, _ int) ([]activitylog.UnclassifiedPath, error) {
+	if err := s.run(ctx, "unclassified"); err != nil {
+		return nil, err
+	}
+	return []activitylog.UnclassifiedPath{{RouteTemplate: "/wishlist/{id}", Count: 1}}, nil
+}
+
+func getDashboard(t *testing.T, store dashboardStore) (int, map[string]json.RawMessage, []dashboardWarning) {
+	t.Helper()
+	fe := &frontendServer{dashboardStore: store}
+	req := sessionRequest("/activities/dashboard", "session-1", nil)
+	req.Method = http.MethodGet
+	w := httptest.NewRecorder()
+	fe.activityDashboardHandler(w, req)
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding the dashboard failed: %v\n%s", err, w.Body)
+	}
+	var warnings []dashboardWarning
+	if err := json.Unmarshal(body["warnings"], &warnings); err != nil {
+		t.Fatalf("decoding the warnings failed: %v", err)
+	}
+	return w.Code, body, warnings
+}
+
+func TestDashboardReturnsPartialResults(t *testing.T) {
+	tests := []struct {
+		name       string
+		fail       map[string]bool
+		wantStatus int
+		wantNull   []string
+	}{
+		{"all succeed", nil, http.StatusOK, nil},
+		{"one fails", map[string]bool{"funnel": true}, http.StatusOK, []string{"funnel"}},
+		{"two fail", map[string]bool{"stats": true, "top_products": true}, http.StatusOK, []string{"stats", "top_products"}},
+		{"all fail", map[string]bool{"stats": true, "funnel": true, "top_products": true, "normalized": true, "unclassified": true},
+			http.StatusServiceUnavailable, []string{"stats", "funnel", "top_products", "normalized", "unclassified"}},
+	}
+	for _, tt := range tests {
+		status, body, warnings := getDashboard(t, &fakeDashboardStore{fail: tt.fail})
+		if status != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.wantStatus)
+		}
+		var failed []string
+		for _, w := range warnings {
+			failed = append(failed, w.Section)
+			if w.Error != w.Section+" failed" {
+				t.Errorf("%s: warning of %s = %q", tt.name, w.Section, w.Error)
+			}
+		}
+		if !reflect.DeepEqual(failed, tt.wantNull) {
+			t.Errorf("%s: warnings name %v, want %v", tt.name, failed, tt.wantNull)
+		}
+		for _, section := range []string{"stats", "funnel", "top_products", "normalized", "unclassified"} {
+			null := string(body[section]) == "null"
+			if want := tt.fail[section]; null != want {
+				t.Errorf("%s: %s = %s, want it null: %v", tt.name, section, body[section], want)
+			}
+		}
+	}
+}
+
+func TestDashboardWarnsOfUnclassifiedTraffic(t *testing.T) {
+	_, body, _ := getDashboard(t, &fakeDashboardStore{})
+	var got unclassifiedReport
+	if err := json.Unmarshal(body["unclassified"], &got); err != nil {
+		t.Fatal(err)
+	}
+	// One activity in four is other, above the default of 10%
+	if got.Share == nil || got.Share.Share.Value != 0.25 || !got.Share.Warning || len(got.Paths) != 1 {
+		t.Errorf("unclassified = %s, want a warning about 25%% of other", body["unclassified"])
+	}
+
+	// Without stats there is no share to warn about, but the routes still help
+	_, body, _ = getDashboard(t, &fakeDashboardStore{fail: map[string]bool{"stats": true}})
+	got = unclassifiedReport{}
+	if err := json.Unmarshal(body["unclassified"], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Share != nil || len(got.Paths) != 1 {
+		t.Errorf("unclassified = %s, want the routes without a share", body["unclassified"])
+	}
+}
+
+func TestDashboardTimesOutSlowSections(t *testing.T) {
+	defer func(timeout time.Duration) { dashboardSectionTimeout = timeout }(dashboardSectionTimeout)
+	dashboardSectionTimeout = 10 * time.Millisecond
+
+	store := &fakeDashboardStore{delay: time.Minute}
+	status, _, warnings := getDashboard(t, store)
+	if status != http.StatusServiceUnavailable || len(warnings) != 5 {
+		t.Errorf("status = %d, warnings = %+v, want every section timed out", status, warnings)
+	}
+	for _, w := range warnings {
+		if w.Error != "timed out after 10ms" {
+			t.Errorf("warning of %s = %q, want a timeout", w.Section, w.Error)
+		}
+	}
+	// The queries are canceled, not left running against the snapshot
+	if store.canceled != 5 || store.running != 0 {
+		t.Errorf("%d queries canceled, %d still running, want all 5 canceled", store.canceled, store.running)
+	}
+}
+
+func TestDashboardStaysWithinTheAnalyticalQueryLimit(t *testing.T) {
+	activitylog.ConfigureAnalyticalQueries(1, 0)
+	defer activitylog.ConfigureAnalyticalQueries(0, 0)
+
+	store := &fakeDashboardStore{delay: 5 * time.Millisecond}
+	if status, _, warnings := getDashboard(t, store); status != http.StatusOK || len(warnings) != 0 {
+		t.Fatalf("status = %d, warnings = %+v", status, warnings)
+	}
+	if store.peak != 1 {
+		t.Errorf("%d sections ran at once, want at most 1", store.peak)
+	}
+}
+
+func TestDashboardSectionsAgreeWhileActivitiesAreLogged(t *testing.T) {
+	emptyActivityLog(t)
+	start := activitylog.Now().Add(-time.Hour)
+	end := start.Add(2 * time.Hour)
+
+	// Sessions keep viewing a product and adding it to their cart while
+	// the dashboard is fetched
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				done <- nil
+				return
+			default:
+			}
+			session := fmt.Sprintf("session-%d", i)
+			for _, activityType := range []string{activitylog.ActivityTypeProductView, activitylog.ActivityTypeAddToCart} {
+				a := &activitylog.ActivityLog{SessionID: session, ActivityType: activityType, ProductID: "OLJCESPC7Z", StatusCode: http.StatusOK}
+				if err := activitylog.LogActivity(a); err != nil {
+					done <- err
+					return
+				}
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		if err := <-done; err != nil {
+			t.Errorf("logging activities failed: %v", err)
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		fe := &frontendServer{}
+		req := sessionRequest("/activities/dashboard", "session-1", nil)
+		req.Method = http.MethodGet
+		req.URL.RawQuery = url.Values{"start": {start.Format(time.RFC3339)}, "end": {end.Format(time.RFC3339)}}.Encode()
+		w := httptest.NewRecorder()
+		fe.activityDashboardHandler(w, req)
+
+		var dash activityDashboard
+		if err := json.Unmarshal(w.Body.Bytes(), &dash); err != nil {
+			t.Fatalf("decoding the dashboard failed: %v\n%s", err, w.Body)
+		}
+		if len(dash.Warnings) > 0 {
+			t.Fatalf("dashboard warnings: %+v", dash.Warnings)
+		}
+		var total int
+		for _, n := range dash.Stats {
+			total += n
+		}
+		var productViews int
+		for _, p := range dash.TopProducts {
+			productViews += p.Views
+		}
+		views, sessions := dash.Stats[activitylog.ActivityTypeProductView], dash.Funnel[0].Sessions
+		switch {
+		case dash.Normalized.ActivitiesPerSession.Numerator != total:
+			t.Fatalf("fetch %d: normalized stats count %d activities, stats %d", i, dash.Normalized.ActivitiesPerSession.Numerator, total)
+		case sessions > views || sessions > dash.Normalized.Sessions:
+			t.Fatalf("fetch %d: %d sessions viewed a product, more than the %d views in %d sessions", i, sessions, views, dash.Normalized.Sessions)
+		case productViews != views:
+			t.Fatalf("fetch %d: top products %+v, want %d views", i, dash.TopProducts, views)
+		case dash.Funnel[1].Conversion > 1:
+			t.Fatalf("fetch %d: funnel converts more than all sessions: %+v", i, dash.Funnel)
+		}
+	}
+}
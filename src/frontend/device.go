@@ -0,0 +1,70 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+// deviceCookieMaxAge is how long a browser keeps its device ID, across
+// the sessions it stitches together
+const deviceCookieMaxAge = 365 * 24 * time.Hour
+
+// deviceIDFormat is what the device IDs the frontend hands out look like
+var deviceIDFormat = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// ensureDeviceID returns the device ID of the browser, handing it a new
+// one in the device cookie when it has none, if ENABLE_DEVICE_ID is set.
+// Shoppers who opted out of tracking get none and keep none.
+func ensureDeviceID(w http.ResponseWriter, r *http.Request) string {
+	if os.Getenv("ENABLE_DEVICE_ID") != "true" || !activitylog.TrackingAllowed(r) {
+		return ""
+	}
+	if c, err := r.Cookie(cookieDeviceID); err == nil && deviceIDFormat.MatchString(c.Value) {
+		return c.Value
+	}
+	u, _ := uuid.NewRandom()
+	http.SetCookie(w, &http.Cookie{
+		Name:   cookieDeviceID,
+		Value:  u.String(),
+		Path:   baseUrl + "/",
+		MaxAge: int(deviceCookieMaxAge.Seconds()),
+	})
+	return u.String()
+}
+
+// forgetDeviceID removes the device cookie, so that the browser's next
+// sessions aren't stitched to the previous ones
+func forgetDeviceID(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   cookieDeviceID,
+		Path:   baseUrl + "/",
+		MaxAge: -1,
+	})
+}
+
+// deviceID returns the device ID of the request's browser, empty when it
+// has none
+func deviceID(r *http.Request) string {
+	v, _ := r.Context().Value(ctxKeyDeviceID{}).(string)
+	return v
+}
@@ -0,0 +1,189 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+// deviceCookie returns the device cookie set by a response, if any
+func deviceCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == cookieDeviceID {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestDeviceIDCookie(t *testing.T) {
+	var got string
+	h := ensureSessionID(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = deviceID(r)
+	}))
+	serve := func(cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		got = ""
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// Off unless enabled
+	if w := serve(); deviceCookie(w) != nil || got != "" {
+		t.Errorf("device ID %q handed out without ENABLE_DEVICE_ID", got)
+	}
+
+	t.Setenv("ENABLE_DEVICE_ID", "true")
+	c := deviceCookie(serve())
+	if c == nil || !deviceIDFormat.MatchString(c.Value) || got != c.Value {
+		t.Fatalf("device cookie %v, device ID %q; want a new device ID", c, got)
+	}
+	// Kept across sessions
+	if w := serve(c); deviceCookie(w) != nil || got != c.Value {
+		t.Errorf("returning device got ID %q, want %q kept", got, c.Value)
+	}
+	// Not for shoppers who opted out
+	declined := &http.Cookie{Name: activitylog.ConsentCookie, Value: activitylog.ConsentDeclined}
+	if w := serve(c, declined); deviceCookie(w) != nil || got != "" {
+		t.Errorf("device ID %q used for a shopper who opted out of tracking", got)
+	}
+}
+
+func TestClearSessionActivitiesClearsDevice(t *testing.T) {
+	emptyActivityLog(t)
+	for _, a := range []activitylog.ActivityLog{
+		{SessionID: "mine", DeviceID: "my-device", ActivityType: activitylog.ActivityTypePageView},
+		{SessionID: "earlier", DeviceID: "my-device", ActivityType: activitylog.ActivityTypePageView},
+		{SessionID: "theirs", DeviceID: "their-device", ActivityType: activitylog.ActivityTypePageView},
+	} {
+		if err := activitylog.LogActivity(&a); err != nil {
+			t.Fatalf("LogActivity() failed: %v", err)
+		}
+	}
+
+	fe := &frontendServer{}
+	req := sessionRequest("/activities/session/clear", "mine", url.Values{"csrf_token": {csrfToken("mine")}})
+	req = req.WithContext(context.WithValue(req.Context(), ctxKeyDeviceID{}, "my-device"))
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	fe.clearSessionActivitiesHandler(w, req)
+
+	var body map[string]int64
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body["deleted"] != 2 {
+		t.Errorf("response %d %v (%v), want both activities of the device deleted", w.Code, body, err)
+	}
+	if c := deviceCookie(w); c == nil || c.MaxAge >= 0 {
+		t.Errorf("device cookie %v, want it cleared", c)
+	}
+	if sessions, err := activitylog.GetDeviceHistory(context.Background(), "their-device"); err != nil || len(sessions) != 1 {
+		t.Errorf("other device's history = %v, %v; want it kept", sessions, err)
+	}
+}
+
+func TestDeviceHistoryOfOtherDevicesNeedsTheAdminToken(t *testing.T) {
+	emptyActivityLog(t)
+	defer func(token string) { activityAdminToken = token }(activityAdminToken)
+	activityAdminToken = "secret"
+	for _, a := range []activitylog.ActivityLog{
+		{SessionID: "mine", DeviceID: "my-device", ActivityType: activitylog.ActivityTypePageView},
+		{SessionID: "theirs", DeviceID: "their-device", ActivityType: activitylog.ActivityTypePageView},
+	} {
+		if err := activitylog.LogActivity(&a); err != nil {
+			t.Fatalf("LogActivity() failed: %v", err)
+		}
+	}
+	fe := &frontendServer{}
+	get := func(query, token string) (int, []activitylog.SessionSummary) {
+		req := sessionRequest("/activities/device", "mine", nil)
+		req.Method = http.MethodGet
+		req.URL.RawQuery = query
+		req = req.WithContext(context.WithValue(req.Context(), ctxKeyDeviceID{}, "my-device"))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		fe.deviceHistoryHandler(w, req)
+		var sessions []activitylog.SessionSummary
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&sessions); err != nil {
+				t.Fatalf("decoding ?%s failed: %v", query, err)
+			}
+		}
+		return w.Code, sessions
+	}
+
+	for _, query := range []string{"", "device_id=my-device"} {
+		if code, sessions := get(query, ""); code != http.StatusOK || len(sessions) != 1 || sessions[0].SessionID != "mine" {
+			t.Errorf("?%s: status %d, %+v; want the session of the caller's device", query, code, sessions)
+		}
+	}
+	if code, sessions := get("device_id=their-device", ""); code != http.StatusUnauthorized || sessions != nil {
+		t.Errorf("another device without the admin token: status %d, %+v; want 401", code, sessions)
+	}
+	if code, sessions := get("device_id=their-device", "secret"); code != http.StatusOK || len(sessions) != 1 || sessions[0].SessionID != "theirs" {
+		t.Errorf("another device with the admin token: status %d, %+v; want its session", code, sessions)
+	}
+}
+
+func TestActivityListsLeaveOutIdentitiesForNonAdmins(t *testing.T) {
+	emptyActivityLog(t)
+	defer func(token string) { activityAdminToken = token }(activityAdminToken)
+	activityAdminToken = "secret"
+	if err := activitylog.LogActivity(&activitylog.ActivityLog{SessionID: "theirs", UserID: "them", DeviceID: "their-device", ActivityType: activitylog.ActivityTypePageView}); err != nil {
+		t.Fatalf("LogActivity() failed: %v", err)
+	}
+	fe := &frontendServer{}
+	for path, handler := range map[string]http.HandlerFunc{
+		"/activities":      fe.listActivitiesHandler,
+		"/activities/poll": fe.pollActivitiesHandler,
+	} {
+		get := func(token string) []map[string]interface{} {
+			req := sessionRequest(path, "mine", nil)
+			req.Method = http.MethodGet
+			req.URL.RawQuery = "since_id=0"
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+			var got []map[string]interface{}
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil || len(got) != 1 {
+				t.Fatalf("%s: status %d, %v (%v); want the activity", path, w.Code, got, err)
+			}
+			return got
+		}
+
+		got := get("")
+		for _, key := range []string{"device_id", "user_id"} {
+			if v, ok := got[0][key]; ok {
+				t.Errorf("%s without the admin token has %s %v, want none", path, key, v)
+			}
+		}
+		if got := get("secret"); got[0]["device_id"] != "their-device" || got[0]["user_id"] != "them" {
+			t.Errorf("%s with the admin token = %v, want its device and user IDs", path, got[0])
+		}
+	}
+}
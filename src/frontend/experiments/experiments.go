@@ -0,0 +1,105 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package experiments assigns sessions to A/B experiment variants.
+package experiments
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// VariantControl is the variant of sessions outside the rollout.
+	VariantControl = "control"
+	// VariantTreatment is the variant of sessions inside the rollout.
+	VariantTreatment = "treatment"
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// ValidName reports whether name is usable as an experiment name.
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// Experiment is a named experiment rolled out to a percentage of sessions.
+type Experiment struct {
+	Name    string `json:"name"`
+	Percent int    `json:"percent"`
+}
+
+// Variant deterministically assigns the session to a variant. The same
+// session always gets the same variant, and assignments of different
+// experiments are independent of each other.
+func (e Experiment) Variant(sessionID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(e.Name))
+	h.Write([]byte{0})
+	h.Write([]byte(sessionID))
+	if int(h.Sum32()%100) < e.Percent {
+		return VariantTreatment
+	}
+	return VariantControl
+}
+
+// Set is the list of running experiments.
+type Set []Experiment
+
+// Parse reads an experiment list such as
+// "home_personalization:50,rec_above_fold:10", where the number is the
+// percentage of sessions that get the treatment.
+func Parse(spec string) (Set, error) {
+	var set Set
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, pct, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("experiment %q: missing rollout percentage", entry)
+		}
+		if !ValidName(name) {
+			return nil, fmt.Errorf("experiment %q: name must only contain letters, digits and underscores", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("experiment %q: listed more than once", name)
+		}
+		percent, err := strconv.Atoi(pct)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("experiment %q: rollout must be a percentage between 0 and 100", name)
+		}
+		seen[name] = true
+		set = append(set, Experiment{Name: name, Percent: percent})
+	}
+	return set, nil
+}
+
+// Assign returns the variant of every experiment for the session, keyed by
+// experiment name. It returns nil when no experiments are running.
+func (s Set) Assign(sessionID string) map[string]string {
+	if len(s) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(s))
+	for _, e := range s {
+		out[e.Name] = e.Variant(sessionID)
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package experiments
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	got, err := Parse("home_personalization:50, rec_above_fold:10")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	want := Set{{"home_personalization", 50}, {"rec_above_fold", 10}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %v, want %v", got, want)
+	}
+
+	if got, err := Parse(""); err != nil || got != nil {
+		t.Errorf("Parse(\"\") = %v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestParseRejectsInvalidSpecs(t *testing.T) {
+	for _, spec := range []string{
+		"home_personalization",
+		"home_personalization:abc",
+		"home_personalization:101",
+		"home_personalization:-1",
+		"home.personalization:50",
+		"a:10,a:20",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestVariantIsStable(t *testing.T) {
+	e := Experiment{Name: "home_personalization", Percent: 50}
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("session-%d", i)
+		first := e.Variant(id)
+		for j := 0; j < 5; j++ {
+			if got := e.Variant(id); got != first {
+				t.Fatalf("Variant(%q) = %q, previously %q", id, got, first)
+			}
+		}
+	}
+}
+
+func TestVariantDistribution(t *testing.T) {
+	const sessions = 20000
+	for _, percent := range []int{0, 10, 50, 100} {
+		e := Experiment{Name: "rec_above_fold", Percent: percent}
+		treated := 0
+		for i := 0; i < sessions; i++ {
+			if e.Variant(fmt.Sprintf("%08x-session", i)) == VariantTreatment {
+				treated++
+			}
+		}
+		got := 100 * float64(treated) / sessions
+		if math.Abs(got-float64(percent)) > 1.5 {
+			t.Errorf("%d%% rollout treated %.2f%% of sessions", percent, got)
+		}
+	}
+}
+
+func TestExperimentsAreIndependent(t *testing.T) {
+	const sessions = 20000
+	a := Experiment{Name: "home_personalization", Percent: 50}
+	b := Experiment{Name: "rec_above_fold", Percent: 50}
+	both := 0
+	for i := 0; i < sessions; i++ {
+		id := fmt.Sprintf("%08x-session", i)
+		if a.Variant(id) == VariantTreatment && b.Variant(id) == VariantTreatment {
+			both++
+		}
+	}
+	// Independent 50% rollouts overlap in about a quarter of sessions.
+	if got := 100 * float64(both) / sessions; math.Abs(got-25) > 1.5 {
+		t.Errorf("%.2f%% of sessions got both treatments, want about 25%%", got)
+	}
+}
+
+func TestAssign(t *testing.T) {
+	set := Set{{"always", 100}, {"never", 0}}
+	want := map[string]string{"always": VariantTreatment, "never": VariantControl}
+	if got := set.Assign("session"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Assign() = %v, want %v", got, want)
+	}
+	if got := Set(nil).Assign("session"); got != nil {
+		t.Errorf("Assign() with no experiments = %v, want nil", got)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// prometheusContentType is the Prometheus text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// statsFormat tells how a stats response is rendered: "prometheus" when
+// asked for by ?format=prometheus or an Accept header listing the text
+// exposition format, as Prometheus scrapers send, and "json" otherwise.
+func statsFormat(r *http.Request) (string, error) {
+	switch f := r.URL.Query().Get("format"); f {
+	case "json", "prometheus":
+		return f, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unknown format %q", f)
+	}
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err == nil && mediaType == "text/plain" && params["version"] == "0.0.4" {
+			return "prometheus", nil
+		}
+	}
+	return "json", nil
+}
+
+// writeActivityCounts renders per-type activity counts as the
+// activity_count gauge, labeled with the window they were counted over
+func writeActivityCounts(w io.Writer, counts map[string]int, start, end time.Time) error {
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	window := fmt.Sprintf(`window_end="%s",window_start="%s"`,
+		escapeLabelValue(end.UTC().Format(time.RFC3339)), escapeLabelValue(start.UTC().Format(time.RFC3339)))
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# HELP activity_count Activities logged per type between window_start and window_end.")
+	fmt.Fprintln(bw, "# TYPE activity_count gauge")
+	for _, t := range types {
+		fmt.Fprintf(bw, "activity_count{activity_type=\"%s\",%s} %d\n", escapeLabelValue(t), window, counts[t])
+	}
+	return bw.Flush()
+}
+
+// labelValueEscaper escapes what the exposition format doesn't allow
+// verbatim between the quotes of a label value
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
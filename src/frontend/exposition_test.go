@@ -0,0 +1,133 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sample struct {
+	labels map[string]string
+	value  float64
+}
+
+// parseExposition parses the samples of a text exposition, following the
+// format's grammar rather than our encoder, and checks the TYPE line
+func parseExposition(t *testing.T, text, metric string) []sample {
+	t.Helper()
+	var samples []sample
+	typed := false
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if strings.HasPrefix(line, "#") {
+			if line == "# TYPE "+metric+" gauge" {
+				typed = true
+			}
+			continue
+		}
+		rest, ok := strings.CutPrefix(line, metric+"{")
+		if !ok {
+			t.Fatalf("unexpected line %q", line)
+		}
+		s := sample{labels: make(map[string]string)}
+		for !strings.HasPrefix(rest, "}") {
+			name, after, ok := strings.Cut(rest, `="`)
+			if !ok {
+				t.Fatalf("no label value in %q", line)
+			}
+			var value strings.Builder
+			i := 0
+			for ; i < len(after) && after[i] != '"'; i++ {
+				if after[i] != '\\' {
+					value.WriteByte(after[i])
+					continue
+				}
+				i++
+				switch after[i] {
+				case 'n':
+					value.WriteByte('\n')
+				case '\\', '"':
+					value.WriteByte(after[i])
+				default:
+					t.Fatalf("invalid escape in %q", line)
+				}
+			}
+			if i == len(after) {
+				t.Fatalf("unterminated label value in %q", line)
+			}
+			s.labels[name] = value.String()
+			rest = strings.TrimPrefix(after[i+1:], ",")
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(rest[1:]), 64)
+		if err != nil {
+			t.Fatalf("invalid value in %q: %v", line, err)
+		}
+		s.value = v
+		samples = append(samples, s)
+	}
+	if !typed {
+		t.Errorf("exposition doesn't declare %s a gauge:\n%s", metric, text)
+	}
+	return samples
+}
+
+func TestWriteActivityCountsRoundTrips(t *testing.T) {
+	start := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2025, 6, 2, 9, 0, 0, 0, time.FixedZone("JST", 9*60*60))
+	weird := "say \"hi\"\\\nbye"
+	counts := map[string]int{"page_view": 7, "checkout": 42, weird: 1}
+
+	var buf bytes.Buffer
+	if err := writeActivityCounts(&buf, counts, start, end); err != nil {
+		t.Fatalf("writeActivityCounts() failed: %v", err)
+	}
+	got := make(map[string]int)
+	for _, s := range parseExposition(t, buf.String(), "activity_count") {
+		if s.labels["window_start"] != "2025-06-01T00:00:00Z" || s.labels["window_end"] != "2025-06-02T00:00:00Z" {
+			t.Errorf("window labels = %v, want the window in UTC", s.labels)
+		}
+		got[s.labels["activity_type"]] = int(s.value)
+	}
+	if !reflect.DeepEqual(got, counts) {
+		t.Errorf("parsed counts = %v, want %v", got, counts)
+	}
+}
+
+func TestStatsFormat(t *testing.T) {
+	for _, tc := range []struct {
+		target, accept, want string
+	}{
+		{"/activities/stats", "", "json"},
+		{"/activities/stats", "text/html,*/*;q=0.8", "json"},
+		{"/activities/stats", "text/plain", "json"},
+		{"/activities/stats", "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", "prometheus"},
+		{"/activities/stats?format=prometheus", "", "prometheus"},
+		{"/activities/stats?format=json", "text/plain; version=0.0.4", "json"},
+	} {
+		req := httptest.NewRequest("GET", tc.target, nil)
+		req.Header.Set("Accept", tc.accept)
+		if got, err := statsFormat(req); err != nil || got != tc.want {
+			t.Errorf("%s with Accept %q: statsFormat() = %q, %v, want %q", tc.target, tc.accept, got, err, tc.want)
+		}
+	}
+	if _, err := statsFormat(httptest.NewRequest("GET", "/activities/stats?format=xml", nil)); err == nil {
+		t.Error("statsFormat() accepts format=xml")
+	}
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -24,6 +25,8 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -31,9 +34,12 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/status"
 
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
 	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
 	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/money"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/timing"
 	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/validator"
 )
 
@@ -46,10 +52,23 @@ var (
 	frontendMessage  = strings.TrimSpace(os.Getenv("FRONTEND_MESSAGE"))
 	isCymbalBrand    = "true" == strings.ToLower(os.Getenv("CYMBAL_BRANDING"))
 	assistantEnabled = "true" == strings.ToLower(os.Getenv("ENABLE_ASSISTANT"))
+	popularityBadge  = "true" == strings.ToLower(os.Getenv("ENABLE_POPULARITY_BADGE"))
+	cartValueDetails = "true" == strings.ToLower(os.Getenv("ENABLE_CART_VALUE_DETAILS"))
+	// activityDebug shows the health of activity logging on every page, for
+	// developers to notice when it isn't recording anything
+	activityDebug = "true" == strings.ToLower(os.Getenv("ENABLE_ACTIVITY_DEBUG"))
+	// logAssistantText records what shoppers ask the assistant in the
+	// activity log, which otherwise only keeps the length of messages
+	logAssistantText = "true" == strings.ToLower(os.Getenv("ACTIVITY_LOG_ASSISTANT_TEXT"))
+	// clientEvents has pages report events only the browser sees, such as
+	// the time spent on them, to the activity log
+	clientEvents = "true" == strings.ToLower(os.Getenv("ENABLE_CLIENT_EVENTS"))
 	templates        = template.Must(template.New("").
 				Funcs(template.FuncMap{
 			"renderMoney":        renderMoney,
 			"renderCurrencyLogo": renderCurrencyLogo,
+			"renderPercent":      renderPercent,
+			"renderSeconds":      renderSeconds,
 		}).ParseGlob("templates/*.html"))
 	plat platformDetails
 )
@@ -59,6 +78,7 @@ var validEnvs = []string{"local", "gcp", "azure", "aws", "onprem", "alibaba"}
 func (fe *frontendServer) homeHandler(w http.ResponseWriter, r *http.Request) {
 	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
 	log.WithField("currency", currentCurrency(r)).Info("home")
+	grpcDone := timing.Phase(r.Context(), "grpc")
 	currencies, err := fe.getCurrencies(r.Context())
 	if err != nil {
 		renderHTTPError(log, r, w, errors.Wrap(err, "could not retrieve currencies"), http.StatusInternalServerError)
@@ -107,13 +127,16 @@ func (fe *frontendServer) homeHandler(w http.ResponseWriter, r *http.Request) {
 	plat = platformDetails{}
 	plat.setPlatformDetails(strings.ToLower(env))
 
-	if err := templates.ExecuteTemplate(w, "home", injectCommonTemplateData(r, map[string]interface{}{
+	ad := fe.chooseAd(r.Context(), []string{}, log)
+	grpcDone()
+	defer timing.Phase(r.Context(), "render")()
+	if err := executeTemplate(w, r, "home", injectCommonTemplateData(r, map[string]interface{}{
 		"show_currency": true,
 		"currencies":    currencies,
 		"products":      ps,
 		"cart_size":     cartSize(cart),
 		"banner_color":  os.Getenv("BANNER_COLOR"), // illustrates canary deployments
-		"ad":            fe.chooseAd(r.Context(), []string{}, log),
+		"ad":            ad,
 	})); err != nil {
 		log.Error(err)
 	}
@@ -151,8 +174,11 @@ func (fe *frontendServer) productHandler(w http.ResponseWriter, r *http.Request)
 	log.WithField("id", id).WithField("currency", currentCurrency(r)).
 		Debug("serving product page")
 
+	grpcDone := timing.Phase(r.Context(), "grpc")
 	p, err := fe.getProduct(r.Context(), id)
 	if err != nil {
+		// Reported by /activities/stats/availability
+		activitylog.AddDetails(r.Context(), activitylog.ProductViewDetails{ProductUnavailable: true})
 		renderHTTPError(log, r, w, errors.Wrap(err, "could not retrieve product"), http.StatusInternalServerError)
 		return
 	}
@@ -195,8 +221,13 @@ func (fe *frontendServer) productHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	if err := templates.ExecuteTemplate(w, "product", injectCommonTemplateData(r, map[string]interface{}{
-		"ad":              fe.chooseAd(r.Context(), p.Categories, log),
+	ad := fe.chooseAd(r.Context(), p.Categories, log)
+	grpcDone()
+	popularity := productPopularity(r, log, id)
+	defer timing.Phase(r.Context(), "render")()
+	if err := executeTemplate(w, r, "product", injectCommonTemplateData(r, map[string]interface{}{
+		"popularity":      popularity,
+		"ad":              ad,
 		"show_currency":   true,
 		"currencies":      currencies,
 		"product":         product,
@@ -224,14 +255,18 @@ func (fe *frontendServer) addToCartHandler(w http.ResponseWriter, r *http.Reques
 
 	p, err := fe.getProduct(r.Context(), payload.ProductID)
 	if err != nil {
+		activitylog.AddDetails(r.Context(), activitylog.AddToCartDetails{Result: activitylog.ResultFailed})
 		renderHTTPError(log, r, w, errors.Wrap(err, "could not retrieve product"), http.StatusInternalServerError)
 		return
 	}
 
 	if err := fe.insertCart(r.Context(), sessionID(r), p.GetId(), int32(payload.Quantity)); err != nil {
+		activitylog.AddDetails(r.Context(), activitylog.AddToCartDetails{Result: activitylog.ResultFailed})
+		recordCartServiceFailure(r, err)
 		renderHTTPError(log, r, w, errors.Wrap(err, "failed to add to cart"), http.StatusInternalServerError)
 		return
 	}
+	fe.recordCartValue(r, log)
 	w.Header().Set("location", baseUrl + "/cart")
 	w.WriteHeader(http.StatusFound)
 }
@@ -241,6 +276,7 @@ func (fe *frontendServer) emptyCartHandler(w http.ResponseWriter, r *http.Reques
 	log.Debug("emptying cart")
 
 	if err := fe.emptyCart(r.Context(), sessionID(r)); err != nil {
+		recordCartServiceFailure(r, err)
 		renderHTTPError(log, r, w, errors.Wrap(err, "failed to empty cart"), http.StatusInternalServerError)
 		return
 	}
@@ -251,6 +287,7 @@ func (fe *frontendServer) emptyCartHandler(w http.ResponseWriter, r *http.Reques
 func (fe *frontendServer) viewCartHandler(w http.ResponseWriter, r *http.Request) {
 	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
 	log.Debug("view user cart")
+	grpcDone := timing.Phase(r.Context(), "grpc")
 	currencies, err := fe.getCurrencies(r.Context())
 	if err != nil {
 		renderHTTPError(log, r, w, errors.Wrap(err, "could not retrieve currencies"), http.StatusInternalServerError)
@@ -258,9 +295,12 @@ func (fe *frontendServer) viewCartHandler(w http.ResponseWriter, r *http.Request
 	}
 	cart, err := fe.getCart(r.Context(), sessionID(r))
 	if err != nil {
+		recordCartServiceFailure(r, err)
 		renderHTTPError(log, r, w, errors.Wrap(err, "could not retrieve cart"), http.StatusInternalServerError)
 		return
 	}
+	cartItems := cartSize(cart)
+	activitylog.AddDetails(r.Context(), activitylog.ViewCartDetails{CartItems: &cartItems})
 
 	// ignores the error retrieving recommendations since it is not critical
 	recommendations, err := fe.getRecommendations(r.Context(), sessionID(r), cartIDs(cart))
@@ -303,7 +343,9 @@ func (fe *frontendServer) viewCartHandler(w http.ResponseWriter, r *http.Request
 	totalPrice = money.Must(money.Sum(totalPrice, *shippingCost))
 	year := time.Now().Year()
 
-	if err := templates.ExecuteTemplate(w, "cart", injectCommonTemplateData(r, map[string]interface{}{
+	grpcDone()
+	defer timing.Phase(r.Context(), "render")()
+	if err := executeTemplate(w, r, "cart", injectCommonTemplateData(r, map[string]interface{}{
 		"currencies":       currencies,
 		"recommendations":  recommendations,
 		"cart_size":        cartSize(cart),
@@ -334,6 +376,11 @@ func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Reque
 		ccCVV, _      = strconv.ParseInt(r.FormValue("credit_card_cvv"), 10, 32)
 	)
 
+	// Only the destination country is recorded, never the street address
+	if country != "" && len(country) <= 128 {
+		activitylog.AddDetails(r.Context(), activitylog.CheckoutDetails{Country: country})
+	}
+
 	payload := validator.PlaceOrderPayload{
 		Email:         email,
 		StreetAddress: streetAddress,
@@ -347,6 +394,7 @@ func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Reque
 		CcCVV:         ccCVV,
 	}
 	if err := payload.Validate(); err != nil {
+		activitylog.AddDetails(r.Context(), activitylog.CheckoutDetails{Failed: true, FailureReason: activitylog.FailureValidation})
 		renderHTTPError(log, r, w, validator.ValidationErrorResponse(err), http.StatusUnprocessableEntity)
 		return
 	}
@@ -369,10 +417,18 @@ func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Reque
 				Country:       payload.Country},
 		})
 	if err != nil {
+		reason, code := checkoutFailure(err)
+		activitylog.AddDetails(r.Context(), activitylog.CheckoutDetails{Failed: true, FailureReason: reason, FailureCode: code})
 		renderHTTPError(log, r, w, errors.Wrap(err, "failed to complete the order"), http.StatusInternalServerError)
 		return
 	}
 	log.WithField("order", order.GetOrder().GetOrderId()).Info("order placed")
+	shippingCost := order.GetOrder().GetShippingCost()
+	shipping := float64(shippingCost.GetUnits()) + float64(shippingCost.GetNanos())/1e9
+	activitylog.AddDetails(r.Context(), activitylog.CheckoutDetails{ShippingCost: &shipping, ShippingCurrency: shippingCost.GetCurrencyCode()})
+	for _, v := range order.GetOrder().GetItems() {
+		activitylog.AddItem(r.Context(), v.GetItem().GetProductId(), int(v.GetItem().GetQuantity()))
+	}
 
 	order.GetOrder().GetItems()
 	recommendations, _ := fe.getRecommendations(r.Context(), sessionID(r), nil)
@@ -382,6 +438,8 @@ func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Reque
 		multPrice := money.MultiplySlow(*v.GetCost(), uint32(v.GetItem().GetQuantity()))
 		totalPaid = money.Must(money.Sum(totalPaid, multPrice))
 	}
+	total := float64(totalPaid.GetUnits()) + float64(totalPaid.GetNanos())/1e9
+	activitylog.AddDetails(r.Context(), activitylog.CheckoutDetails{OrderTotal: &total, OrderCurrency: totalPaid.GetCurrencyCode()})
 
 	currencies, err := fe.getCurrencies(r.Context())
 	if err != nil {
@@ -389,7 +447,7 @@ func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	if err := templates.ExecuteTemplate(w, "order", injectCommonTemplateData(r, map[string]interface{}{
+	if err := executeTemplate(w, r, "order", injectCommonTemplateData(r, map[string]interface{}{
 		"show_currency":   false,
 		"currencies":      currencies,
 		"order":           order.GetOrder(),
@@ -400,6 +458,106 @@ func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// checkoutFailure tells why the checkout service failed to place an order,
+// as one of the activitylog failure reasons, along with the gRPC code it
+// returned. The service reports every failed step as an internal error, so
+// the steps are told apart by the start of its message.
+func checkoutFailure(err error) (reason, code string) {
+	s := status.Convert(err)
+	code = s.Code().String()
+	switch msg := s.Message(); {
+	case strings.HasPrefix(msg, "failed to charge card"):
+		return activitylog.FailurePaymentDeclined, code
+	case strings.HasPrefix(msg, "shipping quote failure"), strings.HasPrefix(msg, "shipping error"):
+		return activitylog.FailureShipping, code
+	case strings.HasPrefix(msg, "cart failure"):
+		return activitylog.FailureCartUnavailable, code
+	default:
+		return activitylog.FailureOther, code
+	}
+}
+
+// recordCartServiceFailure records in the activity of r that the cart
+// service failed, and the gRPC code it failed with, for the dependency
+// failure stats
+func recordCartServiceFailure(r *http.Request, err error) {
+	ok := false
+	activitylog.AddDetails(r.Context(), activitylog.CartServiceDetails{CartServiceOK: &ok, CartServiceCode: status.Code(err).String()})
+}
+
+// popularityFloor is the view count below which the popularity badge is
+// hidden, to avoid advertising how few people looked at a product
+const popularityFloor = 5
+
+// productPopularity returns how many sessions viewed the product in the
+// last 24 hours, or 0 when the badge shouldn't be shown: it is disabled, the
+// count is below popularityFloor or it couldn't be counted. Whether the badge
+// was shown is recorded on the product view to measure its effect.
+func productPopularity(r *http.Request, log logrus.FieldLogger, productID string) int {
+	if !popularityBadge {
+		return 0
+	}
+	count, err := activitylog.GetProductViewCount(productID, 24*time.Hour)
+	if err != nil {
+		log.WithField("error", err).Warn("failed to count product views")
+		count = 0
+	}
+	if count < popularityFloor {
+		count = 0
+	}
+	shown := count > 0
+	activitylog.AddDetails(r.Context(), activitylog.ProductViewDetails{PopularityBadgeShown: &shown})
+	return count
+}
+
+// cartValueTimeout bounds how much recordCartValue can slow down a cart add
+const cartValueTimeout = 50 * time.Millisecond
+
+// recordCartValue records the number of items in the session's cart and
+// their subtotal in the current currency on the add_to_cart activity, for
+// basket-size analysis. It takes extra backend calls, so it only runs when
+// enabled, and gives up silently after cartValueTimeout.
+func (fe *frontendServer) recordCartValue(r *http.Request, log logrus.FieldLogger) {
+	if !cartValueDetails {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), cartValueTimeout)
+	defer cancel()
+
+	cart, err := fe.getCart(ctx, sessionID(r))
+	if err != nil {
+		log.WithField("error", err).Debug("skipping cart value, could not retrieve cart")
+		return
+	}
+	var items int32
+	subtotal := pb.Money{CurrencyCode: "USD"}
+	for _, item := range cart {
+		p, err := fe.getProduct(ctx, item.GetProductId())
+		if err != nil {
+			log.WithField("error", err).Debug("skipping cart value, could not retrieve product")
+			return
+		}
+		price := money.MultiplySlow(*p.GetPriceUsd(), uint32(item.GetQuantity()))
+		if subtotal, err = money.Sum(subtotal, price); err != nil {
+			log.WithField("error", err).Debug("skipping cart value, could not add up prices")
+			return
+		}
+		items += item.GetQuantity()
+	}
+	converted, err := fe.convertCurrency(ctx, &subtotal, currentCurrency(r))
+	if err != nil {
+		log.WithField("error", err).Debug("skipping cart value, could not convert currency")
+		return
+	}
+	cartItems := int(items)
+	cartSubtotal := float64(converted.GetUnits()) + float64(converted.GetNanos())/1e9
+	activitylog.AddDetails(r.Context(), activitylog.AddToCartDetails{
+		CartItems:    &cartItems,
+		CartSubtotal: &cartSubtotal,
+		CartCurrency: converted.GetCurrencyCode(),
+	})
+}
+
 func (fe *frontendServer) assistantHandler(w http.ResponseWriter, r *http.Request) {
 	currencies, err := fe.getCurrencies(r.Context())
 	if err != nil {
@@ -407,7 +565,7 @@ func (fe *frontendServer) assistantHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if err := templates.ExecuteTemplate(w, "assistant", injectCommonTemplateData(r, map[string]interface{}{
+	if err := executeTemplate(w, r, "assistant", injectCommonTemplateData(r, map[string]interface{}{
 		"show_currency": false,
 		"currencies":    currencies,
 	})); err != nil {
@@ -427,6 +585,53 @@ func (fe *frontendServer) logoutHandler(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusFound)
 }
 
+// userIDFormat is what user IDs, such as a user name or an email address,
+// look like
+var userIDFormat = regexp.MustCompile(`^[A-Za-z0-9_.@+-]{1,64}$`)
+
+// loginHandler signs the shopper in as the user_id form value. The demo has
+// no accounts, so there is nothing to check it against. The session's
+// earlier activities are attributed to the user, and signing in to a
+// session that belongs to someone else fails.
+func (fe *frontendServer) loginHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	if !validCSRFToken(r) {
+		renderJSONError(log, r, w, errors.New("missing or invalid CSRF token"), http.StatusForbidden)
+		return
+	}
+	userID := r.PostFormValue("user_id")
+	if !userIDFormat.MatchString(userID) {
+		renderJSONError(log, r, w, errors.New("invalid user_id"), http.StatusBadRequest)
+		return
+	}
+
+	attributed, err := activitylog.AttributeSession(r.Context(), sessionID(r), userID)
+	if errors.Is(err, activitylog.ErrSessionAttributed) {
+		renderJSONError(log, r, w, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to attribute the session"), http.StatusInternalServerError)
+		return
+	}
+	activitylog.SetUser(r.Context(), userID)
+	log.WithField("attributed", attributed).Debug("signed in")
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieUserID,
+		Value:    signUserID(userID),
+		MaxAge:   cookieMaxAge,
+		HttpOnly: true,
+	})
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"user_id": userID, "attributed": attributed})
+		return
+	}
+	w.Header().Set("Location", baseUrl+"/")
+	w.WriteHeader(http.StatusFound)
+}
+
 func (fe *frontendServer) getProductByID(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["ids"]
 	if id == "" {
@@ -461,15 +666,29 @@ func (fe *frontendServer) chatBotHandler(w http.ResponseWriter, r *http.Request)
 
 	var response LLMResponse
 
+	message, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAssistantMessageBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		renderHTTPError(log, r, w, errors.Errorf("messages are limited to %d bytes", maxAssistantMessageBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		renderHTTPError(log, r, w, errors.Wrap(err, "failed to read message"), http.StatusBadRequest)
+		return
+	}
+	recordAssistantMessage(r, message)
+
 	url := "http://" + fe.shoppingAssistantSvcAddr
-	req, err := http.NewRequest(http.MethodPost, url, r.Body)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(message))
 	if err != nil {
 		renderHTTPError(log, r, w, errors.Wrap(err, "failed to create request"), http.StatusInternalServerError)
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	start := time.Now()
 	res, err := http.DefaultClient.Do(req)
+	activitylog.AddDuration(r.Context(), "assistant_ms", time.Since(start))
 	if err != nil {
 		renderHTTPError(log, r, w, errors.Wrap(err, "failed to send request"), http.StatusInternalServerError)
 		return
@@ -536,10 +755,14 @@ func (fe *frontendServer) chooseAd(ctx context.Context, ctxKeys []string, log lo
 func renderHTTPError(log logrus.FieldLogger, r *http.Request, w http.ResponseWriter, err error, code int) {
 	log.WithField("error", err).Error("request error")
 	errMsg := fmt.Sprintf("%+v", err)
+	handler := callerName(1)
+	if code >= http.StatusInternalServerError {
+		activitylog.AddDetails(r.Context(), activitylog.CommonDetails{Error: err.Error(), Handler: handler})
+	}
 
 	w.WriteHeader(code)
 
-	if templateErr := templates.ExecuteTemplate(w, "error", injectCommonTemplateData(r, map[string]interface{}{
+	if templateErr := executeTemplateFor(handler, w, r, "error", injectCommonTemplateData(r, map[string]interface{}{
 		"error":       errMsg,
 		"status_code": code,
 		"status":      http.StatusText(code),
@@ -548,6 +771,43 @@ func renderHTTPError(log logrus.FieldLogger, r *http.Request, w http.ResponseWri
 	}
 }
 
+// executeTemplate renders the named template to w. A template that fails
+// to render is recorded, with the error and the handler that called, in
+// the details of the request's activity, which makes it a render_error.
+func executeTemplate(w io.Writer, r *http.Request, name string, data interface{}) error {
+	return executeTemplateFor(callerName(1), w, r, name, data)
+}
+
+// executeTemplateFor is executeTemplate on behalf of handler
+func executeTemplateFor(handler string, w io.Writer, r *http.Request, name string, data interface{}) error {
+	err := templates.ExecuteTemplate(w, name, data)
+	if err != nil {
+		activitylog.AddDetails(r.Context(), activitylog.RenderErrorDetails{
+			CommonDetails: activitylog.CommonDetails{Error: err.Error(), Handler: handler},
+			Template:      name,
+		})
+	}
+	return err
+}
+
+// closureSuffix is what the runtime appends to the names of closures
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$`)
+
+// callerName returns the name of the function skip frames above its
+// caller, such as homeHandler, without its package or receiver
+func callerName(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return ""
+	}
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return ""
+	}
+	name := closureSuffix.ReplaceAllString(fn.Name(), "")
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
 func injectCommonTemplateData(r *http.Request, payload map[string]interface{}) map[string]interface{} {
 	data := map[string]interface{}{
 		"session_id":        sessionID(r),
@@ -561,6 +821,12 @@ func injectCommonTemplateData(r *http.Request, payload map[string]interface{}) m
 		"frontendMessage":   frontendMessage,
 		"currentYear":       time.Now().Year(),
 		"baseUrl":           baseUrl,
+		"csrf_token":        csrfToken(sessionID(r)),
+		"flash":             r.Context().Value(ctxKeyFlash{}),
+		"client_events":     clientEvents,
+	}
+	if activityDebug {
+		data["activity_status"] = activitylog.Status()
 	}
 
 	for k, v := range payload {
@@ -625,6 +891,17 @@ func renderCurrencyLogo(currencyCode string) string {
 	return logo
 }
 
+// renderPercent renders a fraction such as 0.25 as a whole percentage
+func renderPercent(fraction float64) string {
+	return fmt.Sprintf("%.0f%%", fraction*100)
+}
+
+// renderSeconds renders a number of seconds as a duration such as 3m20s,
+// rounded to the second
+func renderSeconds(seconds float64) string {
+	return (time.Duration(seconds*float64(time.Second))).Round(time.Second).String()
+}
+
 func stringinSlice(slice []string, val string) bool {
 	for _, item := range slice {
 		if item == val {
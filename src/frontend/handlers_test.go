@@ -0,0 +1,203 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+func TestCheckoutFailure(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		reason string
+		code   string
+	}{
+		// As the checkout service reports them
+		{status.Error(codes.Internal, "failed to charge card: could not charge the card: rpc error: code = Unknown desc = Credit card info is invalid"), activitylog.FailurePaymentDeclined, "Internal"},
+		{status.Error(codes.Internal, "shipping quote failure: failed to get shipping quote: rpc error: code = Unavailable"), activitylog.FailureShipping, "Internal"},
+		{status.Error(codes.Unavailable, "shipping error: shipment failed: rpc error: code = Unavailable"), activitylog.FailureShipping, "Unavailable"},
+		{status.Error(codes.Internal, "cart failure: failed to get user cart during checkout: rpc error: code = Unavailable"), activitylog.FailureCartUnavailable, "Internal"},
+		// The inner error doesn't decide the reason
+		{status.Error(codes.Internal, "failed to prepare order: failed to get product #\"cart\""), activitylog.FailureOther, "Internal"},
+		{status.Error(codes.DeadlineExceeded, "context deadline exceeded"), activitylog.FailureOther, "DeadlineExceeded"},
+		{context.Canceled, activitylog.FailureOther, "Unknown"},
+	} {
+		reason, code := checkoutFailure(tc.err)
+		if reason != tc.reason || code != tc.code {
+			t.Errorf("checkoutFailure(%v) = %s, %s; want %s, %s", tc.err, reason, code, tc.reason, tc.code)
+		}
+	}
+}
+
+func TestCartServiceFailuresAreRecorded(t *testing.T) {
+	emptyActivityLog(t)
+	// Nothing listens there, so every call fails
+	conn, err := grpc.NewClient("passthrough:///127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fe := &frontendServer{productCatalogSvcConn: conn, cartSvcConn: conn}
+	r := mux.NewRouter()
+	r.HandleFunc("/cart", fe.addToCartHandler).Methods(http.MethodPost)
+	r.HandleFunc("/cart/empty", fe.emptyCartHandler).Methods(http.MethodPost)
+	log := logrus.New()
+	log.Out = io.Discard
+	h := activitylog.NewActivityMiddleware(log, r)
+
+	for path, want := range map[string]map[string]interface{}{
+		// The product lookup failed before the cart service was called
+		"/cart":       {"result": activitylog.ResultFailed, "handler": "addToCartHandler"},
+		"/cart/empty": {"cart_service_ok": false, "cart_service_code": "Unavailable", "handler": "emptyCartHandler"},
+	} {
+		session := "session" + strings.ReplaceAll(path, "/", "-")
+		req := sessionRequest(path, session, url.Values{"product_id": {"OLJCESPC7Z"}, "quantity": {"1"}})
+		req = req.WithContext(context.WithValue(req.Context(), activitylog.CtxKeySessionID{}, session))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("POST %s: status = %d, want 500", path, w.Code)
+		}
+		activities, err := activitylog.GetActivitiesBySession(session, 1)
+		if err != nil || len(activities) != 1 {
+			t.Fatalf("POST %s logged %d activities, %v; want 1", path, len(activities), err)
+		}
+		var details map[string]interface{}
+		if err := json.Unmarshal([]byte(activities[0].Details), &details); err != nil {
+			t.Fatalf("POST %s: details %q: %v", path, activities[0].Details, err)
+		}
+		for k, v := range want {
+			if details[k] != v {
+				t.Errorf("POST %s: details[%s] = %v, want %v", path, k, details[k], v)
+			}
+		}
+	}
+}
+
+func TestTemplateFailuresAreRecorded(t *testing.T) {
+	emptyActivityLog(t)
+	log := logrus.New()
+	log.Out = io.Discard
+	h := activitylog.NewActivityMiddleware(log, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := executeTemplate(w, r, "no_such_page", nil); err == nil {
+			t.Error("executeTemplate() of a missing template succeeded")
+		}
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), activitylog.CtxKeySessionID{}, "render"))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	activities, err := activitylog.GetActivitiesBySession("render", 1)
+	if err != nil || len(activities) != 1 {
+		t.Fatalf("logged %d activities, %v; want 1", len(activities), err)
+	}
+	if got := activities[0].ActivityType; got != activitylog.ActivityTypeRenderError {
+		t.Errorf("activity type = %q, want %q", got, activitylog.ActivityTypeRenderError)
+	}
+	var details map[string]interface{}
+	if err := json.Unmarshal([]byte(activities[0].Details), &details); err != nil {
+		t.Fatalf("details %q: %v", activities[0].Details, err)
+	}
+	if details["template"] != "no_such_page" || details["handler"] != "TestTemplateFailuresAreRecorded" ||
+		!strings.Contains(details["error"].(string), "no_such_page") {
+		t.Errorf("details = %v, want the template, handler and error", details)
+	}
+}
+
+func TestLoginAttributesTheSession(t *testing.T) {
+	emptyActivityLog(t)
+	for _, session := range []string{"anonymous", "taken"} {
+		if err := activitylog.LogActivity(&activitylog.ActivityLog{SessionID: session, ActivityType: activitylog.ActivityTypePageView}); err != nil {
+			t.Fatalf("LogActivity() failed: %v", err)
+		}
+	}
+	if _, err := activitylog.AttributeSession(context.Background(), "taken", "bob"); err != nil {
+		t.Fatalf("AttributeSession() failed: %v", err)
+	}
+	h := http.HandlerFunc((&frontendServer{}).loginHandler)
+	log := logrus.New()
+	log.Out = io.Discard
+	login := func(session, token, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("user_id="+userID))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-CSRF-Token", token)
+		ctx := context.WithValue(req.Context(), ctxKeyLog{}, logrus.FieldLogger(log))
+		ctx = context.WithValue(ctx, ctxKeySessionID{}, session)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req.WithContext(ctx))
+		return w
+	}
+
+	w := login("anonymous", csrfToken("anonymous"), "alice")
+	if w.Code != http.StatusFound || !strings.Contains(w.Header().Get("Set-Cookie"), cookieUserID+"=alice") {
+		t.Fatalf("status = %d, cookies %q; want a redirect signing alice in", w.Code, w.Header().Values("Set-Cookie"))
+	}
+	if got, err := activitylog.GetActivities(activitylog.Filter{UserID: "alice"}, 10); err != nil || len(got) != 1 {
+		t.Errorf("activities of alice = %d, %v; want the session's page view", len(got), err)
+	}
+
+	for _, tc := range []struct {
+		name, session, token, userID string
+		want                         int
+	}{
+		{"session of another user", "taken", csrfToken("taken"), "alice", http.StatusConflict},
+		{"missing CSRF token", "anonymous", "", "alice", http.StatusForbidden},
+		{"invalid user ID", "anonymous", csrfToken("anonymous"), "<alice>", http.StatusBadRequest},
+	} {
+		if w := login(tc.session, tc.token, tc.userID); w.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
+
+func TestActivityDebugBanner(t *testing.T) {
+	emptyActivityLog(t)
+	render := func() string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), ctxKeySessionID{}, "session-1"))
+		var buf strings.Builder
+		if err := templates.ExecuteTemplate(&buf, "header", injectCommonTemplateData(req, nil)); err != nil {
+			t.Fatalf("rendering the header failed: %v", err)
+		}
+		return buf.String()
+	}
+
+	if html := render(); strings.Contains(html, "Activity logging:") {
+		t.Error("header shows the activity status with ENABLE_ACTIVITY_DEBUG unset")
+	}
+	activityDebug = true
+	defer func() { activityDebug = false }()
+	if html := render(); !strings.Contains(html, "Activity logging: "+activitylog.StateOK) {
+		t.Error("header doesn't show the activity status with ENABLE_ACTIVITY_DEBUG=true")
+	}
+}
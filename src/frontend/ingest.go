@@ -0,0 +1,92 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+// clientEventRetryAfter is how long a session over its allowance of client
+// events is told to wait
+const clientEventRetryAfter = 60
+
+// ingestActivityHandler records an event reported by the shop's own
+// script, such as the time spent on a page, as the activity of the
+// request. Requests without a session cookie, from other origins, of
+// another content type or over the session's allowance are refused, and
+// neither they nor invalid events are logged.
+func (fe *frontendServer) ingestActivityHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	reject := func(reason string, err error, code int) {
+		activitylog.SkipActivity(r.Context())
+		activitylog.RejectClientEvent(reason)
+		renderJSONError(log, r, w, err, code)
+	}
+
+	// The session must be one the shop handed out, not the one assigned
+	// to this cookie-less request
+	if _, err := r.Cookie(cookieSessionID); err != nil {
+		reject("no_session", errors.New("client events need a session"), http.StatusForbidden)
+		return
+	}
+	if !sameOrigin(r) {
+		reject("cross_origin", errors.New("client events must come from the shop's pages"), http.StatusForbidden)
+		return
+	}
+	// Forms can't send JSON, so other sites can't post events on behalf
+	// of a shopper without a preflight
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		reject("content_type", errors.New("client events must be application/json"), http.StatusUnsupportedMediaType)
+		return
+	}
+	if !activitylog.AllowClientEvent(sessionID(r)) {
+		w.Header().Set("Retry-After", strconv.Itoa(clientEventRetryAfter))
+		reject("rate_limited", errors.New("too many client events"), http.StatusTooManyRequests)
+		return
+	}
+
+	ev, err := activitylog.ParseClientEvent(http.MaxBytesReader(w, r.Body, activitylog.MaxClientEventBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		reject("too_large", errors.Errorf("client events are limited to %d bytes", activitylog.MaxClientEventBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		reject("invalid", err, http.StatusBadRequest)
+		return
+	}
+	activitylog.RecordClientEvent(r.Context(), ev)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sameOrigin tells whether a request comes from a page of the shop, going
+// by its Origin header. Browsers always send one with cross-origin POSTs,
+// so requests without one pass.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
@@ -0,0 +1,96 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+const pageUnload = `{"type":"page_unload","details":{"time_on_page_ms":1500}}`
+
+// ingestRequest is a client event as the shop's own script sends it
+func ingestRequest(session, body string) *http.Request {
+	req := sessionRequest("/activities/ingest", session, nil)
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.Host = "shop.example.com"
+	req.Header.Set("Origin", "https://shop.example.com")
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: session})
+	return req
+}
+
+func TestIngestActivityHandler(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		req  func() *http.Request
+		want int
+	}{
+		{"accepted", func() *http.Request { return ingestRequest("session-1", pageUnload) }, http.StatusNoContent},
+		{"no session cookie", func() *http.Request {
+			req := ingestRequest("session-1", pageUnload)
+			req.Header.Del("Cookie")
+			return req
+		}, http.StatusForbidden},
+		{"other origin", func() *http.Request {
+			req := ingestRequest("session-1", pageUnload)
+			req.Header.Set("Origin", "https://evil.example.com")
+			return req
+		}, http.StatusForbidden},
+		{"form", func() *http.Request {
+			req := ingestRequest("session-1", pageUnload)
+			req.Header.Set("Content-Type", "text/plain")
+			return req
+		}, http.StatusUnsupportedMediaType},
+		{"too large", func() *http.Request {
+			return ingestRequest("session-1", `{"type":"page_unload","details":{"time_on_page_ms":1`+
+				strings.Repeat("0", activitylog.MaxClientEventBytes)+`}}`)
+		}, http.StatusRequestEntityTooLarge},
+		{"invalid", func() *http.Request {
+			return ingestRequest("session-1", `{"type":"checkout"}`)
+		}, http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			activitylog.ConfigureClientEvents(0)
+			w := httptest.NewRecorder()
+			(&frontendServer{}).ingestActivityHandler(w, tt.req())
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestIngestActivityHandlerRateLimits(t *testing.T) {
+	activitylog.ConfigureClientEvents(1)
+	t.Cleanup(func() { activitylog.ConfigureClientEvents(0) })
+
+	fe := &frontendServer{}
+	w := httptest.NewRecorder()
+	fe.ingestActivityHandler(w, ingestRequest("session-1", pageUnload))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("first event status = %d, want 204: %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	fe.ingestActivityHandler(w, ingestRequest("session-1", pageUnload))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("second event status = %d with Retry-After %q, want 429 with one", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const defaultShedRetryAfter = 5 * time.Second
+
+// Load shedding metrics, served at /debug/vars along with the activity
+// log's
+var (
+	// requestsInFlight is the number of requests being served, activity
+	// streams and long polls aside.
+	requestsInFlight = expvar.NewInt("frontend_requests_in_flight")
+	// activityReadsShedding is 1 while activity API reads are refused to
+	// make room for shoppers, and 0 otherwise.
+	activityReadsShedding = expvar.NewInt("activity_api_shedding")
+	// activityReadsShed counts the activity API reads refused.
+	activityReadsShed = expvar.NewInt("activity_api_shed_total")
+)
+
+// activityLoadShedder is set when ACTIVITY_SHED_HIGH_WATER is
+var activityLoadShedder *loadShedder
+
+// unsheddableActivityPaths are the activity API reads answered however
+// busy the frontend is, since deployments and clients check them to tell
+// whether it is healthy
+var unsheddableActivityPaths = []string{"/activities/version", "/activities/archives/status", "/activities/outbox/status"}
+
+// longLivedActivityPaths are the activity API reads that mostly wait,
+// which aren't counted in flight so that dashboards following the
+// activity log don't count as load
+var longLivedActivityPaths = []string{"/activities/stream", "/activities/poll"}
+
+// loadShedder refuses activity API reads while more than highWater
+// requests are in flight, until no more than lowWater are, so that
+// analytics get out of the way of shoppers during traffic spikes
+type loadShedder struct {
+	highWater  int64
+	lowWater   int64
+	retryAfter time.Duration
+
+	inFlight atomic.Int64
+	shedding atomic.Bool
+}
+
+// newLoadShedder returns a load shedder starting to shed above highWater
+// requests in flight. It stops at lowWater, 3/4 of highWater unless
+// lower, and tells clients to retry after retryAfter, 5s by default.
+func newLoadShedder(highWater, lowWater int, retryAfter time.Duration) *loadShedder {
+	if lowWater <= 0 || lowWater >= highWater {
+		lowWater = highWater * 3 / 4
+	}
+	if retryAfter <= 0 {
+		retryAfter = defaultShedRetryAfter
+	}
+	return &loadShedder{highWater: int64(highWater), lowWater: int64(lowWater), retryAfter: retryAfter}
+}
+
+// Shedding tells whether activity API reads are being refused
+func (s *loadShedder) Shedding() bool {
+	return s.shedding.Load()
+}
+
+// update starts or stops shedding per the n requests in flight
+func (s *loadShedder) update(n int64) {
+	requestsInFlight.Set(n)
+	switch {
+	case n > s.highWater:
+		if s.shedding.CompareAndSwap(false, true) {
+			activityReadsShedding.Set(1)
+		}
+	case n <= s.lowWater:
+		if s.shedding.CompareAndSwap(true, false) {
+			activityReadsShedding.Set(0)
+		}
+	}
+}
+
+// withLoadShedding counts the requests in flight through next and answers
+// 503 with a Retry-After hint to activity API reads while s sheds them.
+// Other requests are always passed through.
+func withLoadShedding(next http.Handler, s *loadShedder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isActivityRead(r, unsheddableActivityPaths) && s.Shedding() {
+			activityReadsShed.Add(1)
+			var body activityError
+			body.Error.Code = http.StatusServiceUnavailable
+			body.Error.Message = "the frontend is busy serving shoppers, retry later"
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(s.retryAfter.Seconds()))))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(body)
+			return
+		}
+		if !onPath(r, longLivedActivityPaths) {
+			s.update(s.inFlight.Add(1))
+			defer func() { s.update(s.inFlight.Add(-1)) }()
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// isActivityRead tells whether r reads the activity API at a path other
+// than the excluded ones
+func isActivityRead(r *http.Request, excluded []string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	prefix := baseUrl + "/activities"
+	if r.URL.Path != prefix && !strings.HasPrefix(r.URL.Path, prefix+"/") {
+		return false
+	}
+	return !onPath(r, excluded)
+}
+
+// onPath tells whether r is for one of paths, under baseUrl
+func onPath(r *http.Request, paths []string) bool {
+	for _, p := range paths {
+		if r.URL.Path == baseUrl+p {
+			return true
+		}
+	}
+	return false
+}
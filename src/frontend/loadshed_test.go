@@ -0,0 +1,136 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestLoadSheddingUnderSpike floods the frontend with slow product page
+// requests and checks that activity reads are refused while they are in
+// flight, and served again once they drain, while every product page
+// succeeds
+func TestLoadSheddingUnderSpike(t *testing.T) {
+	const shoppers, highWater = 40, 20
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/product/", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, "product")
+	})
+	mux.HandleFunc("/activities/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "[]")
+	})
+	s := newLoadShedder(highWater, 0, 2*time.Second)
+	srv := httptest.NewServer(withLoadShedding(mux, s))
+	defer srv.Close()
+
+	get := func(path string) *http.Response {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := get("/activities/sessions"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("activity read before the spike: status %d, want 200", resp.StatusCode)
+	}
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, shoppers)
+	for i := range shoppers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(fmt.Sprintf("%s/product/%d", srv.URL, i))
+			if err != nil {
+				t.Errorf("GET /product/%d failed: %v", i, err)
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.inFlight.Load() < shoppers {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d product requests in flight", s.inFlight.Load(), shoppers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	shed := activityReadsShed.Value()
+	for _, path := range []string{"/activities/sessions", "/activities/stats/funnel", "/activities/poll"} {
+		resp := get(path)
+		if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "2" {
+			t.Errorf("GET %s during the spike: status %d, Retry-After %q; want 503 after 2s", path, resp.StatusCode, resp.Header.Get("Retry-After"))
+		}
+	}
+	if n := activityReadsShed.Value() - shed; n != 3 {
+		t.Errorf("%d activity reads counted as shed, want 3", n)
+	}
+	if activityReadsShedding.Value() != 1 {
+		t.Error("activity_api_shedding isn't set during the spike")
+	}
+	if resp := get("/activities/version"); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /activities/version during the spike: status %d, want 200", resp.StatusCode)
+	}
+
+	close(release)
+	wg.Wait()
+	close(statuses)
+	served := 0
+	for status := range statuses {
+		if status != http.StatusOK {
+			t.Errorf("product page during the spike: status %d, want 200", status)
+		}
+		served++
+	}
+	if served != shoppers {
+		t.Errorf("%d of %d product pages served", served, shoppers)
+	}
+	if resp := get("/activities/sessions"); resp.StatusCode != http.StatusOK {
+		t.Errorf("activity read after the spike: status %d, want 200", resp.StatusCode)
+	}
+	if activityReadsShedding.Value() != 0 {
+		t.Error("activity_api_shedding still set after the spike")
+	}
+}
+
+func TestLoadShedderHysteresis(t *testing.T) {
+	s := newLoadShedder(8, 4, 0)
+	if s.retryAfter != defaultShedRetryAfter {
+		t.Errorf("retry after %s, want the default %s", s.retryAfter, defaultShedRetryAfter)
+	}
+	for _, step := range []struct {
+		inFlight int64
+		shedding bool
+	}{{8, false}, {9, true}, {6, true}, {4, false}, {6, false}, {9, true}} {
+		s.update(step.inFlight)
+		if s.Shedding() != step.shedding {
+			t.Errorf("shedding = %t at %d requests in flight, want %t", s.Shedding(), step.inFlight, step.shedding)
+		}
+	}
+	if s := newLoadShedder(8, 10, time.Second); s.lowWater != 6 {
+		t.Errorf("low water %d above the high water, want it at 3/4 of it", s.lowWater)
+	}
+}
@@ -16,13 +16,18 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+	_ "time/tzdata" // the tz parameter of stats needs zone data, which the image lacks
 
 	"cloud.google.com/go/profiler"
 	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/experiments"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -43,6 +48,9 @@ const (
 	cookiePrefix    = "shop_"
 	cookieSessionID = cookiePrefix + "session-id"
 	cookieCurrency  = cookiePrefix + "currency"
+	cookieFlash     = cookiePrefix + "flash"
+	cookieUserID    = cookiePrefix + "user-id"
+	cookieDeviceID  = cookiePrefix + "device-id"
 )
 
 var (
@@ -58,8 +66,26 @@ var (
 	baseUrl         = ""
 )
 
-// CtxKeySessionID is the type for session ID context keys
-type CtxKeySessionID struct{}
+// version is the frontend build, set with -ldflags "-X main.version=..."
+// when building a release. It is reported to the profiler and stamped on
+// every activity.
+var version = "1.0.0"
+
+// ctxKeySessionID is shared with the activity logging middleware, which
+// attributes every activity to the session it belongs to.
+type ctxKeySessionID = activitylog.CtxKeySessionID
+
+// ctxKeyUserID is set for the requests of signed-in users, whose activities
+// are attributed to them.
+type ctxKeyUserID = activitylog.CtxKeyUserID
+
+// ctxKeyNewSession marks the requests that start a session, whose
+// activity is logged as the session's entry.
+type ctxKeyNewSession = activitylog.CtxKeyNewSession
+
+// ctxKeyDeviceID is set, with ENABLE_DEVICE_ID, to the device ID that
+// stitches together the sessions of a returning browser.
+type ctxKeyDeviceID = activitylog.CtxKeyDeviceID
 
 type frontendServer struct {
 	productCatalogSvcAddr string
@@ -87,6 +113,22 @@ type frontendServer struct {
 	collectorConn *grpc.ClientConn
 
 	shoppingAssistantSvcAddr string
+
+	experiments experiments.Set
+
+	// archiveExporter uploads daily activity archives to Cloud Storage,
+	// nil unless ACTIVITY_GCS_BUCKET is set
+	archiveExporter *activitylog.ArchiveExporter
+	// anomalyDetector watches activity rates for spikes and drops
+	anomalyDetector *activitylog.AnomalyDetector
+	// reports caches the weekly activity reports
+	reports *reportCache
+	// dashboardStore serves the sections of /activities/dashboard, the
+	// activity log when nil
+	dashboardStore dashboardStore
+	// flagsHash identifies the behaviorFlags the frontend runs with,
+	// stamped on every activity
+	flagsHash string
 }
 
 func main() {
@@ -120,7 +162,7 @@ func main() {
 
 	if os.Getenv("ENABLE_PROFILER") == "1" {
 		log.Info("Profiling enabled.")
-		go initProfiling(log, "frontend", "1.0.0")
+		go initProfiling(log, "frontend", version)
 	} else {
 		log.Info("Profiling disabled.")
 	}
@@ -152,42 +194,547 @@ func main() {
 		log.Fatalf("failed to initialize activity logging: %v", err)
 	}
 	defer activitylog.CloseDB()
+	configureActivityLimits(log)
+	// Splitting the activities by month is a one-way conversion, run once
+	// and skipped from then on
+	if os.Getenv("ACTIVITY_PARTITION_BY_MONTH") == "true" {
+		if _, err := activitylog.PartitionByMonth(ctx); err != nil {
+			log.Fatalf("failed to split the activities by month: %v", err)
+		}
+	}
+	// Writes can be frozen from the start, during a migration for one, and
+	// let through again with POST /activities/admin/readonly
+	activitylog.SetReadOnly(os.Getenv("ACTIVITY_READ_ONLY") == "true")
+	activitylog.StartRollupJob(ctx)
+	activitylog.StartSessionCounterCheck(ctx)
+	activitylog.StartWALCheckpointer(ctx)
+	activitylog.StartIntegrityCheck(ctx)
+	svc.reports = &reportCache{dir: reportCacheDir}
+	svc.reports.start(ctx, log)
+	if url := os.Getenv("ACTIVITY_WEBHOOK_URL"); url != "" {
+		err := activitylog.NewWebhookSink(activitylog.WebhookConfig{
+			URL:    url,
+			Token:  os.Getenv("ACTIVITY_WEBHOOK_TOKEN"),
+			Secret: os.Getenv("ACTIVITY_WEBHOOK_SECRET"),
+		}).Start(ctx)
+		if err != nil {
+			log.Fatalf("failed to start forwarding activities to the webhook: %v", err)
+		}
+		log.Info("forwarding activities to webhook")
+	}
+	svc.anomalyDetector = activitylog.NewAnomalyDetector(anomalyConfig(log))
+	svc.anomalyDetector.Start(ctx)
+	if url := os.Getenv("ACTIVITY_ALERT_WEBHOOK_URL"); url != "" {
+		activitylog.NewAlertNotifier(alertNotifierConfig(log, url)).Start(ctx)
+		log.Info("posting activity alerts to webhook")
+	}
+	if bucket := os.Getenv("ACTIVITY_GCS_BUCKET"); bucket != "" {
+		interval := 24 * time.Hour
+		if v := os.Getenv("ACTIVITY_GCS_EXPORT_INTERVAL"); v != "" {
+			if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+				log.Warnf("ignoring invalid ACTIVITY_GCS_EXPORT_INTERVAL %q", v)
+			} else {
+				interval = d
+			}
+		}
+		if gcs, err := activitylog.NewGCSBucket(ctx, bucket); err != nil {
+			log.WithError(err).Warn("not exporting activity archives to Cloud Storage")
+		} else {
+			svc.archiveExporter = activitylog.NewArchiveExporter(gcs, bucket)
+			svc.archiveExporter.Start(ctx, interval)
+			log.WithField("bucket", bucket).Info("exporting activity archives to Cloud Storage")
+		}
+	}
+	// Started once archiving is, so that it doesn't expire activities not
+	// archived yet
+	activitylog.StartRetentionJob(ctx)
 
 	r := mux.NewRouter()
-	r.HandleFunc(baseUrl + "/", svc.homeHandler).Methods(http.MethodGet, http.MethodHead)
-	r.HandleFunc(baseUrl + "/product/{id}", svc.productHandler).Methods(http.MethodGet, http.MethodHead)
-	r.HandleFunc(baseUrl + "/cart", svc.viewCartHandler).Methods(http.MethodGet, http.MethodHead)
-	r.HandleFunc(baseUrl + "/cart", svc.addToCartHandler).Methods(http.MethodPost)
-	r.HandleFunc(baseUrl + "/cart/empty", svc.emptyCartHandler).Methods(http.MethodPost)
-	r.HandleFunc(baseUrl + "/setCurrency", svc.setCurrencyHandler).Methods(http.MethodPost)
-	r.HandleFunc(baseUrl + "/logout", svc.logoutHandler).Methods(http.MethodGet)
-	r.HandleFunc(baseUrl + "/cart/checkout", svc.placeOrderHandler).Methods(http.MethodPost)
-	r.HandleFunc(baseUrl + "/assistant", svc.assistantHandler).Methods(http.MethodGet)
-	r.PathPrefix(baseUrl + "/static/").Handler(http.StripPrefix(baseUrl + "/static/", http.FileServer(http.Dir("./static/"))))
-	r.HandleFunc(baseUrl + "/robots.txt", func(w http.ResponseWriter, _ *http.Request) { fmt.Fprint(w, "User-agent: *\nDisallow: /") })
-	r.HandleFunc(baseUrl + "/_healthz", func(w http.ResponseWriter, _ *http.Request) { fmt.Fprint(w, "ok") })
-	r.HandleFunc(baseUrl + "/product-meta/{ids}", svc.getProductByID).Methods(http.MethodGet)
-	r.HandleFunc(baseUrl + "/bot", svc.chatBotHandler).Methods(http.MethodPost)
+	svc.registerRoutes(r, log)
 
-	// Activity logging endpoints
-	r.HandleFunc(baseUrl + "/activities", svc.listActivitiesHandler).Methods(http.MethodGet)
-	r.HandleFunc(baseUrl + "/activities/session", svc.sessionActivitiesHandler).Methods(http.MethodGet)
-	r.HandleFunc(baseUrl + "/activities/stats", svc.activityStatsHandler).Methods(http.MethodGet)
-	r.HandleFunc(baseUrl + "/activities/view", func(w http.ResponseWriter, r *http.Request) {
-		if err := templates.ExecuteTemplate(w, "activities", nil); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Activity logging runs inside the router so that the matched route is
+	// available for classification, and after the session and request IDs
+	// have been assigned by the outer handlers.
+	experimentSet, err := experiments.Parse(os.Getenv("FRONTEND_EXPERIMENTS"))
+	if err != nil {
+		log.Fatalf("invalid FRONTEND_EXPERIMENTS: %v", err)
+	}
+	svc.experiments = experimentSet
+	activityOpts := []activitylog.Option{
+		activitylog.WithExperiments(experimentSet.Assign),
+		// FRONTEND_REVISION tells deployments of the same version apart,
+		// such as the blue and green sides of a rollout
+		activitylog.WithDeployment(version, os.Getenv("FRONTEND_REVISION")),
+	}
+	// The flags that change what shoppers see, so that metrics can be
+	// compared across flips of them
+	if svc.flagsHash, err = activitylog.RegisterFlagSet(ctx, behaviorFlags()); err != nil {
+		log.WithError(err).Warn("failed to record the feature flags, activities are stamped with an unresolvable flags_hash")
+	}
+	activityOpts = append(activityOpts, activitylog.WithFlagSet(svc.flagsHash))
+	// The currency cookie is whatever clients send, check it against what
+	// the currency service knows
+	currencyTTL := activitylog.DefaultCurrencyCacheTTL
+	if v := os.Getenv("ACTIVITY_CURRENCY_CACHE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			log.Warnf("ignoring invalid ACTIVITY_CURRENCY_CACHE_TTL %q", v)
+		} else {
+			currencyTTL = d
+		}
+	}
+	currencyValidator := activitylog.NewCurrencyValidator(svc.getSupportedCurrencies, currencyTTL)
+	currencyValidator.Start(ctx)
+	activityOpts = append(activityOpts, activitylog.WithCurrencyValidator(currencyValidator))
+	// Cookies such as a loyalty tier set by marketing, to segment stats by
+	if v := os.Getenv("ACTIVITY_TAG_COOKIES"); v != "" {
+		var names []string
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if err := activitylog.ValidateTagName(name); err != nil {
+				log.Warnf("ignoring ACTIVITY_TAG_COOKIES entry: %v", err)
+				continue
+			}
+			names = append(names, name)
+		}
+		activityOpts = append(activityOpts, activitylog.WithTagCookies(names...))
+	}
+	// In strict mode a change that can't be recorded isn't made
+	if os.Getenv("ACTIVITY_STRICT") == "true" {
+		activityOpts = append(activityOpts, activitylog.WithStrictWrites())
+		log.Info("activity logging is strict: requests that change state fail when their activity can't be logged")
+	}
+	// Tell in every response which activity it was logged as, to look up
+	// when a shopper reports a problem
+	if os.Getenv("ACTIVITY_EXPOSE_ID") == "true" {
+		activityOpts = append(activityOpts, activitylog.WithExposedIDs())
+	}
+	// Throttle sessions requesting faster than any shopper could, such as
+	// scrapers. Off unless a limit is set.
+	if v := os.Getenv("ACTIVITY_SESSION_RATE_LIMIT"); v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n <= 0 {
+			log.Warnf("ignoring invalid ACTIVITY_SESSION_RATE_LIMIT %q", v)
+		} else {
+			exempt := []string{baseUrl + "/static/", baseUrl + "/_healthz", baseUrl + "/robots.txt"}
+			if v := os.Getenv("ACTIVITY_SESSION_RATE_LIMIT_EXEMPT"); v != "" {
+				exempt = nil
+				for _, prefix := range strings.Split(v, ",") {
+					if prefix = strings.TrimSpace(prefix); prefix != "" {
+						exempt = append(exempt, baseUrl+prefix)
+					}
+				}
+			}
+			activityOpts = append(activityOpts, activitylog.WithSessionRateLimiter(activitylog.NewSessionRateLimiter(n, exempt...)))
+			log.WithField("exempt", exempt).Infof("throttling sessions making over %d requests per minute", n)
 		}
-	}).Methods(http.MethodGet)
+	}
+	activityMiddleware := func(next http.Handler) http.Handler {
+		return activitylog.NewActivityMiddleware(log, next, activityOpts...)
+	}
+	r.Use(activityMiddleware)
+	// mux doesn't run middleware for requests no route matches
+	r.NotFoundHandler = activityMiddleware(http.NotFoundHandler())
 
 	var handler http.Handler = r
+	if origins := parseCORSOrigins(os.Getenv("ACTIVITY_CORS_ORIGINS")); len(origins) > 0 {
+		handler = withActivityCORS(handler, origins) // let other origins read activities
+		log.WithField("origins", os.Getenv("ACTIVITY_CORS_ORIGINS")).Info("allowing cross-origin requests to the activity endpoints")
+	}
+	handler = withFlash(handler)                       // show flash messages once
+	if v := os.Getenv("ACTIVITY_SHED_HIGH_WATER"); v != "" {
+		highWater, err := strconv.Atoi(v)
+		if err != nil || highWater <= 0 {
+			log.Warnf("ignoring invalid ACTIVITY_SHED_HIGH_WATER %q", v)
+		} else {
+			lowWater, _ := strconv.Atoi(os.Getenv("ACTIVITY_SHED_LOW_WATER"))
+			retryAfter, _ := time.ParseDuration(os.Getenv("ACTIVITY_SHED_RETRY_AFTER"))
+			activityLoadShedder = newLoadShedder(highWater, lowWater, retryAfter)
+			handler = withLoadShedding(handler, activityLoadShedder) // make way for shoppers
+			log.Infof("refusing activity reads above %d requests in flight", highWater)
+		}
+	}
 	handler = &logHandler{log: log, next: handler}     // add logging
 	handler = ensureSessionID(handler)                 // add session ID
-	handler = activitylog.NewActivityMiddleware(log, handler) // add activity logging
 	handler = otelhttp.NewHandler(handler, "frontend") // add OTel tracing
 
+	// Other services follow the activity log over gRPC on a port of its own
+	if port := os.Getenv("ACTIVITY_GRPC_PORT"); port != "" {
+		var maxStreams int
+		if v := os.Getenv("ACTIVITY_GRPC_MAX_STREAMS"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				log.Warnf("ignoring invalid ACTIVITY_GRPC_MAX_STREAMS %q: %v", v, err)
+			} else {
+				maxStreams = n
+			}
+		}
+		go serveActivityRPC(log, addr+":"+port, newActivityRPCServer(maxStreams))
+	}
+
 	log.Infof("starting server on " + addr + ":" + srvPort)
 	log.Fatal(http.ListenAndServe(addr+":"+srvPort, handler))
 }
+
+// registerRoutes registers the frontend's routes on r, and the features
+// of the activity API they serve. Every route needs an activity type in
+// the classification table of routes_test.go.
+func (fe *frontendServer) registerRoutes(r *mux.Router, log logrus.FieldLogger) {
+	r.HandleFunc(baseUrl + "/", fe.homeHandler).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc(baseUrl + "/product/{id}", fe.productHandler).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc(baseUrl + "/cart", fe.viewCartHandler).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc(baseUrl + "/cart", fe.addToCartHandler).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/cart/empty", fe.emptyCartHandler).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/setCurrency", fe.setCurrencyHandler).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/login", fe.loginHandler).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/logout", fe.logoutHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/cart/checkout", fe.placeOrderHandler).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/assistant", fe.assistantHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/my-activity", fe.myActivityHandler).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc(baseUrl + "/my-activity/tracking", fe.setTrackingHandler).Methods(http.MethodPost)
+	r.PathPrefix(baseUrl + "/static/").Handler(http.StripPrefix(baseUrl + "/static/", http.FileServer(http.Dir("./static/"))))
+	r.HandleFunc(baseUrl + "/robots.txt", func(w http.ResponseWriter, _ *http.Request) { fmt.Fprint(w, "User-agent: *\nDisallow: /") })
+	r.HandleFunc(baseUrl + "/_healthz", healthHandler)
+	r.HandleFunc(baseUrl + "/product-meta/{ids}", fe.getProductByID).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/bot", fe.chatBotHandler).Methods(http.MethodPost)
+
+	// Activity logging endpoints
+	// Endpoints register the features they serve, advertised by
+	// /activities/version
+	r.HandleFunc(baseUrl + "/activities", fe.listActivitiesHandler).Methods(http.MethodGet)
+	activitylog.RegisterFeature(activitylog.FeatureFiltersV2, activitylog.FeatureResponseClass, activitylog.FeatureTypedDetails, activitylog.FeatureDetailFilters, activitylog.FeatureCursor)
+	r.HandleFunc(baseUrl + "/activities/session", fe.sessionActivitiesHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/device", fe.deviceHistoryHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/{id:[0-9]+}", requireActivityAdmin(fe.activityByIDHandler)).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/by-request/{request_id}", requireActivityAdmin(fe.activityByRequestHandler)).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/sessions", fe.sessionSummariesHandler).Methods(http.MethodGet)
+	activitylog.RegisterFeature(activitylog.FeatureSessionSummaries)
+	r.HandleFunc(baseUrl + "/activities/session/clear", refuseWhenReadOnly(fe.clearSessionActivitiesHandler)).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/activities/stream", fe.streamActivitiesHandler).Methods(http.MethodGet)
+	activitylog.RegisterFeature(activitylog.FeatureSSE)
+	r.HandleFunc(baseUrl + "/activities/poll", fe.pollActivitiesHandler).Methods(http.MethodGet)
+	activitylog.RegisterFeature(activitylog.FeatureLongPoll)
+	r.HandleFunc(baseUrl + "/activities/export", requireActivityAdmin(fe.exportActivitiesHandler)).Methods(http.MethodGet)
+	activitylog.RegisterFeature(activitylog.FeatureExport)
+	for _, e := range fe.statsEndpoints() {
+		r.HandleFunc(baseUrl+e.Path, e.handler).Methods(http.MethodGet)
+	}
+	activitylog.RegisterFeature(activitylog.FeatureGroupBy, activitylog.FeatureStatsAsOf)
+	r.HandleFunc(baseUrl + "/activities/currencies", fe.observedCurrenciesHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/meta", fe.activityMetaHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/version", fe.activityVersionHandler).Methods(http.MethodGet)
+	if clientEvents {
+		r.HandleFunc(baseUrl + activitylog.IngestPath, fe.ingestActivityHandler).Methods(http.MethodPost)
+		activitylog.RegisterFeature(activitylog.FeatureClientEvents)
+	}
+	r.HandleFunc(baseUrl + "/activities/report", requireActivityAdmin(fe.weeklyReportHandler)).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/dashboard", fe.activityDashboardHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/rollups/rebuild", requireActivityAdmin(refuseWhenReadOnly(fe.rebuildRollupsHandler))).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/activities/sessions/counters/rebuild", requireActivityAdmin(refuseWhenReadOnly(fe.rebuildSessionCountersHandler))).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/activities/purge", requireActivityAdmin(refuseWhenReadOnly(fe.purgeActivitiesHandler))).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/activities/admin/audit", requireActivityAdmin(fe.listAuditHandler)).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/admin/readonly", requireActivityAdmin(fe.setReadOnlyHandler)).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/activities/backfill/products", requireActivityAdmin(refuseWhenReadOnly(fe.backfillProductsHandler))).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/activities/backfill/quantities", requireActivityAdmin(refuseWhenReadOnly(fe.backfillQuantitiesHandler))).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/activities/archives/upload", requireActivityAdmin(fe.uploadArchiveHandler)).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/activities/archives/status", fe.archiveStatusHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/outbox/status", fe.outboxStatusHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/flagsets", fe.flagSetsHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/db/plans", requireActivityAdmin(fe.queryPlansHandler)).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/db/stats", requireActivityAdmin(fe.dbStatsHandler)).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/debug/query", requireActivityAdmin(fe.debugQueryHandler)).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/db/verify", requireActivityAdmin(refuseWhenReadOnly(fe.verifyDBHandler))).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl + "/activities/db/snapshot", requireActivityAdmin(fe.snapshotDBHandler)).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/alerts", fe.listAlertsHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/alerts/{id:[0-9]+}/ack", requireActivityAdmin(refuseWhenReadOnly(fe.acknowledgeAlertHandler))).Methods(http.MethodPost)
+	// Metrics include the command line and memory stats, for admins only
+	r.HandleFunc(baseUrl + "/debug/vars", requireActivityAdmin(expvar.Handler().ServeHTTP)).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl + "/activities/view", fe.activitiesViewHandler).Methods(http.MethodGet)
+	if activityTestEndpointsEnabled(log) {
+		fe.registerActivityTestEndpoints(r)
+	}
+}
+
+// configureActivityLimits applies the optional ACTIVITY_* limits and
+// settings of the activity store. A value that doesn't parse is logged and
+// leaves its default.
+func configureActivityLimits(log logrus.FieldLogger) {
+	var limit int
+	var wait time.Duration
+	if v := os.Getenv("ACTIVITY_ANALYTICAL_QUERY_LIMIT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_ANALYTICAL_QUERY_LIMIT %q: %v", v, err)
+		} else {
+			limit = n
+		}
+	}
+	if v := os.Getenv("ACTIVITY_ANALYTICAL_QUERY_WAIT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_ANALYTICAL_QUERY_WAIT %q: %v", v, err)
+		} else {
+			wait = d
+		}
+	}
+	activitylog.ConfigureAnalyticalQueries(limit, wait)
+
+	var threshold int
+	var cooldown time.Duration
+	if v := os.Getenv("ACTIVITY_WRITE_BREAKER_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_WRITE_BREAKER_THRESHOLD %q: %v", v, err)
+		} else {
+			threshold = n
+		}
+	}
+	if v := os.Getenv("ACTIVITY_WRITE_BREAKER_COOLDOWN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_WRITE_BREAKER_COOLDOWN %q: %v", v, err)
+		} else {
+			cooldown = d
+		}
+	}
+	activitylog.ConfigureWriteBreaker(threshold, cooldown)
+
+	var highWater int
+	var sustain time.Duration
+	if v := os.Getenv("ACTIVITY_OVERLOAD_HIGH_WATER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_OVERLOAD_HIGH_WATER %q: %v", v, err)
+		} else {
+			highWater = n
+		}
+	}
+	if v := os.Getenv("ACTIVITY_OVERLOAD_SUSTAIN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_OVERLOAD_SUSTAIN %q: %v", v, err)
+		} else {
+			sustain = d
+		}
+	}
+	activitylog.ConfigureOverload(highWater, sustain)
+
+	var clientEventsPerMinute int
+	if v := os.Getenv("ACTIVITY_CLIENT_EVENTS_PER_MINUTE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_CLIENT_EVENTS_PER_MINUTE %q: %v", v, err)
+		} else {
+			clientEventsPerMinute = n
+		}
+	}
+	activitylog.ConfigureClientEvents(clientEventsPerMinute)
+
+	var unclassifiedWarning float64
+	if v := os.Getenv("ACTIVITY_UNCLASSIFIED_WARNING"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_UNCLASSIFIED_WARNING %q: %v", v, err)
+		} else {
+			unclassifiedWarning = f
+		}
+	}
+	activitylog.ConfigureUnclassifiedWarning(unclassifiedWarning)
+
+	if v := os.Getenv("ACTIVITY_SLOW_QUERY_THRESHOLD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_SLOW_QUERY_THRESHOLD %q: %v", v, err)
+		} else {
+			activitylog.ConfigureSlowQueries(d)
+		}
+	}
+
+	if v := os.Getenv("ACTIVITY_DELETED_SESSION_GRACE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_DELETED_SESSION_GRACE %q: %v", v, err)
+		} else {
+			activitylog.ConfigureDeletedSessionGrace(d)
+		}
+	}
+
+	if v := os.Getenv("ACTIVITY_RETENTION_MONTHS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_RETENTION_MONTHS %q: %v", v, err)
+		} else {
+			activitylog.ConfigurePartitionRetention(n)
+		}
+	}
+
+	if v := os.Getenv("ACTIVITY_RETENTION"); v != "" {
+		// Activities are kept rather than expired by a typo
+		if ttls, err := activitylog.ParseRetention(v); err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_RETENTION %q, keeping every activity: %v", v, err)
+		} else {
+			activitylog.ConfigureRetention(ttls)
+		}
+	}
+
+	if v := os.Getenv("ACTIVITY_MAX_ROWS_PER_SESSION"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_MAX_ROWS_PER_SESSION %q: %v", v, err)
+		} else {
+			mode := os.Getenv("ACTIVITY_SESSION_QUOTA_MODE")
+			if mode != "" && mode != activitylog.QuotaDrop && mode != activitylog.QuotaAggregate {
+				log.Warnf("ignoring unknown ACTIVITY_SESSION_QUOTA_MODE %q", mode)
+			}
+			activitylog.ConfigureSessionQuota(n, mode)
+		}
+	}
+
+	if v := os.Getenv("ACTIVITY_CANARY_MIN_SAMPLE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_CANARY_MIN_SAMPLE %q: %v", v, err)
+		} else {
+			activitylog.ConfigureCanaryMinSample(n)
+		}
+	}
+
+	if codec := os.Getenv("ACTIVITY_DETAILS_CODEC"); codec != "" {
+		var threshold int
+		if v := os.Getenv("ACTIVITY_DETAILS_CODEC_THRESHOLD"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				log.Warnf("ignoring invalid ACTIVITY_DETAILS_CODEC_THRESHOLD %q: %v", v, err)
+			} else {
+				threshold = n
+			}
+		}
+		if err := activitylog.ConfigureDetailsCodec(codec, threshold); err != nil {
+			log.Warnf("ignoring ACTIVITY_DETAILS_CODEC: %v", err)
+		}
+	}
+
+	if v := os.Getenv("ACTIVITY_MAX_QUANTITY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_MAX_QUANTITY %q: %v", v, err)
+		} else {
+			activitylog.ConfigureMaxQuantity(n)
+		}
+	}
+
+	if v := os.Getenv("ACTIVITY_WAL_CHECKPOINT_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_WAL_CHECKPOINT_BYTES %q: %v", v, err)
+		} else {
+			activitylog.ConfigureWALCheckpoint(n, 0)
+		}
+	}
+}
+
+// anomalyConfig reads the optional ACTIVITY_ANOMALY_WINDOW,
+// ACTIVITY_ANOMALY_WARMUP, ACTIVITY_ANOMALY_MULTIPLIER and
+// ACTIVITY_ANOMALY_MIN_COUNT settings of the traffic anomaly detector.
+func anomalyConfig(log logrus.FieldLogger) activitylog.AnomalyConfig {
+	var cfg activitylog.AnomalyConfig
+	if v := os.Getenv("ACTIVITY_ANOMALY_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_ANOMALY_WINDOW %q: %v", v, err)
+		} else {
+			cfg.Window = d
+		}
+	}
+	if v := os.Getenv("ACTIVITY_ANOMALY_WARMUP"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_ANOMALY_WARMUP %q: %v", v, err)
+		} else {
+			cfg.Warmup = d
+		}
+	}
+	if v := os.Getenv("ACTIVITY_ANOMALY_MULTIPLIER"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_ANOMALY_MULTIPLIER %q: %v", v, err)
+		} else {
+			cfg.Multiplier = f
+		}
+	}
+	if v := os.Getenv("ACTIVITY_ANOMALY_MIN_COUNT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_ANOMALY_MIN_COUNT %q: %v", v, err)
+		} else {
+			cfg.MinCount = n
+		}
+	}
+	return cfg
+}
+
+// alertNotifierConfig reads the optional ACTIVITY_ALERT_FORMAT,
+// ACTIVITY_ALERT_ERROR_RATE, ACTIVITY_ALERT_CHECKOUT_FAILURES and
+// ACTIVITY_ALERT_COOLDOWN settings of the alerts posted to url.
+func alertNotifierConfig(log logrus.FieldLogger, url string) activitylog.AlertNotifierConfig {
+	cfg := activitylog.AlertNotifierConfig{URL: url}
+	if v := os.Getenv("ACTIVITY_ALERT_FORMAT"); v != "" {
+		if err := activitylog.ValidateAlertFormat(v); err != nil {
+			log.Warnf("ignoring ACTIVITY_ALERT_FORMAT: %v", err)
+		} else {
+			cfg.Format = v
+		}
+	}
+	if v := os.Getenv("ACTIVITY_ALERT_ERROR_RATE"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_ALERT_ERROR_RATE %q: %v", v, err)
+		} else {
+			cfg.ErrorRate = f
+		}
+	}
+	if v := os.Getenv("ACTIVITY_ALERT_CHECKOUT_FAILURES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_ALERT_CHECKOUT_FAILURES %q: %v", v, err)
+		} else {
+			cfg.CheckoutFailures = n
+		}
+	}
+	if v := os.Getenv("ACTIVITY_ALERT_COOLDOWN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Warnf("ignoring invalid ACTIVITY_ALERT_COOLDOWN %q: %v", v, err)
+		} else {
+			cfg.Cooldown = d
+		}
+	}
+	return cfg
+}
+
+// healthHandler reports the frontend as healthy. Activity logging isn't
+// needed to serve shoppers, so a suspended or degraded activity log is
+// reported but doesn't fail the check.
+func healthHandler(w http.ResponseWriter, _ *http.Request) {
+	fmt.Fprint(w, "ok")
+	if state := activitylog.WriteBreakerState(); state != activitylog.BreakerClosed {
+		fmt.Fprintf(w, "\nactivity log writes: %s", state)
+	}
+	if mode := activitylog.CurrentLoggingMode(); mode != activitylog.LoggingFull {
+		fmt.Fprintf(w, "\nactivity logging: %s", mode)
+	}
+	if activityLoadShedder != nil && activityLoadShedder.Shedding() {
+		fmt.Fprintf(w, "\nactivity API: refusing reads, %d requests in flight", activityLoadShedder.inFlight.Load())
+	}
+	if activitylog.ReadOnly() {
+		fmt.Fprintf(w, "\nactivity log: read-only, %d activities skipped", activitylog.ReadOnlySkipped())
+	}
+	if lag := activitylog.PipelineLatencyMax(); lag > activitylog.PipelineLagWarning {
+		fmt.Fprintf(w, "\nactivity persistence lagging: activities took up to %s to be written", lag.Round(time.Millisecond))
+	}
+	if check, ok := activitylog.LastIntegrityCheck(); ok && !check.OK {
+		fmt.Fprintf(w, "\nactivity database: corrupt at %s, recovered: %t", check.At.Format(time.RFC3339), check.Recovered)
+	}
+}
+
 func initStats(log logrus.FieldLogger) {
 	// TODO(arbrown) Implement OpenTelemtry stats
 }
@@ -17,18 +17,25 @@ package main
 import (
 	"context"
 	"net/http"
+	"net/url"
 	"time"
 	"os"
 
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
-// CtxKeyLog is the type for logging context keys
-type CtxKeyLog struct{}
+// ctxKeyLog is shared with the activity log, which adds the request's
+// fields to the lines it logs on its behalf.
+type ctxKeyLog = activitylog.CtxKeyLog
 
-// CtxKeyRequestID is the type for request ID context keys
-type CtxKeyRequestID struct{}
+// ctxKeyFlash holds the flash message to show on the page being rendered
+type ctxKeyFlash struct{}
+
+// ctxKeyRequestID is shared with the activity logging middleware, which reads
+// the request ID to correlate activities with request logs.
+type ctxKeyRequestID = activitylog.CtxKeyRequestID
 
 type logHandler struct {
 	log  *logrus.Logger
@@ -52,6 +59,10 @@ func (r *responseRecorder) Write(p []byte) (int, error) {
 	return n, err
 }
 
+// Unwrap exposes the underlying writer to http.ResponseController so that
+// streamed responses can be flushed.
+func (r *responseRecorder) Unwrap() http.ResponseWriter { return r.w }
+
 func (r *responseRecorder) WriteHeader(statusCode int) {
 	r.status = statusCode
 	r.w.WriteHeader(statusCode)
@@ -89,7 +100,8 @@ func ensureSessionID(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var sessionID string
 		c, err := r.Cookie(cookieSessionID)
-		if err == http.ErrNoCookie {
+		newSession := err == http.ErrNoCookie
+		if newSession {
 			if os.Getenv("ENABLE_SINGLE_SHARED_SESSION") == "true" {
 				// Hard coded user id, shared across sessions
 				sessionID = "12345678-1234-1234-1234-123456789123"
@@ -108,7 +120,44 @@ func ensureSessionID(next http.Handler) http.HandlerFunc {
 			sessionID = c.Value
 		}
 		ctx := context.WithValue(r.Context(), ctxKeySessionID{}, sessionID)
+		if newSession {
+			ctx = context.WithValue(ctx, ctxKeyNewSession{}, true)
+		}
+		// The cookie is signed at sign-in, anything else is ignored
+		if c, err := r.Cookie(cookieUserID); err == nil {
+			if userID, ok := verifyUserID(c.Value); ok && userIDFormat.MatchString(userID) {
+				ctx = context.WithValue(ctx, ctxKeyUserID{}, userID)
+			}
+		}
+		if deviceID := ensureDeviceID(w, r); deviceID != "" {
+			ctx = context.WithValue(ctx, ctxKeyDeviceID{}, deviceID)
+		}
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 	}
 }
+
+// setFlash leaves a message for the next page the shopper sees, typically
+// the one a form redirects to.
+func setFlash(w http.ResponseWriter, message string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   cookieFlash,
+		Value:  url.QueryEscape(message),
+		Path:   baseUrl + "/",
+		MaxAge: 60,
+	})
+}
+
+// withFlash hands the flash message left by setFlash to the page being
+// rendered and clears it, so that it is shown once.
+func withFlash(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(cookieFlash); err == nil {
+			if message, err := url.QueryUnescape(c.Value); err == nil && message != "" {
+				r = r.WithContext(context.WithValue(r.Context(), ctxKeyFlash{}, message))
+			}
+			http.SetCookie(w, &http.Cookie{Name: cookieFlash, Path: baseUrl + "/", MaxAge: -1})
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+const (
+	// myActivityProducts is how many viewed products the insights page lists
+	myActivityProducts = 10
+	// myActivityTimeline is how many of the latest activities it shows
+	myActivityTimeline = 15
+	// consentMaxAge is how long a shopper's tracking choice is remembered
+	consentMaxAge = 365 * 24 * time.Hour
+)
+
+// viewedProduct is a product the shopper viewed, as the insights page lists
+// it. Name is the product ID when the catalog couldn't be reached.
+type viewedProduct struct {
+	ID         string
+	Name       string
+	Picture    string
+	Views      int
+	LastViewed time.Time
+}
+
+// categoryCount is the number of viewed products of a category
+type categoryCount struct {
+	Name     string
+	Products int
+}
+
+// myActivityHandler shows shoppers what was logged about their own session.
+// It reads nothing but the requester's session, so it needs no admin token.
+// Shoppers who opted out of tracking, and everyone when all shoppers share
+// one session, get an explanation instead.
+func (fe *frontendServer) myActivityHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	data := map[string]interface{}{
+		"tracking":              activitylog.TrackingAllowed(r),
+		"single_shared_session": os.Getenv("ENABLE_SINGLE_SHARED_SESSION") == "true",
+	}
+	if data["tracking"].(bool) && !data["single_shared_session"].(bool) {
+		if err := fe.myActivityData(r, data); err != nil {
+			renderHTTPError(log, r, w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := executeTemplate(w, r, "my_activity", injectCommonTemplateData(r, data)); err != nil {
+		log.Error(err)
+	}
+}
+
+// myActivityData adds the stats of the requester's session to data
+func (fe *frontendServer) myActivityData(r *http.Request, data map[string]interface{}) error {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	id := sessionID(r)
+	summary, err := activitylog.GetSessionSummary(id)
+	if errors.Is(err, activitylog.ErrSessionNotFound) {
+		// Nothing logged yet, or the history was erased
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to get the session summary")
+	}
+	views, err := activitylog.RecentProductViews(id, myActivityProducts)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the viewed products")
+	}
+	timeline, err := activitylog.GetActivitiesBySession(id, myActivityTimeline)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the session activities")
+	}
+
+	products := make([]viewedProduct, 0, len(views))
+	categories := map[string]int{}
+	for _, v := range views {
+		p := viewedProduct{ID: v.ProductID, Name: v.ProductID, Views: v.Views, LastViewed: v.LastViewed}
+		product, err := fe.getProduct(r.Context(), v.ProductID)
+		if err != nil {
+			// The page is still useful with product IDs
+			log.WithField("error", err).WithField("product_id", v.ProductID).Warn("failed to look up a viewed product")
+		} else {
+			p.Name, p.Picture = product.GetName(), product.GetPicture()
+			for _, c := range product.GetCategories() {
+				categories[c]++
+			}
+		}
+		products = append(products, p)
+	}
+
+	data["summary"] = summary
+	data["time_spent"] = summary.LastSeen.Sub(summary.FirstSeen).Seconds()
+	data["viewed_products"] = products
+	data["categories"] = sortedCategories(categories)
+	data["timeline"] = timeline
+	return nil
+}
+
+// sortedCategories lists categories by how many products of them were
+// viewed, most first
+func sortedCategories(counts map[string]int) []categoryCount {
+	categories := make([]categoryCount, 0, len(counts))
+	for name, n := range counts {
+		categories = append(categories, categoryCount{Name: name, Products: n})
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Products != categories[j].Products {
+			return categories[i].Products > categories[j].Products
+		}
+		return categories[i].Name < categories[j].Name
+	})
+	return categories
+}
+
+// setTrackingHandler records whether shoppers agree to their activities
+// being logged, in the consent cookie, and sends them back to their
+// insights page
+func (fe *frontendServer) setTrackingHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	if !validCSRFToken(r) {
+		renderHTTPError(log, r, w, errors.New("missing or invalid CSRF token"), http.StatusForbidden)
+		return
+	}
+	value, message := "on", "Your activity on this shop is being recorded again."
+	if r.PostFormValue("tracking") == activitylog.ConsentDeclined {
+		value, message = activitylog.ConsentDeclined, "Your activity on this shop is no longer recorded."
+		// The cookie only comes with the next request
+		activitylog.SkipActivity(r.Context())
+		// Nor are the shopper's later visits linked to this one
+		forgetDeviceID(w)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   activitylog.ConsentCookie,
+		Value:  value,
+		Path:   baseUrl + "/",
+		MaxAge: int(consentMaxAge.Seconds()),
+	})
+	log.WithField("tracking", value).Info("set tracking consent")
+	setFlash(w, message)
+	w.Header().Set("Location", baseUrl+"/my-activity")
+	w.WriteHeader(http.StatusFound)
+}
@@ -0,0 +1,127 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+func myActivityPage(t *testing.T, fe *frontendServer, session string, cookies ...*http.Cookie) string {
+	t.Helper()
+	req := sessionRequest("/my-activity", session, nil)
+	req.Method = http.MethodGet
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	fe.myActivityHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /my-activity = %d, want 200: %s", w.Code, w.Body)
+	}
+	return w.Body.String()
+}
+
+func TestMyActivityShowsOwnSession(t *testing.T) {
+	emptyActivityLog(t)
+	for _, a := range []activitylog.ActivityLog{
+		{SessionID: "mine", ActivityType: activitylog.ActivityTypePageView, Path: "/"},
+		{SessionID: "mine", ActivityType: activitylog.ActivityTypeProductView, Path: "/product/OLJCESPC7Z", ProductID: "OLJCESPC7Z"},
+		{SessionID: "mine", ActivityType: activitylog.ActivityTypeAddToCart, Path: "/cart", ProductID: "OLJCESPC7Z"},
+		{SessionID: "theirs", ActivityType: activitylog.ActivityTypeProductView, Path: "/product/66VCHSJNUP", ProductID: "66VCHSJNUP"},
+	} {
+		if err := activitylog.LogActivity(&a); err != nil {
+			t.Fatalf("LogActivity() failed: %v", err)
+		}
+	}
+	// Nothing listens there, so products are listed by ID
+	conn, err := grpc.NewClient("passthrough:///127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	page := myActivityPage(t, &frontendServer{productCatalogSvcConn: conn}, "mine")
+	for _, want := range []string{"Your activity", "OLJCESPC7Z", "/cart", "Erase my history", `action="/activities/session/clear"`, csrfToken("mine")} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page doesn't show %q", want)
+		}
+	}
+	if strings.Contains(page, "66VCHSJNUP") {
+		t.Error("page shows another session's product views")
+	}
+}
+
+func TestMyActivityWithoutActivities(t *testing.T) {
+	emptyActivityLog(t)
+	page := myActivityPage(t, &frontendServer{}, "new")
+	if !strings.Contains(page, "No activity yet") {
+		t.Error("page doesn't say the session has no activity")
+	}
+}
+
+func TestMyActivityRespectsConsent(t *testing.T) {
+	emptyActivityLog(t)
+	if err := activitylog.LogActivity(&activitylog.ActivityLog{SessionID: "mine", ActivityType: activitylog.ActivityTypePageView, Path: "/"}); err != nil {
+		t.Fatalf("LogActivity() failed: %v", err)
+	}
+	page := myActivityPage(t, &frontendServer{}, "mine",
+		&http.Cookie{Name: activitylog.ConsentCookie, Value: activitylog.ConsentDeclined})
+	if !strings.Contains(page, "isn't being recorded") {
+		t.Error("page doesn't explain that tracking is off")
+	}
+	if strings.Contains(page, "Timeline") {
+		t.Error("page shows activities while tracking is off")
+	}
+}
+
+func TestSetTracking(t *testing.T) {
+	for _, tc := range []struct {
+		tracking, want string
+	}{
+		{activitylog.ConsentDeclined, activitylog.ConsentDeclined},
+		{"on", "on"},
+	} {
+		form := url.Values{"csrf_token": {csrfToken("mine")}, "tracking": {tc.tracking}}
+		w := httptest.NewRecorder()
+		(&frontendServer{}).setTrackingHandler(w, sessionRequest("/my-activity/tracking", "mine", form))
+		if w.Code != http.StatusFound {
+			t.Fatalf("tracking=%s: status = %d, want 302", tc.tracking, w.Code)
+		}
+		var got string
+		for _, c := range w.Result().Cookies() {
+			if c.Name == activitylog.ConsentCookie {
+				got = c.Value
+			}
+		}
+		if got != tc.want {
+			t.Errorf("tracking=%s: consent cookie = %q, want %q", tc.tracking, got, tc.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	(&frontendServer{}).setTrackingHandler(w, sessionRequest("/my-activity/tracking", "mine", url.Values{"tracking": {"off"}}))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("without a CSRF token: status = %d, want 403", w.Code)
+	}
+}
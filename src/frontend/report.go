@@ -0,0 +1,318 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+// Formats the weekly report is rendered in
+const (
+	reportFormatHTML = "html"
+	reportFormatJSON = "json"
+)
+
+var reportFormats = []string{reportFormatHTML, reportFormatJSON}
+
+const (
+	// reportTopProducts is how many products the weekly report lists
+	reportTopProducts = 10
+	// reportCohortWeeks is how many weekly cohorts the report shows
+	reportCohortWeeks = 8
+	// reportMaxWeeksBack is how many weeks before the current one reports
+	// can be asked for, which bounds the reports cached
+	reportMaxWeeksBack = 52
+	// reportCacheDir is where the rendered reports are kept, next to the
+	// activity database
+	reportCacheDir = "data/reports"
+)
+
+// isoWeekFormat is the format of the week parameter, such as 2025-W23
+var isoWeekFormat = regexp.MustCompile(`^(\d{4})-W(\d{2})$`)
+
+// weeklyReport is the weekly report, in the order the HTML renders it
+type weeklyReport struct {
+	// Week is the ISO week covered, from Start, a Monday at 00:00 UTC, to
+	// End, the next Monday
+	Week        string                        `json:"week"`
+	Start       time.Time                     `json:"start"`
+	End         time.Time                     `json:"end"`
+	GeneratedAt time.Time                     `json:"generated_at"`
+	Funnel      []activitylog.FunnelStep      `json:"funnel"`
+	TopProducts []activitylog.ProductActivity `json:"top_products"`
+	Revenue     []activitylog.CurrencyRevenue `json:"revenue"`
+	ErrorRates  []activitylog.ErrorRate       `json:"error_rates"`
+	// Cohorts are the weekly cohorts as of End, up to the week of the
+	// report
+	Cohorts *activitylog.CohortReport `json:"cohorts"`
+}
+
+// parseISOWeek returns the Monday, 00:00 UTC, starting an ISO week given
+// as 2025-W23
+func parseISOWeek(s string) (time.Time, error) {
+	m := isoWeekFormat.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}, errors.Errorf("invalid week %q, must look like 2025-W23", s)
+	}
+	year, _ := strconv.Atoi(m[1])
+	week, _ := strconv.Atoi(m[2])
+	// January 4th is always in the first week
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	start := weekStart(jan4).AddDate(0, 0, 7*(week-1))
+	if y, w := start.ISOWeek(); week < 1 || y != year || w != week {
+		return time.Time{}, errors.Errorf("%d has no week %d", year, week)
+	}
+	return start, nil
+}
+
+// weekStart returns the Monday, 00:00 UTC, starting the week t is in
+func weekStart(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// isoWeek names the ISO week t is in
+func isoWeek(t time.Time) string {
+	year, week := t.UTC().ISOWeek()
+	return fmt.Sprintf("%04d-W%02d", year, week)
+}
+
+// buildWeeklyReport gathers the stats of the week starting at start. The
+// report is only useful whole, so any failing stat fails it.
+func buildWeeklyReport(ctx context.Context, start time.Time) (*weeklyReport, error) {
+	end := start.AddDate(0, 0, 7)
+	rep := &weeklyReport{
+		Week:        isoWeek(start),
+		Start:       start,
+		End:         end,
+		GeneratedAt: activitylog.Now().UTC(),
+	}
+	var err error
+	if rep.Funnel, err = activitylog.GetFunnel(ctx, start, end, activitylog.DefaultFunnel); err != nil {
+		return nil, errors.Wrap(err, "failed to get the funnel")
+	}
+	if rep.TopProducts, err = activitylog.GetTopProducts(ctx, start, end, reportTopProducts); err != nil {
+		return nil, errors.Wrap(err, "failed to get the top products")
+	}
+	if rep.Revenue, err = activitylog.GetRevenueByCurrency(ctx, start, end); err != nil {
+		return nil, errors.Wrap(err, "failed to get the revenue")
+	}
+	if rep.ErrorRates, err = activitylog.GetErrorRates(ctx, start, end); err != nil {
+		return nil, errors.Wrap(err, "failed to get the error rates")
+	}
+	if rep.Cohorts, err = activitylog.GetCohortReportAsOf(ctx, reportCohortWeeks, end); err != nil {
+		return nil, errors.Wrap(err, "failed to get the cohorts")
+	}
+	return rep, nil
+}
+
+// renderWeeklyReport writes rep in format: a standalone HTML page, with its
+// styles inline and no external assets so that it can be mailed or archived
+// as is, or JSON. Anything sending the report, such as a summary email,
+// renders it here so that it reads the same everywhere.
+func renderWeeklyReport(w io.Writer, rep *weeklyReport, format string) error {
+	switch format {
+	case reportFormatHTML:
+		return templates.ExecuteTemplate(w, "report", rep)
+	case reportFormatJSON:
+		return json.NewEncoder(w).Encode(rep)
+	}
+	return errors.Errorf("unknown report format %q, must be html or json", format)
+}
+
+// generateWeeklyReport builds the report of the week starting at start and
+// renders it in every format
+func generateWeeklyReport(ctx context.Context, start time.Time) (map[string][]byte, error) {
+	rep, err := buildWeeklyReport(ctx, start)
+	if err != nil {
+		return nil, err
+	}
+	rendered := make(map[string][]byte, len(reportFormats))
+	for _, format := range reportFormats {
+		var buf bytes.Buffer
+		if err := renderWeeklyReport(&buf, rep, format); err != nil {
+			return nil, errors.Wrapf(err, "failed to render the %s report", format)
+		}
+		rendered[format] = buf.Bytes()
+	}
+	return rendered, nil
+}
+
+// reportCache keeps the rendered weekly reports on disk. A nil cache keeps
+// nothing.
+type reportCache struct {
+	dir string
+}
+
+func (c *reportCache) path(week, format string) string {
+	return filepath.Join(c.dir, week+"."+format)
+}
+
+// get returns the report of week in format, unless it isn't cached or was
+// generated before the week ended at end and the week is now over. The
+// current week's report is refreshed nightly instead.
+func (c *reportCache) get(week, format string, end time.Time) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	path := c.path(week, format)
+	info, err := os.Stat(path)
+	if err != nil || (!activitylog.Now().Before(end) && info.ModTime().Before(end)) {
+		return nil, false
+	}
+	body, err := os.ReadFile(path)
+	return body, err == nil
+}
+
+// put caches the renderings of the report of week. Each file is written
+// to a temporary file of its own and renamed into place, so that readers
+// never see a partial report, even while requests and the nightly refresh
+// put the same week.
+func (c *reportCache) put(week string, rendered map[string][]byte) error {
+	if c == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	for format, body := range rendered {
+		f, err := os.CreateTemp(c.dir, week+"."+format+".*.tmp")
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(body)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), c.path(week, format))
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return err
+		}
+	}
+	return nil
+}
+
+// refresh generates and caches the report of the week starting at start
+func (c *reportCache) refresh(ctx context.Context, start time.Time) (map[string][]byte, error) {
+	rendered, err := generateWeeklyReport(ctx, start)
+	if err != nil {
+		return nil, err
+	}
+	return rendered, c.put(isoWeek(start), rendered)
+}
+
+// start generates the report of the current week right away
+// and then after every UTC midnight, so that requests for it are served from
+// the cache, until ctx is done. The report of the previous week is
+// generated one last time once it is over.
+func (c *reportCache) start(ctx context.Context, log logrus.FieldLogger) {
+	go func() {
+		for {
+			now := activitylog.Now()
+			current := weekStart(now)
+			previous := current.AddDate(0, 0, -7)
+			if _, ok := c.get(isoWeek(previous), reportFormatHTML, current); !ok {
+				if _, err := c.refresh(ctx, previous); err != nil {
+					log.WithError(err).Warn("failed to generate the report of last week")
+				}
+			}
+			if _, err := c.refresh(ctx, current); err != nil {
+				log.WithError(err).Warn("failed to generate the weekly report")
+			}
+
+			day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(day.AddDate(0, 0, 1).Sub(now)):
+			}
+		}
+	}()
+}
+
+// weeklyReportHandler serves the report of the ISO week given as week, the
+// current one by default and at most reportMaxWeeksBack before it, as HTML
+// or as JSON with format=json. Reports are served from the cache when it
+// has them.
+func (fe *frontendServer) weeklyReportHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = reportFormatHTML
+	}
+	if format != reportFormatHTML && format != reportFormatJSON {
+		renderJSONError(log, r, w, errors.Errorf("unknown format %q, must be html or json", format), http.StatusBadRequest)
+		return
+	}
+	now := activitylog.Now()
+	start := weekStart(now)
+	if v := q.Get("week"); v != "" {
+		var err error
+		if start, err = parseISOWeek(v); err != nil {
+			renderJSONError(log, r, w, err, http.StatusBadRequest)
+			return
+		}
+		if start.After(now) {
+			renderJSONError(log, r, w, errors.Errorf("week %s hasn't started", v), http.StatusBadRequest)
+			return
+		}
+		if start.Before(weekStart(now).AddDate(0, 0, -7*reportMaxWeeksBack)) {
+			renderJSONError(log, r, w, errors.Errorf("reports go back %d weeks, %s is older", reportMaxWeeksBack, v), http.StatusBadRequest)
+			return
+		}
+	}
+	week := isoWeek(start)
+
+	body, ok := fe.reports.get(week, format, start.AddDate(0, 0, 7))
+	if !ok {
+		rendered, err := fe.reports.refresh(r.Context(), start)
+		if rendered == nil {
+			renderActivityError(log, r, w, errors.Wrap(err, "failed to generate the weekly report"))
+			return
+		}
+		if err != nil {
+			log.WithError(err).Warn("failed to cache the weekly report")
+		}
+		body = rendered[format]
+	}
+
+	if format == reportFormatJSON {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"activity-report-%s.%s\"", week, format))
+	w.Write(body)
+}
@@ -0,0 +1,123 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+func TestParseISOWeek(t *testing.T) {
+	tests := []struct {
+		week string
+		want time.Time
+	}{
+		{"2025-W23", time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{"2025-W01", time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC)},
+		{"2020-W53", time.Date(2020, 12, 28, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseISOWeek(tt.week)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseISOWeek(%q) = %v, %v; want %v", tt.week, got, err, tt.want)
+		}
+		if isoWeek(got) != tt.week {
+			t.Errorf("isoWeek(%v) = %q, want %q", got, isoWeek(got), tt.week)
+		}
+	}
+	for _, week := range []string{"2025-23", "2025-W00", "2025-W53", "2025-W7"} {
+		if _, err := parseISOWeek(week); err == nil {
+			t.Errorf("parseISOWeek(%q) succeeded, want an error", week)
+		}
+	}
+}
+
+func TestWeeklyReportEndpoint(t *testing.T) {
+	emptyActivityLog(t)
+	for _, a := range []activitylog.ActivityLog{
+		{SessionID: "session-1", ActivityType: activitylog.ActivityTypeProductView, ProductID: "OLJCESPC7Z", StatusCode: 200},
+		{SessionID: "session-1", ActivityType: activitylog.ActivityTypeCheckout, StatusCode: 200,
+			Details: `{"order_total":42.5,"order_currency":"EUR"}`},
+		{SessionID: "session-2", ActivityType: activitylog.ActivityTypePageView, StatusCode: 500},
+	} {
+		if err := activitylog.LogActivity(&a); err != nil {
+			t.Fatalf("LogActivity() failed: %v", err)
+		}
+	}
+	dir := t.TempDir()
+	h := http.HandlerFunc((&frontendServer{reports: &reportCache{dir: dir}}).weeklyReportHandler)
+	log := logrus.New()
+	log.Out = io.Discard
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/activities/report"+query, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), ctxKeyLog{}, logrus.FieldLogger(log))))
+		return w
+	}
+	week := isoWeek(activitylog.Now())
+
+	w := get("?format=json&week=" + week)
+	var rep weeklyReport
+	if err := json.Unmarshal(w.Body.Bytes(), &rep); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("status = %d, body %s (%v); want the report", w.Code, w.Body, err)
+	}
+	if rep.Week != week || len(rep.TopProducts) != 1 || rep.TopProducts[0].ProductID != "OLJCESPC7Z" ||
+		len(rep.Revenue) != 1 || rep.Revenue[0].Currency != "EUR" || rep.Revenue[0].Revenue != 42.5 {
+		t.Errorf("report = %+v, want this week's product view and EUR order", rep)
+	}
+
+	w = get("")
+	html := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(html, "OLJCESPC7Z") || !strings.Contains(html, "42.50") {
+		t.Fatalf("status = %d, body %s; want the HTML report", w.Code, html)
+	}
+	for _, external := range []string{"<link", "<script", "src="} {
+		if strings.Contains(html, external) {
+			t.Errorf("HTML report contains %q, want no external assets", external)
+		}
+	}
+
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) != 0 {
+		t.Errorf("temporary files %v left in the cache", tmp)
+	}
+
+	// The current week's report is served from the cache until refreshed
+	if err := os.WriteFile(filepath.Join(dir, week+".html"), []byte("cached"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if body := get("").Body.String(); body != "cached" {
+		t.Errorf("body = %q, want the cached report", body)
+	}
+
+	next := isoWeek(activitylog.Now().AddDate(0, 0, 7))
+	tooOld := isoWeek(activitylog.Now().AddDate(0, 0, -7*(reportMaxWeeksBack+1)))
+	for _, query := range []string{"?format=pdf", "?week=last", "?week=" + next, "?week=" + tooOld} {
+		if w := get(query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, w.Code)
+		}
+	}
+}
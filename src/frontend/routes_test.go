@@ -0,0 +1,199 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+// routeClassifications is the activity type of every route of the
+// frontend, by method and path template. Routes registered without
+// methods are listed under ANY. A route added without an entry here fails
+// TestRouteClassifications, so that a new page is classified on purpose
+// rather than drifting into "other".
+var routeClassifications = map[string]string{
+	"GET /":                      activitylog.ActivityTypePageView,
+	"HEAD /":                     activitylog.ActivityTypePageView,
+	"GET /product/{id}":          activitylog.ActivityTypeProductView,
+	"HEAD /product/{id}":         activitylog.ActivityTypeProductView,
+	"GET /cart":                  activitylog.ActivityTypeViewCart,
+	"HEAD /cart":                 activitylog.ActivityTypeViewCart,
+	"POST /cart":                 activitylog.ActivityTypeAddToCart,
+	"POST /cart/empty":           activitylog.ActivityTypeEmptyCart,
+	"POST /cart/checkout":        activitylog.ActivityTypeCheckout,
+	"POST /setCurrency":          activitylog.ActivityTypeCurrencyChange,
+	"POST /bot":                  activitylog.ActivityTypeAssistantMessage,
+	"POST /login":                activitylog.ActivityTypeOther,
+	"GET /logout":                activitylog.ActivityTypeOther,
+	"GET /assistant":             activitylog.ActivityTypeOther,
+	"GET /my-activity":           activitylog.ActivityTypeOther,
+	"HEAD /my-activity":          activitylog.ActivityTypeOther,
+	"POST /my-activity/tracking": activitylog.ActivityTypeOther,
+	"ANY /static/":               activitylog.ActivityTypeOther,
+	"ANY /robots.txt":            activitylog.ActivityTypeOther,
+	"ANY /_healthz":              activitylog.ActivityTypeOther,
+	"GET /product-meta/{ids}":    activitylog.ActivityTypeOther,
+	"GET /debug/vars":            activitylog.ActivityTypeOther,
+
+	// The activity API
+	"GET /activities":                            activitylog.ActivityTypeOther,
+	"GET /activities/session":                    activitylog.ActivityTypeOther,
+	"GET /activities/device":                     activitylog.ActivityTypeOther,
+	"GET /activities/{id:[0-9]+}":                activitylog.ActivityTypeOther,
+	"GET /activities/by-request/{request_id}":    activitylog.ActivityTypeOther,
+	"GET /activities/sessions":                   activitylog.ActivityTypeOther,
+	"POST /activities/session/clear":             activitylog.ActivityTypeOther,
+	"GET /activities/stream":                     activitylog.ActivityTypeOther,
+	"GET /activities/poll":                       activitylog.ActivityTypeOther,
+	"GET /activities/export":                     activitylog.ActivityTypeOther,
+	"GET /activities/currencies":                 activitylog.ActivityTypeOther,
+	"GET /activities/meta":                       activitylog.ActivityTypeOther,
+	"GET /activities/version":                    activitylog.ActivityTypeOther,
+	"POST " + activitylog.IngestPath:             activitylog.ActivityTypeOther,
+	"GET /activities/report":                     activitylog.ActivityTypeOther,
+	"GET /activities/dashboard":                  activitylog.ActivityTypeOther,
+	"POST /activities/rollups/rebuild":           activitylog.ActivityTypeOther,
+	"POST /activities/sessions/counters/rebuild": activitylog.ActivityTypeOther,
+	"POST /activities/purge":                     activitylog.ActivityTypeOther,
+	"GET /activities/admin/audit":                activitylog.ActivityTypeOther,
+	"POST /activities/admin/readonly":            activitylog.ActivityTypeOther,
+	"POST /activities/backfill/products":         activitylog.ActivityTypeOther,
+	"POST /activities/backfill/quantities":       activitylog.ActivityTypeOther,
+	"POST /activities/archives/upload":           activitylog.ActivityTypeOther,
+	"GET /activities/archives/status":            activitylog.ActivityTypeOther,
+	"GET /activities/outbox/status":              activitylog.ActivityTypeOther,
+	"GET /activities/flagsets":                   activitylog.ActivityTypeOther,
+	"GET /activities/db/plans":                   activitylog.ActivityTypeOther,
+	"GET /activities/db/stats":                   activitylog.ActivityTypeOther,
+	"GET /activities/debug/query":                activitylog.ActivityTypeOther,
+	"POST /activities/db/verify":                 activitylog.ActivityTypeOther,
+	"GET /activities/db/snapshot":                activitylog.ActivityTypeOther,
+	"GET /activities/alerts":                     activitylog.ActivityTypeOther,
+	"POST /activities/alerts/{id:[0-9]+}/ack":    activitylog.ActivityTypeOther,
+	"GET /activities/view":                       activitylog.ActivityTypeOther,
+	"POST /activities/test/reset":                activitylog.ActivityTypeOther,
+	"GET /activities/test/last":                  activitylog.ActivityTypeOther,
+}
+
+// routeVar matches the variables of a path template, with their pattern
+var routeVar = regexp.MustCompile(`\{[^}]+\}`)
+
+func TestRouteClassifications(t *testing.T) {
+	// Register the routes that are off by default too
+	defer func(enabled bool) { clientEvents = enabled }(clientEvents)
+	clientEvents = true
+	t.Setenv("ENABLE_ACTIVITY_TEST_ENDPOINTS", "true")
+	log := logrus.New()
+	log.Out = io.Discard
+	fe := &frontendServer{}
+	want := make(map[string]string, len(routeClassifications))
+	for route, activityType := range routeClassifications {
+		want[route] = activityType
+	}
+	// The stats endpoints are read-only JSON, listed by statsEndpoints
+	for _, e := range fe.statsEndpoints() {
+		want["GET "+e.Path] = activitylog.ActivityTypeOther
+	}
+
+	r := mux.NewRouter()
+	fe.registerRoutes(r, log)
+	var got string
+	r.Use(func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = activitylog.ClassifyRequest(r)
+		})
+	})
+
+	var unlisted []string
+	err := r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{"ANY"}
+		}
+		for _, method := range methods {
+			key := method + " " + template
+			activityType, ok := want[key]
+			if !ok {
+				unlisted = append(unlisted, key)
+				continue
+			}
+			delete(want, key)
+			if method == "ANY" {
+				method = http.MethodGet
+			}
+			got = ""
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, routeVar.ReplaceAllString(template, "1"), nil))
+			if got != activityType {
+				t.Errorf("%s is classified as %q, want %q", key, got, activityType)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking the routes failed: %v", err)
+	}
+	sort.Strings(unlisted)
+	for _, key := range unlisted {
+		t.Errorf("route %s has no classification, add it to routeClassifications", key)
+	}
+	for key := range want {
+		t.Errorf("routeClassifications lists %s, which isn't registered", key)
+	}
+}
+
+// adminRoutes are the operational routes that expose internals, which only
+// admins may call
+var adminRoutes = []string{
+	"GET /debug/vars",
+	"GET /activities/db/plans",
+	"GET /activities/db/stats",
+	"GET /activities/export",
+	"GET /activities/report",
+}
+
+func TestAdminRoutesRequireToken(t *testing.T) {
+	defer func(token string) { activityAdminToken = token }(activityAdminToken)
+	activityAdminToken = "secret"
+	log := logrus.New()
+	log.Out = io.Discard
+	r := mux.NewRouter()
+	(&frontendServer{}).registerRoutes(r, log)
+
+	for _, route := range adminRoutes {
+		method, path, _ := strings.Cut(route, " ")
+		req := httptest.NewRequest(method, path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), ctxKeyLog{}, logrus.FieldLogger(log))))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s without the admin token: status %d, want 401", route, w.Code)
+		}
+	}
+}
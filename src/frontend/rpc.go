@@ -42,6 +42,17 @@ func (fe *frontendServer) getCurrencies(ctx context.Context) ([]string, error) {
 	return out, nil
 }
 
+// getSupportedCurrencies returns every currency the currency service
+// supports, including those the shop doesn't offer
+func (fe *frontendServer) getSupportedCurrencies(ctx context.Context) ([]string, error) {
+	currs, err := pb.NewCurrencyServiceClient(fe.currencySvcConn).
+		GetSupportedCurrencies(ctx, &pb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	return currs.CurrencyCodes, nil
+}
+
 func (fe *frontendServer) getProducts(ctx context.Context) ([]*pb.Product, error) {
 	resp, err := pb.NewProductCatalogServiceClient(fe.productCatalogSvcConn).
 		ListProducts(ctx, &pb.Empty{})
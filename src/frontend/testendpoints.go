@@ -0,0 +1,96 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+// activityTestEndpointsEnabled tells whether the end-to-end test endpoints
+// were asked for with ENABLE_ACTIVITY_TEST_ENDPOINTS=true. They delete
+// every activity, so anything but exactly "true" leaves them off, and
+// enabling them is logged loudly.
+func activityTestEndpointsEnabled(log logrus.FieldLogger) bool {
+	v, ok := os.LookupEnv("ENABLE_ACTIVITY_TEST_ENDPOINTS")
+	if !ok || v == "" {
+		return false
+	}
+	if v != "true" {
+		log.Errorf("not enabling the activity test endpoints: ENABLE_ACTIVITY_TEST_ENDPOINTS is %q, not \"true\"", v)
+		return false
+	}
+	log.Warn("ACTIVITY TEST ENDPOINTS ARE ENABLED: anyone can delete every activity with POST /activities/test/reset. Never run this in production.")
+	return true
+}
+
+// registerActivityTestEndpoints adds the endpoints end-to-end tests use to
+// start from an empty activity log and check what a step logged
+func (fe *frontendServer) registerActivityTestEndpoints(r *mux.Router) {
+	r.HandleFunc(baseUrl+"/activities/test/reset", refuseWhenReadOnly(fe.resetActivitiesHandler)).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl+"/activities/test/last", fe.lastActivityHandler).Methods(http.MethodGet)
+}
+
+// resetActivitiesHandler deletes every activity and the state derived from
+// them, and reports how many activities there were. The reset itself isn't
+// logged, so that tests start from an empty log.
+func (fe *frontendServer) resetActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	activitylog.SkipActivity(r.Context())
+	deleted, err := activitylog.ResetForTesting(r.Context())
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to reset the activity log"), http.StatusInternalServerError)
+		return
+	}
+	if fe.anomalyDetector != nil {
+		fe.anomalyDetector.Reset()
+	}
+	log.WithField("deleted", deleted).Warn("activity log reset by the test endpoint")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"deleted": deleted})
+}
+
+// lastActivityHandler returns the most recent activity of the type given
+// as type. Looking isn't logged either.
+func (fe *frontendServer) lastActivityHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	activitylog.SkipActivity(r.Context())
+	activityType := r.URL.Query().Get("type")
+	if activityType == "" {
+		renderJSONError(log, r, w, errors.New("type is required"), http.StatusBadRequest)
+		return
+	}
+
+	activities, err := activitylog.GetActivities(activitylog.Filter{Types: []string{activityType}}, 1)
+	if err != nil {
+		renderJSONError(log, r, w, errors.Wrap(err, "failed to get the last activity"), http.StatusInternalServerError)
+		return
+	}
+	if len(activities) == 0 {
+		renderJSONError(log, r, w, errors.Errorf("no %s activity was logged", activityType), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(activities[0])
+}
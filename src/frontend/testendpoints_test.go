@@ -0,0 +1,170 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+var activityDB struct {
+	once sync.Once
+	err  error
+}
+
+// emptyActivityLog opens the activity database the tests of this package
+// share, and empties it
+func emptyActivityLog(t *testing.T) {
+	t.Helper()
+	activityDB.once.Do(func() {
+		dir, err := os.MkdirTemp("", "frontend-activities")
+		if err != nil {
+			activityDB.err = err
+			return
+		}
+		log := logrus.New()
+		log.Out = io.Discard
+		activityDB.err = activitylog.InitDBAt(log, dir)
+	})
+	if activityDB.err != nil {
+		t.Fatalf("opening the activity database failed: %v", activityDB.err)
+	}
+	if _, err := activitylog.ResetForTesting(context.Background()); err != nil {
+		t.Fatalf("emptying the activity log failed: %v", err)
+	}
+}
+
+// testEndpointsRouter routes requests to the test endpoints, with the
+// request logger the frontend's outer handlers would set
+func testEndpointsRouter(fe *frontendServer) http.Handler {
+	r := mux.NewRouter()
+	fe.registerActivityTestEndpoints(r)
+	log := logrus.New()
+	log.Out = io.Discard
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), ctxKeyLog{}, logrus.FieldLogger(log))))
+	})
+}
+
+func TestActivityTestEndpointsGuard(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		set   bool
+		want  bool
+		level logrus.Level
+	}{
+		{set: false, want: false},
+		{value: "", set: true, want: false},
+		{value: "true", set: true, want: true, level: logrus.WarnLevel},
+		{value: "TRUE", set: true, want: false, level: logrus.ErrorLevel},
+		{value: "1", set: true, want: false, level: logrus.ErrorLevel},
+		{value: "true ", set: true, want: false, level: logrus.ErrorLevel},
+	} {
+		if tc.set {
+			t.Setenv("ENABLE_ACTIVITY_TEST_ENDPOINTS", tc.value)
+		} else {
+			os.Unsetenv("ENABLE_ACTIVITY_TEST_ENDPOINTS")
+		}
+		log, hook := test.NewNullLogger()
+		if got := activityTestEndpointsEnabled(log); got != tc.want {
+			t.Errorf("ENABLE_ACTIVITY_TEST_ENDPOINTS=%q: enabled = %v, want %v", tc.value, got, tc.want)
+		}
+		entries := hook.AllEntries()
+		if tc.level == 0 {
+			if len(entries) != 0 {
+				t.Errorf("ENABLE_ACTIVITY_TEST_ENDPOINTS=%q: logged %d entries, want none", tc.value, len(entries))
+			}
+			continue
+		}
+		if len(entries) != 1 || entries[0].Level != tc.level {
+			t.Errorf("ENABLE_ACTIVITY_TEST_ENDPOINTS=%q: logged %v, want a single %v entry", tc.value, entries, tc.level)
+		}
+	}
+}
+
+func TestResetActivitiesEndpoint(t *testing.T) {
+	emptyActivityLog(t)
+	for i := 0; i < 2; i++ {
+		if err := activitylog.LogActivity(&activitylog.ActivityLog{SessionID: "session-1", ActivityType: activitylog.ActivityTypePageView}); err != nil {
+			t.Fatalf("LogActivity() failed: %v", err)
+		}
+	}
+	h := testEndpointsRouter(&frontendServer{})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/activities/test/reset", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.Deleted != 2 {
+		t.Errorf("body = %+v (%v), want the 2 prior activities", body, err)
+	}
+	if left, err := activitylog.GetRecentActivities(10); err != nil || len(left) != 0 {
+		t.Errorf("%d activities left after the reset (%v)", len(left), err)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/activities/test/reset", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET reset: status = %d, want 405", w.Code)
+	}
+}
+
+func TestLastActivityEndpoint(t *testing.T) {
+	emptyActivityLog(t)
+	now := activitylog.Now()
+	for _, a := range []activitylog.ActivityLog{
+		{SessionID: "first", ActivityType: activitylog.ActivityTypeCheckout, CreatedAt: now.Add(-2 * time.Minute)},
+		{SessionID: "second", ActivityType: activitylog.ActivityTypeCheckout, CreatedAt: now.Add(-time.Minute)},
+		{SessionID: "third", ActivityType: activitylog.ActivityTypePageView, CreatedAt: now},
+	} {
+		if err := activitylog.LogActivity(&a); err != nil {
+			t.Fatalf("LogActivity() failed: %v", err)
+		}
+	}
+	h := testEndpointsRouter(&frontendServer{})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/activities/test/last?type=checkout", nil))
+	var got activitylog.ActivityLog
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil || got.SessionID != "second" {
+		t.Errorf("last checkout = %+v (%v), want the second one", got, err)
+	}
+
+	for query, want := range map[string]int{"": http.StatusBadRequest, "?type=empty_cart": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/activities/test/last"+query, nil))
+		if w.Code != want || !strings.Contains(w.Body.String(), `"error"`) {
+			t.Errorf("/activities/test/last%s: status = %d, want %d with a JSON error", query, w.Code, want)
+		}
+	}
+}
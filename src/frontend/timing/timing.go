@@ -0,0 +1,40 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package timing records how long the phases of a request take in the
+// details of the request's activity.
+package timing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/activitylog"
+)
+
+// Phase starts timing the named phase of the request ctx belongs to and
+// returns the function that stops it. The time spent is added to the
+// "<name>_ms" detail, so a phase timed several times adds up. Only the first
+// call to stop counts, which makes it safe to both defer it and call it
+// early.
+func Phase(ctx context.Context, name string) (stop func()) {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			activitylog.AddDuration(ctx, name+"_ms", time.Since(start))
+		})
+	}
+}